@@ -53,6 +53,7 @@ from nuvlaedge.agent.settings import AgentSettings
 from nuvlaedge.agent.orchestrator import COEClient
 from nuvlaedge.agent.orchestrator.factory import get_coe_client
 from nuvlaedge.agent.orchestrator.job_local import JobLocal
+from nuvlaedge.peripherals.config_file import write_remote_config
 from nuvlaedge.models import model_diff
 
 
@@ -368,6 +369,10 @@ class Agent:
             self.heartbeat_period = self._nuvla_client.nuvlaedge.heartbeat_interval
             self.action_handler.edit_period('heartbeat', self.heartbeat_period)
 
+        # The peripheral managers following the configuration apply its changes without being redeployed
+        if write_remote_config(self._nuvla_client.nuvlaedge.peripherals_configuration):
+            logger.info("Peripheral managers configuration has changed in Nuvla")
+
     def _telemetry(self) -> dict | None:
         """ This method is responsible for executing the telemetry operation.
         It retrieves the telemetry data from the telemetry channel and updates the telemetry payload. If the telemetry
@@ -116,9 +116,55 @@ class NuvlaEdgeStatusHandler:
                                              message=_notes if _notes is not None else '',
                                              date=datetime.now()))
 
+    def _get_peripherals_status(self) -> None:
+        """
+        Peripheral managers are expected to report their health into a status.json file in their channel folder:
+         - status: one of the module statuses
+         - time: ISO 8601 time of the last scan
+         - devices, devices-by-class, last-scan-duration, scan-errors and last-error describing the last scans
+
+        Managers that have not written their status for longer than STATUS_TIMEOUT are reported as UNKNOWN
+        Returns: None. Status is reported via the status_channel for consistency
+
+        """
+        peripherals_folder = FILE_NAMES.PERIPHERALS_FOLDER
+        if not peripherals_folder.is_dir():
+            return
+
+        for status_file in sorted(peripherals_folder.glob('*/status.json')):
+            _status: dict = read_file(status_file, decode_json=True, warn_on_missing=False)
+            if not isinstance(_status, dict):
+                continue
+            module_name = f'Peripheral {status_file.parent.name}'
+
+            try:
+                _date = datetime.fromisoformat(_status.get('time', '').replace('Z', '+00:00'))
+                _date = _date.astimezone().replace(tzinfo=None)
+            except ValueError:
+                _date = datetime.now()
+
+            _module_status = _status.get('status', 'UNKNOWN')
+            if _module_status not in ['RUNNING', 'WARNING', 'FAILING'] or \
+                    (datetime.now() - _date).total_seconds() > self.STATUS_TIMEOUT:
+                _module_status = 'UNKNOWN'
+
+            by_class = ', '.join(f'{k}: {v}' for k, v in sorted(_status.get('devices-by-class', {}).items()))
+            message = (f"{_status.get('devices', 0)} devices{f' ({by_class})' if by_class else ''}, "
+                       f"last scan {_status.get('last-scan-duration', 0):.2f}s, "
+                       f"{_status.get('scan-errors', 0)} errors")
+            if _status.get('last-error'):
+                message += f" - last error: {_status['last-error']}"
+
+            logger.debug(f"{module_name} status: {_module_status} - {message}")
+            self.status_channel.put(StatusReport(origin_module=module_name,
+                                                 module_status=_module_status,
+                                                 message=message,
+                                                 date=_date))
+
     def get_status(self, coe_client: COEClient) -> tuple[str, list[str]]:
         self._get_coe_status(coe_client)
         self._get_system_manager_status()
+        self._get_peripherals_status()
 
         self.update_status()
         return self._status, self._notes
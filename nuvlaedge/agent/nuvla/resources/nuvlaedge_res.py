@@ -49,6 +49,7 @@ class NuvlaEdgeResource(NuvlaResourceBase):
         nuvlabox_status (Optional[NuvlaID]): The NuvlaBox status ID associated with the resource.
         credential_api_key (Optional[NuvlaID]): The credential API key associated with the resource.
         host_level_management_api_key (Optional[NuvlaID]): The host level management API key associated with the resource.
+        peripherals_configuration (Optional[dict]): The settings of the peripheral managers, by manager section.
 
     Class Methods:
         cast_str_to_state(cls, v): Helper method to cast a string value to a State value.
@@ -70,6 +71,8 @@ class NuvlaEdgeResource(NuvlaResourceBase):
 
     host_level_management_api_key:  Optional[NuvlaID] = None
 
+    peripherals_configuration:      Optional[dict] = None
+
     @field_validator('state', mode='before')
     def cast_str_to_state(cls, v):
         if isinstance(v, str):
@@ -2,6 +2,7 @@
 
 """
 import logging
+import time
 from pathlib import Path
 from queue import Queue
 from threading import Event, Thread
@@ -10,14 +11,16 @@ from pydantic import ValidationError
 from nuvla.api import Api as NuvlaClient
 
 from nuvlaedge.agent.common.status_handler import NuvlaEdgeStatusHandler, StatusReport
-from nuvlaedge.broker.file_broker import FileBroker
+from nuvlaedge.broker.socket_broker import SocketBroker
 from nuvlaedge.common.constant_files import FILE_NAMES
 from nuvlaedge.common.nuvlaedge_logging import get_nuvlaedge_logger
 from nuvlaedge.models.messages import NuvlaEdgeMessage
 from nuvlaedge.peripherals.peripheral_manager_db import PeripheralsDBManager
+from nuvlaedge.peripherals.hooks import ATTACHED, DETACHED, MOVED, PeripheralHooks
+from nuvlaedge.peripherals.enrichment import ENRICHMENT_URL, PeripheralEnricher
 from nuvlaedge.broker import NuvlaEdgeBroker
 from nuvlaedge.models.peripheral import PeripheralData
-from nuvlaedge.common.file_operations import create_directory
+from nuvlaedge.common.file_operations import create_directory, read_file
 
 
 logger: logging.Logger = get_nuvlaedge_logger(__name__)
@@ -37,7 +40,12 @@ class PeripheralManager:
         __init__(nuvla_client, nuvlaedge_uuid): Initializes the PeripheralManager class.
         update_running_managers(): Checks which peripheral scanners are currently running.
         process_new_peripherals(new_peripherals): Assess what to do with the new received peripherals.
+        reconcile_on_startup(new_peripherals): Converges Nuvla to the first complete scan after startup.
+        process_remediations(): Runs the moved hooks of the containers mapping the former path of a peripheral.
+        hooks: Runs the peripheral hooks of the attached, detached and moved peripherals.
+        enricher: Adds the metadata of the online device service to the peripherals, when enabled.
         available_messages(): Generator that allows to iterate over the latest messages of the peripheral managers.
+        report_peripherals(data): Returns the peripherals of a report, unwrapping the batch reports.
         join_new_peripherals(new_peripherals): Takes a list of new received peripherals and rearranges them into a dictionary.
         run(): Runs the peripheral manager.
 
@@ -49,8 +57,13 @@ class PeripheralManager:
     # Normally, NuvlaDB and local DB shouldn't be desynchronized. For safety, we check Nuvla db and synchronize the
     # local one with it for safe keeping
     NUVLA_SYNCHRONIZATION_PERIOD = 4*REFRESH_RATE
+    # Maximum time to wait for every running peripheral manager to report before reconciling Nuvla with the
+    # peripherals reported so far
+    RECONCILE_TIMEOUT = 4*REFRESH_RATE
 
     PERIPHERALS_LOCATION: Path = FILE_NAMES.PERIPHERALS_FOLDER
+    # Containers mapping the former device path of a peripheral, written by the managers in their folder
+    REMEDIATIONS_FILE = 'remediations.json'
 
     def __init__(self, nuvla_client: NuvlaClient,
                  nuvlaedge_uuid: str,
@@ -67,8 +80,9 @@ class PeripheralManager:
         # Required to check the Nuvla database and filter present peripherals
         self._uuid: str = nuvlaedge_uuid
 
-        # Broker instance to consume messages from the peripherals
-        self.broker: NuvlaEdgeBroker = FileBroker()
+        # Broker instance to consume messages from the peripherals, streamed over the socket of the managers offering
+        # one, read from the buffer folders otherwise
+        self.broker: NuvlaEdgeBroker = SocketBroker()
 
         # Particular class to control and wrap the handling of peripherals
         self.db: PeripheralsDBManager = PeripheralsDBManager(nuvla_client, nuvlaedge_uuid)
@@ -79,8 +93,29 @@ class PeripheralManager:
         self.running_peripherals: set = set()
         self.registered_peripherals: dict[str, PeripheralData] = {}
 
+        # Peripherals reported since startup, until Nuvla is reconciled with them
+        self._started: float = time.time()
+        self._reconciled: bool = False
+        self._reported_managers: set[str] = set()
+        self._startup_peripherals: dict[str, PeripheralData] = {}
+
         self.status_channel: Queue[StatusReport] = status_channel
 
+        # Remediations the moved hooks were run for, by identifier, until the managers clear them
+        self._remediations: set[str] = set()
+
+        # Hooks run when peripherals are attached, detached or moved
+        self.hooks: PeripheralHooks = PeripheralHooks(
+            FILE_NAMES.PERIPHERAL_HOOKS,
+            FILE_NAMES.PERIPHERAL_HOOK_RESULTS,
+            nuvla_client,
+            report=lambda message: NuvlaEdgeStatusHandler.warning(self.status_channel, _status_module_name, message),
+            nuvlaedge_id=nuvlaedge_uuid)
+
+        # Metadata of the device models from the online service, when enabled
+        self.enricher: PeripheralEnricher | None = \
+            PeripheralEnricher(FILE_NAMES.PERIPHERAL_ENRICHMENT_CACHE) if ENRICHMENT_URL else None
+
         create_directory(FILE_NAMES.PERIPHERALS_FOLDER)
 
         NuvlaEdgeStatusHandler.starting(self.status_channel, _status_module_name)
@@ -121,17 +156,66 @@ class PeripheralManager:
 
         if to_add:
             self.db.add({i: new_peripherals[i] for i in to_add})
+            self.hooks.trigger(ATTACHED, {i: new_peripherals[i] for i in to_add})
 
         # Peripherals registered in Nuvla no longer present in the systems
         to_delete = present_identifiers - new_identifiers
         if to_delete:
+            registered = {i: self.db.get(i) for i in to_delete}
             self.db.remove(to_delete)
+            # Peripherals only count as detached once actually removed, after their expiration time
+            remaining = self.db.keys
+            self.hooks.trigger(DETACHED, {i: p for i, p in registered.items() if p and i not in remaining})
 
         # Peripherals registered and detected in the last iteration that need to check for changes
         to_check = new_identifiers & present_identifiers
         if to_check:
             self.db.edit({i: new_peripherals[i] for i in to_check})
 
+    def process_remediations(self):
+        """
+        Runs the moved hooks once for every remediation of the running managers: a container mapping the former path
+        of a peripheral enumerated again under a new one. The hooks are given the registered peripheral, with the
+        paths, the container and its deployment.
+        """
+        current = set()
+        for manager in self.running_peripherals:
+            remediations = read_file(Path(manager) / self.REMEDIATIONS_FILE, decode_json=True,
+                                     remove_file_on_error=False, warn_on_missing=False)
+            if not isinstance(remediations, list):
+                continue
+
+            for remediation in remediations:
+                if not isinstance(remediation, dict) or not remediation.get('id'):
+                    continue
+                current.add(remediation['id'])
+                if remediation['id'] in self._remediations:
+                    continue
+
+                identifier = remediation.get('peripheral', '')
+                logger.warning(f'Peripheral {identifier} moved from {remediation.get("path")} to '
+                               f'{remediation.get("new-path")}, still mapped by container {remediation.get("container")}')
+                peripheral = self.db.get(identifier)
+                if hasattr(peripheral, 'model_dump'):
+                    peripheral = peripheral.model_dump(by_alias=True, exclude_none=True)
+                fields = {k: v for k, v in remediation.items() if k not in ('id', 'peripheral')}
+                self.hooks.trigger(MOVED, {identifier: {**(peripheral or {}), **fields}})
+
+        self._remediations = current
+
+    @staticmethod
+    def report_peripherals(data: dict) -> dict:
+        """
+        Batch reports, as written by the USB peripheral manager with the batch buffer layout, hold the peripherals
+        along with the changes since the report consumed last: {'peripherals': {...}, 'events': [...]}
+        :param data: The content of a report
+        :return: The peripherals of the report, by identifier
+        """
+        if isinstance(data, dict) and set(data) == {'peripherals', 'events'} and isinstance(data['peripherals'], dict):
+            logger.debug(f'Received a batch of {len(data["events"] or [])} peripheral changes')
+            return data['peripherals']
+        return data
+
     @property
     def available_messages(self):
         """
@@ -149,13 +233,67 @@ class PeripheralManager:
                 continue
 
             try:
-                # Yield latest message
-                yield sorted(new_devices, key=lambda x: x.time)[0].data
-            except IndexError:
-                # We should never reach here, catch the possible index error to prevent the manager
+                # Yield latest message, the sequence ordering the ones of the same second
+                latest = self.report_peripherals(max(new_devices, key=lambda x: (x.time, x.sequence)).data)
+                self._reported_managers.add(peripheral_manager.name)
+                yield latest
+            except ValueError:
+                # We should never reach here, catch the possible empty sequence error to prevent the manager
                 # from dying due to broker errors
                 logger.warning(f'Error sorting messages from peripheral {peripheral_manager} channel')
 
+    @property
+    def startup_scan_complete(self) -> bool:
+        """
+        The startup scan is complete once every running peripheral manager has reported, or after RECONCILE_TIMEOUT
+        for managers that never do
+        :return: True if Nuvla can be reconciled with the peripherals reported since startup
+        """
+        if self.all_managers_reported:
+            return True
+        return time.time() - self._started > self.RECONCILE_TIMEOUT
+
+    @property
+    def all_managers_reported(self) -> bool:
+        """
+        :return: True if every running peripheral manager reported since startup
+        """
+        running = {p.name for p in self.running_peripherals}
+        return bool(running) and running <= self._reported_managers
+
+    def reconcile_on_startup(self, new_peripherals: dict[str, PeripheralData]):
+        """
+        Accumulates the peripherals reported after startup and, once all peripheral managers have reported, reconciles
+        the peripherals registered in Nuvla with them. Until then, Nuvla is left untouched so peripherals of managers
+        still starting are not removed.
+        Registered peripherals no longer detected are only removed when every manager reported some: after
+        RECONCILE_TIMEOUT, or with nothing detected, they may belong to a manager that did not report yet, and are left
+        to the regular removal cycle instead.
+        If Nuvla cannot be reached, the reconciliation is retried in the next iteration.
+        :param new_peripherals: Peripherals received in this iteration
+        :return: None
+        """
+        self._startup_peripherals.update(new_peripherals)
+        if not self.startup_scan_complete:
+            return
+
+        remove_stale = self.all_managers_reported and bool(self._startup_peripherals)
+        if not remove_stale:
+            logger.info('Not every peripheral manager reported since startup, keeping the registered peripherals not '
+                        'detected yet')
+
+        try:
+            added, removed = self.db.reconcile(self._startup_peripherals, remove_stale=remove_stale)
+        except Exception as e:
+            logger.warning(f'Cannot reconcile peripherals with Nuvla, retrying in the next iteration: {e}')
+            return
+
+        self.hooks.trigger(ATTACHED, {i: self._startup_peripherals[i] for i in added})
+        self.hooks.trigger(DETACHED, removed)
+
+        self._reconciled = True
+        self._startup_peripherals = {}
+
     def join_new_peripherals(self, new_peripherals: list[dict]) -> dict[str, PeripheralData]:
         """
         Takes a list of new received peripherals and rearranges them into a dictionary:
@@ -189,16 +327,26 @@ class PeripheralManager:
 
         self.update_running_managers()
 
+        # Send the changes queued while Nuvla was unreachable before the new ones
+        self.db.replay_pending_operations()
+
         # New peripherals accumulator for different peripheral managers
         new_peripherals = [m for m in self.available_messages]
+        if self.enricher:
+            new_peripherals = [self.enricher.enrich(m) for m in new_peripherals]
 
         # Decode all new messages at once. This function is an auxiliary tool for the DB to decode
         # peripherals coming from Nuvla that can be reused here
         new_peripherals = self.join_new_peripherals(new_peripherals)
 
-        # Process the new peripherals data
-        if new_peripherals:
+        # Process the new peripherals data. The first complete scan after startup reconciles Nuvla instead, to clean
+        # up the changes missed while offline
+        if not self._reconciled:
+            self.reconcile_on_startup(new_peripherals)
+        elif new_peripherals:
             self.process_new_peripherals(new_peripherals)
 
+        self.process_remediations()
+
         self.exit_event.wait(self.REFRESH_RATE)
 
@@ -1,8 +1,9 @@
 import json
 import logging
 import re
+import threading
 from pathlib import Path
-from datetime import datetime
+from datetime import datetime, timezone
 
 import filelock
 
@@ -18,9 +19,17 @@ class MessageFormatError(Exception):
 
 
 class FileBroker(NuvlaEdgeBroker):
-    FILE_PATTERN = '[a-zA-Z0-9]*_[a-zA-Z0-9]*.json$'
+    # <timestamp>_<sender>.json, optionally followed by a sequence number keeping
+    # the messages published within the same second apart: <timestamp>_<sender>_<sequence>.json
+    # The timestamp is in UTC, 20240501T120000Z. Names in local time, 05012024120000, are still read.
+    FILE_PATTERN = '[a-zA-Z0-9]*_[a-zA-Z0-9]*(_[0-9]+)?.json$'
     BUFFER_NAME = 'buffer'
 
+    # Sequence of the last message published, in microseconds since the epoch as the USB peripheral manager does,
+    # and at least one more than the previous one
+    _last_sequence: int = 0
+    _sequence_lock: threading.Lock = threading.Lock()
+
     def __init__(self, root_path: str = FILE_NAMES.root_fs):
         self.logger: logging.Logger = logging.getLogger(self.__class__.__name__)
 
@@ -35,12 +44,31 @@ class FileBroker(NuvlaEdgeBroker):
         file_name = file_name.replace('.json', '')
         message: list = file_name.split('_')
 
-        return datetime.strptime(message[0], CTE.DATETIME_FORMAT), message[1]
+        try:
+            message_time = datetime.strptime(message[0], CTE.UTC_DATETIME_FORMAT).replace(tzinfo=timezone.utc)
+        except ValueError:
+            message_time = datetime.strptime(message[0], CTE.DATETIME_FORMAT).astimezone()
+        return message_time, message[1]
 
     @staticmethod
-    def compose_file_name(sender):
-        str_now = datetime.now().strftime(CTE.DATETIME_FORMAT)
-        file_name = f'{str_now}_{sender}.json'
+    def decode_sequence_from_file_name(file_name) -> int:
+        """
+        Returns the sequence number of a message file, or 0 when the name has none
+        :param file_name:
+        :return:
+        """
+        parts: list = file_name.replace('.json', '').split('_')
+        if len(parts) > 2 and parts[2].isdigit():
+            return int(parts[2])
+        return 0
+
+    @classmethod
+    def compose_file_name(cls, sender):
+        now = datetime.now(timezone.utc)
+        with cls._sequence_lock:
+            sequence = max(int(now.timestamp() * 1_000_000), cls._last_sequence + 1)
+            cls._last_sequence = sequence
+        file_name = f'{now.strftime(CTE.UTC_DATETIME_FORMAT)}_{sender}_{sequence}.json'
         return file_name
 
     def consume(self, channel: str) -> list[NuvlaEdgeMessage]:
@@ -72,7 +100,8 @@ class FileBroker(NuvlaEdgeBroker):
                     messages.append(NuvlaEdgeMessage(
                         data=json.load(file),
                         sender=sender,
-                        time=message_time
+                        time=message_time,
+                        sequence=self.decode_sequence_from_file_name(message.name)
                     ))
                 message.unlink()
 
@@ -97,8 +126,9 @@ class FileBroker(NuvlaEdgeBroker):
         )
 
     def publish_from_message(self, channel: Path, message: NuvlaEdgeMessage) -> bool:
-        self.logger.info(f'Writing message to {channel / self.BUFFER_NAME / self.compose_file_name(message.sender)}')
-        write_file(message.data, channel / self.BUFFER_NAME / self.compose_file_name(message.sender))
+        file = channel / self.BUFFER_NAME / self.compose_file_name(message.sender)
+        self.logger.info(f'Writing message to {file}')
+        write_file(message.data, file)
         return True
 
     def publish(self, channel: str, data: dict | NuvlaEdgeMessage, sender: str = '') -> bool:
@@ -0,0 +1,246 @@
+"""
+Broker reading the messages the peripheral managers stream over a unix socket
+
+A manager streaming its reports listens on manager.sock in its channel folder. Each message is a frame: its length as a
+4 bytes big-endian integer, followed by the message as JSON, {"sender": ..., "time": ..., "sequence": ..., "data": ...},
+or as an Event of the protocol buffers schema of the managers, nuvlaedge/peripherals/v1/event.proto. A JSON message
+starts with a brace, which is not a valid start of an Event.
+The channels without a socket are read from their buffer folder, with the file broker.
+"""
+import json
+import logging
+import socket
+import struct
+import threading
+import time
+from datetime import datetime, timezone
+from pathlib import Path
+
+from nuvlaedge.models.messages import NuvlaEdgeMessage
+from nuvlaedge.broker import NuvlaEdgeBroker
+from nuvlaedge.broker.file_broker import FileBroker
+from nuvlaedge.common.constant_files import FILE_NAMES
+
+
+MAX_FRAME_SIZE = 16 << 20
+
+
+class FrameError(Exception):
+    ...
+
+
+# Attributes of the Peripheral message with a field of their own, by field number. Field 14 holds the other
+# attributes of the record as a JSON object.
+PERIPHERAL_STRING_FIELDS = {
+    1: 'identifier',
+    2: 'name',
+    3: 'description',
+    4: 'interface',
+    7: 'device-path',
+    8: 'vendor',
+    9: 'product',
+    10: 'serial-number',
+    11: 'video-device',
+    12: 'port',
+    13: 'node',
+}
+
+
+def _read_varint(data: bytes, offset: int) -> tuple[int, int]:
+    value = shift = 0
+    while True:
+        if offset >= len(data):
+            raise FrameError('truncated protobuf varint')
+        byte = data[offset]
+        offset += 1
+        value |= (byte & 0x7f) << shift
+        if not byte & 0x80:
+            return value, offset
+        shift += 7
+        if shift >= 64:
+            raise FrameError('invalid protobuf varint')
+
+
+def protobuf_fields(data: bytes) -> list[tuple[int, int | bytes]]:
+    """
+    Decodes the fields of a protocol buffers message, skipping the fixed size ones the schemas do not use
+    :param data: Encoded message
+    :return: The (number, value) of the fields, in their order on the wire. Values are ints for varints, and bytes
+        for length delimited fields
+    :raises FrameError: when the message is invalid
+    """
+    fields = []
+    offset = 0
+    while offset < len(data):
+        tag, offset = _read_varint(data, offset)
+        number, wire_type = tag >> 3, tag & 7
+        if number == 0:
+            raise FrameError('invalid protobuf field number 0')
+        if wire_type == 0:
+            value, offset = _read_varint(data, offset)
+        elif wire_type == 2:
+            size, offset = _read_varint(data, offset)
+            if offset + size > len(data):
+                raise FrameError('truncated protobuf message')
+            value, offset = data[offset:offset + size], offset + size
+        elif wire_type in (1, 5):
+            offset += 8 if wire_type == 1 else 4
+            if offset > len(data):
+                raise FrameError('truncated protobuf message')
+            continue
+        else:
+            raise FrameError(f'unsupported protobuf wire type {wire_type} of field {number}')
+        fields.append((number, value))
+    return fields
+
+
+def decode_peripheral(data: bytes) -> dict:
+    """
+    Decodes a Peripheral message to the JSON document of the peripheral
+    """
+    fields = protobuf_fields(data)
+    peripheral = {'classes': [], 'available': False}
+    for number, value in fields:
+        if number == 14 and isinstance(value, bytes) and value:
+            try:
+                peripheral.update(json.loads(value))
+            except ValueError as e:
+                raise FrameError(f'invalid peripheral extensions: {e}')
+    for number, value in fields:
+        if number == 6 and isinstance(value, int):
+            peripheral['available'] = value != 0
+        elif number == 5 and isinstance(value, bytes):
+            peripheral['classes'].append(value.decode())
+        elif number in PERIPHERAL_STRING_FIELDS and isinstance(value, bytes):
+            peripheral[PERIPHERAL_STRING_FIELDS[number]] = value.decode()
+    return peripheral
+
+
+def decode_event(data: bytes) -> dict:
+    """
+    Decodes an Event message to the attributes of a JSON message
+    """
+    message = {'sender': '', 'time': None, 'sequence': 0, 'data': {}}
+    try:
+        for number, value in protobuf_fields(data):
+            if number == 1 and isinstance(value, bytes):
+                message['sender'] = value.decode()
+            elif number == 2 and isinstance(value, bytes):
+                timestamp = dict(protobuf_fields(value))
+                seconds, nanos = timestamp.get(1, 0), timestamp.get(2, 0)
+                if seconds >= 1 << 63:
+                    seconds -= 1 << 64
+                message['time'] = datetime.fromtimestamp(seconds + nanos / 1e9, tz=timezone.utc)
+            elif number == 3 and isinstance(value, int):
+                message['sequence'] = value - (1 << 64) if value >= 1 << 63 else value
+            elif number == 4 and isinstance(value, bytes):
+                entry = dict(protobuf_fields(value))
+                message['data'][entry.get(1, b'').decode()] = decode_peripheral(entry.get(2, b''))
+    except UnicodeDecodeError as e:
+        raise FrameError(f'invalid protobuf string: {e}')
+    return message
+
+
+def read_frame(stream) -> dict:
+    """
+    Reads the next frame of a stream
+    :param stream: File like object, such as the one of socket.makefile('rb')
+    :return: The decoded message
+    :raises EOFError: when the stream ends
+    :raises FrameError: when the frame is invalid
+    """
+    header = stream.read(4)
+    if len(header) < 4:
+        raise EOFError('stream closed')
+    size, = struct.unpack('>I', header)
+    if size > MAX_FRAME_SIZE:
+        raise FrameError(f'frame of {size} bytes exceeds the maximum of {MAX_FRAME_SIZE}')
+    payload = stream.read(size)
+    if len(payload) < size:
+        raise EOFError('stream closed within a frame')
+    if not payload.startswith(b'{'):
+        return decode_event(payload)
+    try:
+        return json.loads(payload)
+    except ValueError as e:
+        raise FrameError(f'invalid frame: {e}')
+
+
+class SocketReader(threading.Thread):
+    """
+    Keeps a connection to the socket of a channel, reconnecting when it drops, and queues the received messages
+    """
+
+    def __init__(self, path: Path, retry_interval: float = 5):
+        super().__init__(name=f'socket-reader-{path.parent.name}', daemon=True)
+        self.logger: logging.Logger = logging.getLogger(self.__class__.__name__)
+        self.path = path
+        self.retry_interval = retry_interval
+        self.connected = threading.Event()
+        self.stopped = threading.Event()
+        self._lock = threading.Lock()
+        self._messages: list[NuvlaEdgeMessage] = []
+
+    def drain(self) -> list[NuvlaEdgeMessage]:
+        with self._lock:
+            messages, self._messages = self._messages, []
+        return messages
+
+    def run(self):
+        while not self.stopped.is_set():
+            try:
+                with socket.socket(socket.AF_UNIX, socket.SOCK_STREAM) as conn:
+                    conn.connect(str(self.path))
+                    self.connected.set()
+                    self.logger.info(f'Connected to {self.path}')
+                    with conn.makefile('rb') as stream:
+                        while not self.stopped.is_set():
+                            frame = read_frame(stream)
+                            with self._lock:
+                                self._messages.append(NuvlaEdgeMessage(**frame))
+            except (OSError, EOFError, FrameError, ValueError) as e:
+                if self.connected.is_set():
+                    self.logger.warning(f'Connection to {self.path} lost: {e}')
+            self.connected.clear()
+            self.stopped.wait(self.retry_interval)
+
+    def stop(self):
+        self.stopped.set()
+
+
+class SocketBroker(NuvlaEdgeBroker):
+    SOCKET_NAME = 'manager.sock'
+
+    def __init__(self, root_path: str = FILE_NAMES.root_fs, fallback: NuvlaEdgeBroker | None = None):
+        self.logger: logging.Logger = logging.getLogger(self.__class__.__name__)
+        self.root_path: Path = Path(root_path)
+        self.fallback: NuvlaEdgeBroker = fallback if fallback is not None else FileBroker(root_path)
+        self.readers: dict[str, SocketReader] = {}
+
+    def consume(self, channel: str) -> list[NuvlaEdgeMessage]:
+        """
+        Returns the messages streamed on the channel socket since the last call, followed by the messages of its
+        buffer folder, which a manager may still write to while starting to stream
+        """
+        messages = []
+        reader = self.readers.get(channel)
+        if reader is None:
+            path = self.root_path / channel / self.SOCKET_NAME
+            if path.is_socket():
+                reader = SocketReader(path)
+                self.readers[channel] = reader
+                reader.start()
+        if reader is not None:
+            messages.extend(reader.drain())
+        messages.extend(self.fallback.consume(channel))
+        return messages
+
+    def publish(self, channel: str, data: dict | NuvlaEdgeMessage, sender: str = '') -> bool:
+        return self.fallback.publish(channel, data, sender)
+
+    def close(self, timeout: float = 1):
+        for reader in self.readers.values():
+            reader.stop()
+        deadline = time.monotonic() + timeout
+        for reader in self.readers.values():
+            reader.join(max(0.0, deadline - time.monotonic()))
@@ -82,10 +82,21 @@ class FileConstants(BaseFileConstants):
     # Peripherals
     PERIPHERALS_FOLDER = '.peripherals/'
     LOCAL_PERIPHERAL_DB = PERIPHERALS_FOLDER + 'local_peripherals.json'
+    PENDING_PERIPHERAL_OPERATIONS = PERIPHERALS_FOLDER + 'pending_operations.json'
+    PERIPHERAL_HOOKS = PERIPHERALS_FOLDER + 'hooks.json'
+    PERIPHERAL_HOOK_RESULTS = PERIPHERALS_FOLDER + 'hook_results.json'
+    PERIPHERAL_ENRICHMENT_CACHE = PERIPHERALS_FOLDER + 'enrichment_cache.json'
+    # Configuration of the peripheral managers pushed from Nuvla
+    PERIPHERALS_REMOTE_CONFIG = PERIPHERALS_FOLDER + 'remote_config.json'
     NETWORK_PERIPHERAL = PERIPHERALS_FOLDER + 'network'
     BLUETOOTH_PERIPHERAL = PERIPHERALS_FOLDER + 'bluetooth'
     MODBUS_PERIPHERAL = PERIPHERALS_FOLDER + 'modbus'
     GPU_PERIPHERAL = PERIPHERALS_FOLDER + 'gpu'
+    # Kept out of the peripherals folder, where every directory is the channel of a running manager
+    PERIPHERAL_ACTIONS_FOLDER = '.peripheral_actions/'
+    BLUETOOTH_ACTIONS = PERIPHERAL_ACTIONS_FOLDER + 'bluetooth'
+    # Metadata set on the peripherals in Nuvla, read by the edge applications
+    PERIPHERAL_METADATA_FOLDER = '.peripheral_metadata/'
 
     # System manager
     # Status and status notes report
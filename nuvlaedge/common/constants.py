@@ -4,7 +4,8 @@ from dataclasses import dataclass
 @dataclass(frozen=True)
 class Constants:
     # FORMATS
-    DATETIME_FORMAT: str = "%m%d%Y%H%M%S"  # Used for file names in the broker
+    DATETIME_FORMAT: str = "%m%d%Y%H%M%S"  # Used for file names in the broker, in local time, before UTC names
+    UTC_DATETIME_FORMAT: str = "%Y%m%dT%H%M%SZ"  # Used for file names in the broker, in UTC
 
     # Timeouts
     NETWORK_TIMEOUT: int = 10
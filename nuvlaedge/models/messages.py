@@ -10,6 +10,7 @@ class NuvlaEdgeMessage(NuvlaEdgeBaseModel):
     sender: str
     data: dict
     time: datetime | None = None
+    sequence: int = 0
 
 
 def parse_message(file_location: Path | str) -> NuvlaEdgeMessage | None:
@@ -0,0 +1,131 @@
+"""
+Remote actions on the peripherals, requested through a folder based channel
+
+The protocol is the one of the USB peripheral manager: a request is a JSON file dropped in the requests folder
+    {"id": "...", "action": "pair", "identifier": "AA:BB:CC:DD:EE:FF", "params": {...}}
+and the manager answers in the results folder with a file of the same name, rewritten as the action progresses.
+"""
+import json
+import logging
+import os
+import threading
+import time
+from pathlib import Path
+from typing import Callable
+
+from nuvlaedge.common.file_operations import write_file
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+STATUS_RUNNING = 'running'
+STATUS_SUCCESS = 'success'
+STATUS_FAILED = 'failed'
+STATUS_REJECTED = 'rejected'
+
+# Handlers run an action request and return the data to report back
+ActionHandler = Callable[[dict], dict | None]
+
+
+def now() -> str:
+    return time.strftime('%Y-%m-%dT%H:%M:%SZ', time.gmtime())
+
+
+class ActionChannel:
+    """
+    Dispatches the action requests found on disk to their handlers, one at a time and in file name order
+    """
+
+    def __init__(self, folder: str | Path, interval: float = 1):
+        self.requests_folder: Path = Path(folder) / 'requests'
+        self.results_folder: Path = Path(folder) / 'results'
+        self.interval: float = interval
+        self.handlers: dict[str, ActionHandler] = {}
+
+    def register(self, name: str, handler: ActionHandler):
+        self.handlers[name] = handler
+
+    @property
+    def actions(self) -> list[str]:
+        return sorted(self.handlers.keys())
+
+    def process_pending(self):
+        self.requests_folder.mkdir(parents=True, exist_ok=True)
+        self.results_folder.mkdir(parents=True, exist_ok=True)
+
+        for request_file in sorted(self.requests_folder.glob('*.json')):
+            self.process(request_file)
+
+    def process(self, request_file: Path):
+        # Requests are consumed whatever their outcome, so a broken one is not retried forever
+        try:
+            request = json.loads(request_file.read_text())
+        except (OSError, ValueError) as ex:
+            logger.error(f'Unable to read action request {request_file}: {ex}')
+            return
+        finally:
+            request_file.unlink(missing_ok=True)
+
+        if not isinstance(request, dict):
+            logger.error(f'Malformed action request {request_file}')
+            return
+
+        # The ID names the result file
+        request_id = str(request.get('id', ''))
+        if not request_id or request_id != os.path.basename(request_id):
+            request_id = request_file.stem
+
+        result = {
+            'id': request_id,
+            'action': request.get('action', ''),
+            'identifier': request.get('identifier', ''),
+            'status': STATUS_RUNNING,
+            'started': now()
+        }
+
+        handler = self.handlers.get(result['action'])
+        if not handler:
+            self.finish(result, STATUS_REJECTED, f'action "{result["action"]}" is not enabled')
+            return
+
+        logger.info(f'Running action {result["action"]} ({request_id}) on peripheral {result["identifier"]}')
+        self.write(result)
+
+        try:
+            data = handler(request)
+        except Exception as ex:
+            self.finish(result, STATUS_FAILED, str(ex))
+            return
+        self.finish(result, STATUS_SUCCESS, data=data)
+
+    def finish(self, result: dict, status: str, message: str = '', data: dict | None = None):
+        result['status'] = status
+        if message:
+            result['message'] = message
+        if data:
+            result['data'] = data
+        if status == STATUS_SUCCESS:
+            result['progress'] = 100
+        result['finished'] = now()
+        logger.info(f'Action {result["action"]} ({result["id"]}) finished with status {status} {message}')
+        self.write(result)
+
+    def write(self, result: dict):
+        write_file(result, self.results_folder / f'{result["id"]}.json')
+
+    def run(self):
+        logger.info(f'Listening for peripheral actions {self.actions} in {self.requests_folder}')
+        while True:
+            try:
+                self.process_pending()
+            except OSError as ex:
+                logger.error(f'Unable to process the action requests in {self.requests_folder}: {ex}')
+            time.sleep(self.interval)
+
+    def start(self) -> threading.Thread:
+        """
+        Runs the channel in the background, alongside the discovery loop of the peripheral manager
+        """
+        thread = threading.Thread(target=self.run, name='ActionChannel', daemon=True)
+        thread.start()
+        return thread
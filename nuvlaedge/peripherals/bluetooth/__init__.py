@@ -3,7 +3,12 @@
 
 """NuvlaEdge Peripheral Manager Bluetooth
 
-This service provides bluetooth device discovery.
+This service provides bluetooth device discovery, and the remote pair, trust, connect and disconnect actions listed
+in BLUETOOTH_ACTIONS.
+
+Setting BLE_GATT_ENUMERATION to true also connects to the BLE devices to list their GATT services.
+
+All these settings can also be set in the bluetooth section of the peripherals configuration file, see config_file.
 
 """
 
@@ -15,11 +20,19 @@ from typing import List
 
 import bluetooth as bt
 
-from bleak import BleakScanner, BLEDevice, AdvertisementData, BleakClient
+from bleak import BleakScanner, BLEDevice, AdvertisementData
 from bleak.backends._manufacturers import MANUFACTURERS
 from bleak.uuids import uuidstr_to_str
 
+from nuvlaedge.peripherals import config_file
+
+# The settings of the configuration file are exported before the modules reading them are imported
+config_file.load_section('bluetooth')
+
 from nuvlaedge.peripherals.peripheral import Peripheral
+from nuvlaedge.peripherals.bluetooth.actions import build_action_channel
+from nuvlaedge.peripherals.bluetooth.gatt import GATT_ENUMERATION, GattEnumerator
+from nuvlaedge.common.constant_files import FILE_NAMES
 from nuvlaedge.common.nuvlaedge_config import parse_arguments_and_initialize_logging
 
 logger: logging.Logger = logging.getLogger(__name__)
@@ -301,7 +314,7 @@ def cod_converter(cod_decimal_string):
     return peripheral_classes
 
 
-async def bluetooth_manager():
+async def bluetooth_manager(gatt: GattEnumerator | None = None):
 
     bluetooth_devices = []
     try:
@@ -315,6 +328,8 @@ async def bluetooth_manager():
     try:
         ble_devices = await ble_device_discovery()
         logger.debug(f'BLE devices: {ble_devices}')
+        if gatt:
+            await gatt.enrich(ble_devices)
     except Exception as e:
         logger.error(f"Failed to discover BLE devices: {e}")
         error = str(e)
@@ -351,7 +366,16 @@ def main():
 
     bluetooth_peripheral: Peripheral = Peripheral(name='bluetooth', async_mode=True)
 
-    bluetooth_peripheral.run(bluetooth_manager)
+    actions = [a.strip() for a in os.getenv('BLUETOOTH_ACTIONS', '').split(',') if a.strip()]
+    try:
+        channel = build_action_channel(FILE_NAMES.BLUETOOTH_ACTIONS, actions)
+    except ValueError as ex:
+        logger.error(f'Bluetooth actions disabled: {ex}')
+        channel = None
+    if channel:
+        channel.start()
+
+    bluetooth_peripheral.run(bluetooth_manager, gatt=GattEnumerator() if GATT_ENUMERATION else None)
 
 
 def entry():
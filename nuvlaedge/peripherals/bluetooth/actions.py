@@ -0,0 +1,83 @@
+"""
+Remote Bluetooth actions, letting headless NuvlaEdges be provisioned with Bluetooth sensors from Nuvla
+
+The actions run bluetoothctl against the device with the MAC address given as the request identifier:
+ - pair: pairs with the device, without any input or output (Just Works pairing)
+ - trust: lets the device reconnect on its own
+ - connect / disconnect
+
+They are all disabled unless listed in BLUETOOTH_ACTIONS, e.g. "pair,trust,connect,disconnect".
+"""
+import logging
+import os
+import re
+import subprocess
+
+from nuvlaedge.peripherals.actions import ActionChannel
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+ACTIONS = ['pair', 'trust', 'connect', 'disconnect']
+ACTION_TIMEOUT = int(os.getenv('BLUETOOTH_ACTION_TIMEOUT', 30))
+
+MAC_ADDRESS = re.compile(r'^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$')
+
+# bluetoothctl does not always exit with an error code, so its output is checked as well
+FAILURE_MARKERS = ('Failed to', 'not available', 'org.bluez.Error')
+
+
+class BluetoothActionError(Exception):
+    """ Raised when bluetoothctl fails to run an action """
+
+
+def bluetoothctl(command: str, mac: str, timeout: int = ACTION_TIMEOUT) -> str:
+    """
+    Runs a bluetoothctl command on a device
+    :param command: One of ACTIONS
+    :param mac: MAC address of the device
+    :param timeout: Seconds after which the command is given up
+    :return: The output of bluetoothctl
+    """
+    if not MAC_ADDRESS.match(mac or ''):
+        raise BluetoothActionError(f'invalid MAC address "{mac}"')
+
+    try:
+        process = subprocess.run(['bluetoothctl', '--agent', 'NoInputNoOutput', '--timeout', str(timeout),
+                                  command, mac.upper()],
+                                 capture_output=True, text=True, timeout=timeout + 5)
+    except FileNotFoundError:
+        raise BluetoothActionError('bluetoothctl is not installed')
+    except subprocess.TimeoutExpired:
+        raise BluetoothActionError(f'{command} timed out after {timeout} seconds')
+
+    output = (process.stdout + process.stderr).strip()
+    if process.returncode != 0 or any(marker in output for marker in FAILURE_MARKERS):
+        raise BluetoothActionError(f'{command} failed: {output.splitlines()[-1] if output else process.returncode}')
+    return output
+
+
+def action_handler(command: str):
+    def handler(request: dict) -> dict:
+        mac = request.get('identifier', '')
+        logger.info(f'Running bluetoothctl {command} on {mac}')
+        bluetoothctl(command, mac)
+        return {'address': mac.upper()}
+    return handler
+
+
+def build_action_channel(folder: str, actions: list[str]) -> ActionChannel | None:
+    """
+    Enables the listed actions
+    :return: The channel, None when no action is enabled
+    :raises ValueError: on unknown actions
+    """
+    if not actions:
+        return None
+
+    channel = ActionChannel(folder)
+    for action in actions:
+        if action not in ACTIONS:
+            raise ValueError(f'unknown Bluetooth action "{action}"')
+        channel.register(action, action_handler(action))
+    return channel
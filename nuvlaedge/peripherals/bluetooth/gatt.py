@@ -0,0 +1,159 @@
+"""
+Opt-in enumeration of the GATT services of the discovered BLE devices
+
+Advertisements only carry a few service UUIDs, if any. Connecting to a device lists all of its services and
+characteristics, which tell what the device can measure. Connections are brief and limited, since they keep the device
+from advertising and drain its battery:
+ - enumeration is disabled unless BLE_GATT_ENUMERATION is set to true
+ - at most BLE_GATT_MAX_DEVICES devices are connected to per scan
+ - a device is only enumerated once, and retried after BLE_GATT_RETRY_INTERVAL seconds when it refused the connection
+"""
+import logging
+import os
+import time
+
+from bleak import BleakClient
+from bleak.uuids import uuidstr_to_str
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+GATT_ENUMERATION = os.getenv('BLE_GATT_ENUMERATION', 'false').lower() in ['true', '1', 'yes']
+MAX_DEVICES = int(os.getenv('BLE_GATT_MAX_DEVICES', 5))
+CONNECT_TIMEOUT = float(os.getenv('BLE_GATT_TIMEOUT', 10))
+RETRY_INTERVAL = int(os.getenv('BLE_GATT_RETRY_INTERVAL', 3600))
+
+# Capabilities of the devices exposing the standard services, by 16 bit service UUID
+KNOWN_SERVICES = {
+    0x1808: 'glucose',
+    0x1809: 'health-thermometer',
+    0x180d: 'heart-rate',
+    0x180f: 'battery',
+    0x1810: 'blood-pressure',
+    0x1814: 'running-speed-and-cadence',
+    0x1816: 'cycling-speed-and-cadence',
+    0x1818: 'cycling-power',
+    0x1819: 'location-and-navigation',
+    0x181a: 'environmental-sensing',
+    0x181b: 'body-composition',
+    0x181d: 'weight-scale',
+    0x1822: 'pulse-oximeter',
+    0x183b: 'binary-sensor',
+}
+
+# Measurements of the environmental sensing service, by 16 bit characteristic UUID
+KNOWN_MEASUREMENTS = {
+    0x2a6c: 'elevation',
+    0x2a6d: 'pressure',
+    0x2a6e: 'temperature',
+    0x2a6f: 'humidity',
+    0x2a70: 'true-wind-speed',
+    0x2a73: 'apparent-wind-speed',
+    0x2a76: 'uv-index',
+    0x2a77: 'irradiance',
+    0x2a78: 'rainfall',
+    0x2a7b: 'dew-point',
+    0x2bd0: 'co2-concentration',
+}
+
+BLUETOOTH_BASE_UUID_SUFFIX = '-0000-1000-8000-00805f9b34fb'
+
+
+def short_uuid(uuid: str) -> int | None:
+    """
+    :return: The 16 bit alias of a UUID derived from the Bluetooth base UUID, None for vendor specific UUIDs
+    """
+    uuid = uuid.lower()
+    if not uuid.endswith(BLUETOOTH_BASE_UUID_SUFFIX) or not uuid.startswith('0000'):
+        return None
+    return int(uuid[4:8], 16)
+
+
+def describe_services(services) -> tuple[list[dict], list[str]]:
+    """
+    Describes the GATT services of a device
+    :param services: BleakGATTServiceCollection, or any iterable of services with their characteristics
+    :return: The services with their characteristics, and the capabilities they grant
+    """
+    description = []
+    capabilities = []
+
+    def capability(name: str):
+        if name not in capabilities:
+            capabilities.append(name)
+
+    for service in services:
+        alias = short_uuid(service.uuid)
+        if alias in KNOWN_SERVICES:
+            capability(KNOWN_SERVICES[alias])
+
+        characteristics = []
+        for characteristic in service.characteristics:
+            char_alias = short_uuid(characteristic.uuid)
+            if char_alias in KNOWN_MEASUREMENTS:
+                capability(KNOWN_MEASUREMENTS[char_alias])
+            characteristics.append({
+                'uuid': characteristic.uuid,
+                'name': uuidstr_to_str(characteristic.uuid),
+                'properties': list(characteristic.properties)
+            })
+
+        description.append({
+            'uuid': service.uuid,
+            'name': uuidstr_to_str(service.uuid),
+            'characteristics': characteristics
+        })
+    return description, capabilities
+
+
+class GattEnumerator:
+    """
+    Enumerates the GATT services of the BLE devices, remembering the results across the scans
+    """
+
+    def __init__(self, max_devices: int = MAX_DEVICES, timeout: float = CONNECT_TIMEOUT,
+                 retry_interval: int = RETRY_INTERVAL):
+        self.max_devices: int = max_devices
+        self.timeout: float = timeout
+        self.retry_interval: int = retry_interval
+        self.known: dict[str, tuple[list[dict], list[str]]] = {}
+        self.failed: dict[str, float] = {}
+
+    def pending(self, address: str) -> bool:
+        if address in self.known:
+            return False
+        return time.time() - self.failed.get(address, 0) >= self.retry_interval
+
+    async def enumerate(self, address: str) -> tuple[list[dict], list[str]] | None:
+        try:
+            async with BleakClient(address, timeout=self.timeout) as client:
+                result = describe_services(client.services)
+        except Exception as ex:
+            logger.debug(f'Cannot enumerate the GATT services of {address}: {ex}')
+            self.failed[address] = time.time()
+            return None
+
+        logger.info(f'Enumerated {len(result[0])} GATT services of {address}')
+        self.known[address] = result
+        self.failed.pop(address, None)
+        return result
+
+    async def enrich(self, ble_devices: dict):
+        """
+        Adds the GATT services and capabilities of the devices to their peripheral records, connecting to the
+        devices not enumerated yet
+        """
+        attempts = 0
+        for address, device in ble_devices.items():
+            if self.pending(address) and attempts < self.max_devices:
+                attempts += 1
+                await self.enumerate(address)
+
+            if address not in self.known:
+                continue
+
+            services, capabilities = self.known[address]
+            device.setdefault('additional-assets', {})['gatt-services'] = services
+            for capability in capabilities:
+                if capability not in device['classes']:
+                    device['classes'].append(capability)
@@ -0,0 +1,372 @@
+"""
+Command line tools of the peripherals, for troubleshooting from a shell on the NuvlaEdge
+
+    nuvlaedge-peripherals inspect [--tree | --json] [--manager usb] [--root /var/lib/nuvlaedge/]
+    nuvlaedge-peripherals mount [--mountpoint /run/nuvlaedge/peripherals] [--refresh 5] [--root /var/lib/nuvlaedge/]
+    nuvlaedge-peripherals dbus [--bus unix:path=/run/dbus/system_bus_socket] [--refresh 5] [--root /var/lib/nuvlaedge/]
+    nuvlaedge-peripherals bom [--manager usb] [--output hardware-bom.cdx.json] [--root /var/lib/nuvlaedge/]
+
+inspect prints what the peripheral managers and the agent currently know of the peripherals, as found in the shared
+data volume, without changing any of it:
+    - the peripheral managers running, with their reports not consumed yet by the agent, their last snapshot and
+      status when they write them
+    - every peripheral reported or registered, with its classes, serial number and device nodes
+    - the report state of each of them: registered in Nuvla, waiting for Nuvla to be reachable again, or not
+      registered yet
+
+mount serves the same peripherals as a read-only filesystem, see nuvlaedge.peripherals.fuse_view, and dbus publishes
+them on the system bus, see nuvlaedge.peripherals.dbus_service.
+
+bom verifies the signed hardware bill of materials of a manager, a CycloneDX document, and prints it or exports it
+with its signature and public key, as the inventory evidence to upload to Nuvla or hand to an auditor.
+"""
+import argparse
+import json
+import re
+import shutil
+import sys
+from datetime import datetime, timezone
+from pathlib import Path
+
+from nuvlaedge.common.constant_files import FILE_NAMES, FileConstants
+from nuvlaedge.common.file_operations import read_file
+
+
+BUFFER_NAME = 'buffer'
+SNAPSHOT_FILE = 'latest.json'
+STATUS_FILE = 'status.json'
+BOM_FILE = 'hardware-bom.cdx.json'
+BOM_PUBLIC_KEY_FILE = 'bom.key.pub'
+
+# The attributes naming the device nodes of a peripheral
+DEVICE_NODES = ('device-path', 'video-device', 'video-devices', 'serial-devices')
+
+_BUFFER_FILE = re.compile(r'^(\d+)_[a-zA-Z0-9]*(_\d+)?\.json$')
+
+
+def read_json(path: Path) -> dict | list | None:
+    # Never remove the files failing to decode, which may be written at that very moment
+    return read_file(path, decode_json=True, remove_file_on_error=False, warn_on_missing=False)
+
+
+def format_time(timestamp: float | str | None) -> str:
+    if timestamp is None:
+        return '-'
+    if isinstance(timestamp, str):
+        return timestamp
+    return datetime.fromtimestamp(timestamp, timezone.utc).strftime('%Y-%m-%dT%H:%M:%SZ')
+
+
+def device_nodes(peripheral: dict) -> list[str]:
+    nodes = []
+    for attribute in DEVICE_NODES:
+        value = peripheral.get(attribute)
+        for node in value if isinstance(value, list) else [value]:
+            if node and node not in nodes:
+                nodes.append(node)
+    return nodes
+
+
+def buffered_reports(manager: Path) -> list[tuple[float, dict]]:
+    """
+    :return: The reports of the manager waiting in its buffer for the agent, from the oldest, with their time
+    """
+    reports = []
+    buffer = manager / BUFFER_NAME
+    if not buffer.is_dir():
+        return reports
+    for file in buffer.iterdir():
+        match = _BUFFER_FILE.match(file.name)
+        if not match:
+            continue
+        content = read_json(file)
+        if isinstance(content, dict):
+            reports.append((int(match.group(1)), int(match.group(2)[1:]) if match.group(2) else 0, content))
+    reports.sort(key=lambda r: (r[0], r[1]))
+    return [(r[0], r[2]) for r in reports]
+
+
+def inspect_managers(folder: Path) -> dict[str, dict]:
+    """
+    :return: The state of the peripheral managers, with the peripherals they last reported, by manager name
+    """
+    managers = {}
+    if not folder.is_dir():
+        return managers
+    for manager in sorted(p for p in folder.iterdir() if p.is_dir()):
+        reports = buffered_reports(manager)
+        snapshot = read_json(manager / SNAPSHOT_FILE)
+        status = read_json(manager / STATUS_FILE)
+        state = {
+            'buffered-reports': len(reports),
+            'last-buffered-report': format_time(reports[-1][0]) if reports else None,
+            'status': status.get('status') if isinstance(status, dict) else None,
+            'status-time': status.get('time') if isinstance(status, dict) else None,
+            'last-error': status.get('last-error') if isinstance(status, dict) else None,
+            'snapshot-time': snapshot.get('time') if isinstance(snapshot, dict) else None,
+            'peripherals': {}
+        }
+        # The latest of the reports in the buffer is newer than the snapshot, written with the report before
+        if reports:
+            state['peripherals'] = reports[-1][1]
+        elif isinstance(snapshot, dict) and isinstance(snapshot.get('peripherals'), dict):
+            state['peripherals'] = snapshot['peripherals']
+        managers[manager.name] = state
+    return managers
+
+
+def report_state(identifier: str, registered: dict, pending: list[dict]) -> str:
+    operations = [o.get('operation') for o in pending if o.get('identifier') == identifier]
+    if operations:
+        return f'pending {operations[-1]}'
+    if identifier in registered:
+        return 'registered'
+    return 'not registered'
+
+
+def inspect(files: FileConstants = FILE_NAMES, attributes: bool = False) -> dict:
+    """
+    Gathers the peripherals reported by the managers and registered by the agent
+    :param attributes: Whether to keep all the attributes of the peripherals, as reported or registered
+    :return: The managers, and the peripherals by identifier
+    """
+    managers = inspect_managers(files.PERIPHERALS_FOLDER)
+    registered = read_json(files.LOCAL_PERIPHERAL_DB)
+    registered = registered if isinstance(registered, dict) else {}
+    pending = read_json(files.PENDING_PERIPHERAL_OPERATIONS)
+    pending = [o for o in pending if isinstance(o, dict)] if isinstance(pending, list) else []
+
+    peripherals = {}
+
+    def add(identifier: str, data: dict, manager: str | None):
+        entry = peripherals.setdefault(identifier, {'identifier': identifier, 'managers': [], 'data': {}})
+        # What the managers report prevails over what was registered
+        entry['data'] = {**data, **entry['data']} if manager is None else {**entry['data'], **data}
+        if manager and manager not in entry['managers']:
+            entry['managers'].append(manager)
+
+    for name, manager in managers.items():
+        for identifier, data in manager['peripherals'].items():
+            if isinstance(data, dict):
+                add(identifier, data, name)
+    for identifier, data in registered.items():
+        if isinstance(data, dict):
+            add(identifier, data, None)
+    for operation in pending:
+        if operation.get('identifier') and isinstance(operation.get('data'), dict):
+            add(operation['identifier'], operation['data'], None)
+
+    for identifier, entry in peripherals.items():
+        data = entry.pop('data')
+        entry.update({
+            'name': data.get('name'),
+            'interface': data.get('interface'),
+            'classes': data.get('classes') or [],
+            'serial-number': data.get('serial-number'),
+            'device-nodes': device_nodes(data),
+            'available': data.get('available'),
+            'state': report_state(identifier, registered, pending),
+            'nuvla-id': (registered.get(identifier) or {}).get('id'),
+        })
+        if attributes:
+            entry['attributes'] = data
+
+    for manager in managers.values():
+        manager['peripherals'] = sorted(manager['peripherals'])
+    return {'managers': managers, 'peripherals': dict(sorted(peripherals.items())), 'pending-operations': len(pending)}
+
+
+def table(rows: list[list[str]]) -> str:
+    widths = [max(len(row[i]) for row in rows) for i in range(len(rows[0]))]
+    return '\n'.join('  '.join(cell.ljust(width) for cell, width in zip(row, widths)).rstrip() for row in rows)
+
+
+def format_table(result: dict) -> str:
+    lines = []
+    rows = [['MANAGER', 'STATUS', 'PERIPHERALS', 'BUFFERED', 'LAST REPORT', 'LAST ERROR']]
+    for name, manager in result['managers'].items():
+        rows.append([name, manager['status'] or '-', str(len(manager['peripherals'])), str(manager['buffered-reports']),
+                     manager['last-buffered-report'] or manager['snapshot-time'] or '-', manager['last-error'] or ''])
+    lines.append(table(rows) if len(rows) > 1 else 'No peripheral manager running')
+    lines.append('')
+
+    rows = [['IDENTIFIER', 'MANAGER', 'INTERFACE', 'NAME', 'CLASSES', 'SERIAL', 'DEVICE NODES', 'STATE']]
+    for identifier, peripheral in result['peripherals'].items():
+        rows.append([identifier, ','.join(peripheral['managers']) or '-', peripheral['interface'] or '-',
+                     peripheral['name'] or '-', ','.join(peripheral['classes']) or '-',
+                     peripheral['serial-number'] or '-', ','.join(peripheral['device-nodes']) or '-',
+                     peripheral['state']])
+    lines.append(table(rows) if len(rows) > 1 else 'No peripheral known')
+    if result['pending-operations']:
+        lines.append('')
+        lines.append(f'{result["pending-operations"]} operations waiting for Nuvla to be reachable')
+    return '\n'.join(lines)
+
+
+def format_tree(result: dict) -> str:
+    lines = []
+    groups: dict[str, list[str]] = {name: [] for name in result['managers']}
+    for identifier, peripheral in result['peripherals'].items():
+        for manager in peripheral['managers'] or ['(registered only)']:
+            groups.setdefault(manager, []).append(identifier)
+
+    for name, identifiers in groups.items():
+        manager = result['managers'].get(name)
+        header = name if manager is None else f'{name} [{manager["status"] or "no status"}, ' \
+                                              f'{manager["buffered-reports"]} buffered reports]'
+        lines.append(header)
+        for i, identifier in enumerate(identifiers):
+            peripheral = result['peripherals'][identifier]
+            last = i == len(identifiers) - 1
+            lines.append(f'{"└── " if last else "├── "}{identifier} ({peripheral["name"] or "unnamed"}) '
+                         f'{peripheral["state"]}')
+            details = [('interface', peripheral['interface']), ('classes', ', '.join(peripheral['classes'])),
+                       ('serial', peripheral['serial-number']), ('nodes', ', '.join(peripheral['device-nodes'])),
+                       ('nuvla', peripheral['nuvla-id'])]
+            details = [(k, v) for k, v in details if v]
+            for j, (key, value) in enumerate(details):
+                lines.append(f'{"    " if last else "│   "}{"└── " if j == len(details) - 1 else "├── "}{key}: {value}')
+    return '\n'.join(lines) if lines else 'No peripheral known'
+
+
+def run_inspect(args: argparse.Namespace) -> int:
+    files = FileConstants(args.root) if args.root else FILE_NAMES
+    result = inspect(files)
+    if args.manager:
+        result['managers'] = {k: v for k, v in result['managers'].items() if k == args.manager}
+        result['peripherals'] = {k: v for k, v in result['peripherals'].items() if args.manager in v['managers']}
+
+    if args.json:
+        print(json.dumps(result, indent=2))
+    elif args.tree:
+        print(format_tree(result))
+    else:
+        print(format_table(result))
+    return 0
+
+
+def run_mount(args: argparse.Namespace) -> int:
+    from nuvlaedge.peripherals.fuse_view import mount
+
+    files = FileConstants(args.root) if args.root else FILE_NAMES
+    try:
+        mount(args.mountpoint, files, args.refresh, allow_other=not args.owner_only)
+    except (RuntimeError, OSError) as e:
+        print(e, file=sys.stderr)
+        return 1
+    return 0
+
+
+def run_dbus(args: argparse.Namespace) -> int:
+    from nuvlaedge.peripherals.dbus_service import serve
+
+    files = FileConstants(args.root) if args.root else FILE_NAMES
+    try:
+        serve(args.bus, files, args.refresh) if args.bus else serve(files=files, refresh=args.refresh)
+    except (RuntimeError, ValueError, OSError) as e:
+        print(e, file=sys.stderr)
+        return 1
+    return 0
+
+
+def verify_bom(document: bytes, signature: bytes | None, public_key: bytes | None) -> str:
+    """
+    :return: valid or invalid, unsigned without signature or public key, and unverified without the cryptography
+        package to check the Ed25519 signature with
+    """
+    if not signature or not public_key:
+        return 'unsigned'
+    try:
+        from cryptography.exceptions import InvalidSignature
+        from cryptography.hazmat.primitives.serialization import load_pem_public_key
+    except ImportError:
+        return 'unverified'
+
+    try:
+        load_pem_public_key(public_key).verify(signature, document)
+    except (InvalidSignature, ValueError, TypeError, AttributeError):
+        return 'invalid'
+    return 'valid'
+
+
+def run_bom(args: argparse.Namespace) -> int:
+    files = FileConstants(args.root) if args.root else FILE_NAMES
+    folder = files.PERIPHERALS_FOLDER / args.manager
+    path = folder / BOM_FILE
+    try:
+        document = path.read_bytes()
+    except OSError:
+        print(f'No hardware bill of materials at {path}, set USB_BOM_INTERVAL to have the manager make it',
+              file=sys.stderr)
+        return 1
+
+    def optional(file: Path) -> bytes | None:
+        try:
+            return file.read_bytes()
+        except OSError:
+            return None
+
+    signature = optional(path.with_name(path.name + '.sig'))
+    public_key = optional(folder / BOM_PUBLIC_KEY_FILE)
+    verification = verify_bom(document, signature, public_key)
+    print(f'Signature of {path}: {verification}', file=sys.stderr)
+    if verification == 'invalid':
+        return 1
+
+    if not args.output:
+        print(document.decode())
+        return 0
+    output = Path(args.output)
+    output.write_bytes(document)
+    if signature:
+        output.with_name(output.name + '.sig').write_bytes(signature)
+    if public_key:
+        shutil.copyfile(folder / BOM_PUBLIC_KEY_FILE, output.with_name(output.name + '.pub'))
+    return 0
+
+
+def parse_arguments(argv: list[str] | None = None) -> argparse.Namespace:
+    parser = argparse.ArgumentParser(prog='nuvlaedge-peripherals', description='NuvlaEdge peripherals tools')
+    commands = parser.add_subparsers(dest='command', required=True)
+
+    inspect_parser = commands.add_parser('inspect', help='Print the peripherals known to the managers and the agent')
+    output = inspect_parser.add_mutually_exclusive_group()
+    output.add_argument('--tree', action='store_true', help='Group the peripherals by manager, with their details')
+    output.add_argument('--json', action='store_true', help='Print the raw result, for scripts')
+    inspect_parser.add_argument('--manager', help='Only show the peripherals of this manager, such as usb')
+    inspect_parser.add_argument('--root', help=f'Shared data volume, {FILE_NAMES.root_fs} by default')
+    inspect_parser.set_defaults(run=run_inspect)
+
+    mount_parser = commands.add_parser('mount', help='Serve the peripherals as a read-only filesystem, one file per '
+                                                     'attribute, until unmounted')
+    mount_parser.add_argument('--mountpoint', default='/run/nuvlaedge/peripherals', help='Where to mount the view')
+    mount_parser.add_argument('--refresh', type=float, default=5, help='Seconds between two updates of the view')
+    mount_parser.add_argument('--owner-only', action='store_true', help='Only let the current user read the view')
+    mount_parser.add_argument('--root', help=f'Shared data volume, {FILE_NAMES.root_fs} by default')
+    mount_parser.set_defaults(run=run_mount)
+
+    dbus_parser = commands.add_parser('dbus', help='Publish the peripherals and their changes on the system D-Bus')
+    dbus_parser.add_argument('--bus', help='Address of the bus, DBUS_SYSTEM_BUS_ADDRESS or the system bus by default')
+    dbus_parser.add_argument('--refresh', type=float, default=5, help='Seconds between two checks of the changes')
+    dbus_parser.add_argument('--root', help=f'Shared data volume, {FILE_NAMES.root_fs} by default')
+    dbus_parser.set_defaults(run=run_dbus)
+
+    bom_parser = commands.add_parser('bom', help='Verify and print or export the signed hardware bill of materials')
+    bom_parser.add_argument('--manager', default='usb', help='Manager making the bill of materials')
+    bom_parser.add_argument('--output', help='File to export the document to, with its .sig signature and .pub key')
+    bom_parser.add_argument('--root', help=f'Shared data volume, {FILE_NAMES.root_fs} by default')
+    bom_parser.set_defaults(run=run_bom)
+    return parser.parse_args(argv)
+
+
+def main(argv: list[str] | None = None) -> int:
+    args = parse_arguments(argv)
+    return args.run(args)
+
+
+def entry():
+    sys.exit(main())
+
+
+if __name__ == '__main__':
+    entry()
@@ -0,0 +1,335 @@
+#!/usr/bin/env python3
+# -*- coding: utf-8 -*-
+
+"""Peripheral managers configuration file
+
+The peripheral managers share a configuration file, /etc/nuvlaedge/peripherals.yaml (NUVLAEDGE_PERIPHERALS_CONFIG),
+with one section per manager:
+    usb:
+      scan-interval: 10s
+    bluetooth:
+      gatt-enumeration: true
+    network:
+      subnet-sweep: true
+      probe-exclude: [10.0.0.5, 10.0.1.0/24]
+
+Each manager validates its section against its schema at startup, and exports its settings as the environment
+variables they stand for, unless these are already set. The file is the subset of YAML the USB manager also reads:
+sections of scalars and lists, so it needs no YAML library.
+
+The settings of the managers can also be pushed from Nuvla, in the peripherals-configuration attribute of the
+nuvlabox resource, with the same sections. The agent writes them to the peripherals folder, remote_config.json, for
+the managers following it to apply the changes while running.
+"""
+import difflib
+import json
+import logging
+import os
+import re
+
+from nuvlaedge.common.constant_files import FILE_NAMES
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+CONFIG_FILE = os.getenv('NUVLAEDGE_PERIPHERALS_CONFIG', '/etc/nuvlaedge/peripherals.yaml')
+REMOTE_CONFIG_FILE = FILE_NAMES.PERIPHERALS_REMOTE_CONFIG
+
+SECTIONS = ['usb', 'bluetooth', 'network', 'csi', 'legacy-io']
+
+STRING = 'a string'
+INT = 'an integer'
+FLOAT = 'a number'
+BOOL = 'true or false'
+LIST = 'a list'
+
+# Settings of the Python managers: file key -> (environment variable, kind)
+SCHEMAS: dict[str, dict[str, tuple[str, str]]] = {
+    'bluetooth': {
+        'actions': ('BLUETOOTH_ACTIONS', LIST),
+        'action-timeout': ('BLUETOOTH_ACTION_TIMEOUT', INT),
+        'gatt-enumeration': ('BLE_GATT_ENUMERATION', BOOL),
+        'gatt-max-devices': ('BLE_GATT_MAX_DEVICES', INT),
+        'gatt-timeout': ('BLE_GATT_TIMEOUT', FLOAT),
+        'gatt-retry-interval': ('BLE_GATT_RETRY_INTERVAL', INT),
+    },
+    'network': {
+        'discovery-ipv6': ('NETWORK_DISCOVERY_IPV6', BOOL),
+        'service-detection': ('NETWORK_SERVICE_DETECTION', BOOL),
+        'service-ports': ('NETWORK_SERVICE_PORTS', LIST),
+        'probe-rate': ('NETWORK_PROBE_RATE', FLOAT),
+        'probe-timeout': ('NETWORK_PROBE_TIMEOUT', FLOAT),
+        'probe-exclude': ('NETWORK_PROBE_EXCLUDE', LIST),
+        'subnet-sweep': ('NETWORK_SUBNET_SWEEP', BOOL),
+        'sweep-interval': ('NETWORK_SWEEP_INTERVAL', INT),
+        'sweep-max-hosts': ('NETWORK_SWEEP_MAX_HOSTS', INT),
+        'oui-file': ('NETWORK_OUI_FILE', STRING),
+        'opcua-discovery': ('NETWORK_OPCUA_DISCOVERY', BOOL),
+        'opcua-discovery-urls': ('NETWORK_OPCUA_DISCOVERY_URLS', LIST),
+        'opcua-timeout': ('NETWORK_OPCUA_TIMEOUT', FLOAT),
+        'bacnet-discovery': ('NETWORK_BACNET_DISCOVERY', BOOL),
+        'bacnet-interval': ('NETWORK_BACNET_INTERVAL', INT),
+        'bacnet-timeout': ('NETWORK_BACNET_TIMEOUT', FLOAT),
+        'wireless-adapters': ('NETWORK_WIRELESS_ADAPTERS', BOOL),
+        'restream-api': ('NETWORK_RESTREAM_API', STRING),
+        'restream-url': ('NETWORK_RESTREAM_URL', STRING),
+        'restream-timeout': ('NETWORK_RESTREAM_TIMEOUT', FLOAT),
+        'onvif-username': ('NETWORK_ONVIF_USERNAME', STRING),
+        'onvif-password': ('NETWORK_ONVIF_PASSWORD', STRING),
+        'leader-lock': ('NETWORK_LEADER_LOCK', STRING),
+        'leader-ttl': ('NETWORK_LEADER_TTL', FLOAT),
+        'leader-id': ('NETWORK_LEADER_ID', STRING),
+    },
+    'csi': {
+        'scan-interval': ('CSI_SCAN_INTERVAL', INT),
+        'command-timeout': ('CSI_COMMAND_TIMEOUT', FLOAT),
+    },
+    'legacy-io': {
+        'scan-interval': ('LEGACY_IO_SCAN_INTERVAL', INT),
+        'pci-vendors': ('LEGACY_IO_PCI_VENDORS', LIST),
+    },
+}
+
+# The values the Python managers read as booleans, in any case
+BOOLEANS = ['true', 'false', 'yes', 'no', '1', '0']
+
+_KEY = re.compile(r'^([^\s"\':][^\s"\']*):(?:\s+(.*))?$')
+
+
+class ConfigFileError(ValueError):
+    """
+    Raised with all the problems of an invalid configuration file
+    """
+
+
+class Setting:
+    def __init__(self, line: int, value: str | list[str]):
+        self.line = line
+        self.value = value
+
+
+class ConfigFile:
+    def __init__(self, path: str):
+        self.path = path
+        # Settings of every section, by key
+        self.sections: dict[str, dict[str, Setting]] = {}
+        self.section_lines: dict[str, int] = {}
+
+
+def _strip_comment(line: str) -> str:
+    quote = None
+    for i, c in enumerate(line):
+        if quote:
+            if c == quote:
+                quote = None
+        elif c in '"\'':
+            quote = c
+        elif c == '#' and (i == 0 or line[i - 1] in ' \t'):
+            return line[:i]
+    return line
+
+
+def _scalar(raw: str) -> str:
+    if len(raw) >= 2 and raw[0] == raw[-1] == '"':
+        return raw[1:-1].encode().decode('unicode_escape')
+    if len(raw) >= 2 and raw[0] == raw[-1] == "'":
+        return raw[1:-1].replace("''", "'")
+    if raw.startswith(('"', "'")):
+        raise ValueError(f'unterminated string {raw}')
+    if raw in ('~', 'null'):
+        return ''
+    return raw
+
+
+def parse(content: str, path: str = CONFIG_FILE) -> ConfigFile:
+    """
+    Parses the content of a configuration file
+    :raises ConfigFileError: when the content is not in the supported subset of YAML
+    """
+    config = ConfigFile(path)
+    sections = config.sections
+    section = None
+    list_key = None
+    key_indent = 0
+
+    def error(line: int, message: str) -> ConfigFileError:
+        return ConfigFileError(f'{path}:{line}: {message}')
+
+    for number, raw in enumerate(content.split('\n'), start=1):
+        line = _strip_comment(raw).rstrip(' \r')
+        if not line.strip() or line == '---':
+            continue
+        stripped = line.lstrip(' \t')
+        if '\t' in line[:len(line) - len(stripped)]:
+            raise error(number, 'indentation must use spaces, not tabs')
+        indent = len(line) - len(line.lstrip(' '))
+        text = line.strip()
+
+        if indent == 0:
+            match = _KEY.match(text)
+            if not match or match.group(2):
+                raise error(number, f'expected a section name followed by a colon, such as "usb:", got "{text}"')
+            section, list_key, key_indent = match.group(1), None, 0
+            if section in sections:
+                raise error(number, f'section {section} is defined twice')
+            sections[section] = {}
+            config.section_lines[section] = number
+            continue
+        if section is None:
+            raise error(number, 'settings must be in a section')
+
+        if text == '-' or text.startswith('- '):
+            if list_key is None:
+                raise error(number, 'list item outside of a list')
+            try:
+                sections[section][list_key].value.append(_scalar(text[1:].strip()))
+            except ValueError as e:
+                raise error(number, str(e))
+            continue
+
+        match = _KEY.match(text)
+        if not match:
+            raise error(number, f'expected a setting such as "key: value", got "{text}"')
+        if key_indent == 0:
+            key_indent = indent
+        elif indent != key_indent:
+            raise error(number, 'settings of a section must all have the same indentation, nested maps are not supported')
+        key, raw_value = match.group(1), (match.group(2) or '').strip()
+        if key in sections[section]:
+            raise error(number, f'{section}.{key} is defined twice')
+
+        list_key = None
+        try:
+            if not raw_value:
+                # Either a block list follows, or the setting is left empty
+                value: str | list[str] = []
+                list_key = key
+            elif raw_value.startswith('['):
+                if not raw_value.endswith(']'):
+                    raise ValueError(f'unterminated list {raw_value}')
+                value = [_scalar(item.strip()) for item in raw_value[1:-1].split(',') if item.strip()]
+            elif raw_value[0] in '{&*|>':
+                raise ValueError(f'only strings, numbers, booleans and lists are supported, got {raw_value}')
+            else:
+                value = _scalar(raw_value)
+        except ValueError as e:
+            raise error(number, str(e))
+        sections[section][key] = Setting(number, value)
+    return config
+
+
+def _suggest(name: str, known: list[str]) -> str:
+    matches = difflib.get_close_matches(name, known, n=1)
+    return f', did you mean {matches[0]}?' if matches else ''
+
+
+def _check(kind: str, value: str | list[str]) -> str | None:
+    """
+    :return: What is wrong with the value, None when it is valid
+    """
+    if isinstance(value, list) and kind != LIST:
+        return 'got an empty value' if not value else 'got a list'
+    if kind == LIST:
+        return None if isinstance(value, list) else f'such as [{value}], got "{value}"'
+    try:
+        if kind == INT:
+            int(value)
+        elif kind == FLOAT:
+            float(value)
+        elif kind == BOOL and value.lower() not in BOOLEANS:
+            raise ValueError
+    except ValueError:
+        return f'got "{value}"'
+    return None
+
+
+def validate(config: ConfigFile, section: str, schema: dict[str, tuple[str, str]]):
+    """
+    Checks the section of a manager against its schema, and the names of all the sections
+    :raises ConfigFileError: with all the problems found
+    """
+    path = config.path
+    problems = []
+    for name in sorted(config.sections):
+        if name not in SECTIONS:
+            problems.append(f'{path}:{config.section_lines[name]}: unknown section {name}{_suggest(name, SECTIONS)}')
+
+    settings = config.sections.get(section, {})
+    for key, setting in sorted(settings.items(), key=lambda item: item[1].line):
+        if key not in schema:
+            problems.append(f'{path}:{setting.line}: unknown setting {section}.{key}{_suggest(key, list(schema))}')
+            continue
+        kind = schema[key][1]
+        problem = _check(kind, setting.value)
+        if problem:
+            problems.append(f'{path}:{setting.line}: {section}.{key} must be {kind}, {problem}')
+
+    if problems:
+        raise ConfigFileError('\n'.join(problems))
+
+
+def load_section(section: str, path: str = CONFIG_FILE, environ=os.environ) -> dict[str, str]:
+    """
+    Exports the settings of a manager section as environment variables, keeping the ones already set, so deployments
+    can still override the file. Does nothing when there is no configuration file.
+    :raises ConfigFileError: when the file is invalid
+    :return: The variables set from the file
+    """
+    try:
+        with open(path) as f:
+            content = f.read()
+    except FileNotFoundError:
+        return {}
+
+    schema = SCHEMAS[section]
+    config = parse(content, path)
+    validate(config, section, schema)
+
+    exported = {}
+    for key, setting in config.sections.get(section, {}).items():
+        name = schema[key][0]
+        value = ','.join(setting.value) if isinstance(setting.value, list) else setting.value
+        if name not in environ:
+            environ[name] = value
+            exported[name] = value
+    logger.info(f'Loaded {len(exported)} settings from {path}')
+    return exported
+
+
+def write_remote_config(configuration: dict | None, path=REMOTE_CONFIG_FILE) -> bool:
+    """
+    Writes the configuration of the peripheral managers pushed from Nuvla, for the managers following it. The file is
+    only replaced when the configuration changes, and removed when Nuvla no longer configures the managers. Each
+    manager validates its own section, and ignores an invalid one.
+    :param configuration: The settings of the managers, by section, as {"usb": {"scan-interval": "10s"}}
+    :return: True when the file changed
+    """
+    configuration = configuration or {}
+    if not isinstance(configuration, dict) or any(not isinstance(s, dict) for s in configuration.values()):
+        logger.warning(f'Ignoring the peripherals configuration of Nuvla, expected settings by section, '
+                       f'got {configuration}')
+        return False
+
+    try:
+        with open(path) as f:
+            current = json.load(f)
+    except FileNotFoundError:
+        current = None
+    except ValueError:
+        current = {}
+
+    if not configuration:
+        if current is None:
+            return False
+        os.remove(path)
+        logger.info(f'Removed the peripherals configuration of Nuvla from {path}')
+        return True
+    if configuration == current:
+        return False
+    os.makedirs(os.path.dirname(path), exist_ok=True)
+    tmp = f'{path}.tmp'
+    with open(tmp, 'w') as f:
+        json.dump(configuration, f, indent=2)
+    os.replace(tmp, path)
+    logger.info(f'Wrote the peripherals configuration of Nuvla, sections {", ".join(sorted(configuration))}, '
+                f'to {path}')
+    return True
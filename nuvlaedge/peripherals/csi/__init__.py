@@ -0,0 +1,288 @@
+#!/usr/bin/env python3
+# -*- coding: utf-8 -*-
+
+"""NuvlaEdge Peripheral Manager CSI
+
+This service discovers the cameras attached to the CSI ports of the board, which are not USB devices and thus not
+reported by the USB manager:
+    - on Raspberry Pi, with the libcamera tools (rpicam-hello, libcamera-hello or cam), falling back to vcgencmd on the
+      legacy camera stack, which only tells how many cameras are detected
+    - on NVIDIA Jetson, from the video4linux devices of the Tegra video input, with v4l2-ctl for their resolutions
+
+Each camera is reported with its sensor model and resolutions. The tools are looked up in the PATH of the container,
+and each of them is given CSI_COMMAND_TIMEOUT seconds to answer.
+
+All these settings can also be set in the csi section of the peripherals configuration file, see config_file.
+
+"""
+
+import logging
+import os
+import re
+import subprocess
+from pathlib import Path
+from shutil import which
+
+from nuvlaedge.peripherals import config_file
+
+# The settings of the configuration file are exported before they are read
+config_file.load_section('csi')
+
+from nuvlaedge.peripherals.peripheral import Peripheral
+from nuvlaedge.common.nuvlaedge_config import parse_arguments_and_initialize_logging
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+SCAN_INTERVAL = int(os.getenv('CSI_SCAN_INTERVAL', '60'))
+COMMAND_TIMEOUT = float(os.getenv('CSI_COMMAND_TIMEOUT', '10'))
+
+DEVICE_TREE_MODEL = '/proc/device-tree/model'
+SYSFS_VIDEO4LINUX = '/sys/class/video4linux'
+
+# The libcamera apps were renamed from libcamera-* to rpicam-* on Raspberry Pi OS Bookworm
+LIBCAMERA_APPS = ['rpicam-hello', 'libcamera-hello']
+
+# Sensor model prefixes, by vendor
+SENSOR_VENDORS = {
+    'imx': 'Sony',
+    'ov': 'OmniVision',
+    'ar': 'onsemi',
+    'gc': 'GalaxyCore',
+}
+
+# "0 : imx219 [3280x2464 10-bit RGGB] (/base/soc/i2c0mux/i2c@1/imx219@10)"
+_LIBCAMERA_CAMERA = re.compile(r'^(\d+)\s*:\s*(\S+)\s*\[(\d+x\d+)[^\]]*\]\s*\((.+)\)\s*$')
+# "Modes: 'SRGGB10_CSI2P' : 640x480 [206.65 fps - (1000, 752)/1280x960 crop]", or one of the following resolutions
+_LIBCAMERA_MODE = re.compile(r'(\d+x\d+)\s*\[([\d.]+)\s*fps')
+# "1: 'imx219' (/base/soc/i2c0mux/i2c@1/imx219@10)", from cam --list
+_CAM_CAMERA = re.compile(r"^(\d+):\s*'([^']+)'\s*\((.+)\)\s*$")
+# "vi-output, imx219 9-0010", the name of the video devices of the Tegra video input
+_TEGRA_VIDEO = re.compile(r'^vi-output,\s*(\S+)\s+(\S+)$')
+_V4L2_SIZE = re.compile(r'Size:\s*Discrete\s+(\d+x\d+)')
+
+
+def run_command(command: list[str]) -> str | None:
+    """
+    Runs a tool, if found in the PATH
+    :return: Its output, None when it is not available or fails
+    """
+    if which(command[0]) is None:
+        return None
+    try:
+        result = subprocess.run(command, capture_output=True, text=True, timeout=COMMAND_TIMEOUT)
+    except (OSError, subprocess.TimeoutExpired) as e:
+        logger.warning(f'Cannot run {" ".join(command)}: {e}')
+        return None
+    if result.returncode != 0:
+        logger.debug(f'{" ".join(command)} failed with {result.returncode}: {result.stderr.strip()}')
+        return None
+    return result.stdout
+
+
+def read_text(path: str | Path) -> str | None:
+    try:
+        return Path(path).read_text().strip('\0\n ')
+    except OSError:
+        return None
+
+
+def sort_resolutions(resolutions) -> list[str]:
+    """
+    :return: The distinct resolutions, from the smallest to the largest
+    """
+    def area(resolution: str) -> tuple[int, int]:
+        width, height = resolution.split('x')
+        return int(width) * int(height), int(width)
+    return sorted(set(resolutions), key=area)
+
+
+def parse_libcamera_list(output: str) -> list[dict]:
+    """
+    Parses the camera list of the libcamera apps, rpicam-hello --list-cameras
+    :return: The index, sensor, largest resolution, device tree node and modes of each camera
+    """
+    cameras = []
+    for line in output.splitlines():
+        match = _LIBCAMERA_CAMERA.match(line.strip())
+        if match:
+            cameras.append({
+                'index': int(match.group(1)),
+                'sensor': match.group(2),
+                'resolutions': [match.group(3)],
+                'node': match.group(4),
+                'frame-rates': {}
+            })
+            continue
+        if not cameras:
+            continue
+        for resolution, fps in _LIBCAMERA_MODE.findall(line):
+            camera = cameras[-1]
+            camera['resolutions'].append(resolution)
+            camera['frame-rates'][resolution] = max(float(fps), camera['frame-rates'].get(resolution, 0.0))
+
+    for camera in cameras:
+        camera['resolutions'] = sort_resolutions(camera['resolutions'])
+    return cameras
+
+
+def parse_cam_list(output: str) -> list[dict]:
+    """
+    Parses the camera list of cam --list, which does not tell their modes
+    """
+    cameras = []
+    for line in output.splitlines():
+        match = _CAM_CAMERA.match(line.strip())
+        if match:
+            # cam numbers the cameras from 1, the libcamera apps from 0
+            cameras.append({'index': int(match.group(1)) - 1, 'sensor': match.group(2), 'resolutions': [],
+                            'node': match.group(3), 'frame-rates': {}})
+    return cameras
+
+
+def parse_vcgencmd_camera(output: str) -> int:
+    """
+    Parses vcgencmd get_camera, "supported=1 detected=1, libcamera interfaces=0"
+    :return: The number of cameras detected by the legacy camera stack
+    """
+    match = re.search(r'\bdetected=(\d+)', output)
+    return int(match.group(1)) if match else 0
+
+
+def raspberry_pi_cameras() -> list[dict]:
+    """
+    Lists the CSI cameras of a Raspberry Pi, with the first of the libcamera tools available
+    """
+    for app in LIBCAMERA_APPS:
+        output = run_command([app, '--list-cameras'])
+        if output is not None:
+            return parse_libcamera_list(output)
+
+    output = run_command(['cam', '--list'])
+    if output is not None:
+        return parse_cam_list(output)
+
+    output = run_command(['vcgencmd', 'get_camera'])
+    if output is not None:
+        return [{'index': i, 'sensor': None, 'resolutions': [], 'node': None, 'frame-rates': {}}
+                for i in range(parse_vcgencmd_camera(output))]
+    return []
+
+
+def jetson_cameras(root: str | Path = SYSFS_VIDEO4LINUX) -> list[dict]:
+    """
+    Lists the CSI cameras of a Jetson, the video devices of the Tegra video input, with their resolutions when
+    v4l2-ctl is available
+    """
+    root = Path(root)
+    if not root.is_dir():
+        return []
+
+    cameras = []
+    for video in sorted(root.glob('video*'), key=lambda p: int(re.sub(r'\D', '', p.name) or 0)):
+        match = _TEGRA_VIDEO.match(read_text(video / 'name') or '')
+        if not match:
+            continue
+        device = f'/dev/{video.name}'
+        output = run_command(['v4l2-ctl', '--list-formats-ext', '-d', device]) or ''
+        cameras.append({
+            'index': len(cameras),
+            'sensor': match.group(1),
+            'resolutions': sort_resolutions(_V4L2_SIZE.findall(output)),
+            # The I2C bus and address of the sensor
+            'node': match.group(2),
+            'device-path': device,
+            'frame-rates': {}
+        })
+    return cameras
+
+
+def sensor_vendor(sensor: str | None) -> str | None:
+    if not sensor:
+        return None
+    for prefix, vendor in SENSOR_VENDORS.items():
+        if sensor.lower().startswith(prefix):
+            return vendor
+    return None
+
+
+def format_camera(camera: dict, board: str) -> dict:
+    """
+    Formats a CSI camera into a Nuvla compliant peripheral
+    """
+    sensor = camera['sensor']
+    # Variants such as imx708_wide share the sensor of their base model
+    model = sensor.split('_')[0].upper() if sensor else None
+    name = f'{model} CSI camera' if model else 'CSI camera'
+    description = f'CSI camera {camera["index"]} of the {board}'
+    if sensor:
+        description += f', sensor {sensor}'
+    if camera['resolutions']:
+        description += f', up to {camera["resolutions"][-1]}'
+
+    csi = {'port': camera['index'], 'sensor': sensor, 'resolutions': camera['resolutions']}
+    if camera['node']:
+        csi['node'] = camera['node']
+    if camera['frame-rates']:
+        csi['max-frame-rates'] = camera['frame-rates']
+
+    peripheral = {
+        'identifier': f'csi-{camera["index"]}-{sensor}' if sensor else f'csi-{camera["index"]}',
+        'available': True,
+        'interface': 'CSI',
+        'classes': ['video'],
+        'name': name,
+        'description': description,
+        'additional-assets': {'csi': csi}
+    }
+    vendor = sensor_vendor(sensor)
+    if vendor:
+        peripheral['vendor'] = vendor
+    if model:
+        peripheral['product'] = model
+    if camera.get('device-path'):
+        peripheral['device-path'] = camera['device-path']
+    return peripheral
+
+
+def board_model(path: str = DEVICE_TREE_MODEL) -> str:
+    return read_text(path) or ''
+
+
+def flow(model_path: str = DEVICE_TREE_MODEL, video4linux: str = SYSFS_VIDEO4LINUX) -> dict[str, dict]:
+    """
+    :return: The CSI cameras of the board, as peripherals by identifier
+    """
+    board = board_model(model_path)
+    if 'Raspberry Pi' in board:
+        cameras = raspberry_pi_cameras()
+    elif 'Jetson' in board or 'NVIDIA' in board:
+        cameras = jetson_cameras(video4linux)
+    else:
+        logger.debug(f'No CSI camera support for board "{board}"')
+        return {}
+
+    peripherals = {}
+    for camera in cameras:
+        peripheral = format_camera(camera, board)
+        peripherals[peripheral['identifier']] = peripheral
+    return peripherals
+
+
+def main():
+    global logger
+    parse_arguments_and_initialize_logging('CSI Peripheral')
+
+    logger = logging.getLogger(__name__)
+    logger.info('CSI PERIPHERAL MANAGER STARTED')
+
+    csi_peripheral: Peripheral = Peripheral('csi', scanning_interval=SCAN_INTERVAL)
+    csi_peripheral.run(flow)
+
+
+def entry():
+    main()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,7 @@
+#!/usr/bin/env python3
+# -*- coding: utf-8 -*-
+
+from nuvlaedge.peripherals.csi import main
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,535 @@
+"""
+D-Bus service publishing the peripherals on the system bus, for the system services and desktop applications of the
+edge device
+
+    nuvlaedge-peripherals dbus [--bus unix:path=/run/dbus/system_bus_socket] [--refresh 5] [--root /var/lib/nuvlaedge/]
+
+The service owns the org.nuvlaedge.Peripherals name, and its /org/nuvlaedge/Peripherals object implements the
+org.nuvlaedge.Peripherals interface:
+    List() -> as                         the identifiers of the peripherals known to the managers and the agent
+    Get(s identifier) -> a{ss}           the attributes of a peripheral as text, as in the files of the FUSE view, with
+                                         its state and managers
+    Inspect() -> s                       everything known of the managers and the peripherals, as JSON
+    Count (u), Managers (as)             read-only properties
+    PeripheralAdded(s identifier)        signals emitted when the peripherals change, checked every refresh seconds
+    PeripheralRemoved(s identifier)
+    PeripheralChanged(s identifier, s state)
+
+The bus only lets the service own its name with a policy allowing it, as conf/example/org.nuvlaedge.Peripherals.conf
+installed in /etc/dbus-1/system.d/. The protocol is spoken directly on the unix socket of the bus, so no D-Bus library
+is needed.
+"""
+import json
+import logging
+import os
+import select
+import socket
+import struct
+import time
+from urllib.parse import unquote
+
+from nuvlaedge.common.constant_files import FILE_NAMES, FileConstants
+from nuvlaedge.peripherals.cli import inspect
+from nuvlaedge.peripherals.fuse_view import encode_value
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+SYSTEM_BUS_ADDRESS = os.getenv('DBUS_SYSTEM_BUS_ADDRESS', 'unix:path=/var/run/dbus/system_bus_socket')
+REFRESH = 5
+
+BUS_NAME = 'org.nuvlaedge.Peripherals'
+OBJECT_PATH = '/org/nuvlaedge/Peripherals'
+INTERFACE = 'org.nuvlaedge.Peripherals'
+UNKNOWN_PERIPHERAL = 'org.nuvlaedge.Peripherals.Error.UnknownPeripheral'
+
+DBUS_NAME = 'org.freedesktop.DBus'
+DBUS_PATH = '/org/freedesktop/DBus'
+PROPERTIES = 'org.freedesktop.DBus.Properties'
+INTROSPECTABLE = 'org.freedesktop.DBus.Introspectable'
+PEER = 'org.freedesktop.DBus.Peer'
+
+# Message types and flags
+METHOD_CALL, METHOD_RETURN, ERROR, SIGNAL = 1, 2, 3, 4
+NO_REPLY_EXPECTED = 0x1
+# Header fields
+PATH, FIELD_INTERFACE, MEMBER, ERROR_NAME, REPLY_SERIAL, DESTINATION, SENDER, SIGNATURE = range(1, 9)
+HEADER_SIGNATURES = {PATH: 'o', FIELD_INTERFACE: 's', MEMBER: 's', ERROR_NAME: 's', REPLY_SERIAL: 'u',
+                     DESTINATION: 's', SENDER: 's', SIGNATURE: 'g'}
+# RequestName flags and replies
+DO_NOT_QUEUE = 0x4
+PRIMARY_OWNER, ALREADY_OWNER = 1, 4
+
+ALIGNMENT = {'y': 1, 'b': 4, 'n': 2, 'q': 2, 'i': 4, 'u': 4, 'x': 8, 't': 8, 'd': 8, 'h': 4,
+             's': 4, 'o': 4, 'g': 1, 'a': 4, '(': 8, '{': 8, 'v': 1}
+FIXED = {'y': 'B', 'b': 'I', 'n': 'h', 'q': 'H', 'i': 'i', 'u': 'I', 'x': 'q', 't': 'Q', 'd': 'd', 'h': 'I'}
+
+INTROSPECTION = f'''<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">
+<node>
+  <interface name="{INTERFACE}">
+    <method name="List"><arg name="identifiers" type="as" direction="out"/></method>
+    <method name="Get">
+      <arg name="identifier" type="s" direction="in"/>
+      <arg name="attributes" type="a{{ss}}" direction="out"/>
+    </method>
+    <method name="Inspect"><arg name="json" type="s" direction="out"/></method>
+    <property name="Count" type="u" access="read"/>
+    <property name="Managers" type="as" access="read"/>
+    <signal name="PeripheralAdded"><arg name="identifier" type="s"/></signal>
+    <signal name="PeripheralRemoved"><arg name="identifier" type="s"/></signal>
+    <signal name="PeripheralChanged"><arg name="identifier" type="s"/><arg name="state" type="s"/></signal>
+  </interface>
+  <interface name="{PROPERTIES}">
+    <method name="Get">
+      <arg name="interface" type="s" direction="in"/><arg name="name" type="s" direction="in"/>
+      <arg name="value" type="v" direction="out"/>
+    </method>
+    <method name="GetAll">
+      <arg name="interface" type="s" direction="in"/><arg name="properties" type="a{{sv}}" direction="out"/>
+    </method>
+  </interface>
+  <interface name="{INTROSPECTABLE}">
+    <method name="Introspect"><arg name="xml" type="s" direction="out"/></method>
+  </interface>
+  <interface name="{PEER}"><method name="Ping"/></interface>
+</node>
+'''
+
+
+def _type_end(signature: str, i: int) -> int:
+    c = signature[i]
+    if c == 'a':
+        return _type_end(signature, i + 1)
+    if c in '({':
+        close = ')' if c == '(' else '}'
+        i += 1
+        while signature[i] != close:
+            i = _type_end(signature, i)
+        return i + 1
+    if c not in ALIGNMENT:
+        raise ValueError(f'Invalid D-Bus type {c} in signature {signature}')
+    return i + 1
+
+
+def split_signature(signature: str) -> list[str]:
+    """
+    :return: The complete types of a signature, as ['s', 'a{sv}'] for sa{sv}
+    """
+    types, i = [], 0
+    try:
+        while i < len(signature):
+            end = _type_end(signature, i)
+            types.append(signature[i:end])
+            i = end
+    except IndexError:
+        raise ValueError(f'Invalid D-Bus signature {signature}')
+    return types
+
+
+class Writer:
+    """
+    Marshals values in the little-endian D-Bus format. Variants are (signature, value) tuples.
+    """
+
+    def __init__(self):
+        self.data: bytearray = bytearray()
+
+    def align(self, alignment: int):
+        self.data.extend(b'\0' * (-len(self.data) % alignment))
+
+    def write(self, signature: str, value):
+        c = signature[0]
+        self.align(ALIGNMENT[c])
+        if c in FIXED:
+            self.data.extend(struct.pack('<' + FIXED[c], int(value) if c == 'b' else value))
+        elif c in 'so':
+            encoded = value.encode()
+            self.data.extend(struct.pack('<I', len(encoded)) + encoded + b'\0')
+        elif c == 'g':
+            encoded = value.encode()
+            self.data.extend(struct.pack('<B', len(encoded)) + encoded + b'\0')
+        elif c == 'v':
+            self.write('g', value[0])
+            self.write(value[0], value[1])
+        elif c == '(':
+            for t, v in zip(split_signature(signature[1:-1]), value):
+                self.write(t, v)
+        elif c == '{':
+            key, item = split_signature(signature[1:-1])
+            self.write(key, value[0])
+            self.write(item, value[1])
+        elif c == 'a':
+            element = signature[1:]
+            length_offset = len(self.data)
+            self.data.extend(b'\0' * 4)
+            # The length excludes the padding before the first element
+            self.align(ALIGNMENT[element[0]])
+            start = len(self.data)
+            for item in value.items() if element[0] == '{' else value:
+                self.write(element, item)
+            struct.pack_into('<I', self.data, length_offset, len(self.data) - start)
+
+
+class Reader:
+    """
+    Unmarshals values of the D-Bus format, in either byte order
+    """
+
+    def __init__(self, data: bytes, endian: str = '<', offset: int = 0):
+        self.data: bytes = data
+        self.endian: str = endian
+        self.offset: int = offset
+
+    def align(self, alignment: int):
+        self.offset += -self.offset % alignment
+
+    def unpack(self, fmt: str):
+        (value,) = struct.unpack_from(self.endian + fmt, self.data, self.offset)
+        self.offset += struct.calcsize(fmt)
+        return value
+
+    def read(self, signature: str):
+        c = signature[0]
+        self.align(ALIGNMENT[c])
+        if c in FIXED:
+            value = self.unpack(FIXED[c])
+            return bool(value) if c == 'b' else value
+        if c in 'sog':
+            length = self.unpack('B' if c == 'g' else 'I')
+            value = bytes(self.data[self.offset:self.offset + length]).decode()
+            self.offset += length + 1
+            return value
+        if c == 'v':
+            inner = self.read('g')
+            return inner, self.read(inner)
+        if c == '(':
+            return tuple(self.read(t) for t in split_signature(signature[1:-1]))
+        if c == '{':
+            key, item = split_signature(signature[1:-1])
+            return self.read(key), self.read(item)
+        # Array
+        length = self.unpack('I')
+        element = signature[1:]
+        self.align(ALIGNMENT[element[0]])
+        end = self.offset + length
+        items = []
+        while self.offset < end:
+            items.append(self.read(element))
+        return dict(items) if element[0] == '{' else items
+
+
+class Message:
+    """
+    A D-Bus message, with its header fields by code and its body values
+    """
+
+    def __init__(self, message_type: int, fields: dict | None = None, signature: str = '', body: tuple | list = (),
+                 flags: int = 0, serial: int = 0):
+        self.type: int = message_type
+        self.fields: dict = fields or {}
+        self.signature: str = signature
+        self.body: list = list(body)
+        self.flags: int = flags
+        self.serial: int = serial
+
+    path = property(lambda self: self.fields.get(PATH))
+    interface = property(lambda self: self.fields.get(FIELD_INTERFACE))
+    member = property(lambda self: self.fields.get(MEMBER))
+    error_name = property(lambda self: self.fields.get(ERROR_NAME))
+    reply_serial = property(lambda self: self.fields.get(REPLY_SERIAL))
+    sender = property(lambda self: self.fields.get(SENDER))
+
+    def encode(self) -> bytes:
+        body = Writer()
+        for t, v in zip(split_signature(self.signature), self.body):
+            body.write(t, v)
+        fields = dict(self.fields)
+        if self.signature:
+            fields[SIGNATURE] = self.signature
+
+        header = Writer()
+        for value in (ord('l'), self.type, self.flags, 1):
+            header.write('y', value)
+        header.write('u', len(body.data))
+        header.write('u', self.serial)
+        header.write('a(yv)', [(code, (HEADER_SIGNATURES[code], value)) for code, value in sorted(fields.items())])
+        header.align(8)
+        return bytes(header.data + body.data)
+
+    @classmethod
+    def decode(cls, buffer: bytes) -> tuple['Message | None', int]:
+        """
+        :return: The first message of the buffer and its length, or None and 0 when it is not complete yet
+        """
+        if len(buffer) < 16:
+            return None, 0
+        endian = '<' if buffer[0] == ord('l') else '>'
+        body_length, serial, fields_length = struct.unpack_from(endian + 'III', buffer, 4)
+        header_end = 16 + fields_length
+        header_end += -header_end % 8
+        if len(buffer) < header_end + body_length:
+            return None, 0
+
+        fields = {code: value[1] for code, value in Reader(buffer, endian, 12).read('a(yv)')}
+        signature = fields.pop(SIGNATURE, '')
+        reader = Reader(bytes(buffer[header_end:header_end + body_length]), endian)
+        body = [reader.read(t) for t in split_signature(signature)]
+        return cls(buffer[1], fields, signature, body, buffer[2], serial), header_end + body_length
+
+
+def method_return(call: Message, signature: str = '', *values) -> Message:
+    return Message(METHOD_RETURN, {REPLY_SERIAL: call.serial, **({DESTINATION: call.sender} if call.sender else {})},
+                   signature, values)
+
+
+def error(call: Message, name: str, text: str) -> Message:
+    return Message(ERROR, {REPLY_SERIAL: call.serial, ERROR_NAME: name,
+                           **({DESTINATION: call.sender} if call.sender else {})}, 's', [text])
+
+
+def signal(member: str, signature: str = '', *values) -> Message:
+    return Message(SIGNAL, {PATH: OBJECT_PATH, FIELD_INTERFACE: INTERFACE, MEMBER: member}, signature, values,
+                   NO_REPLY_EXPECTED)
+
+
+def socket_path(address: str) -> str:
+    """
+    :return: The path of the first unix socket of a bus address, with a leading NUL for the abstract ones
+    """
+    for entry in address.split(';'):
+        transport, _, params = entry.partition(':')
+        if transport != 'unix':
+            continue
+        options = dict(p.split('=', 1) for p in params.split(',') if '=' in p)
+        if 'path' in options:
+            return unquote(options['path'])
+        if 'abstract' in options:
+            return '\0' + unquote(options['abstract'])
+    raise ValueError(f'No unix socket in the D-Bus address {address}')
+
+
+class BusConnection:
+    """
+    A connection to a message bus, authenticated as the user running the service
+    """
+
+    def __init__(self, sock: socket.socket):
+        self.sock: socket.socket = sock
+        self.buffer: bytearray = bytearray()
+        self.queue: list[Message] = []
+        self.serial: int = 0
+        self.unique_name: str | None = None
+
+    @classmethod
+    def connect(cls, address: str = SYSTEM_BUS_ADDRESS, timeout: float = 10) -> 'BusConnection':
+        sock = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        sock.settimeout(timeout)
+        sock.connect(socket_path(address))
+        connection = cls(sock)
+        connection.authenticate()
+        reply = connection.call(DBUS_NAME, DBUS_PATH, DBUS_NAME, 'Hello')
+        connection.unique_name = reply.body[0]
+        return connection
+
+    def authenticate(self):
+        uid = str(os.getuid()).encode().hex()
+        self.sock.sendall(b'\0' + f'AUTH EXTERNAL {uid}\r\n'.encode())
+        while b'\r\n' not in self.buffer:
+            self._recv()
+        line, _, rest = bytes(self.buffer).partition(b'\r\n')
+        self.buffer = bytearray(rest)
+        if not line.startswith(b'OK'):
+            raise ConnectionError(f'The bus refused the authentication: {line.decode(errors="replace")}')
+        self.sock.sendall(b'BEGIN\r\n')
+
+    def _recv(self):
+        data = self.sock.recv(65536)
+        if not data:
+            raise ConnectionError('The bus closed the connection')
+        self.buffer.extend(data)
+
+    def send(self, message: Message) -> int:
+        self.serial += 1
+        message.serial = self.serial
+        self.sock.sendall(message.encode())
+        return message.serial
+
+    def receive(self, timeout: float | None = None) -> Message | None:
+        """
+        :return: The next message received, None when none arrived in time
+        """
+        if self.queue:
+            return self.queue.pop(0)
+        deadline = None if timeout is None else time.monotonic() + timeout
+        while True:
+            message, length = Message.decode(self.buffer)
+            if message is not None:
+                del self.buffer[:length]
+                return message
+            remaining = None if deadline is None else max(0.0, deadline - time.monotonic())
+            if not select.select([self.sock], [], [], remaining)[0]:
+                return None
+            self._recv()
+
+    def call(self, destination: str, path: str, interface: str, member: str, signature: str = '', *values) -> Message:
+        """
+        :return: The reply of the call, the other messages received meanwhile are kept for receive
+        """
+        serial = self.send(Message(METHOD_CALL, {PATH: path, FIELD_INTERFACE: interface, MEMBER: member,
+                                                 DESTINATION: destination}, signature, values))
+        pending = []
+        try:
+            while True:
+                message = self.receive(self.sock.gettimeout())
+                if message is None:
+                    raise TimeoutError(f'No answer from the bus to {member}')
+                if message.type in (METHOD_RETURN, ERROR) and message.reply_serial == serial:
+                    break
+                pending.append(message)
+        finally:
+            self.queue = pending + self.queue
+        if message.type == ERROR:
+            raise RuntimeError(f'{member} failed with {message.error_name}: {message.body[0] if message.body else ""}')
+        return message
+
+    def close(self):
+        self.sock.close()
+
+
+def text_attributes(attributes: dict) -> dict[str, str]:
+    """
+    :return: The attributes as text, the lists one item per line, and the objects or lists of objects as JSON
+    """
+    text = {}
+    for key, value in attributes.items():
+        if isinstance(value, dict) or (isinstance(value, list) and any(isinstance(v, (dict, list)) for v in value)):
+            text[key] = json.dumps(value)
+        else:
+            text[key] = encode_value(value).decode().rstrip('\n')
+    return text
+
+
+class PeripheralsService:
+    """
+    Answers the calls made to the peripherals object, and tells the changes of the peripherals as signals
+    """
+
+    def __init__(self, files: FileConstants = FILE_NAMES):
+        self.files: FileConstants = files
+        self.result: dict = {'managers': {}, 'peripherals': {}, 'pending-operations': 0}
+        self._fingerprints: dict[str, str] | None = None
+
+    def update(self) -> list[Message]:
+        """
+        Reads the peripherals again
+        :return: The signals of the peripherals added, removed or changed since the last update, none the first time
+        """
+        result = inspect(self.files, attributes=True)
+        fingerprints = {identifier: json.dumps(p, sort_keys=True, default=str)
+                        for identifier, p in result['peripherals'].items()}
+        signals = []
+        if self._fingerprints is not None:
+            for identifier, fingerprint in fingerprints.items():
+                if identifier not in self._fingerprints:
+                    signals.append(signal('PeripheralAdded', 's', identifier))
+                elif self._fingerprints[identifier] != fingerprint:
+                    signals.append(signal('PeripheralChanged', 'ss', identifier,
+                                          result['peripherals'][identifier]['state']))
+            for identifier in self._fingerprints:
+                if identifier not in fingerprints:
+                    signals.append(signal('PeripheralRemoved', 's', identifier))
+        self.result = result
+        self._fingerprints = fingerprints
+        return signals
+
+    def properties(self) -> dict[str, tuple[str, object]]:
+        return {'Count': ('u', len(self.result['peripherals'])),
+                'Managers': ('as', sorted(self.result['managers']))}
+
+    def introspect(self, path: str) -> str | None:
+        if path == OBJECT_PATH:
+            return INTROSPECTION
+        # The parents of the object, for the tools walking the tree from the root
+        if OBJECT_PATH.startswith(path.rstrip('/') + '/'):
+            child = OBJECT_PATH[len(path.rstrip('/')) + 1:].split('/')[0]
+            return f'<node><node name="{child}"/></node>'
+        return None
+
+    def handle(self, call: Message) -> Message | None:
+        """
+        :return: The reply to a method call, None when no reply is expected
+        """
+        reply = self._dispatch(call)
+        return None if call.flags & NO_REPLY_EXPECTED else reply
+
+    def _dispatch(self, call: Message) -> Message:
+        interface, member = call.interface, call.member
+        if interface in (None, INTROSPECTABLE) and member == 'Introspect':
+            xml = self.introspect(call.path or '')
+            if xml is not None:
+                return method_return(call, 's', xml)
+        if interface in (None, PEER) and member == 'Ping':
+            return method_return(call)
+        if call.path != OBJECT_PATH:
+            return error(call, 'org.freedesktop.DBus.Error.UnknownObject', f'No object at {call.path}')
+
+        if interface == PROPERTIES and member in ('Get', 'GetAll', 'Set'):
+            if not call.body or call.body[0] != INTERFACE:
+                return error(call, 'org.freedesktop.DBus.Error.UnknownInterface', 'No such interface')
+            if member == 'GetAll':
+                return method_return(call, 'a{sv}', self.properties())
+            if member == 'Set':
+                return error(call, 'org.freedesktop.DBus.Error.PropertyReadOnly', 'The properties are read-only')
+            value = self.properties().get(call.body[1] if len(call.body) > 1 else '')
+            if value is None:
+                return error(call, 'org.freedesktop.DBus.Error.UnknownProperty', 'No such property')
+            return method_return(call, 'v', value)
+
+        if interface in (None, INTERFACE):
+            if member == 'List':
+                return method_return(call, 'as', list(self.result['peripherals']))
+            if member == 'Get' and call.signature == 's':
+                peripheral = self.result['peripherals'].get(call.body[0])
+                if peripheral is None:
+                    return error(call, UNKNOWN_PERIPHERAL, f'No peripheral {call.body[0]}')
+                attributes = text_attributes(peripheral.get('attributes') or {})
+                attributes.update({'state': peripheral['state'], 'managers': '\n'.join(peripheral['managers'])})
+                return method_return(call, 'a{ss}', attributes)
+            if member == 'Inspect':
+                return method_return(call, 's', json.dumps(self.result))
+        return error(call, 'org.freedesktop.DBus.Error.UnknownMethod',
+                     f'No method {member} with signature "{call.signature}" in {interface or INTERFACE}')
+
+
+def serve(address: str = SYSTEM_BUS_ADDRESS, files: FileConstants = FILE_NAMES, refresh: float = REFRESH):
+    """
+    Serves the peripherals on the bus until the connection is lost
+    """
+    connection = BusConnection.connect(address)
+    try:
+        reply = connection.call(DBUS_NAME, DBUS_PATH, DBUS_NAME, 'RequestName', 'su', BUS_NAME, DO_NOT_QUEUE)
+        if reply.body[0] not in (PRIMARY_OWNER, ALREADY_OWNER):
+            raise RuntimeError(f'{BUS_NAME} is already owned on the bus')
+        logger.info(f'Serving the peripherals on D-Bus as {BUS_NAME} ({connection.unique_name})')
+
+        service = PeripheralsService(files)
+        service.update()
+        next_update = time.monotonic() + refresh
+        while True:
+            message = connection.receive(max(0.0, next_update - time.monotonic()))
+            if message is not None and message.type == METHOD_CALL:
+                reply = service.handle(message)
+                if reply is not None:
+                    connection.send(reply)
+            if time.monotonic() >= next_update:
+                try:
+                    changes = service.update()
+                except Exception as e:
+                    logger.warning(f'Unable to read the peripherals: {e}')
+                    changes = []
+                for change in changes:
+                    connection.send(change)
+                next_update = time.monotonic() + refresh
+    finally:
+        connection.close()
@@ -0,0 +1,146 @@
+"""
+Opt-in enrichment of the peripherals with the data of an online device metadata service
+
+When PERIPHERAL_ENRICHMENT_URL is set, the peripheral manager looks up each device model there, by its USB vendor and
+product ids, or by its interface, vendor and product names for the other peripherals:
+    GET <url>/usb/046d/0825
+    GET <url>/bluetooth/Nordic%20Semiconductor/Thingy%3A52
+The service answers with a JSON object, of which the icon, category and datasheet are added to the additional assets
+of the peripheral, as its device-info. A 404 tells the model is unknown. No serial number nor address is ever sent.
+
+Answers are cached in the enrichment cache file of the peripherals folder, for PERIPHERAL_ENRICHMENT_TTL seconds, a
+day for the unknown models. Cached entries keep being used past their expiration when the service cannot be reached,
+and no request is made for a while after a failure, so the peripherals are reported without delay while offline.
+"""
+import logging
+import os
+import time
+from pathlib import Path
+from urllib.parse import quote
+
+import requests
+
+from nuvlaedge.common.file_operations import read_file, write_file
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+ENRICHMENT_URL = os.getenv('PERIPHERAL_ENRICHMENT_URL', '').rstrip('/')
+ENRICHMENT_TTL = int(os.getenv('PERIPHERAL_ENRICHMENT_TTL', 30 * 24 * 3600))
+ENRICHMENT_TIMEOUT = float(os.getenv('PERIPHERAL_ENRICHMENT_TIMEOUT', 5))
+
+UNKNOWN_TTL = 24 * 3600
+# Time without requests after the service failed to answer
+OFFLINE_BACKOFF = 3600
+# Requests made per iteration of the peripheral manager, the other models are looked up in the next ones
+MAX_LOOKUPS = 10
+
+ASSET = 'device-info'
+FIELDS = ('icon', 'category', 'datasheet')
+
+
+def lookup_key(peripheral: dict) -> str | None:
+    """
+    :return: The path of the device model in the metadata service, None when the peripheral does not tell its model
+    """
+    identifier = peripheral.get('identifier') or ''
+    interface = (peripheral.get('interface') or '').lower()
+    if interface == 'usb' and len(identifier) == 9 and identifier[4] == ':':
+        vendor_id, product_id = identifier.split(':')
+        return f'usb/{vendor_id.lower()}/{product_id.lower()}'
+
+    vendor, product = peripheral.get('vendor'), peripheral.get('product')
+    if not interface or not vendor or not product:
+        return None
+    return '/'.join(quote(part, safe='') for part in (interface, vendor, product))
+
+
+class PeripheralEnricher:
+    """
+    Adds the metadata of their device model to the peripherals, from the cache or the metadata service
+    """
+
+    def __init__(self,
+                 cache_file: str | Path,
+                 url: str = ENRICHMENT_URL,
+                 ttl: int = ENRICHMENT_TTL,
+                 timeout: float = ENRICHMENT_TIMEOUT):
+        self.cache_file: Path = Path(cache_file)
+        self.url: str = url
+        self.ttl: int = ttl
+        self.timeout: float = timeout
+
+        self._cache: dict[str, dict] | None = None
+        self._offline_until: float = 0
+
+    @property
+    def cache(self) -> dict[str, dict]:
+        if self._cache is None:
+            content = read_file(self.cache_file, decode_json=True, warn_on_missing=False)
+            self._cache = content if isinstance(content, dict) else {}
+        return self._cache
+
+    def fetch(self, key: str) -> dict | None:
+        """
+        :return: The metadata of the device model, None when unknown to the service
+        :raises requests.RequestException: when the service cannot be reached or fails
+        """
+        response = requests.get(f'{self.url}/{key}', timeout=self.timeout, headers={'Accept': 'application/json'})
+        if response.status_code == 404:
+            return None
+        response.raise_for_status()
+        data = response.json()
+        if not isinstance(data, dict):
+            raise ValueError(f'expected a JSON object, got {type(data).__name__}')
+        metadata = {field: data[field] for field in FIELDS if isinstance(data.get(field), str) and data[field]}
+        return metadata or None
+
+    def lookup(self, key: str, now: float) -> tuple[dict | None, bool]:
+        """
+        :return: The metadata of the device model, and whether the cache changed
+        """
+        entry = self.cache.get(key)
+        if entry is not None:
+            ttl = self.ttl if entry.get('metadata') else UNKNOWN_TTL
+            if now - entry.get('fetched', 0) < ttl:
+                return entry.get('metadata'), False
+        if now < self._offline_until:
+            return entry.get('metadata') if entry else None, False
+
+        try:
+            metadata = self.fetch(key)
+        except (requests.RequestException, ValueError) as ex:
+            logger.warning(f'Cannot look up {key} in the device metadata service, retrying in {OFFLINE_BACKOFF}s: {ex}')
+            self._offline_until = now + OFFLINE_BACKOFF
+            return entry.get('metadata') if entry else None, False
+
+        self.cache[key] = {'metadata': metadata, 'fetched': now}
+        return metadata, True
+
+    def enrich(self, peripherals: dict[str, dict]) -> dict[str, dict]:
+        """
+        Adds the device-info asset to the peripherals of known models, in place
+        :param peripherals: Peripherals as reported by the managers, by identifier
+        :return: The peripherals
+        """
+        now = time.time()
+        changed = False
+        lookups = 0
+        for peripheral in peripherals.values():
+            key = lookup_key(peripheral) if isinstance(peripheral, dict) else None
+            if key is None:
+                continue
+            if key not in self.cache:
+                if lookups >= MAX_LOOKUPS:
+                    continue
+                lookups += 1
+
+            metadata, updated = self.lookup(key, now)
+            changed |= updated
+            if metadata:
+                assets = peripheral.get('additional-assets') or {}
+                peripheral['additional-assets'] = {**assets, ASSET: metadata}
+
+        if changed:
+            write_file(self.cache, self.cache_file, indent=4)
+        return peripherals
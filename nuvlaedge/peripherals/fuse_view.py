@@ -0,0 +1,196 @@
+"""
+Read-only virtual filesystem mirroring the peripherals known to the managers and the agent
+
+    nuvlaedge-peripherals mount [--mountpoint /run/nuvlaedge/peripherals] [--refresh 5] [--root /var/lib/nuvlaedge/]
+
+Every peripheral is a directory named after its identifier, holding one file per attribute, so shell scripts and the
+applications not reading JSON can consume them directly:
+    /run/nuvlaedge/peripherals/046d:0825/attributes/name           Webcam C270
+    /run/nuvlaedge/peripherals/046d:0825/attributes/video-devices  /dev/video0 and /dev/video1, one per line
+    /run/nuvlaedge/peripherals/046d:0825/attributes/additional-assets/device-info/category
+    /run/nuvlaedge/peripherals/046d:0825/state                     registered
+    /run/nuvlaedge/peripherals/046d:0825/managers                  usb
+
+The values are written as text, with a final newline: the booleans as true or false, the lists one item per line, and
+the objects as directories. The slashes of the identifiers are replaced by %2F. The view is rebuilt from the shared
+data volume at most every refresh seconds, when accessed.
+
+Mounting requires the fusepy package and the FUSE library of the system, as the fuse package of Alpine, and the
+/dev/fuse device with the SYS_ADMIN capability when running in a container.
+"""
+import errno
+import logging
+import os
+import stat
+import time
+
+from nuvlaedge.common.constant_files import FILE_NAMES, FileConstants
+from nuvlaedge.peripherals.cli import inspect
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+MOUNTPOINT = '/run/nuvlaedge/peripherals'
+REFRESH = 5
+
+ATTRIBUTES = 'attributes'
+
+OPERATIONS = ('access', 'destroy', 'flush', 'getattr', 'init', 'open', 'opendir', 'read', 'readdir', 'release',
+              'releasedir', 'statfs')
+# Answered as a read-only filesystem, the other operations not implemented are unsupported
+WRITE_OPERATIONS = ('chmod', 'chown', 'create', 'link', 'mkdir', 'mknod', 'removexattr', 'rename', 'rmdir',
+                    'setxattr', 'symlink', 'truncate', 'unlink', 'utimens', 'write')
+
+
+def encode_name(name: str) -> str:
+    return str(name).replace('%', '%25').replace('/', '%2F') or '%00'
+
+
+def encode_value(value) -> bytes:
+    if isinstance(value, bool):
+        text = 'true' if value else 'false'
+    elif isinstance(value, list):
+        text = '\n'.join('' if v is None else str(v) for v in value)
+    else:
+        text = '' if value is None else str(value)
+    return (text + '\n').encode() if text else b''
+
+
+def to_node(value) -> dict | bytes:
+    """
+    :return: A directory, as a dict of nodes by name, for the objects and the lists of objects, else a file content
+    """
+    if isinstance(value, dict):
+        return {encode_name(k): to_node(v) for k, v in value.items()}
+    if isinstance(value, list) and any(isinstance(v, (dict, list)) for v in value):
+        return {str(i): to_node(v) for i, v in enumerate(value)}
+    return encode_value(value)
+
+
+def build_tree(result: dict) -> dict:
+    """
+    :param result: The peripherals gathered by inspect, with their attributes
+    :return: The root directory of the view
+    """
+    tree = {}
+    for identifier, peripheral in result['peripherals'].items():
+        tree[encode_name(identifier)] = {
+            ATTRIBUTES: to_node(peripheral.get('attributes') or {}),
+            'state': encode_value(peripheral['state']),
+            'managers': encode_value(peripheral['managers']),
+        }
+    return tree
+
+
+class PeripheralsView:
+    """
+    The operations of the filesystem, as called by fusepy. Errors are raised as OSError, answered with their errno.
+    """
+
+    def __init__(self, files: FileConstants = FILE_NAMES, refresh: float = REFRESH):
+        self.files: FileConstants = files
+        self.refresh: float = refresh
+
+        self._tree: dict = {}
+        self._built: float = 0
+        self.uid: int = os.getuid()
+        self.gid: int = os.getgid()
+
+    def __call__(self, operation: str, *args):
+        method = getattr(self, operation, None) if operation in OPERATIONS else None
+        if method is None:
+            raise OSError(errno.EROFS if operation in WRITE_OPERATIONS else errno.ENOSYS, operation)
+        return method(*args)
+
+    @property
+    def tree(self) -> dict:
+        now = time.time()
+        if now - self._built >= self.refresh:
+            try:
+                self._tree = build_tree(inspect(self.files, attributes=True))
+            except Exception as e:
+                # Keep serving the last view rather than failing the reads
+                logger.warning(f'Unable to refresh the peripherals view: {e}')
+            self._built = now
+        return self._tree
+
+    def lookup(self, path: str) -> dict | bytes:
+        node = self.tree
+        for part in (p for p in path.split('/') if p):
+            if not isinstance(node, dict) or part not in node:
+                raise OSError(errno.ENOENT, path)
+            node = node[part]
+        return node
+
+    def getattr(self, path: str, fh=None) -> dict:
+        node = self.lookup(path)
+        times = {'st_atime': self._built, 'st_mtime': self._built, 'st_ctime': self._built}
+        if isinstance(node, dict):
+            return {'st_mode': stat.S_IFDIR | 0o555, 'st_nlink': 2, 'st_size': 0,
+                    'st_uid': self.uid, 'st_gid': self.gid, **times}
+        return {'st_mode': stat.S_IFREG | 0o444, 'st_nlink': 1, 'st_size': len(node),
+                'st_uid': self.uid, 'st_gid': self.gid, **times}
+
+    def readdir(self, path: str, fh=None) -> list[str]:
+        node = self.lookup(path)
+        if not isinstance(node, dict):
+            raise OSError(errno.ENOTDIR, path)
+        return ['.', '..', *sorted(node)]
+
+    def open(self, path: str, flags: int) -> int:
+        if flags & (os.O_WRONLY | os.O_RDWR | os.O_APPEND | os.O_TRUNC):
+            raise OSError(errno.EROFS, path)
+        if isinstance(self.lookup(path), dict):
+            raise OSError(errno.EISDIR, path)
+        return 0
+
+    def read(self, path: str, size: int, offset: int, fh=None) -> bytes:
+        node = self.lookup(path)
+        if isinstance(node, dict):
+            raise OSError(errno.EISDIR, path)
+        return node[offset:offset + size]
+
+    def access(self, path: str, mode: int) -> int:
+        self.lookup(path)
+        if mode & os.W_OK:
+            raise OSError(errno.EROFS, path)
+        return 0
+
+    def opendir(self, path: str) -> int:
+        if not isinstance(self.lookup(path), dict):
+            raise OSError(errno.ENOTDIR, path)
+        return 0
+
+    def release(self, path: str, fh) -> int:
+        return 0
+
+    def releasedir(self, path: str, fh) -> int:
+        return 0
+
+    def init(self, path: str):
+        pass
+
+    def destroy(self, path: str):
+        pass
+
+    def flush(self, path: str, fh) -> int:
+        return 0
+
+    def statfs(self, path: str) -> dict:
+        return {'f_bsize': 4096, 'f_frsize': 4096, 'f_namemax': 255}
+
+
+def mount(mountpoint: str = MOUNTPOINT, files: FileConstants = FILE_NAMES, refresh: float = REFRESH,
+          allow_other: bool = True):
+    """
+    Serves the view on the mountpoint until unmounted or interrupted
+    """
+    try:
+        from fuse import FUSE
+    except (ImportError, OSError) as e:
+        raise RuntimeError(f'Mounting the peripherals view requires fusepy and the FUSE library: {e}') from e
+
+    os.makedirs(mountpoint, exist_ok=True)
+    logger.info(f'Mounting the peripherals view on {mountpoint}')
+    FUSE(PeripheralsView(files, refresh), mountpoint, foreground=True, ro=True, nothreads=True,
+         allow_other=allow_other, fsname='nuvlaedge-peripherals')
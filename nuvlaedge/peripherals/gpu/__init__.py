@@ -9,6 +9,7 @@ It provides:
     - Nvidia Docker Runtime discovery
     - a list of the devices and libraries needed to use a GPU.
     - checks if Docker is the correct version to use --gpus.
+    - the Jetson module, with its JetPack and CUDA versions and its video engines, as a platform peripheral.
 """
 
 import os
@@ -24,6 +25,7 @@ import docker.errors
 
 from nuvlaedge.agent.common.util import compose_project_name
 from nuvlaedge.peripherals.peripheral import Peripheral
+from nuvlaedge.peripherals.gpu.jetson import platform_peripheral
 from nuvlaedge.common.nuvlaedge_config import parse_arguments_and_initialize_logging
 
 # Increase this version to enforce rebuild (usually when Dockerfile.gpu is updated)
@@ -324,7 +326,7 @@ def cuda_cores_information(nv_devices):
     return None, None
 
 
-def flow(**kwargs):
+def gpu_flow(**kwargs):
     runtime = search_runtime(kwargs['runtime'], kwargs['host_files_path'])
 
     runtime_files = {
@@ -384,6 +386,16 @@ def flow(**kwargs):
         return None
 
 
+def flow(**kwargs):
+    peripherals = gpu_flow(**kwargs) or {}
+
+    platform = platform_peripheral(host=HOST_PATH)
+    if platform is not None:
+        peripherals[platform['identifier']] = platform
+
+    return peripherals or None
+
+
 def gpu_check(api_url):
     """ Checks if peripheral already exists """
 
@@ -0,0 +1,176 @@
+"""
+NVIDIA Jetson modules, reported as a platform peripheral
+
+The module is identified from the device tree, the L4T release from /etc/nv_tegra_release, and the JetPack release
+from the nvidia-jetpack package, or from the L4T release when the package is not installed. The CUDA toolkit version
+is read from its version file, and the compute capability follows from the SoC. The video encoder (NVENC) and decoder
+(NVDEC) engines are counted from the device tree, as some modules, such as the Orin Nano, come without NVENC.
+
+All the host files are read below the host root mounted in the container, except the device tree, which the kernel
+exposes to every container.
+"""
+import json
+import logging
+import re
+from pathlib import Path
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+DEVICE_TREE = '/proc/device-tree'
+
+# SoC of the device tree compatible string -> (family, CUDA compute capability)
+SOCS = {
+    'tegra210': ('Nano/TX1', '5.3'),
+    'tegra186': ('TX2', '6.2'),
+    'tegra194': ('Xavier', '7.2'),
+    'tegra234': ('Orin', '8.7'),
+}
+
+# L4T release -> JetPack release, for the hosts without the nvidia-jetpack package
+JETPACK_RELEASES = {
+    '32.7.1': '4.6.1',
+    '32.7.2': '4.6.2',
+    '32.7.3': '4.6.3',
+    '32.7.4': '4.6.4',
+    '32.7.5': '4.6.5',
+    '35.1.0': '5.0.2',
+    '35.2.1': '5.1',
+    '35.3.1': '5.1.1',
+    '35.4.1': '5.1.2',
+    '35.5.0': '5.1.3',
+    '35.6.0': '5.1.4',
+    '36.3.0': '6.0',
+    '36.4.0': '6.1',
+    '36.4.3': '6.2',
+}
+
+# "# R35 (release), REVISION: 4.1, GCID: 33958178, BOARD: t186ref, EABI: aarch64, DATE: Tue Aug  1 19:57:35 UTC 2023"
+_TEGRA_RELEASE = re.compile(r'^#\s*R(\d+)\s*\(release\),\s*REVISION:\s*(\d+)\.(\d+)')
+_ENGINE_NODE = re.compile(r'^(nvenc|nvdec|msenc)\d*(@|$)')
+
+
+def read_text(path: Path) -> str | None:
+    try:
+        return path.read_text().strip('\0\n ')
+    except OSError:
+        return None
+
+
+def read_l4t_release(host: Path) -> str | None:
+    """
+    :return: The L4T release, such as 35.4.1
+    """
+    match = _TEGRA_RELEASE.match(read_text(host / 'etc' / 'nv_tegra_release') or '')
+    if not match:
+        return None
+    return '.'.join(match.groups())
+
+
+def read_jetpack_package(host: Path) -> str | None:
+    """
+    :return: The version of the nvidia-jetpack package, without its build, such as 5.1.2
+    """
+    status = read_text(host / 'var' / 'lib' / 'dpkg' / 'status')
+    if not status:
+        return None
+    for paragraph in status.split('\n\n'):
+        if not re.search(r'^Package: nvidia-jetpack$', paragraph, re.M):
+            continue
+        version = re.search(r'^Version: (\S+)', paragraph, re.M)
+        if version:
+            return version.group(1).split('-')[0]
+    return None
+
+
+def read_cuda_version(host: Path) -> str | None:
+    """
+    :return: The version of the CUDA toolkit, from version.json since CUDA 11, version.txt before
+    """
+    cuda = host / 'usr' / 'local' / 'cuda'
+    try:
+        return json.loads((cuda / 'version.json').read_text())['cuda']['version']
+    except (OSError, ValueError, KeyError, TypeError):
+        pass
+    match = re.search(r'CUDA Version (\S+)', read_text(cuda / 'version.txt') or '')
+    return match.group(1) if match else None
+
+
+def count_engines(device_tree: Path) -> dict[str, int]:
+    """
+    Counts the enabled NVENC and NVDEC engines, the nodes of the device tree without a status or with status okay. The
+    engines are at the root of the device tree, or below the bus@0 node since L4T 35.
+    """
+    engines = {'nvenc': 0, 'nvdec': 0}
+    for node in [*device_tree.glob('*'), *device_tree.glob('*/*')]:
+        match = _ENGINE_NODE.match(node.name)
+        if not match or not node.is_dir():
+            continue
+        status = read_text(node / 'status')
+        if status is not None and status not in ('okay', 'ok'):
+            continue
+        engines['nvdec' if match.group(1) == 'nvdec' else 'nvenc'] += 1
+    return engines
+
+
+def jetson_module(device_tree: str | Path = DEVICE_TREE, host: str | Path = '/') -> dict | None:
+    """
+    :return: The description of the Jetson module, None when not running on one
+    """
+    device_tree, host = Path(device_tree), Path(host)
+    compatible = (read_text(device_tree / 'compatible') or '').split('\0')
+    soc = next((c.split(',')[-1] for c in compatible if c.split(',')[-1] in SOCS), None)
+    if soc is None:
+        return None
+
+    family, compute_capability = SOCS[soc]
+    l4t = read_l4t_release(host)
+    jetpack = read_jetpack_package(host) or JETPACK_RELEASES.get(l4t)
+    return {
+        'model': read_text(device_tree / 'model') or f'NVIDIA Jetson {family}',
+        'serial-number': read_text(device_tree / 'serial-number'),
+        # The carrier board and module part numbers, such as p3768-0000+p3767-0005
+        'part-number': compatible[0].split(',')[-1] if compatible[0].startswith('nvidia,') else None,
+        'soc': soc,
+        'family': family,
+        'l4t': l4t,
+        'jetpack': jetpack,
+        'cuda': read_cuda_version(host),
+        'compute-capability': compute_capability,
+        **count_engines(device_tree)
+    }
+
+
+def platform_peripheral(device_tree: str | Path = DEVICE_TREE, host: str | Path = '/') -> dict | None:
+    """
+    :return: The Jetson module as a Nuvla compliant peripheral, None when not running on one
+    """
+    module = jetson_module(device_tree, host)
+    if module is None:
+        return None
+
+    classes = ['platform', 'gpu', 'cuda']
+    for engine in ('nvenc', 'nvdec'):
+        if module[engine] > 0:
+            classes.append(engine)
+
+    description = f'{module["model"]} ({module["soc"]}), CUDA compute capability {module["compute-capability"]}'
+    if module['jetpack']:
+        description += f', JetPack {module["jetpack"]}'
+    if module['cuda']:
+        description += f', CUDA {module["cuda"]}'
+    description += f', {module["nvenc"]} NVENC and {module["nvdec"]} NVDEC engines'
+
+    peripheral = {
+        'identifier': f'jetson-{module["serial-number"]}' if module['serial-number'] else 'jetson',
+        'available': True,
+        'interface': 'platform',
+        'classes': classes,
+        'name': module['model'],
+        'vendor': 'Nvidia',
+        'product': f'Jetson {module["family"]}',
+        'description': description,
+        'additional-assets': {'jetson': module}
+    }
+    logger.debug(f'Jetson module: {module}')
+    return peripheral
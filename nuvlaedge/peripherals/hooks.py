@@ -0,0 +1,315 @@
+"""
+Hooks run when peripherals are attached to or detached from the NuvlaEdge, or moved to a new device path while a
+container still maps the former one
+
+Rules are read from the hooks file of the peripherals folder, reloaded whenever it changes:
+    [
+        {
+            "name": "vision-app",
+            "event": "attached",
+            "match": {"interface": "USB", "classes": "*Video*"},
+            "deployment": "deployment/4f0b6c4e-..."
+        },
+        {
+            "name": "notify",
+            "event": "detached",
+            "match": {"identifier": "046d:*"},
+            "script": "/opt/hooks/camera-unplugged.sh"
+        },
+        {
+            "name": "payment-terminal",
+            "event": "detached",
+            "match": {"identifier": "0b00:3070*"},
+            "notify": "critical"
+        },
+        {
+            "name": "redeploy-meter",
+            "event": "moved",
+            "match": {"identifier": "1a86:7523"},
+            "deployment": "*"
+        }
+    ]
+
+Match patterns are shell-style wildcards, compared case-insensitively to the peripheral attributes. List attributes,
+like the classes, match when any of their elements does.
+
+A hook either runs a script, given the peripheral in the PERIPHERAL_EVENT, PERIPHERAL_IDENTIFIER and PERIPHERAL_DATA
+environment variables, triggers an operation on a Nuvla deployment: start when attached, stop when detached and update
+when moved, unless the rule sets its own, or creates a Nuvla event of the notify severity on the NuvlaEdge, which the notification
+subscriptions of the operators can page them on. Every execution is logged and recorded in the hook results file.
+
+The moved events are given the container still mapping the former path, with the path, new-path, container and
+deployment attributes. Their deployment hooks can target the deployment of the container with "*".
+"""
+import json
+import logging
+import os
+import queue
+import subprocess
+import threading
+import time
+from concurrent.futures import Future
+from fnmatch import fnmatch
+from pathlib import Path
+from typing import Callable, Literal
+
+from pydantic import ValidationError
+from nuvla.api import Api
+
+from nuvlaedge.common.file_operations import read_file, write_file
+from nuvlaedge.common.nuvlaedge_base_model import NuvlaEdgeBaseModel
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+ATTACHED = 'attached'
+DETACHED = 'detached'
+MOVED = 'moved'
+
+# Deployment of the hooks run on the deployment the event is about
+EVENT_DEPLOYMENT = '*'
+
+# Number of hook executions kept in the results file
+MAX_RESULTS = 100
+OUTPUT_SIZE = 1024
+# Number of hooks running at once, the next ones wait in the queue of the workers
+MAX_WORKERS = 4
+
+
+class HookRule(NuvlaEdgeBaseModel):
+    name: str
+    event: Literal['attached', 'detached', 'moved'] = ATTACHED
+    match: dict[str, str] = {}
+
+    script: str | None = None
+    deployment: str | None = None
+    # Deployment operation, start, stop or update by default depending on the event
+    operation: str | None = None
+    # Severity of the Nuvla event created
+    notify: Literal['critical', 'high', 'medium', 'low'] | None = None
+    timeout: int = 60
+
+    def matches(self, event: str, peripheral: dict) -> bool:
+        if event != self.event:
+            return False
+
+        for attribute, pattern in self.match.items():
+            value = peripheral.get(attribute)
+            values = value if isinstance(value, list) else [value]
+            if not any(v is not None and fnmatch(str(v).lower(), pattern.lower()) for v in values):
+                return False
+        return True
+
+
+class HookResult(NuvlaEdgeBaseModel):
+    hook: str
+    event: str
+    identifier: str
+    success: bool
+    message: str = ''
+    time: str
+
+
+class PeripheralHooks:
+    """
+    Queues the hooks matching the peripheral events to a pool of worker threads, so the scan loop of the peripheral
+    manager never waits on the scripts or on Nuvla
+    """
+
+    def __init__(self,
+                 rules_file: str | Path,
+                 results_file: str | Path,
+                 nuvla_client: Api | None = None,
+                 report: Callable[[str], None] | None = None,
+                 nuvlaedge_id: str | None = None):
+        """
+        :param rules_file: JSON file listing the hook rules
+        :param results_file: JSON file where the last executions are recorded
+        :param nuvla_client: Client triggering the deployment operations and creating the events
+        :param report: Called with a message for every failed hook
+        :param nuvlaedge_id: NuvlaEdge the events are about
+        """
+        self.rules_file: Path = Path(rules_file)
+        self.results_file: Path = Path(results_file)
+        self.nuvla_client: Api | None = nuvla_client
+        self.report: Callable[[str], None] | None = report
+        self.nuvlaedge_id: str | None = nuvlaedge_id
+
+        self._rules: list[HookRule] = []
+        self._rules_mtime: float | None = None
+        self._results_lock: threading.Lock = threading.Lock()
+        # Hooks waiting for a worker, and the daemon threads running them, started with the first hook
+        self._queue: queue.Queue = queue.Queue()
+        self._workers: list[threading.Thread] = []
+
+    @property
+    def rules(self) -> list[HookRule]:
+        try:
+            mtime = self.rules_file.stat().st_mtime
+        except OSError:
+            self._rules, self._rules_mtime = [], None
+            return self._rules
+
+        if mtime != self._rules_mtime:
+            self._rules_mtime = mtime
+            self._rules = self.load_rules()
+        return self._rules
+
+    def load_rules(self) -> list[HookRule]:
+        content = read_file(self.rules_file, decode_json=True, warn_on_missing=False)
+        if not isinstance(content, list):
+            logger.warning(f'Peripheral hooks file {self.rules_file} must hold a list of rules')
+            return []
+
+        rules = []
+        for rule in content:
+            try:
+                rule = HookRule.model_validate(rule)
+            except ValidationError as ex:
+                logger.warning(f'Ignoring invalid peripheral hook {rule}: {ex}')
+                continue
+            if [bool(rule.script), bool(rule.deployment), bool(rule.notify)].count(True) != 1:
+                logger.warning(f'Ignoring peripheral hook {rule.name}, which must either run a script, a deployment '
+                               f'or notify')
+                continue
+            rules.append(rule)
+
+        logger.info(f'Loaded {len(rules)} peripheral hooks from {self.rules_file}')
+        return rules
+
+    def trigger(self, event: str, peripherals: dict) -> list[Future]:
+        """
+        Queues the hooks matching the event for each of the peripherals, and returns without waiting for them
+        :param event: ATTACHED, DETACHED or MOVED
+        :param peripherals: Peripherals, as models or dictionaries, by identifier
+        :return: The futures of the queued hooks
+        """
+        rules = self.rules
+        if not rules:
+            return []
+
+        futures = []
+        for identifier, peripheral in peripherals.items():
+            if hasattr(peripheral, 'model_dump'):
+                peripheral = peripheral.model_dump(by_alias=True, exclude_none=True)
+            peripheral = {'identifier': identifier, **peripheral}
+
+            for rule in rules:
+                if rule.matches(event, peripheral):
+                    future = Future()
+                    self._queue.put((future, rule, event, peripheral))
+                    futures.append(future)
+
+        if futures and not self._workers:
+            for i in range(MAX_WORKERS):
+                worker = threading.Thread(target=self.work, daemon=True, name=f'PeripheralHook-{i}')
+                worker.start()
+                self._workers.append(worker)
+        return futures
+
+    def work(self):
+        """
+        Runs the queued hooks, one at a time
+        """
+        while True:
+            future, rule, event, peripheral = self._queue.get()
+            if not future.set_running_or_notify_cancel():
+                continue
+            try:
+                self.execute(rule, event, peripheral)
+                future.set_result(None)
+            except Exception as ex:
+                future.set_exception(ex)
+
+    def execute(self, rule: HookRule, event: str, peripheral: dict):
+        logger.info(f'Running hook {rule.name} for peripheral {peripheral["identifier"]} {event}')
+        try:
+            if rule.script:
+                message = self.run_script(rule, event, peripheral)
+            elif rule.notify:
+                message = self.create_event(rule, event, peripheral)
+            else:
+                message = self.run_deployment_operation(rule, event, peripheral)
+            success = True
+        except Exception as ex:
+            message = str(ex)
+            success = False
+
+        self.record(HookResult(hook=rule.name,
+                               event=event,
+                               identifier=peripheral['identifier'],
+                               success=success,
+                               message=message[-OUTPUT_SIZE:],
+                               time=time.strftime('%Y-%m-%dT%H:%M:%SZ', time.gmtime())))
+
+    @staticmethod
+    def run_script(rule: HookRule, event: str, peripheral: dict) -> str:
+        env = {
+            **os.environ,
+            'PERIPHERAL_EVENT': event,
+            'PERIPHERAL_IDENTIFIER': peripheral['identifier'],
+            'PERIPHERAL_DATA': json.dumps(peripheral)
+        }
+        try:
+            process = subprocess.run([rule.script], env=env, capture_output=True, text=True, timeout=rule.timeout)
+        except subprocess.TimeoutExpired:
+            raise TimeoutError(f'script {rule.script} timed out after {rule.timeout} seconds')
+
+        output = (process.stdout + process.stderr).strip()
+        if process.returncode != 0:
+            raise RuntimeError(f'script {rule.script} exited with code {process.returncode}: {output}')
+        return output
+
+    def run_deployment_operation(self, rule: HookRule, event: str, peripheral: dict) -> str:
+        if not self.nuvla_client:
+            raise RuntimeError('no Nuvla client to trigger the deployment with')
+
+        deployment_id = rule.deployment
+        if deployment_id == EVENT_DEPLOYMENT:
+            if not peripheral.get('deployment'):
+                raise RuntimeError(f'no deployment for the peripheral {peripheral["identifier"]} {event}')
+            deployment_id = peripheral['deployment']
+            if not deployment_id.startswith('deployment/'):
+                deployment_id = f'deployment/{deployment_id}'
+
+        operation = rule.operation or {ATTACHED: 'start', DETACHED: 'stop', MOVED: 'update'}[event]
+        deployment = self.nuvla_client.get(deployment_id)
+        self.nuvla_client.operation(deployment, operation)
+        return f'{operation} {deployment_id}'
+
+    def create_event(self, rule: HookRule, event: str, peripheral: dict) -> str:
+        if not self.nuvla_client or not self.nuvlaedge_id:
+            raise RuntimeError('no Nuvla client to create the event with')
+
+        name = peripheral.get('name') or peripheral['identifier']
+        state = f'Peripheral {name} ({peripheral["identifier"]}) {event}'
+        if event == MOVED:
+            state += f' from {peripheral.get("path")} to {peripheral.get("new-path")}, still mapped by container ' \
+                     f'{peripheral.get("container")}'
+        response = self.nuvla_client.add('event', {
+            'name': f'Peripheral hook {rule.name}',
+            'category': 'user',
+            'severity': rule.notify,
+            'timestamp': time.strftime('%Y-%m-%dT%H:%M:%S.000Z', time.gmtime()),
+            'content': {
+                'resource': {'href': self.nuvlaedge_id},
+                'state': state
+            }
+        })
+        return f'{rule.notify} event {response.data.get("resource-id")}: {state}'
+
+    def record(self, result: HookResult):
+        if result.success:
+            logger.info(f'Hook {result.hook} succeeded for peripheral {result.identifier} {result.event}')
+        else:
+            logger.warning(f'Hook {result.hook} failed for peripheral {result.identifier} {result.event}: '
+                           f'{result.message}')
+            if self.report:
+                self.report(f'Peripheral hook {result.hook} failed: {result.message}')
+
+        with self._results_lock:
+            results = read_file(self.results_file, decode_json=True, warn_on_missing=False)
+            if not isinstance(results, list):
+                results = []
+            results.append(result.model_dump(by_alias=True))
+            write_file(results[-MAX_RESULTS:], self.results_file, indent=4)
@@ -0,0 +1,283 @@
+#!/usr/bin/env python3
+# -*- coding: utf-8 -*-
+
+"""NuvlaEdge Peripheral Manager Legacy I/O
+
+This service discovers the legacy I/O hardware the brownfield factory edges still depend on, which is not USB and
+thus not reported by the USB manager:
+    - the parallel ports, from /proc/sys/dev/parport, with their base address, IRQ, modes and the IEEE 1284 ID of the
+      device attached, when the port could probe it
+    - the PCI industrial I/O cards of Advantech and Moxa, multiport serial boards and data acquisition cards, with the
+      device nodes their drivers create: serial ports, comedi, UIO and GPIO chips
+
+Each card is named from the pci.ids database, when the container has one. LEGACY_IO_PCI_VENDORS adds the PCI vendor
+IDs of other industrial I/O makers, as 4 hexadecimal digits.
+
+All these settings can also be set in the legacy-io section of the peripherals configuration file, see config_file.
+
+"""
+
+import logging
+import os
+import re
+from pathlib import Path
+
+from nuvlaedge.peripherals import config_file
+
+# The settings of the configuration file are exported before they are read
+config_file.load_section('legacy-io')
+
+from nuvlaedge.peripherals.peripheral import Peripheral
+from nuvlaedge.common.nuvlaedge_config import parse_arguments_and_initialize_logging
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+SCAN_INTERVAL = int(os.getenv('LEGACY_IO_SCAN_INTERVAL', '60'))
+EXTRA_PCI_VENDORS = [v.strip().lower() for v in os.getenv('LEGACY_IO_PCI_VENDORS', '').split(',') if v.strip()]
+
+PROC_PARPORT = '/proc/sys/dev/parport'
+SYSFS_PCI_DEVICES = '/sys/bus/pci/devices'
+DEV = '/dev'
+PCI_IDS = ['/usr/share/misc/pci.ids', '/usr/share/hwdata/pci.ids']
+
+# PCI vendor IDs of the industrial I/O makers
+INDUSTRIAL_VENDORS = {
+    '13fe': 'Advantech',
+    '1393': 'Moxa',
+}
+
+# Classes of the device nodes a PCI card driver creates, and the peripheral class they make
+NODE_CLASSES = {
+    'tty': 'serial',
+    'comedi': 'data-acquisition',
+    'uio': 'io',
+}
+
+_PARPORT = re.compile(r'^parport\d+$')
+_PCI_ADDRESS = re.compile(r'^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$')
+
+
+def read_text(path: str | Path) -> str | None:
+    try:
+        return Path(path).read_text().strip('\0\n ')
+    except OSError:
+        return None
+
+
+def parse_ieee1284_id(device_id: str) -> dict[str, str]:
+    """
+    Parses the IEEE 1284 device ID a parallel port reads from the device, "MFG:Hewlett-Packard;MDL:LaserJet 4;", with
+    the long keys of /proc/sys/dev/parport/*/autoprobe as well
+    :return: The manufacturer, model and class, when given
+    """
+    keys = {'mfg': 'manufacturer', 'manufacturer': 'manufacturer', 'mdl': 'model', 'model': 'model',
+            'cls': 'class', 'class': 'class', 'des': 'description', 'description': 'description'}
+    fields = {}
+    for field in re.split(r'[;\n]', device_id):
+        key, sep, value = field.partition(':')
+        key = keys.get(key.strip().lower())
+        if sep and key and value.strip():
+            fields[key] = value.strip()
+    return fields
+
+
+def parallel_ports(proc_dir: str | Path = PROC_PARPORT, dev_dir: str | Path = DEV) -> list[dict]:
+    """
+    Lists the parallel ports the kernel registered, with their device nodes: the ppdev one, /dev/parportN, and the
+    one of the printer driver, /dev/lpN
+    """
+    proc_dir = Path(proc_dir)
+    if not proc_dir.is_dir():
+        return []
+
+    ports = []
+    for port in sorted(proc_dir.iterdir(), key=lambda p: int(re.sub(r'\D', '', p.name) or 0)):
+        if not _PARPORT.match(port.name):
+            continue
+        number = port.name[len('parport'):]
+        nodes = [str(Path(dev_dir) / name) for name in (port.name, f'lp{number}') if (Path(dev_dir) / name).exists()]
+        base = (read_text(port / 'base-addr') or '').split()
+        ports.append({
+            'name': port.name,
+            'base-address': f'0x{int(base[0]):x}' if base and base[0].isdigit() else None,
+            'irq': read_text(port / 'irq'),
+            'dma': read_text(port / 'dma'),
+            'modes': [m for m in (read_text(port / 'modes') or '').split(',') if m],
+            'device': parse_ieee1284_id(read_text(port / 'autoprobe') or ''),
+            'device-paths': nodes,
+        })
+    return ports
+
+
+def load_pci_names(paths: list[str] = None) -> dict[str, str]:
+    """
+    Loads the vendor and device names of the industrial I/O makers from the first pci.ids database found
+    :return: The names, by vendor ID and by vendor:device ID
+    """
+    vendors = set(INDUSTRIAL_VENDORS) | set(EXTRA_PCI_VENDORS)
+    for path in paths if paths is not None else PCI_IDS:
+        try:
+            lines = Path(path).read_text(errors='replace').splitlines()
+        except OSError:
+            continue
+        names = {}
+        vendor = None
+        for line in lines:
+            if not line or line.startswith('#'):
+                continue
+            if not line.startswith('\t'):
+                vendor_id, _, name = line.partition(' ')
+                vendor = vendor_id.lower() if vendor_id.lower() in vendors else None
+                if vendor:
+                    names[vendor] = name.strip()
+            elif vendor and not line.startswith('\t\t'):
+                device_id, _, name = line.strip().partition(' ')
+                names[f'{vendor}:{device_id.lower()}'] = name.strip()
+        return names
+    return {}
+
+
+def card_nodes(device: Path, dev_dir: str | Path = DEV) -> dict[str, list[str]]:
+    """
+    Lists the device nodes the driver of a PCI card created, by peripheral class
+    """
+    nodes: dict[str, list[str]] = {}
+    for node_class, peripheral_class in NODE_CLASSES.items():
+        # comedi and uio nodes are classes of the card, the serial ports hang off a port device on recent kernels.
+        # The depth is bounded, sysfs links back to the parents.
+        found = [node for pattern in (f'{node_class}/*', f'*/{node_class}/*', f'*/*/{node_class}/*')
+                 for node in device.glob(pattern)]
+        for node in sorted(found, key=lambda n: n.name):
+            nodes.setdefault(peripheral_class, []).append(str(Path(dev_dir) / node.name))
+    for chip in sorted(device.glob('gpiochip*')):
+        nodes.setdefault('gpio', []).append(str(Path(dev_dir) / chip.name))
+    return nodes
+
+
+def pci_cards(sysfs_dir: str | Path = SYSFS_PCI_DEVICES, dev_dir: str | Path = DEV,
+              names: dict[str, str] = None) -> list[dict]:
+    """
+    Lists the PCI cards of the industrial I/O makers
+    """
+    sysfs_dir = Path(sysfs_dir)
+    if not sysfs_dir.is_dir():
+        return []
+    vendors = set(INDUSTRIAL_VENDORS) | set(EXTRA_PCI_VENDORS)
+    names = names if names is not None else load_pci_names()
+
+    cards = []
+    for device in sorted(sysfs_dir.iterdir()):
+        if not _PCI_ADDRESS.match(device.name):
+            continue
+        vendor = (read_text(device / 'vendor') or '').lower().replace('0x', '')
+        if vendor not in vendors:
+            continue
+        product = (read_text(device / 'device') or '').lower().replace('0x', '')
+        driver = device / 'driver'
+        cards.append({
+            'address': device.name,
+            'vendor-id': vendor,
+            'product-id': product,
+            'vendor': INDUSTRIAL_VENDORS.get(vendor) or names.get(vendor),
+            'product': names.get(f'{vendor}:{product}'),
+            'driver': os.path.basename(os.readlink(driver)) if driver.is_symlink() else None,
+            'nodes': card_nodes(device, dev_dir),
+        })
+    return cards
+
+
+def format_parallel_port(port: dict) -> dict:
+    """
+    Formats a parallel port into a Nuvla compliant peripheral
+    """
+    device = port['device']
+    description = f'Parallel port {port["name"]}'
+    if port['base-address']:
+        description += f' at {port["base-address"]}'
+    if device.get('model'):
+        description += f', {" ".join(filter(None, [device.get("manufacturer"), device["model"]]))} attached'
+
+    parallel = {k: v for k, v in port.items() if v and k not in ('name', 'device-paths')}
+    peripheral = {
+        'identifier': port['name'],
+        # ppdev or the printer driver must expose the port to the container
+        'available': bool(port['device-paths']),
+        'interface': 'Parallel',
+        'classes': ['parallel'] + (['printer'] if device.get('class', '').upper() == 'PRINTER' else []),
+        'name': device.get('model') or 'Parallel port',
+        'description': description,
+        'additional-assets': {'parallel': parallel}
+    }
+    if device.get('manufacturer'):
+        peripheral['vendor'] = device['manufacturer']
+    if port['device-paths']:
+        peripheral['device-path'] = port['device-paths'][0]
+        peripheral['device-paths'] = port['device-paths']
+    return peripheral
+
+
+def format_pci_card(card: dict) -> dict:
+    """
+    Formats a PCI industrial I/O card into a Nuvla compliant peripheral
+    """
+    vendor = card['vendor'] or card['vendor-id']
+    name = card['product'] or f'{vendor} PCI card {card["product-id"]}'
+    paths = [path for class_paths in card['nodes'].values() for path in class_paths]
+    description = f'{vendor} {name} at PCI {card["address"]}'
+    if card['driver']:
+        description += f', driver {card["driver"]}'
+    else:
+        description += ', no driver bound'
+
+    peripheral = {
+        'identifier': f'pci-{card["address"]}',
+        'available': bool(paths) and all(Path(path).exists() for path in paths),
+        'interface': 'PCI',
+        'classes': sorted(card['nodes']) or ['io'],
+        'name': name,
+        'description': description,
+        'vendor-id': card['vendor-id'],
+        'product-id': card['product-id'],
+        'vendor': vendor,
+        'additional-assets': {'pci': {k: card[k] for k in ('address', 'driver', 'nodes') if card[k]}}
+    }
+    if card['product']:
+        peripheral['product'] = card['product']
+    if paths:
+        peripheral['device-path'] = paths[0]
+        peripheral['device-paths'] = paths
+    return peripheral
+
+
+def flow(proc_dir: str = PROC_PARPORT, sysfs_dir: str = SYSFS_PCI_DEVICES, dev_dir: str = DEV) -> dict[str, dict]:
+    """
+    :return: The parallel ports and industrial I/O cards of the host, as peripherals by identifier
+    """
+    peripherals = {}
+    for port in parallel_ports(proc_dir, dev_dir):
+        peripheral = format_parallel_port(port)
+        peripherals[peripheral['identifier']] = peripheral
+    for card in pci_cards(sysfs_dir, dev_dir):
+        peripheral = format_pci_card(card)
+        peripherals[peripheral['identifier']] = peripheral
+    return peripherals
+
+
+def main():
+    global logger
+    parse_arguments_and_initialize_logging('Legacy I/O Peripheral')
+
+    logger = logging.getLogger(__name__)
+    logger.info('LEGACY I/O PERIPHERAL MANAGER STARTED')
+
+    legacy_io_peripheral: Peripheral = Peripheral('legacy-io', scanning_interval=SCAN_INTERVAL)
+    legacy_io_peripheral.run(flow)
+
+
+def entry():
+    main()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,91 @@
+"""
+Metadata set on the peripheral resources in Nuvla, written back locally for the edge applications
+
+Operators describe peripherals in Nuvla with their name, description and tags. Each registered peripheral gets a JSON
+file in the metadata folder, named after its identifier, rewritten whenever Nuvla changes it:
+    {
+        "identifier": "046d:0825",
+        "id": "nuvlabox-peripheral/4f0b6c4e-...",
+        "name": "Entrance camera",
+        "description": "Mounted above the main door",
+        "tags": ["role:entrance-camera", "building-a"],
+        "roles": ["entrance-camera"],
+        "updated": "2024-05-01T12:00:00.000Z"
+    }
+
+Tags prefixed with "role:" are also listed as roles, so applications can look up the peripherals by the role a human
+assigned them. The files of the peripherals removed from Nuvla are deleted.
+"""
+import json
+import logging
+import re
+from pathlib import Path
+
+from nuvlaedge.common.file_operations import write_file
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+ROLE_PREFIX = 'role:'
+
+# Characters kept in the metadata file names, the others are replaced
+UNSAFE_CHARACTERS = re.compile(r'[^A-Za-z0-9._:-]')
+
+
+def metadata_file_name(identifier: str) -> str:
+    return UNSAFE_CHARACTERS.sub('_', identifier) + '.json'
+
+
+def peripheral_metadata(identifier: str, resource) -> dict:
+    """
+    :param identifier: Identifier of the peripheral
+    :param resource: Peripheral resource, as registered in Nuvla
+    :return: The metadata written locally
+    """
+    tags = list(resource.tags or [])
+    return {
+        'identifier': identifier,
+        'id': resource.id,
+        'name': resource.name,
+        'description': resource.description,
+        'tags': tags,
+        'roles': [tag[len(ROLE_PREFIX):] for tag in tags if tag.startswith(ROLE_PREFIX)],
+        'updated': resource.updated
+    }
+
+
+class PeripheralMetadataWriter:
+    """
+    Keeps the metadata folder in line with the peripheral resources of Nuvla
+    """
+
+    def __init__(self, folder: str | Path):
+        self.folder: Path = Path(folder)
+
+    def sync(self, resources: dict):
+        """
+        Writes the metadata of the resources whose metadata changed, and deletes the metadata of the others
+        :param resources: Peripheral resources registered in Nuvla, by identifier
+        """
+        self.folder.mkdir(parents=True, exist_ok=True)
+
+        expected = set()
+        for identifier, resource in resources.items():
+            file = self.folder / metadata_file_name(identifier)
+            expected.add(file.name)
+            metadata = peripheral_metadata(identifier, resource)
+            if self.read(file) != metadata:
+                logger.info(f'Writing the Nuvla metadata of peripheral {identifier} to {file}')
+                write_file(metadata, file, indent=4)
+
+        for file in self.folder.glob('*.json'):
+            if file.name not in expected:
+                logger.info(f'Removing the metadata of unregistered peripheral {file.stem}')
+                file.unlink(missing_ok=True)
+
+    @staticmethod
+    def read(file: Path) -> dict | None:
+        try:
+            return json.loads(file.read_text())
+        except (OSError, ValueError):
+            return None
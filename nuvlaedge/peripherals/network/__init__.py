@@ -5,16 +5,53 @@
 
 This service provides network devices discovery.
 
+Discovery is dual-stack: mDNS and SSDP are also queried over their IPv6 link-local multicast groups (ff02::fb and
+ff02::c), unless NETWORK_DISCOVERY_IPV6 is set to false.
+
+Setting NETWORK_SUBNET_SWEEP to true also sweeps the local subnets for hosts not announcing themselves, and
+NETWORK_SERVICE_DETECTION to true classifies them by their responding services.
+
+The OPC UA servers announced over mDNS, registered to the discovery servers of NETWORK_OPCUA_DISCOVERY_URLS or found
+by the sweep are reported with their endpoints and security policies, unless NETWORK_OPCUA_DISCOVERY is set to false.
+
+Setting NETWORK_BACNET_DISCOVERY to true also broadcasts a BACnet/IP Who-Is, reporting the building automation devices
+answering with their instance, vendor and number of objects.
+
+Setting NETWORK_RESTREAM_API to the control API of a media server, as mediamtx, registers the RTSP streams of the
+cameras found there, and reports the proxied URLs of the cameras with their stream.
+
+The local Wi-Fi adapters are reported as well, with their bands and monitor and access point capabilities, unless
+NETWORK_WIRELESS_ADAPTERS is set to false.
+
+Setting NETWORK_LEADER_LOCK to a file of a volume shared by the nodes of a highly available NuvlaEdge elects a single
+instance to scan the LAN, the others standing by, see leader.
+
+All these settings can also be set in the network section of the peripherals configuration file, see config_file.
+
 """
 
 import base64
 import logging
 import os
 import re
+import signal
+import sys
 import requests
 import xmltodict
 
+from nuvlaedge.peripherals import config_file
+
+# The settings of the configuration file are exported before the modules reading them are imported
+config_file.load_section('network')
+
 from nuvlaedge.peripherals.peripheral import Peripheral
+from nuvlaedge.peripherals.network.bacnet import BACNET_DISCOVERY, BACnetDiscovery
+from nuvlaedge.peripherals.network.leader import LEADER_LOCK, LeaderElection
+from nuvlaedge.peripherals.network.opcua import OPCUA_DISCOVERY, OPCUADiscovery, mdns_urls, swept_urls
+from nuvlaedge.peripherals.network.restream import RESTREAM_API, Restreamer
+from nuvlaedge.peripherals.network.services import SERVICE_DETECTION, ServiceDetector
+from nuvlaedge.peripherals.network.sweep import SUBNET_SWEEP, SubnetSweeper
+from nuvlaedge.peripherals.network.wireless import WIRELESS_ADAPTERS, wireless_adapters
 from nuvlaedge.common.nuvlaedge_config import parse_arguments_and_initialize_logging
 
 # Packages for Service Discovery
@@ -22,13 +59,14 @@ from ssdpy import SSDPClient
 from xml.dom import minidom
 from urllib.parse import urlparse
 from wsdiscovery.discovery import ThreadedWSDiscovery as WSDiscovery
-from zeroconf import ZeroconfServiceTypes, ServiceBrowser, Zeroconf
+from zeroconf import IPVersion, ZeroconfServiceTypes, ServiceBrowser, Zeroconf
 
 
 logger: logging.Logger = logging.getLogger(__name__)
 
 KUBERNETES_SERVICE_HOST = os.getenv('KUBERNETES_SERVICE_HOST')
 namespace = os.getenv('MY_NAMESPACE', 'nuvlaedge')
+IPV6_DISCOVERY = os.getenv('NETWORK_DISCOVERY_IPV6', 'true').lower() not in ['false', '0', 'no']
 
 
 def get_ssdp_device_xml_as_json(url):
@@ -59,13 +97,28 @@ def get_ssdp_device_xml_as_json(url):
         return {}
 
 
+def ssdp_search():
+    """
+    Searches SSDP devices over IPv4 and, when enabled, over the IPv6 link-local multicast group. Hosts without IPv6
+    only report the IPv4 devices.
+    """
+    devices = SSDPClient().m_search("ssdp:all")
+
+    if IPV6_DISCOVERY:
+        try:
+            devices += SSDPClient(proto="ipv6").m_search("ssdp:all")
+        except OSError as ex:
+            logger.debug(f'SSDP discovery over IPv6 unavailable: {ex}')
+
+    return devices
+
+
 def ssdp_manager():
     """
     Manages SSDP discoverable devices (SSDP and UPnP devices)
     """
 
-    client = SSDPClient()
-    devices = client.m_search("ssdp:all")
+    devices = ssdp_search()
     output = {
         'peripherals': {},
         'xml': {}
@@ -223,6 +276,7 @@ def format_zeroconf_services(services):
                 output[identifier]['classes'].append(service_data.type)
 
             if service_data.parsed_addresses() and 'device-path' not in output[identifier]:
+                # Dual-stack services list their IPv4 addresses first. IPv6-only ones are reached over IPv6
                 output[identifier]['device-path'] = service_data.parsed_addresses()[0]
 
             if service_name not in output[identifier]['description']:
@@ -272,7 +326,8 @@ def parse_zeroconf_devices(zc, listener):
     :return: list of peripheral documents
     """
 
-    service_types_available = set(ZeroconfServiceTypes.find())
+    # Browsing with the manager instance queries the same address families it listens to
+    service_types_available = set(ZeroconfServiceTypes.find(zc=zc))
 
     old_service_types = set(listener.listening_to) - service_types_available
     new_service_types = service_types_available - set(listener.listening_to)
@@ -292,21 +347,48 @@ def parse_zeroconf_devices(zc, listener):
 
 def network_manager(**kwargs):
     """
-    Runs and manages the outputs from the discovery.
+    Runs and manages the outputs from the discovery. Instances standing by for the leader neither scan nor report.
     """
     output = {}
 
+    leader = kwargs.get('leader')
+    if leader and not leader.elect():
+        return output
+
     if kwargs['zc_obj']:
         zeroconf_output = parse_zeroconf_devices(kwargs['zc_obj'], kwargs['zc_listener'])
     else:
         zeroconf_output = {}
 
+    # Hosts found by the subnet sweep are the least described ones, discovery protocols prevail
+    if kwargs.get('sweeper'):
+        output.update(kwargs['sweeper'].sweep())
+
     ssdp_output = ssdp_manager()
     ws_discovery_output = ws_discovery_manager(kwargs['wsdaemon'])
     output.update(ssdp_output)
     output.update(ws_discovery_output)
     output.update(zeroconf_output)
 
+    if kwargs.get('opcua'):
+        candidates = swept_urls(output)
+        if kwargs['zc_listener']:
+            candidates = mdns_urls(kwargs['zc_listener'].all_info) + candidates
+        output.update(kwargs['opcua'].discover(candidates))
+
+    if kwargs.get('bacnet'):
+        output.update(kwargs['bacnet'].discover())
+
+    if kwargs.get('wireless'):
+        output.update(wireless_adapters())
+
+    if kwargs.get('restreamer'):
+        kwargs['restreamer'].register(output)
+
+    # The lease may have been taken over during a scan longer than its TTL
+    if leader and not leader.elect():
+        return {}
+
     return output
 
 
@@ -319,7 +401,13 @@ def main():
 
     network_peripheral: Peripheral = Peripheral('network')
     try:
-        zeroconf = Zeroconf()
+        zeroconf = Zeroconf(ip_version=IPVersion.All if IPV6_DISCOVERY else IPVersion.V4Only)
+    except OSError as ex:
+        logger.warning(f'Zeroconf failed to start in dual-stack mode, falling back to IPv4: {str(ex)}')
+        zeroconf = None
+
+    try:
+        zeroconf = zeroconf or Zeroconf()
     except OSError as ex:
         logger.error(f'Zeroconf failed to start and cannot be fixed without a restart: {str(ex)}')
         zeroconf = zeroconf_listener = None
@@ -327,8 +415,28 @@ def main():
         zeroconf_listener = ZeroConfListener()
 
     ws_daemon = WSDiscovery()
+    sweeper = None
+    if SUBNET_SWEEP:
+        sweeper = SubnetSweeper(detector=ServiceDetector() if SERVICE_DETECTION else None)
 
-    network_peripheral.run(network_manager, zc_obj=zeroconf, zc_listener=zeroconf_listener, wsdaemon=ws_daemon)
+    opcua = OPCUADiscovery() if OPCUA_DISCOVERY else None
+    bacnet = BACnetDiscovery() if BACNET_DISCOVERY else None
+    restreamer = Restreamer() if RESTREAM_API else None
+
+    leader = None
+    if LEADER_LOCK:
+        leader = LeaderElection()
+        logger.info(f'Electing the network discovery leader as {leader.identity} with the lease {LEADER_LOCK}')
+        # Stopping the container releases the lease, for an instance standing by to take over
+        signal.signal(signal.SIGTERM, lambda signum, frame: sys.exit(0))
+
+    try:
+        network_peripheral.run(network_manager, zc_obj=zeroconf, zc_listener=zeroconf_listener, wsdaemon=ws_daemon,
+                               sweeper=sweeper, opcua=opcua, bacnet=bacnet, wireless=WIRELESS_ADAPTERS,
+                               restreamer=restreamer, leader=leader)
+    finally:
+        if leader:
+            leader.release()
 
 
 def entry():
@@ -0,0 +1,429 @@
+"""
+Opt-in discovery of the BACnet/IP devices of building networks
+
+A Who-Is is broadcast on the local subnets, including to the remote BACnet networks behind the routers, and the devices
+answering with an I-Am are asked for their name, vendor, model and number of objects with ReadProperty requests. HVAC
+controllers, sensors and the other building automation devices are so reported with their device instance.
+
+Most devices broadcast their I-Am, which is only received when the BACnet port is free on the NuvlaEdge. When another
+BACnet application holds it, the discovery listens on another port and only gets the I-Am sent back to it.
+
+The discovery is disabled unless NETWORK_BACNET_DISCOVERY is set to true, and runs at most every
+NETWORK_BACNET_INTERVAL seconds. The hosts of NETWORK_PROBE_EXCLUDE are not sent ReadProperty requests.
+"""
+import ipaddress
+import logging
+import os
+import socket
+import struct
+import time
+
+import psutil
+
+from nuvlaedge.peripherals.network.services import parse_exclusions
+from nuvlaedge.peripherals.network.sweep import IGNORED_INTERFACES
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+BACNET_DISCOVERY = os.getenv('NETWORK_BACNET_DISCOVERY', 'false').lower() in ['true', '1', 'yes']
+BACNET_INTERVAL = int(os.getenv('NETWORK_BACNET_INTERVAL', 600))
+BACNET_TIMEOUT = float(os.getenv('NETWORK_BACNET_TIMEOUT', 3))
+BACNET_PORT = 47808
+
+# BACnet Virtual Link Control functions
+BVLC_TYPE = 0x81
+BVLC_ORIGINAL_UNICAST = 0x0a
+BVLC_ORIGINAL_BROADCAST = 0x0b
+BVLC_FORWARDED = 0x04
+
+# APDU types and services
+PDU_CONFIRMED_REQUEST = 0x0
+PDU_UNCONFIRMED_REQUEST = 0x1
+PDU_COMPLEX_ACK = 0x3
+PDU_ERROR = 0x5
+PDU_REJECT = 0x6
+PDU_ABORT = 0x7
+SERVICE_I_AM = 0x00
+SERVICE_WHO_IS = 0x08
+SERVICE_READ_PROPERTY = 0x0c
+
+OBJECT_DEVICE = 8
+PROPERTY_MODEL_NAME = 70
+PROPERTY_OBJECT_LIST = 76
+PROPERTY_OBJECT_NAME = 77
+PROPERTY_VENDOR_NAME = 121
+
+SEGMENTATION = {0: 'both', 1: 'transmit', 2: 'receive', 3: 'none'}
+
+# Vendors of common building automation equipment, used when a device does not tell its vendor name
+KNOWN_VENDORS = {
+    2: 'Trane',
+    5: 'Johnson Controls',
+    7: 'Siemens',
+    8: 'Delta Controls',
+    10: 'Schneider Electric',
+    24: 'Automated Logic',
+}
+
+
+class BACnetError(Exception):
+    ...
+
+
+def bvlc(function: int, npdu: bytes) -> bytes:
+    return struct.pack('>BBH', BVLC_TYPE, function, 4 + len(npdu)) + npdu
+
+
+def npdu(apdu: bytes, network: int | None = None, mac: bytes = b'', expecting_reply: bool = False) -> bytes:
+    """
+    Wraps an APDU, for a device behind a router when given its network and MAC address. Network 0xFFFF with an empty
+    MAC address is a global broadcast
+    """
+    control = 0x04 if expecting_reply else 0x00
+    if network is None:
+        return bytes([0x01, control]) + apdu
+    return bytes([0x01, control | 0x20]) + struct.pack('>HB', network, len(mac)) + mac + b'\xff' + apdu
+
+
+def who_is() -> bytes:
+    return bvlc(BVLC_ORIGINAL_BROADCAST, npdu(bytes([PDU_UNCONFIRMED_REQUEST << 4, SERVICE_WHO_IS]), network=0xffff))
+
+
+def read_property(invoke_id: int, instance: int, property_id: int, array_index: int | None = None,
+                  network: int | None = None, mac: bytes = b'') -> bytes:
+    object_id = (OBJECT_DEVICE << 22) | instance
+    # Segmented responses are not accepted, up to 1476 bytes
+    apdu = bytes([PDU_CONFIRMED_REQUEST << 4, 0x05, invoke_id, SERVICE_READ_PROPERTY])
+    apdu += b'\x0c' + struct.pack('>I', object_id)
+    apdu += bytes([0x19, property_id]) if property_id < 256 else b'\x1a' + struct.pack('>H', property_id)
+    if array_index is not None:
+        apdu += bytes([0x29, array_index])
+    return bvlc(BVLC_ORIGINAL_UNICAST, npdu(apdu, network, mac, expecting_reply=True))
+
+
+class Tag:
+    def __init__(self, number: int, context: bool, value: bytes | None, opening: bool = False, closing: bool = False):
+        self.number = number
+        self.context = context
+        self.value = value
+        self.opening = opening
+        self.closing = closing
+
+
+def read_tag(data: bytes, offset: int) -> tuple[Tag, int]:
+    """
+    Decodes the tag at offset
+    :return: The tag and the offset of the next one
+    """
+    def take(size: int) -> bytes:
+        nonlocal offset
+        if offset + size > len(data):
+            raise BACnetError('truncated tag')
+        value = data[offset:offset + size]
+        offset += size
+        return value
+
+    header = take(1)[0]
+    number, context, length = header >> 4, bool(header & 0x08), header & 0x07
+    if number == 15:
+        number = take(1)[0]
+    if context and length == 6:
+        return Tag(number, True, None, opening=True), offset
+    if context and length == 7:
+        return Tag(number, True, None, closing=True), offset
+    if not context and number == 1:
+        # Booleans hold their value in the length field
+        return Tag(number, False, bytes([length])), offset
+    if length == 5:
+        length = take(1)[0]
+        if length == 254:
+            length = struct.unpack('>H', take(2))[0]
+        elif length == 255:
+            length = struct.unpack('>I', take(4))[0]
+    return Tag(number, context, take(length)), offset
+
+
+def decode_unsigned(value: bytes) -> int:
+    return int.from_bytes(value, 'big')
+
+
+def decode_string(value: bytes) -> str:
+    """
+    Decodes a character string, UTF-8 or ISO 8859-1 as told by its first byte
+    """
+    if not value:
+        return ''
+    encoding = {0: 'utf-8', 5: 'latin-1'}.get(value[0], 'utf-8')
+    return value[1:].decode(encoding, errors='replace').strip('\0 ')
+
+
+def parse_bvlc(data: bytes) -> tuple[bytes, str | None]:
+    """
+    :return: The NPDU of a BACnet/IP message, and the address of the device it was forwarded for by a BBMD
+    """
+    if len(data) < 4 or data[0] != BVLC_TYPE:
+        raise BACnetError('not a BACnet/IP message')
+    function, length = data[1], struct.unpack('>H', data[2:4])[0]
+    if length != len(data):
+        raise BACnetError('invalid BVLC length')
+    if function == BVLC_FORWARDED:
+        if len(data) < 10:
+            raise BACnetError('truncated forwarded NPDU')
+        return data[10:], f'{socket.inet_ntoa(data[4:8])}:{struct.unpack(">H", data[8:10])[0]}'
+    return data[4:], None
+
+
+def parse_npdu(data: bytes) -> tuple[bytes | None, int | None, bytes]:
+    """
+    :return: The APDU, None for network layer messages, with the network and MAC address of the source device when
+    behind a router
+    """
+    if len(data) < 2 or data[0] != 0x01:
+        raise BACnetError('unsupported NPDU version')
+    control, offset = data[1], 2
+    network, mac = None, b''
+    if control & 0x20:
+        dlen = data[offset + 2]
+        offset += 3 + dlen
+    if control & 0x08:
+        network, slen = struct.unpack('>HB', data[offset:offset + 3])
+        mac = data[offset + 3:offset + 3 + slen]
+        offset += 3 + slen
+    if control & 0x20:
+        offset += 1                                 # Hop count
+    if control & 0x80:
+        return None, network, mac
+    return data[offset:], network, mac
+
+
+def parse_i_am(apdu: bytes) -> dict | None:
+    """
+    :return: The device instance, maximum APDU length, segmentation and vendor id of an I-Am, None for other APDUs
+    """
+    if len(apdu) < 2 or apdu[0] >> 4 != PDU_UNCONFIRMED_REQUEST or apdu[1] != SERVICE_I_AM:
+        return None
+    values = []
+    offset = 2
+    while offset < len(apdu) and len(values) < 4:
+        tag, offset = read_tag(apdu, offset)
+        values.append(tag)
+    if len(values) < 4 or values[0].number != 12 or len(values[0].value) != 4:
+        raise BACnetError('invalid I-Am')
+    object_id = decode_unsigned(values[0].value)
+    if object_id >> 22 != OBJECT_DEVICE:
+        raise BACnetError('I-Am of an object other than a device')
+    return {
+        'instance': object_id & 0x3fffff,
+        'max-apdu': decode_unsigned(values[1].value),
+        'segmentation': SEGMENTATION.get(decode_unsigned(values[2].value), 'unknown'),
+        'vendor-id': decode_unsigned(values[3].value),
+    }
+
+
+def parse_read_property_ack(apdu: bytes, invoke_id: int) -> Tag | None:
+    """
+    :return: The first value of a ReadProperty acknowledge, None when the device answered with an error
+    :raises BACnetError: when the APDU is not the answer to the request
+    """
+    if len(apdu) < 3:
+        raise BACnetError('truncated APDU')
+    pdu_type = apdu[0] >> 4
+    if pdu_type == PDU_COMPLEX_ACK:
+        if apdu[1] != invoke_id or apdu[2] != SERVICE_READ_PROPERTY:
+            raise BACnetError('unexpected acknowledge')
+        offset = 3
+        while offset < len(apdu):
+            tag, offset = read_tag(apdu, offset)
+            if tag.opening and tag.number == 3:
+                value, _ = read_tag(apdu, offset)
+                return value
+        raise BACnetError('acknowledge without value')
+    if pdu_type in (PDU_ERROR, PDU_REJECT, PDU_ABORT) and apdu[1] == invoke_id:
+        return None
+    raise BACnetError('unexpected APDU')
+
+
+def broadcast_addresses() -> list[str]:
+    """
+    :return: The broadcast addresses of the IPv4 subnets of the physical interfaces
+    """
+    addresses = []
+    for name, interface_addresses in psutil.net_if_addrs().items():
+        if name.startswith(IGNORED_INTERFACES):
+            continue
+        for address in interface_addresses:
+            if address.family != socket.AF_INET or not address.netmask:
+                continue
+            network = ipaddress.IPv4Interface(f'{address.address}/{address.netmask}').network
+            if network.prefixlen < 31:
+                addresses.append(str(network.broadcast_address))
+    return addresses
+
+
+def format_device(device: dict) -> dict:
+    """
+    Formats a BACnet device into a Nuvla compliant peripheral
+    """
+    instance = device['instance']
+    vendor = device.get('vendor-name') or KNOWN_VENDORS.get(device['vendor-id'])
+    name = device.get('object-name') or f'BACnet device {instance}'
+    description = f'BACnet device {instance} at {device["address"]}'
+    if device.get('network') is not None:
+        description += f', network {device["network"]} address {device["mac"]}'
+    if device.get('object-count') is not None:
+        description += f', {device["object-count"]} objects'
+
+    bacnet = {key: device.get(key) for key in ('instance', 'vendor-id', 'max-apdu', 'segmentation', 'network', 'mac',
+                                               'object-count')}
+    peripheral = {
+        'identifier': f'bacnet-{instance}',
+        'available': True,
+        'interface': 'BACnet/IP',
+        'classes': ['bacnet'],
+        'name': name,
+        'description': description,
+        'device-path': device['address'],
+        'additional-assets': {'bacnet': bacnet}
+    }
+    if vendor:
+        peripheral['vendor'] = vendor
+    if device.get('model-name'):
+        peripheral['product'] = device['model-name']
+    return peripheral
+
+
+class BACnetDiscovery:
+    """
+    Broadcasts a Who-Is every interval, reporting the last inventory in between
+    """
+
+    def __init__(self,
+                 interval: int = BACNET_INTERVAL,
+                 timeout: float = BACNET_TIMEOUT,
+                 port: int = BACNET_PORT,
+                 targets: list[str] | None = None,
+                 exclusions: list | None = None):
+        self.interval: int = interval
+        self.timeout: float = timeout
+        self.port: int = port
+        # Where the Who-Is is sent, the local broadcast addresses by default
+        self.targets: list[str] | None = targets
+        self.exclusions: list = exclusions if exclusions is not None else \
+            parse_exclusions(os.getenv('NETWORK_PROBE_EXCLUDE'))
+        self.inventory: dict[str, dict] = {}
+        self._last_discovery: float = 0
+        self._invoke_id: int = 0
+
+    def excluded(self, address: str) -> bool:
+        ip = ipaddress.ip_address(address.rsplit(':', 1)[0])
+        return any(ip in network for network in self.exclusions)
+
+    def open_socket(self) -> socket.socket:
+        sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+        sock.setsockopt(socket.SOL_SOCKET, socket.SO_BROADCAST, 1)
+        sock.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+        try:
+            sock.bind(('', self.port))
+        except OSError as ex:
+            logger.info(f'BACnet port {self.port} in use ({ex}), only the I-Am sent back will be received')
+            sock.bind(('', 0))
+        return sock
+
+    def receive(self, sock: socket.socket, deadline: float):
+        """
+        :return: The APDUs received until the deadline, with the address of their device, and its network and MAC
+        address when behind a router
+        """
+        while True:
+            remaining = deadline - time.monotonic()
+            if remaining <= 0:
+                return
+            sock.settimeout(remaining)
+            try:
+                data, (ip, port) = sock.recvfrom(1500)
+            except socket.timeout:
+                return
+            try:
+                message, forwarded_for = parse_bvlc(data)
+                apdu, network, mac = parse_npdu(message)
+            except (BACnetError, IndexError, struct.error) as ex:
+                logger.debug(f'Ignoring invalid BACnet message from {ip}: {ex}')
+                continue
+            if apdu is not None:
+                yield apdu, forwarded_for or f'{ip}:{port}', network, mac
+
+    def who_is(self, sock: socket.socket) -> dict[int, dict]:
+        """
+        :return: The devices answering the Who-Is, by instance
+        """
+        for target in self.targets if self.targets is not None else broadcast_addresses() + ['255.255.255.255']:
+            try:
+                sock.sendto(who_is(), (target, self.port))
+            except OSError as ex:
+                logger.debug(f'Cannot broadcast a Who-Is to {target}: {ex}')
+
+        devices = {}
+        for apdu, address, network, mac in self.receive(sock, time.monotonic() + self.timeout):
+            try:
+                i_am = parse_i_am(apdu)
+            except (BACnetError, IndexError) as ex:
+                logger.debug(f'Ignoring invalid I-Am from {address}: {ex}')
+                continue
+            if i_am is not None:
+                devices[i_am['instance']] = {**i_am, 'address': address, 'network': network,
+                                             'mac': mac.hex() if network is not None else None, '_mac': mac}
+        return devices
+
+    def read(self, sock: socket.socket, device: dict, property_id: int, array_index: int | None = None) -> Tag | None:
+        """
+        :return: The value of a property of the device object, None when the device does not answer or has an error
+        """
+        self._invoke_id = (self._invoke_id + 1) % 256
+        ip, port = device['address'].rsplit(':', 1)
+        sock.sendto(read_property(self._invoke_id, device['instance'], property_id, array_index,
+                                  device['network'], device['_mac']), (ip, int(port)))
+        deadline = time.monotonic() + min(self.timeout, 1)
+        for apdu, _, network, mac in self.receive(sock, deadline):
+            if network != device['network'] or (network is not None and mac != device['_mac']):
+                continue
+            try:
+                return parse_read_property_ack(apdu, self._invoke_id)
+            except BACnetError:
+                # Such as an I-Am arriving late
+                continue
+        return None
+
+    def describe(self, sock: socket.socket, device: dict):
+        """
+        Adds the names and object count of the device
+        """
+        for key, property_id in (('object-name', PROPERTY_OBJECT_NAME), ('vendor-name', PROPERTY_VENDOR_NAME),
+                                 ('model-name', PROPERTY_MODEL_NAME)):
+            tag = self.read(sock, device, property_id)
+            if tag is not None and not tag.context and tag.number == 7:
+                device[key] = decode_string(tag.value)
+        tag = self.read(sock, device, PROPERTY_OBJECT_LIST, array_index=0)
+        if tag is not None and not tag.context and tag.number == 2:
+            device['object-count'] = decode_unsigned(tag.value)
+
+    def discover(self) -> dict[str, dict]:
+        if self.inventory and time.time() - self._last_discovery < self.interval:
+            return self.inventory
+
+        inventory = {}
+        try:
+            with self.open_socket() as sock:
+                for device in self.who_is(sock).values():
+                    if not self.excluded(device['address']):
+                        self.describe(sock, device)
+                    peripheral = format_device(device)
+                    inventory[peripheral['identifier']] = peripheral
+        except OSError as ex:
+            logger.warning(f'BACnet discovery failed: {ex}')
+            return self.inventory
+
+        logger.info(f'BACnet discovery found {len(inventory)} devices')
+        self.inventory = inventory
+        self._last_discovery = time.time()
+        return inventory
@@ -0,0 +1,112 @@
+"""
+Opt-in leader election between the network discovery instances of a highly available NuvlaEdge
+
+When the NuvlaEdge runs on several nodes of the same LAN, each with its network peripheral manager, setting
+NETWORK_LEADER_LOCK to a file of a volume the nodes share elects one of them to scan the LAN, the others standing by
+without scanning nor reporting. The file holds the leader and when it last renewed its lease:
+    {"holder": "edge-1:42", "renewed": 1714564800.0, "ttl": 120}
+
+The leader renews the lease before and after every scan. A lease not renewed for NETWORK_LEADER_TTL seconds, by
+default 120, is expired and taken over by the first instance standing by to notice, so the TTL must exceed the time
+of a scan and the scan interval. The leader gives the lease up when it stops.
+
+Shared volumes, NFS for instance, seldom honour file locks across nodes: the lease is written to a temporary file
+renamed over the lock file, and read back to confirm who won, the last rename prevailing.
+"""
+import json
+import logging
+import os
+import re
+import socket
+import time
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+LEADER_LOCK = os.getenv('NETWORK_LEADER_LOCK', '')
+LEADER_TTL = float(os.getenv('NETWORK_LEADER_TTL', 120))
+LEADER_ID = os.getenv('NETWORK_LEADER_ID', '')
+
+
+def default_identity() -> str:
+    return f'{socket.gethostname()}:{os.getpid()}'
+
+
+class LeaderElection:
+    """
+    Holds, renews and releases the lease of the network discovery
+    """
+
+    def __init__(self,
+                 path: str = LEADER_LOCK,
+                 ttl: float = LEADER_TTL,
+                 identity: str = LEADER_ID,
+                 clock=time.time):
+        self.path: str = path
+        self.ttl: float = ttl
+        self.identity: str = identity or default_identity()
+        self.clock = clock
+        self.leader: bool = False
+
+    def read(self) -> dict:
+        """
+        :return: The current lease, empty when there is none or it cannot be read
+        """
+        try:
+            with open(self.path) as f:
+                lease = json.load(f)
+        except (OSError, ValueError):
+            return {}
+        return lease if isinstance(lease, dict) else {}
+
+    def expired(self, lease: dict) -> bool:
+        try:
+            return self.clock() - float(lease['renewed']) > float(lease.get('ttl', self.ttl))
+        except (KeyError, TypeError, ValueError):
+            return True
+
+    def write(self) -> None:
+        # Each instance writes its own temporary file, the renames alone compete
+        tmp = f'{self.path}.{re.sub(r"[^A-Za-z0-9_.-]", "-", self.identity)}.tmp'
+        os.makedirs(os.path.dirname(self.path) or '.', exist_ok=True)
+        with open(tmp, 'w') as f:
+            json.dump({'holder': self.identity, 'renewed': self.clock(), 'ttl': self.ttl}, f)
+        os.replace(tmp, self.path)
+
+    def elect(self) -> bool:
+        """
+        Takes or renews the lease when it is free, expired or already held
+
+        :return: Whether this instance leads the discovery
+        """
+        lease = self.read()
+        holder = lease.get('holder')
+        leader = False
+        if not holder or holder == self.identity or self.expired(lease):
+            try:
+                self.write()
+                holder = self.read().get('holder')
+                leader = holder == self.identity
+            except OSError as ex:
+                logger.error(f'Unable to write the network discovery lease {self.path}: {ex}')
+
+        if leader and not self.leader:
+            logger.info(f'Leading the network discovery, holding the lease {self.path}')
+        elif not leader and self.leader:
+            logger.warning(f'Lost the network discovery lease to {holder}, standing by')
+        elif not leader:
+            logger.debug(f'Standing by, {holder} leads the network discovery')
+        self.leader = leader
+        return leader
+
+    def release(self) -> None:
+        """
+        Gives the lease up, if held, so an instance standing by takes over right away
+        """
+        if self.read().get('holder') != self.identity:
+            return
+        try:
+            os.remove(self.path)
+        except OSError as ex:
+            logger.warning(f'Unable to release the network discovery lease {self.path}: {ex}')
+        self.leader = False
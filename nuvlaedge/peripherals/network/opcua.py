@@ -0,0 +1,495 @@
+"""
+Discovery of the OPC UA servers of the local network
+
+The servers are found from:
+ - the discovery URLs of NETWORK_OPCUA_DISCOVERY_URLS, such as the one of a local discovery server (LDS), which lists
+   the servers registered to it
+ - the _opcua-tcp._tcp services announced over mDNS, by the servers themselves or by a multicast enabled LDS (LDS-ME)
+ - the swept hosts with the OPC UA port open, when the subnet sweep and the service detection are enabled
+
+Each server is asked for its endpoints with the GetEndpoints service, over a secure channel without security, which
+the specification requires every server to accept for discovery. The servers are reported with their endpoint URLs,
+security modes and policies, and the user identity tokens they accept, so data acquisition applications can pick the
+endpoint to connect to. No session is ever created. The hosts of NETWORK_PROBE_EXCLUDE are not contacted, unless listed
+in NETWORK_OPCUA_DISCOVERY_URLS, and the discovery is disabled when NETWORK_OPCUA_DISCOVERY is set to false.
+
+Only the binary encoding of the few services involved is implemented, so no OPC UA library is needed.
+"""
+import ipaddress
+import logging
+import os
+import socket
+import struct
+import time
+from urllib.parse import urlparse
+
+from nuvlaedge.peripherals.network.services import parse_exclusions
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+OPCUA_DISCOVERY = os.getenv('NETWORK_OPCUA_DISCOVERY', 'true').lower() not in ['false', '0', 'no']
+OPCUA_TIMEOUT = float(os.getenv('NETWORK_OPCUA_TIMEOUT', 2))
+
+DEFAULT_PORT = 4840
+MDNS_SERVICE_TYPE = '_opcua-tcp._tcp.local.'
+MAX_MESSAGE_SIZE = 1 << 22
+
+SECURITY_POLICY_NONE = 'http://opcfoundation.org/UA/SecurityPolicy#None'
+
+# Binary encoding ids of the services, from the NodeIds of the specification
+OPEN_SECURE_CHANNEL_REQUEST = 446
+OPEN_SECURE_CHANNEL_RESPONSE = 449
+CLOSE_SECURE_CHANNEL_REQUEST = 452
+FIND_SERVERS_REQUEST = 422
+FIND_SERVERS_RESPONSE = 425
+GET_ENDPOINTS_REQUEST = 428
+GET_ENDPOINTS_RESPONSE = 431
+SERVICE_FAULT = 397
+
+SECURITY_MODES = {1: 'None', 2: 'Sign', 3: 'SignAndEncrypt'}
+APPLICATION_TYPES = {0: 'server', 1: 'client', 2: 'client-and-server', 3: 'discovery-server'}
+USER_TOKEN_TYPES = {0: 'anonymous', 1: 'username', 2: 'certificate', 3: 'issued-token'}
+
+# Seconds between 1601-01-01, the epoch of the OPC UA DateTime, and 1970-01-01
+_EPOCH_OFFSET = 11644473600
+
+
+class OPCUAError(Exception):
+    ...
+
+
+def _string(value: str | None) -> bytes:
+    if value is None:
+        return struct.pack('<i', -1)
+    data = value.encode()
+    return struct.pack('<i', len(data)) + data
+
+
+def _date_time(timestamp: float) -> bytes:
+    return struct.pack('<q', int((timestamp + _EPOCH_OFFSET) * 10_000_000))
+
+
+def _request_header(handle: int) -> bytes:
+    return (b'\x00\x00'                             # Null authentication token
+            + _date_time(time.time())
+            + struct.pack('<IIi', handle, 0, -1)    # Request handle, no diagnostics, no audit entry
+            + struct.pack('<I', int(OPCUA_TIMEOUT * 1000))
+            + b'\x00\x00\x00')                      # No additional header
+
+
+def _type_id(encoding_id: int) -> bytes:
+    # Four byte NodeId, in namespace 0
+    return struct.pack('<BBH', 1, 0, encoding_id)
+
+
+class Decoder:
+    """
+    Reads the OPC UA binary encoding of a message body
+    """
+
+    def __init__(self, data: bytes):
+        self.data = data
+        self.offset = 0
+
+    def read(self, size: int) -> bytes:
+        if size < 0 or self.offset + size > len(self.data):
+            raise OPCUAError('message truncated')
+        value = self.data[self.offset:self.offset + size]
+        self.offset += size
+        return value
+
+    def unpack(self, fmt: str):
+        values = struct.unpack(fmt, self.read(struct.calcsize(fmt)))
+        return values[0] if len(values) == 1 else values
+
+    def byte(self) -> int:
+        return self.unpack('<B')
+
+    def int32(self) -> int:
+        return self.unpack('<i')
+
+    def uint32(self) -> int:
+        return self.unpack('<I')
+
+    def string(self) -> str | None:
+        size = self.int32()
+        return None if size < 0 else self.read(size).decode('utf-8', errors='replace')
+
+    def byte_string(self) -> bytes | None:
+        size = self.int32()
+        return None if size < 0 else self.read(size)
+
+    def array(self, read) -> list:
+        size = self.int32()
+        if size > len(self.data):
+            raise OPCUAError(f'invalid array length {size}')
+        return [read() for _ in range(max(size, 0))]
+
+    def node_id(self) -> int:
+        """
+        :return: The identifier of a numeric NodeId, the only kind used for the type ids
+        """
+        encoding = self.byte() & 0x3f
+        if encoding == 0:
+            return self.byte()
+        if encoding == 1:
+            return self.unpack('<BH')[1]
+        if encoding == 2:
+            return self.unpack('<HI')[1]
+        raise OPCUAError(f'unsupported NodeId encoding {encoding}')
+
+    def localized_text(self) -> str | None:
+        mask = self.byte()
+        if mask & 0x01:
+            self.string()
+        return self.string() if mask & 0x02 else None
+
+    def diagnostic_info(self):
+        mask = self.byte()
+        for bit in (0x01, 0x02, 0x08, 0x04):
+            if mask & bit:
+                self.int32()
+        if mask & 0x10:
+            self.string()
+        if mask & 0x20:
+            self.uint32()
+        if mask & 0x40:
+            self.diagnostic_info()
+
+    def extension_object(self):
+        self.node_id()
+        encoding = self.byte()
+        if encoding in (1, 2):
+            self.byte_string()
+
+    def response_header(self):
+        """
+        :raises OPCUAError: when the service failed
+        """
+        self.read(8)                                # Timestamp
+        self.uint32()                               # Request handle
+        status = self.uint32()
+        self.diagnostic_info()
+        self.array(self.string)
+        self.extension_object()
+        if status & 0x80000000:
+            raise OPCUAError(f'service failed with status 0x{status:08X}')
+
+    def application_description(self) -> dict:
+        return {
+            'application-uri': self.string(),
+            'product-uri': self.string(),
+            'name': self.localized_text(),
+            'application-type': APPLICATION_TYPES.get(self.int32(), 'unknown'),
+            'gateway-server-uri': self.string(),
+            'discovery-profile-uri': self.string(),
+            'discovery-urls': self.array(self.string)
+        }
+
+    def user_token_policy(self) -> dict:
+        token = {'policy-id': self.string(), 'type': USER_TOKEN_TYPES.get(self.int32(), 'unknown')}
+        self.string()                               # Issued token type
+        self.string()                               # Issuer endpoint URL
+        token['security-policy'] = policy_name(self.string())
+        return token
+
+    def endpoint_description(self) -> dict:
+        endpoint = {'url': self.string(), 'server': self.application_description()}
+        self.byte_string()                          # Server certificate
+        endpoint['security-mode'] = SECURITY_MODES.get(self.int32(), 'Invalid')
+        endpoint['security-policy'] = policy_name(self.string())
+        endpoint['user-tokens'] = self.array(self.user_token_policy)
+        endpoint['transport-profile'] = self.string()
+        endpoint['security-level'] = self.byte()
+        return endpoint
+
+
+def policy_name(uri: str | None) -> str | None:
+    """
+    :return: The name of a security policy, the fragment of its URI, such as Basic256Sha256
+    """
+    if not uri:
+        return None
+    return uri.rsplit('#', 1)[-1]
+
+
+class SecureChannel:
+    """
+    A secure channel without security, enough for the discovery services
+    """
+
+    def __init__(self, url: str, timeout: float = OPCUA_TIMEOUT):
+        parsed = urlparse(url)
+        if parsed.scheme != 'opc.tcp' or not parsed.hostname:
+            raise OPCUAError(f'unsupported URL {url}')
+        self.url = url
+        self.address = (parsed.hostname, parsed.port or DEFAULT_PORT)
+        self.timeout = timeout
+        self.sock: socket.socket | None = None
+        self.channel_id = 0
+        self.token_id = 0
+        self.sequence = 0
+        self.request_id = 0
+
+    def __enter__(self):
+        self.sock = socket.create_connection(self.address, timeout=self.timeout)
+        try:
+            self._hello()
+            self._open()
+        except Exception:
+            self.sock.close()
+            raise
+        return self
+
+    def __exit__(self, *args):
+        try:
+            self._send(b'CLO', _type_id(CLOSE_SECURE_CHANNEL_REQUEST) + _request_header(self.request_id + 1))
+        except OSError:
+            pass
+        finally:
+            self.sock.close()
+
+    def _recv_exactly(self, size: int) -> bytes:
+        data = b''
+        while len(data) < size:
+            chunk = self.sock.recv(size - len(data))
+            if not chunk:
+                raise OPCUAError('connection closed by the server')
+            data += chunk
+        return data
+
+    def _recv_chunk(self) -> tuple[bytes, bytes, bytes]:
+        """
+        :return: The message type, chunk type and content of the next chunk
+        """
+        header = self._recv_exactly(8)
+        message_type, chunk_type, size = header[:3], header[3:4], struct.unpack('<I', header[4:])[0]
+        if size < 8 or size > MAX_MESSAGE_SIZE:
+            raise OPCUAError(f'invalid chunk size {size}')
+        content = self._recv_exactly(size - 8)
+        if message_type == b'ERR':
+            decoder = Decoder(content)
+            raise OPCUAError(f'server error 0x{decoder.uint32():08X}: {decoder.string()}')
+        return message_type, chunk_type, content
+
+    def _hello(self):
+        body = struct.pack('<IIIII', 0, 65536, 65536, MAX_MESSAGE_SIZE, 0) + _string(self.url)
+        self.sock.sendall(b'HELF' + struct.pack('<I', 8 + len(body)) + body)
+        message_type, _, _ = self._recv_chunk()
+        if message_type != b'ACK':
+            raise OPCUAError(f'expected an acknowledge, got {message_type!r}')
+
+    def _send(self, message_type: bytes, body: bytes):
+        self.sequence += 1
+        self.request_id += 1
+        if message_type == b'OPN':
+            security = _string(SECURITY_POLICY_NONE) + _string(None) + _string(None)
+        else:
+            security = struct.pack('<I', self.token_id)
+        payload = struct.pack('<I', self.channel_id) + security + struct.pack('<II', self.sequence, self.request_id) \
+            + body
+        self.sock.sendall(message_type + b'F' + struct.pack('<I', 8 + len(payload)) + payload)
+
+    def _receive(self, message_type: bytes) -> Decoder:
+        """
+        Reads the chunks of a response, until the final one
+        :return: A decoder of the response body, positioned after its type id
+        """
+        body = b''
+        while True:
+            received_type, chunk_type, content = self._recv_chunk()
+            if received_type != message_type:
+                raise OPCUAError(f'expected a {message_type!r} message, got {received_type!r}')
+            decoder = Decoder(content)
+            decoder.uint32()                        # Secure channel id
+            if message_type == b'OPN':
+                decoder.string()
+                decoder.byte_string()
+                decoder.byte_string()
+            else:
+                decoder.uint32()                    # Token id
+            decoder.read(8)                         # Sequence number and request id
+            body += content[decoder.offset:]
+            if chunk_type == b'A':
+                raise OPCUAError('response aborted by the server')
+            if chunk_type == b'F':
+                break
+            if len(body) > MAX_MESSAGE_SIZE:
+                raise OPCUAError('response exceeds the maximum message size')
+        return Decoder(body)
+
+    def _open(self):
+        body = (_type_id(OPEN_SECURE_CHANNEL_REQUEST) + _request_header(1)
+                + struct.pack('<Iii', 0, 0, 1)      # Protocol version, issue, security mode None
+                + struct.pack('<i', 0)              # Empty client nonce
+                + struct.pack('<I', 60000))         # Requested lifetime
+        self._send(b'OPN', body)
+        decoder = self._receive(b'OPN')
+        self._check_type(decoder, OPEN_SECURE_CHANNEL_RESPONSE)
+        decoder.response_header()
+        decoder.uint32()                            # Server protocol version
+        self.channel_id, self.token_id = decoder.unpack('<II')
+
+    def _check_type(self, decoder: Decoder, expected: int):
+        type_id = decoder.node_id()
+        if type_id == SERVICE_FAULT:
+            decoder.response_header()
+        if type_id != expected:
+            raise OPCUAError(f'unexpected response type {type_id}')
+
+    def call(self, request: int, response: int, parameters: bytes) -> Decoder:
+        self._send(b'MSG', _type_id(request) + _request_header(self.request_id + 1) + parameters)
+        decoder = self._receive(b'MSG')
+        self._check_type(decoder, response)
+        decoder.response_header()
+        return decoder
+
+    def find_servers(self) -> list[dict]:
+        decoder = self.call(FIND_SERVERS_REQUEST, FIND_SERVERS_RESPONSE,
+                            _string(self.url) + struct.pack('<ii', 0, 0))
+        return decoder.array(decoder.application_description)
+
+    def get_endpoints(self) -> list[dict]:
+        decoder = self.call(GET_ENDPOINTS_REQUEST, GET_ENDPOINTS_RESPONSE,
+                            _string(self.url) + struct.pack('<ii', 0, 0))
+        return decoder.array(decoder.endpoint_description)
+
+
+def format_server(server: dict, endpoints: list[dict]) -> dict:
+    """
+    Formats an OPC UA server and its endpoints into a Nuvla compliant peripheral
+    """
+    name = server['name'] or server['application-uri']
+    policies = sorted({e['security-policy'] for e in endpoints if e['security-policy']})
+    description = f'OPC UA {server["application-type"]} {name}'
+    if server['product-uri']:
+        description += f', product {server["product-uri"]}'
+    description += f', {len(endpoints)} endpoints'
+    if policies:
+        description += f', security policies {" ".join(policies)}'
+
+    opcua = {
+        'application-uri': server['application-uri'],
+        'product-uri': server['product-uri'],
+        'application-type': server['application-type'],
+        'discovery-urls': server['discovery-urls'],
+        'endpoints': [{
+            'url': e['url'],
+            'security-mode': e['security-mode'],
+            'security-policy': e['security-policy'],
+            'security-level': e['security-level'],
+            'user-tokens': sorted({token['type'] for token in e['user-tokens']})
+        } for e in endpoints]
+    }
+    peripheral = {
+        'identifier': server['application-uri'],
+        'available': True,
+        'interface': 'OPC UA',
+        'classes': ['opc-ua', server['application-type']],
+        'name': name,
+        'description': description,
+        'additional-assets': {'opc-ua': opcua}
+    }
+    if endpoints:
+        peripheral['device-path'] = endpoints[0]['url']
+    elif server['discovery-urls']:
+        peripheral['device-path'] = server['discovery-urls'][0]
+    return peripheral
+
+
+def mdns_urls(services: dict) -> list[str]:
+    """
+    :param services: The zeroconf services, by name
+    :return: The discovery URLs of the OPC UA servers announced over mDNS
+    """
+    urls = []
+    for service in services.values():
+        if service is None or service.type != MDNS_SERVICE_TYPE:
+            continue
+        addresses = service.parsed_addresses()
+        if not addresses:
+            continue
+        path = (service.properties or {}).get(b'path') or b''
+        path = path.decode(errors='ignore')
+        if path and not path.startswith('/'):
+            path = '/' + path
+        host = addresses[0] if ':' not in addresses[0] else f'[{addresses[0]}]'
+        urls.append(f'opc.tcp://{host}:{service.port}{path}')
+    return urls
+
+
+def swept_urls(peripherals: dict[str, dict]) -> list[str]:
+    """
+    :return: The default discovery URLs of the swept hosts with the OPC UA port open
+    """
+    return [f'opc.tcp://{p["device-path"]}:{DEFAULT_PORT}' for p in peripherals.values()
+            if 'opc-ua' in p.get('classes', []) and p.get('interface') != 'OPC UA' and p.get('device-path')]
+
+
+class OPCUADiscovery:
+    """
+    Finds the OPC UA servers behind discovery URLs and lists their endpoints
+    """
+
+    def __init__(self, urls: list[str] | None = None, exclusions: list | None = None, timeout: float = OPCUA_TIMEOUT):
+        self.urls: list[str] = urls if urls is not None else \
+            [u.strip() for u in os.getenv('NETWORK_OPCUA_DISCOVERY_URLS', '').split(',') if u.strip()]
+        self.exclusions: list = exclusions if exclusions is not None else \
+            parse_exclusions(os.getenv('NETWORK_PROBE_EXCLUDE'))
+        self.timeout: float = timeout
+
+    def excluded(self, url: str) -> bool:
+        try:
+            address = ipaddress.ip_address(urlparse(url).hostname or '')
+        except ValueError:
+            return False
+        return any(address in network for network in self.exclusions)
+
+    def servers(self, url: str) -> list[dict]:
+        """
+        :return: The servers known to a discovery URL: the registered ones for an LDS, the server itself otherwise
+        """
+        try:
+            with SecureChannel(url, self.timeout) as channel:
+                return channel.find_servers()
+        except (OSError, OPCUAError) as e:
+            logger.debug(f'FindServers failed on {url}: {e}')
+            return []
+
+    def endpoints(self, url: str) -> list[dict] | None:
+        try:
+            with SecureChannel(url, self.timeout) as channel:
+                return channel.get_endpoints()
+        except (OSError, OPCUAError) as e:
+            logger.debug(f'GetEndpoints failed on {url}: {e}')
+            return None
+
+    def discover(self, candidates: list[str] = ()) -> dict[str, dict]:
+        """
+        :param candidates: Discovery URLs found by other means, such as mDNS, skipped when excluded
+        :return: The OPC UA servers, as peripherals by application URI
+        """
+        urls = list(dict.fromkeys(self.urls + [u for u in candidates if not self.excluded(u)]))
+        # The servers, and the URL they were found from
+        servers: dict[str, tuple[dict, str]] = {}
+        for url in urls:
+            for server in self.servers(url):
+                # Discovery servers list themselves too, only the servers they know are reported
+                if server['application-uri'] and server['application-type'] in ('server', 'client-and-server'):
+                    servers.setdefault(server['application-uri'], (server, url))
+
+        peripherals = {}
+        for uri, (server, source) in servers.items():
+            # The discovery URLs of a server may use a host name the NuvlaEdge cannot resolve, the URL of the
+            # discovery server answered on is tried last
+            for url in dict.fromkeys((server['discovery-urls'] or []) + [source]):
+                if url not in urls and self.excluded(url):
+                    continue
+                endpoints = self.endpoints(url)
+                if endpoints is not None:
+                    peripherals[uri] = format_server(server, endpoints)
+                    break
+            else:
+                logger.debug(f'No reachable discovery URL for OPC UA server {uri}')
+        return peripherals
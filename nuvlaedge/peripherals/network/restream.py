@@ -0,0 +1,237 @@
+"""
+Opt-in registration of the discovered cameras with a media server re-streaming them, as mediamtx
+
+When NETWORK_RESTREAM_API is set to the control API of the media server, http://mediamtx:9997 for instance, the RTSP
+stream of every camera discovered is registered there as an on-demand path, so the video pipelines of the edge read
+the cameras through the server rather than each opening its own connection to them:
+    - the ONVIF cameras found by WS-Discovery are asked for the stream URI of their first media profile, with the
+      NETWORK_ONVIF_USERNAME and NETWORK_ONVIF_PASSWORD credentials when set
+    - the peripherals whose device path is an rtsp:// URL are registered as is
+
+The path of a camera is named after its identifier, and its proxied URL is the one of NETWORK_RESTREAM_URL, by default
+rtsp://<media server host>:8554, followed by the path. Both URLs go to the stream asset of the additional assets of the
+camera, without the credentials: the media server alone gets them, to pull the stream.
+
+The paths are kept registered when the cameras disappear, the media server only connects to the cameras while read.
+"""
+import base64
+import hashlib
+import logging
+import os
+import re
+import time
+import xml.etree.ElementTree as ElementTree
+from datetime import datetime, timezone
+from urllib.parse import quote, urlparse, urlunparse
+from xml.sax.saxutils import escape
+
+import requests
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+RESTREAM_API = os.getenv('NETWORK_RESTREAM_API', '').rstrip('/')
+RESTREAM_URL = os.getenv('NETWORK_RESTREAM_URL', '').rstrip('/')
+RESTREAM_TIMEOUT = float(os.getenv('NETWORK_RESTREAM_TIMEOUT', 5))
+ONVIF_USERNAME = os.getenv('NETWORK_ONVIF_USERNAME', '')
+ONVIF_PASSWORD = os.getenv('NETWORK_ONVIF_PASSWORD', '')
+
+RTSP_PORT = 8554
+# Time before asking again a camera which did not tell its stream, or registering again a failed path
+RETRY_AFTER = 600
+
+ASSET = 'stream'
+ONVIF_CAMERA_CLASS = 'NetworkVideoTransmitter'
+
+SOAP_ENVELOPE = ('<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Header>{header}</s:Header>'
+                 '<s:Body>{body}</s:Body></s:Envelope>')
+WSSE = 'http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd'
+WSU = 'http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd'
+PASSWORD_DIGEST = 'http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest'
+BASE64_BINARY = 'http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary'
+
+GET_CAPABILITIES = ('<GetCapabilities xmlns="http://www.onvif.org/ver10/device/wsdl"><Category>Media</Category>'
+                    '</GetCapabilities>')
+GET_PROFILES = '<GetProfiles xmlns="http://www.onvif.org/ver10/media/wsdl"/>'
+GET_STREAM_URI = ('<GetStreamUri xmlns="http://www.onvif.org/ver10/media/wsdl"><StreamSetup>'
+                  '<Stream xmlns="http://www.onvif.org/ver10/schema">RTP-Unicast</Stream>'
+                  '<Transport xmlns="http://www.onvif.org/ver10/schema"><Protocol>RTSP</Protocol></Transport>'
+                  '</StreamSetup><ProfileToken>{token}</ProfileToken></GetStreamUri>')
+
+
+def security_header(username: str, password: str, created: str | None = None, nonce: bytes | None = None) -> str:
+    """
+    :return: The WS-Security UsernameToken header authenticating the ONVIF requests, empty without credentials
+    """
+    if not username:
+        return ''
+    created = created or datetime.now(timezone.utc).strftime('%Y-%m-%dT%H:%M:%S.000Z')
+    nonce = nonce or os.urandom(16)
+    digest = base64.b64encode(hashlib.sha1(nonce + created.encode() + password.encode()).digest()).decode()
+    return (f'<Security s:mustUnderstand="1" xmlns="{WSSE}"><UsernameToken>'
+            f'<Username>{escape(username)}</Username><Password Type="{PASSWORD_DIGEST}">{digest}</Password>'
+            f'<Nonce EncodingType="{BASE64_BINARY}">{base64.b64encode(nonce).decode()}</Nonce>'
+            f'<Created xmlns="{WSU}">{created}</Created></UsernameToken></Security>')
+
+
+def without_credentials(url: str) -> str:
+    parsed = urlparse(url)
+    if not parsed.username and not parsed.password:
+        return url
+    host = parsed.hostname or ''
+    netloc = f'[{host}]' if ':' in host else host
+    if parsed.port:
+        netloc += f':{parsed.port}'
+    return urlunparse(parsed._replace(netloc=netloc))
+
+
+def with_credentials(url: str, username: str, password: str) -> str:
+    """
+    :return: The URL with the credentials, unless it already has some
+    """
+    parsed = urlparse(url)
+    if not username or parsed.username:
+        return url
+    credentials = quote(username, safe='') + (f':{quote(password, safe="")}' if password else '')
+    return urlunparse(parsed._replace(netloc=f'{credentials}@{parsed.netloc}'))
+
+
+def path_name(identifier: str) -> str:
+    return 'camera-' + re.sub(r'[^A-Za-z0-9_-]+', '-', identifier).strip('-').lower()
+
+
+def onvif_address(peripheral: dict) -> str | None:
+    """
+    :return: The device service address of an ONVIF camera, None for the other peripherals
+    """
+    if ONVIF_CAMERA_CLASS not in (peripheral.get('classes') or []):
+        return None
+    for address in (peripheral.get('device-path') or '').split(','):
+        address = address.strip()
+        if address.startswith(('http://', 'https://')):
+            return address
+    return None
+
+
+def stream_source(peripheral: dict) -> str | None:
+    """
+    :return: The RTSP URL of a peripheral streaming one as its device path
+    """
+    path = (peripheral.get('device-path') or '').strip()
+    return path if path.lower().startswith(('rtsp://', 'rtsps://')) else None
+
+
+class Restreamer:
+    """
+    Finds the streams of the cameras and registers them with the media server
+    """
+
+    def __init__(self,
+                 api: str = RESTREAM_API,
+                 url: str = RESTREAM_URL,
+                 username: str = ONVIF_USERNAME,
+                 password: str = ONVIF_PASSWORD,
+                 timeout: float = RESTREAM_TIMEOUT):
+        self.api: str = api.rstrip('/')
+        self.url: str = url.rstrip('/') or f'rtsp://{urlparse(self.api).hostname}:{RTSP_PORT}'
+        self.username: str = username
+        self.password: str = password
+        self.timeout: float = timeout
+
+        # Stream URIs of the ONVIF cameras, by device service address
+        self._streams: dict[str, str] = {}
+        # Sources registered by path name
+        self._registered: dict[str, str] = {}
+        self._failures: dict[str, float] = {}
+
+    def soap(self, address: str, body: str) -> ElementTree.Element:
+        """
+        :raises requests.RequestException: when the camera cannot be reached or fails
+        :raises ElementTree.ParseError: when the answer is not XML
+        """
+        envelope = SOAP_ENVELOPE.format(header=security_header(self.username, self.password), body=body)
+        response = requests.post(address, data=envelope.encode(), timeout=self.timeout,
+                                 headers={'Content-Type': 'application/soap+xml; charset=utf-8'})
+        response.raise_for_status()
+        return ElementTree.fromstring(response.content)
+
+    def onvif_stream(self, address: str) -> str | None:
+        """
+        :return: The RTSP stream URI of the first media profile of an ONVIF camera
+        """
+        capabilities = self.soap(address, GET_CAPABILITIES)
+        media = capabilities.find('.//{*}Media/{*}XAddr')
+        media_address = media.text.strip() if media is not None and media.text else address
+
+        profile = self.soap(media_address, GET_PROFILES).find('.//{*}Profiles')
+        token = profile.get('token') if profile is not None else None
+        if not token:
+            return None
+        uri = self.soap(media_address, GET_STREAM_URI.format(token=escape(token))).find('.//{*}MediaUri/{*}Uri')
+        return uri.text.strip() if uri is not None and uri.text else None
+
+    def source(self, identifier: str, peripheral: dict, now: float) -> str | None:
+        source = stream_source(peripheral)
+        address = onvif_address(peripheral)
+        if source or not address:
+            return source
+        if address in self._streams:
+            return self._streams[address]
+        if now < self._failures.get(address, 0):
+            return None
+        try:
+            stream = self.onvif_stream(address)
+        except (requests.RequestException, ElementTree.ParseError) as ex:
+            logger.warning(f'Unable to get the stream of the ONVIF camera {identifier} at {address}: {ex}')
+            stream = None
+        if stream:
+            self._streams[address] = stream
+        else:
+            self._failures[address] = now + RETRY_AFTER
+        return stream
+
+    def register_path(self, name: str, source: str):
+        """
+        Adds the path to the media server, or replaces it when its source changed
+        :raises requests.RequestException: when the media server cannot be reached or fails
+        """
+        config = {'source': with_credentials(source, self.username, self.password), 'sourceOnDemand': True}
+        response = requests.get(f'{self.api}/v3/config/paths/get/{name}', timeout=self.timeout)
+        if response.status_code == 404:
+            response = requests.post(f'{self.api}/v3/config/paths/add/{name}', json=config, timeout=self.timeout)
+        else:
+            response.raise_for_status()
+            if response.json().get('source') == config['source']:
+                return
+            response = requests.post(f'{self.api}/v3/config/paths/replace/{name}', json=config,
+                                     timeout=self.timeout)
+        response.raise_for_status()
+
+    def register(self, peripherals: dict[str, dict], now: float | None = None) -> dict[str, dict]:
+        """
+        Registers the streams of the cameras, adding their URLs to their additional assets
+        :return: The peripherals
+        """
+        now = time.time() if now is None else now
+        for identifier, peripheral in peripherals.items():
+            source = self.source(identifier, peripheral, now)
+            if not source:
+                continue
+
+            name = path_name(identifier)
+            if self._registered.get(name) != source and now >= self._failures.get(name, 0):
+                try:
+                    self.register_path(name, source)
+                    self._registered[name] = source
+                    self._failures.pop(name, None)
+                    logger.info(f'Registered the stream of the camera {identifier} with the media server as {name}')
+                except (requests.RequestException, ValueError) as ex:
+                    logger.warning(f'Unable to register the stream of the camera {identifier}: {ex}')
+                    self._failures[name] = now + RETRY_AFTER
+
+            stream = {'url': without_credentials(source)}
+            if self._registered.get(name) == source:
+                stream['proxied-url'] = f'{self.url}/{name}'
+            assets = peripheral.get('additional-assets')
+            peripheral['additional-assets'] = {**(assets if isinstance(assets, dict) else {}), ASSET: stream}
+        return peripherals
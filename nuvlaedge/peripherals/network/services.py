@@ -0,0 +1,194 @@
+"""
+Opt-in detection of the services run by the swept LAN hosts
+
+Production LANs host equipment that does not cope well with scanners, so the detection is conservative:
+ - it is disabled unless NETWORK_SERVICE_DETECTION is set to true
+ - only the ports of NETWORK_SERVICE_PORTS are probed, one connection at a time and at most NETWORK_PROBE_RATE
+   connections per second
+ - the hosts and subnets listed in NETWORK_PROBE_EXCLUDE are never contacted
+ - industrial protocols (Modbus, S7, EtherNet/IP, OPC UA) are only detected by connecting, nothing is sent to them
+
+The responding services classify the hosts, e.g. an RTSP server makes a camera and a JetDirect port a printer.
+"""
+import ipaddress
+import logging
+import os
+import socket
+import threading
+import time
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+SERVICE_DETECTION = os.getenv('NETWORK_SERVICE_DETECTION', 'false').lower() in ['true', '1', 'yes']
+PROBE_RATE = float(os.getenv('NETWORK_PROBE_RATE', 10))
+PROBE_TIMEOUT = float(os.getenv('NETWORK_PROBE_TIMEOUT', 0.5))
+
+BANNER_SIZE = 128
+
+# Ports probed by default, with the name of the service usually behind them
+SERVICE_PORTS = {
+    22: 'ssh',
+    80: 'http',
+    102: 's7comm',
+    443: 'https',
+    502: 'modbus',
+    515: 'lpd',
+    554: 'rtsp',
+    631: 'ipp',
+    1883: 'mqtt',
+    4840: 'opc-ua',
+    8554: 'rtsp',
+    9100: 'jetdirect',
+    44818: 'ethernet-ip',
+}
+
+# Services speaking first, or answering a harmless request. The others are only connected to
+SERVICE_REQUESTS = {
+    'ssh': None,
+    'http': b'HEAD / HTTP/1.0\r\n\r\n',
+    'ipp': b'HEAD / HTTP/1.0\r\n\r\n',
+    'rtsp': b'OPTIONS * RTSP/1.0\r\nCSeq: 1\r\n\r\n',
+}
+
+# Device classes inferred from the responding services
+DEVICE_CLASSES = {
+    'rtsp': 'camera',
+    'jetdirect': 'printer',
+    'ipp': 'printer',
+    'lpd': 'printer',
+    'modbus': 'plc',
+    's7comm': 'plc',
+    'ethernet-ip': 'plc',
+    'opc-ua': 'plc',
+}
+
+
+def parse_ports(value: str | None) -> dict[int, str]:
+    """
+    Parses the ports to probe, as a comma separated list of port numbers, optionally named: "554,8080:http"
+    :param value: NETWORK_SERVICE_PORTS. The default ports when empty
+    :return: Service names by port
+    """
+    if not value:
+        return dict(SERVICE_PORTS)
+
+    ports = {}
+    for entry in value.split(','):
+        port, _, service = entry.strip().partition(':')
+        try:
+            port = int(port)
+        except ValueError:
+            logger.warning(f'Ignoring invalid port {entry} in NETWORK_SERVICE_PORTS')
+            continue
+        ports[port] = service or SERVICE_PORTS.get(port, f'tcp/{port}')
+    return ports
+
+
+def parse_exclusions(value: str | None) -> list[ipaddress.IPv4Network | ipaddress.IPv6Network]:
+    """
+    Parses the hosts and subnets never to probe, as a comma separated list: "10.0.0.5,10.0.1.0/24"
+    """
+    exclusions = []
+    for entry in (value or '').split(','):
+        if not entry.strip():
+            continue
+        try:
+            exclusions.append(ipaddress.ip_network(entry.strip(), strict=False))
+        except ValueError:
+            logger.warning(f'Ignoring invalid address {entry} in NETWORK_PROBE_EXCLUDE')
+    return exclusions
+
+
+class RateLimiter:
+    """
+    Spaces the calls to wait() so that at most rate of them happen per second
+    """
+
+    def __init__(self, rate: float):
+        self.interval: float = 1 / rate if rate > 0 else 0
+        self._next: float = 0
+        self._lock = threading.Lock()
+
+    def wait(self):
+        with self._lock:
+            now = time.monotonic()
+            if self._next > now:
+                time.sleep(self._next - now)
+                now = self._next
+            self._next = now + self.interval
+
+
+class ServiceDetector:
+    """
+    Probes the configured ports of the hosts and classifies them by their responding services
+    """
+
+    def __init__(self,
+                 ports: dict[int, str] | None = None,
+                 rate: float = PROBE_RATE,
+                 exclusions: list | None = None,
+                 timeout: float = PROBE_TIMEOUT):
+        self.ports: dict[int, str] = ports if ports is not None else parse_ports(os.getenv('NETWORK_SERVICE_PORTS'))
+        self.exclusions: list = exclusions if exclusions is not None else \
+            parse_exclusions(os.getenv('NETWORK_PROBE_EXCLUDE'))
+        self.timeout: float = timeout
+        self.limiter: RateLimiter = RateLimiter(rate)
+
+    def excluded(self, ip: str) -> bool:
+        address = ipaddress.ip_address(ip)
+        return any(address in network for network in self.exclusions)
+
+    def probe(self, ip: str, port: int) -> str | None:
+        """
+        Connects to a port and, for the services known to answer harmless requests, reads the first line of their
+        response, or their server name for HTTP
+        :return: The banner, empty if none was read, or None if the port is closed
+        """
+        self.limiter.wait()
+        service = self.ports[port]
+        try:
+            with socket.create_connection((ip, port), timeout=self.timeout) as s:
+                if service not in SERVICE_REQUESTS:
+                    return ''
+                if SERVICE_REQUESTS[service]:
+                    s.sendall(SERVICE_REQUESTS[service])
+                try:
+                    data = s.recv(BANNER_SIZE * 4)
+                except socket.timeout:
+                    return ''
+        except OSError:
+            return None
+
+        lines = data.decode('ascii', errors='ignore').splitlines()
+        for line in lines:
+            if line.lower().startswith('server:'):
+                return line[len('server:'):].strip()[:BANNER_SIZE]
+        return lines[0].strip()[:BANNER_SIZE] if lines else ''
+
+    def detect(self, ip: str) -> dict[str, str]:
+        """
+        :return: Banners of the responding services of the host, by service name. Empty for excluded hosts
+        """
+        if self.excluded(ip):
+            logger.debug(f'Not probing excluded host {ip}')
+            return {}
+
+        services = {}
+        for port, service in self.ports.items():
+            banner = self.probe(ip, port)
+            if banner is not None and service not in services:
+                services[service] = banner
+        return services
+
+
+def classify(services: dict[str, str]) -> list[str]:
+    """
+    :return: Device classes of a host, inferred from its responding services, followed by the services themselves
+    """
+    classes = []
+    for service in services:
+        device_class = DEVICE_CLASSES.get(service)
+        if device_class and device_class not in classes:
+            classes.append(device_class)
+    return classes + [service for service in services if service not in classes]
@@ -0,0 +1,219 @@
+"""
+Opt-in active sweep of the local subnets
+
+Hosts are woken up with an ICMP echo, or a UDP datagram when the container is not allowed to ping, so they show in the
+kernel ARP table. Their MAC OUI gives their vendor and, when the service detection is enabled, their responding
+services give a hint on what they are. The result is a basic inventory of the LAN assets, reported as peripherals.
+
+The sweep is disabled unless NETWORK_SUBNET_SWEEP is set to true, since it contacts every address of the subnets.
+"""
+import ipaddress
+import logging
+import os
+import socket
+import time
+from pathlib import Path
+
+import psutil
+
+from nuvlaedge.peripherals.network.services import ServiceDetector, classify
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+SUBNET_SWEEP = os.getenv('NETWORK_SUBNET_SWEEP', 'false').lower() in ['true', '1', 'yes']
+SWEEP_INTERVAL = int(os.getenv('NETWORK_SWEEP_INTERVAL', 600))
+# Larger subnets are only swept around the address of the NuvlaEdge
+MAX_SWEEP_HOSTS = int(os.getenv('NETWORK_SWEEP_MAX_HOSTS', 256))
+OUI_FILE = os.getenv('NETWORK_OUI_FILE', '/usr/share/ieee-data/oui.txt')
+
+ARP_TABLE = '/proc/net/arp'
+ARP_FLAG_COMPLETE = 0x2
+IGNORED_INTERFACES = ('lo', 'docker', 'br-', 'veth', 'cni', 'flannel', 'cali', 'vpn', 'tun', 'tap')
+
+# Vendors of common industrial and IoT equipment, used when no OUI database is installed
+KNOWN_OUIS = {
+    '00:0e:8c': 'Siemens AG',
+    '00:1b:1b': 'Siemens AG',
+    '00:1c:06': 'Siemens AG',
+    '00:00:bc': 'Rockwell Automation',
+    '00:1d:9c': 'Rockwell Automation',
+    '00:80:f4': 'Telemecanique (Schneider Electric)',
+    '00:00:54': 'Schneider Electric',
+    '00:30:de': 'WAGO Kontakttechnik',
+    '00:a0:45': 'Phoenix Contact',
+    '00:40:8c': 'Axis Communications',
+    'ac:cc:8e': 'Axis Communications',
+    '44:19:b6': 'Hangzhou Hikvision',
+    'bc:ad:28': 'Hangzhou Hikvision',
+    '3c:ef:8c': 'Zhejiang Dahua',
+    '00:1e:c0': 'Microchip Technology',
+    'b8:27:eb': 'Raspberry Pi Foundation',
+    'dc:a6:32': 'Raspberry Pi Trading',
+    'e4:5f:01': 'Raspberry Pi Trading',
+    '24:0a:c4': 'Espressif',
+    '30:ae:a4': 'Espressif',
+    '00:04:4b': 'NVIDIA',
+    '00:17:c8': 'Kyocera',
+    '00:00:48': 'Seiko Epson',
+    '3c:2a:f4': 'Brother Industries',
+    '00:1b:a9': 'Brother Industries',
+}
+
+
+def normalize_mac(mac: str) -> str:
+    return mac.strip().lower().replace('-', ':')
+
+
+def load_oui_database(path: str | Path) -> dict[str, str]:
+    """
+    Parses an IEEE OUI registry, in the "oui.txt" format:
+        00-1B-1B   (hex)		Siemens AG
+    :param path: Location of the registry
+    :return: Vendors by OUI, in lower case colon separated notation. Empty if the registry is missing
+    """
+    vendors = {}
+    try:
+        with open(path, encoding='utf-8', errors='ignore') as f:
+            for line in f:
+                if '(hex)' not in line:
+                    continue
+                prefix, _, vendor = line.partition('(hex)')
+                vendors[normalize_mac(prefix)] = vendor.strip()
+    except OSError:
+        logger.debug(f'No OUI database found in {path}')
+    return vendors
+
+
+def read_arp_table(path: str | Path = ARP_TABLE) -> dict[str, str]:
+    """
+    Reads the resolved neighbours of the kernel ARP table
+    :param path: Location of the ARP table
+    :return: MAC addresses by IP
+    """
+    neighbours = {}
+    try:
+        with open(path) as f:
+            next(f, None)
+            for line in f:
+                fields = line.split()
+                if len(fields) < 4:
+                    continue
+                ip, flags, mac = fields[0], int(fields[2], 16), normalize_mac(fields[3])
+                if flags & ARP_FLAG_COMPLETE and mac != '00:00:00:00:00:00':
+                    neighbours[ip] = mac
+    except (OSError, ValueError) as ex:
+        logger.warning(f'Cannot read the ARP table {path}: {ex}')
+    return neighbours
+
+
+def local_subnets() -> list[tuple[ipaddress.IPv4Address, ipaddress.IPv4Network]]:
+    """
+    Lists the IPv4 subnets of the physical interfaces, with the address of the NuvlaEdge in each of them. Subnets larger
+    than MAX_SWEEP_HOSTS are narrowed down to the block of that size around the address.
+    """
+    subnets = []
+    for name, addresses in psutil.net_if_addrs().items():
+        if name.startswith(IGNORED_INTERFACES):
+            continue
+        for address in addresses:
+            if address.family != socket.AF_INET or not address.netmask:
+                continue
+            interface = ipaddress.IPv4Interface(f'{address.address}/{address.netmask}')
+            network = interface.network
+            if network.num_addresses > MAX_SWEEP_HOSTS:
+                prefix = 32 - max(MAX_SWEEP_HOSTS - 1, 1).bit_length()
+                network = ipaddress.IPv4Interface(f'{address.address}/{prefix}').network
+            subnets.append((interface.ip, network))
+    return subnets
+
+
+def wake_up(ip: str):
+    """
+    Sends an ICMP echo to the host, or a UDP datagram to the discard port when the container cannot ping. Either way,
+    the kernel resolves the MAC address of the host
+    """
+    try:
+        # Unprivileged ICMP sockets, allowed by net.ipv4.ping_group_range
+        with socket.socket(socket.AF_INET, socket.SOCK_DGRAM, socket.IPPROTO_ICMP) as s:
+            s.sendto(b'\x08\x00\x00\x00\x00\x01\x00\x01', (ip, 0))
+            return
+    except OSError:
+        pass
+
+    try:
+        with socket.socket(socket.AF_INET, socket.SOCK_DGRAM) as s:
+            s.sendto(b'', (ip, 9))
+    except OSError as ex:
+        logger.debug(f'Cannot reach {ip}: {ex}')
+
+
+def format_host(ip: str, mac: str, vendor: str | None, services: dict[str, str]) -> dict:
+    """
+    Formats a swept host into a Nuvla compliant peripheral
+    """
+    name = f'{vendor} device at {ip}' if vendor else f'LAN host {ip}'
+    description = f'LAN host {ip} ({mac})'
+    if services:
+        description += '. Responding services: ' + ', '.join(
+            f'{service}{f" [{banner}]" if banner else ""}' for service, banner in services.items())
+
+    peripheral = {
+        'identifier': mac,
+        'available': True,
+        'interface': 'LAN',
+        'classes': classify(services) or ['host'],
+        'name': name,
+        'description': description,
+        'device-path': ip,
+    }
+    if vendor:
+        peripheral['vendor'] = vendor
+    return peripheral
+
+
+class SubnetSweeper:
+    """
+    Sweeps the local subnets every SWEEP_INTERVAL, reporting the last inventory in between. The services of the hosts
+    are only probed when given a detector
+    """
+
+    def __init__(self,
+                 interval: int = SWEEP_INTERVAL,
+                 oui_file: str | Path = OUI_FILE,
+                 detector: ServiceDetector | None = None):
+        self.interval: int = interval
+        self.detector: ServiceDetector | None = detector
+        self.vendors: dict[str, str] = {**KNOWN_OUIS, **load_oui_database(oui_file)}
+        self.inventory: dict[str, dict] = {}
+        self._last_sweep: float = 0
+
+    def vendor(self, mac: str) -> str | None:
+        return self.vendors.get(mac[:8])
+
+    def sweep(self) -> dict[str, dict]:
+        if self.inventory and time.time() - self._last_sweep < self.interval:
+            return self.inventory
+
+        own_addresses = set()
+        for own_ip, network in local_subnets():
+            own_addresses.add(str(own_ip))
+            logger.info(f'Sweeping subnet {network}')
+            for host in network.hosts():
+                if host != own_ip:
+                    wake_up(str(host))
+
+        # Leave the neighbours time to answer the ARP requests
+        time.sleep(2)
+
+        inventory = {}
+        for ip, mac in read_arp_table().items():
+            if ip in own_addresses:
+                continue
+            services = self.detector.detect(ip) if self.detector else {}
+            inventory[mac] = format_host(ip, mac, self.vendor(mac), services)
+
+        logger.info(f'Subnet sweep found {len(inventory)} hosts')
+        self.inventory = inventory
+        self._last_sweep = time.time()
+        return inventory
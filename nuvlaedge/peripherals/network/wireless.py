@@ -0,0 +1,300 @@
+"""
+Local Wi-Fi adapters, USB and PCIe alike, with the bands and modes they support
+
+Adapters are listed from the ieee80211 sysfs class, which tells their bus, driver and network interfaces, and described
+through nl80211: supported bands, 802.11 standards and interface modes. Adapters able to run in monitor mode or as an
+access point get the monitor-mode and access-point classes, so wireless survey and AP workloads can target them.
+
+The adapters are reported unless NETWORK_WIRELESS_ADAPTERS is set to false. Without nl80211, as when the kernel does
+not let the container open a netlink socket, only the sysfs information is reported.
+"""
+import logging
+import os
+import socket
+import struct
+from pathlib import Path
+
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+WIRELESS_ADAPTERS = os.getenv('NETWORK_WIRELESS_ADAPTERS', 'true').lower() not in ['false', '0', 'no']
+SYSFS_IEEE80211 = '/sys/class/ieee80211'
+
+# Netlink and generic netlink, from linux/netlink.h and linux/genetlink.h
+NETLINK_GENERIC = 16
+NLM_F_REQUEST = 0x1
+NLM_F_DUMP = 0x300
+NLMSG_ERROR = 2
+NLMSG_DONE = 3
+NLA_TYPE_MASK = 0x3fff
+GENL_ID_CTRL = 0x10
+CTRL_CMD_GETFAMILY = 3
+CTRL_ATTR_FAMILY_ID = 1
+CTRL_ATTR_FAMILY_NAME = 2
+
+# nl80211, from linux/nl80211.h
+NL80211_CMD_GET_WIPHY = 1
+NL80211_ATTR_WIPHY = 1
+NL80211_ATTR_WIPHY_NAME = 2
+NL80211_ATTR_WIPHY_BANDS = 22
+NL80211_ATTR_SUPPORTED_IFTYPES = 32
+NL80211_ATTR_SPLIT_WIPHY_DUMP = 174
+NL80211_BAND_ATTR_HT_CAPA = 4
+NL80211_BAND_ATTR_VHT_CAPA = 8
+NL80211_BAND_ATTR_IFTYPE_DATA = 9
+
+BANDS = {0: '2.4GHz', 1: '5GHz', 2: '60GHz', 3: '6GHz', 4: '900MHz'}
+MODES = {
+    1: 'ad-hoc',
+    2: 'station',
+    3: 'access-point',
+    4: 'ap-vlan',
+    5: 'wds',
+    6: 'monitor',
+    7: 'mesh-point',
+    8: 'p2p-client',
+    9: 'p2p-go',
+    10: 'p2p-device',
+    11: 'ocb',
+    12: 'nan',
+}
+BUSES = {'usb': 'USB', 'pci': 'PCIe', 'sdio': 'SDIO'}
+
+NETLINK_TIMEOUT = 2
+
+
+def attribute(attr_type: int, payload: bytes) -> bytes:
+    """
+    :return: A netlink attribute, padded to 4 bytes
+    """
+    length = 4 + len(payload)
+    return struct.pack('=HH', length, attr_type) + payload + b'\0' * (-length % 4)
+
+
+def parse_attributes(data: bytes) -> dict[int, bytes]:
+    """
+    Parses a stream of netlink attributes
+    :return: The payloads by attribute type
+    """
+    attributes = {}
+    offset = 0
+    while offset + 4 <= len(data):
+        length, attr_type = struct.unpack_from('=HH', data, offset)
+        if length < 4:
+            break
+        attributes[attr_type & NLA_TYPE_MASK] = data[offset + 4:offset + length]
+        offset += (length + 3) & ~3
+    return attributes
+
+
+def parse_messages(data: bytes):
+    """
+    Splits a netlink datagram into its messages
+    :return: Generator of (type, payload) pairs
+    """
+    offset = 0
+    while offset + 16 <= len(data):
+        length, msg_type = struct.unpack_from('=IH', data, offset)
+        if length < 16:
+            break
+        yield msg_type, data[offset + 16:offset + length]
+        offset += (length + 3) & ~3
+
+
+def merge_wiphy(wiphys: dict[int, dict], attributes: dict[int, bytes]):
+    """
+    Merges a message of a wiphy dump. Split dumps describe each wiphy over several messages, all with its index
+    """
+    if NL80211_ATTR_WIPHY not in attributes:
+        return
+    index = struct.unpack('=I', attributes[NL80211_ATTR_WIPHY][:4])[0]
+    wiphy = wiphys.setdefault(index, {'name': None, 'bands': {}, 'modes': set()})
+
+    if NL80211_ATTR_WIPHY_NAME in attributes:
+        wiphy['name'] = attributes[NL80211_ATTR_WIPHY_NAME].rstrip(b'\0').decode(errors='replace')
+
+    if NL80211_ATTR_SUPPORTED_IFTYPES in attributes:
+        for mode in parse_attributes(attributes[NL80211_ATTR_SUPPORTED_IFTYPES]):
+            if mode in MODES:
+                wiphy['modes'].add(MODES[mode])
+
+    if NL80211_ATTR_WIPHY_BANDS in attributes:
+        for band, band_data in parse_attributes(attributes[NL80211_ATTR_WIPHY_BANDS]).items():
+            if band not in BANDS:
+                continue
+            standards = wiphy['bands'].setdefault(BANDS[band], set())
+            band_attributes = parse_attributes(band_data)
+            if NL80211_BAND_ATTR_HT_CAPA in band_attributes:
+                standards.add('802.11n')
+            if NL80211_BAND_ATTR_VHT_CAPA in band_attributes:
+                standards.add('802.11ac')
+            if NL80211_BAND_ATTR_IFTYPE_DATA in band_attributes:
+                standards.add('802.11ax')
+
+
+class NL80211:
+    """
+    Minimal nl80211 client, only listing the wiphys
+    """
+
+    def __init__(self):
+        self.sequence: int = 0
+
+    def request(self, sock: socket.socket, msg_type: int, flags: int, command: int, attributes: bytes) -> list[bytes]:
+        """
+        Sends a generic netlink request and reads the answer
+        :return: The attributes of each message of the answer
+        :raises OSError: on netlink errors
+        """
+        self.sequence += 1
+        payload = struct.pack('=BBH', command, 1, 0) + attributes
+        sock.send(struct.pack('=IHHII', 16 + len(payload), msg_type, NLM_F_REQUEST | flags, self.sequence, 0) + payload)
+
+        answers = []
+        while True:
+            for reply_type, reply in parse_messages(sock.recv(65536)):
+                if reply_type == NLMSG_DONE:
+                    return answers
+                if reply_type == NLMSG_ERROR:
+                    error = struct.unpack_from('=i', reply)[0]
+                    if error:
+                        raise OSError(-error, os.strerror(-error))
+                    return answers
+                answers.append(reply[4:])
+                if not flags & NLM_F_DUMP:
+                    return answers
+
+    def wiphys(self) -> dict[str, dict]:
+        """
+        :return: Bands, with the standards supported in each, and interface modes of the wiphys, by wiphy name
+        :raises OSError: when nl80211 is not available
+        """
+        with socket.socket(socket.AF_NETLINK, socket.SOCK_RAW, NETLINK_GENERIC) as sock:
+            sock.settimeout(NETLINK_TIMEOUT)
+            sock.bind((0, 0))
+
+            family = self.request(sock, GENL_ID_CTRL, 0, CTRL_CMD_GETFAMILY,
+                                  attribute(CTRL_ATTR_FAMILY_NAME, b'nl80211\0'))
+            family_attributes = parse_attributes(family[0]) if family else {}
+            if CTRL_ATTR_FAMILY_ID not in family_attributes:
+                raise OSError('nl80211 family not found')
+            family_id = struct.unpack('=H', family_attributes[CTRL_ATTR_FAMILY_ID][:2])[0]
+
+            wiphys = {}
+            for message in self.request(sock, family_id, NLM_F_DUMP, NL80211_CMD_GET_WIPHY,
+                                        attribute(NL80211_ATTR_SPLIT_WIPHY_DUMP, b'')):
+                merge_wiphy(wiphys, parse_attributes(message))
+            return {wiphy['name']: wiphy for wiphy in wiphys.values() if wiphy['name']}
+
+
+def read_text(path: Path) -> str | None:
+    try:
+        return path.read_text().strip()
+    except OSError:
+        return None
+
+
+def read_sysfs_adapters(root: str | Path = SYSFS_IEEE80211) -> list[dict]:
+    """
+    Lists the wiphys of the ieee80211 sysfs class
+    :return: The name, MAC address, bus, driver, IDs and network interfaces of each wiphy
+    """
+    root = Path(root)
+    if not root.is_dir():
+        return []
+
+    adapters = []
+    for phy in sorted(root.iterdir()):
+        device = phy / 'device'
+        adapter = {
+            'phy': phy.name,
+            'mac': read_text(phy / 'macaddress'),
+            'bus': None,
+            'driver': None,
+            'vendor-id': None,
+            'product-id': None,
+            'product': None,
+            'interfaces': sorted(p.name for p in (device / 'net').glob('*')) if (device / 'net').is_dir() else []
+        }
+        if (device / 'subsystem').exists():
+            adapter['bus'] = (device / 'subsystem').resolve().name
+        if (device / 'driver').exists():
+            adapter['driver'] = (device / 'driver').resolve().name
+
+        if adapter['bus'] == 'pci':
+            adapter['vendor-id'] = read_text(device / 'vendor')
+            adapter['product-id'] = read_text(device / 'device')
+        elif adapter['bus'] == 'usb':
+            # The wiphy hangs off a USB interface, the descriptors are those of its parent device
+            usb_device = device.resolve().parent
+            adapter['vendor-id'] = read_text(usb_device / 'idVendor')
+            adapter['product-id'] = read_text(usb_device / 'idProduct')
+            adapter['product'] = read_text(usb_device / 'product')
+
+        adapters.append(adapter)
+    return adapters
+
+
+def format_adapter(adapter: dict, wiphy: dict | None) -> dict:
+    """
+    Formats a Wi-Fi adapter into a Nuvla compliant peripheral
+    """
+    interface = BUSES.get(adapter['bus'], (adapter['bus'] or 'unknown').upper())
+    name = adapter['product'] or f'{interface} Wi-Fi adapter'
+    description = f'{interface} Wi-Fi adapter {adapter["phy"]}'
+    if adapter['driver']:
+        description += f', driver {adapter["driver"]}'
+
+    classes = ['wifi']
+    wifi = {'phy': adapter['phy'], 'driver': adapter['driver'], 'interfaces': adapter['interfaces']}
+    if adapter['vendor-id'] and adapter['product-id']:
+        wifi['id'] = f'{adapter["vendor-id"].removeprefix("0x")}:{adapter["product-id"].removeprefix("0x")}'
+    if wiphy:
+        modes = sorted(wiphy['modes'])
+        wifi['bands'] = [band for band in BANDS.values() if band in wiphy['bands']]
+        wifi['standards'] = sorted({standard for standards in wiphy['bands'].values() for standard in standards})
+        wifi['modes'] = modes
+        wifi['monitor-mode'] = 'monitor' in modes
+        wifi['access-point'] = 'access-point' in modes
+        if wifi['monitor-mode']:
+            classes.append('monitor-mode')
+        if wifi['access-point']:
+            classes.append('access-point')
+        if wifi['bands']:
+            description += f', bands {" ".join(wifi["bands"])}'
+
+    peripheral = {
+        'identifier': adapter['mac'] or adapter['phy'],
+        'available': True,
+        'interface': interface,
+        'classes': classes,
+        'name': name,
+        'description': description,
+        'additional-assets': {'wifi': wifi}
+    }
+    if adapter['interfaces']:
+        peripheral['device-path'] = adapter['interfaces'][0]
+    if adapter['product']:
+        peripheral['product'] = adapter['product']
+    return peripheral
+
+
+def wireless_adapters(root: str | Path = SYSFS_IEEE80211, nl80211: NL80211 | None = None) -> dict[str, dict]:
+    """
+    :return: The local Wi-Fi adapters, as peripherals by identifier
+    """
+    adapters = read_sysfs_adapters(root)
+    if not adapters:
+        return {}
+
+    try:
+        wiphys = (nl80211 or NL80211()).wiphys()
+    except OSError as ex:
+        logger.debug(f'Cannot query nl80211, Wi-Fi adapters are reported without their capabilities: {ex}')
+        wiphys = {}
+
+    peripherals = {}
+    for adapter in adapters:
+        peripheral = format_adapter(adapter, wiphys.get(adapter['phy']))
+        peripherals[peripheral['identifier']] = peripheral
+    return peripherals
@@ -0,0 +1,120 @@
+"""
+On-disk queue of the peripheral operations that could not reach Nuvla
+"""
+import logging
+from pathlib import Path
+from typing import Callable, Literal
+
+import requests
+
+from nuvlaedge.common.file_operations import read_file, write_file
+from nuvlaedge.common.nuvlaedge_base_model import NuvlaEdgeBaseModel
+
+
+# Errors telling Nuvla cannot be reached, as opposed to Nuvla refusing the operation
+OFFLINE_ERRORS = (requests.exceptions.ConnectionError, requests.exceptions.Timeout, TimeoutError)
+
+
+class PendingOperation(NuvlaEdgeBaseModel):
+    operation: Literal['create', 'update', 'delete']
+    identifier: str
+    # Nuvla id of the peripheral resource. Unknown for resources created while offline
+    resource_id: str | None = None
+    data: dict | None = None
+
+
+class PendingOperationsQueue:
+    """
+    Keeps the peripheral operations made while Nuvla is unreachable, in order, and replays them once it is back.
+
+    Operations superseded by a later one on the same peripheral are merged when queued, so reconnecting after a long
+    offline period does not replay the whole history:
+     - updates of a peripheral created or updated while offline are merged into the pending operation
+     - deleting a peripheral created while offline drops both operations
+     - deleting a registered peripheral drops its pending updates
+    """
+
+    def __init__(self, file: str | Path):
+        self.logger: logging.Logger = logging.getLogger(self.__class__.__name__)
+        self.file: Path = Path(file)
+        self.operations: list[PendingOperation] = self.load()
+
+    def __len__(self):
+        return len(self.operations)
+
+    def load(self) -> list[PendingOperation]:
+        content = read_file(self.file, decode_json=True, warn_on_missing=False)
+        if not isinstance(content, list):
+            return []
+
+        operations = []
+        for operation in content:
+            try:
+                operations.append(PendingOperation.model_validate(operation))
+            except ValueError:
+                self.logger.warning(f'Ignoring invalid pending peripheral operation {operation}')
+        return operations
+
+    def save(self):
+        write_file([o.model_dump(by_alias=True, exclude_none=True) for o in self.operations], self.file, indent=4)
+
+    def _pending(self, identifier: str, operation: str) -> PendingOperation | None:
+        for o in reversed(self.operations):
+            if o.identifier == identifier:
+                return o if o.operation == operation else None
+        return None
+
+    def push(self, operation: PendingOperation):
+        """
+        Queues an operation, merging it with the pending operations it supersedes
+        :param operation: Operation that could not reach Nuvla
+        :return: None
+        """
+        if operation.operation == 'update':
+            pending = self._pending(operation.identifier, 'create') or self._pending(operation.identifier, 'update')
+            if pending:
+                pending.data = {**(pending.data or {}), **(operation.data or {})}
+                self.save()
+                return
+
+        elif operation.operation == 'delete':
+            created = self._pending(operation.identifier, 'create')
+            self.operations = [o for o in self.operations
+                               if not (o.identifier == operation.identifier and o.operation == 'update')]
+            if created:
+                self.operations.remove(created)
+                self.save()
+                return
+
+        elif operation.operation == 'create':
+            created = self._pending(operation.identifier, 'create')
+            if created:
+                created.data = operation.data
+                self.save()
+                return
+
+        self.operations.append(operation)
+        self.save()
+
+    def replay(self, execute: Callable[[PendingOperation], None]) -> int:
+        """
+        Replays the pending operations in order. Replaying stops at the first operation that still cannot reach Nuvla,
+        keeping it and the following ones for the next attempt. Operations refused by Nuvla are dropped.
+        :param execute: Function sending an operation to Nuvla
+        :return: Number of operations replayed
+        """
+        replayed = 0
+        while self.operations:
+            operation = self.operations[0]
+            try:
+                execute(operation)
+                replayed += 1
+            except OFFLINE_ERRORS:
+                break
+            except Exception as e:
+                self.logger.warning(f'Dropping pending {operation.operation} of peripheral {operation.identifier} '
+                                    f'refused by Nuvla: {e}')
+            self.operations.pop(0)
+            self.save()
+
+        return replayed
@@ -4,7 +4,7 @@
 import json
 import logging
 import time
-from typing import Set, List, Dict, Tuple
+from typing import Set, List, Dict, Tuple, Callable
 from datetime import datetime
 from copy import deepcopy
 
@@ -15,6 +15,8 @@ from nuvlaedge.models.nuvla_resources import NuvlaBoxPeripheralResource as Perip
 from nuvlaedge.common.constants import CTE
 from nuvlaedge.common.constant_files import FILE_NAMES
 from nuvlaedge.common.file_operations import write_file
+from nuvlaedge.peripherals.metadata import PeripheralMetadataWriter
+from nuvlaedge.peripherals.pending_operations import OFFLINE_ERRORS, PendingOperation, PendingOperationsQueue
 
 
 class PeripheralsDBManager:
@@ -34,6 +36,12 @@ class PeripheralsDBManager:
 
         self._last_synch: int = 0
 
+        # Operations made while Nuvla was unreachable, replayed in order once it is back
+        self.pending: PendingOperationsQueue = PendingOperationsQueue(FILE_NAMES.PENDING_PERIPHERAL_OPERATIONS)
+
+        # Metadata set in Nuvla, written back locally on every synchronization
+        self.metadata: PeripheralMetadataWriter = PeripheralMetadataWriter(FILE_NAMES.PERIPHERAL_METADATA_FOLDER)
+
     @property
     def content(self) -> Dict:
         """
@@ -42,7 +50,10 @@ class PeripheralsDBManager:
         :return:
         """
         if time.time() - self._last_synch > self.LOCAL_DB_SYNC_PERIOD:
-            self.synchronize()
+            # Remote database prevails, so it is only synchronized once the local changes have all reached it
+            self.replay_pending_operations()
+            if not self.pending:
+                self.synchronize()
             self._last_synch = time.time()
 
         return self._local_db
@@ -51,6 +62,12 @@ class PeripheralsDBManager:
     def keys(self) -> Set:
         return set(self.content.keys())
 
+    def get(self, identifier: str) -> PeripheralResource | None:
+        """
+        :return: The registered peripheral with the given identifier, as last known locally
+        """
+        return self._local_db.get(identifier)
+
     def synchronize(self):
         """
         Synchronizes the local database with the remote. Remote database will always prevail
@@ -65,6 +82,7 @@ class PeripheralsDBManager:
             self.logger.info('No resources registered in Nuvla to update')
             self._local_db = {}
             self._latest_update = {}
+            self.sync_metadata()
             return
 
         # Decode the NuvlaPeripherals into a dictionary following {'identifier': 'data'}
@@ -83,6 +101,16 @@ class PeripheralsDBManager:
                     self.logger.warning(f'Error retrieving peripheral data for deletion: {e}')
 
         self.update_local_storage()
+        self.sync_metadata()
+
+    def sync_metadata(self):
+        """
+        Writes the metadata of the peripheral resources, as just synchronized from Nuvla, for the edge applications
+        """
+        try:
+            self.metadata.sync(self._local_db)
+        except OSError as ex:
+            self.logger.warning(f'Unable to write the peripheral metadata to {self.metadata.folder}: {ex}')
 
     def update_local_storage(self):
         """
@@ -124,8 +152,17 @@ class PeripheralsDBManager:
         res.parent = self.uuid
         res.version = CTE.PERIPHERAL_SCHEMA_VERSION
 
-        # Add to remote,
-        peripheral_id, reg_status = self.add_remote_peripheral(res)
+        # Add to remote, or queue the registration while Nuvla is unreachable
+        result = self._send(lambda: self.add_remote_peripheral(res),
+                            operation='create',
+                            identifier=peripheral.identifier,
+                            data=res.model_dump(by_alias=True, exclude_none=True))
+        if result is None:
+            self._latest_update[peripheral.identifier] = datetime.now()
+            self.add_local_peripheral(res)
+            return
+
+        peripheral_id, reg_status = result
 
         if reg_status in [200, 201]:
             self.logger.info(f'Peripheral {peripheral.identifier} successfully registered in Nuvla')
@@ -173,9 +210,16 @@ class PeripheralsDBManager:
         :return: Status Response for the cimi delete action
         """
         # Remove from Nuvla extracting the Nuvlabox-peripheral ID from the corresponding field
-        del_status = self.remove_remote_peripheral(self.content.get(peripheral_id).id)
-
-        if del_status in [200, 201]:
+        resource_id = self.content.get(peripheral_id).id
+        del_status = self._send(lambda: self.remove_remote_peripheral(resource_id),
+                                operation='delete',
+                                identifier=peripheral_id,
+                                resource_id=resource_id)
+
+        if del_status is None:
+            self.logger.info(f'Peripheral "{peripheral_id}" removal queued for Nuvla, removing from local')
+            self.remove_local_peripheral(peripheral_id)
+        elif del_status in [200, 201]:
             self.logger.info(f'Peripheral "{peripheral_id}" successfully removed from Nuvla, removing from local')
             self.remove_local_peripheral(peripheral_id)
         else:
@@ -189,7 +233,7 @@ class PeripheralsDBManager:
         """
         # Add peripheral to local registry
         self._local_db.pop(peripheral_id)
-        self._latest_update.pop(peripheral_id)
+        self._latest_update.pop(peripheral_id, None)
 
     def remove_remote_peripheral(self, peripheral_res_id: str) -> int:
         """
@@ -203,6 +247,61 @@ class PeripheralsDBManager:
         self.logger.debug(f'Peripheral {peripheral_res_id} removed from Nuvla with status {p_status}')
         return p_status
 
+    def _send(self, send: Callable, **operation):
+        """
+        Sends an operation to Nuvla, or queues it if Nuvla is unreachable. Operations are also queued while older ones
+        are pending, so they reach Nuvla in order
+        :param send: Function sending the operation to Nuvla
+        :param operation: Attributes of the PendingOperation to queue
+        :return: The result of send, or None if the operation was queued
+        """
+        if not self.pending:
+            try:
+                return send()
+            except OFFLINE_ERRORS as e:
+                self.logger.warning(f'Nuvla unreachable, queueing the {operation["operation"]} of peripheral '
+                                    f'{operation["identifier"]}: {e}')
+
+        self.pending.push(PendingOperation(**operation))
+        return None
+
+    def _execute_pending(self, operation: PendingOperation):
+        """
+        Sends a queued operation to Nuvla
+        :param operation: Operation to replay
+        :return: None. Raises if Nuvla is unreachable or refuses the operation
+        """
+        match operation.operation:
+            case 'create':
+                response: CimiResponse = self.nuvla_client.add(CTE.PERIPHERAL_RES_NAME, operation.data)
+                status = response.data.get('status')
+                if status not in [200, 201]:
+                    raise ValueError(f'error code {status}')
+                if operation.identifier in self._local_db:
+                    self._local_db[operation.identifier].id = response.data.get('resource-id')
+            case 'update':
+                stored = self._local_db.get(operation.identifier)
+                resource_id = operation.resource_id or (stored.id if stored else None)
+                if not resource_id:
+                    raise ValueError('unknown resource id')
+                self.nuvla_client.edit(resource_id, data=operation.data)
+            case 'delete':
+                if operation.resource_id:
+                    self.nuvla_client.delete(operation.resource_id)
+
+    def replay_pending_operations(self):
+        """
+        Replays the operations queued while Nuvla was unreachable
+        :return: None
+        """
+        if not self.pending:
+            return
+
+        replayed = self.pending.replay(self._execute_pending)
+        self.logger.info(f'Replayed {replayed} pending peripheral operations, {len(self.pending)} left')
+        if replayed:
+            self.update_local_storage()
+
     def add(self, new_peripherals: Dict[str, PeripheralData]):
         """
 
@@ -270,3 +369,77 @@ class PeripheralsDBManager:
 
         self.logger.debug('After editing the local DB, backup to file')
         self.update_local_storage()
+
+    @staticmethod
+    def peripheral_changed(stored: PeripheralResource, detected: PeripheralData) -> bool:
+        """
+        Compares the attributes of a detected peripheral with the ones registered in Nuvla
+        :param stored: Peripheral resource registered in Nuvla
+        :param detected: Data of the detected peripheral
+        :return: True if any of the detected attributes differs from the registered one
+        """
+        for field, value in detected.model_dump(exclude_none=True).items():
+            if getattr(stored, field, None) != value:
+                return True
+        return False
+
+    def edit_peripheral(self, peripheral: PeripheralData):
+        """
+        Updates the registered peripheral with the detected data, both in local and remote registry
+        :param peripheral: Data of the detected peripheral
+        :return:
+        """
+        stored: PeripheralResource = self._local_db.get(peripheral.identifier)
+        changes = peripheral.model_dump(by_alias=True, exclude_none=True)
+
+        try:
+            self._send(lambda: self.nuvla_client.edit(stored.id, data=changes),
+                       operation='update',
+                       identifier=peripheral.identifier,
+                       resource_id=stored.id,
+                       data=changes)
+        except Exception as e:
+            self.logger.warning(f'Cannot update {peripheral.identifier} in Nuvla: {e}')
+            return
+
+        self.logger.info(f'Peripheral {peripheral.identifier} successfully updated in Nuvla')
+        self._local_db[peripheral.identifier] = stored.model_copy(update=peripheral.model_dump(exclude_none=True))
+        self._latest_update[peripheral.identifier] = datetime.now()
+
+    def reconcile(self, new_peripherals: Dict[str, PeripheralData],
+                  remove_stale: bool = True) -> Tuple[Set[str], Dict[str, PeripheralResource]]:
+        """
+        Converges the peripherals registered in Nuvla to the detected ones. Unlike the regular add, edit and remove
+        cycle, registered peripherals no longer detected are removed straight away: after a long offline period, the
+        Nuvla registry can hold any number of peripherals unplugged in the meantime.
+        :param new_peripherals: Peripherals detected by the first scan of every peripheral manager
+        :param remove_stale: Whether to remove the registered peripherals not detected. False when some manager did not
+            report, as its peripherals would be removed too
+        :return: The identifiers of the added peripherals, and the removed peripherals
+        """
+        self.replay_pending_operations()
+        if self.pending:
+            raise ConnectionError(f'{len(self.pending)} pending peripheral operations could not reach Nuvla')
+
+        self.synchronize()
+        self._last_synch = time.time()
+
+        registered = set(self._local_db.keys())
+        detected = set(new_peripherals.keys())
+        self.logger.info(f'Reconciling {len(registered)} registered peripherals with {len(detected)} detected ones')
+
+        removed = {i: self._local_db[i] for i in registered - detected} if remove_stale else {}
+        for identifier in removed:
+            self.remove_peripheral(identifier)
+
+        for identifier in registered & detected:
+            if self.peripheral_changed(self._local_db[identifier], new_peripherals[identifier]):
+                self.edit_peripheral(new_peripherals[identifier])
+            else:
+                self._latest_update[identifier] = datetime.now()
+
+        for identifier in detected - registered:
+            self.add_peripheral(new_peripherals[identifier])
+
+        self.update_local_storage()
+        return detected - registered, removed
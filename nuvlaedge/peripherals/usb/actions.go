@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/action"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/lock"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/registry"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/dfu"
+	log "github.com/sirupsen/logrus"
+)
+
+const ActionsPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/actions/"
+
+// Actions buildActionChannel knows how to enable
+var supportedActions = []string{
+	action.BarcodeTestReadAction,
+	action.ClaimAction,
+	action.ReleaseAction,
+	action.DFUFlashAction,
+	action.DriverUnbindAction,
+	action.DriverBindAction,
+	action.AudioLevelTestAction,
+	action.AutosuspendAction,
+}
+
+// buildActionChannel enables the actions listed in the configuration. It
+// returns nil when no action is enabled.
+func buildActionChannel(cfg Config, state *registry.Section, claims *lock.Registry, openDFU dfu.Opener) (*action.Channel, error) {
+	if len(cfg.Actions) == 0 {
+		return nil, nil
+	}
+
+	channel := action.NewChannel(cfg.ActionsPath, time.Second)
+	for _, name := range cfg.Actions {
+		switch name {
+		case action.BarcodeTestReadAction:
+			channel.Register(name, action.BarcodeTestRead(state.Lookup))
+		case action.ClaimAction:
+			channel.Register(name, action.Claim(claims, state.Lookup))
+		case action.ReleaseAction:
+			channel.Register(name, action.Release(claims))
+		case action.DFUFlashAction:
+			channel.Register(name, action.DFUFlash(state.Lookup, openDFU))
+		case action.DriverUnbindAction:
+			channel.Register(name, action.DriverUnbind(state.Lookup, cfg.SysfsDir))
+		case action.DriverBindAction:
+			channel.Register(name, action.DriverBind(state.Lookup, cfg.SysfsDir))
+		case action.AudioLevelTestAction:
+			channel.Register(name, action.AudioLevelTest(state.Lookup, cfg.SysfsDir))
+		case action.AutosuspendAction:
+			channel.Register(name, action.Autosuspend(state.Lookup, cfg.SysfsDir))
+		default:
+			return nil, fmt.Errorf("unknown action %q", name)
+		}
+	}
+	return channel, nil
+}
+
+func runActionChannel(ctx context.Context, channel *action.Channel) {
+	if err := channel.Run(ctx); err != nil && ctx.Err() == nil {
+		log.Errorf("Action channel stopped. Reason: %s", err)
+	}
+}
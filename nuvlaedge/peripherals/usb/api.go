@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/changes"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/registry"
+	log "github.com/sirupsen/logrus"
+)
+
+// serveAPI serves the changes of the journal and the metrics of the registry
+// to the agent until ctx is done
+func serveAPI(ctx context.Context, cfg Config, journal *changes.Journal, shared *registry.Registry, background *sync.WaitGroup) {
+	mux := http.NewServeMux()
+	mux.Handle(changes.DiffPath, changes.Handler(journal))
+	mux.Handle(registry.MetricsPath, registry.Handler(shared))
+	server := &http.Server{
+		Addr:              cfg.APIListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		log.Infof("Serving the peripheral changes on %s%s", cfg.APIListen, changes.DiffPath)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Unable to serve the peripheral changes. Reason: %s", err)
+		}
+	}()
+	go func() {
+		defer background.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+}
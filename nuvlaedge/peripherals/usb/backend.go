@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/dfu"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/replay"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/simulate"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/sysfs"
+	log "github.com/sirupsen/logrus"
+)
+
+// Values of the backend setting
+const (
+	backendAuto   = "auto"
+	backendLibusb = "libusb"
+	backendSysfs  = "sysfs"
+)
+
+// How long the first listing through libusb may take before auto falls back
+// to sysfs
+const backendProbeTimeout = 10 * time.Second
+
+// supportedBackends lists the backends of a build with or without libusb
+func supportedBackends(libusb bool) []string {
+	if libusb {
+		return []string{backendAuto, backendLibusb, backendSysfs}
+	}
+	return []string{backendAuto, backendSysfs}
+}
+
+// usbBackend is what the manager needs from the USB stack on top of listing
+// the devices
+type usbBackend interface {
+	peripherals.Backend
+	OnVisit(f peripherals.VisitFunc)
+	OpenDFU(bus int, address int) (dfu.Device, error)
+}
+
+// openBackend opens the configured USB stack. In auto mode, libusb is tried
+// first and sysfs takes over when it cannot be initialised or fails to list
+// any device, so hosts without a usable libusb still get their peripherals
+// reported, only without firmware updates and with the names the devices
+// give themselves.
+func openBackend(ctx context.Context, cfg Config) (usbBackend, string, error) {
+	switch cfg.Backend {
+	case backendLibusb:
+		backend, err := openLibusb()
+		if err != nil {
+			return nil, "", err
+		}
+		return backend, backendLibusb, nil
+	case backendSysfs:
+		backend, err := sysfs.New(cfg.SysfsDir)
+		if err != nil {
+			return nil, "", err
+		}
+		return backend, backendSysfs, nil
+	case backendAuto:
+	default:
+		return nil, "", fmt.Errorf("unknown USB backend %q", cfg.Backend)
+	}
+
+	if !libusbAvailable {
+		backend, err := sysfs.New(cfg.SysfsDir)
+		if err != nil {
+			return nil, "", err
+		}
+		return backend, backendSysfs, nil
+	}
+
+	backend, err := openLibusb()
+	if err == nil {
+		probeCtx, cancel := context.WithTimeout(ctx, backendProbeTimeout)
+		devices, probeErr := backend.Devices(probeCtx)
+		cancel()
+		if probeErr == nil || len(devices) > 0 {
+			return backend, backendLibusb, nil
+		}
+		backend.Close()
+		err = probeErr
+	}
+
+	fallback, sysfsErr := sysfs.New(cfg.SysfsDir)
+	if sysfsErr != nil {
+		return nil, "", fmt.Errorf("%v, and %w", err, sysfsErr)
+	}
+	log.Warnf("Unable to list the USB devices with libusb, listing them from %s instead. Reason: %s", cfg.SysfsDir, err)
+	return fallback, backendSysfs, nil
+}
+
+// isolatedOptions gives the discoverer empty device, sysfs and proc folders,
+// so the devices of the host are neither probed nor mixed with the ones
+// simulated or replayed, and the prober answering their serial numbers. The
+// caller removes the folder returned.
+func isolatedOptions(prober peripherals.Prober) ([]peripherals.Option, string, error) {
+	dir, err := ioutil.TempDir("", "nuvlaedge-isolated")
+	if err != nil {
+		return nil, "", err
+	}
+	options := []peripherals.Option{
+		peripherals.WithProber(prober),
+		peripherals.WithDevDir(dir + "/"),
+		peripherals.WithSysfsDir(dir),
+		peripherals.WithProcDir(dir + "/"),
+	}
+	return options, dir, nil
+}
+
+// openSimulation starts the scenario of the simulated peripherals
+func openSimulation(cfg Config) (*simulate.Backend, []peripherals.Option, string, error) {
+	scenario, err := simulate.Load(cfg.Simulate)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	backend, err := simulate.New(scenario)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	options, dir, err := isolatedOptions(backend)
+	return backend, options, dir, err
+}
+
+// openReplay loads the recording replayed
+func openReplay(cfg Config) (*replay.Backend, []peripherals.Option, string, error) {
+	backend, err := replay.Load(cfg.Replay)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	options, dir, err := isolatedOptions(backend)
+	return backend, options, dir, err
+}
+
+// recordingBackend records the listings of the USB stack with the serial
+// numbers the recorder looks up
+type recordingBackend struct {
+	usbBackend
+	recorder *replay.Recorder
+}
+
+func (b recordingBackend) Devices(ctx context.Context) ([]peripherals.Device, error) {
+	return b.recorder.Devices(ctx)
+}
+
+func (b recordingBackend) Close() error {
+	if err := b.recorder.Close(); err != nil {
+		log.Errorf("Unable to close the recording of the discovery session. Reason: %s", err)
+	}
+	return b.usbBackend.Close()
+}
+
+// recordBackend records the session of the backend in cfg.Record. The
+// recorder is the prober of the discoverer, so the serial numbers are
+// recorded too.
+func recordBackend(cfg Config, backend usbBackend, backendName string) (recordingBackend, *replay.Recorder, error) {
+	recorder, err := replay.NewRecorder(cfg.Record, backend, backendName,
+		peripherals.NewCachingProber(peripherals.UdevadmProber{}))
+	if err != nil {
+		return recordingBackend{}, nil, err
+	}
+	return recordingBackend{usbBackend: backend, recorder: recorder}, recorder, nil
+}
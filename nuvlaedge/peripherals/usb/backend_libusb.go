@@ -0,0 +1,16 @@
+//go:build cgo && !nolibusb
+// +build cgo,!nolibusb
+
+package main
+
+import "github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/libusb"
+
+const libusbAvailable = true
+
+func openLibusb() (usbBackend, error) {
+	backend, err := libusb.New()
+	if err != nil {
+		return nil, err
+	}
+	return backend, nil
+}
@@ -0,0 +1,21 @@
+//go:build !cgo || nolibusb
+// +build !cgo nolibusb
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// Builds without cgo, or with the nolibusb tag, only list the devices from
+// sysfs. They link no C library, so the binary is fully static and runs from
+// scratch images and on the architectures libusb is not packaged for:
+//
+//	CGO_ENABLED=0 go build -tags nolibusb -o nuvlaedge
+const libusbAvailable = false
+
+func openLibusb() (usbBackend, error) {
+	return nil, fmt.Errorf("%w: this build of the peripheral manager has no libusb support", peripherals.ErrNoContext)
+}
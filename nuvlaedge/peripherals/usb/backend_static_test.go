@@ -0,0 +1,47 @@
+//go:build !cgo || nolibusb
+// +build !cgo nolibusb
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// The fixture holds the device folders of a root hub, a webcam and a device
+// being removed. Their interface folders are left out, module files cannot be
+// named with the colons of 1-1.2:1.0.
+const sysfsFixture = "testdata/sysfs"
+
+func TestStaticBackend(t *testing.T) {
+	for _, name := range []string{backendAuto, backendSysfs} {
+		backend, opened, err := openBackend(context.Background(), Config{Backend: name, SysfsDir: sysfsFixture})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if opened != backendSysfs {
+			t.Errorf("%s: expected the sysfs backend, got %s", name, opened)
+		}
+
+		devices, err := backend.Devices(context.Background())
+		backend.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(devices) != 2 {
+			t.Fatalf("%s: expected the root hub and the webcam, got %+v", name, devices)
+		}
+		webcam := devices[1]
+		if webcam.Identifier() != "046d:0825" || webcam.Bus != 1 || webcam.Address != 12 ||
+			webcam.VendorName != "Logitech" || webcam.ProductName != "Webcam C270" {
+			t.Errorf("%s: unexpected webcam %+v", name, webcam)
+		}
+	}
+
+	if _, _, err := openBackend(context.Background(), Config{Backend: backendLibusb}); !errors.Is(err, peripherals.ErrNoContext) {
+		t.Errorf("expected the libusb backend to be missing, got %v", err)
+	}
+}
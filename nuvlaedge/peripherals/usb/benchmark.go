@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// latencyReport summarises the samples of one scan phase
+type latencyReport struct {
+	P50 string `json:"p50"`
+	P90 string `json:"p90"`
+	P99 string `json:"p99"`
+	Max string `json:"max"`
+}
+
+type benchmarkReport struct {
+	Iterations  int           `json:"iterations"`
+	Devices     int           `json:"devices"`
+	Enumeration latencyReport `json:"enumeration"`
+	Udev        latencyReport `json:"udev"`
+	Write       latencyReport `json:"write"`
+	Total       latencyReport `json:"total"`
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func summarise(samples []time.Duration) latencyReport {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return latencyReport{
+		P50: percentile(sorted, 50).String(),
+		P90: percentile(sorted, 90).String(),
+		P99: percentile(sorted, 99).String(),
+		Max: percentile(sorted, 100).String(),
+	}
+}
+
+// runBenchmark runs the discovery the given number of times with the scan
+// budget disabled, and prints the latency percentiles of each phase. Reports
+// are written to a temporary channel so the agent does not consume them. The
+// udev lookups are not cached, every iteration measures real udevadm runs.
+func runBenchmark(ctx context.Context, backend peripherals.Backend, cfg Config, iterations int) {
+	if iterations <= 0 {
+		log.Fatalf("Benchmark requires a positive number of iterations, got %d", iterations)
+	}
+
+	channel, err := os.MkdirTemp("", "usb-benchmark-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(channel)
+
+	discoverer := peripherals.NewDiscoverer(backend, peripherals.WithProber(peripherals.UdevadmProber{}))
+	report := benchmark(ctx, discoverer, cfg, channel, iterations)
+	bReport, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(bReport))
+}
+
+// benchmark runs the discovery the given number of times, writing the reports
+// to channel, and summarises the latencies of each phase
+func benchmark(ctx context.Context, discoverer *peripherals.Discoverer, cfg Config, channel string, iterations int) benchmarkReport {
+	fileSink := &sink.FileSink{Dir: channel, Sender: PeripheralName}
+	var enumeration, udev, write, total []time.Duration
+	devices := 0
+
+	log.Infof("Running USB discovery benchmark over %d iterations", iterations)
+	for i := 0; i < iterations && ctx.Err() == nil; i++ {
+		discovered, devErr := discoverer.Discover(ctx)
+		stats := discoverer.Stats()
+		if devErr != nil {
+			log.Errorf("A problem occurred while listing the USB peripherals %s. Continuing...", devErr)
+		}
+
+		writeStart := time.Now()
+		message := buildMessage(discovered, cfg, nil, nil)
+		if err := fileSink.Send(ctx, sink.Report{Time: time.Now(), Peripherals: message}); err != nil {
+			log.Errorf("Unable to write benchmark report. Reason: %s", err)
+		}
+		written := time.Since(writeStart)
+
+		enumeration = append(enumeration, stats.Enumeration)
+		udev = append(udev, stats.Udev)
+		write = append(write, written)
+		total = append(total, stats.Duration+written)
+		devices = len(message)
+	}
+
+	return benchmarkReport{
+		Iterations:  iterations,
+		Devices:     devices,
+		Enumeration: summarise(enumeration),
+		Udev:        summarise(udev),
+		Write:       summarise(write),
+		Total:       summarise(total),
+	}
+}
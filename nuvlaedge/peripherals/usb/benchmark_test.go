@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+type benchmarkBackend struct {
+	devices []peripherals.Device
+}
+
+func (b *benchmarkBackend) Devices(ctx context.Context) ([]peripherals.Device, error) {
+	return b.devices, nil
+}
+
+func (b *benchmarkBackend) Close() error {
+	return nil
+}
+
+type slowProber struct {
+	delay time.Duration
+	calls int
+}
+
+func (p *slowProber) SerialNumber(ctx context.Context, devicePath string) string {
+	p.calls++
+	time.Sleep(p.delay)
+	return ""
+}
+
+func webcams(n int) *benchmarkBackend {
+	backend := &benchmarkBackend{}
+	for i := 0; i < n; i++ {
+		backend.devices = append(backend.devices, peripherals.Device{
+			Bus: 1, Address: 4 + i, VendorID: 0x046d, ProductID: uint16(0x0825 + i),
+			VendorName: "Logitech, Inc.", ProductName: "Webcam C270",
+			Interfaces: []peripherals.InterfaceSetting{{Number: 0, Class: 0x0e, ClassName: "Video"}},
+		})
+	}
+	return backend
+}
+
+func TestScanBudgetStopsDeepProbing(t *testing.T) {
+	prober := &slowProber{delay: 20 * time.Millisecond}
+	d := peripherals.NewDiscoverer(webcams(4), peripherals.WithProber(prober),
+		peripherals.WithScanBudget(10*time.Millisecond), peripherals.WithDevDir(t.TempDir()))
+
+	discovered, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discovered) != 4 {
+		t.Errorf("expected every device to be reported, got %d", len(discovered))
+	}
+	// The first probe spends the budget, none of the next devices is probed
+	if stats := d.Stats(); prober.calls != 1 || stats.DeepProbed != 1 || stats.DeepProbeSkipped != 3 {
+		t.Errorf("expected 1 device probed and 3 skipped, got %d probes, %d probed and %d skipped",
+			prober.calls, stats.DeepProbed, stats.DeepProbeSkipped)
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	prober := &slowProber{}
+	d := peripherals.NewDiscoverer(webcams(2), peripherals.WithProber(prober), peripherals.WithDevDir(t.TempDir()))
+
+	report := benchmark(context.Background(), d, Config{}, t.TempDir(), 3)
+	if prober.calls != 6 {
+		t.Errorf("expected every device to be probed in every iteration, got %d probes", prober.calls)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output map[string]interface{}
+	if err := json.Unmarshal(data, &output); err != nil {
+		t.Fatal(err)
+	}
+	if output["iterations"] != float64(3) || output["devices"] != float64(2) {
+		t.Errorf("unexpected iterations or devices in %s", data)
+	}
+	for _, phase := range []string{"enumeration", "udev", "write", "total"} {
+		latencies, ok := output[phase].(map[string]interface{})
+		if !ok {
+			t.Errorf("expected the %s latencies in %s", phase, data)
+			continue
+		}
+		var previous time.Duration
+		for _, p := range []string{"p50", "p90", "p99", "max"} {
+			value, _ := latencies[p].(string)
+			latency, err := time.ParseDuration(value)
+			if err != nil {
+				t.Errorf("expected a duration as %s %s, got %q", phase, p, value)
+				continue
+			}
+			if latency < previous {
+				t.Errorf("expected %s %s of %s not to be below the previous percentile %s", phase, p, latency, previous)
+			}
+			previous = latency
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var samples []time.Duration
+	for i := 1; i <= 10; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	if p := percentile(samples, 50); p != 5*time.Millisecond {
+		t.Errorf("expected a p50 of 5ms, got %s", p)
+	}
+	if p := percentile(samples, 100); p != 10*time.Millisecond {
+		t.Errorf("expected a max of 10ms, got %s", p)
+	}
+	if p := percentile(nil, 90); p != 0 {
+		t.Errorf("expected no latency without samples, got %s", p)
+	}
+}
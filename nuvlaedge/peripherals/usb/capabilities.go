@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/privacy"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+const CapabilitiesPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/capabilities.json"
+
+// capabilitiesSchema is bumped whenever the layout of the capabilities
+// document changes in a way readers must know about
+const capabilitiesSchema = 1
+
+// Version of the manager, set at build time with
+// -ldflags "-X main.Version=2.14.0". Falls back to the module version.
+var Version = ""
+
+// Sink kinds buildSinks knows how to create
+var supportedSinks = []string{"file", "mqtt", "rest", "socket"}
+
+// capabilitiesDocument tells the agent what this build of the manager
+// supports, so it can adapt to older or newer managers. Every feature of the
+// build is listed in features, mapped to whether the configuration enables
+// it.
+type capabilitiesDocument struct {
+	Schema   int             `json:"schema"`
+	Manager  string          `json:"manager"`
+	Version  string          `json:"version"`
+	Platform string          `json:"platform"`
+	Features map[string]bool `json:"features"`
+
+	// Supported values, followed by the ones the configuration enables
+	Actions        []string `json:"actions"`
+	EnabledActions []string `json:"enabled-actions"`
+	Sinks          []string `json:"sinks"`
+	EnabledSinks   []string `json:"enabled-sinks"`
+	PrivacyModes   []string `json:"privacy-modes"`
+	ClusterRoles   []string `json:"cluster-roles"`
+	ScanModes      []string `json:"scan-modes"`
+	ReportFormats  []string `json:"report-formats"`
+	BufferLayouts  []string `json:"buffer-layouts"`
+	FrameEncodings []string `json:"frame-encodings"`
+	Backends       []string `json:"backends"`
+
+	// Schemas are the JSON schemas of the typed sections of the records
+	Schemas map[string]interface{} `json:"schemas"`
+}
+
+func buildVersion() string {
+	if len(Version) > 0 {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+func buildCapabilities(cfg Config) capabilitiesDocument {
+	return capabilitiesOf(cfg, libusbAvailable)
+}
+
+// capabilitiesOf builds the capabilities document of a build with or without
+// libusb, the static builds only listing the devices from sysfs
+func capabilitiesOf(cfg Config, libusb bool) capabilitiesDocument {
+	features := map[string]bool{
+		// Devices are listed by polling, there is no udev event monitor
+		"hotplug":           false,
+		"scan-budget":       cfg.ScanBudget > 0,
+		"scan-timeout":      cfg.ScanTimeout > 0,
+		"adaptive-scan":     cfg.ScanIntervalActive > 0 && cfg.ScanIntervalActive < cfg.ScanInterval,
+		"clean-shutdown":    true,
+		"self-test":         cfg.SelfTest,
+		"shallow-scans":     cfg.DeepScanEvery > 1,
+		"topology-cache":    cfg.TopologyCache && len(cfg.Simulate) == 0 && len(cfg.Replay) == 0 && len(cfg.Record) == 0,
+		"energy-saving":     cfg.BatteryScanInterval > 0,
+		"video-probing":     true,
+		"serial-probing":    true,
+		"dvb-probing":       true,
+		"capture-cards":     true,
+		"depth-cameras":     true,
+		"usb-c":             true,
+		"hid-probing":       true,
+		"class-details":     true,
+		"storage-safety":    true,
+		"cellular-modems":   true,
+		"holders":           cfg.ReportHolders,
+		"circuit-breaker":   cfg.SinkFailureThreshold > 0,
+		"firmware":          true,
+		"p1-smart-meters":   cfg.P1ProbeTimeout > 0,
+		"gnss-rtk":          cfg.GNSSProbeTimeout > 0,
+		"overrides":         true,
+		"port-locations":    true,
+		"exec-plugins":      len(cfg.PluginsPath) > 0 && !cfg.PluginsWASMOnly,
+		"wasm-plugins":      len(cfg.PluginsPath) > 0,
+		"claims":            true,
+		"privacy":           len(cfg.Privacy) > 0,
+		"cluster":           len(cfg.ClusterRole) > 0,
+		"diagnostics":       true,
+		"audit-log":         len(cfg.AuditLogPath) > 0,
+		"maintenance":       len(cfg.MaintenancePath) > 0,
+		"uptime":            len(cfg.UptimePath) > 0,
+		"health":            cfg.HealthWindow > 0,
+		"over-current":      cfg.HealthWindow > 0,
+		"single-instance":   len(cfg.InstanceLockPath) > 0,
+		"snapshot":          len(cfg.SnapshotPath) > 0,
+		"status":            true,
+		"config-file":       true,
+		"remote-config":     len(cfg.RemoteConfigPath) > 0,
+		"diff-api":          len(cfg.APIListen) > 0,
+		"metrics-api":       len(cfg.APIListen) > 0,
+		"benchmark":         true,
+		"sysfs-fallback":    cfg.Backend == backendAuto && libusb,
+		"libusb":            libusb,
+		"bandwidth-advisor": true,
+		"quirks":            true,
+		"kernel-drivers":    true,
+		"host-critical":     true,
+		"security-tokens":   true,
+		"buffer-owner":      len(cfg.BufferOwner) > 0,
+		"buffer-backlog":    cfg.BacklogStallAfter > 0,
+		"latency-tracking":  cfg.LatencySamples > 0,
+		"class-throttles":   len(cfg.ClassThrottles) > 0,
+		"removal-grace":     cfg.RemovalGracePeriod > 0,
+		"hardware-census":   cfg.CensusInterval > 0 && len(cfg.CensusPath) > 0,
+		"hardware-bom":      cfg.BOMInterval > 0 && len(cfg.BOMPath) > 0,
+		"container-devices": len(cfg.DeploymentsSource) > 0,
+		"path-remediation":  len(cfg.DeploymentsSource) > 0 && len(cfg.RemediationsPath) > 0,
+		"simulation":        len(cfg.Simulate) > 0,
+		"recording":         len(cfg.Record) > 0,
+		"replay":            len(cfg.Replay) > 0,
+	}
+	// The uvcvideo driver controls are only queried on Linux
+	if runtime.GOOS == "linux" {
+		features["uvc-controls"] = true
+	}
+
+	actions := append([]string{}, supportedActions...)
+	sort.Strings(actions)
+
+	return capabilitiesDocument{
+		Schema:         capabilitiesSchema,
+		Manager:        PeripheralName,
+		Version:        buildVersion(),
+		Platform:       runtime.GOOS + "/" + runtime.GOARCH,
+		Features:       features,
+		Actions:        actions,
+		EnabledActions: append([]string{}, cfg.Actions...),
+		Sinks:          supportedSinks,
+		EnabledSinks:   cfg.Sinks,
+		PrivacyModes:   []string{privacy.ModeHash, privacy.ModeTruncate},
+		ClusterRoles:   []string{"member", "leader"},
+		ScanModes:      []string{"deep", "shallow"},
+		ReportFormats:  []string{"nuvlabox-peripheral"},
+		BufferLayouts:  []string{sink.LayoutNative, sink.LayoutLegacy, sink.LayoutBatch},
+		FrameEncodings: sink.Encodings,
+		Backends:       supportedBackends(libusb),
+		Schemas:        map[string]interface{}{"details": peripherals.DetailsSchema()},
+	}
+}
+
+// writeCapabilities publishes the capabilities document next to the status
+// fragment, replacing it atomically
+func writeCapabilities(path string, document capabilitiesDocument) error {
+	bData, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bData, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
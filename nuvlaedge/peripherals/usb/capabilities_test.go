@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readCapabilities writes the document as the manager does and reads it back
+// as the agent would
+func readCapabilities(t *testing.T, document capabilitiesDocument) map[string]interface{} {
+	path := filepath.Join(t.TempDir(), "usb", "capabilities.json")
+	if err := writeCapabilities(path, document); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var capabilities map[string]interface{}
+	if err := json.Unmarshal(data, &capabilities); err != nil {
+		t.Fatalf("expected a JSON document, got %s: %s", data, err)
+	}
+	return capabilities
+}
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		name     string
+		libusb   bool
+		backends []interface{}
+		fallback bool
+	}{
+		{"libusb build", true, []interface{}{backendAuto, backendLibusb, backendSysfs}, true},
+		{"sysfs-only build", false, []interface{}{backendAuto, backendSysfs}, false},
+	}
+	for _, test := range tests {
+		capabilities := readCapabilities(t, capabilitiesOf(Config{Backend: backendAuto}, test.libusb))
+
+		if capabilities["schema"] != float64(capabilitiesSchema) || capabilities["manager"] != PeripheralName {
+			t.Errorf("%s: unexpected schema or manager in %v", test.name, capabilities)
+		}
+		if !reflect.DeepEqual(capabilities["backends"], test.backends) {
+			t.Errorf("%s: expected the backends %v, got %v", test.name, test.backends, capabilities["backends"])
+		}
+		features, ok := capabilities["features"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: expected the features, got %v", test.name, capabilities["features"])
+		}
+		if features["libusb"] != test.libusb || features["sysfs-fallback"] != test.fallback {
+			t.Errorf("%s: expected libusb %v and sysfs-fallback %v, got %v and %v", test.name, test.libusb,
+				test.fallback, features["libusb"], features["sysfs-fallback"])
+		}
+		if _, ok := capabilities["schemas"].(map[string]interface{})["details"]; !ok {
+			t.Errorf("%s: expected the schema of the details", test.name)
+		}
+	}
+
+	// This build reports what it was built with
+	features := readCapabilities(t, buildCapabilities(Config{Backend: backendAuto}))["features"].(map[string]interface{})
+	if features["libusb"] != libusbAvailable {
+		t.Errorf("expected libusb %v for this build, got %v", libusbAvailable, features["libusb"])
+	}
+}
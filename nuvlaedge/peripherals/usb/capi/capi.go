@@ -0,0 +1,104 @@
+// Command capi builds the USB discovery as a C shared library, for the hosts
+// where running the USB peripheral manager in its own container is too heavy.
+// The Python agent loads it with ctypes and discovers the USB peripherals in
+// process:
+//
+//	go build -buildmode=c-shared -o libnuvlaedgeusb.so ./capi
+//
+// The library exports two functions:
+//
+//	char *nuvlaedge_usb_discover(int timeout_ms);
+//	void nuvlaedge_usb_free(char *result);
+//
+// The discovery returns a JSON object with the peripherals by identifier, as
+// reported by the manager, and the error of the scan if any:
+//
+//	{"peripherals": {"046d:0825": {...}}, "error": "..."}
+//
+// The timeout bounds each call: the udev probing stops when the call spends
+// it, and the scan is cancelled then. Zero or less disables the limit. The
+// result must be released with nuvlaedge_usb_free. The libusb context and the
+// probe caches are kept between the calls, and the calls are serialized.
+package main
+
+// #include <stdlib.h>
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/libusb"
+)
+
+// result is the JSON returned by nuvlaedge_usb_discover
+type result struct {
+	Peripherals map[string]peripherals.Peripheral `json:"peripherals"`
+	Error       string                            `json:"error,omitempty"`
+}
+
+var (
+	mu         sync.Mutex
+	discoverer *peripherals.Discoverer
+)
+
+// discover runs a scan, opening libusb on the first one. Panics are turned
+// into errors, they must not bring down the process loading the library.
+func discover(timeout time.Duration) (r result) {
+	r.Peripherals = map[string]peripherals.Peripheral{}
+	defer func() {
+		if p := recover(); p != nil {
+			r.Error = fmt.Sprintf("USB discovery panicked: %v", p)
+		}
+	}()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if discoverer == nil {
+		backend, err := libusb.New()
+		if err != nil {
+			r.Error = err.Error()
+			return r
+		}
+		discoverer = peripherals.NewDiscoverer(backend)
+	}
+	discoverer.SetScanBudget(timeout)
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	discovered, err := discoverer.Discover(ctx)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	for _, peripheral := range discovered {
+		r.Peripherals[peripheral.Identifier] = peripheral
+	}
+	return r
+}
+
+//export nuvlaedge_usb_discover
+func nuvlaedge_usb_discover(timeoutMS C.int) *C.char {
+	bData, err := json.Marshal(discover(time.Duration(timeoutMS) * time.Millisecond))
+	if err != nil {
+		bData, _ = json.Marshal(result{Peripherals: map[string]peripherals.Peripheral{}, Error: err.Error()})
+	}
+	return C.CString(string(bData))
+}
+
+//export nuvlaedge_usb_free
+func nuvlaedge_usb_free(r *C.char) {
+	C.free(unsafe.Pointer(r))
+}
+
+// main is required by the c-shared build mode, it is never called
+func main() {}
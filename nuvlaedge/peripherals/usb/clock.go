@@ -0,0 +1,67 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// clockJumpThreshold is how far the wall clock may drift from the monotonic
+// clock before the jump is reported
+const clockJumpThreshold = 2 * time.Second
+
+// clockStatus is reported along the status when the times of the reports
+// cannot be trusted: the system clock is not synchronized, or it jumped while
+// the manager was running
+type clockStatus struct {
+	// Synchronized is unset when the synchronization state is unknown
+	Synchronized *bool `json:"synchronized,omitempty"`
+	// MaxError is the maximum error of the clock, in seconds, as estimated by
+	// the kernel
+	MaxError float64 `json:"max-error,omitempty"`
+	// Jump is how far, in seconds, the wall clock moved away from the
+	// monotonic clock since the manager started, as when NTP steps the clock
+	// of a device that booted without network
+	Jump float64 `json:"jump"`
+}
+
+// clockWatcher follows the wall clock against the monotonic clock
+type clockWatcher struct {
+	started  time.Time
+	reported bool
+}
+
+func newClockWatcher() *clockWatcher {
+	return &clockWatcher{started: time.Now()}
+}
+
+// status returns the state of the clock, or nil when it is synchronized and
+// did not jump
+func (c *clockWatcher) status() *clockStatus {
+	now := time.Now()
+	// Round(0) drops the monotonic reading, leaving the wall clock
+	jump := now.Round(0).Sub(c.started.Round(0)) - now.Sub(c.started)
+
+	synchronized, maxError, known := clockSynchronized()
+	if (!known || synchronized) && math.Abs(float64(jump)) < float64(clockJumpThreshold) {
+		c.reported = false
+		return nil
+	}
+
+	status := &clockStatus{Jump: jump.Seconds()}
+	if known {
+		status.Synchronized = &synchronized
+		status.MaxError = maxError.Seconds()
+	}
+	if !c.reported {
+		if known && !synchronized {
+			log.Warnf("The system clock is not synchronized, report times may be off")
+		}
+		if math.Abs(float64(jump)) >= float64(clockJumpThreshold) {
+			log.Warnf("The system clock jumped by %s since the manager started", jump)
+		}
+		c.reported = true
+	}
+	return status
+}
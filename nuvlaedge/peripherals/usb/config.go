@@ -0,0 +1,506 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/deployments"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/health"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/settings"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/sysfs"
+	log "github.com/sirupsen/logrus"
+)
+
+const OverridesPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/overrides/"
+const PortLocationsPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/ports.yaml"
+const PluginsPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/plugins.d/"
+const LocksPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/locks/"
+const ClusterPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/cluster/"
+const AuditLogPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/audit.jsonl"
+const SnapshotPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/latest.json"
+const UptimePath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/uptime.json"
+const CensusPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/census.json"
+const BOMPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/hardware-bom.cdx.json"
+const BOMKeyPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/bom.key"
+const InstanceLockPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/manager.lock"
+const SocketPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/manager.sock"
+const QuirksPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/quirks"
+const MaintenancePath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/maintenance"
+const RemediationsPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/remediations.json"
+
+// RemoteConfigPath is where the agent writes the configuration of the
+// peripheral managers pushed from Nuvla, with the sections of the
+// configuration file
+const RemoteConfigPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + "remote_config.json"
+
+// Config holds the tunable settings of the USB peripheral manager. Every field
+// can be set in the usb section of the peripherals configuration file, or of
+// the configuration pushed from Nuvla, and overridden from the environment of
+// the peripheral container.
+type Config struct {
+	ScanInterval         time.Duration `json:"scan-interval"`
+	MaxConsecutivePanics int           `json:"max-consecutive-panics"`
+	DiagnosticsPath      string        `json:"diagnostics-path"`
+	StatusPath           string        `json:"status-path"`
+	CapabilitiesPath     string        `json:"capabilities-path"`
+	MaxDiagnosticBundles int           `json:"max-diagnostic-bundles"`
+	SelfTest             bool          `json:"self-test"`
+	OverridesPath        string        `json:"overrides-path"`
+	// Locations of the USB ports, see the location package
+	PortLocationsPath string        `json:"port-locations-path"`
+	LocksPath         string        `json:"locks-path"`
+	ScanBudget        time.Duration `json:"scan-budget"`
+	ScanTimeout       time.Duration `json:"scan-timeout"`
+	DeepScanEvery     int           `json:"deep-scan-every"`
+	TopologyCache     bool          `json:"topology-cache"`
+	P1ProbeTimeout    time.Duration `json:"p1-probe-timeout"`
+	GNSSProbeTimeout  time.Duration `json:"gnss-probe-timeout"`
+	ReportHolders     bool          `json:"report-holders"`
+	Privacy           string        `json:"privacy"`
+	PrivacySalt       string        `json:"-"`
+
+	// Interval of the scans following a change, and for how long, before
+	// backing off to the scan interval. A zero interval disables it.
+	ScanIntervalActive time.Duration `json:"scan-interval-active"`
+	ScanActivePeriod   time.Duration `json:"scan-active-period"`
+
+	// Shortest interval of the shallow scans while the host runs on battery,
+	// disabled when zero, and the one below the low battery capacity
+	BatteryScanInterval    time.Duration `json:"battery-scan-interval"`
+	BatteryLowCapacity     int           `json:"battery-low-capacity"`
+	BatteryLowScanInterval time.Duration `json:"battery-low-scan-interval"`
+
+	// USB stack the devices are listed from: libusb, sysfs, or auto to fall
+	// back to sysfs when libusb is unusable
+	Backend  string `json:"backend"`
+	SysfsDir string `json:"sysfs-dir"`
+
+	// Scenario file of the simulated peripherals listed instead of the
+	// attached ones, disabled when empty
+	Simulate string `json:"simulate"`
+
+	// File the discovery session is recorded in, and the recording replayed
+	// instead of listing the attached devices, disabled when empty
+	Record string `json:"record"`
+	Replay string `json:"replay"`
+
+	// Log of the reported changes, disabled when the path is empty
+	AuditLogPath     string `json:"audit-log-path"`
+	AuditLogMaxSize  int    `json:"audit-log-max-size"`
+	AuditLogMaxFiles int    `json:"audit-log-max-files"`
+
+	// Address of the HTTP API serving the changes of the peripherals to the
+	// agent, disabled when empty, and how many changes it keeps
+	APIListen     string `json:"api-listen"`
+	APIMaxChanges int    `json:"api-max-changes"`
+
+	// Lock file keeping a second manager from reporting to the same channel,
+	// disabled when the path is empty
+	InstanceLockPath string `json:"instance-lock-path"`
+
+	// Attachment history of the peripherals, disabled when the path is empty
+	UptimePath string `json:"uptime-path"`
+
+	// Window the USB errors of the kernel log are counted over to grade the
+	// health of the peripherals, disabled when zero
+	HealthWindow    time.Duration `json:"health-window"`
+	KernelLogDevice string        `json:"kernel-log-device"`
+
+	// Flag file holding back the reports while it exists, disabled when the
+	// path is empty
+	MaintenancePath string `json:"maintenance-path"`
+
+	// Attachments whose latency until reported is kept, disabled when zero,
+	// and the udev database telling when the devices were attached
+	LatencySamples int    `json:"latency-samples"`
+	UdevDataDir    string `json:"udev-data-dir"`
+
+	// How often the records of each peripheral class are refreshed, as
+	// class=policy entries; every scan when empty
+	ClassThrottles []string `json:"class-throttles"`
+
+	// How long the peripherals missing from the scans are reported as
+	// suspects before their removal, disabled when zero
+	RemovalGracePeriod time.Duration `json:"removal-grace-period"`
+
+	// Anonymous hardware census of the peripherals, for aggregation across
+	// the fleet, and how often it is made, disabled when zero
+	CensusPath     string        `json:"census-path"`
+	CensusInterval time.Duration `json:"census-interval"`
+
+	// Signed hardware bill of materials of the edge, in the CycloneDX format,
+	// the key signing it, created when missing, and how often it is made,
+	// disabled when zero
+	BOMPath     string        `json:"bom-path"`
+	BOMKeyPath  string        `json:"bom-key-path"`
+	BOMInterval time.Duration `json:"bom-interval"`
+
+	// Security tokens required on the edge, see peripherals.CheckCompliance;
+	// the compliance is not reported when empty
+	RequiredTokens []string `json:"required-tokens"`
+
+	// API listing the running containers, docker or kubernetes, to flag the
+	// device paths they map that no longer exist, and how often they are
+	// listed; not followed when empty. The containers mapping the former path
+	// of a device enumerated again are written to RemediationsPath for the
+	// agent to run the moved hooks.
+	DeploymentsSource   string        `json:"deployments-source"`
+	DeploymentsInterval time.Duration `json:"deployments-interval"`
+	DockerSocket        string        `json:"docker-socket"`
+	RemediationsPath    string        `json:"remediations-path"`
+
+	// Report sinks
+	Sinks        []string `json:"sinks"`
+	BufferLayout string   `json:"buffer-layout"`
+	// Octal mode and uid:gid owner of the buffer files, so an agent not
+	// running as root can consume them. The owner is kept when empty.
+	BufferFileMode string `json:"buffer-file-mode"`
+	BufferOwner    string `json:"buffer-owner"`
+	// Time a buffer file may wait for the agent before the consumption is
+	// considered stalled, not followed when zero
+	BacklogStallAfter time.Duration `json:"backlog-stall-after"`
+	SinkQueueSize     int           `json:"sink-queue-size"`
+	SinkMaxRetries    int           `json:"sink-max-retries"`
+	SinkBackoff       time.Duration `json:"sink-backoff"`
+	// Reports in a row a sink may fail before its circuit opens, and how long
+	// it stays open before the sink is probed again
+	SinkFailureThreshold int           `json:"sink-failure-threshold"`
+	SinkOpenTimeout      time.Duration `json:"sink-open-timeout"`
+	MQTTBroker           string        `json:"mqtt-broker"`
+	MQTTTopic            string        `json:"mqtt-topic"`
+	MQTTUsername         string        `json:"mqtt-username"`
+	MQTTPassword         string        `json:"-"`
+	RESTURL              string        `json:"rest-url"`
+	// Unix socket of the socket sink, and the encoding of its frames, json or
+	// protobuf
+	SocketPath     string `json:"socket-path"`
+	SocketEncoding string `json:"socket-encoding"`
+	// File holding the peripherals currently attached, disabled when the path
+	// is empty
+	SnapshotPath string `json:"snapshot-path"`
+	// Quirk table extending the shipped one, see peripherals.ParseQuirks
+	QuirksPath string `json:"quirks-path"`
+	// Devices whose autosuspend is disabled, as vendor:product[:revision]
+	// entries, on top of the no-autosuspend quirks of the table
+	NoAutosuspend []string `json:"no-autosuspend"`
+
+	// Executables and WASM classifiers adding attributes to the records, see
+	// the plugins package, disabled when the path is empty, how long each may
+	// run, and whether only the classifiers run
+	PluginsPath     string        `json:"plugins-path"`
+	PluginTimeout   time.Duration `json:"plugin-timeout"`
+	PluginsWASMOnly bool          `json:"plugins-wasm-only"`
+
+	// Remote actions, all disabled by default
+	Actions     []string `json:"actions"`
+	ActionsPath string   `json:"actions-path"`
+
+	// Cluster mode: "member" publishes the reports of this node in the shared
+	// cluster folder, "leader" also aggregates those of all the nodes
+	ClusterRole       string        `json:"cluster-role"`
+	ClusterPath       string        `json:"cluster-path"`
+	ClusterStaleAfter time.Duration `json:"cluster-stale-after"`
+	NodeName          string        `json:"node-name"`
+
+	// Configuration pushed from Nuvla, followed while the manager runs, not
+	// followed when the path is empty
+	RemoteConfigPath string `json:"remote-config-path"`
+}
+
+// fileSettings are the settings of the usb section of the peripherals
+// configuration file, as the environment variables they stand for
+var fileSettings = map[string]string{}
+
+// remoteSettings are the settings of the usb section of the configuration
+// pushed from Nuvla, remoteConfig follows their changes
+var remoteSettings = map[string]string{}
+var remoteConfig *settings.Remote
+
+// remoteConfigProblem is the last problem reported with the configuration
+// pushed from Nuvla, so it is not reported at every scan
+var remoteConfigProblem string
+
+// lookupSetting reads a setting from the environment, then from the
+// configuration pushed from Nuvla and from the configuration file, so
+// deployments can still override the fleet-wide settings
+func lookupSetting(key string) (string, bool) {
+	if value, ok := os.LookupEnv(key); ok {
+		return value, ok
+	}
+	if value, ok := remoteSettings[key]; ok {
+		return value, ok
+	}
+	value, ok := fileSettings[key]
+	return value, ok
+}
+
+// configSchema lists the settings of the usb section, named after the JSON
+// names of the Config fields. Secrets are only read from the environment.
+func configSchema() settings.Schema {
+	schema := settings.Schema{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("json")
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+		switch field.Type {
+		case reflect.TypeOf(time.Duration(0)):
+			schema[name] = settings.Duration
+		case reflect.TypeOf([]string{}):
+			schema[name] = settings.List
+		default:
+			switch field.Type.Kind() {
+			case reflect.Int:
+				schema[name] = settings.Int
+			case reflect.Bool:
+				schema[name] = settings.Bool
+			default:
+				schema[name] = settings.String
+			}
+		}
+	}
+	return schema
+}
+
+// loadConfigFile reads the usb section of the peripherals configuration file.
+// An invalid file stops the manager, rather than running with settings the
+// operator did not ask for.
+func loadConfigFile(path string) {
+	file, err := settings.Load(path)
+	if err == nil {
+		err = file.Validate(PeripheralName, configSchema())
+	}
+	if err != nil {
+		log.Fatalf("Invalid peripherals configuration file:\n%s", err)
+	}
+	if file != nil {
+		fileSettings = file.Environment(PeripheralName, "USB_")
+		log.Infof("Loaded %d settings from %s", len(fileSettings), path)
+	}
+}
+
+// loadRemoteConfig reads the usb section of the configuration pushed from
+// Nuvla. Unlike an invalid file, an invalid configuration is ignored: a
+// mistake pushed to the fleet must not stop its managers.
+func loadRemoteConfig(path string) {
+	if len(path) == 0 {
+		return
+	}
+	remoteConfig = &settings.Remote{Path: path, Section: PeripheralName, Prefix: "USB_", Schema: configSchema()}
+	env, err := remoteConfig.Load()
+	if err != nil {
+		remoteConfigProblem = err.Error()
+		log.Errorf("Ignoring the configuration pushed from Nuvla. Reason: %s", err)
+		return
+	}
+	remoteSettings = env
+	if len(env) > 0 {
+		log.Infof("Loaded the settings %s pushed from Nuvla", strings.Join(remoteConfig.Keys(), ", "))
+	}
+}
+
+// remoteConfigChanged tells whether the usb settings pushed from Nuvla changed
+// since the manager loaded them. Invalid configurations are reported once.
+func remoteConfigChanged() bool {
+	if remoteConfig == nil {
+		return false
+	}
+	changed, err := remoteConfig.Changed()
+	if err != nil {
+		if err.Error() != remoteConfigProblem {
+			remoteConfigProblem = err.Error()
+			log.Errorf("Ignoring the configuration pushed from Nuvla. Reason: %s", err)
+		}
+		return false
+	}
+	remoteConfigProblem = ""
+	return changed
+}
+
+func loadConfig() Config {
+	loadConfigFile(envString("NUVLAEDGE_PERIPHERALS_CONFIG", settings.DefaultPath))
+	remoteConfigPath := envString("USB_REMOTE_CONFIG_PATH", RemoteConfigPath)
+	loadRemoteConfig(remoteConfigPath)
+	hostname, _ := os.Hostname()
+	cfg := Config{
+		ScanInterval:         envDuration("USB_SCAN_INTERVAL", 30*time.Second),
+		MaxConsecutivePanics: envInt("USB_MAX_CONSECUTIVE_PANICS", 5),
+		DiagnosticsPath:      envString("USB_DIAGNOSTICS_PATH", DiagnosticsPath),
+		StatusPath:           envString("USB_STATUS_PATH", StatusPath),
+		CapabilitiesPath:     envString("USB_CAPABILITIES_PATH", CapabilitiesPath),
+		MaxDiagnosticBundles: envInt("USB_MAX_DIAGNOSTIC_BUNDLES", 10),
+		SelfTest:             envBool("USB_SELF_TEST", true),
+		OverridesPath:        envString("USB_OVERRIDES_PATH", OverridesPath),
+		PortLocationsPath:    envString("USB_PORT_LOCATIONS_PATH", PortLocationsPath),
+		PluginsPath:          envString("USB_PLUGINS_PATH", PluginsPath),
+		PluginTimeout:        envDuration("USB_PLUGIN_TIMEOUT", 5*time.Second),
+		PluginsWASMOnly:      envBool("USB_PLUGINS_WASM_ONLY", false),
+		LocksPath:            envString("USB_LOCKS_PATH", LocksPath),
+		ScanBudget:           envDuration("USB_SCAN_BUDGET", 20*time.Second),
+		ScanTimeout:          envDuration("USB_SCAN_TIMEOUT", time.Minute),
+		DeepScanEvery:        envInt("USB_DEEP_SCAN_EVERY", 1),
+		TopologyCache:        envBool("USB_TOPOLOGY_CACHE", true),
+		P1ProbeTimeout:       envDuration("USB_P1_PROBE_TIMEOUT", 0),
+		GNSSProbeTimeout:     envDuration("USB_GNSS_PROBE_TIMEOUT", 0),
+		ReportHolders:        envBool("USB_REPORT_HOLDERS", true),
+		Privacy:              envString("USB_PRIVACY", ""),
+		PrivacySalt:          envString("USB_PRIVACY_SALT", ""),
+
+		ScanIntervalActive: envDuration("USB_SCAN_INTERVAL_ACTIVE", 0),
+		ScanActivePeriod:   envDuration("USB_SCAN_ACTIVE_PERIOD", time.Minute),
+
+		BatteryScanInterval:    envDuration("USB_BATTERY_SCAN_INTERVAL", 5*time.Minute),
+		BatteryLowCapacity:     envInt("USB_BATTERY_LOW_CAPACITY", 20),
+		BatteryLowScanInterval: envDuration("USB_BATTERY_LOW_SCAN_INTERVAL", 15*time.Minute),
+
+		Backend:  envString("USB_BACKEND", backendAuto),
+		SysfsDir: envString("USB_SYSFS_DIR", sysfs.DefaultDir),
+		Simulate: envString("USB_SIMULATE", ""),
+		Record:   envString("USB_RECORD", ""),
+		Replay:   envString("USB_REPLAY", ""),
+
+		AuditLogPath:     envString("USB_AUDIT_LOG_PATH", AuditLogPath),
+		AuditLogMaxSize:  envInt("USB_AUDIT_LOG_MAX_SIZE", 10<<20),
+		AuditLogMaxFiles: envInt("USB_AUDIT_LOG_MAX_FILES", 5),
+
+		APIListen:     envString("USB_API_LISTEN", ""),
+		APIMaxChanges: envInt("USB_API_MAX_CHANGES", 1000),
+
+		InstanceLockPath: envString("USB_INSTANCE_LOCK_PATH", InstanceLockPath),
+
+		UptimePath: envString("USB_UPTIME_PATH", UptimePath),
+
+		HealthWindow:    envDuration("USB_HEALTH_WINDOW", time.Hour),
+		KernelLogDevice: envString("USB_KERNEL_LOG_DEVICE", health.DefaultKernelLog),
+
+		MaintenancePath: envString("USB_MAINTENANCE_PATH", MaintenancePath),
+
+		LatencySamples: envInt("USB_LATENCY_SAMPLES", 1000),
+		UdevDataDir:    envString("USB_UDEV_DATA_DIR", UdevDataDir),
+
+		ClassThrottles: envList("USB_CLASS_THROTTLES", nil),
+
+		RemovalGracePeriod: envDuration("USB_REMOVAL_GRACE_PERIOD", 0),
+
+		CensusPath:     envString("USB_CENSUS_PATH", CensusPath),
+		CensusInterval: envDuration("USB_CENSUS_INTERVAL", 0),
+
+		BOMPath:     envString("USB_BOM_PATH", BOMPath),
+		BOMKeyPath:  envString("USB_BOM_KEY_PATH", BOMKeyPath),
+		BOMInterval: envDuration("USB_BOM_INTERVAL", 0),
+
+		RequiredTokens: envList("USB_REQUIRED_TOKENS", nil),
+
+		DeploymentsSource:   envString("USB_DEPLOYMENTS_SOURCE", ""),
+		DeploymentsInterval: envDuration("USB_DEPLOYMENTS_INTERVAL", time.Minute),
+		DockerSocket:        envString("USB_DOCKER_SOCKET", deployments.DefaultDockerSocket),
+		RemediationsPath:    envString("USB_REMEDIATIONS_PATH", RemediationsPath),
+
+		Sinks:                envList("USB_SINKS", []string{"file"}),
+		BufferLayout:         envString("USB_BUFFER_LAYOUT", sink.LayoutNative),
+		BufferFileMode:       envString("USB_BUFFER_FILE_MODE", "0644"),
+		BufferOwner:          envString("USB_BUFFER_OWNER", ""),
+		BacklogStallAfter:    envDuration("USB_BACKLOG_STALL_AFTER", 5*time.Minute),
+		SinkQueueSize:        envInt("USB_SINK_QUEUE_SIZE", 10),
+		SinkMaxRetries:       envInt("USB_SINK_MAX_RETRIES", 3),
+		SinkBackoff:          envDuration("USB_SINK_BACKOFF", 5*time.Second),
+		SinkFailureThreshold: envInt("USB_SINK_FAILURE_THRESHOLD", 5),
+		SinkOpenTimeout:      envDuration("USB_SINK_OPEN_TIMEOUT", 2*time.Minute),
+		MQTTBroker:           envString("USB_MQTT_BROKER", "tcp://data-gateway:1883"),
+		MQTTTopic:            envString("USB_MQTT_TOPIC", "nuvlaedge/peripherals/usb"),
+		MQTTUsername:         envString("USB_MQTT_USERNAME", ""),
+		MQTTPassword:         envString("USB_MQTT_PASSWORD", ""),
+		RESTURL:              envString("USB_REST_URL", ""),
+		SocketPath:           envString("USB_SOCKET_PATH", SocketPath),
+		SocketEncoding:       envString("USB_SOCKET_ENCODING", sink.EncodingJSON),
+		SnapshotPath:         envString("USB_SNAPSHOT_PATH", SnapshotPath),
+		QuirksPath:           envString("USB_QUIRKS_PATH", QuirksPath),
+		NoAutosuspend:        envList("USB_NO_AUTOSUSPEND", nil),
+
+		Actions:     envList("USB_ACTIONS", nil),
+		ActionsPath: envString("USB_ACTIONS_PATH", ActionsPath),
+
+		ClusterRole: envString("USB_CLUSTER_ROLE", ""),
+		ClusterPath: envString("USB_CLUSTER_PATH", ClusterPath),
+		NodeName:    envString("USB_NODE_NAME", hostname),
+
+		RemoteConfigPath: remoteConfigPath,
+	}
+	cfg.ClusterStaleAfter = envDuration("USB_CLUSTER_STALE_AFTER", 3*cfg.ScanInterval)
+	bCfg, _ := json.Marshal(cfg)
+	log.Infof("Running with configuration %s", bCfg)
+	return cfg
+}
+
+func envString(key string, fallback string) string {
+	if value, ok := lookupSetting(key); ok && len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+// envList reads a comma separated list, ignoring blank entries
+func envList(key string, fallback []string) []string {
+	value, ok := lookupSetting(key)
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func envInt(key string, fallback int) int {
+	value, ok := lookupSetting(key)
+	if !ok || len(value) == 0 {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Warnf("Invalid value %q for %s, using default %d", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func envBool(key string, fallback bool) bool {
+	value, ok := lookupSetting(key)
+	if !ok || len(value) == 0 {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Warnf("Invalid value %q for %s, using default %t", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// envDuration accepts both Go durations ("45s", "2m") and plain integers,
+// which are read as seconds.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := lookupSetting(key)
+	if !ok || len(value) == 0 {
+		return fallback
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Warnf("Invalid value %q for %s, using default %s", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+const DiagnosticsPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/diagnostics/"
+
+// lastDevicesKept bounds how many visited devices are remembered for the bundle
+const lastDevicesKept = 32
+
+// scanTracker remembers which devices were visited during the current scan
+// and which ones were seen in the last complete one, so a crash can be traced
+// back to the descriptor that caused it.
+type scanTracker struct {
+	current  []string
+	previous []string
+	panics   int
+}
+
+func newScanTracker() *scanTracker {
+	return &scanTracker{}
+}
+
+func (t *scanTracker) visit(identifier string, devicePath string) {
+	entry := fmt.Sprintf("%s (%s)", identifier, devicePath)
+	if len(t.current) >= lastDevicesKept {
+		t.current = t.current[1:]
+	}
+	t.current = append(t.current, entry)
+}
+
+func (t *scanTracker) reset() {
+	t.current = nil
+}
+
+func (t *scanTracker) complete() {
+	t.previous = t.current
+	t.current = nil
+	t.panics = 0
+}
+
+type diagnosticBundle struct {
+	Time              string   `json:"time"`
+	Panic             string   `json:"panic"`
+	Stack             string   `json:"stack"`
+	CurrentScan       []string `json:"current-scan-devices"`
+	LastCompleteScan  []string `json:"last-complete-scan-devices"`
+	ConsecutivePanics int      `json:"consecutive-panics"`
+	Config            Config   `json:"config"`
+}
+
+// safeDiscoverPeripherals runs a single discovery and turns any panic raised by
+// gousb or usbid on a malformed descriptor into a diagnostic bundle, so the
+// manager keeps the context of the failure instead of dying with it.
+func safeDiscoverPeripherals(ctx context.Context, discoverer *peripherals.Discoverer, tracker *scanTracker, cfg Config) (discovered []peripherals.Peripheral, devErr error, recovered bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			recovered = true
+			tracker.panics++
+			log.Errorf("Recovered from panic during USB discovery: %v", r)
+			bundle := diagnosticBundle{
+				Time:              time.Now().UTC().Format(time.RFC3339),
+				Panic:             fmt.Sprint(r),
+				Stack:             string(debug.Stack()),
+				CurrentScan:       tracker.current,
+				LastCompleteScan:  tracker.previous,
+				ConsecutivePanics: tracker.panics,
+				Config:            cfg,
+			}
+			writeDiagnosticBundle(bundle, cfg)
+			tracker.reset()
+		}
+	}()
+
+	tracker.reset()
+	discovered, devErr = discoverer.Discover(ctx)
+	tracker.complete()
+	return discovered, devErr, false
+}
+
+func writeDiagnosticBundle(bundle diagnosticBundle, cfg Config) {
+	if err := os.MkdirAll(cfg.DiagnosticsPath, os.ModePerm); err != nil {
+		log.Errorf("Unable to create diagnostics folder %s. Reason: %s", cfg.DiagnosticsPath, err)
+		return
+	}
+
+	bData, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		log.Errorf("Unable to encode diagnostic bundle. Reason: %s", err)
+		return
+	}
+
+	// The nanoseconds keep apart the bundles of the panics of the same second
+	now := time.Now().UTC()
+	file := filepath.Join(cfg.DiagnosticsPath, fmt.Sprintf("%s_%09d_panic.json", now.Format(sink.DatetimeFormat), now.Nanosecond()))
+	if err := os.WriteFile(file, bData, 0644); err != nil {
+		log.Errorf("Unable to write diagnostic bundle %s. Reason: %s", file, err)
+		return
+	}
+	log.Warnf("Diagnostic bundle written to %s", file)
+
+	pruneDiagnosticBundles(cfg.DiagnosticsPath, cfg.MaxDiagnosticBundles)
+}
+
+// pruneDiagnosticBundles keeps only the most recent bundles so a descriptor that
+// panics on every scan cannot fill the shared volume. The newest bundle is
+// always kept.
+func pruneDiagnosticBundles(path string, keep int) {
+	if keep < 1 {
+		keep = 1
+	}
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return
+	}
+
+	var bundles []os.FileInfo
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), "_panic.json") {
+			bundles = append(bundles, f)
+		}
+	}
+	if len(bundles) <= keep {
+		return
+	}
+
+	sort.Slice(bundles, func(i, j int) bool {
+		if !bundles[i].ModTime().Equal(bundles[j].ModTime()) {
+			return bundles[i].ModTime().Before(bundles[j].ModTime())
+		}
+		return bundles[i].Name() < bundles[j].Name()
+	})
+	for _, f := range bundles[:len(bundles)-keep] {
+		_ = os.Remove(filepath.Join(path, f.Name()))
+	}
+}
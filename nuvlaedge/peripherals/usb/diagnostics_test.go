@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func bundleNames(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*_panic.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestWriteDiagnosticBundle(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{DiagnosticsPath: dir, MaxDiagnosticBundles: 3}
+
+	// The panics of the same second get a bundle each
+	for i := 0; i < 3; i++ {
+		writeDiagnosticBundle(diagnosticBundle{Panic: "index out of range"}, cfg)
+	}
+	if names := bundleNames(t, dir); len(names) != 3 {
+		t.Fatalf("expected 3 bundles, got %v", names)
+	}
+
+	writeDiagnosticBundle(diagnosticBundle{Panic: "nil pointer dereference"}, cfg)
+	if names := bundleNames(t, dir); len(names) != 3 {
+		t.Errorf("expected the bundles to be pruned to 3, got %v", names)
+	}
+}
+
+func TestPruneDiagnosticBundles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{DiagnosticsPath: dir, MaxDiagnosticBundles: 10}
+	for i := 0; i < 3; i++ {
+		writeDiagnosticBundle(diagnosticBundle{Panic: "index out of range"}, cfg)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	names := bundleNames(t, dir)
+
+	// Keeping no bundle still keeps the newest one
+	pruneDiagnosticBundles(dir, 0)
+	if kept := bundleNames(t, dir); len(kept) != 1 || kept[0] != names[len(names)-1] {
+		t.Errorf("expected the newest bundle %s to be kept, got %v", names[len(names)-1], kept)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("expected other files to be left alone: %s", err)
+	}
+}
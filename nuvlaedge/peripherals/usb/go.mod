@@ -1,8 +1,11 @@
-module github.com/nuvlaedge/nuvlaedge
+module github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb
 
-go 1.16
+go 1.20
 
 require (
 	github.com/google/gousb v1.1.1
 	github.com/sirupsen/logrus v1.8.1
-)
\ No newline at end of file
+	github.com/tetratelabs/wazero v1.5.0
+)
+
+require golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
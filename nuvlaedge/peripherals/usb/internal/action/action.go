@@ -0,0 +1,225 @@
+// Package action runs the remote actions requested on the USB peripherals,
+// such as a test read of a barcode scanner.
+//
+// Actions go through a folder based channel, like the reports do: a request is
+// a JSON file dropped in the requests folder, and the manager answers in the
+// results folder with a file of the same name, rewritten as the action
+// progresses.
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// Status of an action result
+const (
+	StatusRunning  = "running"
+	StatusSuccess  = "success"
+	StatusFailed   = "failed"
+	StatusRejected = "rejected"
+)
+
+// Request asks for an action on one peripheral
+type Request struct {
+	ID         string            `json:"id"`
+	Action     string            `json:"action"`
+	Identifier string            `json:"identifier"`
+	Params     map[string]string `json:"params,omitempty"`
+}
+
+// Result is the state of a requested action
+type Result struct {
+	ID         string                 `json:"id"`
+	Action     string                 `json:"action"`
+	Identifier string                 `json:"identifier"`
+	Status     string                 `json:"status"`
+	Message    string                 `json:"message,omitempty"`
+	Progress   int                    `json:"progress,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Started    string                 `json:"started"`
+	Finished   string                 `json:"finished,omitempty"`
+}
+
+// Progress lets a long running action report how far it got, in percent
+type Progress func(percent int, message string)
+
+// Handler runs an action and returns the data to report back
+type Handler func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error)
+
+// Lookup returns the last reported state of a peripheral
+type Lookup func(identifier string) (peripherals.Peripheral, bool)
+
+// Channel dispatches the action requests found on disk to their handlers
+type Channel struct {
+	requestsDir string
+	resultsDir  string
+	interval    time.Duration
+	handlers    map[string]Handler
+}
+
+// NewChannel creates a channel rooted at dir, checked for new requests at the
+// given interval
+func NewChannel(dir string, interval time.Duration) *Channel {
+	return &Channel{
+		requestsDir: filepath.Join(dir, "requests"),
+		resultsDir:  filepath.Join(dir, "results"),
+		interval:    interval,
+		handlers:    map[string]Handler{},
+	}
+}
+
+// Register enables an action
+func (c *Channel) Register(name string, handler Handler) {
+	c.handlers[name] = handler
+}
+
+// Actions lists the enabled actions
+func (c *Channel) Actions() []string {
+	names := make([]string, 0, len(c.handlers))
+	for name := range c.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run processes requests, one at a time and in file name order, until ctx is
+// done
+func (c *Channel) Run(ctx context.Context) error {
+	for _, dir := range []string{c.requestsDir, c.resultsDir} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return err
+		}
+	}
+	log.Infof("Listening for USB peripheral actions %v in %s", c.Actions(), c.requestsDir)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.processPending(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Channel) processPending(ctx context.Context) {
+	files, err := ioutil.ReadDir(c.requestsDir)
+	if err != nil {
+		log.Errorf("Unable to list action requests in %s. Reason: %s", c.requestsDir, err)
+		return
+	}
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		c.process(ctx, f.Name())
+	}
+}
+
+func (c *Channel) process(ctx context.Context, fileName string) {
+	path := filepath.Join(c.requestsDir, fileName)
+	data, err := ioutil.ReadFile(path)
+	// Requests are consumed whatever their outcome, so a broken one is not
+	// retried forever
+	_ = os.Remove(path)
+	if err != nil {
+		log.Errorf("Unable to read action request %s. Reason: %s", path, err)
+		return
+	}
+
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		log.Errorf("Malformed action request %s. Reason: %s", path, err)
+		return
+	}
+	// The ID names the result file
+	if len(req.ID) == 0 || req.ID != filepath.Base(req.ID) {
+		req.ID = strings.TrimSuffix(fileName, ".json")
+	}
+
+	result := &Result{
+		ID:         req.ID,
+		Action:     req.Action,
+		Identifier: req.Identifier,
+		Status:     StatusRunning,
+		Started:    now(),
+	}
+
+	handler, exists := c.handlers[req.Action]
+	if !exists {
+		c.finish(result, StatusRejected, fmt.Sprintf("action %q is not enabled", req.Action), nil)
+		return
+	}
+
+	log.Infof("Running action %s (%s) on peripheral %s", req.Action, req.ID, req.Identifier)
+	c.write(result)
+
+	progress := func(percent int, message string) {
+		result.Progress = percent
+		result.Message = message
+		c.write(result)
+	}
+
+	output, err := handler(ctx, req, progress)
+	if err != nil {
+		c.finish(result, StatusFailed, err.Error(), output)
+		return
+	}
+	c.finish(result, StatusSuccess, "", output)
+}
+
+func (c *Channel) finish(result *Result, status string, message string, data map[string]interface{}) {
+	result.Status = status
+	result.Message = message
+	result.Data = data
+	result.Finished = now()
+	if status == StatusSuccess {
+		result.Progress = 100
+	}
+	log.Infof("Action %s (%s) finished with status %s %s", result.Action, result.ID, status, message)
+	c.write(result)
+}
+
+// write replaces the result file atomically, so readers never see a partial
+// document
+func (c *Channel) write(result *Result) {
+	bData, err := json.Marshal(result)
+	if err != nil {
+		log.Errorf("Unable to encode result of action %s. Reason: %s", result.ID, err)
+		return
+	}
+
+	file := filepath.Join(c.resultsDir, result.ID+".json")
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, bData, 0644); err != nil {
+		log.Errorf("Unable to write result of action %s. Reason: %s", result.ID, err)
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		log.Errorf("Unable to write result of action %s. Reason: %s", result.ID, err)
+	}
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
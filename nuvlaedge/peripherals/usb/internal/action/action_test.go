@@ -0,0 +1,408 @@
+package action
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/lock"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/dfu"
+)
+
+func readResult(t *testing.T, dir string, id string) Result {
+	data, err := os.ReadFile(filepath.Join(dir, "results", id+".json"))
+	if err != nil {
+		t.Fatalf("expected result %s: %s", id, err)
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func writeRequest(t *testing.T, dir string, fileName string, req Request) {
+	data, _ := json.Marshal(req)
+	if err := os.WriteFile(filepath.Join(dir, "requests", fileName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannel(t *testing.T) {
+	dir := t.TempDir()
+	c := NewChannel(dir, time.Millisecond)
+	c.Register("echo", func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		progress(50, "halfway")
+		return map[string]interface{}{"echo": req.Params["value"]}, nil
+	})
+	c.Register("fail", func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		return nil, errors.New("device busy")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+	time.Sleep(20 * time.Millisecond)
+
+	writeRequest(t, dir, "1.json", Request{ID: "1", Action: "echo", Identifier: "0001:0001", Params: map[string]string{"value": "hello"}})
+	writeRequest(t, dir, "2.json", Request{ID: "2", Action: "fail"})
+	writeRequest(t, dir, "3.json", Request{Action: "unknown"})
+	time.Sleep(50 * time.Millisecond)
+
+	if result := readResult(t, dir, "1"); result.Status != StatusSuccess || result.Data["echo"] != "hello" || result.Progress != 100 {
+		t.Errorf("unexpected echo result %+v", result)
+	}
+	if result := readResult(t, dir, "2"); result.Status != StatusFailed || result.Message != "device busy" {
+		t.Errorf("unexpected failed result %+v", result)
+	}
+	if result := readResult(t, dir, "3"); result.Status != StatusRejected {
+		t.Errorf("expected unknown action to be rejected, got %+v", result)
+	}
+
+	if pending, _ := os.ReadDir(filepath.Join(dir, "requests")); len(pending) != 0 {
+		t.Errorf("expected requests to be consumed, %d left", len(pending))
+	}
+}
+
+func TestBarcodeTestRead(t *testing.T) {
+	node := filepath.Join(t.TempDir(), "hidraw0")
+	if err := os.WriteFile(node, []byte{0x00, 0x00, 0x1e}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	attached := map[string]peripherals.Peripheral{
+		"0c2e:0b61": {
+			Identifier: "0c2e:0b61",
+			HID:        []peripherals.HIDInterface{{DevicePath: node, Types: []peripherals.HIDType{peripherals.HIDBarcodeScanner}}},
+		},
+		"046d:c31c": {Identifier: "046d:c31c", Name: "Keyboard K120"},
+	}
+	lookup := func(identifier string) (peripherals.Peripheral, bool) {
+		p, ok := attached[identifier]
+		return p, ok
+	}
+	handler := BarcodeTestRead(lookup)
+	noProgress := func(int, string) {}
+
+	data, err := handler(context.Background(), Request{Identifier: "0c2e:0b61"}, noProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["scan-received"] != true || data["bytes"] != 3 {
+		t.Errorf("unexpected test read output %v", data)
+	}
+
+	for _, req := range []Request{
+		{Identifier: "0000:0000"},
+		{Identifier: "046d:c31c"},
+		{Identifier: "0c2e:0b61", Params: map[string]string{"device": "/dev/sda"}},
+		{Identifier: "0c2e:0b61", Params: map[string]string{"timeout": "-1"}},
+	} {
+		if _, err := handler(context.Background(), req, noProgress); err == nil {
+			t.Errorf("expected request %+v to be refused", req)
+		}
+	}
+}
+
+func TestClaimRelease(t *testing.T) {
+	registry := lock.NewRegistry(t.TempDir())
+	lookup := func(identifier string) (peripherals.Peripheral, bool) {
+		if identifier == "0781:5583" {
+			return peripherals.Peripheral{
+				Identifier: identifier,
+				Storage:    []peripherals.BlockDevice{{DevicePath: "/dev/sda", Protected: true, ProtectedReason: "root filesystem"}},
+			}, true
+		}
+		if identifier == "1a86:7523" {
+			return peripherals.Peripheral{Identifier: identifier, HostCritical: "serial console /dev/ttyUSB0"}, true
+		}
+		return peripherals.Peripheral{Identifier: identifier}, identifier == "046d:0825"
+	}
+	progress := func(int, string) {}
+
+	req := Request{Action: ClaimAction, Identifier: "046d:0825", Params: map[string]string{"owner": "vision-app", "ttl": "60"}}
+	data, err := Claim(registry, lookup)(context.Background(), req, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data["owner"] != "vision-app" || data["expires"] == "" {
+		t.Errorf("unexpected claim result %v", data)
+	}
+
+	req.Identifier = "0bda:8153"
+	if _, err := Claim(registry, lookup)(context.Background(), req, progress); err == nil {
+		t.Error("expected claim of a detached peripheral to fail")
+	}
+	req.Identifier = "0781:5583"
+	if _, err := Claim(registry, lookup)(context.Background(), req, progress); err == nil {
+		t.Error("expected claim of the root disk to fail")
+	}
+	req.Identifier = "1a86:7523"
+	if _, err := Claim(registry, lookup)(context.Background(), req, progress); err == nil {
+		t.Error("expected claim of the host console to fail")
+	}
+
+	release := Request{Action: ReleaseAction, Identifier: "046d:0825", Params: map[string]string{"owner": "vision-app"}}
+	if _, err := Release(registry)(context.Background(), release, progress); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed := registry.Status("046d:0825"); claimed {
+		t.Error("expected peripheral to be released")
+	}
+}
+
+func TestDFUFlashRejections(t *testing.T) {
+	claimed := true
+	attached := map[string]peripherals.Peripheral{
+		"046d:0825": {Identifier: "046d:0825", DevicePath: "/dev/bus/usb/001/004"},
+		"0483:df11": {
+			Identifier: "0483:df11",
+			DevicePath: "/dev/bus/usb/001/005",
+			Firmware:   &peripherals.Firmware{DFU: "dfu"},
+			Claimed:    &claimed,
+			ClaimedBy:  "vision-app",
+		},
+	}
+	lookup := func(identifier string) (peripherals.Peripheral, bool) {
+		p, ok := attached[identifier]
+		return p, ok
+	}
+	open := func(bus int, address int) (dfu.Device, error) {
+		t.Fatal("device must not be opened")
+		return nil, nil
+	}
+	handler := DFUFlash(lookup, open)
+	progress := func(int, string) {}
+
+	image := []byte("firmware image")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(image)
+	}))
+	defer server.Close()
+	digest := sha256.Sum256(image)
+	checksum := hex.EncodeToString(digest[:])
+	other := sha256.Sum256([]byte("other image"))
+
+	for _, req := range []Request{
+		{Identifier: "046d:0825", Params: map[string]string{"url": "http://localhost/fw.bin", "sha256": checksum}},
+		{Identifier: "0483:df11", Params: map[string]string{"url": "http://localhost/fw.bin", "sha256": checksum}},
+		{Identifier: "0483:df11", Params: map[string]string{"url": "file:///fw.bin", "sha256": checksum, "owner": "vision-app"}},
+		// The image must come with its digest, and match it, before the device is opened
+		{Identifier: "0483:df11", Params: map[string]string{"url": server.URL, "owner": "vision-app"}},
+		{Identifier: "0483:df11", Params: map[string]string{"url": server.URL, "sha256": "c0ffee", "owner": "vision-app"}},
+		{Identifier: "0483:df11", Params: map[string]string{"url": server.URL, "sha256": hex.EncodeToString(other[:]), "owner": "vision-app"}},
+	} {
+		if _, err := handler(context.Background(), req, progress); err == nil {
+			t.Errorf("expected request %+v to be rejected", req)
+		}
+	}
+}
+
+func TestDriverBindUnbind(t *testing.T) {
+	sysfs := t.TempDir()
+	mkdir := func(path string) string {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write := func(path string, content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	drivers := filepath.Join(sysfs, "bus", "usb", "drivers")
+	for _, driver := range []string{"ftdi_sio", "custom_serial"} {
+		for _, file := range []string{"bind", "unbind", "new_id"} {
+			write(filepath.Join(mkdir(filepath.Join(drivers, driver)), file), "")
+		}
+	}
+	devices := mkdir(filepath.Join(sysfs, "bus", "usb", "devices"))
+	device := mkdir(filepath.Join(devices, "1-2"))
+	write(filepath.Join(device, "busnum"), "1")
+	write(filepath.Join(device, "devnum"), "7")
+	intf := mkdir(filepath.Join(devices, "1-2:1.0"))
+	write(filepath.Join(intf, "bInterfaceNumber"), "00")
+	if err := os.Symlink(filepath.Join(drivers, "ftdi_sio"), filepath.Join(intf, "driver")); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed := true
+	attached := map[string]peripherals.Peripheral{
+		"0403:6001": {Identifier: "0403:6001", DevicePath: "/dev/bus/usb/001/007"},
+		"0403:6015": {Identifier: "0403:6015", DevicePath: "/dev/bus/usb/001/008", Claimed: &claimed, ClaimedBy: "plc-app"},
+	}
+	lookup := func(identifier string) (peripherals.Peripheral, bool) {
+		p, ok := attached[identifier]
+		return p, ok
+	}
+	progress := func(int, string) {}
+	read := func(driver string, file string) string {
+		data, _ := os.ReadFile(filepath.Join(drivers, driver, file))
+		return string(data)
+	}
+
+	for _, req := range []Request{
+		{Identifier: "0403:6001", Params: map[string]string{"interface": "one"}},
+		{Identifier: "0403:6001", Params: map[string]string{"interface": "3"}},
+		{Identifier: "0403:6001", Params: map[string]string{"interface": "0", "driver": "cdc_acm"}},
+		{Identifier: "0403:6015", Params: map[string]string{"interface": "0"}},
+		{Identifier: "1a86:7523", Params: map[string]string{"interface": "0"}},
+	} {
+		if _, err := DriverUnbind(lookup, sysfs)(context.Background(), req, progress); err == nil {
+			t.Errorf("expected unbind request %+v to be refused", req)
+		}
+	}
+	for _, driver := range []string{"", "../ftdi_sio", "cdc_acm"} {
+		req := Request{Identifier: "0403:6001", Params: map[string]string{"interface": "0", "driver": driver}}
+		if _, err := DriverBind(lookup, sysfs)(context.Background(), req, progress); err == nil {
+			t.Errorf("expected bind of driver %q to be refused", driver)
+		}
+	}
+	if read("ftdi_sio", "unbind") != "" {
+		t.Fatal("expected the refused requests to leave the drivers untouched")
+	}
+
+	req := Request{Identifier: "0403:6001", Params: map[string]string{"interface": "0", "driver": "ftdi_sio"}}
+	data, err := DriverUnbind(lookup, sysfs)(context.Background(), req, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read("ftdi_sio", "unbind") != "1-2:1.0" || data["unbound"] != "ftdi_sio" {
+		t.Errorf("expected the interface to be unbound, got %v", data)
+	}
+
+	req = Request{Identifier: "0403:6001", Params: map[string]string{"interface": "0", "driver": "custom_serial"}}
+	data, err = DriverBind(lookup, sysfs)(context.Background(), req, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read("custom_serial", "bind") != "1-2:1.0" || data["bound"] != "custom_serial" || data["unbound"] != "ftdi_sio" {
+		t.Errorf("expected the interface to be bound to the custom driver, got %v", data)
+	}
+
+	req.Params["new-id"] = "true"
+	if _, err = DriverBind(lookup, sysfs)(context.Background(), req, progress); err != nil {
+		t.Fatal(err)
+	}
+	if read("custom_serial", "new_id") != "0403 6001" {
+		t.Errorf("expected the device ids to be added to the driver, got %q", read("custom_serial", "new_id"))
+	}
+}
+
+func TestAudioLevelTest(t *testing.T) {
+	sysfs := t.TempDir()
+	devices := filepath.Join(sysfs, "bus", "usb", "devices")
+	for _, dir := range []string{"1-3", "1-3:1.0", "1-3:1.1/sound/card2/pcmC2D0c", "1-3:1.1/sound/card2/pcmC2D0p",
+		"1-4", "1-4:1.0"} {
+		if err := os.MkdirAll(filepath.Join(devices, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for device, address := range map[string]string{"1-3": "5", "1-4": "6"} {
+		_ = os.WriteFile(filepath.Join(devices, device, "busnum"), []byte("1"), 0644)
+		_ = os.WriteFile(filepath.Join(devices, device, "devnum"), []byte(address), 0644)
+	}
+
+	attached := map[string]peripherals.Peripheral{
+		"046d:0a44": {Identifier: "046d:0a44", DevicePath: "/dev/bus/usb/001/005"},
+		"046d:c31c": {Identifier: "046d:c31c", DevicePath: "/dev/bus/usb/001/006"},
+	}
+	lookup := func(identifier string) (peripherals.Peripheral, bool) {
+		p, ok := attached[identifier]
+		return p, ok
+	}
+	// A half scale square wave, then silence
+	var sampled string
+	capture := func(ctx context.Context, device string, samples int) ([]byte, error) {
+		sampled = device
+		data := make([]byte, 2*samples)
+		for i := 0; i < samples/2; i++ {
+			value := int16(16384)
+			if i%2 == 1 {
+				value = -16384
+			}
+			data[2*i], data[2*i+1] = byte(value), byte(uint16(value)>>8)
+		}
+		return data, nil
+	}
+	handler := audioLevelTest(lookup, sysfs, capture)
+	progress := func(int, string) {}
+
+	data, err := handler(context.Background(), Request{Identifier: "046d:0a44", Params: map[string]string{"duration": "2"}}, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sampled != "hw:2,0" || data["duration"] != 2.0 || data["peak-dbfs"] != -6.0 || data["rms-dbfs"] != -9.0 ||
+		data["silent"] != false || data["clipping"] != false {
+		t.Errorf("unexpected audio test output %v from %s", data, sampled)
+	}
+	if levels := data["levels-dbfs"].([]float64); len(levels) != 4 || levels[0] != -6.0 || levels[3] != -120 {
+		t.Errorf("unexpected levels %v", levels)
+	}
+
+	for _, req := range []Request{
+		{Identifier: "0000:0000"},
+		{Identifier: "046d:c31c"},
+		{Identifier: "046d:0a44", Params: map[string]string{"device": "hw:0,0"}},
+		{Identifier: "046d:0a44", Params: map[string]string{"duration": "0"}},
+	} {
+		if _, err := handler(context.Background(), req, progress); err == nil {
+			t.Errorf("expected request %+v to be refused", req)
+		}
+	}
+}
+
+func TestAutosuspend(t *testing.T) {
+	sysfs := t.TempDir()
+	device := filepath.Join(sysfs, "bus", "usb", "devices", "1-2")
+	if err := os.MkdirAll(filepath.Join(device, "power"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{"busnum": "1", "devnum": "7", "power/control": "auto\n"} {
+		if err := os.WriteFile(filepath.Join(device, file), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed := true
+	attached := map[string]peripherals.Peripheral{
+		"1199:9071": {Identifier: "1199:9071", DevicePath: "/dev/bus/usb/001/007"},
+		"046d:0825": {Identifier: "046d:0825", DevicePath: "/dev/bus/usb/001/008", Claimed: &claimed, ClaimedBy: "vision"},
+	}
+	lookup := func(identifier string) (peripherals.Peripheral, bool) {
+		p, ok := attached[identifier]
+		return p, ok
+	}
+	handler := Autosuspend(lookup, sysfs)
+	progress := func(int, string) {}
+
+	for _, req := range []Request{
+		{Identifier: "1199:9071", Params: map[string]string{"enabled": "no"}},
+		{Identifier: "046d:0825", Params: map[string]string{"enabled": "false"}},
+		{Identifier: "1a86:7523", Params: map[string]string{"enabled": "false"}},
+	} {
+		if _, err := handler(context.Background(), req, progress); err == nil {
+			t.Errorf("expected request %+v to be refused", req)
+		}
+	}
+
+	data, err := handler(context.Background(), Request{Identifier: "1199:9071", Params: map[string]string{"enabled": "false"}}, progress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	control, _ := os.ReadFile(filepath.Join(device, "power", "control"))
+	if string(control) != "on" || data["previous"] != "auto" {
+		t.Errorf("expected the autosuspend to be disabled, got %q and %v", control, data)
+	}
+}
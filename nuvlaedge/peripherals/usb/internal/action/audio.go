@@ -0,0 +1,189 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	AudioLevelTestAction = "audio-level-test"
+
+	defaultAudioTestDuration = 3 * time.Second
+	maxAudioTestDuration     = 10 * time.Second
+
+	// audioSampleRate is the rate of the capture, ALSA converts to it
+	audioSampleRate = 16000
+	// audioWindow is the span of each level reported
+	audioWindow = 500 * time.Millisecond
+	// audioSilence is the level below which the capture is reported silent,
+	// in dBFS: a connected microphone picks up more than that in any room
+	audioSilence = -60.0
+)
+
+// pcmCapturePattern matches the capture PCM nodes of a sound card in sysfs,
+// pcmC1D0c
+var pcmCapturePattern = regexp.MustCompile(`^pcmC(\d+)D(\d+)c$`)
+
+// audioCapture records mono signed 16-bit little endian samples at
+// audioSampleRate from an ALSA device
+type audioCapture func(ctx context.Context, device string, samples int) ([]byte, error)
+
+// AudioLevelTest returns the handler of the audio-level-test action. It
+// samples a microphone of the peripheral for a few seconds with arecord and
+// reports the RMS and peak levels, so operators can tell a microphone works
+// before dispatching a technician. The audio itself is never reported.
+//
+// Params:
+//   - device: ALSA capture device, as hw:1,0. Defaults to the first capture
+//     device of the peripheral.
+//   - duration: how long to sample, in seconds (default 3, max 10)
+//   - owner: application testing the peripheral, required when it is claimed
+func AudioLevelTest(lookup Lookup, sysfsDir string) Handler {
+	return audioLevelTest(lookup, sysfsDir, arecord)
+}
+
+func audioLevelTest(lookup Lookup, sysfsDir string, capture audioCapture) Handler {
+	return func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		peripheral, exists := lookup(req.Identifier)
+		if !exists {
+			return nil, fmt.Errorf("peripheral %s is not attached", req.Identifier)
+		}
+		// The capture would fail, or steal the audio, of the application
+		// holding the peripheral
+		if peripheral.Claimed != nil && *peripheral.Claimed && peripheral.ClaimedBy != req.Params["owner"] {
+			return nil, fmt.Errorf("peripheral %s is claimed by %v", req.Identifier, peripheral.ClaimedBy)
+		}
+
+		var bus, address int
+		if _, err := fmt.Sscanf(peripheral.DevicePath, "/dev/bus/usb/%d/%d", &bus, &address); err != nil {
+			return nil, fmt.Errorf("unable to locate peripheral %s: %w", req.Identifier, err)
+		}
+		devices, err := captureDevices(sysfsDir, bus, address)
+		if err != nil {
+			return nil, fmt.Errorf("unable to locate the sound card of peripheral %s: %w", req.Identifier, err)
+		}
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("peripheral %s has no microphone", req.Identifier)
+		}
+		device := devices[0]
+		if requested, ok := req.Params["device"]; ok {
+			if !contains(devices, requested) {
+				return nil, fmt.Errorf("%s is not a capture device of peripheral %s", requested, req.Identifier)
+			}
+			device = requested
+		}
+
+		duration, err := durationParam(req.Params, "duration", defaultAudioTestDuration, maxAudioTestDuration)
+		if err != nil {
+			return nil, err
+		}
+
+		progress(0, fmt.Sprintf("Sampling %s for %s", device, duration))
+		data, err := capture(ctx, device, int(duration.Seconds()*audioSampleRate))
+		if err != nil {
+			return nil, err
+		}
+		samples := decodeSamples(data)
+		if len(samples) == 0 {
+			return nil, fmt.Errorf("no audio captured from %s", device)
+		}
+
+		rms, peak := audioLevels(samples)
+		window := int(audioWindow.Seconds() * audioSampleRate)
+		var levels []float64
+		for start := 0; start < len(samples); start += window {
+			end := start + window
+			if end > len(samples) {
+				end = len(samples)
+			}
+			windowRMS, _ := audioLevels(samples[start:end])
+			levels = append(levels, decibels(windowRMS))
+		}
+
+		return map[string]interface{}{
+			"device":      device,
+			"duration":    float64(len(samples)) / audioSampleRate,
+			"sample-rate": audioSampleRate,
+			"rms-dbfs":    decibels(rms),
+			"peak-dbfs":   decibels(peak),
+			"levels-dbfs": levels,
+			"silent":      decibels(rms) < audioSilence,
+			"clipping":    peak >= 1,
+		}, nil
+	}
+}
+
+// captureDevices lists the ALSA capture devices of the sound cards of a
+// device, as hw:1,0
+func captureDevices(sysfsDir string, bus int, address int) ([]string, error) {
+	dirs, err := interfaceDirs(sysfsDir, bus, address)
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, dir := range dirs {
+		nodes, _ := filepath.Glob(filepath.Join(dir, "sound", "card*", "pcmC*"))
+		for _, node := range nodes {
+			if match := pcmCapturePattern.FindStringSubmatch(filepath.Base(node)); match != nil {
+				devices = append(devices, fmt.Sprintf("hw:%s,%s", match[1], match[2]))
+			}
+		}
+	}
+	return devices, nil
+}
+
+// arecord records with the ALSA plug layer, converting the format, rate and
+// channels of the microphone
+func arecord(ctx context.Context, device string, samples int) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(samples)*time.Second/audioSampleRate+5*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "arecord", "-q", "-D", "plug"+device, "-t", "raw", "-f", "S16_LE",
+		"-c", "1", "-r", strconv.Itoa(audioSampleRate), "-s", strconv.Itoa(samples))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("unable to sample %s: %w", device, ctx.Err())
+		}
+		return nil, fmt.Errorf("unable to sample %s: %s %s", device, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// decodeSamples decodes signed 16-bit little endian samples to [-1, 1]
+func decodeSamples(data []byte) []float64 {
+	samples := make([]float64, len(data)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(data[2*i:]))) / 32768
+	}
+	return samples
+}
+
+// audioLevels returns the RMS and the peak of samples in [-1, 1]
+func audioLevels(samples []float64) (float64, float64) {
+	var sum, peak float64
+	for _, sample := range samples {
+		sum += sample * sample
+		peak = math.Max(peak, math.Abs(sample))
+	}
+	return math.Sqrt(sum / float64(len(samples))), peak
+}
+
+// decibels converts a level to dBFS, rounded to a tenth. Digital silence is
+// reported as -120 dBFS, below what the 16-bit samples can express.
+func decibels(level float64) float64 {
+	if level <= 1e-6 {
+		return -120
+	}
+	return math.Round(200*math.Log10(level)) / 10
+}
@@ -0,0 +1,75 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+const AutosuspendAction = "autosuspend"
+
+// Autosuspend returns the handler of the autosuspend action, which enables or
+// disables the autosuspend of a peripheral, as the cameras and modems that
+// stop working once suspended, through its power/control attribute in sysfs.
+// The setting lasts until the peripheral is attached again, the
+// no-autosuspend quirk keeps it across attachments. Peripherals claimed by an
+// application are only changed on behalf of their owner.
+//
+// Params:
+//   - enabled: "true" to let the kernel suspend the peripheral when idle,
+//     "false" to keep it powered
+//   - owner: application changing the peripheral, required when it is claimed
+func Autosuspend(lookup Lookup, sysfsDir string) Handler {
+	return func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		peripheral, exists := lookup(req.Identifier)
+		if !exists {
+			return nil, fmt.Errorf("peripheral %s is not attached", req.Identifier)
+		}
+		if peripheral.Claimed != nil && *peripheral.Claimed && peripheral.ClaimedBy != req.Params["owner"] {
+			return nil, fmt.Errorf("peripheral %s is claimed by %v", req.Identifier, peripheral.ClaimedBy)
+		}
+		var control string
+		switch req.Params["enabled"] {
+		case "true":
+			control = peripherals.AutosuspendEnabled
+		case "false":
+			control = peripherals.AutosuspendDisabled
+		default:
+			return nil, fmt.Errorf("invalid enabled %q, expected true or false", req.Params["enabled"])
+		}
+
+		var bus, address int
+		if _, err := fmt.Sscanf(peripheral.DevicePath, "/dev/bus/usb/%d/%d", &bus, &address); err != nil {
+			return nil, fmt.Errorf("unable to locate peripheral %s: %w", req.Identifier, err)
+		}
+		dir, err := deviceDir(sysfsDir, bus, address)
+		if err != nil {
+			return nil, fmt.Errorf("unable to locate peripheral %s: %w", req.Identifier, err)
+		}
+
+		path := filepath.Join(dir, "power", "control")
+		previous, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("peripheral %s does not support autosuspend: %w", req.Identifier, err)
+		}
+		data := map[string]interface{}{"control": control, "previous": strings.TrimSpace(string(previous))}
+		if data["previous"] == control {
+			return data, nil
+		}
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to change the autosuspend of peripheral %s: %w", req.Identifier, err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(control); err != nil {
+			return nil, fmt.Errorf("unable to change the autosuspend of peripheral %s: %w", req.Identifier, err)
+		}
+		return data, nil
+	}
+}
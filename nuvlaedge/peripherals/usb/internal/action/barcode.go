@@ -0,0 +1,130 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+const (
+	BarcodeTestReadAction = "barcode-test-read"
+
+	defaultTestReadTimeout = 5 * time.Second
+	maxTestReadTimeout     = 30 * time.Second
+)
+
+// BarcodeTestRead returns the handler of the barcode-test-read action. It
+// listens on a node of the scanner for a few seconds and reports whether a
+// scan came through. The scanned data itself is never reported.
+//
+// Params:
+//   - device: node to listen on, one of the scanner hidraw or serial nodes.
+//     Defaults to the first of them.
+//   - timeout: how long to wait for a scan, in seconds (default 5, max 30)
+func BarcodeTestRead(lookup Lookup) Handler {
+	return func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		peripheral, exists := lookup(req.Identifier)
+		if !exists {
+			return nil, fmt.Errorf("peripheral %s is not attached", req.Identifier)
+		}
+		if !peripherals.IsBarcodeScanner(peripheral) {
+			return nil, fmt.Errorf("peripheral %s is not a barcode scanner", req.Identifier)
+		}
+
+		nodes := peripherals.DeviceNodes(peripheral)
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("peripheral %s has no readable node", req.Identifier)
+		}
+		device := nodes[0]
+		if requested, ok := req.Params["device"]; ok {
+			if !contains(nodes, requested) {
+				return nil, fmt.Errorf("%s is not a node of peripheral %s", requested, req.Identifier)
+			}
+			device = requested
+		}
+
+		timeout, err := durationParam(req.Params, "timeout", defaultTestReadTimeout, maxTestReadTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		progress(0, fmt.Sprintf("Waiting %s for a scan on %s", timeout, device))
+		received, err := waitForData(ctx, device, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"device":        device,
+			"timeout":       timeout.Seconds(),
+			"scan-received": received > 0,
+			"bytes":         received,
+		}, nil
+	}
+}
+
+// waitForData opens a device node and returns how many bytes it delivered in
+// its first read, or zero if nothing arrived before the timeout
+func waitForData(ctx context.Context, path string, timeout time.Duration) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	type readResult struct {
+		n   int
+		err error
+	}
+	done := make(chan readResult, 1)
+	go func() {
+		buf := make([]byte, 256)
+		n, err := f.Read(buf)
+		done <- readResult{n, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		if r.n == 0 && r.err != nil {
+			return 0, r.err
+		}
+		return r.n, nil
+	case <-timer.C:
+		return 0, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// durationParam reads a parameter given in seconds, bounded by max
+func durationParam(params map[string]string, key string, fallback time.Duration, max time.Duration) (time.Duration, error) {
+	value, ok := params[key]
+	if !ok {
+		return fallback, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+	duration := time.Duration(seconds * float64(time.Second))
+	if duration > max {
+		return max, nil
+	}
+	return duration, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/lock"
+)
+
+const (
+	ClaimAction   = "claim"
+	ReleaseAction = "release"
+
+	maxClaimTTL = 7 * 24 * time.Hour
+)
+
+// Claim returns the handler of the claim action, which reserves an attached
+// peripheral for an edge application.
+//
+// Params:
+//   - owner: name of the application claiming the peripheral
+//   - ttl: how long the claim holds, in seconds. Without it the claim holds
+//     until released.
+func Claim(registry *lock.Registry, lookup Lookup) Handler {
+	return func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		peripheral, exists := lookup(req.Identifier)
+		if !exists {
+			return nil, fmt.Errorf("peripheral %s is not attached", req.Identifier)
+		}
+		// Applications must not be handed the disk the NuvlaEdge runs from,
+		// nor the console of the host
+		if reason, protected := peripheral.Protected(); protected {
+			return nil, fmt.Errorf("peripheral %s cannot be claimed: %s", req.Identifier, reason)
+		}
+		ttl, err := durationParam(req.Params, "ttl", 0, maxClaimTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		claim, err := registry.Claim(req.Identifier, req.Params["owner"], ttl)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"owner":   claim.Owner,
+			"claimed": claim.Claimed,
+			"expires": claim.Expires,
+		}, nil
+	}
+}
+
+// Release returns the handler of the release action, which frees a peripheral
+// claimed by the given owner.
+//
+// Params:
+//   - owner: name of the application holding the claim
+func Release(registry *lock.Registry) Handler {
+	return func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		if err := registry.Release(req.Identifier, req.Params["owner"]); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"owner": req.Params["owner"]}, nil
+	}
+}
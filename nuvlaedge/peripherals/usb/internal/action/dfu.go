@@ -0,0 +1,107 @@
+package action
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/dfu"
+)
+
+const (
+	DFUFlashAction = "dfu-flash"
+
+	maxImageSize      = 16 << 20
+	imageFetchTimeout = 2 * time.Minute
+)
+
+// DFUFlash returns the handler of the dfu-flash action. It downloads a
+// firmware image and flashes it on a peripheral waiting in DFU mode, once the
+// image matches its digest: the device is not opened before. Peripherals
+// claimed by an application are only flashed on behalf of their owner.
+//
+// Params:
+//   - url: HTTP(S) location of the image, raw or with a DFU suffix
+//   - sha256: expected digest of the image, in hex, required
+//   - owner: application flashing the peripheral, required when it is claimed
+func DFUFlash(lookup Lookup, open dfu.Opener) Handler {
+	client := &http.Client{Timeout: imageFetchTimeout}
+
+	return func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		peripheral, exists := lookup(req.Identifier)
+		if !exists {
+			return nil, fmt.Errorf("peripheral %s is not attached", req.Identifier)
+		}
+		if peripheral.Claimed != nil && *peripheral.Claimed && peripheral.ClaimedBy != req.Params["owner"] {
+			return nil, fmt.Errorf("peripheral %s is claimed by %v", req.Identifier, peripheral.ClaimedBy)
+		}
+		if reason, protected := peripheral.Protected(); protected {
+			return nil, fmt.Errorf("peripheral %s cannot be flashed: %s", req.Identifier, reason)
+		}
+		if peripheral.Firmware == nil || peripheral.Firmware.DFU != "dfu" {
+			return nil, fmt.Errorf("peripheral %s is not in DFU mode", req.Identifier)
+		}
+
+		devicePath := peripheral.DevicePath
+		var bus, address int
+		if _, err := fmt.Sscanf(devicePath, "/dev/bus/usb/%d/%d", &bus, &address); err != nil {
+			return nil, fmt.Errorf("unable to locate peripheral %s: %w", req.Identifier, err)
+		}
+		var vendorID, productID uint16
+		if _, err := fmt.Sscanf(req.Identifier, "%04x:%04x", &vendorID, &productID); err != nil {
+			return nil, fmt.Errorf("invalid identifier %s", req.Identifier)
+		}
+
+		url := req.Params["url"]
+		if len(url) == 0 {
+			return nil, fmt.Errorf("missing image url")
+		}
+		checksum := req.Params["sha256"]
+		if digest, err := hex.DecodeString(checksum); err != nil || len(digest) != 32 {
+			return nil, fmt.Errorf("missing or invalid image sha256")
+		}
+		progress(0, fmt.Sprintf("Downloading %s", url))
+		image, err := dfu.Fetch(ctx, client, url, checksum, maxImageSize)
+		if err != nil {
+			return nil, err
+		}
+		firmware, suffix, err := dfu.SplitSuffix(image)
+		if err != nil {
+			return nil, err
+		}
+		if suffix != nil && !suffix.Matches(vendorID, productID) {
+			return nil, fmt.Errorf("image is meant for device %04x:%04x", suffix.VendorID, suffix.ProductID)
+		}
+
+		dev, err := open(bus, address)
+		if err != nil {
+			return nil, err
+		}
+		defer dev.Close()
+
+		config, err := dfu.ReadConfigDescriptor(dev)
+		if err != nil {
+			return nil, err
+		}
+		intf, err := dfu.FindInterface(config)
+		if err != nil {
+			return nil, err
+		}
+
+		started := time.Now()
+		err = dfu.Download(ctx, dev, intf, firmware, func(sent int, total int) {
+			progress(sent*100/total, fmt.Sprintf("Flashed %d of %d bytes", sent, total))
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{
+			"url":      url,
+			"bytes":    len(firmware),
+			"duration": time.Since(started).Seconds(),
+		}, nil
+	}
+}
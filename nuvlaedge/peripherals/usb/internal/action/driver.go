@@ -0,0 +1,210 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	DriverUnbindAction = "driver-unbind"
+	DriverBindAction   = "driver-bind"
+)
+
+// DriverUnbind returns the handler of the driver-unbind action, which detaches
+// the kernel driver bound to an interface of a peripheral, as ftdi_sio
+// holding a serial adapter meant for a custom driver or a user space
+// application. Peripherals claimed by an application are only changed on
+// behalf of their owner, and the disks the NuvlaEdge needs are refused.
+//
+// Params:
+//   - interface: number of the interface, as reported in the drivers
+//   - driver: driver expected to be bound, the action is refused when another
+//     one is, optional
+//   - owner: application changing the peripheral, required when it is claimed
+func DriverUnbind(lookup Lookup, sysfsDir string) Handler {
+	return func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		dir, name, err := driverTarget(lookup, sysfsDir, req)
+		if err != nil {
+			return nil, err
+		}
+		bound := boundDriver(dir)
+		if len(bound) == 0 {
+			return nil, fmt.Errorf("no driver is bound to interface %s of peripheral %s", req.Params["interface"], req.Identifier)
+		}
+		if expected := req.Params["driver"]; len(expected) > 0 && expected != bound {
+			return nil, fmt.Errorf("interface %s of peripheral %s is bound to %s, not %s", req.Params["interface"], req.Identifier, bound, expected)
+		}
+
+		if err := writeDriverFile(sysfsDir, bound, "unbind", name); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"interface": name, "unbound": bound}, nil
+	}
+}
+
+// DriverBind returns the handler of the driver-bind action, which binds a
+// kernel driver to an interface of a peripheral, after unbinding the one
+// bound to it. The same protections as for unbinding apply.
+//
+// Params:
+//   - interface: number of the interface, as reported in the drivers
+//   - driver: name of the driver to bind, as listed in /sys/bus/usb/drivers
+//   - new-id: "true" to add the vendor and product ids of the peripheral to
+//     the ones the driver handles, for the drivers that do not know the device
+//   - owner: application changing the peripheral, required when it is claimed
+func DriverBind(lookup Lookup, sysfsDir string) Handler {
+	return func(ctx context.Context, req Request, progress Progress) (map[string]interface{}, error) {
+		dir, name, err := driverTarget(lookup, sysfsDir, req)
+		if err != nil {
+			return nil, err
+		}
+		driver := req.Params["driver"]
+		if len(driver) == 0 || driver != filepath.Base(driver) || strings.HasPrefix(driver, ".") {
+			return nil, fmt.Errorf("invalid driver %q", driver)
+		}
+		if _, err := os.Stat(filepath.Join(sysfsDir, "bus", "usb", "drivers", driver)); err != nil {
+			return nil, fmt.Errorf("driver %s is not loaded", driver)
+		}
+
+		data := map[string]interface{}{"interface": name, "bound": driver}
+		bound := boundDriver(dir)
+		if bound == driver {
+			return data, nil
+		}
+		if len(bound) > 0 {
+			progress(0, fmt.Sprintf("Unbinding %s", bound))
+			if err := writeDriverFile(sysfsDir, bound, "unbind", name); err != nil {
+				return nil, err
+			}
+			data["unbound"] = bound
+		}
+
+		if req.Params["new-id"] == "true" {
+			var vendorID, productID uint16
+			if _, err := fmt.Sscanf(req.Identifier, "%04x:%04x", &vendorID, &productID); err != nil {
+				return nil, fmt.Errorf("invalid identifier %s", req.Identifier)
+			}
+			// The driver binds the interfaces it now handles by itself
+			err = writeDriverFile(sysfsDir, driver, "new_id", fmt.Sprintf("%04x %04x", vendorID, productID))
+		} else {
+			err = writeDriverFile(sysfsDir, driver, "bind", name)
+		}
+		return data, err
+	}
+}
+
+// driverTarget checks the request and returns the sysfs folder of the
+// interface and its name, as 1-1:1.0
+func driverTarget(lookup Lookup, sysfsDir string, req Request) (string, string, error) {
+	peripheral, exists := lookup(req.Identifier)
+	if !exists {
+		return "", "", fmt.Errorf("peripheral %s is not attached", req.Identifier)
+	}
+	if peripheral.Claimed != nil && *peripheral.Claimed && peripheral.ClaimedBy != req.Params["owner"] {
+		return "", "", fmt.Errorf("peripheral %s is claimed by %v", req.Identifier, peripheral.ClaimedBy)
+	}
+	// Unbinding the storage driver of the root disk would take the system
+	// down, and the serial one of the console lock the operators out
+	if reason, protected := peripheral.Protected(); protected {
+		return "", "", fmt.Errorf("the drivers of peripheral %s cannot be changed: %s", req.Identifier, reason)
+	}
+	number, err := strconv.Atoi(req.Params["interface"])
+	if err != nil || number < 0 {
+		return "", "", fmt.Errorf("invalid interface %q", req.Params["interface"])
+	}
+
+	var bus, address int
+	if _, err := fmt.Sscanf(peripheral.DevicePath, "/dev/bus/usb/%d/%d", &bus, &address); err != nil {
+		return "", "", fmt.Errorf("unable to locate peripheral %s: %w", req.Identifier, err)
+	}
+	dir, err := interfaceDir(sysfsDir, bus, address, number)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to locate interface %d of peripheral %s: %w", number, req.Identifier, err)
+	}
+	return dir, filepath.Base(dir), nil
+}
+
+// deviceDir finds the sysfs folder of a device, as 1-1.2
+func deviceDir(sysfsDir string, bus int, address int) (string, error) {
+	devicesDir := filepath.Join(sysfsDir, "bus", "usb", "devices")
+	entries, err := ioutil.ReadDir(devicesDir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), ":") {
+			continue
+		}
+		dir := filepath.Join(devicesDir, entry.Name())
+		if readInt(filepath.Join(dir, "busnum"), 10) == bus && readInt(filepath.Join(dir, "devnum"), 10) == address {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("device %03d/%03d is not in sysfs", bus, address)
+}
+
+// interfaceDirs lists the sysfs folders of the interfaces of the active
+// configuration of a device
+func interfaceDirs(sysfsDir string, bus int, address int) ([]string, error) {
+	device, err := deviceDir(sysfsDir, bus, address)
+	if err != nil {
+		return nil, err
+	}
+	// The interfaces are listed next to the device, as 1-1.2:1.0
+	return filepath.Glob(device + ":*")
+}
+
+// interfaceDir finds the sysfs folder of an interface of the active
+// configuration of a device
+func interfaceDir(sysfsDir string, bus int, address int, number int) (string, error) {
+	dirs, err := interfaceDirs(sysfsDir, bus, address)
+	if err != nil {
+		return "", err
+	}
+	for _, dir := range dirs {
+		if readInt(filepath.Join(dir, "bInterfaceNumber"), 16) == number {
+			return dir, nil
+		}
+	}
+	return "", fmt.Errorf("no interface %d in the active configuration", number)
+}
+
+func readInt(path string, base int) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+	value, err := strconv.ParseInt(strings.TrimSpace(string(data)), base, 32)
+	if err != nil {
+		return -1
+	}
+	return int(value)
+}
+
+// boundDriver returns the name of the driver bound to an interface, empty when
+// none is
+func boundDriver(interfaceDir string) string {
+	driver, err := filepath.EvalSymlinks(filepath.Join(interfaceDir, "driver"))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(driver)
+}
+
+func writeDriverFile(sysfsDir string, driver string, file string, value string) error {
+	path := filepath.Join(sysfsDir, "bus", "usb", "drivers", driver, file)
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("unable to write %s of driver %s: %w", file, driver, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(value); err != nil {
+		return fmt.Errorf("unable to write %s of driver %s: %w", file, driver, err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Package audit keeps an append-only log of the peripheral changes reported
+// by the manager, so operators can reconstruct the history of a device when
+// investigating an incident.
+//
+// Every line of the log is a JSON entry telling which peripheral was added,
+// updated or removed, with the SHA-256 of its reported record:
+//
+//	{"time": "2024-05-01T12:00:00Z", "change": "add", "identifier": "046d:0825", "hash": "9f2c...", "payload": {...}}
+//
+// Removals carry the hash of the last record reported for the peripheral.
+// The log is rotated once it reaches its maximum size, keeping a fixed number
+// of older files named after it with a .1, .2, ... suffix.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of change
+const (
+	Added   = "add"
+	Updated = "update"
+	Removed = "remove"
+)
+
+// Entry is one line of the log
+type Entry struct {
+	Time       string                  `json:"time"`
+	Change     string                  `json:"change"`
+	Identifier string                  `json:"identifier"`
+	Hash       string                  `json:"hash"`
+	Payload    *peripherals.Peripheral `json:"payload,omitempty"`
+}
+
+// Log appends the changes between successive reports to a JSONL file
+type Log struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	mu sync.Mutex
+	// reported holds the hash of the last reported record, by identifier
+	reported map[string]string
+}
+
+// Open opens the log at path, rotated once it exceeds maxSize bytes with up to
+// maxFiles older files kept. The last reported state is rebuilt from the
+// existing files, so a restart does not log every peripheral as added again.
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	l := &Log{Path: path, MaxSize: maxSize, MaxFiles: maxFiles, reported: map[string]string{}}
+	for i := maxFiles; i >= 0; i-- {
+		if err := l.replay(l.file(i)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// file returns the path of the nth rotated file, the current one for 0
+func (l *Log) file(n int) string {
+	if n == 0 {
+		return l.Path
+	}
+	return fmt.Sprintf("%s.%d", l.Path, n)
+}
+
+func (l *Log) replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		// A line cut short by a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if entry.Change == Removed {
+			delete(l.reported, entry.Identifier)
+		} else {
+			l.reported[entry.Identifier] = entry.Hash
+		}
+	}
+	return scanner.Err()
+}
+
+// Hash returns the SHA-256 of the JSON encoding of a record
+func Hash(peripheral peripherals.Peripheral) (string, error) {
+	data, err := json.Marshal(peripheral)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Record logs the changes of a report against the previous one, and returns
+// them. When the log cannot be written, the changes are logged again with the
+// next report.
+func (l *Log) Record(at time.Time, report map[string]peripherals.Peripheral) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	timestamp := at.UTC().Format(time.RFC3339)
+	hashes := make(map[string]string, len(report))
+	var entries []Entry
+
+	identifiers := make([]string, 0, len(report))
+	for identifier := range report {
+		identifiers = append(identifiers, identifier)
+	}
+	sort.Strings(identifiers)
+
+	for _, identifier := range identifiers {
+		peripheral := report[identifier]
+		hash, err := Hash(peripheral)
+		if err != nil {
+			return nil, err
+		}
+		hashes[identifier] = hash
+
+		previous, known := l.reported[identifier]
+		switch {
+		case !known:
+			entries = append(entries, Entry{Time: timestamp, Change: Added, Identifier: identifier, Hash: hash, Payload: &peripheral})
+		case previous != hash:
+			entries = append(entries, Entry{Time: timestamp, Change: Updated, Identifier: identifier, Hash: hash, Payload: &peripheral})
+		}
+	}
+
+	var removed []string
+	for identifier := range l.reported {
+		if _, exists := hashes[identifier]; !exists {
+			removed = append(removed, identifier)
+		}
+	}
+	sort.Strings(removed)
+	for _, identifier := range removed {
+		entries = append(entries, Entry{Time: timestamp, Change: Removed, Identifier: identifier, Hash: l.reported[identifier]})
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if err := l.append(entries); err != nil {
+		return nil, err
+	}
+	l.reported = hashes
+	return entries, nil
+}
+
+func (l *Log) append(entries []Entry) error {
+	var data []byte
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	if info, err := os.Stat(l.Path); err == nil && info.Size() > 0 && info.Size()+int64(len(data)) > l.MaxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate shifts the files by one, dropping the oldest
+func (l *Log) rotate() error {
+	log.Infof("Rotating peripheral audit log %s", l.Path)
+	if l.MaxFiles <= 0 {
+		return os.Remove(l.Path)
+	}
+	for i := l.MaxFiles - 1; i >= 0; i-- {
+		if err := os.Rename(l.file(i), l.file(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func changes(entries []Entry) string {
+	var list []string
+	for _, entry := range entries {
+		list = append(list, entry.Change+" "+entry.Identifier)
+	}
+	return strings.Join(list, ", ")
+}
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	l, err := Open(path, 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	camera := peripherals.Peripheral{Identifier: "046d:0825", Name: "Webcam C270", Available: true}
+	scanner := peripherals.Peripheral{Identifier: "0c2e:0b61", Name: "Barcode scanner", Available: true}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	entries, err := l.Record(now, map[string]peripherals.Peripheral{camera.Identifier: camera, scanner.Identifier: scanner})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := changes(entries); c != "add 046d:0825, add 0c2e:0b61" {
+		t.Errorf("unexpected changes %s", c)
+	}
+	if hash, _ := Hash(camera); entries[0].Hash != hash || entries[0].Payload == nil || entries[0].Time != "2024-05-01T12:00:00Z" {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+
+	// Unchanged records are not logged again
+	entries, err = l.Record(now, map[string]peripherals.Peripheral{camera.Identifier: camera, scanner.Identifier: scanner})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("expected no change, got %v %v", entries, err)
+	}
+
+	camera.SerialNumber = "A1B2C3"
+	entries, err = l.Record(now, map[string]peripherals.Peripheral{camera.Identifier: camera})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := changes(entries); c != "update 046d:0825, remove 0c2e:0b61" {
+		t.Errorf("unexpected changes %s", c)
+	}
+	if hash, _ := Hash(scanner); entries[1].Hash != hash || entries[1].Payload != nil {
+		t.Errorf("expected removal to carry the last hash, got %+v", entries[1])
+	}
+
+	// The state survives a restart
+	reopened, err := Open(path, 1<<20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = reopened.Record(now, map[string]peripherals.Peripheral{camera.Identifier: camera})
+	if err != nil || len(entries) != 0 {
+		t.Errorf("expected no change after restart, got %v %v", entries, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("expected 4 lines in the log, got %d", lines)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 6; i++ {
+		peripheral := peripherals.Peripheral{Identifier: "046d:0825", Name: strings.Repeat("x", i+1)}
+		if _, err := l.Record(time.Now(), map[string]peripherals.Peripheral{peripheral.Identifier: peripheral}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("expected %s to exist: %s", name, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 rotated files, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Package backlog follows the consumption of the buffer files by the agent,
+// so a manager whose reports pile up unread is noticed.
+//
+// The files the agent deletes are seen right away through inotify on Linux,
+// and from the listings of the buffer elsewhere. The backlog is the number,
+// size and age of the files still in the buffer: once the oldest of them
+// waited longer than the stall threshold, the agent is considered to have
+// stopped consuming, which is logged and turns the manager status to a
+// warning until it resumes.
+package backlog
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Status is the backlog of the buffer, as reported in the manager status
+type Status struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// OldestAge is how long the oldest file has waited, in seconds
+	OldestAge float64 `json:"oldest-age"`
+	// Consumed is the number of files the agent consumed since the manager
+	// started, the last one at LastConsumed
+	Consumed     int    `json:"consumed"`
+	LastConsumed string `json:"last-consumed,omitempty"`
+	// Stalled is set once the oldest file waited longer than the threshold
+	Stalled bool `json:"stalled"`
+	// Watching tells whether the deletions are seen through inotify
+	Watching bool `json:"watching"`
+}
+
+// Monitor tracks the files of a buffer folder
+type Monitor struct {
+	Dir        string
+	StallAfter time.Duration
+
+	mu           sync.Mutex
+	seen         map[string]time.Time
+	consumed     int
+	lastConsumed time.Time
+	stalled      bool
+	watching     bool
+}
+
+// New monitors the buffer folder dir, stalled once a file waited longer than
+// stallAfter
+func New(dir string, stallAfter time.Duration) *Monitor {
+	return &Monitor{Dir: dir, StallAfter: stallAfter, seen: map[string]time.Time{}}
+}
+
+// isReport tells the buffer files apart from the other files of the folder
+func isReport(name string) bool {
+	return strings.HasSuffix(name, ".json") && !strings.HasPrefix(name, ".")
+}
+
+// Run watches the deletions of the buffer files until ctx is done. Without
+// inotify, the deletions are only found by the listings of Status.
+func (m *Monitor) Run(ctx context.Context) {
+	err := watch(ctx, m.Dir, func() {
+		m.mu.Lock()
+		m.watching = true
+		m.mu.Unlock()
+	}, m.created, m.deleted)
+	if err != nil && ctx.Err() == nil {
+		log.Infof("Following the consumption of the buffer files from their listings. Reason: %s", err)
+	}
+	m.mu.Lock()
+	m.watching = false
+	m.mu.Unlock()
+}
+
+// created starts following a file written between two listings
+func (m *Monitor) created(name string) {
+	if !isReport(name) {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.seen[name]; !ok {
+		m.seen[name] = time.Now()
+	}
+}
+
+// deleted records the consumption of a file, unless a listing already did
+func (m *Monitor) deleted(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.seen[name]; !ok {
+		return
+	}
+	delete(m.seen, name)
+	m.consumed++
+	m.lastConsumed = time.Now()
+}
+
+// Status lists the buffer and returns its backlog. The files that
+// disappeared since the previous listing without inotify noticing are
+// counted as consumed now.
+func (m *Monitor) Status(now time.Time) Status {
+	entries, err := ioutil.ReadDir(m.Dir)
+	if err != nil {
+		log.Debugf("Unable to list the buffer %s. Reason: %s", m.Dir, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := Status{Watching: m.watching}
+	listed := map[string]bool{}
+	var oldest time.Time
+	for _, entry := range entries {
+		if entry.IsDir() || !isReport(entry.Name()) {
+			continue
+		}
+		listed[entry.Name()] = true
+		since, ok := m.seen[entry.Name()]
+		if !ok {
+			// The files rewritten in place, as by the batch layout, keep the
+			// time they were first seen
+			since = entry.ModTime()
+			if since.After(now) {
+				since = now
+			}
+			m.seen[entry.Name()] = since
+		}
+		status.Files++
+		status.Bytes += entry.Size()
+		if oldest.IsZero() || since.Before(oldest) {
+			oldest = since
+		}
+	}
+	if err == nil {
+		for name := range m.seen {
+			if !listed[name] {
+				delete(m.seen, name)
+				m.consumed++
+				m.lastConsumed = now
+			}
+		}
+	}
+
+	if !oldest.IsZero() {
+		status.OldestAge = now.Sub(oldest).Seconds()
+	}
+	status.Consumed = m.consumed
+	if !m.lastConsumed.IsZero() {
+		status.LastConsumed = m.lastConsumed.UTC().Format(time.RFC3339)
+	}
+
+	stalled := m.StallAfter > 0 && !oldest.IsZero() && now.Sub(oldest) > m.StallAfter
+	switch {
+	case stalled && !m.stalled:
+		log.Warnf("The agent has not consumed the USB reports for %s: %d files are waiting in %s",
+			now.Sub(oldest).Round(time.Second), status.Files, filepath.Clean(m.Dir))
+	case !stalled && m.stalled:
+		log.Infof("The agent consumes the USB reports again")
+	}
+	m.stalled = stalled
+	status.Stalled = stalled
+	return status
+}
@@ -0,0 +1,109 @@
+package backlog
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func write(t *testing.T, dir string, name string, content string, at time.Time) {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Truncate(time.Second)
+	write(t, dir, "20261014091203_usb_1.json", `{"a":1}`, start)
+	write(t, dir, "20261014091233_usb_2.json", `{"b":22}`, start.Add(30*time.Second))
+	// Neither reports nor counted
+	write(t, dir, ".batch-123.json", `{}`, start)
+	write(t, dir, "usb.lock", ``, start)
+
+	m := New(dir, 5*time.Minute)
+	status := m.Status(start.Add(time.Minute))
+	if status.Files != 2 || status.Bytes != 15 || status.OldestAge != 60 || status.Stalled || status.Consumed != 0 {
+		t.Fatalf("Status = %+v", status)
+	}
+
+	// The batch layout rewrites the pending file: it keeps its age
+	write(t, dir, "20261014091233_usb_2.json", `{"b":22,"c":3}`, start.Add(5*time.Minute))
+	if status := m.Status(start.Add(6 * time.Minute)); !status.Stalled || status.OldestAge != 360 {
+		t.Errorf("Status with both files waiting = %+v", status)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "20261014091203_usb_1.json")); err != nil {
+		t.Fatal(err)
+	}
+	status = m.Status(start.Add(7 * time.Minute))
+	if status.Files != 1 || status.Consumed != 1 || status.OldestAge != 390 || !status.Stalled || len(status.LastConsumed) == 0 {
+		t.Errorf("Status after a consumption = %+v", status)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "20261014091233_usb_2.json")); err != nil {
+		t.Fatal(err)
+	}
+	if status := m.Status(start.Add(8 * time.Minute)); status.Files != 0 || status.Consumed != 2 || status.Stalled {
+		t.Errorf("Status of the empty buffer = %+v", status)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("inotify is only available on Linux")
+	}
+	dir := t.TempDir()
+	write(t, dir, "20261014091203_usb_1.json", `{}`, time.Now())
+
+	m := New(dir, time.Minute)
+	m.Status(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !m.Status(time.Now()).Watching {
+		if time.Now().After(deadline) {
+			t.Fatal("The buffer is not watched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := os.Remove(filepath.Join(dir, "20261014091203_usb_1.json")); err != nil {
+		t.Fatal(err)
+	}
+	// The deletion is seen without listing the buffer
+	for {
+		m.mu.Lock()
+		consumed := m.consumed
+		m.mu.Unlock()
+		if consumed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The deletion was not seen")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := m.Status(time.Now()); status.Consumed != 1 || status.Files != 0 {
+		t.Errorf("Status = %+v", status)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The watch did not stop")
+	}
+}
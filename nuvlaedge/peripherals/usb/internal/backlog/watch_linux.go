@@ -0,0 +1,69 @@
+package backlog
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// watch calls created with the name of every file written to dir or moved in,
+// and deleted with the name of every file removed from it or moved out, until
+// ctx is done. started is called once the watch is set.
+func watch(ctx context.Context, dir string, started func(), created func(name string), deleted func(name string)) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// Non-blocking, the file is served by the runtime poller, so closing it
+	// interrupts the read in progress
+	file := os.NewFile(uintptr(fd), "inotify")
+	defer file.Close()
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_DELETE|syscall.IN_MOVED_FROM); err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	started()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			file.Close()
+		case <-done:
+		}
+	}()
+
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := file.Read(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			end := start + int(event.Len)
+			if end > n {
+				break
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				// The folder itself was removed
+				return os.ErrNotExist
+			}
+			if event.Len > 0 {
+				name := string(bytes.TrimRight(buffer[start:end], "\x00"))
+				if event.Mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0 {
+					deleted(name)
+				} else {
+					created(name)
+				}
+			}
+			offset = end
+		}
+	}
+}
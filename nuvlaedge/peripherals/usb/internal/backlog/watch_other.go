@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package backlog
+
+import (
+	"context"
+	"errors"
+)
+
+// watch is not supported without inotify, the deletions are found by the
+// listings
+func watch(ctx context.Context, dir string, started func(), created func(name string), deleted func(name string)) error {
+	return errors.New("inotify is only available on Linux")
+}
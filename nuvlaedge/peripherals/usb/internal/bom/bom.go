@@ -0,0 +1,288 @@
+// Package bom exports the hardware bill of materials of the edge: the
+// peripherals attached, the buses they are on and their firmware versions, as
+// a CycloneDX document, for the inventory evidence regulated industries must
+// keep.
+//
+// The edge is the component of the metadata, the USB buses are device
+// components depending on it, and the peripherals are device components
+// depending on their bus, with their firmware as a nested firmware component:
+//
+//	{"bomFormat": "CycloneDX", "specVersion": "1.5", "serialNumber": "urn:uuid:...", "version": 1,
+//	 "metadata": {"timestamp": "2024-05-01T12:00:00Z", "component": {"type": "device", "bom-ref": "edge", "name": "edge-01"}},
+//	 "components": [{"type": "device", "bom-ref": "usb-bus-1", "name": "USB bus 1"},
+//	                {"type": "device", "bom-ref": "usb:046d:0825", "supplier": {"name": "Logitech, Inc."}, "name": "Webcam C270",
+//	                 "version": "0.10", "components": [{"type": "firmware", "bom-ref": "usb:046d:0825/firmware", ...}]}],
+//	 "dependencies": [{"ref": "edge", "dependsOn": ["usb-bus-1"]}, {"ref": "usb-bus-1", "dependsOn": ["usb:046d:0825"]}]}
+//
+// Every document is signed with the Ed25519 key of the edge. The signature of
+// the document bytes is written next to it, with the .sig extension, and the
+// public key next to the private one, with the .pub extension.
+package bom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// CycloneDX format of the documents
+const (
+	Format      = "CycloneDX"
+	SpecVersion = "1.5"
+)
+
+// Property names, in the nuvlaedge namespace of the CycloneDX taxonomy
+const (
+	propertyPrefix    = "nuvlaedge:"
+	propertyPublicKey = propertyPrefix + "public-key-sha256"
+)
+
+// Document is a CycloneDX bill of materials, limited to the fields describing
+// hardware
+type Document struct {
+	BOMFormat    string       `json:"bomFormat"`
+	SpecVersion  string       `json:"specVersion"`
+	SerialNumber string       `json:"serialNumber"`
+	Version      int          `json:"version"`
+	Metadata     Metadata     `json:"metadata"`
+	Components   []Component  `json:"components"`
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// Metadata tells when and by what the document was made, and for which edge
+type Metadata struct {
+	Timestamp  string     `json:"timestamp"`
+	Tools      Tools      `json:"tools"`
+	Component  Component  `json:"component"`
+	Properties []Property `json:"properties,omitempty"`
+}
+
+// Tools are the programs which made the document
+type Tools struct {
+	Components []Component `json:"components"`
+}
+
+// Component is a piece of hardware, or the firmware of one
+type Component struct {
+	Type        string        `json:"type"`
+	BOMRef      string        `json:"bom-ref,omitempty"`
+	Supplier    *Organization `json:"supplier,omitempty"`
+	Name        string        `json:"name"`
+	Version     string        `json:"version,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Properties  []Property    `json:"properties,omitempty"`
+	Components  []Component   `json:"components,omitempty"`
+}
+
+// Organization is the supplier of a component
+type Organization struct {
+	Name string `json:"name"`
+}
+
+// Property is a name and value pair
+type Property struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Dependency lists the components a component depends on, by reference
+type Dependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// Edge is what the document says of the edge and of the tool making it
+type Edge struct {
+	Name    string
+	Tool    string
+	Version string
+}
+
+// edgeRef is the reference of the edge component
+const edgeRef = "edge"
+
+// busRef returns the reference of the bus of a peripheral, from its usbfs
+// node, or an empty one when it is not on a USB bus
+func busRef(peripheral peripherals.Peripheral) (string, int) {
+	var bus, address int
+	if _, err := fmt.Sscanf(peripheral.DevicePath, "/dev/bus/usb/%03d/%03d", &bus, &address); err != nil {
+		return "", 0
+	}
+	return fmt.Sprintf("usb-bus-%d", bus), bus
+}
+
+// property appends the property when it has a value
+func property(properties []Property, name string, value string) []Property {
+	if len(value) == 0 {
+		return properties
+	}
+	return append(properties, Property{Name: propertyPrefix + name, Value: value})
+}
+
+// component describes a peripheral, with its firmware
+func component(peripheral peripherals.Peripheral) Component {
+	c := Component{Type: "device", BOMRef: "usb:" + peripheral.Identifier, Name: peripheral.Product,
+		Description: peripheral.Description}
+	if len(c.Name) == 0 {
+		c.Name = peripheral.Name
+	}
+	if len(peripheral.Vendor) > 0 {
+		c.Supplier = &Organization{Name: peripheral.Vendor}
+	}
+	c.Properties = property(c.Properties, "identifier", peripheral.Identifier)
+	c.Properties = property(c.Properties, "interface", peripheral.Interface)
+	c.Properties = property(c.Properties, "classes", strings.Join(peripheral.Classes, ","))
+	c.Properties = property(c.Properties, "serial-number", peripheral.SerialNumber)
+	c.Properties = property(c.Properties, "port", peripheral.Port)
+	c.Properties = property(c.Properties, "device-path", peripheral.DevicePath)
+
+	if firmware := peripheral.Firmware; firmware != nil && len(firmware.Version) > 0 {
+		c.Version = firmware.Version
+		f := Component{Type: "firmware", BOMRef: c.BOMRef + "/firmware", Name: c.Name + " firmware",
+			Version: firmware.Version}
+		f.Properties = property(f.Properties, "source", firmware.Source)
+		f.Properties = property(f.Properties, "hardware-revision", firmware.HardwareRevision)
+		f.Properties = property(f.Properties, "dfu", firmware.DFU)
+		c.Components = []Component{f}
+	}
+	return c
+}
+
+// Build makes the bill of materials of the peripherals discovered at now. The
+// serial number identifies the document, as a urn:uuid.
+func Build(now time.Time, serial string, edge Edge, discovered []peripherals.Peripheral) Document {
+	document := Document{
+		BOMFormat:    Format,
+		SpecVersion:  SpecVersion,
+		SerialNumber: serial,
+		Version:      1,
+		Metadata: Metadata{
+			Timestamp: now.UTC().Format(time.RFC3339),
+			Tools:     Tools{Components: []Component{{Type: "application", Name: edge.Tool, Version: edge.Version}}},
+			Component: Component{Type: "device", BOMRef: edgeRef, Name: edge.Name},
+		},
+		Components: []Component{},
+	}
+
+	sorted := append([]peripherals.Peripheral{}, discovered...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Identifier < sorted[j].Identifier })
+
+	buses := map[string]int{}
+	attached := map[string][]string{}
+	var edgeDependencies []string
+	for _, peripheral := range sorted {
+		c := component(peripheral)
+		document.Components = append(document.Components, c)
+		ref, bus := busRef(peripheral)
+		if len(ref) == 0 {
+			edgeDependencies = append(edgeDependencies, c.BOMRef)
+			continue
+		}
+		buses[ref] = bus
+		attached[ref] = append(attached[ref], c.BOMRef)
+	}
+
+	refs := make([]string, 0, len(buses))
+	for ref := range buses {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return buses[refs[i]] < buses[refs[j]] })
+	components := make([]Component, 0, len(refs)+len(document.Components))
+	for _, ref := range refs {
+		components = append(components, Component{Type: "device", BOMRef: ref, Name: fmt.Sprintf("USB bus %d", buses[ref])})
+	}
+	document.Components = append(components, document.Components...)
+
+	document.Dependencies = append(document.Dependencies, Dependency{Ref: edgeRef, DependsOn: append(refs, edgeDependencies...)})
+	for _, ref := range refs {
+		document.Dependencies = append(document.Dependencies, Dependency{Ref: ref, DependsOn: attached[ref]})
+	}
+	return document
+}
+
+// newSerial returns a random urn:uuid, of version 4
+func newSerial() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// Writer writes the signed bill of materials of the scans to a file, at most
+// once per interval
+type Writer struct {
+	Path     string
+	Interval time.Duration
+	Edge     Edge
+	Signer   *Signer
+
+	written time.Time
+}
+
+// New creates a writer of the bill of materials at path, signed with the key
+// at keyPath. The document already there counts as written when it was last
+// modified, so restarts do not rewrite it.
+func New(path string, keyPath string, interval time.Duration, edge Edge) (*Writer, error) {
+	signer, err := LoadSigner(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{Path: path, Interval: interval, Edge: edge, Signer: signer}
+	if info, err := os.Stat(path); err == nil {
+		w.written = info.ModTime()
+	}
+	return w, nil
+}
+
+// Update writes the bill of materials of a scan made at now, and its
+// signature, when the last one is older than the interval, and tells whether
+// it did
+func (w *Writer) Update(now time.Time, discovered []peripherals.Peripheral) (bool, error) {
+	if !w.written.IsZero() && now.Sub(w.written) < w.Interval {
+		return false, nil
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return false, err
+	}
+	document := Build(now, serial, w.Edge, discovered)
+	document.Metadata.Properties = []Property{{Name: propertyPublicKey, Value: w.Signer.Fingerprint()}}
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return false, err
+	}
+
+	// The signature is replaced first, so a reader finding a new document
+	// never verifies it against the former signature
+	if err := writeFile(w.Path+".sig", w.Signer.Sign(data)); err != nil {
+		return false, err
+	}
+	if err := writeFile(w.Path, data); err != nil {
+		return false, err
+	}
+	w.written = now
+	return true, nil
+}
+
+// writeFile replaces a file atomically
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,133 @@
+package bom
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+var discovered = []peripherals.Peripheral{
+	{Identifier: "0781:5581", Vendor: "SanDisk Corp.", Product: "Ultra", Interface: "USB", Classes: []string{"Mass Storage"},
+		DevicePath: "/dev/bus/usb/002/002", Port: "2-1"},
+	{Identifier: "046d:0825", Vendor: "Logitech, Inc.", Product: "Webcam C270", Interface: "USB", Classes: []string{"Video", "Audio"},
+		DevicePath: "/dev/bus/usb/001/004", SerialNumber: "2F3C1A40",
+		Firmware: &peripherals.Firmware{Version: "0.10", Source: "bcdDevice"}},
+	{Identifier: "192.168.1.20", Name: "IP camera", Interface: "HTTP"},
+}
+
+var edge = Edge{Name: "edge-01", Tool: "nuvlaedge-usb-peripheral-manager", Version: "2.14.0"}
+
+func TestBuild(t *testing.T) {
+	document := Build(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), "urn:uuid:1", edge, discovered)
+
+	if document.BOMFormat != Format || document.SpecVersion != SpecVersion || document.Metadata.Timestamp != "2024-05-01T12:00:00Z" ||
+		document.Metadata.Component.Name != "edge-01" || document.Metadata.Tools.Components[0].Version != "2.14.0" {
+		t.Errorf("unexpected header %+v", document)
+	}
+
+	var refs []string
+	for _, c := range document.Components {
+		refs = append(refs, c.BOMRef)
+	}
+	if expected := []string{"usb-bus-1", "usb-bus-2", "usb:046d:0825", "usb:0781:5581", "usb:192.168.1.20"}; !reflect.DeepEqual(refs, expected) {
+		t.Errorf("expected components %v, got %v", expected, refs)
+	}
+
+	webcam := document.Components[2]
+	firmware := Component{Type: "firmware", BOMRef: "usb:046d:0825/firmware", Name: "Webcam C270 firmware", Version: "0.10",
+		Properties: []Property{{Name: "nuvlaedge:source", Value: "bcdDevice"}}}
+	if webcam.Supplier.Name != "Logitech, Inc." || webcam.Version != "0.10" || !reflect.DeepEqual(webcam.Components, []Component{firmware}) {
+		t.Errorf("unexpected webcam component %+v", webcam)
+	}
+	if !reflect.DeepEqual(webcam.Properties[3], Property{Name: "nuvlaedge:serial-number", Value: "2F3C1A40"}) {
+		t.Errorf("expected the serial number of the webcam, got %+v", webcam.Properties)
+	}
+
+	expected := []Dependency{
+		{Ref: "edge", DependsOn: []string{"usb-bus-1", "usb-bus-2", "usb:192.168.1.20"}},
+		{Ref: "usb-bus-1", DependsOn: []string{"usb:046d:0825"}},
+		{Ref: "usb-bus-2", DependsOn: []string{"usb:0781:5581"}},
+	}
+	if !reflect.DeepEqual(document.Dependencies, expected) {
+		t.Errorf("expected dependencies %+v, got %+v", expected, document.Dependencies)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "usb", "hardware-bom.cdx.json")
+	keyPath := filepath.Join(dir, "usb", "bom.key")
+	w, err := New(path, keyPath, time.Hour, edge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if written, err := w.Update(now, discovered); !written || err != nil {
+		t.Fatalf("expected the first scan to be written, got %v %v", written, err)
+	}
+	if written, _ := w.Update(now.Add(time.Minute), nil); written {
+		t.Error("expected the document to be written once per interval")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document Document
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(document.SerialNumber) {
+		t.Errorf("unexpected serial number %s", document.SerialNumber)
+	}
+	if !reflect.DeepEqual(document.Metadata.Properties, []Property{{Name: propertyPublicKey, Value: w.Signer.Fingerprint()}}) {
+		t.Errorf("expected the fingerprint of the key, got %+v", document.Metadata.Properties)
+	}
+
+	// The signature verifies with the published public key
+	signature, _ := os.ReadFile(path + ".sig")
+	published, _ := os.ReadFile(keyPath + ".pub")
+	block, _ := pem.Decode(published)
+	if block == nil {
+		t.Fatalf("expected a PEM public key, got %s", published)
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(public.(ed25519.PublicKey), data, signature) {
+		t.Error("expected the signature to verify")
+	}
+	if info, err := os.Stat(keyPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected a private key only the manager reads, got %v", info)
+	}
+
+	// The key is kept across restarts, and the document counts as written
+	restarted, err := New(path, keyPath, time.Hour, edge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Signer.Fingerprint() != w.Signer.Fingerprint() {
+		t.Error("expected the key to be loaded again")
+	}
+	if written, _ := restarted.Update(time.Now(), discovered); written {
+		t.Error("expected the document of the previous run to count as written")
+	}
+
+	if err := os.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(path, keyPath, time.Hour, edge); err == nil {
+		t.Error("expected an invalid key to be refused")
+	}
+}
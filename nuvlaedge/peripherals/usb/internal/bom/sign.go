@@ -0,0 +1,91 @@
+package bom
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Signer signs the documents with the Ed25519 key of the edge
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// LoadSigner reads the PKCS #8 private key at path, or creates it when there
+// is none. The PKIX public key is written at path with the .pub extension, for
+// the verifiers.
+func LoadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createSigner(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s holds no PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", path)
+	}
+	signer := &Signer{key: key}
+	return signer, signer.writePublicKey(path + ".pub")
+}
+
+func createSigner(path string) (*Signer, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	// The key is only readable by the manager
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	signer := &Signer{key: key}
+	return signer, signer.writePublicKey(path + ".pub")
+}
+
+func (s *Signer) writePublicKey(path string) error {
+	der, err := x509.MarshalPKIXPublicKey(s.PublicKey())
+	if err != nil {
+		return err
+	}
+	return writeFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// PublicKey is the key verifying the signatures
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Fingerprint is the hex SHA-256 of the public key
+func (s *Signer) Fingerprint() string {
+	sum := sha256.Sum256(s.PublicKey())
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign returns the signature of data
+func (s *Signer) Sign(data []byte) []byte {
+	return ed25519.Sign(s.key, data)
+}
@@ -0,0 +1,121 @@
+// Package census sums up the hardware attached to the edge into an anonymous
+// document, which platform teams aggregate across the fleet to learn what
+// hardware it actually has.
+//
+// The census counts the peripherals by class and by model, the vendor and
+// product ids with their names, and the day it was made:
+//
+//	{"version": 1, "date": "2024-05-01", "devices": 3, "classes": {"Video": 2, "Mass Storage": 1},
+//	 "models": [{"id": "046d:0825", "vendor": "Logitech, Inc.", "product": "Webcam C270", "classes": ["Video"], "count": 2}]}
+//
+// Nothing in it tells one device or edge from another: no serial number,
+// device node, port, location, hostname nor time of the day.
+package census
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// Version of the census document
+const Version = 1
+
+// Model is a device model attached to the edge, and how many of it
+type Model struct {
+	ID      string   `json:"id"`
+	Vendor  string   `json:"vendor,omitempty"`
+	Product string   `json:"product,omitempty"`
+	Classes []string `json:"classes"`
+	Count   int      `json:"count"`
+}
+
+// Census is the anonymous hardware census of the edge
+type Census struct {
+	Version int            `json:"version"`
+	Date    string         `json:"date"`
+	Devices int            `json:"devices"`
+	Classes map[string]int `json:"classes"`
+	Models  []Model        `json:"models"`
+}
+
+// modelID drops the serial number of the identifiers, as 046d:0825:2F3C1A40
+func modelID(identifier string) string {
+	parts := strings.SplitN(identifier, ":", 3)
+	if len(parts) < 2 {
+		return identifier
+	}
+	return parts[0] + ":" + parts[1]
+}
+
+// Build makes the census of the peripherals discovered at now
+func Build(now time.Time, discovered []peripherals.Peripheral) Census {
+	census := Census{Version: Version, Date: now.UTC().Format("2006-01-02"), Devices: len(discovered),
+		Classes: map[string]int{}, Models: []Model{}}
+
+	models := map[string]*Model{}
+	for _, peripheral := range discovered {
+		for _, class := range peripheral.Classes {
+			census.Classes[class]++
+		}
+		id := modelID(peripheral.Identifier)
+		model, ok := models[id]
+		if !ok {
+			model = &Model{ID: id, Vendor: peripheral.Vendor, Product: peripheral.Product,
+				Classes: append([]string{}, peripheral.Classes...)}
+			sort.Strings(model.Classes)
+			models[id] = model
+		}
+		model.Count++
+	}
+	for _, model := range models {
+		census.Models = append(census.Models, *model)
+	}
+	sort.Slice(census.Models, func(i, j int) bool { return census.Models[i].ID < census.Models[j].ID })
+	return census
+}
+
+// Writer writes the census of the scans to a file, at most once per interval
+type Writer struct {
+	Path     string
+	Interval time.Duration
+
+	written time.Time
+}
+
+// New creates a writer of the census at path. The census already there counts
+// as written when it was last modified, so restarts do not rewrite it.
+func New(path string, interval time.Duration) *Writer {
+	w := &Writer{Path: path, Interval: interval}
+	if info, err := os.Stat(path); err == nil {
+		w.written = info.ModTime()
+	}
+	return w
+}
+
+// Update writes the census of a scan made at now when the last one is older
+// than the interval, and tells whether it did
+func (w *Writer) Update(now time.Time, discovered []peripherals.Peripheral) (bool, error) {
+	if !w.written.IsZero() && now.Sub(w.written) < w.Interval {
+		return false, nil
+	}
+
+	data, _ := json.Marshal(Build(now, discovered))
+	if err := os.MkdirAll(filepath.Dir(w.Path), os.ModePerm); err != nil {
+		return false, err
+	}
+	tmp := w.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, w.Path); err != nil {
+		return false, err
+	}
+	w.written = now
+	return true, nil
+}
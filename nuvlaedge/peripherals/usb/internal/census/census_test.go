@@ -0,0 +1,73 @@
+package census
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+var discovered = []peripherals.Peripheral{
+	{Identifier: "046d:0825:2F3C1A40", Vendor: "Logitech, Inc.", Product: "Webcam C270", Classes: []string{"Video", "Audio"},
+		DevicePath: "/dev/bus/usb/001/004", SerialNumber: "2F3C1A40"},
+	{Identifier: "046d:0825:8E2D0B11", Vendor: "Logitech, Inc.", Product: "Webcam C270", Classes: []string{"Audio", "Video"},
+		DevicePath: "/dev/bus/usb/001/005", SerialNumber: "8E2D0B11"},
+	{Identifier: "0781:5581", Vendor: "SanDisk Corp.", Product: "Ultra", Classes: []string{"Mass Storage"},
+		DevicePath: "/dev/bus/usb/002/002"},
+}
+
+func TestBuild(t *testing.T) {
+	census := Build(time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC), discovered)
+
+	expected := Census{Version: Version, Date: "2024-05-01", Devices: 3,
+		Classes: map[string]int{"Video": 2, "Audio": 2, "Mass Storage": 1},
+		Models: []Model{
+			{ID: "046d:0825", Vendor: "Logitech, Inc.", Product: "Webcam C270", Classes: []string{"Audio", "Video"}, Count: 2},
+			{ID: "0781:5581", Vendor: "SanDisk Corp.", Product: "Ultra", Classes: []string{"Mass Storage"}, Count: 1},
+		}}
+	if !reflect.DeepEqual(census, expected) {
+		t.Fatalf("Build = %+v, expected %+v", census, expected)
+	}
+
+	data, _ := json.Marshal(census)
+	for _, private := range []string{"2F3C1A40", "8E2D0B11", "/dev/bus/usb", "12:30"} {
+		if strings.Contains(string(data), private) {
+			t.Errorf("census %s tells %s", data, private)
+		}
+	}
+}
+
+func TestWriterUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usb", "census.json")
+	w := New(path, 24*time.Hour)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if written, err := w.Update(start, discovered); err != nil || !written {
+		t.Fatalf("Update = %v, %v", written, err)
+	}
+	if written, _ := w.Update(start.Add(time.Hour), nil); written {
+		t.Errorf("expected no census before the interval")
+	}
+	if written, _ := w.Update(start.Add(24*time.Hour), discovered[2:]); !written {
+		t.Errorf("expected a census once the interval passed")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var census Census
+	if err := json.Unmarshal(data, &census); err != nil || census.Date != "2024-05-02" || census.Devices != 1 {
+		t.Errorf("unexpected census %s: %v", data, err)
+	}
+
+	// A restart does not write the census again before the interval
+	if written, _ := New(path, 24*time.Hour).Update(time.Now(), discovered); written {
+		t.Errorf("expected the census on disk to count as written")
+	}
+}
@@ -0,0 +1,198 @@
+// Package changes keeps the recent changes of the reported peripherals in
+// memory, so the agent can fetch the changes made after a cursor instead of
+// reading every report in full.
+//
+// Cursors are opaque to the readers. They carry the start time of the
+// journal, so a cursor handed out before a restart of the manager, or one
+// whose changes were already dropped, is answered with the full list of the
+// current peripherals, flagged as a reset.
+package changes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/audit"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// Kinds of change, as in the audit log
+const (
+	Added   = audit.Added
+	Updated = audit.Updated
+	Removed = audit.Removed
+)
+
+// ErrInvalidCursor is returned for cursors not handed out by a journal
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Change is a change of a peripheral. Removals carry no record.
+type Change struct {
+	Sequence   int64                   `json:"-"`
+	Time       time.Time               `json:"time"`
+	Change     string                  `json:"change"`
+	Identifier string                  `json:"identifier"`
+	Peripheral *peripherals.Peripheral `json:"peripheral,omitempty"`
+}
+
+// Diff answers a read of the journal
+type Diff struct {
+	// Cursor to read the next changes from
+	Cursor string `json:"cursor"`
+	// Reset tells the changes are the full list of the current peripherals,
+	// as additions, rather than the changes since the cursor
+	Reset   bool     `json:"reset"`
+	Changes []Change `json:"changes"`
+}
+
+// Journal holds the last changes of the reports
+type Journal struct {
+	// MaxChanges is how many changes are kept
+	MaxChanges int
+
+	mu       sync.Mutex
+	epoch    int64
+	sequence int64
+	changes  []Change
+	current  map[string]peripherals.Peripheral
+	hashes   map[string]string
+	// updated is the time of the last report
+	updated time.Time
+}
+
+// New returns an empty journal keeping up to maxChanges changes
+func New(maxChanges int, started time.Time) *Journal {
+	return &Journal{
+		MaxChanges: maxChanges,
+		epoch:      started.UnixNano(),
+		current:    map[string]peripherals.Peripheral{},
+		hashes:     map[string]string{},
+	}
+}
+
+// Record adds the changes of a report against the previous one
+func (j *Journal) Record(at time.Time, report map[string]peripherals.Peripheral) error {
+	hashes := make(map[string]string, len(report))
+	for identifier, peripheral := range report {
+		hash, err := audit.Hash(peripheral)
+		if err != nil {
+			return err
+		}
+		hashes[identifier] = hash
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	identifiers := make([]string, 0, len(hashes)+len(j.hashes))
+	for identifier := range hashes {
+		identifiers = append(identifiers, identifier)
+	}
+	for identifier := range j.hashes {
+		if _, exists := hashes[identifier]; !exists {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	sort.Strings(identifiers)
+
+	for _, identifier := range identifiers {
+		hash, exists := hashes[identifier]
+		previous, existed := j.hashes[identifier]
+		change := Change{Time: at, Identifier: identifier}
+		switch {
+		case exists && !existed:
+			change.Change = Added
+		case exists && hash != previous:
+			change.Change = Updated
+		case !exists:
+			change.Change = Removed
+		default:
+			continue
+		}
+		if exists {
+			peripheral := report[identifier]
+			change.Peripheral = &peripheral
+		}
+		j.sequence++
+		change.Sequence = j.sequence
+		j.changes = append(j.changes, change)
+	}
+	if excess := len(j.changes) - j.MaxChanges; j.MaxChanges > 0 && excess > 0 {
+		j.changes = append([]Change{}, j.changes[excess:]...)
+	}
+
+	j.hashes, j.updated = hashes, at
+	j.current = make(map[string]peripherals.Peripheral, len(report))
+	for identifier, peripheral := range report {
+		j.current[identifier] = peripheral
+	}
+	return nil
+}
+
+func (j *Journal) cursor(sequence int64) string {
+	return fmt.Sprintf("%x-%d", j.epoch, sequence)
+}
+
+// Since returns the changes made after cursor. An empty cursor, one of a
+// previous run, or one older than the changes kept returns the current
+// peripherals as a reset.
+func (j *Journal) Since(cursor string) (Diff, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	sequence, sameRun, err := j.parse(cursor)
+	if err != nil {
+		return Diff{}, err
+	}
+	diff := Diff{Cursor: j.cursor(j.sequence), Changes: []Change{}}
+
+	oldest := j.sequence + 1
+	if len(j.changes) > 0 {
+		oldest = j.changes[0].Sequence
+	}
+	if !sameRun || sequence > j.sequence || sequence+1 < oldest {
+		diff.Reset = true
+		identifiers := make([]string, 0, len(j.current))
+		for identifier := range j.current {
+			identifiers = append(identifiers, identifier)
+		}
+		sort.Strings(identifiers)
+		for _, identifier := range identifiers {
+			peripheral := j.current[identifier]
+			diff.Changes = append(diff.Changes, Change{Time: j.updated, Change: Added, Identifier: identifier, Peripheral: &peripheral})
+		}
+		return diff, nil
+	}
+
+	for _, change := range j.changes {
+		if change.Sequence > sequence {
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+	return diff, nil
+}
+
+// parse decodes a cursor, telling whether it was handed out by this journal
+func (j *Journal) parse(cursor string) (int64, bool, error) {
+	if len(cursor) == 0 {
+		return 0, false, nil
+	}
+	parts := strings.SplitN(cursor, "-", 2)
+	if len(parts) != 2 {
+		return 0, false, ErrInvalidCursor
+	}
+	epoch, err := strconv.ParseInt(parts[0], 16, 64)
+	if err != nil {
+		return 0, false, ErrInvalidCursor
+	}
+	sequence, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || sequence < 0 {
+		return 0, false, ErrInvalidCursor
+	}
+	return sequence, epoch == j.epoch, nil
+}
@@ -0,0 +1,116 @@
+package changes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func summary(diff Diff) []string {
+	var changes []string
+	for _, change := range diff.Changes {
+		changes = append(changes, change.Change+" "+change.Identifier)
+	}
+	return changes
+}
+
+func TestSince(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	j := New(3, at)
+	camera := peripherals.Peripheral{Identifier: "046d:0825", Name: "Webcam C270", Available: true}
+	keyboard := peripherals.Peripheral{Identifier: "413c:2113", Name: "Keyboard", Available: true}
+
+	if err := j.Record(at, map[string]peripherals.Peripheral{"046d:0825": camera}); err != nil {
+		t.Fatal(err)
+	}
+	first, err := j.Since("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Reset || len(first.Changes) != 1 || first.Changes[0].Peripheral.Name != "Webcam C270" {
+		t.Fatalf("expected the current peripherals without a cursor, got %+v", first)
+	}
+
+	// Unchanged reports add no change
+	j.Record(at.Add(time.Minute), map[string]peripherals.Peripheral{"046d:0825": camera})
+	if diff, _ := j.Since(first.Cursor); diff.Reset || len(diff.Changes) != 0 || diff.Cursor != first.Cursor {
+		t.Errorf("expected no change, got %+v", diff)
+	}
+
+	camera.Available = false
+	j.Record(at.Add(2*time.Minute), map[string]peripherals.Peripheral{"046d:0825": camera, "413c:2113": keyboard})
+	second, _ := j.Since(first.Cursor)
+	if changes := summary(second); second.Reset || len(changes) != 2 || changes[0] != "update 046d:0825" || changes[1] != "add 413c:2113" {
+		t.Errorf("expected the update and the addition, got %v", changes)
+	}
+
+	j.Record(at.Add(3*time.Minute), map[string]peripherals.Peripheral{"413c:2113": keyboard})
+	third, _ := j.Since(second.Cursor)
+	if changes := summary(third); len(changes) != 1 || changes[0] != "remove 046d:0825" || third.Changes[0].Peripheral != nil {
+		t.Errorf("expected the removal, got %+v", third)
+	}
+
+	// The first changes were dropped, the old cursor gets a reset
+	if diff, _ := j.Since(first.Cursor); diff.Reset {
+		t.Errorf("expected the changes after the first cursor to still be kept, got %+v", diff)
+	}
+	j.Record(at.Add(4*time.Minute), map[string]peripherals.Peripheral{})
+	if diff, _ := j.Since(first.Cursor); !diff.Reset || len(diff.Changes) != 0 {
+		t.Errorf("expected a reset once the changes are dropped, got %+v", diff)
+	}
+
+	// Cursors of a previous run get a reset too
+	if diff, _ := New(3, at.Add(time.Hour)).Since(third.Cursor); !diff.Reset {
+		t.Errorf("expected a reset for the cursor of another run, got %+v", diff)
+	}
+	if _, err := j.Since("tomorrow"); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected an invalid cursor, got %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	j := New(10, time.Now())
+	j.Record(time.Now(), map[string]peripherals.Peripheral{"046d:0825": {Identifier: "046d:0825"}})
+	server := httptest.NewServer(Handler(j))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + DiffPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var diff Diff
+	if err := json.NewDecoder(response.Body).Decode(&diff); err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if !diff.Reset || len(diff.Changes) != 1 {
+		t.Errorf("expected the current peripherals, got %+v", diff)
+	}
+
+	response, err = http.Get(server.URL + DiffPath + "?since=" + diff.Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var next Diff
+	json.NewDecoder(response.Body).Decode(&next)
+	response.Body.Close()
+	if next.Reset || len(next.Changes) != 0 {
+		t.Errorf("expected no change since the cursor, got %+v", next)
+	}
+
+	response, _ = http.Get(server.URL + DiffPath + "?since=invalid")
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an invalid cursor to be rejected, got %d", response.StatusCode)
+	}
+	response, _ = http.Post(server.URL+DiffPath, "application/json", nil)
+	response.Body.Close()
+	if response.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected only GET to be allowed, got %d", response.StatusCode)
+	}
+}
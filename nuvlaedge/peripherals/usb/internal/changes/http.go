@@ -0,0 +1,34 @@
+package changes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// DiffPath is where the handler answers
+const DiffPath = "/api/peripherals/diff"
+
+// Handler serves GET /api/peripherals/diff?since=<cursor> from a journal
+func Handler(j *Journal) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DiffPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		diff, err := j.Since(r.URL.Query().Get("since"))
+		if errors.Is(err, ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(diff)
+	})
+	return mux
+}
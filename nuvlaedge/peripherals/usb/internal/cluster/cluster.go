@@ -0,0 +1,166 @@
+// Package cluster aggregates the peripherals of the NuvlaEdge nodes of a
+// cluster into one inventory.
+//
+// The nodes share a folder, typically a volume mounted on all of them. Every
+// node publishes its last report under nodes/, and the leader merges the
+// fresh ones into inventory.json, attributing each peripheral to its node:
+//
+//	{
+//	  "time": "2024-05-01T12:00:00Z",
+//	  "nodes": ["edge-1", "edge-2"],
+//	  "peripherals": {"edge-1/046d:0825": {..., "node": "edge-1"}}
+//	}
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	nodesFolder   = "nodes"
+	InventoryFile = "inventory.json"
+)
+
+// NodeReport is the last report of a node, as published in the shared folder
+type NodeReport struct {
+	Node        string                            `json:"node"`
+	Time        time.Time                         `json:"time"`
+	Peripherals map[string]peripherals.Peripheral `json:"peripherals"`
+}
+
+// Inventory is the cluster wide list of peripherals, keyed by node and
+// identifier
+type Inventory struct {
+	Time        time.Time                         `json:"time"`
+	Nodes       []string                          `json:"nodes"`
+	Peripherals map[string]peripherals.Peripheral `json:"peripherals"`
+}
+
+// NodeSink publishes the reports of this node in the shared folder
+type NodeSink struct {
+	Dir  string
+	Node string
+}
+
+func (s *NodeSink) Name() string {
+	return "cluster"
+}
+
+func (s *NodeSink) Send(ctx context.Context, report sink.Report) error {
+	if len(s.Node) == 0 || strings.ContainsAny(s.Node, `/\`) {
+		return fmt.Errorf("invalid node name %q", s.Node)
+	}
+	bData, err := json.Marshal(NodeReport{Node: s.Node, Time: report.Time.UTC(), Peripherals: report.Peripherals})
+	if err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(s.Dir, nodesFolder), s.Node+".json", bData)
+}
+
+// Aggregator builds the inventory from the node reports in the shared folder.
+// Reports older than StaleAfter belong to nodes that left the cluster and are
+// left out.
+type Aggregator struct {
+	Dir        string
+	StaleAfter time.Duration
+}
+
+// Inventory merges the fresh node reports
+func (a *Aggregator) Inventory(now time.Time) (Inventory, error) {
+	inventory := Inventory{Time: now, Nodes: []string{}, Peripherals: map[string]peripherals.Peripheral{}}
+
+	files, err := ioutil.ReadDir(filepath.Join(a.Dir, nodesFolder))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return inventory, nil
+		}
+		return inventory, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		report, err := readNodeReport(filepath.Join(a.Dir, nodesFolder, f.Name()))
+		if err != nil {
+			log.Warnf("Ignoring unreadable cluster report %s. Reason: %s", f.Name(), err)
+			continue
+		}
+		if a.StaleAfter > 0 && now.Sub(report.Time) > a.StaleAfter {
+			continue
+		}
+
+		inventory.Nodes = append(inventory.Nodes, report.Node)
+		for identifier, peripheral := range report.Peripherals {
+			peripheral.Node = report.Node
+			inventory.Peripherals[report.Node+"/"+identifier] = peripheral
+		}
+	}
+	sort.Strings(inventory.Nodes)
+	return inventory, nil
+}
+
+// Run rewrites the inventory at every interval until ctx is done
+func (a *Aggregator) Run(ctx context.Context, interval time.Duration) error {
+	log.Infof("Aggregating the cluster peripherals into %s", filepath.Join(a.Dir, InventoryFile))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		inventory, err := a.Inventory(time.Now().UTC())
+		if err == nil {
+			bData, _ := json.Marshal(inventory)
+			err = writeAtomic(a.Dir, InventoryFile, bData)
+		}
+		if err != nil {
+			log.Errorf("Unable to build the cluster inventory. Reason: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func readNodeReport(path string) (NodeReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return NodeReport{}, err
+	}
+	var report NodeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return NodeReport{}, err
+	}
+	if len(report.Node) == 0 {
+		return NodeReport{}, fmt.Errorf("report without node name")
+	}
+	return report, nil
+}
+
+// writeAtomic replaces a file through a rename, so readers on other nodes
+// never see it half written
+func writeAtomic(dir string, name string, data []byte) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, name))
+}
@@ -0,0 +1,66 @@
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	reports := map[string]sink.Report{
+		"edge-1": {Time: now.Add(-10 * time.Second), Peripherals: map[string]peripherals.Peripheral{
+			"046d:0825": {Identifier: "046d:0825", Name: "Webcam C270"},
+		}},
+		"edge-2": {Time: now.Add(-20 * time.Second), Peripherals: map[string]peripherals.Peripheral{
+			"046d:0825": {Identifier: "046d:0825", Name: "Webcam C270"},
+			"0bda:8153": {Identifier: "0bda:8153", Name: "RTL8153"},
+		}},
+		"edge-3": {Time: now.Add(-time.Hour), Peripherals: map[string]peripherals.Peripheral{
+			"1a86:7523": {Identifier: "1a86:7523", Name: "CH340"},
+		}},
+	}
+	for node, report := range reports {
+		s := &NodeSink{Dir: dir, Node: node}
+		if err := s.Send(context.Background(), report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := &Aggregator{Dir: dir, StaleAfter: time.Minute}
+	inventory, err := a.Inventory(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(inventory.Nodes, []string{"edge-1", "edge-2"}) {
+		t.Errorf("expected stale node to be left out, got %v", inventory.Nodes)
+	}
+	if len(inventory.Peripherals) != 3 {
+		t.Errorf("expected 3 peripherals, got %v", inventory.Peripherals)
+	}
+	if camera := inventory.Peripherals["edge-2/046d:0825"]; camera.Node != "edge-2" {
+		t.Errorf("expected camera to be attributed to edge-2, got %v", camera)
+	}
+}
+
+func TestNodeSinkRejectsPaths(t *testing.T) {
+	s := &NodeSink{Dir: t.TempDir(), Node: "../edge"}
+	if err := s.Send(context.Background(), sink.Report{}); err == nil {
+		t.Error("expected node name with path separators to be refused")
+	}
+}
+
+func TestEmptyInventory(t *testing.T) {
+	a := &Aggregator{Dir: t.TempDir()}
+	inventory, err := a.Inventory(time.Now())
+	if err != nil || len(inventory.Nodes) != 0 {
+		t.Errorf("expected empty inventory, got %+v, %v", inventory, err)
+	}
+}
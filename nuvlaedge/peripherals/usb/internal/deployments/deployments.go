@@ -0,0 +1,352 @@
+// Package deployments cross-references the device paths the running
+// containers map with the discovered peripherals.
+//
+// A container gets the device nodes of its deployment when it is created. Once
+// the device re-enumerates, after a reset or being plugged in another port,
+// its node changes, as /dev/ttyUSB0 becoming /dev/ttyUSB1, and the container
+// keeps a path that no longer exists: the application silently stops getting
+// data. The containers are listed from the local Docker or Kubernetes API, and
+// the paths missing from the host are flagged, with the deployment they
+// belong to.
+//
+// The checker remembers which node of which peripheral every mapped path was.
+// When the peripheral of a missing path is found again with the same node
+// under a new path, the container gets a remediation: the new path to
+// re-publish to its deployment, which the agent runs the moved hooks for.
+package deployments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// Container is a running container mapping device paths of the host
+type Container struct {
+	ID   string
+	Name string
+	// Deployment is the compose project, stack or namespace of the container
+	Deployment string
+	// Devices are the host paths of the devices mapped in the container
+	Devices []string
+}
+
+// Source lists the running containers mapping devices
+type Source interface {
+	Name() string
+	Containers(ctx context.Context) ([]Container, error)
+}
+
+// Reference is a device path mapped in a container
+type Reference struct {
+	Container   string `json:"container"`
+	ContainerID string `json:"container-id"`
+	Deployment  string `json:"deployment,omitempty"`
+	Path        string `json:"path"`
+	// Peripheral is the identifier of the discovered peripheral the path is a
+	// node of
+	Peripheral string `json:"peripheral,omitempty"`
+	// Missing is set when the path no longer exists on the host, MovedTo when
+	// the peripheral was enumerated again under another path
+	Missing bool   `json:"missing,omitempty"`
+	MovedTo string `json:"moved-to,omitempty"`
+}
+
+// Remediation is a container mapping the former path of a device node, which
+// its deployment should be given the new path of
+type Remediation struct {
+	ID          string `json:"id"`
+	Container   string `json:"container"`
+	ContainerID string `json:"container-id"`
+	Deployment  string `json:"deployment,omitempty"`
+	Peripheral  string `json:"peripheral"`
+	Path        string `json:"path"`
+	NewPath     string `json:"new-path"`
+	Since       string `json:"since"`
+}
+
+// Report is the last correlation of the containers with the peripherals, as
+// reported in the manager status
+type Report struct {
+	Time       string      `json:"time"`
+	Source     string      `json:"source"`
+	Error      string      `json:"error,omitempty"`
+	References []Reference `json:"references"`
+	// Missing counts the references to paths that no longer exist
+	Missing int `json:"missing"`
+}
+
+// Node is a device node of a peripheral: its kind, as serial or video, and its
+// rank among the nodes of this kind of the peripheral
+type Node struct {
+	Peripheral string
+	Kind       string
+	Index      int
+}
+
+// nodes indexes the device nodes of the peripherals, by path
+func nodes(discovered []peripherals.Peripheral) map[string]Node {
+	index := map[string]Node{}
+	add := func(path string, n Node) {
+		if len(path) > 0 {
+			index[path] = n
+		}
+	}
+	for _, peripheral := range discovered {
+		id := peripheral.Identifier
+		add(peripheral.DevicePath, Node{id, "usb", 0})
+		if len(peripheral.VideoDevices) == 0 {
+			add(peripheral.VideoDevice, Node{id, "video", 0})
+		}
+		for i, path := range peripheral.VideoDevices {
+			add(path, Node{id, "video", i})
+		}
+		for i, path := range peripheral.SerialDevices {
+			add(path, Node{id, "serial", i})
+		}
+		for i, hid := range peripheral.HID {
+			add(hid.DevicePath, Node{id, "hid", i})
+		}
+		for i, disk := range peripheral.Storage {
+			add(disk.DevicePath, Node{id, "disk", i})
+			for j, partition := range disk.Partitions {
+				add(partition, Node{id, fmt.Sprintf("partition-%d", i), j})
+			}
+		}
+	}
+	return index
+}
+
+// Correlate cross-references the device paths of the containers with the
+// device nodes of the peripherals. The links of the paths, as the ones of
+// /dev/serial/by-id, are resolved with resolve, which fails for the paths
+// that do not exist. The missing paths found in history, the nodes of the
+// previous scans, are followed to the current path of their node.
+func Correlate(containers []Container, discovered []peripherals.Peripheral, resolve func(string) (string, error), history map[string]Node) []Reference {
+	current := nodes(discovered)
+	paths := map[Node]string{}
+	for path, n := range current {
+		paths[n] = path
+	}
+
+	references := []Reference{}
+	for _, container := range containers {
+		for _, path := range container.Devices {
+			reference := Reference{Container: container.Name, ContainerID: container.ID,
+				Deployment: container.Deployment, Path: path}
+			target, err := resolve(path)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				reference.Missing = true
+				if former, known := history[path]; known {
+					reference.Peripheral, reference.MovedTo = former.Peripheral, paths[former]
+				}
+			case err == nil:
+				reference.Peripheral = current[target].Peripheral
+			}
+			if len(reference.Peripheral) == 0 && !reference.Missing {
+				reference.Peripheral = current[path].Peripheral
+			}
+			references = append(references, reference)
+		}
+	}
+	sort.Slice(references, func(i, j int) bool {
+		if references[i].Container != references[j].Container {
+			return references[i].Container < references[j].Container
+		}
+		return references[i].Path < references[j].Path
+	})
+	return references
+}
+
+// Checker correlates the containers with the peripherals, at most once per
+// interval since the containers seldom change. The remediations are written
+// to RemediationsPath, unless empty, as a JSON list.
+type Checker struct {
+	Source           Source
+	Interval         time.Duration
+	Timeout          time.Duration
+	RemediationsPath string
+
+	mu      sync.Mutex
+	report  *Report
+	checked time.Time
+	// missing are the missing references already logged
+	missing map[Reference]bool
+	// history are the nodes the mapped paths were, by path
+	history      map[string]Node
+	remediations map[string]Remediation
+}
+
+// New creates a checker querying source at most once per interval
+func New(source Source, interval time.Duration, timeout time.Duration) *Checker {
+	return &Checker{Source: source, Interval: interval, Timeout: timeout, missing: map[Reference]bool{},
+		history: map[string]Node{}, remediations: map[string]Remediation{}}
+}
+
+// Check correlates the containers with the peripherals discovered at now,
+// unless the last check is more recent than the interval
+func (c *Checker) Check(ctx context.Context, now time.Time, discovered []peripherals.Peripheral) {
+	c.mu.Lock()
+	due := c.checked.IsZero() || now.Sub(c.checked) >= c.Interval
+	c.mu.Unlock()
+	if !due {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	report := &Report{Time: now.UTC().Format(time.RFC3339), Source: c.Source.Name(), References: []Reference{}}
+	containers, err := c.Source.Containers(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		report.Error = err.Error()
+		if c.report == nil || c.report.Error != report.Error {
+			log.Warnf("Unable to list the containers from %s. Reason: %s", report.Source, err)
+		}
+	} else {
+		report.References = Correlate(containers, discovered, filepath.EvalSymlinks, c.history)
+		c.remember(report.References, discovered)
+		c.logMissing(report.References)
+		c.remediate(now, report.References)
+	}
+	for _, reference := range report.References {
+		if reference.Missing {
+			report.Missing++
+		}
+	}
+	c.report, c.checked = report, now
+}
+
+// remember records the nodes of the mapped paths. The nodes of the missing
+// paths are kept as long as a container maps them, to follow their
+// peripheral to its new path.
+func (c *Checker) remember(references []Reference, discovered []peripherals.Peripheral) {
+	current := nodes(discovered)
+	history := map[string]Node{}
+	for _, reference := range references {
+		if n, found := current[reference.Path]; found {
+			history[reference.Path] = n
+		} else if n, known := c.history[reference.Path]; known && reference.Missing {
+			history[reference.Path] = n
+		}
+	}
+	c.history = history
+}
+
+// remediate records the references moved to a new path, and writes them for
+// the agent when they change
+func (c *Checker) remediate(now time.Time, references []Reference) {
+	remediations := map[string]Remediation{}
+	for _, reference := range references {
+		if len(reference.MovedTo) == 0 {
+			continue
+		}
+		id := reference.ContainerID + ":" + reference.Path + ":" + reference.MovedTo
+		remediation, known := c.remediations[id]
+		if !known {
+			remediation = Remediation{ID: id, Container: reference.Container, ContainerID: reference.ContainerID,
+				Deployment: reference.Deployment, Peripheral: reference.Peripheral, Path: reference.Path,
+				NewPath: reference.MovedTo, Since: now.UTC().Format(time.RFC3339)}
+			log.Warnf("Peripheral %s moved from %s to %s, container %s should be given the new path",
+				reference.Peripheral, reference.Path, reference.MovedTo, reference.Container)
+		}
+		remediations[id] = remediation
+	}
+
+	changed := len(remediations) != len(c.remediations)
+	for id := range remediations {
+		if _, known := c.remediations[id]; !known {
+			changed = true
+		}
+	}
+	c.remediations = remediations
+	if changed && len(c.RemediationsPath) > 0 {
+		if err := c.writeRemediations(); err != nil {
+			log.Errorf("Unable to write the device path remediations. Reason: %s", err)
+		}
+	}
+}
+
+// Remediations lists the containers to give the new path of their devices,
+// sorted by ID
+func (c *Checker) Remediations() []Remediation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sortedRemediations()
+}
+
+func (c *Checker) sortedRemediations() []Remediation {
+	remediations := make([]Remediation, 0, len(c.remediations))
+	for _, remediation := range c.remediations {
+		remediations = append(remediations, remediation)
+	}
+	sort.Slice(remediations, func(i, j int) bool { return remediations[i].ID < remediations[j].ID })
+	return remediations
+}
+
+// writeRemediations replaces the remediations file atomically
+func (c *Checker) writeRemediations() error {
+	data, err := json.MarshalIndent(c.sortedRemediations(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.RemediationsPath), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := c.RemediationsPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.RemediationsPath)
+}
+
+// logMissing logs the references when they go missing, and when they are
+// found again or their container is gone
+func (c *Checker) logMissing(references []Reference) {
+	missing := map[Reference]bool{}
+	for _, reference := range references {
+		if !reference.Missing {
+			continue
+		}
+		missing[reference] = true
+		if !c.missing[reference] {
+			deployment := ""
+			if len(reference.Deployment) > 0 {
+				deployment = " of deployment " + reference.Deployment
+			}
+			log.Warnf("Container %s%s maps the device %s, which no longer exists on the host. "+
+				"The device may have been enumerated again under another path", reference.Container, deployment, reference.Path)
+		}
+	}
+	var resolved []string
+	for reference := range c.missing {
+		if !missing[reference] {
+			resolved = append(resolved, reference.Container+":"+reference.Path)
+		}
+	}
+	if len(resolved) > 0 {
+		sort.Strings(resolved)
+		log.Infof("The device paths %s are no longer missing, or their containers are gone", strings.Join(resolved, ", "))
+	}
+	c.missing = missing
+}
+
+// Report returns the last correlation, nil before the first one
+func (c *Checker) Report() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.report
+}
@@ -0,0 +1,222 @@
+package deployments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+var discovered = []peripherals.Peripheral{
+	{Identifier: "1a86:7523", DevicePath: "/dev/bus/usb/001/005", SerialDevices: []string{"/dev/ttyUSB1"}},
+	{Identifier: "046d:0825", DevicePath: "/dev/bus/usb/001/004", VideoDevice: "/dev/video0"},
+}
+
+// resolve stands for the host /dev after the serial adapter was enumerated
+// again, from ttyUSB0 to ttyUSB1
+func resolve(path string) (string, error) {
+	switch path {
+	case "/dev/serial/by-id/usb-1a86_USB_Serial-if00-port0":
+		return "/dev/ttyUSB1", nil
+	case "/dev/ttyUSB1", "/dev/video0", "/dev/i2c-1":
+		return path, nil
+	}
+	return "", &os.PathError{Op: "lstat", Path: path, Err: os.ErrNotExist}
+}
+
+func TestCorrelate(t *testing.T) {
+	containers := []Container{
+		{ID: "a1b2c3d4e5f6", Name: "meter-reader", Deployment: "1f0c5b2e", Devices: []string{"/dev/ttyUSB0"}},
+		{ID: "f6e5d4c3b2a1", Name: "camera", Devices: []string{"/dev/video0", "/dev/i2c-1"}},
+		{ID: "0123456789ab", Name: "logger", Devices: []string{"/dev/serial/by-id/usb-1a86_USB_Serial-if00-port0"}},
+	}
+	expected := []Reference{
+		{Container: "camera", ContainerID: "f6e5d4c3b2a1", Path: "/dev/i2c-1"},
+		{Container: "camera", ContainerID: "f6e5d4c3b2a1", Path: "/dev/video0", Peripheral: "046d:0825"},
+		{Container: "logger", ContainerID: "0123456789ab", Path: "/dev/serial/by-id/usb-1a86_USB_Serial-if00-port0", Peripheral: "1a86:7523"},
+		{Container: "meter-reader", ContainerID: "a1b2c3d4e5f6", Deployment: "1f0c5b2e", Path: "/dev/ttyUSB0", Missing: true},
+	}
+	if references := Correlate(containers, discovered, resolve, nil); !reflect.DeepEqual(references, expected) {
+		t.Errorf("expected %+v, got %+v", expected, references)
+	}
+
+	// The serial adapter was known as ttyUSB0 by a previous scan
+	history := map[string]Node{"/dev/ttyUSB0": {"1a86:7523", "serial", 0}}
+	expected[3].Peripheral, expected[3].MovedTo = "1a86:7523", "/dev/ttyUSB1"
+	if references := Correlate(containers, discovered, resolve, history); !reflect.DeepEqual(references, expected) {
+		t.Errorf("expected %+v, got %+v", expected, references)
+	}
+}
+
+type fakeSource struct {
+	containers []Container
+	err        error
+	calls      int
+}
+
+func (s *fakeSource) Name() string {
+	return "fake"
+}
+
+func (s *fakeSource) Containers(context.Context) ([]Container, error) {
+	s.calls++
+	return s.containers, s.err
+}
+
+func TestChecker(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "ttyUSB0")
+	source := &fakeSource{containers: []Container{{ID: "a1b2c3d4e5f6", Name: "meter-reader", Devices: []string{missing}}}}
+	checker := New(source, time.Minute, time.Second)
+	if checker.Report() != nil {
+		t.Error("expected no report before the first check")
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	checker.Check(context.Background(), now, discovered)
+	report := checker.Report()
+	if report == nil || report.Source != "fake" || report.Missing != 1 || !report.References[0].Missing {
+		t.Fatalf("expected the missing device path to be flagged, got %+v", report)
+	}
+
+	// The containers are listed once per interval
+	checker.Check(context.Background(), now.Add(30*time.Second), discovered)
+	if source.calls != 1 {
+		t.Errorf("expected the containers to be listed once within the interval, got %d", source.calls)
+	}
+
+	source.err = errors.New("connection refused")
+	checker.Check(context.Background(), now.Add(time.Minute), discovered)
+	if report := checker.Report(); report.Error != "connection refused" || report.Missing != 0 {
+		t.Errorf("expected the failure to be reported, got %+v", report)
+	}
+}
+
+func TestCheckerRemediations(t *testing.T) {
+	dev := t.TempDir()
+	former, moved := filepath.Join(dev, "ttyUSB0"), filepath.Join(dev, "ttyUSB1")
+	if err := os.WriteFile(former, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "usb", "remediations.json")
+	source := &fakeSource{containers: []Container{{ID: "a1b2c3d4e5f6", Name: "meter-reader", Deployment: "1f0c5b2e", Devices: []string{former}}}}
+	checker := New(source, time.Minute, time.Second)
+	checker.RemediationsPath = path
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	adapter := peripherals.Peripheral{Identifier: "1a86:7523", SerialDevices: []string{former}}
+	checker.Check(context.Background(), now, []peripherals.Peripheral{adapter})
+	if len(checker.Remediations()) != 0 {
+		t.Fatalf("expected no remediation while the path exists, got %+v", checker.Remediations())
+	}
+
+	// The adapter is enumerated again as ttyUSB1
+	if err := os.Rename(former, moved); err != nil {
+		t.Fatal(err)
+	}
+	adapter.SerialDevices = []string{moved}
+	checker.Check(context.Background(), now.Add(time.Minute), []peripherals.Peripheral{adapter})
+	expected := []Remediation{{ID: "a1b2c3d4e5f6:" + former + ":" + moved, Container: "meter-reader",
+		ContainerID: "a1b2c3d4e5f6", Deployment: "1f0c5b2e", Peripheral: "1a86:7523", Path: former,
+		NewPath: moved, Since: "2024-05-01T12:01:00Z"}}
+	if remediations := checker.Remediations(); !reflect.DeepEqual(remediations, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, remediations)
+	}
+	var written []Remediation
+	data, err := os.ReadFile(path)
+	if err != nil || json.Unmarshal(data, &written) != nil || !reflect.DeepEqual(written, expected) {
+		t.Errorf("expected the remediations to be written, got %s (%v)", data, err)
+	}
+
+	// The remediation lasts until the container maps the new path
+	checker.Check(context.Background(), now.Add(2*time.Minute), []peripherals.Peripheral{adapter})
+	if remediations := checker.Remediations(); !reflect.DeepEqual(remediations, expected) {
+		t.Errorf("expected the remediation to be kept, got %+v", remediations)
+	}
+	source.containers[0].Devices = []string{moved}
+	checker.Check(context.Background(), now.Add(3*time.Minute), []peripherals.Peripheral{adapter})
+	data, _ = os.ReadFile(path)
+	if len(checker.Remediations()) != 0 || string(data) != "[]" {
+		t.Errorf("expected the remediation to be cleared, got %s", data)
+	}
+}
+
+func TestDocker(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/json":
+			fmt.Fprint(w, `[{"Id": "a1b2c3d4e5f6a7b8"}, {"Id": "0123456789abcdef"}, {"Id": "ffffffffffffffff"}]`)
+		case "/containers/a1b2c3d4e5f6a7b8/json":
+			fmt.Fprint(w, `{"Id": "a1b2c3d4e5f6a7b8", "Name": "/meter-reader",
+				"Config": {"Labels": {"com.docker.compose.project": "1f0c5b2e"}},
+				"HostConfig": {"Devices": [{"PathOnHost": "/dev/ttyUSB0", "PathInContainer": "/dev/ttyUSB0"}]},
+				"Mounts": [{"Type": "bind", "Source": "/dev/bus/usb"}, {"Type": "bind", "Source": "/dev/video0"},
+					{"Type": "volume", "Source": "/var/lib/docker/volumes/data"}]}`)
+		case "/containers/0123456789abcdef/json":
+			fmt.Fprint(w, `{"Id": "0123456789abcdef", "Name": "/web", "HostConfig": {"Devices": []}}`)
+		default:
+			// Removed since it was listed
+			http.NotFound(w, r)
+		}
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	containers, err := NewDocker(socket).Containers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Container{{ID: "a1b2c3d4e5f6", Name: "meter-reader", Deployment: "1f0c5b2e",
+		Devices: []string{"/dev/ttyUSB0", "/dev/video0"}}}
+	if !reflect.DeepEqual(containers, expected) {
+		t.Errorf("expected %+v, got %+v", expected, containers)
+	}
+}
+
+func TestKubernetes(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if selector := r.URL.Query().Get("fieldSelector"); selector != "status.phase=Running,spec.nodeName=edge-1" {
+			t.Errorf("unexpected field selector %q", selector)
+		}
+		fmt.Fprint(w, `{"items": [
+			{"metadata": {"name": "meter-reader-7d9f", "namespace": "factory", "uid": "5f1c2e0a-8d4b-4c1e"},
+			 "spec": {"volumes": [{"hostPath": {"path": "/dev/ttyUSB0"}}, {"hostPath": {"path": "/dev"}}, {"configMap": {}}]}},
+			{"metadata": {"name": "web", "namespace": "default", "uid": "9a8b7c6d"}, "spec": {"volumes": []}}]}`)
+	}))
+	defer server.Close()
+
+	source := &Kubernetes{URL: server.URL, Token: "token", Node: "edge-1", Client: server.Client()}
+	containers, err := source.Containers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Container{{ID: "5f1c2e0a-8d4b-4c1e", Name: "meter-reader-7d9f", Deployment: "factory", Devices: []string{"/dev/ttyUSB0"}}}
+	if !reflect.DeepEqual(containers, expected) {
+		t.Errorf("expected %+v, got %+v", expected, containers)
+	}
+
+	source.Token = "expired"
+	var answer *apiError
+	if _, err := source.Containers(context.Background()); !errors.As(err, &answer) || answer.Code != http.StatusUnauthorized {
+		t.Errorf("expected the refusal to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package deployments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultDockerSocket is where the Docker API listens
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// Labels naming the deployment of a container, by preference
+var deploymentLabels = []string{"nuvla.deployment.uuid", "com.docker.stack.namespace", "com.docker.compose.project"}
+
+// Docker lists the containers of the Docker API on a unix socket. The devices
+// of a container are the ones passed with --device, and the paths of /dev
+// bind mounted in it.
+type Docker struct {
+	Socket string
+	Client *http.Client
+}
+
+// NewDocker creates a source querying the Docker API on socket
+func NewDocker(socket string) *Docker {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Docker{Socket: socket, Client: &http.Client{Transport: transport}}
+}
+
+func (d *Docker) Name() string {
+	return "docker"
+}
+
+type dockerContainer struct {
+	ID     string
+	Name   string
+	Config struct {
+		Labels map[string]string
+	}
+	HostConfig struct {
+		Devices []struct {
+			PathOnHost string
+		}
+	}
+	Mounts []struct {
+		Type   string
+		Source string
+	}
+}
+
+func (d *Docker) get(ctx context.Context, path string, v interface{}) error {
+	// The host of the URL is not used, the connections go to the socket
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	return decode(resp, d.Name(), path, v)
+}
+
+// Containers lists the running containers mapping devices
+func (d *Docker) Containers(ctx context.Context) ([]Container, error) {
+	var listed []struct{ Id string }
+	if err := d.get(ctx, "/containers/json", &listed); err != nil {
+		return nil, err
+	}
+
+	var containers []Container
+	for _, entry := range listed {
+		var inspected dockerContainer
+		if err := d.get(ctx, "/containers/"+url.PathEscape(entry.Id)+"/json", &inspected); err != nil {
+			// Containers removed since they were listed are skipped
+			var answer *apiError
+			if errors.As(err, &answer) && answer.Code == http.StatusNotFound {
+				continue
+			}
+			return nil, err
+		}
+		container := Container{ID: shortID(inspected.ID), Name: strings.TrimPrefix(inspected.Name, "/")}
+		for _, label := range deploymentLabels {
+			if value := inspected.Config.Labels[label]; len(value) > 0 {
+				container.Deployment = value
+				break
+			}
+		}
+		for _, device := range inspected.HostConfig.Devices {
+			container.Devices = append(container.Devices, device.PathOnHost)
+		}
+		for _, mount := range inspected.Mounts {
+			if mount.Type == "bind" && isDevicePath(mount.Source) {
+				container.Devices = append(container.Devices, mount.Source)
+			}
+		}
+		if len(container.Devices) > 0 {
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
+
+// isDevicePath tells the paths of device nodes apart from the ones of whole
+// device folders, as /dev or /dev/bus/usb, which always exist
+func isDevicePath(path string) bool {
+	if !strings.HasPrefix(path, "/dev/") {
+		return false
+	}
+	switch strings.TrimSuffix(path, "/") {
+	case "/dev/bus", "/dev/bus/usb", "/dev/serial", "/dev/serial/by-id", "/dev/serial/by-path",
+		"/dev/v4l", "/dev/input", "/dev/snd", "/dev/dri", "/dev/shm", "/dev/mqueue":
+		return false
+	}
+	return true
+}
+
+// shortID is the 12 characters form of the container IDs
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// apiError is an answer of an API other than 200 OK
+type apiError struct {
+	API    string
+	Path   string
+	Code   int
+	Status string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s answered %s to %s", e.API, e.Status, e.Path)
+}
+
+// decode reads the JSON document of an answer of api to path into v
+func decode(resp *http.Response, api string, path string, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return &apiError{API: api, Path: path, Code: resp.StatusCode, Status: resp.Status}
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
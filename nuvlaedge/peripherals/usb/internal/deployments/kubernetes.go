@@ -0,0 +1,114 @@
+package deployments
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ServiceAccountDir holds the credentials Kubernetes mounts in the pods
+const ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Kubernetes lists the running pods of a node from the Kubernetes API, with
+// the credentials of the service account of the manager pod. The devices of a
+// pod are the paths of /dev its hostPath volumes mount.
+type Kubernetes struct {
+	URL    string
+	Token  string
+	Node   string
+	Client *http.Client
+}
+
+// NewKubernetes creates a source listing the pods of node, from the API the
+// pod of the manager is given access to
+func NewKubernetes(node string) (*Kubernetes, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return nil, fmt.Errorf("the manager does not run in a Kubernetes pod")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid certificate authority in %s", filepath.Join(ServiceAccountDir, "ca.crt"))
+	}
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return &Kubernetes{
+		URL:    "https://" + net.JoinHostPort(host, port),
+		Token:  strings.TrimSpace(string(token)),
+		Node:   node,
+		Client: &http.Client{Transport: transport},
+	}, nil
+}
+
+func (k *Kubernetes) Name() string {
+	return "kubernetes"
+}
+
+type podList struct {
+	Items []struct {
+		Metadata struct {
+			Name      string
+			Namespace string
+			UID       string
+		}
+		Spec struct {
+			Volumes []struct {
+				HostPath *struct {
+					Path string
+				}
+			}
+		}
+	}
+}
+
+// Containers lists the running pods of the node mounting devices, one
+// container per pod
+func (k *Kubernetes) Containers(ctx context.Context) ([]Container, error) {
+	selector := "status.phase=Running"
+	if len(k.Node) > 0 {
+		selector += ",spec.nodeName=" + k.Node
+	}
+	path := "/api/v1/pods?fieldSelector=" + url.QueryEscape(selector)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.Token)
+	resp, err := k.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	var pods podList
+	if err := decode(resp, k.Name(), path, &pods); err != nil {
+		return nil, err
+	}
+
+	var containers []Container
+	for _, pod := range pods.Items {
+		container := Container{ID: pod.Metadata.UID, Name: pod.Metadata.Name, Deployment: pod.Metadata.Namespace}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.HostPath != nil && isDevicePath(volume.HostPath.Path) {
+				container.Devices = append(container.Devices, volume.HostPath.Path)
+			}
+		}
+		if len(container.Devices) > 0 {
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
@@ -0,0 +1,187 @@
+// Package events is the bus the subsystems of the manager learn the outcome of
+// the scans from, instead of being called one by one from the scan loop: the
+// status, latency, uptime and action state follow the scans, and the sinks,
+// audit log and change journal the reports.
+//
+// The events are delivered synchronously, in the order the handlers
+// subscribed, so a handler sees the state the previous ones left. Handlers
+// must return quickly and hand the slow work, as the delivery of the reports,
+// to their own goroutines.
+package events
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// Type of an event
+type Type string
+
+const (
+	TypeScanCompleted Type = "scan-completed"
+	TypeDeviceAdded   Type = "device-added"
+	TypeDeviceRemoved Type = "device-removed"
+	TypeReportBuilt   Type = "report-built"
+)
+
+// Event is one of the events below
+type Event interface {
+	Type() Type
+}
+
+// ScanCompleted follows every scan, complete or not: Err is the error the
+// listing failed with, and Recovered is set when the discovery panicked.
+type ScanCompleted struct {
+	Time       time.Time
+	Discovered []peripherals.Peripheral
+	Stats      peripherals.ScanStats
+	Bandwidth  []peripherals.BusBandwidth
+	Err        error
+	Recovered  bool
+}
+
+func (ScanCompleted) Type() Type { return TypeScanCompleted }
+
+// Complete tells whether the scan listed all the devices
+func (e ScanCompleted) Complete() bool {
+	return e.Err == nil && !e.Recovered
+}
+
+// DeviceAdded is a peripheral a complete scan found that the previous one did
+// not
+type DeviceAdded struct {
+	Time       time.Time
+	Peripheral peripherals.Peripheral
+}
+
+func (DeviceAdded) Type() Type { return TypeDeviceAdded }
+
+// DeviceRemoved is a peripheral of the previous complete scan that a complete
+// scan no longer found, in its last known state
+type DeviceRemoved struct {
+	Time       time.Time
+	Peripheral peripherals.Peripheral
+}
+
+func (DeviceRemoved) Type() Type { return TypeDeviceRemoved }
+
+// ReportBuilt carries the report of a scan, once the records are completed,
+// with the discovered peripherals they were built from. HeldBack is set when
+// the report must not be published, during a maintenance.
+type ReportBuilt struct {
+	Report     sink.Report
+	Discovered []peripherals.Peripheral
+	HeldBack   bool
+}
+
+func (ReportBuilt) Type() Type { return TypeReportBuilt }
+
+// Handler handles the events it subscribed to
+type Handler func(Event)
+
+// Bus delivers the events to the handlers subscribed to their type
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[Type][]Handler
+
+	// known are the peripherals of the last complete scan, by identifier
+	known map[string]peripherals.Peripheral
+}
+
+// New creates a bus without subscriber
+func New() *Bus {
+	return &Bus{handlers: map[Type][]Handler{}}
+}
+
+// Subscribe calls handler with the events of the given types
+func (b *Bus) Subscribe(handler Handler, types ...Type) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range types {
+		b.handlers[t] = append(b.handlers[t], handler)
+	}
+}
+
+// OnScanCompleted calls handler after every scan
+func (b *Bus) OnScanCompleted(handler func(ScanCompleted)) {
+	b.Subscribe(func(e Event) { handler(e.(ScanCompleted)) }, TypeScanCompleted)
+}
+
+// OnDeviceAdded calls handler for every peripheral attached
+func (b *Bus) OnDeviceAdded(handler func(DeviceAdded)) {
+	b.Subscribe(func(e Event) { handler(e.(DeviceAdded)) }, TypeDeviceAdded)
+}
+
+// OnDeviceRemoved calls handler for every peripheral detached
+func (b *Bus) OnDeviceRemoved(handler func(DeviceRemoved)) {
+	b.Subscribe(func(e Event) { handler(e.(DeviceRemoved)) }, TypeDeviceRemoved)
+}
+
+// OnReportBuilt calls handler with the report of every scan
+func (b *Bus) OnReportBuilt(handler func(ReportBuilt)) {
+	b.Subscribe(func(e Event) { handler(e.(ReportBuilt)) }, TypeReportBuilt)
+}
+
+// Publish delivers an event to its handlers. A handler panicking is logged,
+// the others still get the event.
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	handlers := b.handlers[event.Type()]
+	b.mu.RUnlock()
+	for _, handler := range handlers {
+		deliver(handler, event)
+	}
+}
+
+func deliver(handler Handler, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("A handler of the %s events panicked: %v", event.Type(), r)
+		}
+	}()
+	handler(event)
+}
+
+// PublishScan publishes the completion of a scan, then, when it is complete,
+// the peripherals added and removed since the previous complete one, by
+// identifier. The first complete scan adds all its peripherals.
+func (b *Bus) PublishScan(scan ScanCompleted) {
+	b.Publish(scan)
+	if !scan.Complete() {
+		return
+	}
+
+	current := make(map[string]peripherals.Peripheral, len(scan.Discovered))
+	for _, peripheral := range scan.Discovered {
+		current[peripheral.Identifier] = peripheral
+	}
+	b.mu.Lock()
+	previous := b.known
+	b.known = current
+	b.mu.Unlock()
+
+	for _, identifier := range sortedKeys(current) {
+		if _, known := previous[identifier]; !known {
+			b.Publish(DeviceAdded{Time: scan.Time, Peripheral: current[identifier]})
+		}
+	}
+	for _, identifier := range sortedKeys(previous) {
+		if _, attached := current[identifier]; !attached {
+			b.Publish(DeviceRemoved{Time: scan.Time, Peripheral: previous[identifier]})
+		}
+	}
+}
+
+func sortedKeys(indexed map[string]peripherals.Peripheral) []string {
+	keys := make([]string, 0, len(indexed))
+	for key := range indexed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestPublishScan(t *testing.T) {
+	bus := New()
+	var received []string
+	bus.OnScanCompleted(func(e ScanCompleted) {
+		received = append(received, "scan "+e.Stats.Started.Format("15:04"))
+	})
+	bus.OnDeviceAdded(func(e DeviceAdded) {
+		received = append(received, "added "+e.Peripheral.Identifier)
+	})
+	bus.OnDeviceRemoved(func(e DeviceRemoved) {
+		received = append(received, "removed "+e.Peripheral.Identifier+" "+e.Peripheral.Name)
+	})
+
+	at := func(clock string) peripherals.ScanStats {
+		started, _ := time.Parse("15:04", clock)
+		return peripherals.ScanStats{Started: started}
+	}
+	camera := peripherals.Peripheral{Identifier: "046d:0825", Name: "Webcam C270"}
+	stick := peripherals.Peripheral{Identifier: "0781:5581", Name: "Ultra"}
+	modem := peripherals.Peripheral{Identifier: "12d1:1506", Name: "Modem"}
+
+	bus.PublishScan(ScanCompleted{Stats: at("10:00"), Discovered: []peripherals.Peripheral{stick, camera}})
+	// Partial scans do not tell what was removed
+	bus.PublishScan(ScanCompleted{Stats: at("10:01"), Discovered: []peripherals.Peripheral{modem}, Err: errors.New("timeout")})
+	bus.PublishScan(ScanCompleted{Stats: at("10:02"), Recovered: true})
+	bus.PublishScan(ScanCompleted{Stats: at("10:03"), Discovered: []peripherals.Peripheral{modem, camera}})
+
+	expected := []string{
+		"scan 10:00", "added 046d:0825", "added 0781:5581",
+		"scan 10:01",
+		"scan 10:02",
+		"scan 10:03", "added 12d1:1506", "removed 0781:5581 Ultra",
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Received %q, expected %q", received, expected)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	bus := New()
+	var reports, all int
+	bus.OnReportBuilt(func(e ReportBuilt) {
+		if len(e.Report.Peripherals) == 1 && !e.HeldBack {
+			reports++
+		}
+	})
+	bus.Subscribe(func(e Event) { panic("broken handler") }, TypeReportBuilt)
+	bus.Subscribe(func(e Event) { all++ }, TypeReportBuilt, TypeScanCompleted)
+
+	bus.Publish(ReportBuilt{Report: sink.Report{Peripherals: map[string]peripherals.Peripheral{"046d:0825": {}}}})
+	bus.Publish(ScanCompleted{})
+	bus.Publish(DeviceAdded{})
+	if reports != 1 || all != 2 {
+		t.Errorf("Delivered %d reports, %d events to the handler of all", reports, all)
+	}
+}
@@ -0,0 +1,69 @@
+// Package grace delays the removal of the peripherals missing from a scan, as
+// USB autosuspend, a reset or a flaky hub make devices disappear for a moment,
+// and every disappearance would otherwise remove the peripheral from Nuvla and
+// create it again.
+//
+// A peripheral missing from a scan keeps being reported with its last record,
+// marked suspect, until it has been missing for the grace period. Found again
+// before, it is reported as usual, without ever having been removed.
+package grace
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// seen is the last record of a peripheral, and when it went missing
+type seen struct {
+	peripheral peripherals.Peripheral
+	missing    time.Time
+}
+
+// Grace holds the peripherals missing for less than the grace period
+type Grace struct {
+	period time.Duration
+	seen   map[string]seen
+}
+
+// New creates a grace period keeping the missing peripherals for period
+func New(period time.Duration) *Grace {
+	return &Grace{period: period, seen: map[string]seen{}}
+}
+
+// Apply returns the report with the peripherals missing for less than the
+// grace period added back as suspects, and their identifiers
+func (g *Grace) Apply(now time.Time, report map[string]peripherals.Peripheral) (map[string]peripherals.Peripheral, []string) {
+	kept := make(map[string]peripherals.Peripheral, len(report))
+	for identifier, peripheral := range report {
+		g.seen[identifier] = seen{peripheral: peripheral}
+		kept[identifier] = peripheral
+	}
+
+	var suspects []string
+	for identifier, last := range g.seen {
+		if _, ok := report[identifier]; ok {
+			continue
+		}
+		if last.missing.IsZero() {
+			last.missing = now
+			g.seen[identifier] = last
+		}
+		removal := last.missing.Add(g.period)
+		if !now.Before(removal) {
+			delete(g.seen, identifier)
+			continue
+		}
+
+		suspect := last.peripheral
+		suspect.Suspect = &peripherals.Suspect{
+			MissingSince: last.missing.UTC().Format(time.RFC3339),
+			RemovalAt:    removal.UTC().Format(time.RFC3339),
+		}
+		kept[identifier] = suspect
+		suspects = append(suspects, identifier)
+	}
+	sort.Strings(suspects)
+	return kept, suspects
+}
@@ -0,0 +1,55 @@
+package grace
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func report(identifiers ...string) map[string]peripherals.Peripheral {
+	message := map[string]peripherals.Peripheral{}
+	for _, identifier := range identifiers {
+		message[identifier] = peripherals.Peripheral{Identifier: identifier, Available: true}
+	}
+	return message
+}
+
+func TestApply(t *testing.T) {
+	g := New(time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if kept, suspects := g.Apply(start, report("046d:0825", "0781:5581")); len(kept) != 2 || suspects != nil {
+		t.Fatalf("Apply = %v, %v", kept, suspects)
+	}
+
+	// The stick autosuspends
+	kept, suspects := g.Apply(start.Add(30*time.Second), report("046d:0825"))
+	if !reflect.DeepEqual(suspects, []string{"0781:5581"}) {
+		t.Fatalf("expected the stick to be suspect, got %v", suspects)
+	}
+	expected := &peripherals.Suspect{MissingSince: "2024-05-01T12:00:30Z", RemovalAt: "2024-05-01T12:01:30Z"}
+	if stick := kept["0781:5581"]; !stick.Available || !reflect.DeepEqual(stick.Suspect, expected) {
+		t.Errorf("unexpected suspect record %+v", stick)
+	}
+
+	// Found again, it was never removed
+	kept, suspects = g.Apply(start.Add(time.Minute), report("046d:0825", "0781:5581"))
+	if len(suspects) != 0 || kept["0781:5581"].Suspect != nil {
+		t.Errorf("expected the stick to be found again, got %v", kept)
+	}
+
+	// Missing for the whole grace period, it is removed
+	g.Apply(start.Add(2*time.Minute), report("0781:5581"))
+	if kept, _ := g.Apply(start.Add(2*time.Minute+59*time.Second), report("0781:5581")); len(kept) != 2 {
+		t.Errorf("expected the camera to be kept during the grace period, got %v", kept)
+	}
+	if kept, suspects := g.Apply(start.Add(3*time.Minute), report("0781:5581")); len(kept) != 1 || len(suspects) != 0 {
+		t.Errorf("expected the camera to be removed, got %v, %v", kept, suspects)
+	}
+	// The camera is gone for good, the stick goes missing in turn
+	if kept, suspects := g.Apply(start.Add(4*time.Minute), report()); kept["0781:5581"].Suspect == nil || len(suspects) != 1 {
+		t.Errorf("expected only the stick to be suspect, got %v, %v", kept, suspects)
+	}
+}
@@ -0,0 +1,410 @@
+// Package health grades the peripherals from the USB errors the kernel reports
+// for them, so failing cables, hubs and power supplies stand out before the
+// devices drop off the bus.
+//
+// The kernel log is read at every scan for the resets, babble errors and
+// over-current conditions of the USB ports:
+//
+//	usb 1-1.2: reset high-speed USB device number 5 using xhci_hcd
+//	uvcvideo 1-1.2:1.0: Failed to submit URB 0 (-75)
+//	usb 1-1-port2: over-current condition
+//
+// The errors of the last window are counted by port, and the peripheral on the
+// port is good, degraded or failing from the thresholds its counters reach.
+// Without access to the kernel log, only the over-current counters sysfs keeps
+// for the hub ports are followed.
+//
+// The over-current conditions are also reported by hub, with the downstream
+// ports they hit and the peripherals these ports powered, as the ones an
+// overloaded hub cuts off vanish from the scans without a trace otherwise.
+package health
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// DefaultKernelLog is the device the kernel log is read from
+const DefaultKernelLog = "/dev/kmsg"
+
+// maxEvents bounds the errors kept in the window, the oldest are dropped
+// first during a storm of errors
+const maxEvents = 10000
+
+// Kinds of errors
+const (
+	kindReset = iota
+	kindBabble
+	kindOverCurrent
+)
+
+// Thresholds are the error counts over the window from which a peripheral is
+// degraded or failing
+type Thresholds struct {
+	DegradedResets     int
+	FailingResets      int
+	DegradedBabble     int
+	FailingBabble      int
+	FailingOverCurrent int
+}
+
+// DefaultThresholds degrade the peripherals reset a few times or babbling, and
+// fail the ones reset all along, babbling all along or drawing too much power
+var DefaultThresholds = Thresholds{
+	DegradedResets:     3,
+	FailingResets:      10,
+	DegradedBabble:     1,
+	FailingBabble:      10,
+	FailingOverCurrent: 1,
+}
+
+// kernelLog reads the records added to the kernel log since the last call
+type kernelLog interface {
+	Records() ([]string, error)
+	Close() error
+}
+
+// event is an error of the peripheral on a port. Peripherals are the ones the
+// port and the hubs behind it held when the error was read.
+type event struct {
+	Port        string
+	Kind        int
+	Time        time.Time
+	Peripherals []string
+}
+
+var (
+	// Messages of a device or of one of its interfaces, as
+	// "usb 1-1.2: ..." or "uvcvideo 1-1.2:1.0: ..."
+	devicePattern = regexp.MustCompile(`^\S+ (\d+-\d+(?:\.\d+)*)(?::\d+\.\d+)?: (.+)$`)
+	// Messages of a hub port, as "usb 1-1-port2: ..." or "usb usb1-port2: ..."
+	portPattern = regexp.MustCompile(`^usb (usb\d+|\d+-\d+(?:\.\d+)*)-port(\d+): (.+)$`)
+	// EOVERFLOW is the status of the transfers the device babbled on
+	babblePattern = regexp.MustCompile(`(?i)babble|-75\b`)
+)
+
+// Monitor follows the USB errors of the kernel log and grades the peripherals
+type Monitor struct {
+	Window     time.Duration
+	Thresholds Thresholds
+	SysfsDir   string
+	ProcDir    string
+
+	kernel   kernelLog
+	events   []event
+	counters map[string]int
+	states   map[string]string
+	// occupants are the peripherals on the ports at the last scan, by port
+	occupants map[string]string
+}
+
+// New creates a monitor of the errors of the last window, read from the kernel
+// log at path. When the log cannot be opened, the monitor falls back to the
+// over-current counters of sysfs and the error is returned with it.
+func New(window time.Duration, path string, sysfsDir string, procDir string) (*Monitor, error) {
+	m := &Monitor{
+		Window:     window,
+		Thresholds: DefaultThresholds,
+		SysfsDir:   sysfsDir,
+		ProcDir:    procDir,
+		counters:   map[string]int{},
+		states:     map[string]string{},
+		occupants:  map[string]string{},
+	}
+	kernel, err := openKernelLog(path)
+	if err != nil {
+		return m, err
+	}
+	m.kernel = kernel
+	return m, nil
+}
+
+// Close stops reading the kernel log
+func (m *Monitor) Close() error {
+	if m.kernel == nil {
+		return nil
+	}
+	return m.kernel.Close()
+}
+
+// Update reads the errors reported since the last scan, made at now, and sets
+// the health of the peripherals attached to a port. The errors are charged to
+// the peripherals the ports held at the last complete scan, as the ones an
+// over-current condition cut off are gone from this one.
+func (m *Monitor) Update(now time.Time, discovered []peripherals.Peripheral, complete bool) {
+	occupants := map[string]string{}
+	for _, peripheral := range discovered {
+		if len(peripheral.Port) > 0 {
+			occupants[peripheral.Port] = peripheral.Identifier
+		}
+	}
+	if len(m.occupants) == 0 {
+		m.occupants = occupants
+	}
+
+	if m.kernel != nil {
+		m.readKernelLog(now)
+	} else {
+		m.readCounters(now, discovered)
+	}
+
+	start := now.Add(-m.Window)
+	kept := m.events[:0]
+	for _, e := range m.events {
+		if e.Time.After(start) {
+			kept = append(kept, e)
+		}
+	}
+	m.events = kept
+
+	counts := map[string]*[3]int{}
+	for _, e := range m.events {
+		if counts[e.Port] == nil {
+			counts[e.Port] = &[3]int{}
+		}
+		counts[e.Port][e.Kind]++
+	}
+
+	states := map[string]string{}
+	for i := range discovered {
+		peripheral := &discovered[i]
+		if len(peripheral.Port) == 0 {
+			continue
+		}
+		health := &peripherals.Health{State: peripherals.HealthGood}
+		if count := counts[peripheral.Port]; count != nil {
+			health.Resets = count[kindReset]
+			health.BabbleErrors = count[kindBabble]
+			health.OverCurrent = count[kindOverCurrent]
+			health.State = m.grade(*health)
+		}
+		if rank(health.State) > rank(m.states[peripheral.Port]) {
+			log.Warnf("Peripheral %s on port %s is %s: %d resets, %d babble errors and %d over-current conditions in the last %s",
+				peripheral.Identifier, peripheral.Port, health.State, health.Resets, health.BabbleErrors, health.OverCurrent, m.Window)
+		}
+		states[peripheral.Port] = health.State
+		peripheral.Health = health
+	}
+	m.states = states
+	if complete {
+		m.occupants = occupants
+	}
+}
+
+// grade returns the state the counters of a peripheral reach
+func (m *Monitor) grade(health peripherals.Health) string {
+	t := m.Thresholds
+	switch {
+	case reached(health.OverCurrent, t.FailingOverCurrent), reached(health.Resets, t.FailingResets),
+		reached(health.BabbleErrors, t.FailingBabble):
+		return peripherals.HealthFailing
+	case reached(health.Resets, t.DegradedResets), reached(health.BabbleErrors, t.DegradedBabble):
+		return peripherals.HealthDegraded
+	}
+	return peripherals.HealthGood
+}
+
+// reached tells whether a count reaches a threshold, zero thresholds being
+// disabled
+func reached(count int, threshold int) bool {
+	return threshold > 0 && count >= threshold
+}
+
+// rank orders the states, from the unknown one to failing
+func rank(state string) int {
+	switch state {
+	case peripherals.HealthGood:
+		return 1
+	case peripherals.HealthDegraded:
+		return 2
+	case peripherals.HealthFailing:
+		return 3
+	}
+	return 0
+}
+
+// readKernelLog adds the errors of the records logged since the last scan.
+// The records are stamped from the boot time, so the ones read at the first
+// scan, logged before the manager started, fall in the window they belong to.
+func (m *Monitor) readKernelLog(now time.Time) {
+	records, err := m.kernel.Records()
+	if err != nil {
+		log.Errorf("Unable to read the kernel log. Reason: %s", err)
+	}
+	boot, known := m.bootTime(now)
+	for _, record := range records {
+		since, message, ok := parseRecord(record)
+		if !ok {
+			continue
+		}
+		port, kind, ok := classify(message)
+		if !ok {
+			continue
+		}
+		at := now
+		if known {
+			at = boot.Add(since)
+		}
+		m.add(now, event{Port: port, Kind: kind, Time: at})
+	}
+}
+
+// readCounters adds the over-current conditions counted by sysfs for the ports
+// of the peripherals since the last scan. The first reading of a port is the
+// baseline, its former conditions are not timed.
+func (m *Monitor) readCounters(now time.Time, discovered []peripherals.Peripheral) {
+	ports := map[string]bool{}
+	for _, peripheral := range discovered {
+		if len(peripheral.Port) > 0 {
+			ports[peripheral.Port] = true
+		}
+	}
+	// The ports of the peripherals cut off since the last scan are read too
+	for port := range m.occupants {
+		ports[port] = true
+	}
+	for port := range ports {
+		path := counterPath(m.SysfsDir, port)
+		if len(path) == 0 {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			continue
+		}
+		if previous, known := m.counters[port]; known {
+			for i := previous; i < count; i++ {
+				m.add(now, event{Port: port, Kind: kindOverCurrent, Time: now})
+			}
+		}
+		m.counters[port] = count
+	}
+}
+
+// add keeps an error read at now, unless it is out of the window already
+func (m *Monitor) add(now time.Time, e event) {
+	if !e.Time.After(now.Add(-m.Window)) {
+		return
+	}
+	e.Peripherals = m.downstream(e.Port)
+	if e.Kind == kindOverCurrent {
+		m.logOverCurrent(e)
+	}
+	if len(m.events) >= maxEvents {
+		m.events = m.events[1:]
+	}
+	m.events = append(m.events, e)
+}
+
+// bootTime returns when the host booted, from the uptime procfs reports
+func (m *Monitor) bootTime(now time.Time) (time.Time, bool) {
+	data, err := ioutil.ReadFile(filepath.Join(m.ProcDir, "uptime"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return time.Time{}, false
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return now.Add(-time.Duration(uptime * float64(time.Second))), true
+}
+
+// parseRecord splits a record of the kernel log, as
+// "6,1234,5678901234,-;usb 1-1.2: reset ...", into the time it was logged
+// since the boot and the first line of its message
+func parseRecord(record string) (time.Duration, string, bool) {
+	separator := strings.IndexByte(record, ';')
+	if separator < 0 {
+		return 0, "", false
+	}
+	fields := strings.Split(record[:separator], ",")
+	if len(fields) < 3 {
+		return 0, "", false
+	}
+	microseconds, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	message := record[separator+1:]
+	if end := strings.IndexByte(message, '\n'); end >= 0 {
+		message = message[:end]
+	}
+	return time.Duration(microseconds) * time.Microsecond, message, true
+}
+
+// classify returns the port and the kind of the error a message reports
+func classify(message string) (string, int, bool) {
+	if match := portPattern.FindStringSubmatch(message); match != nil {
+		port := hubPort(match[1], match[2])
+		switch {
+		case strings.Contains(match[3], "over-current condition"):
+			return port, kindOverCurrent, true
+		// Electromagnetic interference makes the hub disable the port and
+		// enumerate the device again
+		case strings.Contains(match[3], "disabled by hub"):
+			return port, kindReset, true
+		}
+		return "", 0, false
+	}
+	if match := devicePattern.FindStringSubmatch(message); match != nil {
+		switch {
+		case strings.HasPrefix(match[2], "reset ") && strings.Contains(match[2], "USB device number"):
+			return match[1], kindReset, true
+		case babblePattern.MatchString(match[2]):
+			return match[1], kindBabble, true
+		}
+	}
+	return "", 0, false
+}
+
+// hubPort returns the port of the device on a port of a hub, 1-1.2 for port 2
+// of hub 1-1 and 1-2 for port 2 of the root hub usb1
+func hubPort(hub string, port string) string {
+	if strings.HasPrefix(hub, "usb") {
+		return strings.TrimPrefix(hub, "usb") + "-" + port
+	}
+	return hub + "." + port
+}
+
+// portHub returns the hub and the number of its port a device port is on, 1-1
+// and 2 for 1-1.2, usb1 and 2 for 1-2
+func portHub(port string) (string, string, bool) {
+	dash := strings.IndexByte(port, '-')
+	if dash < 0 {
+		return "", "", false
+	}
+	if dot := strings.LastIndexByte(port, '.'); dot >= 0 {
+		return port[:dot], port[dot+1:], true
+	}
+	return "usb" + port[:dash], port[dash+1:], true
+}
+
+// counterPath returns the over-current counter of the hub port of a device
+// port, as bus/usb/devices/1-1/1-1:1.0/1-1-port2/over_current_count for 1-1.2
+func counterPath(sysfsDir string, port string) string {
+	hub, number, ok := portHub(port)
+	if !ok {
+		return ""
+	}
+	hubInterface := hub + ":1.0"
+	if strings.HasPrefix(hub, "usb") {
+		hubInterface = strings.TrimPrefix(hub, "usb") + "-0:1.0"
+	}
+	return filepath.Join(sysfsDir, "bus", "usb", "devices", hub, hubInterface, hub+"-port"+number, "over_current_count")
+}
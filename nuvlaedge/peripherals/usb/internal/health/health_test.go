@@ -0,0 +1,206 @@
+package health
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+type fakeLog struct {
+	records [][]string
+}
+
+func (l *fakeLog) Records() ([]string, error) {
+	if len(l.records) == 0 {
+		return nil, nil
+	}
+	records := l.records[0]
+	l.records = l.records[1:]
+	return records, nil
+}
+
+func (l *fakeLog) Close() error {
+	return nil
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		message string
+		port    string
+		kind    int
+	}{
+		{"usb 1-1.2: reset high-speed USB device number 5 using xhci_hcd", "1-1.2", kindReset},
+		{"uvcvideo 1-1.2:1.0: Failed to submit URB 0 (-75)", "1-1.2", kindBabble},
+		{"usb 3-4: babble error on endpoint 2", "3-4", kindBabble},
+		{"usb 1-1-port2: over-current condition", "1-1.2", kindOverCurrent},
+		{"usb usb1-port3: over-current condition", "1-3", kindOverCurrent},
+		{"usb 2-1-port4: disabled by hub (EMI?), re-enabling...", "2-1.4", kindReset},
+	}
+	for _, test := range tests {
+		port, kind, ok := classify(test.message)
+		if !ok || port != test.port || kind != test.kind {
+			t.Errorf("expected %q to be error %d of port %s, got %d of %s (%v)", test.message, test.kind, test.port, kind, port, ok)
+		}
+	}
+
+	for _, message := range []string{
+		"usb 1-1.2: new high-speed USB device number 5 using xhci_hcd",
+		"usb 1-1-port2: over-current change #1",
+		"xhci_hcd 0000:00:14.0: Port over current",
+	} {
+		if _, _, ok := classify(message); ok {
+			t.Errorf("expected %q not to be an error", message)
+		}
+	}
+
+	since, message, ok := parseRecord("6,1234,5000000,-;usb 1-1.2: reset full-speed USB device number 4 using dwc2\n SUBSYSTEM=usb\n")
+	if !ok || since != 5*time.Second || message != "usb 1-1.2: reset full-speed USB device number 4 using dwc2" {
+		t.Errorf("unexpected record %s %q", since, message)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	proc := t.TempDir()
+	// The host booted an hour ago
+	writeFile(t, filepath.Join(proc, "uptime"), "3600.00 7000.00\n")
+
+	reset := "6,%d,%d,-;usb 1-1.2: reset high-speed USB device number 5 using xhci_hcd"
+	kernel := &fakeLog{records: [][]string{
+		{
+			// Logged right after the boot, out of the window
+			record(reset, 1, 10),
+			record(reset, 2, 3000), record(reset, 3, 3100), record(reset, 4, 3200),
+			"4,5,3300000000,-;uvcvideo 1-1.3:1.0: Non-zero status (-75) in video completion handler.",
+		},
+		{"3,6,3500000000,-;usb 1-1-port3: over-current condition"},
+	}}
+	m := &Monitor{Window: 30 * time.Minute, Thresholds: DefaultThresholds, ProcDir: proc, kernel: kernel,
+		counters: map[string]int{}, states: map[string]string{}}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	discovered := []peripherals.Peripheral{
+		{Identifier: "046d:0825", Port: "1-1.2"},
+		{Identifier: "0bda:5411", Port: "1-1.3"},
+		{Identifier: "1d6b:0002", Port: "1-4"},
+		{Identifier: "192.168.1.20"},
+	}
+	m.Update(now, discovered, true)
+
+	expected := []*peripherals.Health{
+		{State: peripherals.HealthDegraded, Resets: 3},
+		{State: peripherals.HealthDegraded, BabbleErrors: 1},
+		{State: peripherals.HealthGood},
+		nil,
+	}
+	for i, health := range expected {
+		if got := discovered[i].Health; (got == nil) != (health == nil) || (got != nil && *got != *health) {
+			t.Errorf("expected %s to be %+v, got %+v", discovered[i].Identifier, health, got)
+		}
+	}
+
+	// The errors age out of the window
+	m.Update(now.Add(30*time.Minute), discovered, true)
+	if health := discovered[0].Health; health.State != peripherals.HealthGood || health.Resets != 0 {
+		t.Errorf("expected the resets to be forgotten, got %+v", health)
+	}
+	if health := discovered[1].Health; health.State != peripherals.HealthFailing || health.OverCurrent != 1 {
+		t.Errorf("expected the over-current condition to fail the peripheral, got %+v", health)
+	}
+}
+
+func record(format string, sequence int, seconds int) string {
+	return fmt.Sprintf(format, sequence, seconds*1000000)
+}
+
+func TestCounters(t *testing.T) {
+	sysfs := t.TempDir()
+	counter := filepath.Join(sysfs, "bus", "usb", "devices", "1-1", "1-1:1.0", "1-1-port2", "over_current_count")
+	if path := counterPath(sysfs, "1-1.2"); path != counter {
+		t.Errorf("unexpected counter path %s", path)
+	}
+	if path := counterPath(sysfs, "2-3"); path != filepath.Join(sysfs, "bus", "usb", "devices", "usb2", "2-0:1.0", "usb2-port3", "over_current_count") {
+		t.Errorf("unexpected counter path of a root hub port %s", path)
+	}
+
+	// Without the kernel log, the former conditions are the baseline
+	writeFile(t, counter, "2\n")
+	m, err := New(time.Hour, filepath.Join(t.TempDir(), "kmsg"), sysfs, t.TempDir())
+	if err == nil {
+		t.Fatal("expected the missing kernel log to be reported")
+	}
+	defer m.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	discovered := []peripherals.Peripheral{{Identifier: "046d:0825", Port: "1-1.2"}}
+	m.Update(now, discovered, true)
+	if health := discovered[0].Health; health.State != peripherals.HealthGood {
+		t.Errorf("expected the former conditions to be left out, got %+v", health)
+	}
+
+	writeFile(t, counter, "3\n")
+	m.Update(now.Add(time.Minute), discovered, true)
+	if health := discovered[0].Health; health.State != peripherals.HealthFailing || health.OverCurrent != 1 {
+		t.Errorf("expected the new condition to fail the peripheral, got %+v", health)
+	}
+}
+
+func TestOverCurrent(t *testing.T) {
+	proc := t.TempDir()
+	writeFile(t, filepath.Join(proc, "uptime"), "3600.00 7000.00\n")
+	kernel := &fakeLog{records: [][]string{
+		nil,
+		{
+			"3,1,3590000000,-;usb 1-1-port2: over-current condition",
+			// The hub itself is cut off next
+			"3,2,3595000000,-;usb usb1-port1: over-current condition",
+		},
+	}}
+	m := &Monitor{Window: time.Hour, Thresholds: DefaultThresholds, ProcDir: proc, kernel: kernel,
+		counters: map[string]int{}, states: map[string]string{}}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m.Update(now, []peripherals.Peripheral{
+		{Identifier: "2109:3431", Port: "1-1"},
+		{Identifier: "046d:0825", Port: "1-1.2"},
+		{Identifier: "0781:5581", Port: "1-1.3"},
+		{Identifier: "0bda:5411", Port: "1-2"},
+	}, true)
+	if report := m.OverCurrent(); report != nil {
+		t.Errorf("expected no over-current condition, got %+v", report)
+	}
+
+	now = now.Add(time.Minute)
+	discovered := []peripherals.Peripheral{{Identifier: "0bda:5411", Port: "1-2"}}
+	m.Update(now, discovered, true)
+
+	cutOff := []string{"046d:0825", "0781:5581", "2109:3431"}
+	expected := []HubOverCurrent{
+		{Bus: 1, Hub: "1-1", Events: 1, Last: "2024-05-01T12:00:50Z", Ports: []PortOverCurrent{
+			{Port: 2, DevicePort: "1-1.2", Events: 1, Last: "2024-05-01T12:00:50Z",
+				Peripherals: []string{"046d:0825"}, Detached: []string{"046d:0825"}},
+		}},
+		{Bus: 1, Hub: "usb1", Events: 1, Last: "2024-05-01T12:00:55Z", Ports: []PortOverCurrent{
+			{Port: 1, DevicePort: "1-1", Events: 1, Last: "2024-05-01T12:00:55Z", Peripherals: cutOff, Detached: cutOff},
+		}},
+	}
+	if report := m.OverCurrent(); !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected %+v, got %+v", expected, report)
+	}
+	if health := discovered[0].Health; health.State != peripherals.HealthGood {
+		t.Errorf("expected the peripheral on the other port to be good, got %+v", health)
+	}
+}
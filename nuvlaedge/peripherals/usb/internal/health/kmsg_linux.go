@@ -0,0 +1,45 @@
+package health
+
+import (
+	"os"
+	"syscall"
+)
+
+// kmsg reads the kernel log device without blocking, one record per read
+type kmsg struct {
+	fd int
+}
+
+func openKernelLog(path string) (kernelLog, error) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &kmsg{fd: fd}, nil
+}
+
+// Records returns the records logged since the last call, the whole ring
+// buffer at the first one
+func (k *kmsg) Records() ([]string, error) {
+	var records []string
+	buffer := make([]byte, 8192)
+	for {
+		n, err := syscall.Read(k.fd, buffer)
+		switch {
+		case err == syscall.EAGAIN:
+			return records, nil
+		// The records overwritten before being read are skipped
+		case err == syscall.EPIPE, err == syscall.EINTR:
+			continue
+		case err != nil:
+			return records, os.NewSyscallError("read", err)
+		case n == 0:
+			return records, nil
+		}
+		records = append(records, string(buffer[:n]))
+	}
+}
+
+func (k *kmsg) Close() error {
+	return syscall.Close(k.fd)
+}
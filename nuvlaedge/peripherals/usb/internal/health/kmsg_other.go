@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package health
+
+import "errors"
+
+// openKernelLog fails without the kernel log device, the over-current
+// counters are followed instead
+func openKernelLog(path string) (kernelLog, error) {
+	return nil, errors.New("the kernel log can only be read on Linux")
+}
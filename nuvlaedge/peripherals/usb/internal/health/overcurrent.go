@@ -0,0 +1,148 @@
+package health
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HubOverCurrent lists the downstream ports of a hub which had over-current
+// conditions over the window. Root hubs are named after their bus, as usb1.
+type HubOverCurrent struct {
+	Bus    int               `json:"bus"`
+	Hub    string            `json:"hub"`
+	Events int               `json:"events"`
+	Last   string            `json:"last"`
+	Ports  []PortOverCurrent `json:"ports"`
+}
+
+// PortOverCurrent is a port of a hub which had over-current conditions, with
+// the peripherals it held then, directly or through other hubs, and the ones of
+// them no longer attached
+type PortOverCurrent struct {
+	Port        int      `json:"port"`
+	DevicePort  string   `json:"device-port"`
+	Events      int      `json:"events"`
+	Last        string   `json:"last"`
+	Peripherals []string `json:"peripherals,omitempty"`
+	Detached    []string `json:"detached,omitempty"`
+}
+
+// OverCurrent returns the over-current conditions of the window by hub, as of
+// the last update, ordered by bus and hub
+func (m *Monitor) OverCurrent() []HubOverCurrent {
+	attached := map[string]bool{}
+	for _, identifier := range m.occupants {
+		attached[identifier] = true
+	}
+
+	hubs := map[string]*HubOverCurrent{}
+	ports := map[string]*PortOverCurrent{}
+	hubLast := map[string]time.Time{}
+	portLast := map[string]time.Time{}
+	for _, e := range m.events {
+		if e.Kind != kindOverCurrent {
+			continue
+		}
+		hubName, number, ok := portHub(e.Port)
+		if !ok {
+			continue
+		}
+		hub := hubs[hubName]
+		if hub == nil {
+			hub = &HubOverCurrent{Hub: hubName, Bus: busNumber(e.Port)}
+			hubs[hubName] = hub
+		}
+		port := ports[e.Port]
+		if port == nil {
+			n, _ := strconv.Atoi(number)
+			port = &PortOverCurrent{Port: n, DevicePort: e.Port}
+			ports[e.Port] = port
+		}
+		hub.Events++
+		port.Events++
+		if e.Time.After(hubLast[hubName]) {
+			hubLast[hubName] = e.Time
+		}
+		if e.Time.After(portLast[e.Port]) {
+			portLast[e.Port] = e.Time
+		}
+		for _, identifier := range e.Peripherals {
+			if !contains(port.Peripherals, identifier) {
+				port.Peripherals = append(port.Peripherals, identifier)
+			}
+			if !attached[identifier] && !contains(port.Detached, identifier) {
+				port.Detached = append(port.Detached, identifier)
+			}
+		}
+	}
+	if len(hubs) == 0 {
+		return nil
+	}
+
+	for devicePort, port := range ports {
+		port.Last = portLast[devicePort].UTC().Format(time.RFC3339)
+		sort.Strings(port.Peripherals)
+		sort.Strings(port.Detached)
+		hubName, _, _ := portHub(devicePort)
+		hubs[hubName].Ports = append(hubs[hubName].Ports, *port)
+	}
+	report := make([]HubOverCurrent, 0, len(hubs))
+	for hubName, hub := range hubs {
+		hub.Last = hubLast[hubName].UTC().Format(time.RFC3339)
+		sort.Slice(hub.Ports, func(i, j int) bool { return hub.Ports[i].Port < hub.Ports[j].Port })
+		report = append(report, *hub)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Bus != report[j].Bus {
+			return report[i].Bus < report[j].Bus
+		}
+		return report[i].Hub < report[j].Hub
+	})
+	return report
+}
+
+// downstream returns the peripherals the last scan found on a port, or behind
+// the hub plugged in it
+func (m *Monitor) downstream(port string) []string {
+	var identifiers []string
+	for occupied, identifier := range m.occupants {
+		if occupied == port || strings.HasPrefix(occupied, port+".") {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	sort.Strings(identifiers)
+	return identifiers
+}
+
+// logOverCurrent warns of an over-current condition on the port of a hub, with
+// the peripherals it cut off
+func (m *Monitor) logOverCurrent(e event) {
+	hub, number, ok := portHub(e.Port)
+	if !ok {
+		return
+	}
+	if len(e.Peripherals) == 0 {
+		log.Warnf("Over-current condition on port %s of USB hub %s, holding no known peripheral", number, hub)
+		return
+	}
+	log.Warnf("Over-current condition on port %s of USB hub %s, powering peripherals %s", number, hub, strings.Join(e.Peripherals, ", "))
+}
+
+// busNumber returns the bus of a device port, 1 for 1-1.2
+func busNumber(port string) int {
+	bus, _ := strconv.Atoi(port[:strings.IndexByte(port, '-')])
+	return bus
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
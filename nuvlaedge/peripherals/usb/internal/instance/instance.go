@@ -0,0 +1,130 @@
+// Package instance makes sure a single USB peripheral manager reports to a
+// channel, as two of them can end up running side by side after a botched
+// upgrade and report every peripheral twice.
+//
+// The manager holding the lock file reports, and records its PID in it:
+//
+//	{"pid": 42, "host": "nuvlaedge-usb-7f9c", "started": "2024-05-01T12:00:00Z"}
+//
+// The lock is an advisory lock of the file, released by the kernel when the
+// manager exits, so a crashed manager does not keep the others out. A manager
+// that cannot take the lock stands by, and records itself in the conflict
+// file next to it, so the active one reports the conflict in its status.
+package instance
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned when another manager holds the lock
+var ErrLocked = errors.New("another manager instance holds the lock")
+
+// Owner identifies a manager instance
+type Owner struct {
+	PID     int    `json:"pid"`
+	Host    string `json:"host"`
+	Started string `json:"started"`
+	// Seen is when a standing by instance last tried to take the lock
+	Seen string `json:"seen,omitempty"`
+}
+
+// Self describes the running manager
+func Self(started time.Time) Owner {
+	host, _ := os.Hostname()
+	return Owner{PID: os.Getpid(), Host: host, Started: started.UTC().Format(time.RFC3339)}
+}
+
+// Lock is the lock held by the active manager
+type Lock struct {
+	Path string
+	// StaleAfter is how long a conflict is reported after the standing by
+	// instance was last seen
+	StaleAfter time.Duration
+
+	file *os.File
+}
+
+func readOwner(path string) (Owner, error) {
+	var owner Owner
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return owner, err
+	}
+	err = json.Unmarshal(data, &owner)
+	return owner, err
+}
+
+// Acquire takes the lock at path for owner. When another manager holds it,
+// ErrLocked is returned along with that manager, as far as its record tells.
+func Acquire(path string, owner Owner, staleAfter time.Duration) (*Lock, Owner, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, Owner{}, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, Owner{}, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		if errors.Is(err, ErrLocked) {
+			holder, _ := readOwner(path)
+			return nil, holder, ErrLocked
+		}
+		return nil, Owner{}, err
+	}
+
+	bData, _ := json.Marshal(owner)
+	if err := file.Truncate(0); err != nil {
+		file.Close()
+		return nil, Owner{}, err
+	}
+	if _, err := file.WriteAt(bData, 0); err != nil {
+		file.Close()
+		return nil, Owner{}, err
+	}
+	return &Lock{Path: path, StaleAfter: staleAfter, file: file}, owner, nil
+}
+
+// Release gives the lock up
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+func conflictPath(path string) string {
+	return path + ".conflict"
+}
+
+// ReportConflict records an instance standing by because the lock at path is
+// held
+func ReportConflict(path string, owner Owner, at time.Time) error {
+	owner.Seen = at.UTC().Format(time.RFC3339)
+	bData, _ := json.Marshal(owner)
+	tmp := conflictPath(path) + ".tmp"
+	if err := os.WriteFile(tmp, bData, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, conflictPath(path))
+}
+
+// Conflict returns the instance standing by, if one was seen recently
+func (l *Lock) Conflict(now time.Time) *Owner {
+	if l == nil {
+		return nil
+	}
+	owner, err := readOwner(conflictPath(l.Path))
+	if err != nil {
+		return nil
+	}
+	seen, err := time.Parse(time.RFC3339, owner.Seen)
+	if err != nil || now.Sub(seen) > l.StaleAfter {
+		return nil
+	}
+	return &owner
+}
@@ -0,0 +1,17 @@
+package instance
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock of the file, without waiting.
+// Managers in different containers see each other as long as they share the
+// folder of the file.
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package instance
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the lock is only taken on Linux")
+	}
+	path := filepath.Join(t.TempDir(), "usb", "manager.lock")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	first := Owner{PID: 42, Host: "edge-old", Started: "2024-05-01T11:00:00Z"}
+	second := Owner{PID: 43, Host: "edge-new", Started: "2024-05-01T12:00:00Z"}
+
+	lock, _, err := Acquire(path, first, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Conflict(now) != nil {
+		t.Error("expected no conflict with a single instance")
+	}
+
+	_, holder, err := Acquire(path, second, time.Minute)
+	if !errors.Is(err, ErrLocked) || holder.PID != 42 || holder.Host != "edge-old" {
+		t.Fatalf("expected the lock to be held by the first instance, got %+v (%v)", holder, err)
+	}
+	if err := ReportConflict(path, second, now); err != nil {
+		t.Fatal(err)
+	}
+	if conflict := lock.Conflict(now.Add(30 * time.Second)); conflict == nil || conflict.PID != 43 || conflict.Seen != "2024-05-01T12:00:00Z" {
+		t.Errorf("expected the second instance to be reported, got %+v", conflict)
+	}
+	if lock.Conflict(now.Add(2*time.Minute)) != nil {
+		t.Error("expected the conflict to expire once the second instance is gone")
+	}
+
+	// Releasing the lock lets the next instance take over
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	lock, holder, err = Acquire(path, second, time.Minute)
+	if err != nil || holder.PID != 43 {
+		t.Fatalf("expected the second instance to take the lock, got %+v (%v)", holder, err)
+	}
+	lock.Release()
+
+	if owner, err := readOwner(path); err != nil || owner != second {
+		t.Errorf("expected the lock file to record the second instance, got %+v (%v)", owner, err)
+	}
+}
@@ -0,0 +1,190 @@
+// Package latency measures how long the peripherals take to become visible,
+// from their attachment to the report acknowledging them.
+//
+// Each attachment goes through three stages, measured from the attach event:
+//
+//	detected       the scan found the peripheral
+//	reported       a sink wrote the report listing it, for the agent to read
+//	acknowledged   a sink delivering directly to Nuvla or the agent got an answer
+//
+// The durations of the last attachments are kept for every stage, and
+// summarized as percentiles in the manager status, so the peripheral
+// visibility can be monitored fleet-wide. The peripherals attached before the
+// manager started are not measured: their latency is the manager startup.
+package latency
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Stages of the visibility of a peripheral
+const (
+	Detected     = "detected"
+	Reported     = "reported"
+	Acknowledged = "acknowledged"
+)
+
+// Percentiles summarizes the latencies of a stage, in seconds
+type Percentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// attachment is a peripheral attached since the manager started, until it
+// went through every stage
+type attachment struct {
+	attached     time.Time
+	reported     bool
+	acknowledged bool
+}
+
+// Tracker follows the attachments across scans and report deliveries. It is
+// safe for concurrent use, as the deliveries are made by the sink workers.
+type Tracker struct {
+	mu   sync.Mutex
+	size int
+	// acknowledging tells whether a sink acknowledges the reports, the
+	// attachments are only complete once acknowledged then
+	acknowledging bool
+	started       bool
+	// present are the peripherals of the last scan, pending the ones still
+	// waiting for a stage
+	present map[string]bool
+	pending map[string]*attachment
+	samples map[string][]time.Duration
+	next    map[string]int
+}
+
+// New creates a tracker keeping the latencies of the last size attachments.
+// Acknowledging is set when one of the sinks acknowledges the reports.
+func New(size int, acknowledging bool) *Tracker {
+	if size <= 0 {
+		size = 1
+	}
+	return &Tracker{
+		size:          size,
+		acknowledging: acknowledging,
+		present:       map[string]bool{},
+		pending:       map[string]*attachment{},
+		samples:       map[string][]time.Duration{},
+		next:          map[string]int{},
+	}
+}
+
+// Scan records the peripherals found by a scan at the given time, with their
+// attach time when known. The peripherals new since the previous scan start
+// their attachment, from their attach time or, when unknown, from the scan.
+func (t *Tracker) Scan(at time.Time, attached map[string]time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	present := make(map[string]bool, len(attached))
+	for identifier, since := range attached {
+		present[identifier] = true
+		if !t.started || t.present[identifier] {
+			continue
+		}
+		if since.IsZero() || since.After(at) {
+			since = at
+		}
+		t.pending[identifier] = &attachment{attached: since}
+		t.add(Detected, at.Sub(since))
+	}
+	// Peripherals detached before being reported are no longer waited for
+	for identifier := range t.pending {
+		if !present[identifier] {
+			delete(t.pending, identifier)
+		}
+	}
+	t.present = present
+	t.started = true
+}
+
+// Delivered records the delivery of a report listing the given peripherals.
+// Acknowledged deliveries were confirmed by their receiver, the others only
+// written.
+func (t *Tracker) Delivered(identifiers []string, at time.Time, acknowledged bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, identifier := range identifiers {
+		a, ok := t.pending[identifier]
+		if !ok {
+			continue
+		}
+		if !a.reported {
+			a.reported = true
+			t.add(Reported, at.Sub(a.attached))
+		}
+		if acknowledged && !a.acknowledged {
+			a.acknowledged = true
+			t.add(Acknowledged, at.Sub(a.attached))
+		}
+		if a.acknowledged || !t.acknowledging {
+			delete(t.pending, identifier)
+		}
+	}
+}
+
+// Pending returns the attachments still waiting for a stage
+func (t *Tracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+func (t *Tracker) add(stage string, latency time.Duration) {
+	if latency < 0 {
+		latency = 0
+	}
+	samples := t.samples[stage]
+	if len(samples) < t.size {
+		t.samples[stage] = append(samples, latency)
+		return
+	}
+	samples[t.next[stage]] = latency
+	t.next[stage] = (t.next[stage] + 1) % t.size
+}
+
+// Summary returns the percentiles of every stage measured so far
+func (t *Tracker) Summary() map[string]Percentiles {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.samples) == 0 {
+		return nil
+	}
+	summary := make(map[string]Percentiles, len(t.samples))
+	for stage, samples := range t.samples {
+		sorted := append([]time.Duration(nil), samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		summary[stage] = Percentiles{
+			Count: len(sorted),
+			P50:   percentile(sorted, 50),
+			P90:   percentile(sorted, 90),
+			P99:   percentile(sorted, 99),
+			Max:   seconds(sorted[len(sorted)-1]),
+		}
+	}
+	return summary
+}
+
+// percentile is the nearest-rank percentile of sorted samples, in seconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return seconds(sorted[rank-1])
+}
+
+// seconds rounds a latency to the millisecond
+func seconds(d time.Duration) float64 {
+	return math.Round(d.Seconds()*1000) / 1000
+}
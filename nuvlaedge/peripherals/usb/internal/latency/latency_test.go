@@ -0,0 +1,83 @@
+package latency
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrackerStages(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker := New(10, true)
+
+	// Attached before the manager started
+	tracker.Scan(start, map[string]time.Time{"046d:0825": start.Add(-time.Hour)})
+	// Attached 2s before the scan, and one without a known attach time
+	tracker.Scan(start.Add(10*time.Second), map[string]time.Time{
+		"046d:0825": {},
+		"0c2e:0b61": start.Add(8 * time.Second),
+		"1a86:7523": {},
+	})
+	if pending := tracker.Pending(); pending != 2 {
+		t.Fatalf("expected 2 attachments pending, got %d", pending)
+	}
+
+	tracker.Delivered([]string{"046d:0825", "0c2e:0b61", "1a86:7523"}, start.Add(11*time.Second), false)
+	tracker.Delivered([]string{"0c2e:0b61", "1a86:7523"}, start.Add(14*time.Second), true)
+	if pending := tracker.Pending(); pending != 0 {
+		t.Errorf("expected the acknowledged attachments to be complete, got %d pending", pending)
+	}
+
+	summary := tracker.Summary()
+	if _, ok := summary[Detected]; !ok || summary[Detected].Count != 2 {
+		t.Fatalf("expected 2 detections, got %+v", summary)
+	}
+	if summary[Detected].Max != 2 || summary[Detected].P50 != 0 {
+		t.Errorf("unexpected detection latencies %+v", summary[Detected])
+	}
+	if summary[Reported].Max != 3 || summary[Reported].P50 != 1 {
+		t.Errorf("unexpected report latencies %+v", summary[Reported])
+	}
+	if summary[Acknowledged].Max != 6 || summary[Acknowledged].P50 != 4 {
+		t.Errorf("unexpected acknowledgment latencies %+v", summary[Acknowledged])
+	}
+}
+
+func TestTrackerWithoutAcknowledgment(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker := New(10, false)
+	tracker.Scan(start, nil)
+	tracker.Scan(start.Add(time.Second), map[string]time.Time{"046d:0825": {}, "0c2e:0b61": {}})
+
+	tracker.Delivered([]string{"046d:0825"}, start.Add(2*time.Second), false)
+	if pending := tracker.Pending(); pending != 1 {
+		t.Errorf("expected the written report to complete the attachment, got %d pending", pending)
+	}
+
+	// Detached before being reported
+	tracker.Scan(start.Add(3*time.Second), map[string]time.Time{"046d:0825": {}})
+	if pending := tracker.Pending(); pending != 0 {
+		t.Errorf("expected the detached peripheral to be forgotten, got %d pending", pending)
+	}
+	if _, ok := tracker.Summary()[Acknowledged]; ok {
+		t.Error("expected no acknowledgment latency")
+	}
+}
+
+func TestTrackerKeepsLastSamples(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker := New(3, false)
+	tracker.Scan(start, nil)
+	for i := 1; i <= 5; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		tracker.Scan(at, map[string]time.Time{"046d:0825": at.Add(-time.Duration(i) * time.Second)})
+		tracker.Scan(at.Add(time.Second), nil)
+	}
+
+	detected := tracker.Summary()[Detected]
+	if detected.Count != 3 || detected.Max != 5 || detected.P50 != 4 {
+		t.Errorf("expected the last 3 latencies to be kept, got %+v", detected)
+	}
+	if percentile([]time.Duration{time.Second}, 99) != 1 {
+		t.Error("expected the percentile of a single sample to be the sample")
+	}
+}
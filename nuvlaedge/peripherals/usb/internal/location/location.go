@@ -0,0 +1,71 @@
+// Package location maps the physical USB ports to where they are on the site,
+// so the maintenance staff know where each peripheral is plugged.
+//
+// The mapping file holds one "port: location" pair per line, in the flat YAML
+// of the override files, the ports as reported in the records:
+//
+//	# Hub of cabinet A, and its port reserved for the payment terminal
+//	1-1: cabinet A
+//	1-1.2: cabinet A, left drawer
+//	2-3: front panel
+//
+// A port locates the devices plugged in it and behind the hubs plugged in it:
+// a device is at the location of the longest port of the mapping its own port
+// starts with, 1-1.4.1 in cabinet A above. The file is read on every scan, so
+// editing it takes effect without restarting the manager.
+package location
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/overrides"
+)
+
+// Map holds the locations by port
+type Map map[string]string
+
+// Load reads the mapping file. A missing file is not an error and yields an
+// empty mapping.
+func Load(path string) (Map, error) {
+	if len(path) == 0 {
+		return Map{}, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return Map{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	locations, err := overrides.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for port := range locations {
+		if !strings.Contains(port, "-") {
+			return nil, fmt.Errorf("%s: invalid port %q, expected bus-port[.port...] as 1-1.2", path, port)
+		}
+	}
+	return Map(locations), nil
+}
+
+// Locate returns the location of the device plugged in port, empty when the
+// mapping does not cover it
+func (m Map) Locate(port string) string {
+	for len(port) > 0 {
+		if location, ok := m[port]; ok {
+			return location
+		}
+		// Up to the port of the parent hub
+		parent := strings.LastIndexByte(port, '.')
+		if parent < 0 {
+			break
+		}
+		port = port[:parent]
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package location
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ports.yaml")
+	content := "# Cabinet A\n1-1: cabinet A\n1-1.2: \"cabinet A, left drawer\"\n2-3: front panel # below the screen\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	locations, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for port, expected := range map[string]string{
+		"1-1":     "cabinet A",
+		"1-1.4.1": "cabinet A",
+		"1-1.2":   "cabinet A, left drawer",
+		"1-1.2.3": "cabinet A, left drawer",
+		"1-12":    "",
+		"2-3":     "front panel",
+		"2-1":     "",
+		"":        "",
+	} {
+		if location := locations.Locate(port); location != expected {
+			t.Errorf("Location of port %q = %q, expected %q", port, location, expected)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if locations, err := Load(filepath.Join(dir, "missing.yaml")); err != nil || len(locations) != 0 {
+		t.Errorf("Missing mapping = %v, %v", locations, err)
+	}
+
+	for _, content := range []string{"usb1: rack\n", "1-1:\n  nested: value\n"} {
+		path := filepath.Join(dir, "ports.yaml")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("Mapping %q was loaded", content)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Package lock keeps the registry of the peripherals claimed by edge
+// applications, so two deployments do not fight over the same device.
+//
+// A claim is a JSON file named after the peripheral identifier in the registry
+// folder. Applications can create it themselves, for instance from a shared
+// volume, or through the claim and release actions:
+//
+//	{"owner": "vision-app", "expires": "2024-05-01T12:00:00Z"}
+//
+// Claims without an expiry date hold until released.
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// ErrClaimed is returned when claiming a peripheral held by another owner
+var ErrClaimed = errors.New("peripheral already claimed")
+
+// Claim tells who holds a peripheral
+type Claim struct {
+	Owner   string `json:"owner"`
+	Claimed string `json:"claimed,omitempty"`
+	Expires string `json:"expires,omitempty"`
+}
+
+// expired reports whether the claim lapsed at the given time
+func (c Claim) expired(at time.Time) bool {
+	if len(c.Expires) == 0 {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339, c.Expires)
+	return err == nil && !at.Before(expires)
+}
+
+// Registry stores the claims in a folder
+type Registry struct {
+	Dir string
+	now func() time.Time
+}
+
+// NewRegistry creates a registry stored in dir
+func NewRegistry(dir string) *Registry {
+	return &Registry{Dir: dir, now: time.Now}
+}
+
+func (r *Registry) path(identifier string) (string, error) {
+	if len(identifier) == 0 || strings.ContainsAny(identifier, `/\`) {
+		return "", fmt.Errorf("invalid peripheral identifier %q", identifier)
+	}
+	return filepath.Join(r.Dir, identifier+".json"), nil
+}
+
+// Status returns the active claim of a peripheral, if any. Expired and
+// unreadable claims are ignored.
+func (r *Registry) Status(identifier string) (Claim, bool) {
+	path, err := r.path(identifier)
+	if err != nil {
+		return Claim{}, false
+	}
+	claim, err := readClaim(path)
+	if err != nil || claim.expired(r.now()) {
+		return Claim{}, false
+	}
+	return claim, true
+}
+
+// Claim gives the peripheral to owner for the given duration, or until
+// released when ttl is zero. Claiming again as the same owner renews the
+// claim.
+func (r *Registry) Claim(identifier string, owner string, ttl time.Duration) (Claim, error) {
+	if len(owner) == 0 {
+		return Claim{}, errors.New("a claim requires an owner")
+	}
+	path, err := r.path(identifier)
+	if err != nil {
+		return Claim{}, err
+	}
+	if err := os.MkdirAll(r.Dir, os.ModePerm); err != nil {
+		return Claim{}, err
+	}
+
+	now := r.now()
+	if current, exists := r.Status(identifier); exists && current.Owner != owner {
+		return current, fmt.Errorf("%w by %s", ErrClaimed, current.Owner)
+	}
+
+	claim := Claim{Owner: owner, Claimed: now.UTC().Format(time.RFC3339)}
+	if ttl > 0 {
+		claim.Expires = now.Add(ttl).UTC().Format(time.RFC3339)
+	}
+
+	bData, _ := json.Marshal(claim)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bData, 0644); err != nil {
+		return Claim{}, err
+	}
+	return claim, os.Rename(tmp, path)
+}
+
+// Release drops the claim of owner on the peripheral. Releasing a peripheral
+// nobody claimed is not an error.
+func (r *Registry) Release(identifier string, owner string) error {
+	path, err := r.path(identifier)
+	if err != nil {
+		return err
+	}
+	if current, exists := r.Status(identifier); exists && current.Owner != owner {
+		return fmt.Errorf("%w by %s", ErrClaimed, current.Owner)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Annotate adds the claim state to a peripheral record
+func (r *Registry) Annotate(peripheral *peripherals.Peripheral) {
+	claim, claimed := r.Status(peripheral.Identifier)
+	peripheral.Claimed = &claimed
+	peripheral.ClaimedBy = ""
+	peripheral.ClaimExpires = ""
+	if claimed {
+		peripheral.ClaimedBy = claim.Owner
+		peripheral.ClaimExpires = claim.Expires
+	}
+}
+
+func readClaim(path string) (Claim, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Claim{}, err
+	}
+	var claim Claim
+	if err := json.Unmarshal(data, &claim); err != nil {
+		return Claim{}, err
+	}
+	if len(claim.Owner) == 0 {
+		return Claim{}, errors.New("claim without owner")
+	}
+	return claim, nil
+}
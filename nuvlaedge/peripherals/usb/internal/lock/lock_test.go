@@ -0,0 +1,83 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestClaimAndRelease(t *testing.T) {
+	r := NewRegistry(filepath.Join(t.TempDir(), "locks"))
+
+	if _, err := r.Claim("046d:0825", "vision-app", 0); err != nil {
+		t.Fatal(err)
+	}
+	if claim, claimed := r.Status("046d:0825"); !claimed || claim.Owner != "vision-app" {
+		t.Errorf("expected camera to be claimed by vision-app, got %+v", claim)
+	}
+
+	if _, err := r.Claim("046d:0825", "other-app", 0); !errors.Is(err, ErrClaimed) {
+		t.Errorf("expected second owner to be refused, got %v", err)
+	}
+	if err := r.Release("046d:0825", "other-app"); !errors.Is(err, ErrClaimed) {
+		t.Errorf("expected release by another owner to be refused, got %v", err)
+	}
+	if _, err := r.Claim("046d:0825", "vision-app", time.Hour); err != nil {
+		t.Errorf("expected owner to renew its claim, got %v", err)
+	}
+
+	if err := r.Release("046d:0825", "vision-app"); err != nil {
+		t.Fatal(err)
+	}
+	if _, claimed := r.Status("046d:0825"); claimed {
+		t.Error("expected camera to be free after release")
+	}
+	if err := r.Release("046d:0825", "vision-app"); err != nil {
+		t.Errorf("expected release of a free peripheral to succeed, got %v", err)
+	}
+}
+
+func TestExpiredClaim(t *testing.T) {
+	r := NewRegistry(t.TempDir())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	if _, err := r.Claim("046d:0825", "vision-app", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, claimed := r.Status("046d:0825"); claimed {
+		t.Error("expected claim to expire")
+	}
+	if _, err := r.Claim("046d:0825", "other-app", 0); err != nil {
+		t.Errorf("expected expired claim to be taken over, got %v", err)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	r := NewRegistry(t.TempDir())
+	if err := os.WriteFile(filepath.Join(r.Dir, "0bda:8153.json"), []byte(`{"owner": "gateway"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed := peripherals.Peripheral{Identifier: "0bda:8153"}
+	r.Annotate(&claimed)
+	if claimed.Claimed == nil || !*claimed.Claimed || claimed.ClaimedBy != "gateway" {
+		t.Errorf("expected lock file claim to be reported, got %v", claimed)
+	}
+
+	free := peripherals.Peripheral{Identifier: "046d:0825"}
+	r.Annotate(&free)
+	if free.Claimed == nil || *free.Claimed {
+		t.Errorf("expected free peripheral, got %v", free)
+	}
+
+	if _, err := r.Claim("../etc", "app", 0); err == nil {
+		t.Error("expected identifier with path separators to be refused")
+	}
+}
@@ -0,0 +1,202 @@
+// Package maintenance pauses the peripheral reports while technicians work on
+// the hardware of the device, so swapping a camera or a hub does not raise a
+// storm of alerts.
+//
+// Maintenance starts when the flag file is created, by hand, by the agent or
+// by any tool sharing the peripherals folder, and ends when it is removed. The
+// flag file may be empty, or tell why and until when the maintenance lasts:
+//
+//	{"reason": "replacing the entrance camera", "until": "2024-05-01T14:00:00Z"}
+//
+// A maintenance whose until time passed is over even if the file is still
+// there, so a forgotten flag does not silence the manager forever. The scans
+// go on during the maintenance, only the reports are held back. When it ends,
+// a single report of the peripherals is published, along with the summary of
+// what was added, updated and removed since the last report made before it.
+package maintenance
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/audit"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// Window is a maintenance in progress
+type Window struct {
+	Reason  string `json:"reason,omitempty"`
+	Until   string `json:"until,omitempty"`
+	Started string `json:"started"`
+	// Suppressed counts the reports held back so far
+	Suppressed int `json:"suppressed-reports"`
+}
+
+// Summary tells what changed over a maintenance, by peripheral identifier
+type Summary struct {
+	Reason     string   `json:"reason,omitempty"`
+	Started    string   `json:"started"`
+	Ended      string   `json:"ended"`
+	Suppressed int      `json:"suppressed-reports"`
+	Added      []string `json:"added"`
+	Updated    []string `json:"updated"`
+	Removed    []string `json:"removed"`
+}
+
+// Mode follows the flag file and decides which reports get published
+type Mode struct {
+	Path string
+
+	mu     sync.Mutex
+	window *Window
+	ending bool
+	last   *Summary
+	// baseline holds the hashes of the last published report, by identifier
+	baseline map[string]string
+}
+
+// New follows the flag file at path. An empty path disables the maintenance
+// mode.
+func New(path string) *Mode {
+	return &Mode{Path: path}
+}
+
+// flag is the content of the flag file
+type flag struct {
+	Reason string `json:"reason"`
+	Until  string `json:"until"`
+}
+
+// read tells whether the flag file declares a maintenance at now
+func (m *Mode) read(now time.Time) (flag, bool) {
+	var f flag
+	if len(m.Path) == 0 {
+		return f, false
+	}
+	data, err := ioutil.ReadFile(m.Path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Unable to read the maintenance flag %s. Reason: %s", m.Path, err)
+		}
+		return f, false
+	}
+	if len(strings.TrimSpace(string(data))) > 0 {
+		if err := json.Unmarshal(data, &f); err != nil {
+			log.Warnf("Ignoring the content of the maintenance flag %s. Reason: %s", m.Path, err)
+			return flag{}, true
+		}
+	}
+	if len(f.Until) > 0 {
+		until, err := time.Parse(time.RFC3339, f.Until)
+		if err != nil {
+			log.Warnf("Ignoring the invalid end %q of the maintenance flag %s", f.Until, m.Path)
+			f.Until = ""
+		} else if !now.Before(until) {
+			return f, false
+		}
+	}
+	return f, true
+}
+
+// Refresh reads the flag file and returns whether a maintenance is in
+// progress at now
+func (m *Mode) Refresh(now time.Time) bool {
+	f, active := m.read(now)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case active && m.window == nil:
+		log.Infof("Entering maintenance, holding back the peripheral reports. Reason: %q", f.Reason)
+		m.window = &Window{Reason: f.Reason, Until: f.Until, Started: now.UTC().Format(time.RFC3339)}
+		m.ending = false
+	case active:
+		// The flag may be put back before the report closing the maintenance
+		m.window.Reason, m.window.Until = f.Reason, f.Until
+		m.ending = false
+	case m.window != nil && !m.ending:
+		log.Infof("Maintenance over after %d reports held back", m.window.Suppressed)
+		m.ending = true
+	}
+	return active
+}
+
+// Report decides whether a report is published. Reports are held back during
+// a maintenance. The first report after it comes with the summary of the
+// changes over the maintenance.
+func (m *Mode) Report(at time.Time, report map[string]peripherals.Peripheral) (bool, *Summary) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.window != nil && !m.ending {
+		m.window.Suppressed++
+		return false, nil
+	}
+
+	hashes := make(map[string]string, len(report))
+	for identifier, peripheral := range report {
+		hashes[identifier], _ = audit.Hash(peripheral)
+	}
+
+	var summary *Summary
+	if m.window != nil {
+		summary = &Summary{
+			Reason:     m.window.Reason,
+			Started:    m.window.Started,
+			Ended:      at.UTC().Format(time.RFC3339),
+			Suppressed: m.window.Suppressed,
+		}
+		summary.Added, summary.Updated, summary.Removed = Diff(m.baseline, hashes)
+		log.Infof("Peripheral changes over the maintenance: %d added, %d updated, %d removed",
+			len(summary.Added), len(summary.Updated), len(summary.Removed))
+		m.last = summary
+		m.window = nil
+		m.ending = false
+	}
+	m.baseline = hashes
+	return true, summary
+}
+
+// Status returns the maintenance in progress, if any, and the summary of the
+// last one
+func (m *Mode) Status() (*Window, *Summary) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var window *Window
+	if m.window != nil && !m.ending {
+		copied := *m.window
+		window = &copied
+	}
+	return window, m.last
+}
+
+// Diff compares two reports, given as record hashes by identifier, and returns
+// the sorted identifiers of the peripherals added, updated and removed
+func Diff(before, after map[string]string) (added, updated, removed []string) {
+	added, updated, removed = []string{}, []string{}, []string{}
+	for identifier, hash := range after {
+		previous, known := before[identifier]
+		switch {
+		case !known:
+			added = append(added, identifier)
+		case previous != hash:
+			updated = append(updated, identifier)
+		}
+	}
+	for identifier := range before {
+		if _, exists := after[identifier]; !exists {
+			removed = append(removed, identifier)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(updated)
+	sort.Strings(removed)
+	return added, updated, removed
+}
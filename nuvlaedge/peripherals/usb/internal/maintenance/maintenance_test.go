@@ -0,0 +1,100 @@
+package maintenance
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestMaintenanceHoldsBackReports(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance")
+	m := New(path)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	camera := peripherals.Peripheral{Identifier: "046d:0825", Name: "Webcam C270", Available: true}
+	hub := peripherals.Peripheral{Identifier: "05e3:0610", Name: "USB hub", Available: true}
+	scanner := peripherals.Peripheral{Identifier: "0c2e:0b61", Name: "Barcode scanner", Available: true}
+
+	if m.Refresh(now) {
+		t.Fatal("expected no maintenance without the flag file")
+	}
+	if publish, summary := m.Report(now, map[string]peripherals.Peripheral{camera.Identifier: camera, hub.Identifier: hub}); !publish || summary != nil {
+		t.Fatal("expected reports to be published outside of maintenance")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"reason": "replacing the camera"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Refresh(now.Add(time.Minute)) {
+		t.Fatal("expected the flag file to start a maintenance")
+	}
+	// The camera is unplugged, then plugged back with another name, and the
+	// hub replaced by a scanner
+	if publish, _ := m.Report(now, map[string]peripherals.Peripheral{hub.Identifier: hub}); publish {
+		t.Error("expected the report to be held back")
+	}
+	renamed := camera
+	renamed.Name = "Entrance camera"
+	m.Refresh(now.Add(2 * time.Minute))
+	if publish, _ := m.Report(now, map[string]peripherals.Peripheral{renamed.Identifier: renamed, scanner.Identifier: scanner}); publish {
+		t.Error("expected the report to be held back")
+	}
+	if window, _ := m.Status(); window == nil || window.Reason != "replacing the camera" || window.Suppressed != 2 {
+		t.Errorf("unexpected maintenance window %+v", window)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if m.Refresh(now.Add(3 * time.Minute)) {
+		t.Fatal("expected removing the flag file to end the maintenance")
+	}
+	publish, summary := m.Report(now.Add(3*time.Minute), map[string]peripherals.Peripheral{renamed.Identifier: renamed, scanner.Identifier: scanner})
+	expected := &Summary{
+		Reason:     "replacing the camera",
+		Started:    "2024-05-01T12:01:00Z",
+		Ended:      "2024-05-01T12:03:00Z",
+		Suppressed: 2,
+		Added:      []string{scanner.Identifier},
+		Updated:    []string{camera.Identifier},
+		Removed:    []string{hub.Identifier},
+	}
+	if !publish || !reflect.DeepEqual(summary, expected) {
+		t.Errorf("expected a single report with the changes over the maintenance, got %+v", summary)
+	}
+	if window, last := m.Status(); window != nil || last != summary {
+		t.Errorf("expected the last maintenance in the status, got %+v and %+v", window, last)
+	}
+
+	if publish, summary := m.Report(now.Add(4*time.Minute), nil); !publish || summary != nil {
+		t.Error("expected the following reports to be published as usual")
+	}
+}
+
+func TestMaintenanceFlag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for content, active := range map[string]bool{
+		``:                                  true,
+		`not json`:                          true,
+		`{"until": "2024-05-01T13:00:00Z"}`: true,
+		`{"until": "2024-05-01T11:00:00Z"}`: false,
+		`{"until": "tomorrow"}`:             true,
+	} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, got := New(path).read(now); got != active {
+			t.Errorf("expected flag %q to be active %t", content, active)
+		}
+	}
+
+	if New("").Refresh(now) {
+		t.Error("expected an empty path to disable the maintenance mode")
+	}
+}
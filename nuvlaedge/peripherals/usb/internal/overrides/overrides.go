@@ -0,0 +1,142 @@
+// Package overrides loads the per-device attribute files that site technicians
+// drop next to the USB channel, one small YAML file per device serial number.
+//
+// A file named <serial>.yaml (or .yml) holds flat "key: value" pairs:
+//
+//	# Cabinet in the loading area
+//	location: loading-dock-2
+//	owner: QA
+//
+// The attributes are merged into the matching peripheral record on every scan,
+// so editing a file takes effect without restarting the manager.
+package overrides
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var extensions = []string{".yaml", ".yml"}
+
+// reserved holds the attributes that identify a peripheral or are owned by the
+// discovery itself, and therefore cannot be overridden from a local file. The
+// other typed fields, as the name or the vendor, may be.
+var reserved = map[string]bool{
+	"identifier":    true,
+	"interface":     true,
+	"classes":       true,
+	"available":     true,
+	"device-path":   true,
+	"port":          true,
+	"serial-number": true,
+	"quirks":        true,
+	// The protection of the devices the host relies on
+	"host-critical": true,
+	"storage":       true,
+}
+
+// Load reads the override file of the device with the given serial number from
+// dir. A missing file is not an error and yields an empty set of attributes.
+func Load(dir string, serial string) (map[string]string, error) {
+	if len(serial) == 0 || serial != filepath.Base(serial) {
+		return map[string]string{}, nil
+	}
+
+	for _, ext := range extensions {
+		file := filepath.Join(dir, serial+ext)
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		attributes, err := Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		return attributes, nil
+	}
+	return map[string]string{}, nil
+}
+
+// Parse decodes the flat YAML subset used by override files: one "key: value"
+// pair per line, optional single or double quotes around values, blank lines
+// and "#" comments. Nested structures are rejected.
+func Parse(r io.Reader) (map[string]string, error) {
+	attributes := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if trimmed != line || strings.HasPrefix(trimmed, "- ") {
+			return nil, fmt.Errorf("line %d: nested values are not supported", lineNumber)
+		}
+
+		sep := strings.Index(trimmed, ":")
+		if sep <= 0 {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNumber)
+		}
+		key := strings.TrimSpace(trimmed[:sep])
+		value, err := parseValue(strings.TrimSpace(trimmed[sep+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		attributes[key] = value
+	}
+
+	return attributes, scanner.Err()
+}
+
+func parseValue(raw string) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+	quote := raw[0]
+	if quote == '"' || quote == '\'' {
+		end := strings.LastIndexByte(raw, quote)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", raw)
+		}
+		rest := strings.TrimSpace(raw[end+1:])
+		if len(rest) > 0 && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected content after quoted value %s", raw)
+		}
+		return raw[1:end], nil
+	}
+	if comment := strings.Index(raw, " #"); comment >= 0 {
+		raw = strings.TrimSpace(raw[:comment])
+	}
+	return raw, nil
+}
+
+// Reserved tells whether an attribute identifies the peripheral or is owned by
+// the discovery, and must not be set from outside of it
+func Reserved(key string) bool {
+	return reserved[key]
+}
+
+// Merge copies the override attributes into the peripheral record, skipping
+// reserved keys, and returns the keys that were ignored.
+func Merge(peripheral map[string]interface{}, attributes map[string]string) []string {
+	var ignored []string
+	for key, value := range attributes {
+		if reserved[key] {
+			ignored = append(ignored, key)
+			continue
+		}
+		peripheral[key] = value
+	}
+	return ignored
+}
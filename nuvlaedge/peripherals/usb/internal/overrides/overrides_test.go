@@ -0,0 +1,106 @@
+package overrides
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := `# Site information
+location: loading-dock-2
+owner: "QA team"
+note: 'rack #3'
+shelf: top # inline comment
+
+empty:
+`
+	attributes, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]string{
+		"location": "loading-dock-2",
+		"owner":    "QA team",
+		"note":     "rack #3",
+		"shelf":    "top",
+		"empty":    "",
+	}
+	if len(attributes) != len(expected) {
+		t.Fatalf("expected %d attributes, got %v", len(expected), attributes)
+	}
+	for key, value := range expected {
+		if attributes[key] != value {
+			t.Errorf("attribute %s: expected %q, got %q", key, value, attributes[key])
+		}
+	}
+}
+
+func TestParseRejectsInvalidContent(t *testing.T) {
+	for _, input := range []string{
+		"location:\n  building: A\n",
+		"- item\n",
+		"no separator\n",
+		"owner: \"unterminated\n",
+	} {
+		if _, err := Parse(strings.NewReader(input)); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ABC123.yml"), []byte("owner: QA\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := Load(dir, "ABC123")
+	if err != nil || attributes["owner"] != "QA" {
+		t.Errorf("expected owner override, got %v (%v)", attributes, err)
+	}
+
+	attributes, err = Load(dir, "missing")
+	if err != nil || len(attributes) != 0 {
+		t.Errorf("expected no overrides for unknown serial, got %v (%v)", attributes, err)
+	}
+
+	attributes, err = Load(dir, "../ABC123")
+	if err != nil || len(attributes) != 0 {
+		t.Errorf("expected serial with path separators to be ignored, got %v (%v)", attributes, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	peripheral := map[string]interface{}{"identifier": "1d6b:0002", "name": "Hub"}
+	ignored := Merge(peripheral, map[string]string{"identifier": "other", "location": "dock"})
+
+	if peripheral["identifier"] != "1d6b:0002" {
+		t.Errorf("reserved attribute was overridden: %v", peripheral["identifier"])
+	}
+	if peripheral["location"] != "dock" {
+		t.Errorf("expected location to be merged, got %v", peripheral["location"])
+	}
+	if len(ignored) != 1 || ignored[0] != "identifier" {
+		t.Errorf("expected identifier to be reported as ignored, got %v", ignored)
+	}
+}
+
+func TestMergeCannotClearHostCritical(t *testing.T) {
+	peripheral := map[string]interface{}{"identifier": "0403:6001", "available": false, "host-critical": "console"}
+	ignored := Merge(peripheral, map[string]string{"host-critical": "", "available": "true", "storage": "[]",
+		"name": "Line scanner", "vendor": "ACME"})
+
+	if peripheral["host-critical"] != "console" || peripheral["available"] != false || peripheral["storage"] != nil {
+		t.Errorf("the protection of the device was overridden: %v", peripheral)
+	}
+	if len(ignored) != 3 {
+		t.Errorf("expected host-critical, available and storage to be ignored, got %v", ignored)
+	}
+	// The descriptive fields can still be overridden
+	if peripheral["name"] != "Line scanner" || peripheral["vendor"] != "ACME" {
+		t.Errorf("expected name and vendor to be merged, got %v", peripheral)
+	}
+}
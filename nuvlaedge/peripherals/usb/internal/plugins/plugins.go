@@ -0,0 +1,283 @@
+// Package plugins runs the executables integrators drop in the plugins folder
+// to add the attributes of their own probes to the peripheral records, without
+// changing the manager.
+//
+// A plugin gets the record of a peripheral, as reported, in JSON on its
+// standard input, and prints a JSON object of attributes to add to it on its
+// standard output, {} when it has nothing to say about the device:
+//
+//	#!/bin/sh
+//	# plugins.d/50-scale: reads the firmware of the scales of the packing line
+//	jq -c 'if .identifier == "0922:8003" then {"scale-firmware": "2.1"} else {} end'
+//
+// The plugins run in the order of their names, a later one replacing the
+// attributes of an earlier one, and none may set the attributes identifying
+// the peripheral. A plugin failing, timing out or printing something else is
+// logged and adds nothing. Every plugin runs once for each device attached,
+// again when it is replaced: the attributes are kept until the device is
+// detached.
+//
+// The plugins named *.wasm are WebAssembly classifiers, which run in a sandbox
+// instead of as processes: they have no filesystem, network, environment nor
+// real clock, at most 16 MiB of memory, and are stopped when they run out of
+// time. They may be built for WASI, their standard outputs being discarded.
+// A classifier exports its memory, and two functions:
+//
+//	alloc(size i32) i32
+//	classify(ptr i32, size i32) i64
+//
+// alloc returns where to write the JSON record of a peripheral, which classify
+// then reads. classify returns where its JSON object of attributes is in
+// memory, the offset in the high 32 bits and the size in the low ones, 0 when
+// it has nothing to say about the device. Every call runs in a new instance of
+// the module. With WASMOnly set, the executables are not run at all.
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/overrides"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+	"github.com/tetratelabs/wazero"
+)
+
+// maxOutput bounds what a plugin may print
+const maxOutput = 1 << 20
+
+// plugin is an executable of the folder, and when it was last changed
+type plugin struct {
+	path     string
+	modified time.Time
+}
+
+// result is what a plugin answered for a device
+type result struct {
+	modified   time.Time
+	attributes map[string]interface{}
+}
+
+// Runner runs the plugins of a folder
+type Runner struct {
+	Dir     string
+	Timeout time.Duration
+	// Run the WASM classifiers only, for the hosts where executables are too
+	// risky
+	WASMOnly bool
+
+	// results by device, then by plugin path
+	results map[string]map[string]result
+
+	// runtime of the classifiers, and their compiled modules by path
+	wasm        wazero.Runtime
+	classifiers map[string]classifier
+}
+
+// New runs the plugins of dir, each for at most timeout
+func New(dir string, timeout time.Duration) *Runner {
+	return &Runner{Dir: dir, Timeout: timeout, results: map[string]map[string]result{}}
+}
+
+// plugins lists the executables and classifiers of the folder, in the order of
+// their names. Hidden files and editor backups are skipped.
+func (r *Runner) plugins() []plugin {
+	entries, err := ioutil.ReadDir(r.Dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Unable to list the plugins of %s. Reason: %s", r.Dir, err)
+		}
+		return nil
+	}
+	var plugins []plugin
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		if !entry.Mode().IsRegular() {
+			// Symbolic links to the executables are followed
+			info, err := os.Stat(filepath.Join(r.Dir, name))
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			entry = info
+		}
+		if isClassifier(name) {
+			plugins = append(plugins, plugin{path: filepath.Join(r.Dir, name), modified: entry.ModTime()})
+			continue
+		}
+		if r.WASMOnly || entry.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		plugins = append(plugins, plugin{path: filepath.Join(r.Dir, name), modified: entry.ModTime()})
+	}
+	return plugins
+}
+
+// isClassifier tells the WASM classifiers from the executables
+func isClassifier(name string) bool {
+	return strings.HasSuffix(name, ".wasm")
+}
+
+// key identifies an attached device: it changes when the device is replaced
+func key(peripheral peripherals.Peripheral) string {
+	return peripheral.DevicePath + " " + peripheral.Identifier + " " + peripheral.SerialNumber
+}
+
+// Apply adds the attributes of the plugins to the records, running them for
+// the devices they did not answer yet. The answers for the devices no longer
+// discovered are forgotten.
+func (r *Runner) Apply(ctx context.Context, discovered []peripherals.Peripheral) {
+	plugins := r.plugins()
+	if r.classifiers != nil {
+		r.forget(ctx, plugins)
+	}
+	attached := map[string]bool{}
+	for i := range discovered {
+		peripheral := &discovered[i]
+		device := key(*peripheral)
+		attached[device] = true
+		if len(plugins) == 0 {
+			continue
+		}
+
+		results := r.results[device]
+		if results == nil {
+			results = map[string]result{}
+			r.results[device] = results
+		}
+		var input []byte
+		for _, p := range plugins {
+			answer, ok := results[p.path]
+			if !ok || !answer.modified.Equal(p.modified) {
+				if ctx.Err() != nil {
+					continue
+				}
+				if input == nil {
+					input, _ = json.Marshal(*peripheral)
+				}
+				answer = result{modified: p.modified, attributes: r.run(ctx, p, peripheral.Identifier, input)}
+				results[p.path] = answer
+			}
+			if len(answer.attributes) > 0 && peripheral.Attributes == nil {
+				peripheral.Attributes = map[string]interface{}{}
+			}
+			for name, value := range answer.attributes {
+				peripheral.Attributes[name] = value
+			}
+		}
+	}
+	for device := range r.results {
+		if !attached[device] {
+			delete(r.results, device)
+		}
+	}
+}
+
+// run runs a plugin for a device and returns the attributes it answered
+func (r *Runner) run(ctx context.Context, p plugin, identifier string, input []byte) map[string]interface{} {
+	path := p.path
+	var attributes map[string]interface{}
+	var err error
+	if isClassifier(path) {
+		attributes, err = r.classify(ctx, p, input)
+	} else {
+		attributes, err = r.exec(ctx, path, input)
+	}
+	if err != nil {
+		log.Warnf("Plugin %s failed for device %s. Reason: %s", filepath.Base(path), identifier, err)
+		return nil
+	}
+	for name := range attributes {
+		if overrides.Reserved(name) || len(name) == 0 {
+			log.Warnf("Ignoring the reserved attribute %q set by plugin %s", name, filepath.Base(path))
+			delete(attributes, name)
+		}
+	}
+	return attributes
+}
+
+// limitedBuffer keeps what a plugin prints up to a limit, and calls overflow
+// once when the plugin prints more. The buffer is not embedded, its ReadFrom
+// would bypass the limit.
+type limitedBuffer struct {
+	buffer   bytes.Buffer
+	limit    int
+	overflow func()
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.exceeded {
+		return len(p), nil
+	}
+	if b.buffer.Len()+len(p) > b.limit {
+		b.exceeded = true
+		if b.overflow != nil {
+			b.overflow()
+		}
+		return len(p), nil
+	}
+	return b.buffer.Write(p)
+}
+
+func (r *Runner) exec(ctx context.Context, path string, input []byte) (map[string]interface{}, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	// The plugin is stopped as soon as its output exceeds the limit
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	stdout := &limitedBuffer{limit: maxOutput, overflow: stop}
+	stderr := &limitedBuffer{limit: maxOutput}
+	cmd := exec.Command(path)
+	cmd.Dir = r.Dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	isolate(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	// The processes the plugin started are stopped with it, they would
+	// otherwise hold its output open
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			kill(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	if stdout.exceeded {
+		return nil, errors.New("the output exceeds 1 MiB")
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", r.Timeout)
+		}
+		if message := strings.TrimSpace(stderr.buffer.String()); len(message) > 0 {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(stdout.buffer.Bytes()), &attributes); err != nil {
+		return nil, fmt.Errorf("expected a JSON object of attributes: %w", err)
+	}
+	return attributes, nil
+}
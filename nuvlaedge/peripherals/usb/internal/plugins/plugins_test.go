@@ -0,0 +1,193 @@
+package plugins
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func writePlugin(t *testing.T, dir string, name string, script string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(t.TempDir(), "calls")
+	// Counts its runs, and answers from the record on its input
+	writePlugin(t, dir, "10-scale", `echo run >> `+calls+`
+if grep -q '"identifier":"0922:8003"' ; then echo '{"scale-firmware": "2.1", "location": "line 1"}'; else echo '{}'; fi
+`)
+	writePlugin(t, dir, "20-site", `cat > /dev/null; echo '{"location": "line 2", "identifier": "spoofed"}'`)
+	writePlugin(t, dir, "30-broken", `echo "no scale" >&2; exit 3`)
+	writePlugin(t, dir, "40-slow", `sleep 5; echo '{"slow": true}'`)
+	writePlugin(t, dir, ".hidden", `echo '{"hidden": true}'`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(dir, 500*time.Millisecond)
+	scale := peripherals.Peripheral{Identifier: "0922:8003", DevicePath: "/dev/bus/usb/001/004"}
+	hub := peripherals.Peripheral{Identifier: "1d6b:0002", DevicePath: "/dev/bus/usb/001/001"}
+	discovered := []peripherals.Peripheral{scale, hub}
+	r.Apply(context.Background(), discovered)
+
+	if discovered[0].Attributes["scale-firmware"] != "2.1" || discovered[0].Attributes["location"] != "line 2" ||
+		len(discovered[0].Attributes) != 2 {
+		t.Errorf("Scale attributes = %v", discovered[0].Attributes)
+	}
+	if discovered[1].Identifier != "1d6b:0002" || len(discovered[1].Attributes) != 1 {
+		t.Errorf("Hub = %+v", discovered[1])
+	}
+
+	// The answers are kept while the devices stay attached
+	discovered = []peripherals.Peripheral{scale}
+	start := time.Now()
+	r.Apply(context.Background(), discovered)
+	if time.Since(start) > 400*time.Millisecond || discovered[0].Attributes["scale-firmware"] != "2.1" {
+		t.Errorf("Plugins ran again for the attached scale: %v", discovered[0].Attributes)
+	}
+	if data, _ := ioutil.ReadFile(calls); string(data) != "run\nrun\n" {
+		t.Errorf("Runs of the scale plugin = %q", data)
+	}
+
+	// A changed plugin runs again
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "10-scale"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	r.Apply(context.Background(), []peripherals.Peripheral{scale})
+	if data, _ := ioutil.ReadFile(calls); string(data) != "run\nrun\nrun\n" {
+		t.Errorf("Runs of the scale plugin = %q", data)
+	}
+	if len(r.results) != 1 {
+		t.Errorf("Kept the answers of %d devices", len(r.results))
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	dir := t.TempDir()
+	// Prints forever, it would fill the memory of the manager
+	writePlugin(t, dir, "10-chatty", `cat > /dev/null; yes '{"chatty": true}'`)
+
+	r := New(dir, 10*time.Second)
+	discovered := []peripherals.Peripheral{{Identifier: "0922:8003"}}
+	start := time.Now()
+	r.Apply(context.Background(), discovered)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("The chatty plugin ran for %s", elapsed)
+	}
+	if discovered[0].Attributes != nil {
+		t.Errorf("Attributes = %v", discovered[0].Attributes)
+	}
+}
+
+func TestNoPlugins(t *testing.T) {
+	r := New(filepath.Join(t.TempDir(), "plugins.d"), time.Second)
+	discovered := []peripherals.Peripheral{{Identifier: "0922:8003"}}
+	r.Apply(context.Background(), discovered)
+	if discovered[0].Attributes != nil {
+		t.Errorf("Attributes = %v", discovered[0].Attributes)
+	}
+}
+
+// leb128 encodes a signed LEB128 integer, which also decodes as the unsigned
+// one for positive values
+func leb128(v int64) []byte {
+	var out []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+// vector encodes the length of data, then data
+func vector(data ...[]byte) []byte {
+	joined := bytes.Join(data, nil)
+	return append(leb128(int64(len(joined))), joined...)
+}
+
+// classifierModule assembles a WASM classifier with one page of memory, an
+// alloc returning offset 4096, the body of classify and the data at offset
+// 1024
+func classifierModule(classify []byte, data string) []byte {
+	count := func(n int) []byte { return leb128(int64(n)) }
+	name := func(s string) []byte { return vector([]byte(s)) }
+	section := func(id byte, content ...[]byte) []byte {
+		return append([]byte{id}, vector(content...)...)
+	}
+	// (i32) -> i32 and (i32, i32) -> i64
+	types := section(1, count(2), []byte{0x60, 1, 0x7f, 1, 0x7f}, []byte{0x60, 2, 0x7f, 0x7f, 1, 0x7e})
+	functions := section(3, count(2), []byte{0, 1})
+	memory := section(5, count(1), []byte{0, 1})
+	exports := section(7, count(3),
+		name("memory"), []byte{2, 0}, name("alloc"), []byte{0, 0}, name("classify"), []byte{0, 1})
+	alloc := vector([]byte{0}, []byte{0x41}, leb128(4096), []byte{0x0b})
+	code := section(10, count(2), alloc, vector([]byte{0}, classify, []byte{0x0b}))
+	segment := section(11, count(1), []byte{0, 0x41}, leb128(1024), []byte{0x0b}, name(data))
+	return bytes.Join([][]byte{{0, 'a', 's', 'm', 1, 0, 0, 0}, types, functions, memory, exports, code, segment}, nil)
+}
+
+// returns is a classify body returning the packed offset and size
+func returns(offset int64, size int64) []byte {
+	return append([]byte{0x42}, leb128(offset<<32|size)...)
+}
+
+func TestClassifiers(t *testing.T) {
+	dir := t.TempDir()
+	answer := `{"scale-model": "SW-10", "identifier": "spoofed"}`
+	modules := map[string][]byte{
+		"10-scale.wasm":  classifierModule(returns(1024, int64(len(answer))), answer),
+		"20-silent.wasm": classifierModule(returns(0, 0), "{}"),
+		// Loops forever
+		"30-loop.wasm":    classifierModule([]byte{0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00}, "{}"),
+		"40-huge.wasm":    classifierModule(returns(0, maxOutput+1), "{}"),
+		"50-outside.wasm": classifierModule(returns(65530, 100), "{}"),
+		"60-invalid.wasm": []byte("not a module"),
+	}
+	for name, module := range modules {
+		// Classifiers need not be executable
+		if err := ioutil.WriteFile(filepath.Join(dir, name), module, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePlugin(t, dir, "70-exec", `cat > /dev/null; echo '{"exec": true}'`)
+
+	r := New(dir, 200*time.Millisecond)
+	r.WASMOnly = true
+	defer r.Close(context.Background())
+	discovered := []peripherals.Peripheral{{Identifier: "0922:8003", DevicePath: "/dev/bus/usb/001/004"}}
+	start := time.Now()
+	r.Apply(context.Background(), discovered)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("The looping classifier ran for %s", elapsed)
+	}
+	if discovered[0].Attributes["scale-model"] != "SW-10" || len(discovered[0].Attributes) != 1 ||
+		discovered[0].Identifier != "0922:8003" {
+		t.Errorf("Scale = %+v", discovered[0])
+	}
+	if len(r.classifiers) != 5 {
+		t.Errorf("Compiled %d classifiers", len(r.classifiers))
+	}
+
+	// The compiled classifiers are dropped with their file
+	if err := os.Remove(filepath.Join(dir, "10-scale.wasm")); err != nil {
+		t.Fatal(err)
+	}
+	discovered = []peripherals.Peripheral{{Identifier: "0922:8003", DevicePath: "/dev/bus/usb/001/005"}}
+	r.Apply(context.Background(), discovered)
+	if len(discovered[0].Attributes) != 0 || len(r.classifiers) != 4 {
+		t.Errorf("Attributes = %v with %d classifiers", discovered[0].Attributes, len(r.classifiers))
+	}
+}
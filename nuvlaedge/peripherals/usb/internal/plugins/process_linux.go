@@ -0,0 +1,16 @@
+package plugins
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// isolate runs the plugin in its own process group
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// kill stops the plugin and the processes of its group
+func kill(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
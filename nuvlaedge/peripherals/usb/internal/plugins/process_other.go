@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package plugins
+
+import "os/exec"
+
+// isolate leaves the plugin in the process group of the manager
+func isolate(cmd *exec.Cmd) {}
+
+// kill stops the plugin only
+func kill(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
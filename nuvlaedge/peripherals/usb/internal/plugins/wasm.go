@@ -0,0 +1,150 @@
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// maxMemoryPages bounds the memory of a classifier, 16 MiB
+const maxMemoryPages = 256
+
+// classifier is a WASM plugin compiled for the runtime
+type classifier struct {
+	modified time.Time
+	module   wazero.CompiledModule
+}
+
+// runtime returns the runtime of the classifiers, created on first use
+func (r *Runner) runtime(ctx context.Context) wazero.Runtime {
+	if r.wasm == nil {
+		config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true).WithMemoryLimitPages(maxMemoryPages)
+		r.wasm = wazero.NewRuntimeWithConfig(ctx, config)
+		wasi_snapshot_preview1.MustInstantiate(ctx, r.wasm)
+		r.classifiers = map[string]classifier{}
+	}
+	return r.wasm
+}
+
+// compile returns the compiled module of a classifier, compiling it again
+// when it changed
+func (r *Runner) compile(ctx context.Context, path string, modified time.Time) (wazero.CompiledModule, error) {
+	runtime := r.runtime(ctx)
+	if compiled, ok := r.classifiers[path]; ok {
+		if compiled.modified.Equal(modified) {
+			return compiled.module, nil
+		}
+		compiled.module.Close(ctx)
+		delete(r.classifiers, path)
+	}
+	code, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	module, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"alloc", "classify"} {
+		if _, found := module.ExportedFunctions()[name]; !found {
+			module.Close(ctx)
+			return nil, fmt.Errorf("the module does not export %s", name)
+		}
+	}
+	r.classifiers[path] = classifier{modified: modified, module: module}
+	return module, nil
+}
+
+// forget closes the compiled classifiers no longer in the folder
+func (r *Runner) forget(ctx context.Context, plugins []plugin) {
+	found := map[string]bool{}
+	for _, p := range plugins {
+		found[p.path] = true
+	}
+	for path, compiled := range r.classifiers {
+		if !found[path] {
+			compiled.module.Close(ctx)
+			delete(r.classifiers, path)
+		}
+	}
+}
+
+// classify runs a classifier for the record of a device
+func (r *Runner) classify(ctx context.Context, p plugin, input []byte) (map[string]interface{}, error) {
+	compiled, err := r.compile(ctx, p.path, p.modified)
+	if err != nil {
+		return nil, err
+	}
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	// Anonymous instances, a module may run for several devices. Reactors
+	// built for WASI initialize in _initialize.
+	config := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	module, err := r.wasm.InstantiateModule(ctx, compiled, config)
+	if err != nil {
+		return nil, timedOut(ctx, err, r.Timeout)
+	}
+	defer module.Close(context.Background())
+
+	memory := module.Memory()
+	if memory == nil {
+		return nil, errors.New("the module does not export its memory")
+	}
+	results, err := module.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, timedOut(ctx, err, r.Timeout)
+	}
+	ptr := uint32(results[0])
+	if !memory.Write(ptr, input) {
+		return nil, errors.New("alloc returned memory out of range")
+	}
+	results, err = module.ExportedFunction("classify").Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, timedOut(ctx, err, r.Timeout)
+	}
+	if results[0] == 0 {
+		return nil, nil
+	}
+	offset, size := uint32(results[0]>>32), uint32(results[0])
+	if size > maxOutput {
+		return nil, errors.New("the output exceeds 1 MiB")
+	}
+	output, ok := memory.Read(offset, size)
+	if !ok {
+		return nil, errors.New("classify returned memory out of range")
+	}
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(output, &attributes); err != nil {
+		return nil, fmt.Errorf("expected a JSON object of attributes: %w", err)
+	}
+	return attributes, nil
+}
+
+// timedOut reports the calls stopped by the timeout as such
+func timedOut(ctx context.Context, err error, timeout time.Duration) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// Close releases the runtime of the classifiers
+func (r *Runner) Close(ctx context.Context) error {
+	if r.wasm == nil {
+		return nil
+	}
+	err := r.wasm.Close(ctx)
+	r.wasm = nil
+	r.classifiers = nil
+	return err
+}
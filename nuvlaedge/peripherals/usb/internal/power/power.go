@@ -0,0 +1,127 @@
+// Package power tells whether the host runs on battery, from the power
+// supplies sysfs lists, so the manager spaces out its scans and skips the
+// deep probing on the solar and battery powered edges.
+//
+// The host runs on battery while one of its batteries discharges. Below the
+// low capacity, the scans are spaced out further.
+package power
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Energy saving levels
+const (
+	SavingBattery    = "battery"
+	SavingLowBattery = "low-battery"
+)
+
+// State is the power source of the host, as reported in the manager status
+type State struct {
+	OnBattery bool `json:"on-battery"`
+	// Capacity is the lowest charge of the batteries, in percent
+	Capacity *int `json:"capacity,omitempty"`
+	// Saving is the energy saving level applied, empty when none is
+	Saving string `json:"saving,omitempty"`
+}
+
+func readString(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Read returns the state of the power supplies of class/power_supply under
+// sysfsDir, and whether the host has a battery at all
+func Read(sysfsDir string) (State, bool) {
+	var state State
+	supplies, _ := filepath.Glob(filepath.Join(sysfsDir, "class", "power_supply", "*"))
+	battery := false
+	for _, supply := range supplies {
+		// The batteries of the peripherals, as wireless mice, are "Device"
+		// scoped and do not power the host
+		if readString(filepath.Join(supply, "type")) != "Battery" || readString(filepath.Join(supply, "scope")) == "Device" {
+			continue
+		}
+		battery = true
+		if readString(filepath.Join(supply, "status")) == "Discharging" {
+			state.OnBattery = true
+		}
+		if capacity, err := strconv.Atoi(readString(filepath.Join(supply, "capacity"))); err == nil {
+			if state.Capacity == nil || capacity < *state.Capacity {
+				state.Capacity = &capacity
+			}
+		}
+	}
+	return state, battery
+}
+
+// Policy spaces out the scans while the host runs on battery
+type Policy struct {
+	SysfsDir string
+	// Interval is the shortest time between two scans on battery, and
+	// LowInterval the one below LowCapacity percent
+	Interval    time.Duration
+	LowCapacity int
+	LowInterval time.Duration
+
+	state State
+}
+
+// Refresh reads the power supplies and returns the energy saving to apply,
+// logging its changes
+func (p *Policy) Refresh() State {
+	state, _ := Read(p.SysfsDir)
+	if state.OnBattery {
+		state.Saving = SavingBattery
+		if state.Capacity != nil && *state.Capacity < p.LowCapacity {
+			state.Saving = SavingLowBattery
+		}
+	}
+	if state.Saving != p.state.Saving {
+		switch state.Saving {
+		case SavingBattery:
+			log.Infof("The host runs on battery, scanning the USB devices at most every %s without deep probing", p.Interval)
+		case SavingLowBattery:
+			log.Warnf("The host battery is below %d%%, scanning the USB devices at most every %s", p.LowCapacity, p.LowInterval)
+		default:
+			log.Infof("The host is powered again, resuming the regular USB scans")
+		}
+	}
+	p.state = state
+	return state
+}
+
+// Saving tells whether energy is saved, as of the last refresh
+func (p *Policy) Saving() bool {
+	return len(p.state.Saving) > 0
+}
+
+// State returns the state of the last refresh
+func (p *Policy) State() State {
+	return p.state
+}
+
+// Next lengthens the time until the next scan to the interval of the energy
+// saving applied
+func (p *Policy) Next(next time.Duration) time.Duration {
+	var least time.Duration
+	switch p.state.Saving {
+	case SavingBattery:
+		least = p.Interval
+	case SavingLowBattery:
+		least = p.LowInterval
+	}
+	if next < least {
+		return least
+	}
+	return next
+}
@@ -0,0 +1,59 @@
+package power
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func supply(t *testing.T, sysfs string, name string, attributes map[string]string) {
+	dir := filepath.Join(sysfs, "class", "power_supply", name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for attribute, value := range attributes {
+		if err := ioutil.WriteFile(filepath.Join(dir, attribute), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	sysfs := t.TempDir()
+	if _, battery := Read(sysfs); battery {
+		t.Error("Found a battery without power supply")
+	}
+
+	supply(t, sysfs, "AC", map[string]string{"type": "Mains", "online": "0"})
+	supply(t, sysfs, "BAT0", map[string]string{"type": "Battery", "status": "Discharging", "capacity": "64"})
+	supply(t, sysfs, "BAT1", map[string]string{"type": "Battery", "status": "Full", "capacity": "100"})
+	// The battery of a wireless mouse
+	supply(t, sysfs, "hidpp_battery_0", map[string]string{"type": "Battery", "scope": "Device", "status": "Discharging", "capacity": "5"})
+
+	state, battery := Read(sysfs)
+	if !battery || !state.OnBattery || state.Capacity == nil || *state.Capacity != 64 {
+		t.Errorf("State = %+v, battery %v", state, battery)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	sysfs := t.TempDir()
+	p := &Policy{SysfsDir: sysfs, Interval: 5 * time.Minute, LowCapacity: 20, LowInterval: 15 * time.Minute}
+
+	supply(t, sysfs, "BAT0", map[string]string{"type": "Battery", "status": "Charging", "capacity": "15"})
+	if state := p.Refresh(); state.Saving != "" || p.Saving() || p.Next(30*time.Second) != 30*time.Second {
+		t.Errorf("Charging state = %+v", state)
+	}
+
+	supply(t, sysfs, "BAT0", map[string]string{"status": "Discharging", "capacity": "50"})
+	if state := p.Refresh(); state.Saving != SavingBattery || p.Next(30*time.Second) != 5*time.Minute || p.Next(time.Hour) != time.Hour {
+		t.Errorf("Battery state = %+v", state)
+	}
+
+	supply(t, sysfs, "BAT0", map[string]string{"capacity": "19"})
+	if state := p.Refresh(); state.Saving != SavingLowBattery || !p.Saving() || p.Next(30*time.Second) != 15*time.Minute {
+		t.Errorf("Low battery state = %+v", state)
+	}
+}
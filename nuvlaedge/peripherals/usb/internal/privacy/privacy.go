@@ -0,0 +1,218 @@
+// Package privacy redacts the attributes of the peripheral records that must
+// not leave the device, such as serial numbers and device paths.
+//
+// Redacted values are derived from the original ones, so a device keeps the
+// same redacted serial number from one report to the next and can still be
+// deduplicated.
+package privacy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// Redaction modes
+const (
+	// ModeOff reports the records as they are
+	ModeOff = ""
+	// ModeHash replaces serial numbers and device paths with a salted hash
+	ModeHash = "hash"
+	// ModeTruncate only keeps the last characters of serial numbers, and
+	// hashes device paths
+	ModeTruncate = "truncate"
+)
+
+const (
+	hashLength = 16
+	keptLength = 4
+)
+
+// serialKeys hold values identifying a physical device or its owner, in the
+// additional attributes of a record
+var serialKeys = map[string]bool{
+	"serial-number": true,
+	"meter-id":      true,
+}
+
+// pathKeys hold device nodes, alone or in lists
+var pathKeys = map[string]bool{
+	"device-path":    true,
+	"video-device":   true,
+	"video-devices":  true,
+	"serial-device":  true,
+	"serial-devices": true,
+}
+
+// Redactor rewrites peripheral records according to a mode
+type Redactor struct {
+	mode string
+	salt string
+}
+
+// New creates a redactor. The salt is mixed into the hashes so they cannot be
+// matched against a list of known serial numbers.
+func New(mode string, salt string) (*Redactor, error) {
+	switch mode {
+	case ModeOff, ModeHash, ModeTruncate:
+		return &Redactor{mode: mode, salt: salt}, nil
+	default:
+		return nil, fmt.Errorf("unknown privacy mode %q", mode)
+	}
+}
+
+// Redact returns a redacted copy of a peripheral record. The record itself is
+// left untouched, so the manager keeps the real values for its own use.
+func (r *Redactor) Redact(peripheral peripherals.Peripheral) peripherals.Peripheral {
+	if r == nil || r.mode == ModeOff {
+		return peripheral
+	}
+
+	redacted := peripheral
+	redacted.SerialNumber = r.serial(peripheral.SerialNumber)
+	redacted.DevicePath = r.hash(peripheral.DevicePath)
+	redacted.VideoDevice = r.hash(peripheral.VideoDevice)
+	redacted.VideoDevices = r.hashList(peripheral.VideoDevices)
+	redacted.SerialDevices = r.hashList(peripheral.SerialDevices)
+
+	if peripheral.SmartMeter != nil {
+		meter := *peripheral.SmartMeter
+		meter.SerialDevice = r.hash(meter.SerialDevice)
+		meter.MeterID = r.serial(meter.MeterID)
+		redacted.SmartMeter = &meter
+	}
+	if peripheral.GNSS != nil {
+		gnss := *peripheral.GNSS
+		gnss.SerialDevice = r.hash(gnss.SerialDevice)
+		redacted.GNSS = &gnss
+	}
+	if peripheral.Storage != nil {
+		redacted.Storage = make([]peripherals.BlockDevice, len(peripheral.Storage))
+		for i, disk := range peripheral.Storage {
+			disk.DevicePath = r.hash(disk.DevicePath)
+			disk.Partitions = r.hashList(disk.Partitions)
+			redacted.Storage[i] = disk
+		}
+	}
+	if peripheral.Modem != nil {
+		modem := *peripheral.Modem
+		modem.ControlDevice = r.hash(modem.ControlDevice)
+		modem.IMEI = r.serial(modem.IMEI)
+		redacted.Modem = &modem
+	}
+	if peripheral.Holders != nil {
+		redacted.Holders = make([]peripherals.Holder, len(peripheral.Holders))
+		for i, holder := range peripheral.Holders {
+			holder.DevicePath = r.hash(holder.DevicePath)
+			redacted.Holders[i] = holder
+		}
+	}
+	if peripheral.HID != nil {
+		redacted.HID = make([]peripherals.HIDInterface, len(peripheral.HID))
+		for i, node := range peripheral.HID {
+			node.DevicePath = r.hash(node.DevicePath)
+			redacted.HID[i] = node
+		}
+	}
+	if peripheral.DepthCamera != nil {
+		camera := *peripheral.DepthCamera
+		camera.Nodes = make([]peripherals.DepthNode, len(peripheral.DepthCamera.Nodes))
+		for i, node := range peripheral.DepthCamera.Nodes {
+			node.DevicePath = r.hash(node.DevicePath)
+			camera.Nodes[i] = node
+		}
+		redacted.DepthCamera = &camera
+	}
+	if peripheral.Attributes != nil {
+		redacted.Attributes = r.redactMap(peripheral.Attributes)
+	}
+	return redacted
+}
+
+func (r *Redactor) hashList(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	list := make([]string, len(values))
+	for i, value := range values {
+		list[i] = r.hash(value)
+	}
+	return list
+}
+
+func (r *Redactor) redactMap(record map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(record))
+	for key, value := range record {
+		switch {
+		case serialKeys[key]:
+			redacted[key] = r.redactValue(value, r.serial)
+		case pathKeys[key]:
+			redacted[key] = r.redactValue(value, r.hash)
+		default:
+			redacted[key] = r.walk(value)
+		}
+	}
+	return redacted
+}
+
+// walk copies nested records, redacting their own sensitive attributes
+func (r *Redactor) walk(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return r.redactMap(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = r.walk(item)
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+// redactValue applies f to a string or to each string of a list
+func (r *Redactor) redactValue(value interface{}, f func(string) string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return f(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = r.redactValue(item, f)
+		}
+		return list
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = f(item)
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+func (r *Redactor) serial(value string) string {
+	if len(value) == 0 {
+		return value
+	}
+	if r.mode == ModeTruncate {
+		if len(value) <= keptLength {
+			return strings.Repeat("*", len(value))
+		}
+		return strings.Repeat("*", len(value)-keptLength) + value[len(value)-keptLength:]
+	}
+	return r.hash(value)
+}
+
+func (r *Redactor) hash(value string) string {
+	if len(value) == 0 {
+		return value
+	}
+	digest := sha256.Sum256([]byte(r.salt + value))
+	return "sha256:" + hex.EncodeToString(digest[:])[:hashLength]
+}
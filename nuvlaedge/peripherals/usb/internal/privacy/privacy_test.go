@@ -0,0 +1,86 @@
+package privacy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func record() peripherals.Peripheral {
+	return peripherals.Peripheral{
+		Identifier:    "0403:6001",
+		SerialNumber:  "A10KXYZ9",
+		DevicePath:    "/dev/bus/usb/001/004",
+		SerialDevices: []string{"/dev/ttyUSB0"},
+		SmartMeter: &peripherals.SmartMeter{
+			SerialDevice: "/dev/ttyUSB0",
+			MeterID:      "E0026000123456789",
+			DSMRVersion:  "5.0",
+		},
+		Attributes: map[string]interface{}{"meter-id": "E0026000123456789", "location": "basement"},
+	}
+}
+
+func TestHash(t *testing.T) {
+	r, err := New(ModeHash, "site-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := record()
+	redacted := r.Redact(original)
+
+	if original.SerialNumber != "A10KXYZ9" || original.SmartMeter.MeterID != "E0026000123456789" {
+		t.Error("expected the original record to be left untouched")
+	}
+	if redacted.Identifier != "0403:6001" {
+		t.Errorf("expected identifier to be kept, got %v", redacted.Identifier)
+	}
+
+	serial := redacted.SerialNumber
+	if !strings.HasPrefix(serial, "sha256:") || strings.Contains(serial, "A10KXYZ9") {
+		t.Errorf("expected hashed serial number, got %q", serial)
+	}
+	if again := r.Redact(record()); again.SerialNumber != serial {
+		t.Error("expected hashes to be stable")
+	}
+	if other, _ := New(ModeHash, "site-2"); other.Redact(record()).SerialNumber == serial {
+		t.Error("expected the salt to change the hashes")
+	}
+
+	meter := redacted.SmartMeter
+	if meter.MeterID == "E0026000123456789" || meter.SerialDevice == "/dev/ttyUSB0" {
+		t.Errorf("expected nested attributes to be redacted, got %v", meter)
+	}
+	if meter.DSMRVersion != "5.0" {
+		t.Errorf("expected other nested attributes to be kept, got %v", meter)
+	}
+	if redacted.SerialDevices[0] != meter.SerialDevice {
+		t.Errorf("expected the same node to be hashed the same way, got %v and %v", redacted.SerialDevices[0], meter.SerialDevice)
+	}
+	if redacted.Attributes["meter-id"] != meter.MeterID || redacted.Attributes["location"] != "basement" {
+		t.Errorf("expected additional attributes to be redacted, got %v", redacted.Attributes)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	r, _ := New(ModeTruncate, "")
+	redacted := r.Redact(record())
+
+	if redacted.SerialNumber != "****XYZ9" {
+		t.Errorf("expected truncated serial number, got %v", redacted.SerialNumber)
+	}
+	if !strings.HasPrefix(redacted.DevicePath, "sha256:") {
+		t.Errorf("expected hashed device path, got %v", redacted.DevicePath)
+	}
+}
+
+func TestOff(t *testing.T) {
+	r, _ := New(ModeOff, "")
+	if redacted := r.Redact(record()); redacted.SerialNumber != "A10KXYZ9" {
+		t.Errorf("expected record to be reported as is, got %v", redacted)
+	}
+	if _, err := New("rot13", ""); err == nil {
+		t.Error("expected unknown mode to be refused")
+	}
+}
@@ -0,0 +1,25 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// MetricsPath is where the handler answers
+const MetricsPath = "/api/peripherals/metrics"
+
+// Handler serves GET /api/peripherals/metrics with a snapshot of the registry
+func Handler(r *Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(MetricsPath, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.Snapshot(time.Now()))
+	})
+	return mux
+}
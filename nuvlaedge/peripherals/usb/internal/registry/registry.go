@@ -0,0 +1,225 @@
+// Package registry holds the device state and the metrics shared by the
+// peripheral managers running in one process.
+//
+// Every manager registers its own section and is the only one writing to it:
+// it publishes the peripherals of its scans as a whole, and updates its
+// counters and gauges. The action handlers, sinks and other managers only
+// read, through lookups and snapshots, so no data is shared mutable between
+// the goroutines. The published records are handed over to the registry and
+// must not be modified afterwards.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// ErrOwned is returned when registering a section another manager owns
+var ErrOwned = errors.New("section already registered")
+
+// Registry holds the sections of the managers, by owner
+type Registry struct {
+	mu       sync.RWMutex
+	sections map[string]*Section
+}
+
+// New creates an empty registry
+func New() *Registry {
+	return &Registry{sections: map[string]*Section{}}
+}
+
+// Register creates the section of owner, which must not have one yet
+func (r *Registry) Register(owner string) (*Section, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, owned := r.sections[owner]; owned {
+		return nil, fmt.Errorf("%w: %s", ErrOwned, owner)
+	}
+	section := &Section{owner: owner, registry: r, devices: map[string]peripherals.Peripheral{},
+		counters: map[string]*Counter{}, gauges: map[string]*Gauge{}}
+	r.sections[owner] = section
+	return section, nil
+}
+
+// Lookup finds a peripheral in the sections, tried in owner order, and tells
+// which manager reported it
+func (r *Registry) Lookup(identifier string) (string, peripherals.Peripheral, bool) {
+	for _, section := range r.ordered() {
+		if peripheral, found := section.Lookup(identifier); found {
+			return section.owner, peripheral, true
+		}
+	}
+	return "", peripherals.Peripheral{}, false
+}
+
+// Snapshot copies the state of every section
+func (r *Registry) Snapshot(now time.Time) Snapshot {
+	snapshot := Snapshot{Time: now.UTC(), Sections: map[string]SectionSnapshot{}}
+	for _, section := range r.ordered() {
+		snapshot.Sections[section.owner] = section.Snapshot()
+	}
+	return snapshot
+}
+
+func (r *Registry) ordered() []*Section {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sections := make([]*Section, 0, len(r.sections))
+	for _, section := range r.sections {
+		sections = append(sections, section)
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].owner < sections[j].owner })
+	return sections
+}
+
+// Section is the part of the registry a manager owns
+type Section struct {
+	owner    string
+	registry *Registry
+
+	mu      sync.RWMutex
+	devices map[string]peripherals.Peripheral
+	updated time.Time
+
+	metricsMu sync.Mutex
+	counters  map[string]*Counter
+	gauges    map[string]*Gauge
+}
+
+// Owner is the manager owning the section
+func (s *Section) Owner() string {
+	return s.owner
+}
+
+// Publish replaces the peripherals of the section with the ones of a scan
+func (s *Section) Publish(at time.Time, discovered []peripherals.Peripheral) {
+	indexed := make(map[string]peripherals.Peripheral, len(discovered))
+	for _, p := range discovered {
+		indexed[p.Identifier] = p
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices, s.updated = indexed, at
+}
+
+// Lookup returns the last published record of a peripheral
+func (s *Section) Lookup(identifier string) (peripherals.Peripheral, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, exists := s.devices[identifier]
+	return p, exists
+}
+
+// Counter returns the counter of the section with the given name, created on
+// first use
+func (s *Section) Counter(name string) *Counter {
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	counter, exists := s.counters[name]
+	if !exists {
+		counter = &Counter{}
+		s.counters[name] = counter
+	}
+	return counter
+}
+
+// Gauge returns the gauge of the section with the given name, created on
+// first use
+func (s *Section) Gauge(name string) *Gauge {
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	gauge, exists := s.gauges[name]
+	if !exists {
+		gauge = &Gauge{}
+		s.gauges[name] = gauge
+	}
+	return gauge
+}
+
+// Snapshot copies the state of the section. The records are shared, they are
+// never modified once published.
+func (s *Section) Snapshot() SectionSnapshot {
+	s.mu.RLock()
+	snapshot := SectionSnapshot{Updated: s.updated, Count: len(s.devices),
+		Devices: make(map[string]peripherals.Peripheral, len(s.devices))}
+	for identifier, p := range s.devices {
+		snapshot.Devices[identifier] = p
+	}
+	s.mu.RUnlock()
+
+	s.metricsMu.Lock()
+	defer s.metricsMu.Unlock()
+	snapshot.Counters = make(map[string]uint64, len(s.counters))
+	for name, counter := range s.counters {
+		snapshot.Counters[name] = counter.Value()
+	}
+	snapshot.Gauges = make(map[string]float64, len(s.gauges))
+	for name, gauge := range s.gauges {
+		snapshot.Gauges[name] = gauge.Value()
+	}
+	return snapshot
+}
+
+// Close removes the section from the registry, so the owner can register it
+// again
+func (s *Section) Close() {
+	s.registry.mu.Lock()
+	defer s.registry.mu.Unlock()
+	if s.registry.sections[s.owner] == s {
+		delete(s.registry.sections, s.owner)
+	}
+}
+
+// Counter is a monotonic count, safe for concurrent use
+type Counter struct {
+	value uint64
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value returns the current count
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge is a value that goes up and down, safe for concurrent use
+type Gauge struct {
+	bits uint64
+}
+
+// Set replaces the value of the gauge
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Snapshot is a copy of the registry at a point in time
+type Snapshot struct {
+	Time     time.Time                  `json:"time"`
+	Sections map[string]SectionSnapshot `json:"sections"`
+}
+
+// SectionSnapshot is a copy of the section of a manager. The records are left
+// out of its JSON document, which only counts them.
+type SectionSnapshot struct {
+	Updated  time.Time                         `json:"updated"`
+	Count    int                               `json:"devices"`
+	Devices  map[string]peripherals.Peripheral `json:"-"`
+	Counters map[string]uint64                 `json:"counters"`
+	Gauges   map[string]float64                `json:"gauges"`
+}
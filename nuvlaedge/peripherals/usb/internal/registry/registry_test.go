@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestSections(t *testing.T) {
+	r := New()
+	usb, err := r.Register("usb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Register("usb"); !errors.Is(err, ErrOwned) {
+		t.Errorf("expected the usb section to be owned, got %v", err)
+	}
+	network, _ := r.Register("network")
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	usb.Publish(at, []peripherals.Peripheral{{Identifier: "046d:0825", Name: "Webcam C270"}})
+	network.Publish(at, []peripherals.Peripheral{{Identifier: "192.168.1.20", Name: "IP camera"}})
+
+	if owner, p, found := r.Lookup("192.168.1.20"); !found || owner != "network" || p.Name != "IP camera" {
+		t.Errorf("expected the IP camera of the network manager, got %s %+v", owner, p)
+	}
+	if _, found := usb.Lookup("192.168.1.20"); found {
+		t.Error("expected the sections to be apart")
+	}
+
+	// Every scan replaces the devices of the section
+	usb.Publish(at.Add(time.Minute), nil)
+	if _, _, found := r.Lookup("046d:0825"); found {
+		t.Error("expected the detached webcam to be gone")
+	}
+
+	usb.Counter("scans").Add(2)
+	usb.Gauge("scan-duration-seconds").Set(0.25)
+	snapshot := r.Snapshot(at)
+	section := snapshot.Sections["usb"]
+	if section.Count != 0 || !section.Updated.Equal(at.Add(time.Minute)) || section.Counters["scans"] != 2 ||
+		section.Gauges["scan-duration-seconds"] != 0.25 || snapshot.Sections["network"].Count != 1 {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+
+	usb.Close()
+	if _, err := r.Register("usb"); err != nil {
+		t.Errorf("expected the closed section to be registered again, got %v", err)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	r := New()
+	usb, _ := r.Register("usb")
+	scans := usb.Counter("scans")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				usb.Publish(time.Now(), []peripherals.Peripheral{{Identifier: fmt.Sprintf("%d:%d", i, j)}})
+				scans.Add(1)
+				usb.Gauge("devices").Set(1)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Lookup("0:0")
+				r.Snapshot(time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if scans.Value() != 400 || usb.Snapshot().Count != 1 {
+		t.Errorf("unexpected state after the concurrent updates %+v", usb.Snapshot())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := New()
+	usb, _ := r.Register("usb")
+	usb.Publish(time.Now(), []peripherals.Peripheral{{Identifier: "046d:0825"}})
+	usb.Counter("reports").Add(1)
+	server := httptest.NewServer(Handler(r))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + MetricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var snapshot struct {
+		Sections map[string]map[string]interface{} `json:"sections"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if usb := snapshot.Sections["usb"]; usb == nil || usb["devices"] != 1.0 || usb["counters"].(map[string]interface{})["reports"] != 1.0 {
+		t.Errorf("unexpected metrics %+v", snapshot)
+	}
+
+	resp, err = http.Post(server.URL+MetricsPath, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be refused, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,141 @@
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ParseJSON parses a JSON document with the sections of the configuration
+// file, as the configuration pushed from Nuvla:
+//
+//	{"usb": {"scan-interval": "10s", "sinks": ["file", "mqtt"]}}
+//
+// Numbers and booleans are taken as the scalars they are written as.
+func ParseJSON(path string, data []byte) (*File, error) {
+	f := &File{Path: path, Sections: map[string]map[string]Value{}, sectionLines: map[string]int{}}
+
+	var sections map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, fmt.Errorf("%s: expected an object of sections of settings: %s", path, err)
+	}
+	for section, values := range sections {
+		f.Sections[section] = map[string]Value{}
+		for key, raw := range values {
+			value, err := parseJSONValue(raw)
+			if err != nil {
+				return nil, f.errorf(0, "%s.%s %s", section, key, err)
+			}
+			f.Sections[section][key] = value
+		}
+	}
+	return f, nil
+}
+
+func parseJSONValue(raw json.RawMessage) (Value, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err == nil && items != nil {
+		value := Value{IsList: true, List: []string{}}
+		for _, item := range items {
+			scalar, err := parseJSONScalar(item)
+			if err != nil {
+				return value, err
+			}
+			value.List = append(value.List, scalar)
+		}
+		return value, nil
+	}
+	scalar, err := parseJSONScalar(raw)
+	return Value{Scalar: scalar}, err
+}
+
+func parseJSONScalar(raw json.RawMessage) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("only strings, numbers, booleans and lists are supported, got %s", raw)
+}
+
+// Remote follows the configuration of a manager pushed from Nuvla, which the
+// agent writes as a JSON document with the sections of the configuration
+// file. Its settings are the environment variables they stand for, as the
+// ones of Environment.
+type Remote struct {
+	Path    string
+	Section string
+	Prefix  string
+	Schema  Schema
+
+	applied map[string]string
+}
+
+// Load reads the settings of the section and records them as the applied
+// ones. A missing document has no setting.
+func (r *Remote) Load() (map[string]string, error) {
+	env, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	r.applied = env
+	return env, nil
+}
+
+// Changed tells whether the settings of the section differ from the applied
+// ones. The changes of the other sections are ignored, and so are invalid
+// documents, which are reported in the error.
+func (r *Remote) Changed() (bool, error) {
+	env, err := r.read()
+	if err != nil {
+		return false, err
+	}
+	if len(env) == 0 && len(r.applied) == 0 {
+		return false, nil
+	}
+	return !reflect.DeepEqual(env, r.applied), nil
+}
+
+// Keys lists the applied settings, sorted
+func (r *Remote) Keys() []string {
+	keys := make([]string, 0, len(r.applied))
+	for key := range r.applied {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *Remote) read() (map[string]string, error) {
+	data, err := os.ReadFile(r.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := ParseJSON(r.Path, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Validate(r.Section, r.Schema); err != nil {
+		return nil, err
+	}
+	return f.Environment(r.Section, r.Prefix), nil
+}
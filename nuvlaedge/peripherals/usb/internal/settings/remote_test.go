@@ -0,0 +1,91 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	data := `{
+  "usb": {"scan-interval": "10s", "deep-scan-every": 3, "report-holders": true, "privacy": null, "sinks": ["file", "mqtt"]},
+  "bluetooth": {"gatt-enumeration": true}
+}`
+	f, err := ParseJSON("remote_config.json", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate("usb", testSchema); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"USB_SCAN_INTERVAL":   "10s",
+		"USB_DEEP_SCAN_EVERY": "3",
+		"USB_REPORT_HOLDERS":  "true",
+		"USB_PRIVACY":         "",
+		"USB_SINKS":           "file,mqtt",
+	}
+	if env := f.Environment("usb", "USB_"); !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %v, got %v", expected, env)
+	}
+
+	for data, expected := range map[string]string{
+		`["usb"]`:                             "remote_config.json: expected an object of sections",
+		`{"usb": {"uvc": {"enabled": true}}}`: "remote_config.json: usb.uvc only strings, numbers, booleans and lists",
+		`{"usb": {"sinks": [["file"]]}}`:      "remote_config.json: usb.sinks only strings, numbers, booleans and lists",
+	} {
+		if _, err := ParseJSON("remote_config.json", []byte(data)); err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected %q for %s, got %v", expected, data, err)
+		}
+	}
+
+	f, _ = ParseJSON("remote_config.json", []byte(`{"usb": {"scan-intervals": "10s", "sinks": "file"}}`))
+	err = f.Validate("usb", testSchema)
+	if err == nil || err.Error() != "remote_config.json: unknown setting usb.scan-intervals, did you mean scan-interval?\n"+
+		"remote_config.json: usb.sinks must be a list, such as [file], got \"file\"" {
+		t.Errorf("expected the problems without line, got %v", err)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remote_config.json")
+	remote := &Remote{Path: path, Section: "usb", Prefix: "USB_", Schema: testSchema}
+
+	// Nothing is pushed until Nuvla configures the manager
+	if env, err := remote.Load(); err != nil || len(env) != 0 {
+		t.Errorf("expected a missing document to configure nothing, got %v (%v)", env, err)
+	}
+	if changed, err := remote.Changed(); changed || err != nil {
+		t.Errorf("expected no change without document, got %v (%v)", changed, err)
+	}
+
+	os.WriteFile(path, []byte(`{"usb": {"scan-interval": "10s"}}`), 0644)
+	if changed, err := remote.Changed(); !changed || err != nil {
+		t.Errorf("expected the pushed settings to be a change, got %v (%v)", changed, err)
+	}
+	if env, err := remote.Load(); err != nil || env["USB_SCAN_INTERVAL"] != "10s" {
+		t.Errorf("expected the pushed settings, got %v (%v)", env, err)
+	}
+	if keys := remote.Keys(); !reflect.DeepEqual(keys, []string{"USB_SCAN_INTERVAL"}) {
+		t.Errorf("expected the applied settings to be listed, got %v", keys)
+	}
+
+	// The sections of the other managers do not concern this one
+	os.WriteFile(path, []byte(`{"usb": {"scan-interval": "10s"}, "bluetooth": {"gatt-enumeration": true}}`), 0644)
+	if changed, err := remote.Changed(); changed || err != nil {
+		t.Errorf("expected the other sections to be ignored, got %v (%v)", changed, err)
+	}
+
+	// Invalid documents are reported, and not a change
+	os.WriteFile(path, []byte(`{"usb": {"scan-interval": "often"}}`), 0644)
+	if changed, err := remote.Changed(); changed || err == nil {
+		t.Errorf("expected an invalid document to be reported, got %v (%v)", changed, err)
+	}
+
+	os.Remove(path)
+	if changed, err := remote.Changed(); !changed || err != nil {
+		t.Errorf("expected the removal of the settings to be a change, got %v (%v)", changed, err)
+	}
+}
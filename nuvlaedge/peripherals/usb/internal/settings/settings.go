@@ -0,0 +1,420 @@
+// Package settings reads the configuration file shared by the peripheral
+// managers, /etc/nuvlaedge/peripherals.yaml, with one section per manager:
+//
+//	usb:
+//	  scan-interval: 10s
+//	  sinks: [file, mqtt]
+//	bluetooth:
+//	  gatt-enumeration: true
+//	network:
+//	  subnet-sweep: true
+//
+// Each manager validates its own section against its schema, and the names of
+// the sections against the known managers. The file is a subset of YAML: maps
+// of scalars and lists, without anchors, multi-line strings nor documents, so
+// it needs no YAML library.
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPath is where the managers look for the file
+const DefaultPath = "/etc/nuvlaedge/peripherals.yaml"
+
+// Sections are the managers the file may configure
+var Sections = []string{"usb", "bluetooth", "network", "csi", "legacy-io"}
+
+// Kind is the type of value a setting takes
+type Kind int
+
+const (
+	String Kind = iota
+	Int
+	Bool
+	Duration
+	List
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Int:
+		return "an integer"
+	case Bool:
+		return "true or false"
+	case Duration:
+		return "a duration such as 30s or a number of seconds"
+	case List:
+		return "a list"
+	}
+	return "a string"
+}
+
+// Schema lists the settings of a section, by key
+type Schema map[string]Kind
+
+// Value is a setting of the file: a string, or a list of strings, with the
+// line it was read from
+type Value struct {
+	Line   int
+	Scalar string
+	List   []string
+	IsList bool
+}
+
+// File is a parsed configuration file
+type File struct {
+	Path     string
+	Sections map[string]map[string]Value
+
+	sectionLines map[string]int
+}
+
+// Load reads and parses the file at path. A missing file is not an error,
+// the managers are then configured from the environment only.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, data)
+}
+
+// Parse parses the content of a configuration file
+func Parse(path string, data []byte) (*File, error) {
+	f := &File{Path: path, Sections: map[string]map[string]Value{}, sectionLines: map[string]int{}}
+
+	var section string
+	var listKey string
+	var keyIndent int
+	for i, raw := range strings.Split(string(data), "\n") {
+		number := i + 1
+		line := strings.TrimRight(stripComment(raw), " \r")
+		if len(strings.TrimSpace(line)) == 0 || line == "---" {
+			continue
+		}
+		if strings.ContainsRune(line[:len(line)-len(strings.TrimLeft(line, " \t"))], '\t') {
+			return nil, f.errorf(number, "indentation must use spaces, not tabs")
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		content := strings.TrimSpace(line)
+
+		if indent == 0 {
+			key, value := splitKey(content)
+			if len(key) == 0 || len(value) > 0 {
+				return nil, f.errorf(number, "expected a section name followed by a colon, such as \"usb:\", got %q", content)
+			}
+			if _, exists := f.Sections[key]; exists {
+				return nil, f.errorf(number, "section %s is defined twice", key)
+			}
+			section, listKey, keyIndent = key, "", 0
+			f.Sections[section] = map[string]Value{}
+			f.sectionLines[section] = number
+			continue
+		}
+		if len(section) == 0 {
+			return nil, f.errorf(number, "settings must be in a section")
+		}
+
+		if strings.HasPrefix(content, "- ") || content == "-" {
+			if len(listKey) == 0 {
+				return nil, f.errorf(number, "list item outside of a list")
+			}
+			item, err := parseScalar(strings.TrimSpace(strings.TrimPrefix(content, "-")))
+			if err != nil {
+				return nil, f.errorf(number, "%s", err)
+			}
+			value := f.Sections[section][listKey]
+			value.List = append(value.List, item)
+			f.Sections[section][listKey] = value
+			continue
+		}
+
+		key, rawValue := splitKey(content)
+		if len(key) == 0 {
+			return nil, f.errorf(number, "expected a setting such as \"key: value\", got %q", content)
+		}
+		if keyIndent == 0 {
+			keyIndent = indent
+		} else if indent != keyIndent {
+			return nil, f.errorf(number, "settings of a section must all have the same indentation, nested maps are not supported")
+		}
+		if _, exists := f.Sections[section][key]; exists {
+			return nil, f.errorf(number, "%s.%s is defined twice", section, key)
+		}
+		value := Value{Line: number}
+		listKey = ""
+		switch {
+		case len(rawValue) == 0:
+			// Either a block list follows, or the setting is left empty
+			value.IsList = true
+			listKey = key
+		case strings.HasPrefix(rawValue, "["):
+			if !strings.HasSuffix(rawValue, "]") {
+				return nil, f.errorf(number, "unterminated list %s", rawValue)
+			}
+			items, err := parseFlowList(rawValue[1 : len(rawValue)-1])
+			if err != nil {
+				return nil, f.errorf(number, "%s", err)
+			}
+			value.IsList, value.List = true, items
+		case strings.HasPrefix(rawValue, "{") || strings.HasPrefix(rawValue, "&") ||
+			strings.HasPrefix(rawValue, "*") || strings.HasPrefix(rawValue, "|") || strings.HasPrefix(rawValue, ">"):
+			return nil, f.errorf(number, "only strings, numbers, booleans and lists are supported, got %s", rawValue)
+		default:
+			scalar, err := parseScalar(rawValue)
+			if err != nil {
+				return nil, f.errorf(number, "%s", err)
+			}
+			value.Scalar = scalar
+		}
+		f.Sections[section][key] = value
+	}
+	return f, nil
+}
+
+// errorf locates a problem in the file, without line for the documents
+// without lines, as the JSON ones
+func (f *File) errorf(line int, format string, args ...interface{}) error {
+	if line == 0 {
+		return fmt.Errorf("%s: %s", f.Path, fmt.Sprintf(format, args...))
+	}
+	return fmt.Errorf("%s:%d: %s", f.Path, line, fmt.Sprintf(format, args...))
+}
+
+// stripComment removes a trailing comment, outside of quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitKey splits "key: value", returning an empty key when the line is not
+// a mapping entry
+func splitKey(content string) (string, string) {
+	index := strings.Index(content, ":")
+	if index <= 0 || (index+1 < len(content) && content[index+1] != ' ') {
+		return "", ""
+	}
+	key := strings.TrimSpace(content[:index])
+	if strings.ContainsAny(key, " \"'") {
+		return "", ""
+	}
+	return key, strings.TrimSpace(content[index+1:])
+}
+
+func parseScalar(raw string) (string, error) {
+	if len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"' {
+		unquoted, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", raw)
+		}
+		return unquoted, nil
+	}
+	if len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+		return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+	}
+	if strings.HasPrefix(raw, "\"") || strings.HasPrefix(raw, "'") {
+		return "", fmt.Errorf("unterminated string %s", raw)
+	}
+	if raw == "~" || raw == "null" {
+		return "", nil
+	}
+	return raw, nil
+}
+
+func parseFlowList(raw string) ([]string, error) {
+	items := []string{}
+	if len(strings.TrimSpace(raw)) == 0 {
+		return items, nil
+	}
+	for _, item := range strings.Split(raw, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		value, err := parseScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+	return items, nil
+}
+
+// Validate checks the section of a manager against its schema, and the names
+// of all the sections. All the problems are reported at once.
+func (f *File) Validate(section string, schema Schema) error {
+	if f == nil {
+		return nil
+	}
+	var problems []string
+
+	names := make([]string, 0, len(f.Sections))
+	for name := range f.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !contains(Sections, name) {
+			problems = append(problems, f.errorf(f.sectionLines[name], "unknown section %s%s", name, suggest(name, Sections)).Error())
+		}
+	}
+
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	settings := f.Sections[section]
+	found := make([]string, 0, len(settings))
+	for key := range settings {
+		found = append(found, key)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if settings[found[i]].Line != settings[found[j]].Line {
+			return settings[found[i]].Line < settings[found[j]].Line
+		}
+		return found[i] < found[j]
+	})
+	for _, key := range found {
+		value := settings[key]
+		kind, known := schema[key]
+		if !known {
+			problems = append(problems, f.errorf(value.Line, "unknown setting %s.%s%s", section, key, suggest(key, keys)).Error())
+			continue
+		}
+		if err := check(kind, value); err != nil {
+			problems = append(problems, f.errorf(value.Line, "%s.%s must be %s, %s", section, key, kind, err).Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+func check(kind Kind, value Value) error {
+	if value.IsList && kind != List {
+		if len(value.List) == 0 {
+			return errors.New("got an empty value")
+		}
+		return errors.New("got a list")
+	}
+	switch kind {
+	case List:
+		if !value.IsList {
+			return fmt.Errorf("such as [%s], got %q", value.Scalar, value.Scalar)
+		}
+	case Int:
+		if _, err := strconv.Atoi(value.Scalar); err != nil {
+			return fmt.Errorf("got %q", value.Scalar)
+		}
+	case Bool:
+		if _, err := strconv.ParseBool(value.Scalar); err != nil {
+			return fmt.Errorf("got %q", value.Scalar)
+		}
+	case Duration:
+		if _, err := strconv.Atoi(value.Scalar); err == nil {
+			return nil
+		}
+		if _, err := time.ParseDuration(value.Scalar); err != nil {
+			return fmt.Errorf("got %q", value.Scalar)
+		}
+	}
+	return nil
+}
+
+// Environment returns the settings of a section as the environment variables
+// they stand for: prefix followed by the key in upper case, with underscores
+// instead of dashes. Lists are comma separated.
+func (f *File) Environment(section string, prefix string) map[string]string {
+	env := map[string]string{}
+	if f == nil {
+		return env
+	}
+	for key, value := range f.Sections[section] {
+		name := prefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if value.IsList {
+			env[name] = strings.Join(value.List, ",")
+		} else {
+			env[name] = value.Scalar
+		}
+	}
+	return env
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// suggest proposes the closest known name to a misspelled one
+func suggest(name string, known []string) string {
+	best, bestDistance := "", len(name)/2+1
+	for _, candidate := range known {
+		if d := distance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+// distance is the Levenshtein distance between two names
+func distance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,107 @@
+package settings
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSchema = Schema{
+	"scan-interval":   Duration,
+	"deep-scan-every": Int,
+	"report-holders":  Bool,
+	"privacy":         String,
+	"sinks":           List,
+	"actions":         List,
+}
+
+func TestParse(t *testing.T) {
+	data := `# Peripheral managers
+usb:
+  scan-interval: 10s   # faster than the default
+  deep-scan-every: 3
+  privacy: "hash # salted"
+  sinks: [file, 'mqtt']
+  actions:
+    - dfu
+    - reset
+
+bluetooth:
+  gatt-enumeration: true
+`
+	f, err := Parse("peripherals.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Validate("usb", testSchema); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"USB_SCAN_INTERVAL":   "10s",
+		"USB_DEEP_SCAN_EVERY": "3",
+		"USB_PRIVACY":         "hash # salted",
+		"USB_SINKS":           "file,mqtt",
+		"USB_ACTIONS":         "dfu,reset",
+	}
+	if env := f.Environment("usb", "USB_"); !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %v, got %v", expected, env)
+	}
+	if f.Sections["bluetooth"]["gatt-enumeration"].Scalar != "true" {
+		t.Errorf("expected the other sections to be parsed, got %v", f.Sections)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	data := `usb:
+  scan-intervall: 10s
+  deep-scan-every: often
+  sinks: file
+wifi:
+  scan: true
+`
+	f, err := Parse("peripherals.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Validate("usb", testSchema)
+	if err == nil {
+		t.Fatal("expected the file to be invalid")
+	}
+	for _, problem := range []string{
+		"peripherals.yaml:2: unknown setting usb.scan-intervall, did you mean scan-interval?",
+		`peripherals.yaml:3: usb.deep-scan-every must be an integer, got "often"`,
+		`peripherals.yaml:4: usb.sinks must be a list, such as [file], got "file"`,
+		"peripherals.yaml:5: unknown section wifi",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q to be reported, got:\n%s", problem, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for data, expected := range map[string]string{
+		"scan-interval: 10s\n":                    "peripherals.yaml:1: expected a section name",
+		"usb:\n  mqtt:\n    broker: local\n":      "peripherals.yaml:3: settings of a section must all have the same indentation",
+		"usb:\n\tsinks: [file]\n":                 "peripherals.yaml:2: indentation must use spaces",
+		"usb:\n  privacy: \"hash\n":               "peripherals.yaml:2: unterminated string",
+		"usb:\n  sinks: [file\n":                  "peripherals.yaml:2: unterminated list",
+		"usb:\n  privacy: hash\n  privacy: x\n":   "peripherals.yaml:3: usb.privacy is defined twice",
+		"usb:\n  privacy: hash\n    - truncate\n": "peripherals.yaml:3: list item outside of a list",
+	} {
+		if _, err := Parse("peripherals.yaml", []byte(data)); err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected %q for %q, got %v", expected, data, err)
+		}
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	f, err := Load(filepath.Join(t.TempDir(), "peripherals.yaml"))
+	if f != nil || err != nil {
+		t.Errorf("expected a missing file to be ignored, got %v (%v)", f, err)
+	}
+	if err := f.Validate("usb", testSchema); err != nil || len(f.Environment("usb", "USB_")) != 0 {
+		t.Errorf("expected a missing file to configure nothing, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxBatchEvents bounds the changes a file accumulates while the agent does
+// not consume it, the oldest ones are dropped first
+const maxBatchEvents = 1000
+
+// lockRetry is how often the channel lock is tried again while the agent
+// holds it
+const lockRetry = 50 * time.Millisecond
+
+// errLocked is returned by lockFile while another process holds the lock
+var errLocked = errors.New("the file is locked by another process")
+
+// BatchLayout names the files as the native layout, and writes the records
+// along with the changes they bring, as added, updated and removed events.
+// Instead of adding a file per report, the file sink rewrites the file the
+// agent did not consume yet with the latest records and the changes since the
+// file it consumed last, so a burst of changes, such as a hub and its devices
+// plugged in, leaves a single file the agent processes once.
+type BatchLayout struct{}
+
+// Batch is the content of the files of the batch layout
+type Batch struct {
+	Peripherals map[string]peripherals.Peripheral `json:"peripherals"`
+	Events      []BatchEvent                      `json:"events"`
+}
+
+// BatchEvent is a change reported in a batch. Removals carry no record.
+type BatchEvent struct {
+	Time       string                  `json:"time"`
+	Event      peripherals.EventType   `json:"event"`
+	Identifier string                  `json:"identifier"`
+	Peripheral *peripherals.Peripheral `json:"peripheral,omitempty"`
+}
+
+func (BatchLayout) FileName(at time.Time, sender string, sequence int64) string {
+	return NativeLayout{}.FileName(at, sender, sequence)
+}
+
+// Encode writes the records of a report without changes, as for the first
+// report of a batch of a single one
+func (BatchLayout) Encode(report map[string]peripherals.Peripheral) ([]byte, error) {
+	return json.Marshal(Batch{Peripherals: report, Events: []BatchEvent{}})
+}
+
+// batchEvents lists the changes between two reports, sorted by identifier
+func batchEvents(at time.Time, previous map[string]peripherals.Peripheral, current map[string]peripherals.Peripheral) []BatchEvent {
+	timestamp := at.UTC().Format(time.RFC3339Nano)
+	var events []BatchEvent
+	for identifier, peripheral := range current {
+		old, existed := previous[identifier]
+		if existed && reflect.DeepEqual(old, peripheral) {
+			continue
+		}
+		event := BatchEvent{Time: timestamp, Event: peripherals.EventAdded, Identifier: identifier}
+		if existed {
+			event.Event = peripherals.EventUpdated
+		}
+		record := peripheral
+		event.Peripheral = &record
+		events = append(events, event)
+	}
+	for identifier := range previous {
+		if _, exists := current[identifier]; !exists {
+			events = append(events, BatchEvent{Time: timestamp, Event: peripherals.EventRemoved, Identifier: identifier})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Identifier < events[j].Identifier })
+	return events
+}
+
+// lockChannel takes the lock the agent holds while consuming the buffer, a
+// file named after the channel next to its buffer folder, so a file is not
+// rewritten while being consumed
+func (s *FileSink) lockChannel(ctx context.Context) (func(), error) {
+	channel := filepath.Dir(filepath.Clean(s.Dir))
+	file, err := os.OpenFile(filepath.Join(channel, filepath.Base(channel)+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// The agent opens the lock for writing
+	permissions := s.permissions()
+	if err := permissions.applyFile(file, permissions.Mode|(permissions.Mode&0444)>>1); err != nil {
+		log.Debugf("Unable to set the permissions of the channel lock. Reason: %s", err)
+	}
+	for {
+		err := lockFile(file)
+		if err == nil {
+			return func() {
+				unlockFile(file)
+				file.Close()
+			}, nil
+		}
+		if err != errLocked {
+			file.Close()
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockRetry):
+		}
+	}
+}
+
+// sendBatch rewrites the file not consumed yet, or starts a new one
+func (s *FileSink) sendBatch(ctx context.Context, report Report) error {
+	unlock, err := s.lockChannel(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to lock the channel: %w", err)
+	}
+	defer unlock()
+
+	s.mu.Lock()
+	pending, events, previous := s.pending, s.events, s.reported
+	s.mu.Unlock()
+
+	if len(pending) > 0 {
+		if _, err := os.Stat(filepath.Join(s.Dir, pending)); err != nil {
+			// Consumed by the agent, its changes with it
+			pending, events = "", nil
+		}
+	}
+	if len(pending) == 0 {
+		pending = s.FileName(report)
+	}
+	events = append(append([]BatchEvent{}, events...), batchEvents(report.Time, previous, report.Peripherals)...)
+	if excess := len(events) - maxBatchEvents; excess > 0 {
+		events = events[excess:]
+	}
+
+	data, err := json.Marshal(Batch{Peripherals: report.Peripherals, Events: events})
+	if err != nil {
+		return err
+	}
+	// The file is written out of the buffer, which the agent expects to only
+	// hold reports, and moved in at once
+	temp, err := ioutil.TempFile(filepath.Dir(filepath.Clean(s.Dir)), ".batch-")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	permissions := s.permissions()
+	if err := permissions.applyFile(temp, permissions.Mode); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return fmt.Errorf("unable to set the permissions of %s: %w", temp.Name(), err)
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	file := filepath.Join(s.Dir, pending)
+	if err := os.Rename(temp.Name(), file); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	log.Infof("Saving USB peripherals to %s, with %d changes", file, len(events))
+
+	s.mu.Lock()
+	s.pending, s.events, s.reported = pending, events, report.Peripherals
+	s.mu.Unlock()
+	return nil
+}
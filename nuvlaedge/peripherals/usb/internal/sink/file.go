@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// DatetimeFormat is the timestamp layout of the buffer file names read by the
+// agent file broker, in UTC. Unlike RFC 3339 it only has letters and digits,
+// as the broker expects.
+const DatetimeFormat = "20060102T150405Z"
+
+// FileSink writes each report as a JSON file into the channel buffer folder
+// consumed by the agent
+type FileSink struct {
+	Dir    string
+	Sender string
+	// Layout of the files, the native one when nil
+	Layout Layout
+	// Permissions of the files, DefaultPermissions when nil
+	Permissions *Permissions
+
+	mu       sync.Mutex
+	sequence int64
+
+	// Batch layout: the file not consumed yet, its changes, and the last
+	// report written
+	pending  string
+	events   []BatchEvent
+	reported map[string]peripherals.Peripheral
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+// FileName returns the name of the buffer file for a report. The timestamp is
+// in UTC, so the names keep their order across time zone changes. It only has
+// a one second resolution, so it is followed by a sequence number:
+// the report time in microseconds, bumped when needed to stay strictly
+// increasing. Names never collide and sort in the order the reports were
+// made, across restarts too unless the clock goes back.
+func (s *FileSink) FileName(report Report) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sequence := report.Time.UnixNano() / int64(time.Microsecond)
+	if sequence <= s.sequence {
+		sequence = s.sequence + 1
+	}
+	s.sequence = sequence
+
+	return s.layout().FileName(report.Time, s.Sender, sequence)
+}
+
+func (s *FileSink) layout() Layout {
+	if s.Layout == nil {
+		return NativeLayout{}
+	}
+	return s.Layout
+}
+
+func (s *FileSink) Send(ctx context.Context, report Report) error {
+	if _, batch := s.layout().(BatchLayout); batch {
+		return s.sendBatch(ctx, report)
+	}
+	bData, err := s.layout().Encode(report.Peripherals)
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Join(s.Dir, s.FileName(report))
+	log.Infof("Saving USB peripherals to %s", file)
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	// Only the layouts without sequence number name two reports alike, the
+	// last one replaces the other
+	if os.IsExist(err) {
+		f, err = os.OpenFile(file, os.O_WRONLY|os.O_TRUNC, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	permissions := s.permissions()
+	if err := permissions.applyFile(f, permissions.Mode); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to set the permissions of %s: %w", file, err)
+	}
+	if _, err := f.Write(bData); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,156 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// Names of the buffer file layouts
+const (
+	LayoutNative = "native"
+	LayoutLegacy = "legacy"
+	LayoutBatch  = "batch"
+)
+
+// LegacyDatetimeFormat is the local time layout of the file names of the
+// legacy Python peripheral manager
+const LegacyDatetimeFormat = "01022006150405"
+
+// Layout names the buffer files and encodes the reports written to them
+type Layout interface {
+	// FileName returns the name of the file of a report made at the given
+	// time, given a strictly increasing sequence number
+	FileName(at time.Time, sender string, sequence int64) string
+	Encode(report map[string]peripherals.Peripheral) ([]byte, error)
+}
+
+// Layouts are the supported layouts, by name
+var Layouts = map[string]Layout{
+	LayoutNative: NativeLayout{},
+	LayoutLegacy: LegacyLayout{},
+	LayoutBatch:  BatchLayout{},
+}
+
+// NativeLayout names the files after the UTC time and a sequence number, and
+// writes the full records of the manager
+type NativeLayout struct{}
+
+func (NativeLayout) FileName(at time.Time, sender string, sequence int64) string {
+	return fmt.Sprintf("%s_%s_%d.json", at.UTC().Format(DatetimeFormat), sender, sequence)
+}
+
+func (NativeLayout) Encode(report map[string]peripherals.Peripheral) ([]byte, error) {
+	return json.Marshal(report)
+}
+
+// LegacyLayout mirrors the legacy Python peripheral manager, so the Go manager
+// can replace it on installs whose consumers rely on its files: names carry
+// the local time with a one second resolution, a report replacing the one
+// of the same second, and the records only have the attributes of the
+// nuvlabox-peripheral resource it used to report.
+type LegacyLayout struct{}
+
+func (LegacyLayout) FileName(at time.Time, sender string, sequence int64) string {
+	return fmt.Sprintf("%s_%s.json", at.Local().Format(LegacyDatetimeFormat), sender)
+}
+
+// legacyRecord holds the attributes of the legacy records
+type legacyRecord struct {
+	Identifier   string   `json:"identifier"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Interface    string   `json:"interface"`
+	Classes      []string `json:"classes"`
+	Available    bool     `json:"available"`
+	DevicePath   string   `json:"device-path,omitempty"`
+	Vendor       string   `json:"vendor,omitempty"`
+	Product      string   `json:"product,omitempty"`
+	SerialNumber string   `json:"serial-number,omitempty"`
+	VideoDevice  string   `json:"video-device,omitempty"`
+}
+
+func (LegacyLayout) Encode(report map[string]peripherals.Peripheral) ([]byte, error) {
+	records := make(map[string]legacyRecord, len(report))
+	for identifier, p := range report {
+		records[identifier] = legacyRecord{
+			Identifier:   p.Identifier,
+			Name:         p.Name,
+			Description:  p.Description,
+			Interface:    p.Interface,
+			Classes:      p.Classes,
+			Available:    p.Available,
+			DevicePath:   p.DevicePath,
+			Vendor:       p.Vendor,
+			Product:      p.Product,
+			SerialNumber: p.SerialNumber,
+			VideoDevice:  p.VideoDevice,
+		}
+	}
+	return json.Marshal(records)
+}
+
+// legacyFileName matches the names of the legacy layout
+var legacyFileName = regexp.MustCompile(`^(\d{14})_([a-zA-Z0-9]+)\.json$`)
+
+// MigrateBuffer renames the files of the legacy layout in a buffer folder to
+// the native layout, keeping their order and their content. Files of the
+// same second are ordered by modification time. It returns the number of
+// files renamed.
+func MigrateBuffer(dir string) (int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	type legacyFile struct {
+		info   os.FileInfo
+		at     time.Time
+		sender string
+	}
+	var files []legacyFile
+	for _, entry := range entries {
+		match := legacyFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		at, err := time.ParseInLocation(LegacyDatetimeFormat, match[1], time.Local)
+		if err != nil {
+			log.Warnf("Skipping buffer file %s. Reason: %s", entry.Name(), err)
+			continue
+		}
+		files = append(files, legacyFile{info: entry, at: at, sender: match[2]})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].at.Equal(files[j].at) {
+			return files[i].at.Before(files[j].at)
+		}
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+
+	// One sink per sender keeps the sequence numbers increasing
+	sinks := map[string]*FileSink{}
+	migrated := 0
+	for _, f := range files {
+		s, exists := sinks[f.sender]
+		if !exists {
+			s = &FileSink{Dir: dir, Sender: f.sender}
+			sinks[f.sender] = s
+		}
+		name := s.FileName(Report{Time: f.at})
+		if err := os.Rename(filepath.Join(dir, f.info.Name()), filepath.Join(dir, name)); err != nil {
+			return migrated, err
+		}
+		log.Infof("Migrated buffer file %s to %s", f.info.Name(), name)
+		migrated++
+	}
+	return migrated, nil
+}
@@ -0,0 +1,174 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestLegacyLayout(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileSink{Dir: dir, Sender: "usb", Layout: LegacyLayout{}}
+
+	report := testReport()
+	camera := report.Peripherals["046d:0825"]
+	camera.Classes = []string{"Video"}
+	camera.UVC = &peripherals.UVC{Autofocus: true}
+	camera.Attributes = map[string]interface{}{"location": "entrance"}
+	report.Peripherals["046d:0825"] = camera
+
+	if err := s.Send(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	// The report of the same second replaces the first one
+	camera.Name = "Entrance camera"
+	report.Peripherals["046d:0825"] = camera
+	if err := s.Send(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+
+	name := report.Time.Local().Format(LegacyDatetimeFormat) + "_usb.json"
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != name {
+		t.Fatalf("expected a single legacy file %s, got %v", name, files)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, name))
+	var records map[string]map[string]interface{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatal(err)
+	}
+	record := records["046d:0825"]
+	if record["name"] != "Entrance camera" || record["uvc"] != nil || record["location"] != nil || record["classes"] == nil {
+		t.Errorf("expected the legacy attributes only, got %s", data)
+	}
+}
+
+func TestMigrateBuffer(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	legacy := at.Format(LegacyDatetimeFormat)
+
+	write := func(name string, content string, modified time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(legacy+"_usb.json", `{"first": {}}`, at)
+	write(at.Add(-time.Hour).Format(LegacyDatetimeFormat)+"_usb.json", `{"older": {}}`, at)
+	write(legacy+"_network.json", `{"network": {}}`, at)
+	write("20240501T100000Z_usb_1714557600000000.json", `{}`, at)
+
+	migrated, err := MigrateBuffer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 3 {
+		t.Errorf("expected the 3 legacy files to be migrated, got %d", migrated)
+	}
+
+	files, _ := os.ReadDir(dir)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sequence := at.UnixNano() / int64(time.Microsecond)
+	utc := at.UTC().Format(DatetimeFormat)
+	older := at.Add(-time.Hour)
+	expected := []string{
+		"20240501T100000Z_usb_1714557600000000.json",
+		older.UTC().Format(DatetimeFormat) + "_usb_" + strconv.FormatInt(older.UnixNano()/int64(time.Microsecond), 10) + ".json",
+		utc + "_network_" + strconv.FormatInt(sequence, 10) + ".json",
+		utc + "_usb_" + strconv.FormatInt(sequence, 10) + ".json",
+	}
+	for _, name := range expected {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s after the migration, got %v", name, names)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, expected[3])); string(data) != `{"first": {}}` {
+		t.Errorf("expected the content to be kept, got %s", data)
+	}
+}
+
+func TestBatchLayout(t *testing.T) {
+	channel := t.TempDir()
+	dir := filepath.Join(channel, "buffer") + "/"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	s := &FileSink{Dir: dir, Sender: "usb", Layout: BatchLayout{}}
+	at := time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC)
+	webcam := peripherals.Peripheral{Identifier: "046d:0825", Name: "Webcam C270"}
+	hub := peripherals.Peripheral{Identifier: "05e3:0610", Name: "Hub"}
+	stick := peripherals.Peripheral{Identifier: "0781:5581", Name: "Ultra"}
+
+	read := func() (string, Batch) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("Buffer holds %d files, expected 1 (%v)", len(entries), err)
+		}
+		data, _ := ioutil.ReadFile(filepath.Join(dir, entries[0].Name()))
+		var batch Batch
+		if err := json.Unmarshal(data, &batch); err != nil {
+			t.Fatal(err)
+		}
+		return entries[0].Name(), batch
+	}
+	send := func(at time.Time, report map[string]peripherals.Peripheral) {
+		if err := s.Send(context.Background(), Report{Time: at, Peripherals: report}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(at, map[string]peripherals.Peripheral{webcam.Identifier: webcam})
+	// The hub and its devices show up over the next scans, before the agent
+	// consumes the buffer
+	send(at.Add(time.Second), map[string]peripherals.Peripheral{webcam.Identifier: webcam, hub.Identifier: hub})
+	send(at.Add(2*time.Second), map[string]peripherals.Peripheral{hub.Identifier: hub, stick.Identifier: stick})
+
+	name, batch := read()
+	if len(batch.Peripherals) != 2 || batch.Peripherals[stick.Identifier].Name != "Ultra" {
+		t.Errorf("Peripherals = %v", batch.Peripherals)
+	}
+	var events []string
+	for _, e := range batch.Events {
+		events = append(events, string(e.Event)+" "+e.Identifier)
+	}
+	// In the order of the scans, then of the identifiers
+	expected := []string{"added 046d:0825", "added 05e3:0610", "removed 046d:0825", "added 0781:5581"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Events = %v, expected %v", events, expected)
+	}
+	if removal := batch.Events[2]; removal.Peripheral != nil || removal.Time != "2024-03-05T10:20:32Z" {
+		t.Errorf("Removal = %+v", removal)
+	}
+
+	// Once consumed, the next report starts a new file with the new changes
+	if err := os.Remove(filepath.Join(dir, name)); err != nil {
+		t.Fatal(err)
+	}
+	renamed := hub
+	renamed.Name = "USB 2.0 Hub"
+	send(at.Add(3*time.Second), map[string]peripherals.Peripheral{hub.Identifier: renamed, stick.Identifier: stick})
+	next, batch := read()
+	if next == name || len(batch.Events) != 1 || batch.Events[0].Event != peripherals.EventUpdated ||
+		batch.Events[0].Peripheral.Name != "USB 2.0 Hub" {
+		t.Errorf("Next file %s holds %+v", next, batch.Events)
+	}
+	if _, err := os.Stat(filepath.Join(channel, filepath.Base(channel)+".lock")); err != nil {
+		t.Errorf("The channel lock was not used: %s", err)
+	}
+}
@@ -0,0 +1,20 @@
+package sink
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock of the file, without waiting. It
+// is the lock of the filelock package the agent uses.
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFile(file *os.File) {
+	_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
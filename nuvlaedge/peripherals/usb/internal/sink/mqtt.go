@@ -0,0 +1,251 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttPubAck     = 0x40
+	mqttDisconnect = 0xe0
+)
+
+// Return code of the brokers that cannot take clients for now
+const mqttServerUnavailable = 3
+
+// MQTTSink publishes each report to a topic of an MQTT broker, such as the
+// NuvlaEdge data gateway. Messages are sent with QoS 1 and retained, so late
+// subscribers get the current set of peripherals straight away.
+//
+// Only the subset of MQTT 3.1.1 needed to publish is implemented, which keeps
+// the manager free of extra dependencies.
+type MQTTSink struct {
+	Broker   string
+	Topic    string
+	ClientID string
+	Username string
+	Password string
+	Timeout  time.Duration
+
+	mu       sync.Mutex
+	conn     net.Conn
+	reader   *bufio.Reader
+	packetID uint16
+}
+
+func (s *MQTTSink) Name() string {
+	return "mqtt"
+}
+
+func (s *MQTTSink) Send(ctx context.Context, report Report) error {
+	payload, err := json.Marshal(report.Peripherals)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := s.publish(payload); err != nil {
+		// Drop the connection, the next attempt reconnects
+		s.closeConn()
+		return err
+	}
+	return nil
+}
+
+// Close disconnects from the broker
+func (s *MQTTSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	_, _ = s.conn.Write([]byte{mqttDisconnect, 0})
+	s.closeConn()
+	return nil
+}
+
+func (s *MQTTSink) closeConn() {
+	_ = s.conn.Close()
+	s.conn = nil
+	s.reader = nil
+}
+
+func (s *MQTTSink) address() (string, error) {
+	u, err := url.Parse(s.Broker)
+	if err != nil || len(u.Host) == 0 {
+		// Plain host:port
+		return s.Broker, nil
+	}
+	if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+		return "", fmt.Errorf("unsupported MQTT broker scheme %s", u.Scheme)
+	}
+	if len(u.Port()) == 0 {
+		return net.JoinHostPort(u.Hostname(), "1883"), nil
+	}
+	return u.Host, nil
+}
+
+func (s *MQTTSink) connect(ctx context.Context) error {
+	address, err := s.address()
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{Timeout: s.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSinkUnavailable, err)
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+
+	var flags byte = 0x02 // clean session
+	payload := encodeString(s.ClientID)
+	if len(s.Username) > 0 {
+		flags |= 0x80
+		payload = append(payload, encodeString(s.Username)...)
+		if len(s.Password) > 0 {
+			flags |= 0x40
+			payload = append(payload, encodeString(s.Password)...)
+		}
+	}
+
+	variableHeader := append(encodeString("MQTT"), 4, flags, 0, 60)
+	if err := s.write(mqttConnect, append(variableHeader, payload...)); err != nil {
+		s.closeConn()
+		return fmt.Errorf("%w: %v", ErrSinkUnavailable, err)
+	}
+
+	packetType, body, err := s.read()
+	if err != nil {
+		s.closeConn()
+		return fmt.Errorf("%w: %v", ErrSinkUnavailable, err)
+	}
+	if packetType != mqttConnAck || len(body) != 2 {
+		s.closeConn()
+		return fmt.Errorf("unexpected answer 0x%02x to MQTT connect", packetType)
+	}
+	if body[1] == mqttServerUnavailable {
+		s.closeConn()
+		return fmt.Errorf("%w: the MQTT broker is unavailable", ErrSinkUnavailable)
+	}
+	if body[1] != 0 {
+		s.closeConn()
+		return fmt.Errorf("MQTT broker refused the connection with code %d", body[1])
+	}
+	return nil
+}
+
+func (s *MQTTSink) publish(payload []byte) error {
+	s.packetID++
+	if s.packetID == 0 {
+		s.packetID = 1
+	}
+
+	body := encodeString(s.Topic)
+	body = append(body, byte(s.packetID>>8), byte(s.packetID))
+	body = append(body, payload...)
+
+	// QoS 1, retained
+	if err := s.write(mqttPublish|0x02|0x01, body); err != nil {
+		return fmt.Errorf("%w: %v", ErrSinkUnavailable, err)
+	}
+
+	packetType, ack, err := s.read()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSinkUnavailable, err)
+	}
+	if packetType != mqttPubAck || len(ack) != 2 || binary.BigEndian.Uint16(ack) != s.packetID {
+		return fmt.Errorf("unexpected answer 0x%02x to MQTT publish", packetType)
+	}
+	return nil
+}
+
+func (s *MQTTSink) write(header byte, body []byte) error {
+	if s.Timeout > 0 {
+		_ = s.conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+	}
+	packet := append([]byte{header}, encodeLength(len(body))...)
+	_, err := s.conn.Write(append(packet, body...))
+	return err
+}
+
+func (s *MQTTSink) read() (byte, []byte, error) {
+	if s.Timeout > 0 {
+		_ = s.conn.SetReadDeadline(time.Now().Add(s.Timeout))
+	}
+
+	header, err := s.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := decodeLength(s.reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header & 0xf0, body, nil
+}
+
+func encodeString(value string) []byte {
+	encoded := []byte{byte(len(value) >> 8), byte(len(value))}
+	return append(encoded, value...)
+}
+
+// encodeLength encodes the remaining length of a packet as an MQTT variable
+// byte integer
+func encodeLength(length int) []byte {
+	var encoded []byte
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		encoded = append(encoded, digit)
+		if length == 0 {
+			return encoded
+		}
+	}
+}
+
+func decodeLength(r io.ByteReader) (int, error) {
+	length, multiplier := 0, 1
+	for i := 0; i < 4; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			return length, nil
+		}
+		multiplier *= 128
+	}
+	return 0, errors.New("malformed MQTT remaining length")
+}
@@ -0,0 +1,111 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type publishedMessage struct {
+	header  byte
+	topic   string
+	payload []byte
+}
+
+// fakeBroker accepts a single client, acknowledges its connection and
+// publications, and forwards what it receives
+func fakeBroker(t *testing.T, connectCode byte) (string, <-chan publishedMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("unable to listen on localhost: %s", err)
+	}
+	messages := make(chan publishedMessage, 4)
+
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		for {
+			header, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			length, err := decodeLength(reader)
+			if err != nil {
+				return
+			}
+			body := make([]byte, length)
+			if _, err := io.ReadFull(reader, body); err != nil {
+				return
+			}
+
+			switch header & 0xf0 {
+			case mqttConnect:
+				_, _ = conn.Write([]byte{mqttConnAck, 2, 0, connectCode})
+			case mqttPublish:
+				topicLength := int(body[0])<<8 | int(body[1])
+				topic := string(body[2 : 2+topicLength])
+				packetID := body[2+topicLength : 4+topicLength]
+				messages <- publishedMessage{header: header, topic: topic, payload: body[4+topicLength:]}
+				_, _ = conn.Write([]byte{mqttPubAck, 2, packetID[0], packetID[1]})
+			}
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func TestMQTTSink(t *testing.T) {
+	address, messages := fakeBroker(t, 0)
+	s := &MQTTSink{Broker: "tcp://" + address, Topic: "nuvlaedge/peripherals/usb", ClientID: "usb", Timeout: time.Second}
+	defer s.Close()
+
+	if err := s.Send(context.Background(), testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-messages
+	if message.topic != "nuvlaedge/peripherals/usb" {
+		t.Errorf("unexpected topic %s", message.topic)
+	}
+	if message.header != mqttPublish|0x03 {
+		t.Errorf("expected a retained QoS 1 publication, got header 0x%02x", message.header)
+	}
+	if !bytes.Contains(message.payload, []byte("Webcam C270")) {
+		t.Errorf("unexpected payload %s", message.payload)
+	}
+}
+
+func TestMQTTSinkRefusedConnection(t *testing.T) {
+	address, _ := fakeBroker(t, 5)
+	s := &MQTTSink{Broker: address, Topic: "usb", ClientID: "usb", Timeout: time.Second}
+
+	if err := s.Send(context.Background(), testReport()); err == nil || errors.Is(err, ErrSinkUnavailable) {
+		t.Errorf("expected refused connection to be reported, got %v", err)
+	}
+
+	address, _ = fakeBroker(t, mqttServerUnavailable)
+	s.Broker = address
+	if err := s.Send(context.Background(), testReport()); !errors.Is(err, ErrSinkUnavailable) {
+		t.Errorf("expected the broker to be unavailable, got %v", err)
+	}
+}
+
+func TestRemainingLength(t *testing.T) {
+	for _, length := range []int{0, 127, 128, 16383, 16384, 2097151} {
+		decoded, err := decodeLength(bytes.NewReader(encodeLength(length)))
+		if err != nil || decoded != length {
+			t.Errorf("length %d decoded as %d (%v)", length, decoded, err)
+		}
+	}
+}
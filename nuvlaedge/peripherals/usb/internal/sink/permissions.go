@@ -0,0 +1,112 @@
+package sink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Permissions are the mode and ownership given to the buffer files, so an
+// agent not running as root can read and delete them
+type Permissions struct {
+	Mode os.FileMode
+	// Owner and group of the files, unchanged when negative
+	UID int
+	GID int
+}
+
+// DefaultPermissions leave the files to the user the manager runs as,
+// readable by everyone
+var DefaultPermissions = Permissions{Mode: 0644, UID: -1, GID: -1}
+
+// ParsePermissions reads the octal mode of the files, as 0640, and their
+// owner as uid, uid:gid or :gid, with numeric ids since the users of the agent
+// are rarely known in the container of the manager. An empty owner keeps the
+// files to the user the manager runs as.
+func ParsePermissions(mode string, owner string) (Permissions, error) {
+	permissions := DefaultPermissions
+	if len(mode) > 0 {
+		parsed, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || parsed > 0777 {
+			return permissions, fmt.Errorf("invalid buffer file mode %q, expected octal permissions such as 0640", mode)
+		}
+		permissions.Mode = os.FileMode(parsed)
+	}
+	if len(owner) == 0 {
+		return permissions, nil
+	}
+
+	parts := strings.SplitN(owner, ":", 2)
+	ids := []*int{&permissions.UID, &permissions.GID}
+	for i, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil || id < 0 {
+			return permissions, fmt.Errorf("invalid buffer owner %q, expected uid, uid:gid or :gid", owner)
+		}
+		*ids[i] = id
+	}
+	if permissions.UID < 0 && permissions.GID < 0 {
+		return permissions, fmt.Errorf("invalid buffer owner %q, expected uid, uid:gid or :gid", owner)
+	}
+	return permissions, nil
+}
+
+func (p Permissions) chown(path string) error {
+	if p.UID < 0 && p.GID < 0 {
+		return nil
+	}
+	return os.Chown(path, p.UID, p.GID)
+}
+
+// applyFile sets the mode and the owner of an open file
+func (p Permissions) applyFile(file *os.File, mode os.FileMode) error {
+	if err := file.Chmod(mode); err != nil {
+		return err
+	}
+	if p.UID < 0 && p.GID < 0 {
+		return nil
+	}
+	return file.Chown(p.UID, p.GID)
+}
+
+// applyDir gives the folder to the owner of the files. With a group, the
+// folder is writable by the group and has the setgid bit, so the group of
+// the files created in it, as the lock of the agent, is the one of the
+// folder.
+func (p Permissions) applyDir(path string) error {
+	if err := p.chown(path); err != nil {
+		return err
+	}
+	if p.GID < 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, info.Mode().Perm()|0070|os.ModeSetgid)
+}
+
+// Prepare applies the permissions to the buffer folder and to the channel
+// folder holding it
+func (s *FileSink) Prepare() error {
+	permissions := s.permissions()
+	for _, dir := range []string{filepath.Dir(filepath.Clean(s.Dir)), s.Dir} {
+		if err := permissions.applyDir(dir); err != nil {
+			return fmt.Errorf("unable to set the permissions of %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) permissions() Permissions {
+	if s.Permissions == nil {
+		return DefaultPermissions
+	}
+	return *s.Permissions
+}
@@ -0,0 +1,131 @@
+package sink
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/protowire"
+)
+
+// Field numbers of the Event message, see
+// proto/nuvlaedge/peripherals/v1/event.proto, and of the well-known
+// google.protobuf.Timestamp it embeds
+const (
+	protoSender   = 1
+	protoTime     = 2
+	protoSequence = 3
+	protoData     = 4
+
+	protoSeconds = 1
+	protoNanos   = 2
+
+	protoMapKey   = 1
+	protoMapValue = 2
+)
+
+// MarshalProto encodes the message as an Event of the protocol buffers schema.
+// The peripherals are encoded by identifier, for the frames of a report to be
+// identical from one encoding to the next.
+func (m Message) MarshalProto() ([]byte, error) {
+	var b []byte
+	b = protowire.AppendString(b, protoSender, m.Sender)
+	if !m.Time.IsZero() {
+		var timestamp []byte
+		timestamp = protowire.AppendVarint(timestamp, protoSeconds, uint64(m.Time.Unix()))
+		timestamp = protowire.AppendVarint(timestamp, protoNanos, uint64(m.Time.Nanosecond()))
+		b = protowire.AppendBytes(b, protoTime, timestamp)
+	}
+	b = protowire.AppendVarint(b, protoSequence, uint64(m.Sequence))
+
+	ids := make([]string, 0, len(m.Data))
+	for id := range m.Data {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		value, err := m.Data[id].MarshalProto()
+		if err != nil {
+			return nil, err
+		}
+		var entry []byte
+		entry = protowire.AppendString(entry, protoMapKey, id)
+		entry = protowire.AppendBytes(entry, protoMapValue, value)
+		b = protowire.AppendBytes(b, protoData, entry)
+	}
+	return b, nil
+}
+
+// UnmarshalProto decodes an Event message
+func (m *Message) UnmarshalProto(b []byte) error {
+	fields, err := protowire.Fields(b)
+	if err != nil {
+		return err
+	}
+
+	message := Message{Data: map[string]peripherals.Peripheral{}}
+	for _, field := range fields {
+		switch {
+		case field.Number == protoSequence && field.Type == protowire.VarintType:
+			message.Sequence = int64(field.Value)
+		case field.Type != protowire.BytesType:
+		case field.Number == protoSender:
+			message.Sender = string(field.Bytes)
+		case field.Number == protoTime:
+			if message.Time, err = unmarshalTimestamp(field.Bytes); err != nil {
+				return err
+			}
+		case field.Number == protoData:
+			id, peripheral, err := unmarshalEntry(field.Bytes)
+			if err != nil {
+				return err
+			}
+			message.Data[id] = peripheral
+		}
+	}
+
+	*m = message
+	return nil
+}
+
+func unmarshalTimestamp(b []byte) (time.Time, error) {
+	fields, err := protowire.Fields(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var seconds, nanos int64
+	for _, field := range fields {
+		switch {
+		case field.Type != protowire.VarintType:
+		case field.Number == protoSeconds:
+			seconds = int64(field.Value)
+		case field.Number == protoNanos:
+			nanos = int64(int32(field.Value))
+		}
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+func unmarshalEntry(b []byte) (string, peripherals.Peripheral, error) {
+	var id string
+	var peripheral peripherals.Peripheral
+
+	fields, err := protowire.Fields(b)
+	if err != nil {
+		return id, peripheral, err
+	}
+	for _, field := range fields {
+		if field.Type != protowire.BytesType {
+			continue
+		}
+		switch field.Number {
+		case protoMapKey:
+			id = string(field.Bytes)
+		case protoMapValue:
+			if err := peripheral.UnmarshalProto(field.Bytes); err != nil {
+				return id, peripheral, err
+			}
+		}
+	}
+	return id, peripheral, nil
+}
@@ -0,0 +1,51 @@
+package sink
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestMessageProto(t *testing.T) {
+	report := testReport()
+	report.Peripherals["1a86:7523"] = peripherals.Peripheral{
+		Identifier: "1a86:7523",
+		Name:       "USB Serial",
+		Classes:    []string{"Vendor Specific"},
+		Available:  true,
+		Attributes: map[string]interface{}{"location": "cabinet"},
+	}
+	message := Message{
+		Sender:   "usb",
+		Time:     report.Time.Add(250 * time.Millisecond),
+		Sequence: 42,
+		Data:     report.Peripherals,
+	}
+
+	data, err := message.MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Message
+	if err := decoded.UnmarshalProto(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Sender != "usb" || decoded.Sequence != 42 || !decoded.Time.Equal(message.Time) {
+		t.Errorf("expected the attributes of the event to be kept, got %+v", decoded)
+	}
+	serial := decoded.Data["1a86:7523"]
+	if !reflect.DeepEqual(serial, report.Peripherals["1a86:7523"]) {
+		t.Errorf("expected %+v after a round trip, got %+v", report.Peripherals["1a86:7523"], serial)
+	}
+	if decoded.Data["046d:0825"].Name != "Webcam C270" {
+		t.Errorf("expected every peripheral to be decoded, got %+v", decoded.Data)
+	}
+
+	// The encoding does not depend on the order of the map
+	again, _ := message.MarshalProto()
+	if !reflect.DeepEqual(data, again) {
+		t.Error("expected the encoding to be deterministic")
+	}
+}
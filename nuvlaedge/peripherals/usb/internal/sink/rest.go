@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// RESTSink posts each report as a JSON document to an HTTP endpoint
+type RESTSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewRESTSink creates a sink posting to url with the given request timeout
+func NewRESTSink(url string, timeout time.Duration) *RESTSink {
+	return &RESTSink{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+func (s *RESTSink) Name() string {
+	return "rest"
+}
+
+func (s *RESTSink) Send(ctx context.Context, report Report) error {
+	bData, err := json.Marshal(report.Peripherals)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(bData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSinkUnavailable, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	// Overloaded or failing servers may take the report later, the other
+	// errors are refusals
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("%w: %s answered %s", ErrSinkUnavailable, s.URL, resp.Status)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", s.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Package sink delivers the USB discovery reports to their consumers. Several
+// sinks can be active at once, each one fed by the Dispatcher through its own
+// queue and retry loop, so a slow or unreachable sink never holds back the
+// others.
+//
+// Each sink also goes through a circuit breaker: after FailureThreshold
+// reports in a row could not be delivered, the circuit opens and the reports
+// are dropped without contacting the sink. Once OpenTimeout has elapsed, the
+// next report probes the sink with a single attempt, which closes the circuit
+// when the sink recovered and opens it again otherwise.
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// Report is the outcome of a discovery: the peripherals found, indexed by
+// identifier, as expected by the NuvlaEdge agent
+type Report struct {
+	Time        time.Time
+	Peripherals map[string]peripherals.Peripheral
+}
+
+// Sink delivers reports to one destination
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, report Report) error
+}
+
+// ErrSinkUnavailable is wrapped by the errors of the sinks that could not
+// reach their destination, as opposed to the ones the destination refused
+var ErrSinkUnavailable = errors.New("sink unavailable")
+
+// RetryPolicy tells how many times, and how far apart, a sink retries a report
+// before dropping it, and when its circuit opens. A zero FailureThreshold
+// disables the circuit breaker.
+type RetryPolicy struct {
+	MaxRetries       int
+	Backoff          time.Duration
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+// States of a sink circuit
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// Health is the state of the circuit of a sink
+type Health struct {
+	Sink  string `json:"sink"`
+	State string `json:"state"`
+	// Failures is the number of reports in a row the sink could not deliver
+	Failures  int    `json:"failures"`
+	Dropped   int    `json:"dropped"`
+	LastError string `json:"last-error,omitempty"`
+	// Since is when the circuit last changed state
+	Since string `json:"since,omitempty"`
+}
+
+type queue struct {
+	sink    Sink
+	reports chan Report
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	dropped  int
+	lastErr  string
+	since    time.Time
+}
+
+// Dispatcher fans reports out to a set of sinks
+type Dispatcher struct {
+	queues []*queue
+	policy RetryPolicy
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// delivered is called by the sink workers after every successful delivery
+	delivered func(sink string, report Report, at time.Time)
+}
+
+// NewDispatcher starts one worker per sink, each with a queue holding up to
+// queueSize pending reports
+func NewDispatcher(sinks []Sink, queueSize int, policy RetryPolicy) *Dispatcher {
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{policy: policy, ctx: ctx, cancel: cancel}
+
+	for _, s := range sinks {
+		q := &queue{sink: s, reports: make(chan Report, queueSize), state: CircuitClosed}
+		d.queues = append(d.queues, q)
+		d.wg.Add(1)
+		go d.run(q)
+	}
+	return d
+}
+
+// OnDelivery sets the function called whenever a sink delivered a report. It
+// must be set before the first Publish.
+func (d *Dispatcher) OnDelivery(delivered func(sink string, report Report, at time.Time)) {
+	d.delivered = delivered
+}
+
+// Publish queues the report on every sink. When a sink queue is full its
+// oldest report is dropped, since newer reports supersede it anyway. Publish
+// must not be called after Close.
+func (d *Dispatcher) Publish(report Report) {
+	for _, q := range d.queues {
+		q.push(report)
+	}
+}
+
+func (q *queue) push(report Report) {
+	for {
+		select {
+		case q.reports <- report:
+			return
+		default:
+		}
+
+		select {
+		case dropped := <-q.reports:
+			log.Warnf("Sink %s queue is full. Dropping report from %s",
+				q.sink.Name(), dropped.Time.Format(time.RFC3339))
+		default:
+		}
+	}
+}
+
+// Close delivers the queued reports and stops the sink workers. Deliveries
+// still running when ctx is done are abandoned.
+func (d *Dispatcher) Close(ctx context.Context) {
+	for _, q := range d.queues {
+		close(q.reports)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+	}
+
+	for _, q := range d.queues {
+		if closer, ok := q.sink.(interface{ Close() error }); ok {
+			_ = closer.Close()
+		}
+	}
+}
+
+// Health returns the circuit state of every sink
+func (d *Dispatcher) Health() []Health {
+	health := make([]Health, 0, len(d.queues))
+	for _, q := range d.queues {
+		q.mu.Lock()
+		entry := Health{
+			Sink:      q.sink.Name(),
+			State:     q.state,
+			Failures:  q.failures,
+			Dropped:   q.dropped,
+			LastError: q.lastErr,
+		}
+		if !q.since.IsZero() {
+			entry.Since = q.since.UTC().Format(time.RFC3339)
+		}
+		q.mu.Unlock()
+		health = append(health, entry)
+	}
+	return health
+}
+
+func (d *Dispatcher) run(q *queue) {
+	defer d.wg.Done()
+	for report := range q.reports {
+		retries, ok := d.admit(q)
+		if !ok {
+			continue
+		}
+		err := d.deliver(q.sink, report, retries)
+		d.settle(q, err)
+		if err == nil && d.delivered != nil {
+			d.delivered(q.sink.Name(), report, time.Now())
+		}
+	}
+}
+
+// admit tells whether a report goes to the sink, and how many times it may be
+// retried. Open circuits drop the reports until their timeout elapsed, then
+// let a single attempt through.
+func (d *Dispatcher) admit(q *queue) (int, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.state != CircuitOpen {
+		return d.policy.MaxRetries, true
+	}
+	if time.Since(q.since) < d.policy.OpenTimeout {
+		q.dropped++
+		log.Debugf("Sink %s circuit is open. Dropping report", q.sink.Name())
+		return 0, false
+	}
+	q.state = CircuitHalfOpen
+	q.since = time.Now()
+	log.Infof("Probing sink %s after %s with an open circuit", q.sink.Name(), d.policy.OpenTimeout)
+	return 0, true
+}
+
+// settle updates the circuit with the outcome of a delivery
+func (d *Dispatcher) settle(q *queue, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err == nil {
+		if q.state != CircuitClosed {
+			log.Infof("Sink %s recovered, closing its circuit", q.sink.Name())
+			q.state = CircuitClosed
+			q.since = time.Now()
+		}
+		q.failures = 0
+		return
+	}
+
+	q.failures++
+	q.lastErr = err.Error()
+	if d.policy.FailureThreshold <= 0 {
+		return
+	}
+	if q.state == CircuitHalfOpen || (q.state == CircuitClosed && q.failures >= d.policy.FailureThreshold) {
+		log.Warnf("Opening the circuit of sink %s after %d failed reports. Next attempt in %s",
+			q.sink.Name(), q.failures, d.policy.OpenTimeout)
+		q.state = CircuitOpen
+		q.since = time.Now()
+	}
+}
+
+func (d *Dispatcher) deliver(s Sink, report Report, retries int) error {
+	for attempt := 0; ; attempt++ {
+		err := s.Send(d.ctx, report)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= retries || d.ctx.Err() != nil {
+			log.Errorf("Unable to deliver report to sink %s after %d attempts. Reason: %s",
+				s.Name(), attempt+1, err)
+			return err
+		}
+
+		log.Warnf("Unable to deliver report to sink %s. Retrying... Reason: %s", s.Name(), err)
+		select {
+		case <-time.After(d.policy.Backoff * time.Duration(attempt+1)):
+		case <-d.ctx.Done():
+		}
+	}
+}
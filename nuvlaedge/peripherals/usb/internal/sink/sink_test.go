@@ -0,0 +1,296 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+type recordingSink struct {
+	name     string
+	failures int
+
+	mu       sync.Mutex
+	attempts int
+	reports  []Report
+}
+
+func (s *recordingSink) Name() string {
+	return s.name
+}
+
+func (s *recordingSink) Send(ctx context.Context, report Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.attempts <= s.failures {
+		return errors.New("unavailable")
+	}
+	s.reports = append(s.reports, report)
+	return nil
+}
+
+func testReport() Report {
+	return Report{
+		Time:        time.Date(2024, 3, 5, 10, 20, 30, 0, time.UTC),
+		Peripherals: map[string]peripherals.Peripheral{"046d:0825": {Identifier: "046d:0825", Name: "Webcam C270"}},
+	}
+}
+
+func TestDispatcherFansOutWithRetries(t *testing.T) {
+	healthy := &recordingSink{name: "healthy"}
+	flaky := &recordingSink{name: "flaky", failures: 2}
+	broken := &recordingSink{name: "broken", failures: 100}
+
+	d := NewDispatcher([]Sink{healthy, flaky, broken}, 5, RetryPolicy{MaxRetries: 2, Backoff: time.Millisecond})
+	d.Publish(testReport())
+	d.Close(context.Background())
+
+	if len(healthy.reports) != 1 {
+		t.Errorf("expected healthy sink to get the report, got %d", len(healthy.reports))
+	}
+	if len(flaky.reports) != 1 || flaky.attempts != 3 {
+		t.Errorf("expected flaky sink to get the report on the third attempt, got %d after %d attempts",
+			len(flaky.reports), flaky.attempts)
+	}
+	if len(broken.reports) != 0 || broken.attempts != 3 {
+		t.Errorf("expected broken sink to give up after 3 attempts, got %d", broken.attempts)
+	}
+}
+
+func TestDispatcherOnDelivery(t *testing.T) {
+	healthy := &recordingSink{name: "healthy"}
+	broken := &recordingSink{name: "broken", failures: 100}
+
+	var mu sync.Mutex
+	var delivered []string
+	d := NewDispatcher([]Sink{healthy, broken}, 5, RetryPolicy{})
+	d.OnDelivery(func(sink string, report Report, at time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		delivered = append(delivered, sink)
+	})
+	d.Publish(testReport())
+	d.Close(context.Background())
+
+	if len(delivered) != 1 || delivered[0] != "healthy" {
+		t.Errorf("expected only the healthy sink delivery to be notified, got %v", delivered)
+	}
+}
+
+func TestQueueDropsOldestReport(t *testing.T) {
+	q := &queue{sink: &recordingSink{name: "slow"}, reports: make(chan Report, 2)}
+
+	for i := 0; i < 3; i++ {
+		report := testReport()
+		report.Time = report.Time.Add(time.Duration(i) * time.Second)
+		q.push(report)
+	}
+
+	first := <-q.reports
+	second := <-q.reports
+	if first.Time.Second() != 31 || second.Time.Second() != 32 {
+		t.Errorf("expected the two newest reports to be kept, got %s and %s", first.Time, second.Time)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	broken := &recordingSink{name: "broken", failures: 3}
+	d := NewDispatcher([]Sink{broken}, 5, RetryPolicy{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond})
+	defer d.Close(context.Background())
+	q := d.queues[0]
+
+	deliver := func() bool {
+		retries, ok := d.admit(q)
+		if ok {
+			d.settle(q, d.deliver(broken, testReport(), retries))
+		}
+		return ok
+	}
+
+	deliver()
+	deliver()
+	if health := d.Health()[0]; health.State != CircuitOpen || health.Failures != 2 || health.LastError != "unavailable" {
+		t.Fatalf("expected the circuit to open after 2 failures, got %+v", health)
+	}
+	if deliver() || d.Health()[0].Dropped != 1 {
+		t.Fatalf("expected the open circuit to drop the report, got %+v", d.Health()[0])
+	}
+
+	// The probe after the timeout fails, reopening the circuit at once
+	time.Sleep(25 * time.Millisecond)
+	if !deliver() || d.Health()[0].State != CircuitOpen {
+		t.Fatalf("expected the failed probe to reopen the circuit, got %+v", d.Health()[0])
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !deliver() {
+		t.Fatal("expected a probe after the timeout")
+	}
+	if health := d.Health()[0]; health.State != CircuitClosed || health.Failures != 0 || len(broken.reports) != 1 {
+		t.Errorf("expected the recovered sink to close its circuit, got %+v", health)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileSink{Dir: dir, Sender: "usb"}
+
+	if err := s.Send(context.Background(), testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	report := testReport()
+	name := fmt.Sprintf("20240305T102030Z_usb_%d.json", report.Time.UnixNano()/int64(time.Microsecond))
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("expected report file to be written: %s", err)
+	}
+	var records map[string]interface{}
+	if err := json.Unmarshal(data, &records); err != nil || records["046d:0825"] == nil {
+		t.Errorf("unexpected report content %s", data)
+	}
+
+	// reports made within the same microsecond still get distinct, ordered names
+	if err := s.Send(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 2 || files[0].Name() != name || files[1].Name() <= name {
+		t.Errorf("expected two ordered report files, got %v", files)
+	}
+
+	// Names are in UTC whatever the time zone of the report time
+	report.Time = report.Time.In(time.FixedZone("CEST", 2*60*60)).Add(time.Second)
+	if name := s.FileName(report); !strings.HasPrefix(name, "20240305T102031Z_usb_") {
+		t.Errorf("expected a UTC file name, got %s", name)
+	}
+}
+
+func TestFileSinkPermissions(t *testing.T) {
+	for _, c := range []struct {
+		mode, owner string
+		expected    Permissions
+		valid       bool
+	}{
+		{"", "", DefaultPermissions, true},
+		{"0640", "1000:1000", Permissions{Mode: 0640, UID: 1000, GID: 1000}, true},
+		{"600", "1000", Permissions{Mode: 0600, UID: 1000, GID: -1}, true},
+		{"0660", ":27", Permissions{Mode: 0660, UID: -1, GID: 27}, true},
+		{"0644", "nuvla", Permissions{}, false},
+		{"0644", ":", Permissions{}, false},
+		{"rw-r--r--", "", Permissions{}, false},
+		{"01777", "", Permissions{}, false},
+	} {
+		permissions, err := ParsePermissions(c.mode, c.owner)
+		if (err == nil) != c.valid || (c.valid && permissions != c.expected) {
+			t.Errorf("ParsePermissions(%q, %q) = %+v, %v", c.mode, c.owner, permissions, err)
+		}
+	}
+
+	// The files can only be given to the current group without privileges
+	channel := t.TempDir()
+	dir := filepath.Join(channel, "buffer")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	permissions := Permissions{Mode: 0640, UID: -1, GID: os.Getgid()}
+	s := &FileSink{Dir: dir, Sender: "usb", Permissions: &permissions}
+	if err := s.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), testReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := os.ReadDir(dir)
+	info, err := files[0].Info()
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("expected the report to be written 0640, got %v (%v)", info.Mode(), err)
+	}
+	for _, folder := range []string{channel, dir} {
+		info, err := os.Stat(folder)
+		if err != nil || info.Mode()&os.ModeSetgid == 0 || info.Mode().Perm()&0070 != 0070 {
+			t.Errorf("expected %s to be group writable with the setgid bit, got %v (%v)", folder, info.Mode(), err)
+		}
+	}
+}
+
+func TestSnapshotSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usb", "latest.json")
+	s := &SnapshotSink{Path: path}
+
+	report := testReport()
+	report.Peripherals["0c2e:0b61"] = peripherals.Peripheral{
+		Identifier: "0c2e:0b61", Interface: "USB", Classes: []string{"HID"}, Available: true,
+	}
+	if err := s.Send(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshot Snapshot
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Count != 2 || snapshot.Available != 1 || snapshot.ByClass["HID"] != 1 || snapshot.ByInterface["USB"] != 1 ||
+		len(snapshot.Identifiers) != 2 || snapshot.Identifiers[0] != "046d:0825" || !snapshot.Time.Equal(report.Time) {
+		t.Errorf("unexpected snapshot %s", data)
+	}
+
+	// Every report replaces the previous snapshot
+	if err := s.Send(context.Background(), Report{Time: report.Time}); err != nil {
+		t.Fatal(err)
+	}
+	var empty Snapshot
+	data, _ = os.ReadFile(path)
+	if err := json.Unmarshal(data, &empty); err != nil || empty.Count != 0 || len(empty.Peripherals) != 0 {
+		t.Errorf("expected an empty snapshot, got %s", data)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("expected no temporary file to be left")
+	}
+}
+
+func TestRESTSink(t *testing.T) {
+	var received map[string]interface{}
+	status := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	s := NewRESTSink(server.URL, time.Second)
+	if err := s.Send(context.Background(), testReport()); err != nil {
+		t.Fatal(err)
+	}
+	if received["046d:0825"] == nil {
+		t.Errorf("unexpected payload %v", received)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := s.Send(context.Background(), testReport()); !errors.Is(err, ErrSinkUnavailable) {
+		t.Errorf("expected the server to be unavailable, got %v", err)
+	}
+	status = http.StatusBadRequest
+	if err := s.Send(context.Background(), testReport()); err == nil || errors.Is(err, ErrSinkUnavailable) {
+		t.Errorf("expected the refusal to be reported, got %v", err)
+	}
+}
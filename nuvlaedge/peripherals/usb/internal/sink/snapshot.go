@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// Snapshot is the content of the snapshot file: the peripherals of the last
+// report, with their counts
+type Snapshot struct {
+	Time        time.Time                         `json:"time"`
+	Count       int                               `json:"count"`
+	Available   int                               `json:"available"`
+	ByClass     map[string]int                    `json:"by-class"`
+	ByInterface map[string]int                    `json:"by-interface"`
+	Identifiers []string                          `json:"identifiers"`
+	Peripherals map[string]peripherals.Peripheral `json:"peripherals"`
+}
+
+// NewSnapshot summarizes a report
+func NewSnapshot(report Report) Snapshot {
+	snapshot := Snapshot{
+		Time:        report.Time.UTC(),
+		Count:       len(report.Peripherals),
+		ByClass:     map[string]int{},
+		ByInterface: map[string]int{},
+		Identifiers: make([]string, 0, len(report.Peripherals)),
+		Peripherals: report.Peripherals,
+	}
+	if snapshot.Peripherals == nil {
+		snapshot.Peripherals = map[string]peripherals.Peripheral{}
+	}
+	for identifier, peripheral := range report.Peripherals {
+		snapshot.Identifiers = append(snapshot.Identifiers, identifier)
+		if peripheral.Available {
+			snapshot.Available++
+		}
+		for _, class := range peripheral.Classes {
+			snapshot.ByClass[class]++
+		}
+		if len(peripheral.Interface) > 0 {
+			snapshot.ByInterface[peripheral.Interface]++
+		}
+	}
+	sort.Strings(snapshot.Identifiers)
+	return snapshot
+}
+
+// SnapshotSink keeps a single file with the peripherals currently attached,
+// rewritten with every report. Unlike the buffer files, which are events
+// consumed by the agent, it is never removed, so new consumers can read the
+// current state without replaying the history.
+type SnapshotSink struct {
+	Path string
+}
+
+func (s *SnapshotSink) Name() string {
+	return "snapshot"
+}
+
+func (s *SnapshotSink) Send(ctx context.Context, report Report) error {
+	bData, err := json.Marshal(NewSnapshot(report))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), os.ModePerm); err != nil {
+		return err
+	}
+	// Readers never see a partial file
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, bData, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.Path)
+}
@@ -0,0 +1,221 @@
+package sink
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxFrameSize bounds the frames of the socket protocol
+const MaxFrameSize = 16 << 20
+
+// Encodings of the frames of the socket protocol
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+)
+
+// Encodings are the supported frame encodings
+var Encodings = []string{EncodingJSON, EncodingProtobuf}
+
+// Message is the content of a frame, with the attributes of the messages the
+// agent reads from the buffer files
+type Message struct {
+	Sender   string                            `json:"sender"`
+	Time     time.Time                         `json:"time"`
+	Sequence int64                             `json:"sequence"`
+	Data     map[string]peripherals.Peripheral `json:"data"`
+}
+
+// SocketSink streams the reports to the clients of a unix domain socket, such
+// as the agent, as an alternative to the buffer folder: the clients get every
+// report as soon as it is made, in full, without polling a shared folder.
+//
+// Each report is a frame: its length as a 4 bytes big-endian integer,
+// followed by the Message, as JSON or as an Event of the protocol buffers
+// schema depending on Encoding. A client gets the last report when it
+// connects. Clients too slow to read a frame within the timeout are
+// disconnected.
+type SocketSink struct {
+	Path     string
+	Sender   string
+	Encoding string
+	Timeout  time.Duration
+
+	listener net.Listener
+
+	mu       sync.Mutex
+	clients  map[net.Conn]bool
+	last     []byte
+	sequence int64
+}
+
+// ListenSocket creates the socket at path, replacing the one of a previous
+// run, and starts accepting clients
+func ListenSocket(path string, sender string, encoding string, timeout time.Duration) (*SocketSink, error) {
+	if !validEncoding(encoding) {
+		return nil, fmt.Errorf("unknown frame encoding %q", encoding)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// The agent runs in another container, possibly as another user
+	if err := os.Chmod(path, 0666); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s := &SocketSink{Path: path, Sender: sender, Encoding: encoding, Timeout: timeout, listener: listener, clients: map[net.Conn]bool{}}
+	go s.accept()
+	return s, nil
+}
+
+func (s *SocketSink) Name() string {
+	return "socket"
+}
+
+func (s *SocketSink) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Errorf("Unable to accept a client on %s. Reason: %s", s.Path, err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.last != nil && s.write(conn, s.last) != nil {
+			s.mu.Unlock()
+			continue
+		}
+		s.clients[conn] = true
+		s.mu.Unlock()
+		log.Infof("Client connected to %s", s.Path)
+	}
+}
+
+// write sends a frame to a client, disconnecting it on failure
+func (s *SocketSink) write(conn net.Conn, frame []byte) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+	if _, err := conn.Write(frame); err != nil {
+		conn.Close()
+		delete(s.clients, conn)
+		log.Warnf("Disconnected client of %s. Reason: %s", s.Path, err)
+		return err
+	}
+	return nil
+}
+
+// Send streams the report to the connected clients. Not having any client is
+// not a failure: the report is kept for the next one to connect.
+func (s *SocketSink) Send(ctx context.Context, report Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequence++
+	frame, err := EncodeFrame(Message{
+		Sender:   s.Sender,
+		Time:     report.Time.UTC(),
+		Sequence: s.sequence,
+		Data:     report.Peripherals,
+	}, s.Encoding)
+	if err != nil {
+		return err
+	}
+	s.last = frame
+
+	for conn := range s.clients {
+		_ = s.write(conn, frame)
+	}
+	return nil
+}
+
+// Close stops accepting clients, disconnects the connected ones and removes
+// the socket
+func (s *SocketSink) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.clients {
+		conn.Close()
+		delete(s.clients, conn)
+	}
+	return err
+}
+
+func validEncoding(encoding string) bool {
+	for _, known := range Encodings {
+		if encoding == known {
+			return true
+		}
+	}
+	return false
+}
+
+// EncodeFrame encodes a message as a frame, with one of the Encodings
+func EncodeFrame(message Message, encoding string) ([]byte, error) {
+	var payload []byte
+	var err error
+	switch encoding {
+	case EncodingJSON:
+		payload, err = json.Marshal(message)
+	case EncodingProtobuf:
+		payload, err = message.MarshalProto()
+	default:
+		err = fmt.Errorf("unknown frame encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > MaxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the maximum of %d", len(payload), MaxFrameSize)
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	return frame, nil
+}
+
+// ReadFrame reads the next frame of a stream, whatever its encoding: a JSON
+// payload is an object, starting with a brace, which is not a valid field tag
+// of a protocol buffers Event
+func ReadFrame(r io.Reader) (Message, error) {
+	var message Message
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return message, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > MaxFrameSize {
+		return message, fmt.Errorf("frame of %d bytes exceeds the maximum of %d", size, MaxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return message, err
+	}
+	if len(payload) > 0 && payload[0] == '{' {
+		err := json.Unmarshal(payload, &message)
+		return message, err
+	}
+	err := message.UnmarshalProto(payload)
+	return message, err
+}
@@ -0,0 +1,85 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSocketSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usb", "manager.sock")
+	// A socket left by a previous run is replaced
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, nil, 0644)
+
+	s, err := ListenSocket(path, "usb", EncodingJSON, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Reports made without clients are kept for the first one
+	report := testReport()
+	if err := s.Send(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	message, err := ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Sender != "usb" || message.Sequence != 1 || len(message.Data) != len(report.Peripherals) {
+		t.Errorf("expected the last report on connection, got %+v", message)
+	}
+
+	delete(report.Peripherals, "046d:0825")
+	if err := s.Send(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	message, err = ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Sequence != 2 || len(message.Data) != len(report.Peripherals) {
+		t.Errorf("expected the new report to be streamed, got %+v", message)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	// Frames are decoded whatever their encoding
+	for _, encoding := range Encodings {
+		frame, err := EncodeFrame(Message{Sender: "usb", Sequence: 3}, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message, err := ReadFrame(bytes.NewReader(frame)); err != nil || message.Sequence != 3 {
+			t.Errorf("expected the %s frame to be decoded, got %+v (%v)", encoding, message, err)
+		}
+	}
+	if _, err := EncodeFrame(Message{}, "xml"); err == nil {
+		t.Error("expected an unknown encoding to be rejected")
+	}
+
+	frame, err := EncodeFrame(Message{Sender: "usb", Sequence: 3}, EncodingJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Truncated frames and oversized lengths are rejected
+	if _, err := ReadFrame(bytes.NewReader(frame[:len(frame)-1])); err == nil {
+		t.Error("expected a truncated frame to fail")
+	}
+	if _, err := ReadFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})); err == nil {
+		t.Error("expected an oversized frame to be rejected")
+	}
+}
@@ -0,0 +1,177 @@
+// Package throttle limits how often the records of the chatty peripheral
+// classes change in the reports, so their updates do not dominate the channel
+// to the agent and Nuvla.
+//
+// Every class gets one of three policies:
+//
+//	every-scan   the record is reported as found by every scan, the default
+//	on-change    the record is only reported again when the peripheral changes
+//	<duration>   as on-change, and refreshed at most every duration, as 1h
+//
+// A peripheral changes when it becomes available or not, moves to another
+// device node, is claimed or goes missing: these changes are always reported right away, as
+// are the peripherals attached or detached. What a throttle holds back are
+// the other attributes, such as the signal of a modem or the free space of a
+// storage device, which keep their last reported value in the meantime.
+//
+// A peripheral with several classes follows the most frequent of the
+// policies set for its classes, or the "*" policy when none of them is set.
+package throttle
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// Policies understood besides the durations
+const (
+	EveryScan = "every-scan"
+	OnChange  = "on-change"
+)
+
+// Default is the class matching the peripherals without a policy for theirs
+const Default = "*"
+
+// Policy is how often the record of a peripheral is refreshed. A zero interval
+// refreshes it at every scan, unless the updates wait for a change.
+type Policy struct {
+	Interval time.Duration
+	OnChange bool
+}
+
+// frequency orders the policies from the most frequent
+func (p Policy) frequency() time.Duration {
+	if p.OnChange {
+		return time.Duration(math.MaxInt64)
+	}
+	return p.Interval
+}
+
+func (p Policy) String() string {
+	switch {
+	case p.OnChange:
+		return OnChange
+	case p.Interval > 0:
+		return p.Interval.String()
+	default:
+		return EveryScan
+	}
+}
+
+// Rules are the policies by class name, lower cased
+type Rules map[string]Policy
+
+// Parse reads the class=policy entries of the configuration, the class names
+// as in the records, case-insensitive:
+//
+//	Mass Storage=1h, Video=on-change, Human Interface Device=every-scan, *=10m
+func Parse(entries []string) (Rules, error) {
+	rules := Rules{}
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			return nil, fmt.Errorf("invalid class throttle %q, expected class=policy", entry)
+		}
+		class := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		var policy Policy
+		switch value {
+		case EveryScan:
+		case OnChange:
+			policy.OnChange = true
+		default:
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("invalid throttle %q for class %s, expected %s, %s or a duration",
+					value, parts[0], EveryScan, OnChange)
+			}
+			policy.Interval = interval
+		}
+		rules[class] = policy
+	}
+	return rules, nil
+}
+
+// Policy returns the policy of a peripheral, from its classes
+func (r Rules) Policy(peripheral peripherals.Peripheral) Policy {
+	var policy Policy
+	found := false
+	for _, class := range peripheral.Classes {
+		p, ok := r[strings.ToLower(class)]
+		if ok && (!found || p.frequency() < policy.frequency()) {
+			policy, found = p, true
+		}
+	}
+	if !found {
+		policy = r[Default]
+	}
+	return policy
+}
+
+// held is the last reported record of a throttled peripheral
+type held struct {
+	peripheral  peripherals.Peripheral
+	fingerprint string
+	reported    time.Time
+}
+
+// Throttle holds back the updates of the throttled peripherals between
+// reports
+type Throttle struct {
+	rules Rules
+	held  map[string]held
+}
+
+// New creates a throttle applying the rules
+func New(rules Rules) *Throttle {
+	return &Throttle{rules: rules, held: map[string]held{}}
+}
+
+// fingerprint sums up the changes always reported
+func fingerprint(p peripherals.Peripheral) string {
+	nodes := append(append([]string{p.DevicePath, p.VideoDevice}, p.VideoDevices...), p.SerialDevices...)
+	claimed := ""
+	if p.Claimed != nil {
+		claimed = fmt.Sprintf("%t:%s", *p.Claimed, p.ClaimedBy)
+	}
+	return fmt.Sprintf("%t|%s|%s|%t", p.Available, strings.Join(nodes, ","), claimed, p.Suspect != nil)
+}
+
+// Apply returns the report with the records of the throttled peripherals
+// replaced by their last reported version, when it is still due, and the
+// identifiers of the peripherals held back
+func (t *Throttle) Apply(now time.Time, report map[string]peripherals.Peripheral) (map[string]peripherals.Peripheral, []string) {
+	throttled := make(map[string]peripherals.Peripheral, len(report))
+	var heldBack []string
+
+	for identifier, peripheral := range report {
+		policy := t.rules.Policy(peripheral)
+		current := fingerprint(peripheral)
+		last, ok := t.held[identifier]
+
+		due := !ok || last.fingerprint != current || policy.frequency() == 0 ||
+			(!policy.OnChange && now.Sub(last.reported) >= policy.Interval)
+		if due {
+			t.held[identifier] = held{peripheral: peripheral, fingerprint: current, reported: now}
+			throttled[identifier] = peripheral
+			continue
+		}
+		throttled[identifier] = last.peripheral
+		heldBack = append(heldBack, identifier)
+	}
+
+	// Detached peripherals start over when attached again
+	for identifier := range t.held {
+		if _, ok := report[identifier]; !ok {
+			delete(t.held, identifier)
+		}
+	}
+	sort.Strings(heldBack)
+	return throttled, heldBack
+}
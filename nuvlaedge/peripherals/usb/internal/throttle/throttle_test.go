@@ -0,0 +1,87 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestParse(t *testing.T) {
+	rules, err := Parse([]string{"Mass Storage=1h", "video=on-change", "Human Interface Device=every-scan", "*=10m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules["mass storage"].Interval != time.Hour || !rules["video"].OnChange || rules["*"].Interval != 10*time.Minute {
+		t.Errorf("unexpected rules %+v", rules)
+	}
+	if p := rules["human interface device"]; p.OnChange || p.Interval != 0 {
+		t.Errorf("expected every scan for the HID class, got %s", p)
+	}
+
+	for _, invalid := range []string{"Video", "=1h", "Video=sometimes", "Video=-1m"} {
+		if _, err := Parse([]string{invalid}); err == nil {
+			t.Errorf("expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	rules, _ := Parse([]string{"Video=on-change", "Audio=5m", "*=1h"})
+
+	camera := peripherals.Peripheral{Classes: []string{"Video", "Audio"}}
+	if p := rules.Policy(camera); p.Interval != 5*time.Minute {
+		t.Errorf("expected the most frequent policy of the classes, got %s", p)
+	}
+	if p := rules.Policy(peripherals.Peripheral{Classes: []string{"VIDEO"}}); !p.OnChange {
+		t.Errorf("expected class names to be case-insensitive, got %s", p)
+	}
+	if p := rules.Policy(peripherals.Peripheral{Classes: []string{"Printer"}}); p.Interval != time.Hour {
+		t.Errorf("expected the default policy, got %s", p)
+	}
+	if p := (Rules{}).Policy(camera); p.String() != EveryScan {
+		t.Errorf("expected every scan without rules, got %s", p)
+	}
+}
+
+func TestApply(t *testing.T) {
+	rules, _ := Parse([]string{"Mass Storage=1h", "Video=on-change"})
+	throttle := New(rules)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	report := func(free string, cameraAvailable bool, sensor string) map[string]peripherals.Peripheral {
+		return map[string]peripherals.Peripheral{
+			"0781:5581": {Identifier: "0781:5581", Classes: []string{"Mass Storage"}, Available: true, Description: free},
+			"046d:0825": {Identifier: "046d:0825", Classes: []string{"Video"}, Available: cameraAvailable,
+				Description: free, DevicePath: "/dev/bus/usb/001/004"},
+			"1a86:7523": {Identifier: "1a86:7523", Classes: []string{"Vendor Specific Class"}, Available: true,
+				Description: sensor},
+		}
+	}
+
+	message, held := throttle.Apply(start, report("10GB free", true, "21C"))
+	if len(held) != 0 || message["0781:5581"].Description != "10GB free" {
+		t.Fatalf("expected the first report to pass, got %v held", held)
+	}
+
+	message, held = throttle.Apply(start.Add(time.Minute), report("9GB free", true, "22C"))
+	if len(held) != 2 || held[0] != "046d:0825" || held[1] != "0781:5581" {
+		t.Errorf("expected the storage and camera updates to be held back, got %v", held)
+	}
+	if message["0781:5581"].Description != "10GB free" || message["1a86:7523"].Description != "22C" {
+		t.Errorf("expected only the sensor to be updated, got %+v", message)
+	}
+
+	// Availability changes pass right away, and the storage refreshes once an hour
+	message, held = throttle.Apply(start.Add(time.Hour), report("8GB free", false, "23C"))
+	if len(held) != 0 || message["0781:5581"].Description != "8GB free" || message["046d:0825"].Available {
+		t.Errorf("expected every update to pass, got %v held", held)
+	}
+
+	// Detached peripherals are forgotten
+	delete(throttle.held, "1a86:7523")
+	_, _ = throttle.Apply(start.Add(2*time.Hour), map[string]peripherals.Peripheral{})
+	if len(throttle.held) != 0 {
+		t.Errorf("expected the detached peripherals to be forgotten, got %v", throttle.held)
+	}
+}
@@ -0,0 +1,246 @@
+// Package typec reads the USB-C ports of the host from the typec class of
+// sysfs: the roles negotiated with the partner plugged in, the alternate modes
+// it entered, as DisplayPort or Thunderbolt, and the Power Delivery
+// capabilities it offers and the contract in force. Docks and screens that do
+// not work on the kiosk edges are diagnosed from them.
+//
+//	/sys/class/typec/port0/data_role                          host [device]
+//	/sys/class/typec/port0-partner/port0-partner.0/svid       ff01
+//	/sys/class/typec/port0-partner/usb_power_delivery/source-capabilities/1:fixed_supply/voltage   5000mV
+package typec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the alternate modes, by standard or vendor ID
+var svids = map[string]string{
+	"ff01": "DisplayPort",
+	"8087": "Thunderbolt",
+}
+
+// AltMode is an alternate mode of the partner
+type AltMode struct {
+	SVID   string `json:"svid"`
+	Name   string `json:"name,omitempty"`
+	Mode   int    `json:"mode"`
+	Active bool   `json:"active"`
+}
+
+// PDO is a power supply the partner offers, in mV, mA and mW
+type PDO struct {
+	Type       string `json:"type"`
+	Voltage    int    `json:"voltage,omitempty"`
+	MinVoltage int    `json:"min-voltage,omitempty"`
+	MaxVoltage int    `json:"max-voltage,omitempty"`
+	MaxCurrent int    `json:"max-current,omitempty"`
+	MaxPower   int    `json:"max-power,omitempty"`
+}
+
+// Contract is the power drawn from the partner, in mV, mA and mW
+type Contract struct {
+	Voltage int `json:"voltage"`
+	Current int `json:"current"`
+	Power   int `json:"power"`
+}
+
+// Partner is the device plugged in a port
+type Partner struct {
+	SupportsPD    bool      `json:"supports-pd"`
+	PDRevision    string    `json:"pd-revision,omitempty"`
+	AccessoryMode string    `json:"accessory-mode,omitempty"`
+	AltModes      []AltMode `json:"alt-modes,omitempty"`
+	// SourceCapabilities are the supplies the partner offers to the host
+	SourceCapabilities []PDO `json:"source-capabilities,omitempty"`
+}
+
+// Port is a USB-C port of the host
+type Port struct {
+	Name               string    `json:"name"`
+	DataRole           string    `json:"data-role,omitempty"`
+	PowerRole          string    `json:"power-role,omitempty"`
+	PowerOperationMode string    `json:"power-operation-mode,omitempty"`
+	Orientation        string    `json:"orientation,omitempty"`
+	PDRevision         string    `json:"pd-revision,omitempty"`
+	Partner            *Partner  `json:"partner,omitempty"`
+	Contract           *Contract `json:"contract,omitempty"`
+}
+
+func readString(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// selected returns the choice in brackets of the role attributes, as host in
+// "[host] device", or the value of the fixed ones
+func selected(value string) string {
+	start, end := strings.Index(value, "["), strings.Index(value, "]")
+	if start < 0 || end < start {
+		return value
+	}
+	return value[start+1 : end]
+}
+
+// revision drops the revision of the ports and partners without PD, 0.0
+func revision(value string) string {
+	if value == "0.0" {
+		return ""
+	}
+	return value
+}
+
+// millis parses the attributes of the PD objects, as 5000mV
+func millis(path string) int {
+	value := strings.TrimRight(readString(path), "mVAW")
+	n, _ := strconv.Atoi(value)
+	return n
+}
+
+// Read returns the USB-C ports of class/typec under sysfsDir, empty when the
+// host has none or its kernel does not report them
+func Read(sysfsDir string) []Port {
+	classDir := filepath.Join(sysfsDir, "class", "typec")
+	entries, err := ioutil.ReadDir(classDir)
+	if err != nil {
+		return nil
+	}
+
+	var ports []Port
+	for _, entry := range entries {
+		// The partners, cables and plugs are listed next to the ports
+		name := entry.Name()
+		if !strings.HasPrefix(name, "port") || strings.Contains(name, "-") {
+			continue
+		}
+		dir := filepath.Join(classDir, name)
+		port := Port{
+			Name:               name,
+			DataRole:           selected(readString(filepath.Join(dir, "data_role"))),
+			PowerRole:          selected(readString(filepath.Join(dir, "power_role"))),
+			PowerOperationMode: readString(filepath.Join(dir, "power_operation_mode")),
+			Orientation:        readString(filepath.Join(dir, "orientation")),
+			PDRevision:         revision(readString(filepath.Join(dir, "usb_power_delivery_revision"))),
+		}
+		port.Partner = readPartner(filepath.Join(classDir, name+"-partner"))
+		if port.Partner != nil {
+			port.Contract = readContract(sysfsDir, name)
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+func readPartner(dir string) *Partner {
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	partner := &Partner{
+		SupportsPD:    readString(filepath.Join(dir, "supports_usb_power_delivery")) == "yes",
+		PDRevision:    revision(readString(filepath.Join(dir, "usb_power_delivery_revision"))),
+		AccessoryMode: readString(filepath.Join(dir, "accessory_mode")),
+	}
+	if partner.AccessoryMode == "none" {
+		partner.AccessoryMode = ""
+	}
+
+	// port0-partner.0, port0-partner.1...
+	modes, _ := filepath.Glob(filepath.Join(dir, filepath.Base(dir)+".*"))
+	for _, mode := range modes {
+		svid := strings.ToLower(readString(filepath.Join(mode, "svid")))
+		if len(svid) == 0 {
+			continue
+		}
+		number, _ := strconv.Atoi(readString(filepath.Join(mode, "mode")))
+		partner.AltModes = append(partner.AltModes, AltMode{SVID: svid, Name: svids[svid], Mode: number,
+			Active: readString(filepath.Join(mode, "active")) == "yes"})
+	}
+	sort.Slice(partner.AltModes, func(i, j int) bool {
+		if partner.AltModes[i].SVID != partner.AltModes[j].SVID {
+			return partner.AltModes[i].SVID < partner.AltModes[j].SVID
+		}
+		return partner.AltModes[i].Mode < partner.AltModes[j].Mode
+	})
+
+	partner.SourceCapabilities = readCapabilities(filepath.Join(dir, "usb_power_delivery", "source-capabilities"))
+	return partner
+}
+
+// readCapabilities reads the PD objects of a capabilities folder, named
+// after their position and type, as 1:fixed_supply
+func readCapabilities(dir string) []PDO {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	type positioned struct {
+		position int
+		pdo      PDO
+	}
+	var objects []positioned
+	for _, entry := range entries {
+		parts := strings.SplitN(entry.Name(), ":", 2)
+		position, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			continue
+		}
+		object := filepath.Join(dir, entry.Name())
+		pdo := PDO{Type: strings.TrimSuffix(parts[1], "_supply")}
+		switch parts[1] {
+		case "fixed_supply":
+			pdo.Voltage = millis(filepath.Join(object, "voltage"))
+			pdo.MaxCurrent = millis(filepath.Join(object, "maximum_current"))
+		case "variable_supply", "programmable_supply":
+			pdo.MinVoltage = millis(filepath.Join(object, "minimum_voltage"))
+			pdo.MaxVoltage = millis(filepath.Join(object, "maximum_voltage"))
+			pdo.MaxCurrent = millis(filepath.Join(object, "maximum_current"))
+		case "battery":
+			pdo.MinVoltage = millis(filepath.Join(object, "minimum_voltage"))
+			pdo.MaxVoltage = millis(filepath.Join(object, "maximum_voltage"))
+			pdo.MaxPower = millis(filepath.Join(object, "maximum_power"))
+		default:
+			continue
+		}
+		objects = append(objects, positioned{position, pdo})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].position < objects[j].position })
+
+	pdos := make([]PDO, 0, len(objects))
+	for _, object := range objects {
+		pdos = append(pdos, object.pdo)
+	}
+	return pdos
+}
+
+// readContract reads the power drawn through the port from the power supply
+// UCSI registers for its connector, named after the connector number, one
+// more than the one of the port: ucsi-source-psy-USBC000:001 for port0
+func readContract(sysfsDir string, port string) *Contract {
+	index, err := strconv.Atoi(strings.TrimPrefix(port, "port"))
+	if err != nil {
+		return nil
+	}
+	supplies, _ := filepath.Glob(filepath.Join(sysfsDir, "class", "power_supply", "ucsi-source-psy-*"))
+	for _, supply := range supplies {
+		if !strings.HasSuffix(filepath.Base(supply), strconv.Itoa(index+1)) || readString(filepath.Join(supply, "online")) != "1" {
+			continue
+		}
+		// µV and µA
+		voltage, _ := strconv.Atoi(readString(filepath.Join(supply, "voltage_now")))
+		current, _ := strconv.Atoi(readString(filepath.Join(supply, "current_max")))
+		if voltage <= 0 {
+			return nil
+		}
+		contract := &Contract{Voltage: voltage / 1000, Current: current / 1000}
+		contract.Power = contract.Voltage * contract.Current / 1000
+		return contract
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package typec
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func attributes(t *testing.T, dir string, values map[string]string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range values {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	sysfs := t.TempDir()
+	if ports := Read(sysfs); ports != nil {
+		t.Errorf("expected no port without the typec class, got %+v", ports)
+	}
+
+	class := filepath.Join(sysfs, "class", "typec")
+	attributes(t, filepath.Join(class, "port0"), map[string]string{
+		"data_role": "[host] device", "power_role": "source [sink]", "power_operation_mode": "usb_power_delivery",
+		"orientation": "reverse", "usb_power_delivery_revision": "3.0"})
+	attributes(t, filepath.Join(class, "port1"), map[string]string{
+		"data_role": "[host] device", "power_role": "[source] sink", "power_operation_mode": "default",
+		"usb_power_delivery_revision": "0.0"})
+	partner := filepath.Join(class, "port0-partner")
+	attributes(t, partner, map[string]string{"supports_usb_power_delivery": "yes",
+		"usb_power_delivery_revision": "3.0", "accessory_mode": "none"})
+	attributes(t, filepath.Join(partner, "port0-partner.1"), map[string]string{"svid": "8087", "mode": "1", "active": "no"})
+	attributes(t, filepath.Join(partner, "port0-partner.0"), map[string]string{"svid": "ff01", "mode": "1", "active": "yes"})
+	capabilities := filepath.Join(partner, "usb_power_delivery", "source-capabilities")
+	attributes(t, filepath.Join(capabilities, "2:fixed_supply"), map[string]string{"voltage": "20000mV", "maximum_current": "3250mA"})
+	attributes(t, filepath.Join(capabilities, "1:fixed_supply"), map[string]string{"voltage": "5000mV", "maximum_current": "3000mA"})
+	attributes(t, filepath.Join(capabilities, "3:programmable_supply"), map[string]string{
+		"minimum_voltage": "3300mV", "maximum_voltage": "21000mV", "maximum_current": "3000mA"})
+	attributes(t, filepath.Join(sysfs, "class", "power_supply", "ucsi-source-psy-USBC000:001"), map[string]string{
+		"online": "1", "voltage_now": "20000000", "current_max": "3250000"})
+	attributes(t, filepath.Join(sysfs, "class", "power_supply", "ucsi-source-psy-USBC000:002"), map[string]string{
+		"online": "0", "voltage_now": "0", "current_max": "0"})
+
+	expected := []Port{
+		{Name: "port0", DataRole: "host", PowerRole: "sink", PowerOperationMode: "usb_power_delivery",
+			Orientation: "reverse", PDRevision: "3.0",
+			Partner: &Partner{SupportsPD: true, PDRevision: "3.0",
+				AltModes: []AltMode{
+					{SVID: "8087", Name: "Thunderbolt", Mode: 1},
+					{SVID: "ff01", Name: "DisplayPort", Mode: 1, Active: true},
+				},
+				SourceCapabilities: []PDO{
+					{Type: "fixed", Voltage: 5000, MaxCurrent: 3000},
+					{Type: "fixed", Voltage: 20000, MaxCurrent: 3250},
+					{Type: "programmable", MinVoltage: 3300, MaxVoltage: 21000, MaxCurrent: 3000},
+				}},
+			Contract: &Contract{Voltage: 20000, Current: 3250, Power: 65000}},
+		{Name: "port1", DataRole: "host", PowerRole: "source", PowerOperationMode: "default"},
+	}
+	if ports := Read(sysfs); !reflect.DeepEqual(ports, expected) {
+		t.Errorf("Read = %+v\nexpected %+v", ports, expected)
+	}
+}
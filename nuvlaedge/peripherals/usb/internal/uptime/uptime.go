@@ -0,0 +1,128 @@
+// Package uptime tracks how long the peripherals stay attached, so flaky
+// hardware that keeps dropping off the bus stands out in the reports.
+//
+// The attachment history is kept in a JSON file, so it survives the restarts
+// of the manager:
+//
+//	{"046d:0825": {"attached-since": "2024-05-01T12:00:00Z", "attachments": 3, "uptime": 86400, "last-seen": "2024-05-02T08:00:00Z"}}
+//
+// A peripheral missing from a scan is detached. A peripheral not seen for
+// longer than the gap, as when the manager was stopped, starts a new
+// attachment from the scan it is seen again, its previous one ends when it was
+// last seen.
+package uptime
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// entry is the history of a peripheral in the file. Detached peripherals have
+// no attached-since.
+type entry struct {
+	AttachedSince string  `json:"attached-since,omitempty"`
+	Attachments   int     `json:"attachments"`
+	Uptime        float64 `json:"uptime"`
+	LastSeen      string  `json:"last-seen"`
+}
+
+// Tracker follows the attachments of the peripherals across scans
+type Tracker struct {
+	Path string
+	Gap  time.Duration
+
+	entries map[string]*entry
+}
+
+// Open loads the attachment history at path. A missing or unreadable file
+// starts an empty history.
+func Open(path string, gap time.Duration) (*Tracker, error) {
+	t := &Tracker{Path: path, Gap: gap, entries: map[string]*entry{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(data, &t.entries); err != nil {
+		t.entries = map[string]*entry{}
+		return t, err
+	}
+	return t, nil
+}
+
+func parseTime(value string) time.Time {
+	parsed, _ := time.Parse(time.RFC3339, value)
+	return parsed
+}
+
+// detach ends the attachment of an entry at its last sighting
+func (e *entry) detach() {
+	if len(e.AttachedSince) == 0 {
+		return
+	}
+	if attached := parseTime(e.LastSeen).Sub(parseTime(e.AttachedSince)); attached > 0 {
+		e.Uptime += attached.Seconds()
+	}
+	e.AttachedSince = ""
+}
+
+// Update records the peripherals of a scan made at now, sets their attachment
+// and saves the history. The peripherals missing from an incomplete scan, one
+// that failed to list all the devices, are not detached.
+func (t *Tracker) Update(now time.Time, discovered []peripherals.Peripheral, complete bool) error {
+	timestamp := now.UTC().Format(time.RFC3339)
+	seen := make(map[string]bool, len(discovered))
+
+	for i := range discovered {
+		peripheral := &discovered[i]
+		seen[peripheral.Identifier] = true
+
+		e, known := t.entries[peripheral.Identifier]
+		if !known {
+			e = &entry{}
+			t.entries[peripheral.Identifier] = e
+		}
+		if len(e.AttachedSince) > 0 && t.Gap > 0 && now.Sub(parseTime(e.LastSeen)) > t.Gap {
+			e.detach()
+		}
+		if len(e.AttachedSince) == 0 {
+			e.AttachedSince = timestamp
+			e.Attachments++
+		}
+		e.LastSeen = timestamp
+
+		peripheral.Attachment = &peripherals.Attachment{
+			AttachedSince: e.AttachedSince,
+			Attachments:   e.Attachments,
+			Uptime:        e.Uptime,
+		}
+	}
+
+	for identifier, e := range t.entries {
+		if complete && !seen[identifier] {
+			e.detach()
+		}
+	}
+	return t.save()
+}
+
+func (t *Tracker) save() error {
+	bData, err := json.Marshal(t.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.Path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := t.Path + ".tmp"
+	if err := os.WriteFile(tmp, bData, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.Path)
+}
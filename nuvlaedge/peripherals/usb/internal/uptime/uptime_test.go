@@ -0,0 +1,77 @@
+package uptime
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func scan(t *testing.T, tracker *Tracker, at time.Time, complete bool, identifiers ...string) map[string]*peripherals.Attachment {
+	var discovered []peripherals.Peripheral
+	for _, identifier := range identifiers {
+		discovered = append(discovered, peripherals.Peripheral{Identifier: identifier})
+	}
+	if err := tracker.Update(at, discovered, complete); err != nil {
+		t.Fatal(err)
+	}
+	attachments := map[string]*peripherals.Attachment{}
+	for _, peripheral := range discovered {
+		attachments[peripheral.Identifier] = peripheral.Attachment
+	}
+	return attachments
+}
+
+func TestTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usb", "uptime.json")
+	tracker, err := Open(path, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	attachments := scan(t, tracker, start, true, "046d:0825")
+	if a := attachments["046d:0825"]; a.AttachedSince != "2024-05-01T12:00:00Z" || a.Attachments != 1 || a.Uptime != 0 {
+		t.Errorf("unexpected first attachment %+v", a)
+	}
+	// The record stays the same while the peripheral is attached
+	attachments = scan(t, tracker, start.Add(time.Minute), true, "046d:0825")
+	if a := attachments["046d:0825"]; a.AttachedSince != "2024-05-01T12:00:00Z" || a.Attachments != 1 || a.Uptime != 0 {
+		t.Errorf("unexpected attachment %+v", a)
+	}
+
+	// Incomplete scans do not detach the peripherals they miss
+	scan(t, tracker, start.Add(2*time.Minute), false)
+	scan(t, tracker, start.Add(3*time.Minute), true)
+	attachments = scan(t, tracker, start.Add(4*time.Minute), true, "046d:0825")
+	if a := attachments["046d:0825"]; a.AttachedSince != "2024-05-01T12:04:00Z" || a.Attachments != 2 || a.Uptime != 60 {
+		t.Errorf("expected the uptime of the first attachment to be kept, got %+v", a)
+	}
+
+	// The history survives a restart, and the time the manager was down
+	// does not count as uptime
+	tracker, err = Open(path, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments = scan(t, tracker, start.Add(time.Hour), true, "046d:0825")
+	if a := attachments["046d:0825"]; a.AttachedSince != "2024-05-01T13:00:00Z" || a.Attachments != 3 || a.Uptime != 60 {
+		t.Errorf("expected a new attachment after the gap, got %+v", a)
+	}
+}
+
+func TestOpenInvalidHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uptime.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tracker, err := Open(path, 0)
+	if err == nil {
+		t.Error("expected an invalid history to be reported")
+	}
+	if a := scan(t, tracker, time.Now(), true, "046d:0825")["046d:0825"]; a.Attachments != 1 {
+		t.Errorf("expected a new history, got %+v", a)
+	}
+}
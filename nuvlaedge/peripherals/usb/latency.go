@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/latency"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+const UdevDataDir = "/run/udev/data/"
+
+// usbMajor is the major number of the USB device nodes, /dev/bus/usb/BBB/DDD
+const usbMajor = 189
+
+// acknowledgingSinks deliver the reports to a receiver answering them, Nuvla
+// or the agent, rather than writing them for the agent to read
+var acknowledgingSinks = map[string]bool{"rest": true}
+
+// ignoredSinks are the sinks whose deliveries do not make the peripherals
+// visible: the snapshot and cluster files are not read by the agent
+var ignoredSinks = map[string]bool{"snapshot": true, "cluster": true}
+
+// newLatencyTracker follows the attachments of the peripherals until they are
+// reported, and acknowledged when a sink does. Nil when disabled.
+func newLatencyTracker(cfg Config, dispatcher *sink.Dispatcher) *latency.Tracker {
+	if cfg.LatencySamples <= 0 {
+		return nil
+	}
+	acknowledging := false
+	for _, name := range cfg.Sinks {
+		acknowledging = acknowledging || acknowledgingSinks[name]
+	}
+	tracker := latency.New(cfg.LatencySamples, acknowledging)
+	dispatcher.OnDelivery(func(name string, report sink.Report, at time.Time) {
+		if ignoredSinks[name] {
+			return
+		}
+		identifiers := make([]string, 0, len(report.Peripherals))
+		for identifier := range report.Peripherals {
+			identifiers = append(identifiers, identifier)
+		}
+		tracker.Delivered(identifiers, at, acknowledgingSinks[name])
+	})
+	return tracker
+}
+
+// attachTimes returns when the discovered peripherals were attached, as
+// initialized by udev. The peripherals unknown to udev have a zero time.
+func attachTimes(discovered []peripherals.Peripheral, udevDir string, now time.Time) map[string]time.Time {
+	uptime, ok := systemUptime()
+	attached := make(map[string]time.Time, len(discovered))
+	for _, peripheral := range discovered {
+		var since time.Time
+		if ok {
+			since = udevAttachTime(udevDir, peripheral.DevicePath, now, uptime)
+		}
+		attached[peripheral.Identifier] = since
+	}
+	return attached
+}
+
+// udevAttachTime reads the initialization time of a USB device node from the
+// udev database, the I: line of its c189:<minor> entry, in microseconds of
+// the monotonic clock. The monotonic clock is converted with the system
+// uptime, which only differs from it after a suspend.
+func udevAttachTime(udevDir, devicePath string, now time.Time, uptime time.Duration) time.Time {
+	parts := strings.Split(strings.TrimPrefix(devicePath, "/dev/bus/usb/"), "/")
+	if len(parts) != 2 {
+		return time.Time{}
+	}
+	bus, errBus := strconv.Atoi(parts[0])
+	address, errAddress := strconv.Atoi(parts[1])
+	if errBus != nil || errAddress != nil || bus < 1 || address < 1 {
+		return time.Time{}
+	}
+
+	minor := (bus-1)*128 + address - 1
+	data, err := os.ReadFile(filepath.Join(udevDir, "c"+strconv.Itoa(usbMajor)+":"+strconv.Itoa(minor)))
+	if err != nil {
+		return time.Time{}
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.HasPrefix(line, "I:") {
+			continue
+		}
+		usec, err := strconv.ParseInt(strings.TrimPrefix(line, "I:"), 10, 64)
+		if err != nil {
+			return time.Time{}
+		}
+		return now.Add(-uptime + time.Duration(usec)*time.Microsecond)
+	}
+	return time.Time{}
+}
+
+// systemUptime reads the time since the system booted
+func systemUptime() (time.Duration, bool) {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		log.Debugf("Unable to parse the system uptime %q", fields[0])
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
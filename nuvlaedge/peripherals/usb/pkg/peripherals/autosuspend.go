@@ -0,0 +1,32 @@
+package peripherals
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Values of the power/control attribute of the USB devices in sysfs
+const (
+	AutosuspendEnabled  = "auto"
+	AutosuspendDisabled = "on"
+)
+
+// disableAutosuspend keeps the device powered, writing "on" to its
+// power/control attribute. Once attached again, the device gets the default
+// of the kernel back.
+func (d *Discoverer) disableAutosuspend(device Device) error {
+	for _, known := range d.sysfsUSBDevices() {
+		if known.Bus != device.Bus || known.Address != device.Address {
+			continue
+		}
+		f, err := os.OpenFile(filepath.Join(known.Dir, "power", "control"), os.O_WRONLY|os.O_TRUNC, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = f.WriteString(AutosuspendDisabled)
+		return err
+	}
+	return fmt.Errorf("device %03d/%03d is not in sysfs", device.Bus, device.Address)
+}
@@ -0,0 +1,205 @@
+package peripherals
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Speed is the signalling rate a device is connected at
+type Speed int
+
+const (
+	SpeedUnknown Speed = iota
+	SpeedLow
+	SpeedFull
+	SpeedHigh
+	SpeedSuper
+	SpeedSuperPlus
+)
+
+func (s Speed) String() string {
+	switch s {
+	case SpeedLow:
+		return "low"
+	case SpeedFull:
+		return "full"
+	case SpeedHigh:
+		return "high"
+	case SpeedSuper:
+		return "super"
+	case SpeedSuperPlus:
+		return "super-plus"
+	}
+	return "unknown"
+}
+
+// periodicCapacity is the share of a bus, in bytes per second, the host
+// controller lets isochronous and interrupt transfers reserve: 90% of the
+// frames at full speed, 80% of the microframes at high speed, and 90% of the
+// payload rate at super speeds
+func (s Speed) periodicCapacity() float64 {
+	switch s {
+	case SpeedLow, SpeedFull:
+		return 0.9 * 1500 * 1000
+	case SpeedHigh:
+		return 0.8 * 7500 * 8000
+	case SpeedSuper:
+		return 0.9 * 500e6
+	case SpeedSuperPlus:
+		return 0.9 * 1212e6
+	}
+	return 0
+}
+
+// Transfer types of an endpoint
+const (
+	TransferControl     = "control"
+	TransferIsochronous = "isochronous"
+	TransferBulk        = "bulk"
+	TransferInterrupt   = "interrupt"
+)
+
+// Endpoint is an endpoint of an interface setting
+type Endpoint struct {
+	Number   int
+	In       bool
+	Transfer string
+	// MaxPacketSize is the number of bytes the endpoint may move in each
+	// service interval, additional transactions included
+	MaxPacketSize int
+	Interval      time.Duration
+}
+
+// bytesPerSecond is the bandwidth an isochronous endpoint reserves
+func (e Endpoint) bytesPerSecond() float64 {
+	if e.Transfer != TransferIsochronous || e.Interval <= 0 {
+		return 0
+	}
+	return float64(e.MaxPacketSize) * float64(time.Second) / float64(e.Interval)
+}
+
+// IsochronousBandwidth is the bandwidth the isochronous interfaces of a device
+// reserve on its bus, with the lowest and the highest of their alternate
+// settings. Drivers such as uvcvideo pick the setting matching the requested
+// resolution and frame rate.
+type IsochronousBandwidth struct {
+	Bus   int    `json:"bus"`
+	Speed string `json:"speed"`
+	// Bytes per second
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+}
+
+// isochronousBandwidth sums, over the interfaces of the device, the bandwidth
+// of the lightest and of the heaviest alternate settings streaming data. It
+// returns nil for the devices without isochronous endpoint.
+func isochronousBandwidth(device Device) *IsochronousBandwidth {
+	type interval struct{ min, max float64 }
+	interfaces := map[int]*interval{}
+	for _, setting := range device.Interfaces {
+		var total float64
+		for _, endpoint := range setting.Endpoints {
+			total += endpoint.bytesPerSecond()
+		}
+		if total == 0 {
+			continue
+		}
+		current, exists := interfaces[setting.Number]
+		if !exists {
+			interfaces[setting.Number] = &interval{min: total, max: total}
+			continue
+		}
+		current.min = math.Min(current.min, total)
+		current.max = math.Max(current.max, total)
+	}
+	if len(interfaces) == 0 {
+		return nil
+	}
+
+	bandwidth := &IsochronousBandwidth{Bus: device.Bus, Speed: device.Speed.String()}
+	for _, i := range interfaces {
+		bandwidth.Min += int64(i.min)
+		bandwidth.Max += int64(i.max)
+	}
+	return bandwidth
+}
+
+// BusBandwidth is the estimated share of a bus the isochronous devices on it
+// reserve, as a percentage of its periodic capacity
+type BusBandwidth struct {
+	Bus   int    `json:"bus"`
+	Speed string `json:"speed"`
+	// Capacity is the periodic bandwidth of the bus, in bytes per second
+	Capacity int64 `json:"capacity"`
+	// Devices are the device paths of the isochronous devices
+	Devices        []string `json:"devices"`
+	MinUtilization float64  `json:"min-utilization"`
+	MaxUtilization float64  `json:"max-utilization"`
+	Warning        string   `json:"warning,omitempty"`
+}
+
+// EstimateBandwidth estimates the bandwidth utilization of the buses having
+// isochronous devices, such as cameras and microphones. The bus speed is the
+// one of its fastest device, its root hub. Devices slower than their bus,
+// behind a transaction translator, are charged their bytes at the bus rate.
+// The estimate ignores the interrupt endpoints and the protocol overhead, and
+// is meant to explain a camera failing to start, not to predict it.
+func EstimateBandwidth(devices []Device) []BusBandwidth {
+	speeds := map[int]Speed{}
+	for _, device := range devices {
+		if device.Speed > speeds[device.Bus] {
+			speeds[device.Bus] = device.Speed
+		}
+	}
+
+	buses := map[int]*BusBandwidth{}
+	usage := map[int]*[2]float64{}
+	for _, device := range devices {
+		bandwidth := isochronousBandwidth(device)
+		if bandwidth == nil {
+			continue
+		}
+		speed := speeds[device.Bus]
+		capacity := speed.periodicCapacity()
+		if capacity == 0 {
+			continue
+		}
+		bus, exists := buses[device.Bus]
+		if !exists {
+			bus = &BusBandwidth{Bus: device.Bus, Speed: speed.String(), Capacity: int64(capacity)}
+			buses[device.Bus] = bus
+			usage[device.Bus] = &[2]float64{}
+		}
+		bus.Devices = append(bus.Devices, device.DevicePath())
+
+		// Low speed devices take eight times their bytes of full speed time
+		cost := 1.0
+		if device.Speed == SpeedLow {
+			cost = 8
+		}
+		usage[device.Bus][0] += cost * float64(bandwidth.Min) / capacity * 100
+		usage[device.Bus][1] += cost * float64(bandwidth.Max) / capacity * 100
+	}
+
+	estimates := make([]BusBandwidth, 0, len(buses))
+	for number, bus := range buses {
+		sort.Strings(bus.Devices)
+		bus.MinUtilization = math.Round(usage[number][0]*10) / 10
+		bus.MaxUtilization = math.Round(usage[number][1]*10) / 10
+		switch {
+		case bus.MinUtilization > 100:
+			bus.Warning = fmt.Sprintf("The isochronous devices of bus %d cannot stream at the same time: "+
+				"even their lowest settings need %.0f%% of the bus. Move some of them to another controller",
+				bus.Bus, bus.MinUtilization)
+		case bus.MaxUtilization > 100:
+			bus.Warning = fmt.Sprintf("The isochronous devices of bus %d need %.0f%% of the bus at their highest settings: "+
+				"streams may fail to start, or have to use lower resolutions or frame rates",
+				bus.Bus, bus.MaxUtilization)
+		}
+		estimates = append(estimates, *bus)
+	}
+	sort.Slice(estimates, func(i, j int) bool { return estimates[i].Bus < estimates[j].Bus })
+	return estimates
+}
@@ -0,0 +1,58 @@
+package peripherals
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// camera is a high speed UVC camera whose streaming interface has a light and
+// a heavy alternate setting
+func camera(address int) Device {
+	streaming := func(alternate int, size int) InterfaceSetting {
+		return InterfaceSetting{
+			Number: 1, Alternate: alternate, Class: 0x0e, SubClass: 2,
+			Endpoints: []Endpoint{{Number: 1, In: true, Transfer: TransferIsochronous, MaxPacketSize: size, Interval: 125 * time.Microsecond}},
+		}
+	}
+	return Device{
+		Bus: 1, Address: address, VendorID: 0x046d, ProductID: 0x0825, Speed: SpeedHigh,
+		Interfaces: []InterfaceSetting{
+			{Number: 0, Class: 0x0e, SubClass: 1, Endpoints: []Endpoint{{Number: 7, In: true, Transfer: TransferInterrupt, MaxPacketSize: 16, Interval: time.Millisecond}}},
+			streaming(0, 0),
+			streaming(1, 192),
+			streaming(2, 3*1024),
+		},
+	}
+}
+
+func TestEstimateBandwidth(t *testing.T) {
+	hub := Device{Bus: 1, Address: 1, VendorID: 0x1d6b, ProductID: 0x0002, Speed: SpeedHigh, Classification: "Hub"}
+	storage := Device{Bus: 2, Address: 3, Speed: SpeedSuper, Interfaces: []InterfaceSetting{{Class: 0x08}}}
+
+	estimates := EstimateBandwidth([]Device{hub, camera(4), storage})
+	if len(estimates) != 1 {
+		t.Fatalf("expected only the bus of the camera, got %+v", estimates)
+	}
+	bus := estimates[0]
+	// 192 and 3072 bytes per microframe, out of 80% of 60 MB/s
+	if bus.Bus != 1 || bus.Speed != "high" || bus.MinUtilization != 3.2 || bus.MaxUtilization != 51.2 || bus.Warning != "" {
+		t.Errorf("expected a single camera to fit, got %+v", bus)
+	}
+
+	estimates = EstimateBandwidth([]Device{hub, camera(4), camera(5)})
+	bus = estimates[0]
+	if len(bus.Devices) != 2 || bus.MaxUtilization != 102.4 || !strings.Contains(bus.Warning, "highest settings") {
+		t.Errorf("expected two cameras at their highest settings to exceed the bus, got %+v", bus)
+	}
+}
+
+func TestIsochronousBandwidth(t *testing.T) {
+	if bandwidth := isochronousBandwidth(Device{Interfaces: []InterfaceSetting{{Class: 0x03}}}); bandwidth != nil {
+		t.Errorf("expected no bandwidth without isochronous endpoint, got %+v", bandwidth)
+	}
+	bandwidth := isochronousBandwidth(camera(4))
+	if bandwidth.Min != 192*8000 || bandwidth.Max != 3*1024*8000 {
+		t.Errorf("expected the lightest and heaviest settings, got %+v", bandwidth)
+	}
+}
@@ -0,0 +1,103 @@
+package peripherals
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Reasons the host relies on a device
+const (
+	CriticalBootDisk = "boot disk"
+	CriticalConsole  = "serial console"
+)
+
+// hostConsoles lists the ttys the host uses as consoles: the active kernel
+// consoles, the ones of the console= arguments of the kernel command line,
+// and the ones a getty serves logins on
+func (d *Discoverer) hostConsoles() map[string]bool {
+	consoles := map[string]bool{}
+	add := func(name string) {
+		name = strings.TrimPrefix(name, "/dev/")
+		if strings.HasPrefix(name, "tty") && name != "tty" {
+			consoles[name] = true
+		}
+	}
+
+	if active, err := readSysfsString(filepath.Join(d.sysfsDir, "class", "tty", "console", "active")); err == nil {
+		for _, name := range strings.Fields(active) {
+			add(name)
+		}
+	}
+	if cmdline, err := readSysfsString(filepath.Join(d.procDir, "cmdline")); err == nil {
+		for _, arg := range strings.Fields(cmdline) {
+			// console=ttyUSB0,115200n8
+			if strings.HasPrefix(arg, "console=") {
+				add(strings.SplitN(strings.TrimPrefix(arg, "console="), ",", 2)[0])
+			}
+		}
+	}
+
+	processes, err := ioutil.ReadDir(d.procDir)
+	if err != nil {
+		return consoles
+	}
+	for _, process := range processes {
+		if !process.IsDir() || strings.Trim(process.Name(), "0123456789") != "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(d.procDir, process.Name(), "cmdline"))
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		// agetty -L 115200 ttyUSB0 vt100
+		args := strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+		if !strings.Contains(filepath.Base(args[0]), "getty") {
+			continue
+		}
+		for _, arg := range args[1:] {
+			add(arg)
+		}
+	}
+	return consoles
+}
+
+// checkHostCritical flags the peripherals the host relies on, the disk it
+// booted from and its serial consoles, and reports them unavailable, so they
+// are neither handed to the edge applications nor touched by the remote
+// actions
+func (d *Discoverer) checkHostCritical(peripherals []Peripheral) {
+	var consoles map[string]bool
+	for i := range peripherals {
+		peripheral := &peripherals[i]
+		var reasons []string
+		for _, disk := range peripheral.Storage {
+			if disk.HoldsRoot {
+				reasons = append(reasons, CriticalBootDisk+" "+disk.DevicePath)
+			}
+		}
+		if len(peripheral.SerialDevices) > 0 && consoles == nil {
+			consoles = d.hostConsoles()
+		}
+		for _, node := range peripheral.SerialDevices {
+			if consoles[filepath.Base(node)] {
+				reasons = append(reasons, CriticalConsole+" "+node)
+			}
+		}
+
+		peripheral.HostCritical = strings.Join(reasons, ", ")
+		if len(reasons) > 0 {
+			peripheral.Available = false
+		}
+	}
+}
+
+// Protected reports whether the peripheral must be left alone, and why: the
+// host relies on it, or one of its disks is protected. Actions handing out or
+// writing to a peripheral must refuse the protected ones.
+func (p Peripheral) Protected() (string, bool) {
+	if len(p.HostCritical) > 0 {
+		return "the host relies on it as its " + p.HostCritical, true
+	}
+	return p.ProtectedStorage()
+}
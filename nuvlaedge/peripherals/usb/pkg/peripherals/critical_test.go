@@ -0,0 +1,65 @@
+package peripherals
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckHostCritical(t *testing.T) {
+	sysfs := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(sysfs, "class", "tty", "console"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(sysfs, "class", "tty", "console", "active"), "tty0 ttyUSB0\n")
+
+	procDir := t.TempDir()
+	writeFile(t, filepath.Join(procDir, "cmdline"), "console=ttyAMA0,115200 console=tty1 root=/dev/mmcblk0p2\n")
+	for pid, cmdline := range map[string]string{
+		"412": "/sbin/agetty\x00-L\x00115200\x00ttyACM1\x00vt100\x00",
+		"977": "/usr/bin/minicom\x00-D\x00/dev/ttyACM2\x00",
+	} {
+		if err := os.MkdirAll(filepath.Join(procDir, pid), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(procDir, pid, "cmdline"), cmdline)
+	}
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs), WithProcDir(procDir))
+	consoles := d.hostConsoles()
+	for _, name := range []string{"tty0", "ttyUSB0", "ttyAMA0", "tty1", "ttyACM1"} {
+		if !consoles[name] {
+			t.Errorf("%s is not a console of the host: %v", name, consoles)
+		}
+	}
+	if consoles["ttyACM2"] {
+		t.Error("The port opened by minicom is not a console")
+	}
+
+	found := []Peripheral{
+		{Identifier: "1a86:7523", Available: true, SerialDevices: []string{"/dev/ttyUSB0"}},
+		{Identifier: "2341:0043", Available: true, SerialDevices: []string{"/dev/ttyACM1"}},
+		{Identifier: "0403:6001", Available: true, SerialDevices: []string{"/dev/ttyACM2"}},
+		{Identifier: "0781:5581", Available: true, Storage: []BlockDevice{{DevicePath: "/dev/sda", HoldsRoot: true, Protected: true}}},
+		{Identifier: "0781:5583", Available: true, Storage: []BlockDevice{{DevicePath: "/dev/sdb", Protected: true}}},
+	}
+	d.checkHostCritical(found)
+
+	for i, expected := range []string{"serial console /dev/ttyUSB0", "serial console /dev/ttyACM1", "", "boot disk /dev/sda", ""} {
+		if found[i].HostCritical != expected || found[i].Available != (expected == "") {
+			t.Errorf("%s: host critical %q, available %t, expected %q", found[i].Identifier, found[i].HostCritical,
+				found[i].Available, expected)
+		}
+	}
+
+	if _, protected := found[2].Protected(); protected {
+		t.Error("An unused serial adapter is protected")
+	}
+	if reason, protected := found[0].Protected(); !protected || reason != "the host relies on it as its serial console /dev/ttyUSB0" {
+		t.Errorf("Protected() = %q, %t", reason, protected)
+	}
+	// Protected storage not holding the root filesystem stays available
+	if _, protected := found[4].Protected(); !protected {
+		t.Error("The mounted disk is not protected")
+	}
+}
@@ -0,0 +1,134 @@
+package peripherals
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Families of depth cameras
+const (
+	DepthRealSense = "realsense"
+	DepthZED       = "zed"
+	DepthKinect    = "kinect"
+)
+
+// Streams of the depth cameras
+const (
+	StreamDepth    = "depth"
+	StreamInfrared = "infrared"
+	StreamColor    = "color"
+	// StreamStereo is the side by side image of a pair of color sensors, the
+	// depth is computed by the host
+	StreamStereo = "stereo"
+	StreamIMU    = "imu"
+)
+
+// DepthCamera describes a depth camera, whose sensors enumerate as several
+// video nodes of the same device. The firmware is the one of the record.
+//
+//	"depth-camera": {"family": "realsense", "model": "RealSense D435i",
+//	                 "streams": ["depth", "infrared", "color", "imu"],
+//	                 "nodes": [{"device-path": "/dev/video0", "stream": "depth"},
+//	                           {"device-path": "/dev/video1", "metadata": true}, ...]}
+type DepthCamera struct {
+	Family  string      `json:"family"`
+	Model   string      `json:"model"`
+	Streams []string    `json:"streams"`
+	Nodes   []DepthNode `json:"nodes,omitempty"`
+}
+
+// DepthNode is a video node of a depth camera. Stream is only set when the
+// model tells which sensor the node carries.
+type DepthNode struct {
+	DevicePath string `json:"device-path"`
+	Stream     string `json:"stream,omitempty"`
+	// Metadata nodes carry the per frame metadata of the capture node before
+	// them
+	Metadata bool `json:"metadata,omitempty"`
+}
+
+// depthModel is a depth camera model. nodeStreams are the streams of its
+// capture nodes, in interface order, when the model has one per sensor.
+type depthModel struct {
+	family      string
+	model       string
+	streams     []string
+	nodeStreams []string
+}
+
+var (
+	realSenseStreams    = []string{StreamDepth, StreamInfrared, StreamColor}
+	realSenseIMUStreams = []string{StreamDepth, StreamInfrared, StreamColor, StreamIMU}
+	// The depth and infrared streams share the node of the stereo module
+	realSenseNodes = []string{StreamDepth, StreamColor}
+)
+
+// depthModels are the depth cameras, by identifier
+var depthModels = map[string]depthModel{
+	"8086:0ad3": {DepthRealSense, "RealSense D415", realSenseStreams, realSenseNodes},
+	"8086:0b07": {DepthRealSense, "RealSense D435", realSenseStreams, realSenseNodes},
+	"8086:0b3a": {DepthRealSense, "RealSense D435i", realSenseIMUStreams, realSenseNodes},
+	"8086:0b5c": {DepthRealSense, "RealSense D455", realSenseIMUStreams, realSenseNodes},
+	// The color image of the D405 comes from its stereo sensors
+	"8086:0b5b": {DepthRealSense, "RealSense D405", realSenseStreams, nil},
+	"8086:0b64": {DepthRealSense, "RealSense L515", realSenseIMUStreams, realSenseNodes},
+	"8086:0aa5": {DepthRealSense, "RealSense SR300", realSenseStreams, realSenseNodes},
+	"8086:0b48": {DepthRealSense, "RealSense SR305", realSenseStreams, realSenseNodes},
+	"2b03:f580": {DepthZED, "ZED", []string{StreamStereo}, nil},
+	"2b03:f682": {DepthZED, "ZED Mini", []string{StreamStereo}, nil},
+	"2b03:f780": {DepthZED, "ZED 2", []string{StreamStereo}, nil},
+	"2b03:f880": {DepthZED, "ZED 2i", []string{StreamStereo}, nil},
+	"045e:02ae": {DepthKinect, "Kinect for Xbox 360", []string{StreamDepth, StreamColor}, nil},
+	"045e:02bf": {DepthKinect, "Kinect for Windows", []string{StreamDepth, StreamColor}, nil},
+	// The Kinect v2 is driven from user space, by libfreenect2, without video
+	// node
+	"045e:02c4": {DepthKinect, "Kinect v2", []string{StreamDepth, StreamInfrared, StreamColor}, nil},
+}
+
+// videoNumber orders the video nodes by number, video10 after video9
+func videoNumber(name string) int {
+	number, err := strconv.Atoi(strings.TrimPrefix(name, "video"))
+	if err != nil {
+		return -1
+	}
+	return number
+}
+
+// probeDepthCamera reports the depth cameras with their streams, and gathers
+// all their video nodes in the record, the first capture node being the video
+// device
+func (d *Discoverer) probeDepthCamera(device Device, peripheral *Peripheral) {
+	model, ok := depthModels[device.Identifier()]
+	if !ok {
+		return
+	}
+	camera := &DepthCamera{Family: model.family, Model: model.model, Streams: model.streams}
+
+	nodes := d.classNodes("video4linux", device)
+	sort.Slice(nodes, func(i, j int) bool { return videoNumber(nodes[i].Name) < videoNumber(nodes[j].Name) })
+	var capture []string
+	for _, node := range nodes {
+		entry := DepthNode{DevicePath: d.devDir + node.Name}
+		// uvcvideo creates a metadata node, index 1, after the capture node
+		// of each streaming interface
+		if index, err := readSysfsInt(filepath.Join(node.Path, "index")); err == nil && index > 0 {
+			entry.Metadata = true
+		} else {
+			if len(capture) < len(model.nodeStreams) {
+				entry.Stream = model.nodeStreams[len(capture)]
+			}
+			capture = append(capture, entry.DevicePath)
+		}
+		camera.Nodes = append(camera.Nodes, entry)
+	}
+
+	peripheral.DepthCamera = camera
+	if len(capture) > 0 {
+		peripheral.VideoDevices = capture
+		if len(peripheral.VideoDevice) == 0 {
+			peripheral.VideoDevice = capture[0]
+		}
+	}
+}
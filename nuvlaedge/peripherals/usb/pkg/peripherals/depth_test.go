@@ -0,0 +1,56 @@
+package peripherals
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProbeDepthCamera(t *testing.T) {
+	sysfs := t.TempDir()
+	camera := fakeUSBDevice(t, sysfs, "2-1", "2", "3")
+	// The depth and color streaming interfaces, each with a metadata node
+	for _, node := range []struct {
+		iface string
+		name  string
+		index string
+	}{
+		{"2-1:1.0", "video2", "0"},
+		{"2-1:1.0", "video3", "1"},
+		{"2-1:1.3", "video4", "0"},
+		{"2-1:1.3", "video10", "1"},
+	} {
+		dir := fakeClassNode(t, sysfs, filepath.Join(camera, node.iface), "video4linux", node.name)
+		writeFile(t, filepath.Join(dir, "index"), node.index)
+	}
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs), WithDevDir("/dev/"))
+	peripheral := Peripheral{}
+	d.probeDepthCamera(Device{Bus: 2, Address: 3, VendorID: 0x8086, ProductID: 0x0b3a}, &peripheral)
+
+	expected := &DepthCamera{Family: DepthRealSense, Model: "RealSense D435i", Streams: realSenseIMUStreams,
+		Nodes: []DepthNode{
+			{DevicePath: "/dev/video2", Stream: StreamDepth},
+			{DevicePath: "/dev/video3", Metadata: true},
+			{DevicePath: "/dev/video4", Stream: StreamColor},
+			{DevicePath: "/dev/video10", Metadata: true},
+		}}
+	if !reflect.DeepEqual(peripheral.DepthCamera, expected) {
+		t.Errorf("Depth camera = %+v", peripheral.DepthCamera)
+	}
+	if peripheral.VideoDevice != "/dev/video2" || !reflect.DeepEqual(peripheral.VideoDevices, []string{"/dev/video2", "/dev/video4"}) {
+		t.Errorf("Video devices = %q %v", peripheral.VideoDevice, peripheral.VideoDevices)
+	}
+
+	// The Kinect v2 has no video node, the webcam is no depth camera
+	kinect := Peripheral{}
+	d.probeDepthCamera(Device{Bus: 1, Address: 7, VendorID: 0x045e, ProductID: 0x02c4}, &kinect)
+	if kinect.DepthCamera == nil || kinect.DepthCamera.Family != DepthKinect || len(kinect.VideoDevices) != 0 {
+		t.Errorf("Kinect = %+v", kinect)
+	}
+	webcam := Peripheral{}
+	d.probeDepthCamera(Device{Bus: 2, Address: 3, VendorID: 0x046d, ProductID: 0x0825}, &webcam)
+	if webcam.DepthCamera != nil {
+		t.Errorf("Webcam = %+v", webcam.DepthCamera)
+	}
+}
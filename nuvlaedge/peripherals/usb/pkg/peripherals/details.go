@@ -0,0 +1,323 @@
+package peripherals
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Details are the typed sections of the record for the main device classes,
+// so consumers can render them without knowing the probes behind them:
+//
+//	"details": {
+//	  "camera": {"video-devices": ["/dev/video0"], "formats": ["mjpeg", "yuy2"],
+//	             "resolutions": ["1280x720", "640x480"], "autofocus": false, "ptz": false, "depth": false},
+//	  "serial": {"devices": ["/dev/ttyUSB0"], "driver": "ch341", "baud-rates": [300, 600, ..., 2000000]}
+//	}
+//
+// Their JSON schema is given by DetailsSchema.
+type Details struct {
+	Camera  *CameraDetails  `json:"camera,omitempty"`
+	Storage *StorageDetails `json:"storage,omitempty"`
+	Serial  *SerialDetails  `json:"serial,omitempty"`
+	Sensor  *SensorDetails  `json:"sensor,omitempty"`
+}
+
+// CameraDetails describes a camera: its video nodes and the formats and
+// resolutions it streams, largest first
+type CameraDetails struct {
+	VideoDevices []string `json:"video-devices"`
+	Formats      []string `json:"formats"`
+	Resolutions  []string `json:"resolutions"`
+	Autofocus    bool     `json:"autofocus"`
+	PTZ          bool     `json:"ptz"`
+	Depth        bool     `json:"depth"`
+}
+
+// StorageDetails sums up the disks of a storage device
+type StorageDetails struct {
+	SizeBytes  int64 `json:"size-bytes"`
+	Disks      int   `json:"disks"`
+	Partitions int   `json:"partitions"`
+	Removable  bool  `json:"removable"`
+	ReadOnly   bool  `json:"read-only"`
+	Mounted    bool  `json:"mounted"`
+	Protected  bool  `json:"protected"`
+}
+
+// SerialDetails describes a serial adapter. The baud rates are the standard
+// ones its driver supports, unknown for the drivers taking any rate the device
+// accepts, as cdc_acm.
+type SerialDetails struct {
+	Devices   []string `json:"devices"`
+	Driver    string   `json:"driver,omitempty"`
+	BaudRates []int    `json:"baud-rates,omitempty"`
+}
+
+// SensorDetails describes a sensor: a HID sensor hub, the smart meter behind
+// a P1 cable or a GNSS receiver
+type SensorDetails struct {
+	Kind     string   `json:"kind"`
+	Devices  []string `json:"devices"`
+	Protocol string   `json:"protocol,omitempty"`
+}
+
+// Sensor kinds
+const (
+	SensorHID        = "hid-sensor"
+	SensorSmartMeter = "smart-meter"
+	SensorGNSS       = "gnss"
+)
+
+// standardBaudRates are the rates serial settings are usually chosen from
+var standardBaudRates = []int{300, 600, 1200, 2400, 4800, 9600, 19200, 38400, 57600, 115200,
+	230400, 460800, 921600, 1000000, 1500000, 2000000, 3000000, 4000000, 6000000}
+
+// serialDrivers are the highest baud rates of the USB to serial drivers
+var serialDrivers = map[string]int{
+	"ftdi_sio": 3000000,
+	"cp210x":   921600,
+	"ch341":    2000000,
+	"pl2303":   6000000,
+	"cdc_acm":  0,
+}
+
+// UVC VideoStreaming descriptor subtypes, from the USB Video Class 1.5
+// specification
+const (
+	subClassVideoStreaming = 0x02
+
+	vsFormatUncompressed = 0x04
+	vsFrameUncompressed  = 0x05
+	vsFormatMJPEG        = 0x06
+	vsFrameMJPEG         = 0x07
+	vsFormatFrameBased   = 0x10
+	vsFrameFrameBased    = 0x11
+)
+
+// uvcFormats are the formats and frame sizes a camera advertises in its
+// VideoStreaming interface descriptors
+type uvcFormats struct {
+	Formats     []string
+	Resolutions []string
+}
+
+// parseUVCFormats reads the stream formats and frame sizes from the raw
+// descriptors of a device. Formats are named after the FourCC of their GUID,
+// as yuy2 or h264, and resolutions are sorted by number of pixels.
+func parseUVCFormats(descriptors []byte) uvcFormats {
+	var formats uvcFormats
+	type size struct{ width, height int }
+	sizes := map[size]bool{}
+	inVideoStreaming := false
+
+	for len(descriptors) >= 2 {
+		length := int(descriptors[0])
+		if length < 2 || length > len(descriptors) {
+			break
+		}
+		descriptor := descriptors[:length]
+		descriptors = descriptors[length:]
+
+		switch descriptor[1] {
+		case descriptorTypeInterface:
+			inVideoStreaming = length >= 9 && descriptor[5] == ClassVideo && descriptor[6] == subClassVideoStreaming
+		case descriptorTypeCSInterface:
+			if !inVideoStreaming || length < 3 {
+				continue
+			}
+			switch descriptor[2] {
+			case vsFormatMJPEG:
+				formats.Formats = appendUnique(formats.Formats, "mjpeg")
+			case vsFormatUncompressed, vsFormatFrameBased:
+				if length >= 21 {
+					if name := fourCC(descriptor[5:9]); len(name) > 0 {
+						formats.Formats = appendUnique(formats.Formats, name)
+					}
+				}
+			case vsFrameUncompressed, vsFrameMJPEG, vsFrameFrameBased:
+				if length < 9 || len(formats.Formats) == 0 {
+					continue
+				}
+				width := int(descriptor[5]) | int(descriptor[6])<<8
+				height := int(descriptor[7]) | int(descriptor[8])<<8
+				if width > 0 && height > 0 {
+					sizes[size{width, height}] = true
+				}
+			}
+		}
+	}
+
+	ordered := make([]size, 0, len(sizes))
+	for s := range sizes {
+		ordered = append(ordered, s)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if pi, pj := ordered[i].width*ordered[i].height, ordered[j].width*ordered[j].height; pi != pj {
+			return pi > pj
+		}
+		return ordered[i].width > ordered[j].width
+	})
+	for _, s := range ordered {
+		formats.Resolutions = append(formats.Resolutions, fmt.Sprintf("%dx%d", s.width, s.height))
+	}
+	return formats
+}
+
+// fourCC returns the printable FourCC of a format GUID, lower case
+func fourCC(code []byte) string {
+	for _, c := range code {
+		if c < 0x20 || c > 0x7e {
+			return ""
+		}
+	}
+	return strings.ToLower(strings.TrimSpace(string(code)))
+}
+
+func appendUnique(list []string, value string) []string {
+	if contains(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
+// probeDetails sums up the records of the cameras, storage devices, serial
+// adapters and sensors in their typed sections. The stream formats are only
+// read from trusted descriptors.
+func (d *Discoverer) probeDetails(device Device, trusted bool, peripheral *Peripheral) {
+	var formats uvcFormats
+	if trusted && device.HasInterfaceClass(ClassVideo) {
+		if dir, ok := d.usbDeviceDir(device); ok {
+			path := filepath.Join(dir, "descriptors")
+			if descriptors, err := ioutil.ReadFile(path); err == nil {
+				formats = parseUVCFormats(descriptors)
+			} else {
+				logSysfsError(path, err)
+			}
+		}
+	}
+	peripheral.Details = classDetails(*peripheral, formats)
+}
+
+// classDetails builds the sections of the classes of a peripheral, nil when it
+// has none
+func classDetails(peripheral Peripheral, formats uvcFormats) *Details {
+	details := &Details{}
+
+	videoDevices := peripheral.VideoDevices
+	if len(videoDevices) == 0 && len(peripheral.VideoDevice) > 0 {
+		videoDevices = []string{peripheral.VideoDevice}
+	}
+	if len(videoDevices) > 0 || len(formats.Formats) > 0 || peripheral.UVC != nil {
+		details.Camera = &CameraDetails{
+			VideoDevices: stringList(videoDevices),
+			Formats:      stringList(formats.Formats),
+			Resolutions:  stringList(formats.Resolutions),
+			Depth:        peripheral.DepthCamera != nil,
+		}
+		if peripheral.UVC != nil {
+			details.Camera.Autofocus, details.Camera.PTZ = peripheral.UVC.Autofocus, peripheral.UVC.PTZ
+		}
+	}
+
+	if len(peripheral.Storage) > 0 {
+		storage := &StorageDetails{Disks: len(peripheral.Storage)}
+		for _, disk := range peripheral.Storage {
+			storage.SizeBytes += disk.Size
+			storage.Partitions += len(disk.Partitions)
+			storage.Removable = storage.Removable || disk.Removable
+			storage.ReadOnly = storage.ReadOnly || disk.ReadOnly
+			storage.Mounted = storage.Mounted || len(disk.Mountpoints) > 0
+			storage.Protected = storage.Protected || disk.Protected
+		}
+		details.Storage = storage
+	}
+
+	if len(peripheral.SerialDevices) > 0 {
+		serial := &SerialDetails{Devices: peripheral.SerialDevices}
+		for _, driver := range peripheral.Drivers {
+			if highest, known := serialDrivers[driver.Driver]; known {
+				serial.Driver = driver.Driver
+				for _, rate := range standardBaudRates {
+					if rate <= highest {
+						serial.BaudRates = append(serial.BaudRates, rate)
+					}
+				}
+				break
+			}
+		}
+		details.Serial = serial
+	}
+
+	if meter := peripheral.SmartMeter; meter != nil {
+		details.Sensor = &SensorDetails{Kind: SensorSmartMeter, Devices: []string{meter.SerialDevice}}
+		if len(meter.DSMRVersion) > 0 {
+			details.Sensor.Protocol = "dsmr-" + meter.DSMRVersion
+		}
+	} else if gnss := peripheral.GNSS; gnss != nil {
+		details.Sensor = &SensorDetails{Kind: SensorGNSS, Devices: []string{gnss.SerialDevice}, Protocol: "ubx"}
+	} else {
+		var devices []string
+		for _, hid := range peripheral.HID {
+			for _, t := range hid.Types {
+				if t == HIDSensor {
+					devices = append(devices, hid.DevicePath)
+					break
+				}
+			}
+		}
+		if len(devices) > 0 {
+			details.Sensor = &SensorDetails{Kind: SensorHID, Devices: devices, Protocol: "hid"}
+		}
+	}
+
+	if *details == (Details{}) {
+		return nil
+	}
+	return details
+}
+
+// DetailsSchema returns the JSON schema of the details section, derived from
+// its types
+func DetailsSchema() map[string]interface{} {
+	schema := jsonSchema(reflect.TypeOf(Details{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Peripheral class details"
+	return schema
+}
+
+// jsonSchema describes a type with the JSON schema keywords. The struct fields
+// without omitempty are required.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("json"), ",")
+			if len(tag[0]) == 0 || tag[0] == "-" {
+				continue
+			}
+			properties[tag[0]] = jsonSchema(t.Field(i).Type)
+			if len(tag) == 1 {
+				required = append(required, tag[0])
+			}
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "required": required,
+			"additionalProperties": false}
+	}
+	return map[string]interface{}{}
+}
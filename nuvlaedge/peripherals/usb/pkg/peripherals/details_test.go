@@ -0,0 +1,114 @@
+package peripherals
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// uvcFrame is a frame descriptor of the given size, with one frame interval
+func uvcFrame(subtype byte, width int, height int) []byte {
+	frame := make([]byte, 30)
+	frame[0], frame[1], frame[2] = 30, descriptorTypeCSInterface, subtype
+	frame[5], frame[6] = byte(width), byte(width>>8)
+	frame[7], frame[8] = byte(height), byte(height>>8)
+	return frame
+}
+
+func streamingDescriptors() []byte {
+	var descriptors []byte
+	descriptors = append(descriptors, c270Descriptors[:18+9]...)
+	// VideoStreaming interface
+	descriptors = append(descriptors, 9, 0x04, 0x01, 0x00, 0x01, 0x0e, 0x02, 0x00, 0x00)
+	// YUY2 uncompressed format
+	descriptors = append(descriptors, 27, 0x24, vsFormatUncompressed, 1, 2,
+		'Y', 'U', 'Y', '2', 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71,
+		16, 1, 0, 0, 0, 0)
+	descriptors = append(descriptors, uvcFrame(vsFrameUncompressed, 640, 480)...)
+	descriptors = append(descriptors, uvcFrame(vsFrameUncompressed, 1280, 720)...)
+	// MJPEG format
+	descriptors = append(descriptors, 11, 0x24, vsFormatMJPEG, 2, 2, 0, 1, 0, 0, 0, 0)
+	descriptors = append(descriptors, uvcFrame(vsFrameMJPEG, 1920, 1080)...)
+	descriptors = append(descriptors, uvcFrame(vsFrameMJPEG, 640, 480)...)
+	return descriptors
+}
+
+func TestParseUVCFormats(t *testing.T) {
+	expected := uvcFormats{Formats: []string{"yuy2", "mjpeg"}, Resolutions: []string{"1920x1080", "1280x720", "640x480"}}
+	if formats := parseUVCFormats(streamingDescriptors()); !reflect.DeepEqual(formats, expected) {
+		t.Errorf("expected formats %+v, got %+v", expected, formats)
+	}
+
+	// The frames of the C270 excerpt follow no format
+	if formats := parseUVCFormats(c270Descriptors); formats.Formats != nil || formats.Resolutions != nil {
+		t.Errorf("expected no formats, got %+v", formats)
+	}
+}
+
+func TestClassDetails(t *testing.T) {
+	if details := classDetails(Peripheral{Identifier: "1050:0407"}, uvcFormats{}); details != nil {
+		t.Errorf("expected no details, got %+v", details)
+	}
+
+	camera := Peripheral{VideoDevice: "/dev/video0", UVC: &UVC{Autofocus: true}}
+	details := classDetails(camera, uvcFormats{Formats: []string{"mjpeg"}, Resolutions: []string{"1280x720"}})
+	expected := &CameraDetails{VideoDevices: []string{"/dev/video0"}, Formats: []string{"mjpeg"},
+		Resolutions: []string{"1280x720"}, Autofocus: true}
+	if details == nil || !reflect.DeepEqual(details.Camera, expected) {
+		t.Errorf("expected camera details %+v, got %+v", expected, details)
+	}
+
+	disk := Peripheral{Storage: []BlockDevice{
+		{DevicePath: "/dev/sda", Size: 32000000000, Removable: true, Partitions: []string{"/dev/sda1", "/dev/sda2"},
+			Mountpoints: []string{"/media/usb"}},
+		{DevicePath: "/dev/sdb", Size: 1000000000, ReadOnly: true},
+	}}
+	if details := classDetails(disk, uvcFormats{}); details == nil || *details.Storage != (StorageDetails{
+		SizeBytes: 33000000000, Disks: 2, Partitions: 2, Removable: true, ReadOnly: true, Mounted: true}) {
+		t.Errorf("unexpected storage details %+v", details)
+	}
+
+	adapter := Peripheral{SerialDevices: []string{"/dev/ttyUSB0"}, Drivers: []InterfaceDriver{{Driver: "cp210x"}}}
+	details = classDetails(adapter, uvcFormats{})
+	if details == nil || details.Serial.Driver != "cp210x" || len(details.Serial.BaudRates) != 13 ||
+		details.Serial.BaudRates[12] != 921600 {
+		t.Errorf("unexpected serial details %+v", details)
+	}
+	adapter.Drivers = []InterfaceDriver{{Driver: "cdc_acm"}}
+	if details := classDetails(adapter, uvcFormats{}); details.Serial.Driver != "cdc_acm" || details.Serial.BaudRates != nil {
+		t.Errorf("expected no baud rates for cdc_acm, got %+v", details.Serial)
+	}
+
+	meter := Peripheral{SerialDevices: []string{"/dev/ttyUSB0"},
+		SmartMeter: &SmartMeter{SerialDevice: "/dev/ttyUSB0", DSMRVersion: "5.0"}}
+	if details := classDetails(meter, uvcFormats{}); details.Sensor == nil || len(details.Sensor.Devices) != 1 ||
+		details.Sensor.Kind != SensorSmartMeter || details.Sensor.Protocol != "dsmr-5.0" {
+		t.Errorf("unexpected sensor details %+v", details.Sensor)
+	}
+	hub := Peripheral{HID: []HIDInterface{{DevicePath: "/dev/hidraw0", Types: []HIDType{HIDSensor}},
+		{DevicePath: "/dev/hidraw1", Types: []HIDType{HIDKeyboard}}}}
+	if details := classDetails(hub, uvcFormats{}); details.Sensor == nil || details.Sensor.Kind != SensorHID ||
+		!reflect.DeepEqual(details.Sensor.Devices, []string{"/dev/hidraw0"}) {
+		t.Errorf("unexpected sensor details %+v", details)
+	}
+}
+
+func TestDetailsSchema(t *testing.T) {
+	schema := DetailsSchema()
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	camera := schema["properties"].(map[string]interface{})["camera"].(map[string]interface{})
+	resolutions := camera["properties"].(map[string]interface{})["resolutions"].(map[string]interface{})
+	if resolutions["type"] != "array" || !reflect.DeepEqual(resolutions["items"], map[string]interface{}{"type": "string"}) {
+		t.Errorf("unexpected resolutions schema %v", resolutions)
+	}
+	if !reflect.DeepEqual(camera["required"], []string{"video-devices", "formats", "resolutions", "autofocus", "ptz", "depth"}) {
+		t.Errorf("unexpected required camera properties %v", camera["required"])
+	}
+	serial := schema["properties"].(map[string]interface{})["serial"].(map[string]interface{})
+	if !reflect.DeepEqual(serial["required"], []string{"devices"}) {
+		t.Errorf("unexpected required serial properties %v", serial["required"])
+	}
+}
@@ -0,0 +1,284 @@
+// Package dfu flashes firmware images on USB devices running a Device
+// Firmware Upgrade bootloader, following the DFU 1.1 specification.
+//
+// The package only issues control transfers, through the Device interface, so
+// it does not depend on libusb.
+package dfu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DFU class requests
+const (
+	requestDownload  uint8 = 1
+	requestGetStatus uint8 = 3
+	requestClrStatus uint8 = 4
+	requestAbort     uint8 = 6
+)
+
+// bmRequestType of the class requests sent to the DFU interface
+const (
+	requestOut uint8 = 0x21
+	requestIn  uint8 = 0xa1
+)
+
+// Device states reported by DFU_GETSTATUS
+const (
+	StateAppIdle           uint8 = 0
+	StateAppDetach         uint8 = 1
+	StateIdle              uint8 = 2
+	StateDownloadSync      uint8 = 3
+	StateDownloadBusy      uint8 = 4
+	StateDownloadIdle      uint8 = 5
+	StateManifestSync      uint8 = 6
+	StateManifest          uint8 = 7
+	StateManifestWaitReset uint8 = 8
+	StateUploadIdle        uint8 = 9
+	StateError             uint8 = 10
+)
+
+// Descriptor types
+const (
+	descriptorTypeConfig     uint8 = 0x02
+	descriptorTypeInterface  uint8 = 0x04
+	descriptorTypeFunctional uint8 = 0x21
+)
+
+// Attributes of the DFU functional descriptor
+const (
+	AttrCanDownload           uint8 = 0x01
+	AttrManifestationTolerant uint8 = 0x04
+)
+
+const defaultTransferSize = 1024
+
+// Device sends control transfers to a USB device. gousb devices implement it.
+type Device interface {
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+	Close() error
+}
+
+// Opener opens the device at the given bus and address
+type Opener func(bus int, address int) (Device, error)
+
+// Interface is the DFU interface of a device, with the capabilities of its
+// functional descriptor
+type Interface struct {
+	Number       uint8
+	Protocol     uint8
+	Attributes   uint8
+	TransferSize int
+}
+
+// Status is the answer to DFU_GETSTATUS
+type Status struct {
+	Status      uint8
+	PollTimeout time.Duration
+	State       uint8
+}
+
+// ReadConfigDescriptor reads the full active configuration descriptor, with
+// the interface and functional descriptors it holds
+func ReadConfigDescriptor(dev Device) ([]byte, error) {
+	header := make([]byte, 9)
+	if _, err := dev.Control(0x80, 0x06, uint16(descriptorTypeConfig)<<8, 0, header); err != nil {
+		return nil, fmt.Errorf("unable to read the configuration descriptor: %w", err)
+	}
+	total := int(binary.LittleEndian.Uint16(header[2:4]))
+	if total < len(header) {
+		return nil, errors.New("invalid configuration descriptor length")
+	}
+
+	config := make([]byte, total)
+	n, err := dev.Control(0x80, 0x06, uint16(descriptorTypeConfig)<<8, 0, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the configuration descriptor: %w", err)
+	}
+	return config[:n], nil
+}
+
+// FindInterface looks for the DFU interface in a configuration descriptor
+func FindInterface(config []byte) (Interface, error) {
+	var found *Interface
+	for len(config) >= 2 {
+		length := int(config[0])
+		if length < 2 || length > len(config) {
+			break
+		}
+		descriptor := config[:length]
+		config = config[length:]
+
+		switch descriptor[1] {
+		case descriptorTypeInterface:
+			if found != nil {
+				// the functional descriptor follows the DFU interface
+				return *found, nil
+			}
+			if length >= 9 && descriptor[5] == 0xfe && descriptor[6] == 0x01 {
+				found = &Interface{Number: descriptor[2], Protocol: descriptor[7], TransferSize: defaultTransferSize}
+			}
+		case descriptorTypeFunctional:
+			if found != nil && length >= 7 {
+				found.Attributes = descriptor[2]
+				if size := int(binary.LittleEndian.Uint16(descriptor[5:7])); size > 0 {
+					found.TransferSize = size
+				}
+				return *found, nil
+			}
+		}
+	}
+	if found != nil {
+		return *found, nil
+	}
+	return Interface{}, errors.New("device has no DFU interface")
+}
+
+// GetStatus reads the status of the DFU interface
+func GetStatus(dev Device, intf Interface) (Status, error) {
+	data := make([]byte, 6)
+	n, err := dev.Control(requestIn, requestGetStatus, 0, uint16(intf.Number), data)
+	if err != nil {
+		return Status{}, err
+	}
+	if n < 6 {
+		return Status{}, fmt.Errorf("short DFU status of %d bytes", n)
+	}
+	timeout := uint32(data[1]) | uint32(data[2])<<8 | uint32(data[3])<<16
+	return Status{
+		Status:      data[0],
+		PollTimeout: time.Duration(timeout) * time.Millisecond,
+		State:       data[4],
+	}, nil
+}
+
+// Download flashes image on the device, which must be in DFU mode. progress,
+// if set, is called after each block with the bytes sent so far.
+func Download(ctx context.Context, dev Device, intf Interface, image []byte, progress func(sent int, total int)) error {
+	if intf.Attributes != 0 && intf.Attributes&AttrCanDownload == 0 {
+		return errors.New("device does not accept downloads")
+	}
+	if err := ensureIdle(dev, intf); err != nil {
+		return err
+	}
+
+	size := intf.TransferSize
+	if size <= 0 {
+		size = defaultTransferSize
+	}
+
+	var block uint16
+	for sent := 0; sent < len(image); block++ {
+		end := sent + size
+		if end > len(image) {
+			end = len(image)
+		}
+		if _, err := dev.Control(requestOut, requestDownload, block, uint16(intf.Number), image[sent:end]); err != nil {
+			return fmt.Errorf("unable to send block %d: %w", block, err)
+		}
+		if _, err := waitState(ctx, dev, intf, StateDownloadIdle); err != nil {
+			return fmt.Errorf("block %d rejected: %w", block, err)
+		}
+		sent = end
+		if progress != nil {
+			progress(sent, len(image))
+		}
+	}
+
+	// a zero length download starts the manifestation phase
+	if _, err := dev.Control(requestOut, requestDownload, block, uint16(intf.Number), nil); err != nil {
+		return fmt.Errorf("unable to end the download: %w", err)
+	}
+	return waitManifest(ctx, dev, intf)
+}
+
+// ensureIdle brings the interface back to dfuIDLE after a failed or aborted
+// previous session
+func ensureIdle(dev Device, intf Interface) error {
+	status, err := GetStatus(dev, intf)
+	if err != nil {
+		return fmt.Errorf("unable to read the DFU status: %w", err)
+	}
+
+	switch status.State {
+	case StateIdle:
+		return nil
+	case StateAppIdle, StateAppDetach:
+		return errors.New("device runs its application, it must be switched to DFU mode first")
+	case StateError:
+		if _, err := dev.Control(requestOut, requestClrStatus, 0, uint16(intf.Number), nil); err != nil {
+			return err
+		}
+	default:
+		if _, err := dev.Control(requestOut, requestAbort, 0, uint16(intf.Number), nil); err != nil {
+			return err
+		}
+	}
+
+	if status, err = GetStatus(dev, intf); err != nil {
+		return err
+	}
+	if status.State != StateIdle {
+		return fmt.Errorf("device stuck in DFU state %d", status.State)
+	}
+	return nil
+}
+
+// waitState polls the status until the device reaches the wanted state,
+// waiting between polls as long as the device asks to
+func waitState(ctx context.Context, dev Device, intf Interface, want uint8) (Status, error) {
+	for {
+		status, err := GetStatus(dev, intf)
+		if err != nil {
+			return status, err
+		}
+		if status.Status != 0 || status.State == StateError {
+			return status, fmt.Errorf("device reported status %d", status.Status)
+		}
+		if status.State == want {
+			return status, nil
+		}
+		if err := sleep(ctx, status.PollTimeout); err != nil {
+			return status, err
+		}
+	}
+}
+
+// waitManifest waits for the device to write the new firmware. Devices that
+// are not manifestation tolerant reset at the end, which the host sees as a
+// failed transfer.
+func waitManifest(ctx context.Context, dev Device, intf Interface) error {
+	for {
+		status, err := GetStatus(dev, intf)
+		if err != nil {
+			if intf.Attributes&AttrManifestationTolerant == 0 {
+				return nil
+			}
+			return err
+		}
+		if status.Status != 0 || status.State == StateError {
+			return fmt.Errorf("manifestation failed with status %d", status.Status)
+		}
+		if status.State == StateIdle || status.State == StateManifestWaitReset {
+			return nil
+		}
+		if err := sleep(ctx, status.PollTimeout); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,132 @@
+package dfu
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+// fakeDevice emulates a DFU bootloader that stores the downloaded blocks
+type fakeDevice struct {
+	state    uint8
+	received bytes.Buffer
+	blocks   []uint16
+	reset    bool
+}
+
+func (f *fakeDevice) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	if f.reset {
+		return 0, errors.New("no such device")
+	}
+	switch request {
+	case requestGetStatus:
+		copy(data, []byte{0, 0, 0, 0, f.state, 0})
+		switch f.state {
+		case StateDownloadSync:
+			f.state = StateDownloadIdle
+		case StateManifestSync:
+			f.state = StateManifestWaitReset
+			f.reset = true
+		}
+		return 6, nil
+	case requestClrStatus, requestAbort:
+		f.state = StateIdle
+	case requestDownload:
+		if len(data) == 0 {
+			f.state = StateManifestSync
+			return 0, nil
+		}
+		f.blocks = append(f.blocks, val)
+		f.received.Write(data)
+		f.state = StateDownloadSync
+		return len(data), nil
+	}
+	return 0, nil
+}
+
+func (f *fakeDevice) Close() error { return nil }
+
+func TestFindInterface(t *testing.T) {
+	config := []byte{
+		9, 0x02, 27, 0, 1, 1, 0, 0x80, 50,
+		9, 0x04, 0, 0, 0, 0xfe, 0x01, 0x02, 0,
+		9, 0x21, 0x0b, 0xff, 0x00, 0x00, 0x08, 0x1a, 0x01,
+	}
+	intf, err := FindInterface(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intf.Protocol != 2 || intf.TransferSize != 2048 || intf.Attributes != 0x0b {
+		t.Errorf("unexpected DFU interface %+v", intf)
+	}
+
+	if _, err := FindInterface(config[:9]); err == nil {
+		t.Error("expected configuration without DFU interface to fail")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	dev := &fakeDevice{state: StateError}
+	image := bytes.Repeat([]byte{0xaa}, 2500)
+	intf := Interface{Number: 0, Attributes: AttrCanDownload, TransferSize: 1024}
+
+	var reported []int
+	err := Download(context.Background(), dev, intf, image, func(sent int, total int) {
+		reported = append(reported, sent)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dev.received.Bytes(), image) {
+		t.Error("device did not receive the image")
+	}
+	if len(dev.blocks) != 3 || dev.blocks[2] != 2 {
+		t.Errorf("expected blocks 0 to 2, got %v", dev.blocks)
+	}
+	if len(reported) != 3 || reported[2] != 2500 {
+		t.Errorf("unexpected progress %v", reported)
+	}
+}
+
+func TestDownloadApplicationMode(t *testing.T) {
+	dev := &fakeDevice{state: StateAppIdle}
+	if err := Download(context.Background(), dev, Interface{}, []byte{1}, nil); err == nil {
+		t.Error("expected download to a device running its application to fail")
+	}
+}
+
+func TestSplitSuffix(t *testing.T) {
+	firmware := []byte("firmware")
+	file := append([]byte{}, firmware...)
+	suffix := make([]byte, 12)
+	binary.LittleEndian.PutUint16(suffix[0:], 0xffff)
+	binary.LittleEndian.PutUint16(suffix[2:], 0xdf11)
+	binary.LittleEndian.PutUint16(suffix[4:], 0x0483)
+	binary.LittleEndian.PutUint16(suffix[6:], 0x011a)
+	copy(suffix[8:], "UFD")
+	suffix[11] = 16
+	file = append(file, suffix...)
+	crc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crc, ^crc32.ChecksumIEEE(file))
+	file = append(file, crc...)
+
+	payload, s, err := SplitSuffix(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(payload, firmware) || s == nil || !s.Matches(0x0483, 0xdf11) || s.Matches(0x1209, 0xdf11) {
+		t.Errorf("unexpected payload %q and suffix %+v", payload, s)
+	}
+
+	file[0] ^= 0xff
+	if _, _, err := SplitSuffix(file); err == nil {
+		t.Error("expected corrupted file to fail")
+	}
+
+	if payload, s, _ := SplitSuffix(firmware); s != nil || !bytes.Equal(payload, firmware) {
+		t.Error("expected raw image to be returned as is")
+	}
+}
@@ -0,0 +1,92 @@
+package dfu
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const suffixLength = 16
+
+// Suffix is the DFU file suffix appended to firmware images by the vendor
+// tools. A 0xffff field matches any device.
+type Suffix struct {
+	Revision  uint16
+	ProductID uint16
+	VendorID  uint16
+}
+
+// Matches reports whether the image is meant for the given device
+func (s Suffix) Matches(vendorID uint16, productID uint16) bool {
+	return (s.VendorID == 0xffff || s.VendorID == vendorID) &&
+		(s.ProductID == 0xffff || s.ProductID == productID)
+}
+
+// SplitSuffix returns the firmware of a DFU file without its suffix. Images
+// without a suffix are returned as they are, with a nil suffix.
+func SplitSuffix(image []byte) ([]byte, *Suffix, error) {
+	if len(image) < suffixLength {
+		return image, nil, nil
+	}
+	suffix := image[len(image)-suffixLength:]
+	if string(suffix[8:11]) != "UFD" || suffix[11] != suffixLength {
+		return image, nil, nil
+	}
+
+	// the CRC covers the whole file but its own field, and is stored inverted
+	crc := ^crc32.ChecksumIEEE(image[:len(image)-4])
+	if crc != binary.LittleEndian.Uint32(suffix[12:16]) {
+		return nil, nil, errors.New("DFU suffix CRC mismatch")
+	}
+
+	return image[:len(image)-suffixLength], &Suffix{
+		Revision:  binary.LittleEndian.Uint16(suffix[0:2]),
+		ProductID: binary.LittleEndian.Uint16(suffix[2:4]),
+		VendorID:  binary.LittleEndian.Uint16(suffix[4:6]),
+	}, nil
+}
+
+// Fetch downloads a firmware image of at most maxSize bytes. When checksum is
+// set, the image must have that SHA-256 digest.
+func Fetch(ctx context.Context, client *http.Client, url string, checksum string, maxSize int64) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("unsupported image URL %q", url)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image download failed with status %s", resp.Status)
+	}
+
+	image, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(image)) > maxSize {
+		return nil, fmt.Errorf("image is larger than %d bytes", maxSize)
+	}
+
+	if len(checksum) > 0 {
+		digest := sha256.Sum256(image)
+		if !strings.EqualFold(hex.EncodeToString(digest[:]), checksum) {
+			return nil, errors.New("image checksum mismatch")
+		}
+	}
+	return image, nil
+}
@@ -0,0 +1,470 @@
+package peripherals
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultDevDir   = "/dev/"
+	DefaultSysfsDir = "/sys/"
+	DefaultProcDir  = "/proc/"
+)
+
+// ScanStats holds the timings of the last discovery, split between the backend
+// enumeration and the udev probing of each device
+type ScanStats struct {
+	Started          time.Time
+	Duration         time.Duration
+	Enumeration      time.Duration
+	Udev             time.Duration
+	Devices          int
+	DeepProbed       int
+	DeepProbeSkipped int
+
+	// Shallow scans reuse the deep probing results of the devices already
+	// known, and only probe the new ones
+	Shallow bool
+
+	// The devices were not enumerated again, the USB topology being the same
+	// as the one of the last listing
+	Cached bool
+}
+
+// Discoverer turns the devices listed by a Backend into peripherals
+type Discoverer struct {
+	backend       Backend
+	prober        Prober
+	modems        ModemQuerier
+	devDir        string
+	sysfsDir      string
+	procDir       string
+	budget        time.Duration
+	watchInterval time.Duration
+	p1Timeout     time.Duration
+	gnssTimeout   time.Duration
+	holders       bool
+	deepEvery     int
+	shallow       bool
+	quirks        Quirks
+
+	// Devices already reset for the reset-on-attach quirk, and the ones whose
+	// autosuspend was disabled for the no-autosuspend one
+	reset       map[string]bool
+	unsuspended map[string]bool
+
+	// Records of the last deep probing, by device, reused by shallow scans
+	deepCache map[string]Peripheral
+	scans     int
+
+	// Last listing of the backend and the USB topology it was made in, reused
+	// while the topology does not change
+	topologyCache bool
+	topology      string
+	listing       []Device
+
+	// Telegrams read from P1 cables, by device and serial node
+	p1Cache map[string]telegram
+	p1Seen  map[string]bool
+
+	// Answers of the GNSS receivers, by device and serial node
+	gnssCache map[string]receiverInfo
+	gnssSeen  map[string]bool
+
+	stats     ScanStats
+	bandwidth []BusBandwidth
+}
+
+// Option customises a Discoverer
+type Option func(*Discoverer)
+
+// WithProber replaces the udevadm based prober. The given prober is used as
+// is, wrap it in a CachingProber to memoize its lookups.
+func WithProber(prober Prober) Option {
+	return func(d *Discoverer) {
+		d.prober = prober
+	}
+}
+
+// WithModemQuerier replaces the querier of the cellular modems, which runs
+// mmcli, qmicli or mbimcli. A nil querier only reports the control device of
+// the modems.
+func WithModemQuerier(querier ModemQuerier) Option {
+	return func(d *Discoverer) {
+		d.modems = querier
+	}
+}
+
+// WithDevDir sets the folder where device nodes are looked up
+func WithDevDir(dir string) Option {
+	return func(d *Discoverer) {
+		d.devDir = dir
+	}
+}
+
+// WithSysfsDir sets where sysfs is mounted
+func WithSysfsDir(dir string) Option {
+	return func(d *Discoverer) {
+		d.sysfsDir = dir
+	}
+}
+
+// WithProcDir sets where procfs is mounted, to find the filesystems in use on
+// storage devices
+func WithProcDir(dir string) Option {
+	return func(d *Discoverer) {
+		d.procDir = dir
+	}
+}
+
+// WithScanBudget sets how long a discovery may spend before skipping the udev
+// probing of the remaining devices. A zero budget disables the limit.
+func WithScanBudget(budget time.Duration) Option {
+	return func(d *Discoverer) {
+		d.budget = budget
+	}
+}
+
+// WithWatchInterval sets how often Watch runs a discovery
+func WithWatchInterval(interval time.Duration) Option {
+	return func(d *Discoverer) {
+		d.watchInterval = interval
+	}
+}
+
+// WithP1Probe enables reading a DSMR telegram from P1 cable candidates, to
+// confirm a smart meter is connected. The serial port is read for up to the
+// given timeout, once per attached cable.
+func WithP1Probe(timeout time.Duration) Option {
+	return func(d *Discoverer) {
+		d.p1Timeout = timeout
+	}
+}
+
+// WithGNSSProbe enables polling the u-blox GNSS receivers for their model,
+// firmware and configured rates, to tell their RTK capability. The serial port
+// is read for up to the given timeout, once per attached receiver.
+func WithGNSSProbe(timeout time.Duration) Option {
+	return func(d *Discoverer) {
+		d.gnssTimeout = timeout
+	}
+}
+
+// WithHolders makes every discovery report the processes holding the device
+// nodes of the peripherals open, read from the file descriptors in procfs
+func WithHolders() Option {
+	return func(d *Discoverer) {
+		d.holders = true
+	}
+}
+
+// WithDeepScanEvery makes only one discovery out of every n a deep scan. The
+// other ones are shallow: they read the descriptors of all devices but only
+// probe udev and sysfs for the devices attached since the last scan, and
+// report the last known deep attributes for the others. n below 2 makes every
+// scan deep.
+func WithDeepScanEvery(n int) Option {
+	return func(d *Discoverer) {
+		d.deepEvery = n
+	}
+}
+
+// WithTopologyCache makes the discoveries skip the enumeration of the devices
+// while the USB topology listed in sysfs does not change, and reuse the last
+// listing of the backend. The deep probing still runs as WithDeepScanEvery
+// tells. Backends listing devices sysfs does not hold, simulated or replayed,
+// must not be combined with it.
+func WithTopologyCache() Option {
+	return func(d *Discoverer) {
+		d.topologyCache = true
+	}
+}
+
+// WithQuirks replaces the quirk table, DefaultQuirks by default. Append the
+// user entries to DefaultQuirks to extend it.
+func WithQuirks(quirks Quirks) Option {
+	return func(d *Discoverer) {
+		d.quirks = quirks
+	}
+}
+
+// NewDiscoverer creates a Discoverer listing devices from the given backend
+func NewDiscoverer(backend Backend, opts ...Option) *Discoverer {
+	d := &Discoverer{
+		backend:       backend,
+		prober:        NewCachingProber(UdevadmProber{}),
+		modems:        CLIModemQuerier{},
+		devDir:        DefaultDevDir,
+		sysfsDir:      DefaultSysfsDir,
+		procDir:       DefaultProcDir,
+		watchInterval: 30 * time.Second,
+		p1Cache:       map[string]telegram{},
+		deepCache:     map[string]Peripheral{},
+		p1Seen:        map[string]bool{},
+		gnssCache:     map[string]receiverInfo{},
+		gnssSeen:      map[string]bool{},
+		quirks:        DefaultQuirks,
+		reset:         map[string]bool{},
+		unsuspended:   map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// SetShallow makes the discoveries shallow until it is unset, whatever
+// WithDeepScanEvery: only the devices attached since the last deep probing
+// are probed
+func (d *Discoverer) SetShallow(shallow bool) {
+	d.shallow = shallow
+}
+
+// Stats returns the timings of the last discovery
+func (d *Discoverer) Stats() ScanStats {
+	return d.stats
+}
+
+// Bandwidth returns the bandwidth estimate of the buses with isochronous
+// devices, as of the last discovery
+func (d *Discoverer) Bandwidth() []BusBandwidth {
+	return d.bandwidth
+}
+
+// Discover lists the attached devices and returns them as peripherals. When
+// the backend fails part way, the devices listed so far are returned along
+// with the error. Once ctx is done, the remaining devices are reported with
+// their descriptor information only and the error of ctx is returned.
+func (d *Discoverer) Discover(ctx context.Context) ([]Peripheral, error) {
+	d.stats = ScanStats{Started: time.Now()}
+	defer func() { d.stats.Duration = time.Since(d.stats.Started) }()
+
+	deep := (d.deepEvery < 2 || d.scans%d.deepEvery == 0) && !d.shallow
+	d.scans++
+	d.stats.Shallow = !deep
+
+	devices, devErr := d.listDevices(ctx)
+	d.stats.Enumeration = time.Since(d.stats.Started)
+	d.stats.Devices = len(devices)
+
+	quirks := make([][]Quirk, len(devices))
+	trusted := make([]Device, 0, len(devices))
+	for i, device := range devices {
+		quirks[i] = d.quirks.Match(device)
+		if !hasQuirk(quirks[i], QuirkBadDescriptors) {
+			trusted = append(trusted, device)
+		}
+	}
+	d.bandwidth = EstimateBandwidth(trusted)
+
+	peripherals := make([]Peripheral, 0, len(devices))
+	attached := map[string]bool{}
+	for i, device := range devices {
+		peripheral := newPeripheral(device)
+		key := device.DevicePath() + " " + device.Identifier()
+		attached[key] = true
+		d.applyQuirks(ctx, key, device, quirks[i], &peripheral)
+
+		cached, known := d.deepCache[key]
+		switch {
+		case !deep && known:
+			copyDeepAttributes(&peripheral, cached)
+		case ctx.Err() == nil && d.withinBudget():
+			// Serial numbers and video nodes come from udev, which is by far the
+			// most expensive part of the scan. Once the budget is exhausted, the
+			// remaining devices are reported with their descriptor information only
+			d.deepProbe(ctx, device, quirks[i], &peripheral)
+			// Probes interrupted part way must not be reused by shallow scans
+			if ctx.Err() == nil {
+				d.deepCache[key] = peripheral
+			}
+			d.stats.DeepProbed++
+		default:
+			d.stats.DeepProbeSkipped++
+		}
+
+		peripherals = append(peripherals, peripheral)
+	}
+
+	if devErr == nil && ctx.Err() != nil {
+		devErr = ctx.Err()
+	}
+	if devErr == nil {
+		for key := range d.deepCache {
+			if !attached[key] {
+				delete(d.deepCache, key)
+			}
+		}
+		// A device reattached is reset again, and its autosuspend disabled
+		for key := range d.reset {
+			if !attached[key] {
+				delete(d.reset, key)
+			}
+		}
+		for key := range d.unsuspended {
+			if !attached[key] {
+				delete(d.unsuspended, key)
+			}
+		}
+		d.pruneP1Cache()
+		d.pruneGNSSCache()
+		// Shallow scans only look up the new devices, the others must stay cached
+		if pruner, ok := d.prober.(interface{ Prune() }); ok && deep {
+			pruner.Prune()
+		}
+		peripherals = d.checkVisibility(peripherals)
+		if d.holders {
+			d.attributeHolders(peripherals)
+		}
+	}
+	// Partial scans must not hand out the console either
+	d.checkHostCritical(peripherals)
+	d.assignPorts(peripherals)
+
+	if d.stats.DeepProbeSkipped > 0 && ctx.Err() == nil {
+		log.Warnf("USB scan exceeded its budget of %s. Skipped deep probing of %d devices",
+			d.budget, d.stats.DeepProbeSkipped)
+	}
+
+	return peripherals, devErr
+}
+
+// copyDeepAttributes sets the attributes deep probing adds to the record built
+// from the descriptors of a device
+func copyDeepAttributes(peripheral *Peripheral, probed Peripheral) {
+	peripheral.SerialNumber = probed.SerialNumber
+	peripheral.VideoDevice = probed.VideoDevice
+	peripheral.VideoDevices = probed.VideoDevices
+	peripheral.SerialDevices = probed.SerialDevices
+	peripheral.CaptureCard = probed.CaptureCard
+	peripheral.DVBAdapters = probed.DVBAdapters
+	peripheral.SmartMeter = probed.SmartMeter
+	peripheral.HID = probed.HID
+	peripheral.UVC = probed.UVC
+	peripheral.Storage = probed.Storage
+	peripheral.Modem = probed.Modem
+	peripheral.SecurityToken = probed.SecurityToken
+	peripheral.DepthCamera = probed.DepthCamera
+	peripheral.GNSS = probed.GNSS
+	peripheral.Drivers = probed.Drivers
+	peripheral.DriverMissing = probed.DriverMissing
+	peripheral.Details = probed.Details
+}
+
+// applyQuirks notes the quirks of the device in its record and applies the
+// ones not related to deep probing
+func (d *Discoverer) applyQuirks(ctx context.Context, key string, device Device, quirks []Quirk, peripheral *Peripheral) {
+	peripheral.Quirks = quirks
+	if hasQuirk(quirks, QuirkBadDescriptors) {
+		peripheral.Bandwidth = nil
+	}
+
+	if hasQuirk(quirks, QuirkNoAutosuspend) && !d.unsuspended[key] {
+		d.unsuspended[key] = true
+		if err := d.disableAutosuspend(device); err != nil {
+			log.Errorf("Unable to disable the autosuspend of device %s on %s. Reason: %s", device.Identifier(), device.DevicePath(), err)
+		} else {
+			log.Infof("Disabled the autosuspend of device %s on %s, as its quirks require", device.Identifier(), device.DevicePath())
+		}
+	}
+
+	if !hasQuirk(quirks, QuirkResetOnAttach) || d.reset[key] {
+		return
+	}
+	d.reset[key] = true
+	resetter, ok := d.backend.(Resetter)
+	if !ok {
+		log.Warnf("Unable to reset device %s on %s: the USB backend does not support it", device.Identifier(), device.DevicePath())
+		return
+	}
+	if err := resetter.Reset(ctx, device); err != nil {
+		log.Errorf("Unable to reset device %s on %s. Reason: %s", device.Identifier(), device.DevicePath(), err)
+		return
+	}
+	log.Infof("Reset device %s on %s, as its quirks require", device.Identifier(), device.DevicePath())
+}
+
+func (d *Discoverer) withinBudget() bool {
+	return d.budget <= 0 || time.Since(d.stats.Started) < d.budget
+}
+
+// SetScanBudget changes the budget of the next discoveries, see WithScanBudget.
+// It must not be called while a discovery runs.
+func (d *Discoverer) SetScanBudget(budget time.Duration) {
+	d.budget = budget
+}
+
+// probeSerialNumber times the udev lookup of a device serial number
+func (d *Discoverer) probeSerialNumber(ctx context.Context, devicePath string) string {
+	start := time.Now()
+	defer func() { d.stats.Udev += time.Since(start) }()
+	return d.prober.SerialNumber(ctx, devicePath)
+}
+
+// deepProbe adds the information held by udev and sysfs to the peripheral.
+// The probes that may block, running udevadm, reading a serial port or
+// querying a camera, are skipped once ctx is done, and the ones the quirks of
+// the device rule out.
+func (d *Discoverer) deepProbe(ctx context.Context, device Device, quirks []Quirk, peripheral *Peripheral) {
+	if !hasQuirk(quirks, QuirkNoUdev) {
+		d.probeVideoDevice(ctx, device, peripheral)
+	}
+	d.probeSerialDevices(device, peripheral)
+	d.probeDVB(device, peripheral)
+	d.probeCaptureCard(device, peripheral)
+	d.probeDepthCamera(device, peripheral)
+	if ctx.Err() == nil {
+		d.probeSmartMeter(ctx, device, peripheral)
+	}
+	if ctx.Err() == nil {
+		d.probeGNSS(ctx, device, peripheral)
+	}
+
+	trusted := !hasQuirk(quirks, QuirkBadDescriptors)
+	if trusted && device.HasInterfaceClass(ClassHID) {
+		d.probeHID(device, peripheral)
+	}
+	d.probeSecurityToken(device, peripheral)
+	if trusted && device.HasInterfaceClass(ClassVideo) {
+		d.probeUVC(ctx, device, peripheral)
+	}
+	if device.HasInterfaceClass(ClassMassStorage) {
+		d.probeStorage(device, peripheral)
+	}
+	d.probeModem(ctx, device, peripheral)
+	d.probeDrivers(device, peripheral)
+	d.probeDetails(device, trusted, peripheral)
+}
+
+// probeVideoDevice adds the serial number and the matching video device node
+// to the peripheral
+func (d *Discoverer) probeVideoDevice(ctx context.Context, device Device, peripheral *Peripheral) {
+	serialNumber := d.probeSerialNumber(ctx, device.DevicePath())
+	if len(serialNumber) == 0 {
+		return
+	}
+	peripheral.SerialNumber = serialNumber
+
+	devFiles, vfErr := ioutil.ReadDir(d.devDir)
+	if vfErr != nil {
+		log.Errorf("Unable to read files under %s. Reason: %s", d.devDir, vfErr.Error())
+		return
+	}
+
+	for _, df := range devFiles {
+		if ctx.Err() != nil {
+			return
+		}
+		if strings.HasPrefix(df.Name(), "video") {
+			vfSerialNumber := d.probeSerialNumber(ctx, d.devDir+df.Name())
+			if vfSerialNumber == serialNumber {
+				peripheral.VideoDevice = d.devDir + df.Name()
+				break
+			}
+		}
+	}
+}
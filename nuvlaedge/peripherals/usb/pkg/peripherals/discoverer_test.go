@@ -0,0 +1,231 @@
+package peripherals
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeBackend struct {
+	devices []Device
+	err     error
+}
+
+func (b *fakeBackend) Devices(ctx context.Context) ([]Device, error) {
+	return b.devices, b.err
+}
+
+func (b *fakeBackend) Close() error {
+	return nil
+}
+
+type fakeProber struct {
+	serials map[string]string
+	delay   time.Duration
+	calls   int
+}
+
+func (p *fakeProber) SerialNumber(ctx context.Context, devicePath string) string {
+	p.calls++
+	time.Sleep(p.delay)
+	return p.serials[devicePath]
+}
+
+func webcam() Device {
+	return Device{
+		Bus:            1,
+		Address:        4,
+		VendorID:       0x046d,
+		ProductID:      0x0825,
+		VendorName:     "Logitech, Inc.",
+		ProductName:    "Webcam C270",
+		Classification: "(Defined at Interface level)",
+		Interfaces: []InterfaceSetting{
+			{Number: 0, Class: 0x0e, ClassName: "Video"},
+			{Number: 1, Class: 0x0e, ClassName: "Video"},
+			{Number: 2, Class: 0x01, ClassName: "Audio"},
+		},
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	videoDir := t.TempDir()
+	for _, name := range []string{"video0", "video1", "null"} {
+		if err := os.WriteFile(filepath.Join(videoDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prober := &fakeProber{serials: map[string]string{
+		"/dev/bus/usb/001/004":            "200901010001",
+		filepath.Join(videoDir, "video0"): "other",
+		filepath.Join(videoDir, "video1"): "200901010001",
+	}}
+	unknown := Device{Bus: 2, Address: 1, VendorID: 0xffff, ProductID: 0x0001}
+	backend := &fakeBackend{devices: []Device{webcam(), unknown}}
+
+	d := NewDiscoverer(backend, WithProber(prober), WithDevDir(videoDir+"/"), WithSysfsDir(t.TempDir()))
+	discovered, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(discovered) != 2 {
+		t.Fatalf("expected 2 peripherals, got %d", len(discovered))
+	}
+
+	camera := discovered[0]
+	if camera.Identifier != "046d:0825" {
+		t.Errorf("unexpected identifier %s", camera.Identifier)
+	}
+	if camera.Name != "Webcam C270" || camera.Vendor != "Logitech, Inc." {
+		t.Errorf("unexpected names %v / %v", camera.Name, camera.Vendor)
+	}
+	if classes := camera.Classes; len(classes) != 2 || classes[0] != "Video" || classes[1] != "Audio" {
+		t.Errorf("unexpected classes %v", classes)
+	}
+	if camera.SerialNumber != "200901010001" {
+		t.Errorf("unexpected serial number %s", camera.SerialNumber)
+	}
+	if camera.VideoDevice != videoDir+"/video1" {
+		t.Errorf("unexpected video device %v", camera.VideoDevice)
+	}
+
+	other := discovered[1]
+	if other.Name != "UNNAMED USB Device with ID ffff:0001" {
+		t.Errorf("unexpected name for unknown device %v", other.Name)
+	}
+	if len(other.Vendor) > 0 {
+		t.Errorf("unknown device should not report a vendor")
+	}
+	if len(other.SerialNumber) > 0 {
+		t.Errorf("unknown device should not report a serial number")
+	}
+
+	if stats := d.Stats(); stats.Devices != 2 || stats.DeepProbeSkipped != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDiscoverReturnsPartialResults(t *testing.T) {
+	backend := &fakeBackend{devices: []Device{webcam()}, err: errors.New("libusb: busy")}
+
+	discovered, err := NewDiscoverer(backend, WithProber(&fakeProber{})).Discover(context.Background())
+	if err == nil {
+		t.Error("expected backend error to be returned")
+	}
+	if len(discovered) != 1 {
+		t.Errorf("expected the listed devices to be returned, got %d", len(discovered))
+	}
+}
+
+func TestDiscoverSkipsDeepProbingOverBudget(t *testing.T) {
+	second := webcam()
+	second.Address = 5
+	backend := &fakeBackend{devices: []Device{webcam(), second}}
+	prober := &fakeProber{delay: 20 * time.Millisecond}
+
+	d := NewDiscoverer(backend, WithProber(prober), WithScanBudget(10*time.Millisecond), WithDevDir(t.TempDir()))
+	discovered, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discovered) != 2 {
+		t.Fatalf("expected all devices to be reported, got %d", len(discovered))
+	}
+	if prober.calls != 1 {
+		t.Errorf("expected a single udev probe before the budget ran out, got %d", prober.calls)
+	}
+	if skipped := d.Stats().DeepProbeSkipped; skipped != 1 {
+		t.Errorf("expected one device to skip deep probing, got %d", skipped)
+	}
+
+	// A changed budget applies to the next discoveries
+	d.SetScanBudget(0)
+	prober.calls = 0
+	if _, err := d.Discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if skipped := d.Stats().DeepProbeSkipped; skipped != 0 || prober.calls != 2 {
+		t.Errorf("expected every device to be probed without budget, got %d probes and %d skipped", prober.calls, skipped)
+	}
+}
+
+func TestDiscoverStopsProbingOnceCancelled(t *testing.T) {
+	second := webcam()
+	second.Address = 5
+	backend := &fakeBackend{devices: []Device{webcam(), second}}
+	prober := &fakeProber{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d := NewDiscoverer(backend, WithProber(prober), WithDevDir(t.TempDir()))
+	discovered, err := d.Discover(ctx)
+	if err != context.Canceled {
+		t.Errorf("expected the scan to report its cancellation, got %v", err)
+	}
+	if len(discovered) != 2 {
+		t.Fatalf("expected the listed devices to be reported, got %d", len(discovered))
+	}
+	if prober.calls != 0 || len(d.deepCache) != 0 {
+		t.Errorf("expected no deep probing once cancelled, got %d udev calls and %d cached records",
+			prober.calls, len(d.deepCache))
+	}
+}
+
+func TestShallowScans(t *testing.T) {
+	prober := &fakeProber{serials: map[string]string{"/dev/bus/usb/001/004": "200901010001"}}
+	backend := &fakeBackend{devices: []Device{webcam()}}
+	d := NewDiscoverer(backend, WithProber(prober), WithDevDir(t.TempDir()), WithSysfsDir(t.TempDir()),
+		WithDeepScanEvery(3))
+
+	discover := func() []Peripheral {
+		discovered, err := d.Discover(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return discovered
+	}
+
+	discover()
+	if d.Stats().Shallow || prober.calls != 1 {
+		t.Fatalf("expected first scan to be deep, got %+v with %d udev calls", d.Stats(), prober.calls)
+	}
+
+	// shallow scan: the camera keeps its serial number without asking udev
+	// again, and the new device is probed
+	second := webcam()
+	second.Address = 5
+	backend.devices = append(backend.devices, second)
+	discovered := discover()
+	if !d.Stats().Shallow || d.Stats().DeepProbed != 1 || prober.calls != 2 {
+		t.Errorf("expected a shallow scan probing the new device only, got %+v with %d udev calls", d.Stats(), prober.calls)
+	}
+	if discovered[0].SerialNumber != "200901010001" {
+		t.Errorf("expected cached serial number, got %v", discovered[0])
+	}
+
+	discover()
+	discover()
+	if d.Stats().Shallow || d.Stats().DeepProbed != 2 {
+		t.Errorf("expected every third scan to be deep, got %+v", d.Stats())
+	}
+
+	// the scans due to be deep stay shallow while forced
+	d.SetShallow(true)
+	discover()
+	discover()
+	discover()
+	if !d.Stats().Shallow || d.Stats().DeepProbed != 0 {
+		t.Errorf("expected forced shallow scans, got %+v", d.Stats())
+	}
+	d.SetShallow(false)
+	discover()
+	discover()
+	discover()
+	if d.Stats().Shallow {
+		t.Errorf("expected deep scans again, got %+v", d.Stats())
+	}
+}
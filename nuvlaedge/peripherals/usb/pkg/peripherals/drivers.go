@@ -0,0 +1,97 @@
+package peripherals
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ClassVendorSpecific interfaces are driven by the vendor software, often
+// from user space through usbfs
+const ClassVendorSpecific uint8 = 0xff
+
+// DriverNone is reported for the interfaces no kernel driver is bound to
+const DriverNone = "none"
+
+// InterfaceDriver is the kernel driver bound to an interface of a device
+type InterfaceDriver struct {
+	Interface int    `json:"interface"`
+	Class     string `json:"class,omitempty"`
+	Driver    string `json:"driver"`
+}
+
+// needsKernelDriver tells whether an interface of the class is expected to be
+// bound to a kernel driver. The vendor specific and application specific ones,
+// as DFU, are commonly used from user space.
+func needsKernelDriver(class uint8) bool {
+	return class != ClassVendorSpecific && class != ClassApplicationSpecific
+}
+
+// probeDrivers reports the kernel driver bound to each interface of the
+// active configuration, from the sysfs interface folders such as 1-1:1.0, and
+// flags the devices whose standard interfaces have none: they are visible,
+// but no application can use them until the missing driver is installed.
+//
+//	"drivers": [
+//	  {"interface": 0, "class": "Video", "driver": "uvcvideo"},
+//	  {"interface": 2, "class": "Audio", "driver": "none"}
+//	],
+//	"driver-missing": true
+func (d *Discoverer) probeDrivers(device Device, peripheral *Peripheral) {
+	dir, ok := d.usbDeviceDir(device)
+	if !ok {
+		return
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logSysfsError(dir, err)
+		return
+	}
+
+	settings := map[int]InterfaceSetting{}
+	for _, setting := range device.Interfaces {
+		if _, seen := settings[setting.Number]; !seen || setting.Alternate == 0 {
+			settings[setting.Number] = setting
+		}
+	}
+
+	var drivers []InterfaceDriver
+	missing := false
+	prefix := filepath.Base(dir) + ":"
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		interfaceDir := filepath.Join(dir, entry.Name())
+		number, err := readSysfsString(filepath.Join(interfaceDir, "bInterfaceNumber"))
+		if err != nil {
+			continue
+		}
+		parsed, err := strconv.ParseUint(number, 16, 8)
+		if err != nil {
+			continue
+		}
+
+		binding := InterfaceDriver{Interface: int(parsed), Driver: DriverNone}
+		class := uint8(readSysfsHex(filepath.Join(interfaceDir, "bInterfaceClass")))
+		if setting, ok := settings[binding.Interface]; ok {
+			binding.Class = setting.ClassName
+			class = setting.Class
+		}
+		if driver, err := filepath.EvalSymlinks(filepath.Join(interfaceDir, "driver")); err == nil {
+			binding.Driver = filepath.Base(driver)
+		} else if needsKernelDriver(class) {
+			missing = true
+		}
+		drivers = append(drivers, binding)
+	}
+	if len(drivers) == 0 {
+		return
+	}
+
+	sort.Slice(drivers, func(i, j int) bool { return drivers[i].Interface < drivers[j].Interface })
+	peripheral.Drivers = drivers
+	peripheral.DriverMissing = missing
+}
@@ -0,0 +1,79 @@
+package peripherals
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeInterface creates the sysfs folder of an interface, bound to driver
+// unless empty
+func fakeInterface(t *testing.T, sysfs string, deviceDir string, name string, number string, class string, driver string) {
+	dir := filepath.Join(deviceDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "bInterfaceNumber"), number)
+	writeFile(t, filepath.Join(dir, "bInterfaceClass"), class)
+	if len(driver) == 0 {
+		return
+	}
+	driverDir := filepath.Join(sysfs, "bus", "usb", "drivers", driver)
+	if err := os.MkdirAll(driverDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(driverDir, filepath.Join(dir, "driver")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbeDrivers(t *testing.T) {
+	sysfs := t.TempDir()
+	usbDir := fakeUSBDevice(t, sysfs, "1-1", "1", "4")
+	fakeInterface(t, sysfs, usbDir, "1-1:1.0", "00", "0e", "uvcvideo")
+	fakeInterface(t, sysfs, usbDir, "1-1:1.1", "01", "0e", "uvcvideo")
+	fakeInterface(t, sysfs, usbDir, "1-1:1.2", "02", "01", "")
+
+	devicesDir := filepath.Join(sysfs, "bus", "usb", "devices")
+	if err := os.MkdirAll(devicesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(usbDir, filepath.Join(devicesDir, "1-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs))
+	peripheral := Peripheral{}
+	d.probeDrivers(webcam(), &peripheral)
+
+	expected := []InterfaceDriver{
+		{Interface: 0, Class: "Video", Driver: "uvcvideo"},
+		{Interface: 1, Class: "Video", Driver: "uvcvideo"},
+		{Interface: 2, Class: "Audio", Driver: DriverNone},
+	}
+	if !reflect.DeepEqual(peripheral.Drivers, expected) {
+		t.Errorf("expected drivers %+v, got %+v", expected, peripheral.Drivers)
+	}
+	if !peripheral.DriverMissing {
+		t.Error("expected the audio interface without driver to be flagged")
+	}
+
+	// Vendor specific interfaces are commonly driven from user space
+	vendorDir := fakeUSBDevice(t, sysfs, "1-2", "1", "5")
+	fakeInterface(t, sysfs, vendorDir, "1-2:1.0", "00", "ff", "")
+	if err := os.Symlink(vendorDir, filepath.Join(devicesDir, "1-2")); err != nil {
+		t.Fatal(err)
+	}
+	vendor := Peripheral{}
+	d.probeDrivers(Device{Bus: 1, Address: 5}, &vendor)
+	if len(vendor.Drivers) != 1 || vendor.Drivers[0].Driver != DriverNone || vendor.DriverMissing {
+		t.Errorf("expected the vendor specific interface not to be flagged, got %+v", vendor)
+	}
+
+	unknown := Peripheral{}
+	d.probeDrivers(Device{Bus: 1, Address: 9}, &unknown)
+	if unknown.Drivers != nil || unknown.DriverMissing {
+		t.Errorf("expected no drivers for an unknown device, got %+v", unknown)
+	}
+}
@@ -0,0 +1,135 @@
+package peripherals
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Frontend types and capabilities, from linux/dvb/frontend.h
+const (
+	feQPSK = 0
+	feQAM  = 1
+	feOFDM = 2
+	feATSC = 3
+
+	feCan2GModulation = 0x10000000
+)
+
+// dvbFrontendInfoSize is the size of struct dvb_frontend_info
+const dvbFrontendInfoSize = 168
+
+// dvbNodePattern matches the names of the dvb sysfs class nodes, such as
+// dvb0.frontend0
+var dvbNodePattern = regexp.MustCompile(`^dvb(\d+)\.frontend(\d+)$`)
+
+// captureCardVendors lists vendors of USB video capture and analog TV cards.
+// Their video nodes carry a capture input rather than a camera.
+var captureCardVendors = map[uint16]bool{
+	0x534d: true, // MacroSilicon
+	0x0fd9: true, // Elgato
+	0x07ca: true, // AVerMedia
+	0x2040: true, // Hauppauge
+	0x2935: true, // Magewell
+	0x1b71: true, // Fushicai (EasyCAP)
+	0xeb1a: true, // eMPIA
+}
+
+// frontendInfo is the decoded struct dvb_frontend_info of a DVB frontend
+type frontendInfo struct {
+	Name string
+	Type uint32
+	Caps uint32
+}
+
+func decodeFrontendInfo(data []byte) (frontendInfo, error) {
+	if len(data) < dvbFrontendInfoSize {
+		return frontendInfo{}, fmt.Errorf("short dvb_frontend_info of %d bytes", len(data))
+	}
+	name := data[:128]
+	if end := bytes.IndexByte(name, 0); end >= 0 {
+		name = name[:end]
+	}
+	return frontendInfo{
+		Name: string(name),
+		Type: binary.LittleEndian.Uint32(data[128:132]),
+		Caps: binary.LittleEndian.Uint32(data[164:168]),
+	}, nil
+}
+
+// standards lists the delivery systems a frontend supports. Second generation
+// standards are only announced by the FE_CAN_2G_MODULATION capability.
+func (f frontendInfo) standards() []string {
+	secondGeneration := f.Caps&feCan2GModulation != 0
+
+	switch f.Type {
+	case feQPSK:
+		if secondGeneration {
+			return []string{"DVB-S", "DVB-S2"}
+		}
+		return []string{"DVB-S"}
+	case feQAM:
+		if secondGeneration {
+			return []string{"DVB-C", "DVB-C2"}
+		}
+		return []string{"DVB-C"}
+	case feOFDM:
+		if secondGeneration {
+			return []string{"DVB-T", "DVB-T2"}
+		}
+		return []string{"DVB-T"}
+	case feATSC:
+		return []string{"ATSC"}
+	}
+	return []string{}
+}
+
+// probeDVB reports the DVB adapters of the device, with the standards of each
+// frontend
+func (d *Discoverer) probeDVB(device Device, peripheral *Peripheral) {
+	var adapters []DVBAdapter
+
+	for _, node := range d.classNodes("dvb", device) {
+		match := dvbNodePattern.FindStringSubmatch(node.Name)
+		if match == nil {
+			continue
+		}
+		adapter, _ := strconv.Atoi(match[1])
+		frontendPath := fmt.Sprintf("%sdvb/adapter%s/frontend%s", d.devDir, match[1], match[2])
+
+		entry := DVBAdapter{Adapter: adapter, Frontend: frontendPath}
+		if info, err := readFrontendInfo(frontendPath); err != nil {
+			logSysfsError(frontendPath, err)
+		} else {
+			entry.Name = info.Name
+			entry.Standards = info.standards()
+		}
+		adapters = append(adapters, entry)
+	}
+
+	if len(adapters) > 0 {
+		peripheral.DVBAdapters = adapters
+	}
+}
+
+// probeCaptureCard flags video capture cards and lists their video nodes,
+// which unlike cameras are not tied to the device serial number
+func (d *Discoverer) probeCaptureCard(device Device, peripheral *Peripheral) {
+	if !captureCardVendors[device.VendorID] {
+		return
+	}
+
+	nodes := d.classNodes("video4linux", device)
+	if len(nodes) == 0 {
+		return
+	}
+
+	videoDevices := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		videoDevices = append(videoDevices, d.devDir+node.Name)
+	}
+	peripheral.CaptureCard = true
+	peripheral.VideoDevices = videoDevices
+}
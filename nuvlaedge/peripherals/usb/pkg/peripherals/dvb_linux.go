@@ -0,0 +1,27 @@
+package peripherals
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// FE_GET_INFO, _IOR('o', 61, struct dvb_frontend_info)
+const feGetInfo = 0x80a86f3d
+
+// readFrontendInfo queries a DVB frontend. The frontend is opened read-only,
+// which the kernel allows while another application is tuning it.
+func readFrontendInfo(path string) (frontendInfo, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return frontendInfo{}, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, dvbFrontendInfoSize)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), feGetInfo, uintptr(unsafe.Pointer(&buf[0])))
+	if errno != 0 {
+		return frontendInfo{}, errno
+	}
+	return decodeFrontendInfo(buf)
+}
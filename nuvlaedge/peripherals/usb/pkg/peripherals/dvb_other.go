@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package peripherals
+
+import "errors"
+
+func readFrontendInfo(path string) (frontendInfo, error) {
+	return frontendInfo{}, errors.New("DVB frontends can only be queried on Linux")
+}
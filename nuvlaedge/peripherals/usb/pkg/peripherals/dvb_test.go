@@ -0,0 +1,82 @@
+package peripherals
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func frontendInfoBytes(name string, feType uint32, caps uint32) []byte {
+	data := make([]byte, dvbFrontendInfoSize)
+	copy(data, name)
+	binary.LittleEndian.PutUint32(data[128:], feType)
+	binary.LittleEndian.PutUint32(data[164:], caps)
+	return data
+}
+
+func TestDecodeFrontendInfo(t *testing.T) {
+	cases := []struct {
+		feType   uint32
+		caps     uint32
+		expected []string
+	}{
+		{feOFDM, 0, []string{"DVB-T"}},
+		{feOFDM, feCan2GModulation, []string{"DVB-T", "DVB-T2"}},
+		{feQPSK, feCan2GModulation | 0x1, []string{"DVB-S", "DVB-S2"}},
+		{feQAM, 0, []string{"DVB-C"}},
+		{feATSC, 0, []string{"ATSC"}},
+	}
+
+	for _, c := range cases {
+		info, err := decodeFrontendInfo(frontendInfoBytes("Silicon Labs Si2168", c.feType, c.caps))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name != "Silicon Labs Si2168" {
+			t.Errorf("unexpected name %q", info.Name)
+		}
+		if standards := info.standards(); !reflect.DeepEqual(standards, c.expected) {
+			t.Errorf("type %d caps %x: expected %v, got %v", c.feType, c.caps, c.expected, standards)
+		}
+	}
+
+	if _, err := decodeFrontendInfo(make([]byte, 10)); err == nil {
+		t.Error("expected short buffer to be refused")
+	}
+}
+
+func TestProbeDVBAndCaptureCard(t *testing.T) {
+	sysfs := t.TempDir()
+	tuner := fakeUSBDevice(t, sysfs, "1-1", "1", "3")
+	fakeClassNode(t, sysfs, filepath.Join(tuner, "1-1:1.0"), "dvb", "dvb0.frontend0")
+	fakeClassNode(t, sysfs, filepath.Join(tuner, "1-1:1.0"), "dvb", "dvb0.demux0")
+	fakeClassNode(t, sysfs, filepath.Join(tuner, "1-1:1.0"), "video4linux", "video0")
+
+	devDir := t.TempDir() + "/"
+	if err := os.MkdirAll(devDir+"dvb/adapter0", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs), WithDevDir(devDir))
+	device := Device{Bus: 1, Address: 3, VendorID: 0x2040}
+	peripheral := Peripheral{}
+	d.probeDVB(device, &peripheral)
+	d.probeCaptureCard(device, &peripheral)
+
+	if len(peripheral.DVBAdapters) != 1 {
+		t.Fatalf("expected one DVB adapter, got %v", peripheral.DVBAdapters)
+	}
+	adapter := peripheral.DVBAdapters[0]
+	if adapter.Adapter != 0 || adapter.Frontend != devDir+"dvb/adapter0/frontend0" {
+		t.Errorf("unexpected adapter %v", adapter)
+	}
+
+	if !peripheral.CaptureCard {
+		t.Error("expected Hauppauge device to be reported as a capture card")
+	}
+	if !reflect.DeepEqual(peripheral.VideoDevices, []string{devDir + "video0"}) {
+		t.Errorf("unexpected video devices %v", peripheral.VideoDevices)
+	}
+}
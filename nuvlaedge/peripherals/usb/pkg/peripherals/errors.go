@@ -0,0 +1,16 @@
+package peripherals
+
+import "errors"
+
+// Classes of the failures of the discovery. The errors of the library wrap
+// them, so callers tell the failures apart with errors.Is rather than by
+// their message.
+var (
+	// ErrNoContext is returned by the backends that cannot open the USB
+	// stack of the host, as libusb failing to initialise or sysfs missing
+	ErrNoContext = errors.New("no USB context")
+
+	// ErrUdevTimeout is returned by the udev lookups cut short by their
+	// context, as when the scan runs out of budget
+	ErrUdevTimeout = errors.New("udev lookup timed out")
+)
@@ -0,0 +1,71 @@
+package peripherals
+
+import "fmt"
+
+// Class and sub-class of the Device Firmware Upgrade interface
+const (
+	ClassApplicationSpecific uint8 = 0xfe
+	SubClassDFU              uint8 = 0x01
+)
+
+// DFU interface protocols, telling whether the device runs its application or
+// waits in its bootloader for a new firmware
+const (
+	DFUProtocolRuntime uint8 = 0x01
+	DFUProtocolMode    uint8 = 0x02
+)
+
+// hardwareRevisionVendors are the vendors of bridge chips whose bcdDevice
+// encodes the chip model or silicon revision instead of a firmware version
+var hardwareRevisionVendors = map[uint16]bool{
+	0x0403: true, // FTDI
+	0x067b: true, // Prolific
+	0x10c4: true, // Silicon Labs
+	0x1a86: true, // QinHeng (CH34x)
+}
+
+// FormatBCD renders a binary-coded decimal version, such as bcdDevice, the way
+// vendors print it: 0x0102 is "1.02"
+func FormatBCD(bcd uint16) string {
+	return fmt.Sprintf("%x.%02x", bcd>>8, bcd&0xff)
+}
+
+// DFUMode returns "runtime" when the device can be switched to DFU, "dfu"
+// when it is already running its DFU bootloader, or an empty string when it
+// does not support DFU
+func (d Device) DFUMode() string {
+	mode := ""
+	for _, setting := range d.Interfaces {
+		if setting.Class != ClassApplicationSpecific || setting.SubClass != SubClassDFU {
+			continue
+		}
+		if setting.Protocol == DFUProtocolMode {
+			return "dfu"
+		}
+		mode = "runtime"
+	}
+	return mode
+}
+
+// firmwareInfo describes the firmware of a device from its descriptors.
+// It returns nil when the descriptors say nothing about it.
+//
+// The device is never opened: vendor specific version requests would claim
+// interfaces other containers might be using.
+func firmwareInfo(device Device) *Firmware {
+	info := Firmware{DFU: device.DFUMode()}
+
+	if device.Revision != 0 {
+		if hardwareRevisionVendors[device.VendorID] {
+			info.HardwareRevision = FormatBCD(device.Revision)
+		} else {
+			info.Version = FormatBCD(device.Revision)
+			info.Source = "bcdDevice"
+		}
+	}
+
+	if info == (Firmware{}) {
+		return nil
+	}
+	return &info
+}
@@ -0,0 +1,47 @@
+package peripherals
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatBCD(t *testing.T) {
+	for bcd, expected := range map[uint16]string{
+		0x0102: "1.02",
+		0x1234: "12.34",
+		0x0010: "0.10",
+	} {
+		if got := FormatBCD(bcd); got != expected {
+			t.Errorf("FormatBCD(%#04x) = %q, expected %q", bcd, got, expected)
+		}
+	}
+}
+
+func TestFirmwareInfo(t *testing.T) {
+	camera := webcam()
+	camera.Revision = 0x0012
+	camera.Interfaces = append(camera.Interfaces,
+		InterfaceSetting{Number: 3, Class: ClassApplicationSpecific, SubClass: SubClassDFU, Protocol: DFUProtocolRuntime})
+
+	expected := &Firmware{Version: "0.12", Source: "bcdDevice", DFU: "runtime"}
+	if got := newPeripheral(camera).Firmware; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected camera firmware %v, got %v", expected, got)
+	}
+
+	bootloader := Device{VendorID: 0x0483, ProductID: 0xdf11, Interfaces: []InterfaceSetting{
+		{Class: ClassApplicationSpecific, SubClass: SubClassDFU, Protocol: DFUProtocolMode},
+	}}
+	if got := bootloader.DFUMode(); got != "dfu" {
+		t.Errorf("expected device in DFU mode, got %q", got)
+	}
+
+	ftdi := Device{VendorID: 0x0403, ProductID: 0x6001, Revision: 0x0600}
+	expected = &Firmware{HardwareRevision: "6.00"}
+	if got := firmwareInfo(ftdi); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected bridge chip revision %v, got %v", expected, got)
+	}
+
+	if got := newPeripheral(webcam()).Firmware; got != nil {
+		t.Errorf("expected no firmware without revision, got %v", got)
+	}
+}
@@ -0,0 +1,283 @@
+package peripherals
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ubloxVendorID is the USB vendor of the u-blox receivers with a native USB
+// port
+const ubloxVendorID = 0x1546
+
+// ubloxGenerations are the receiver generations told by the USB product.
+// Generation 8 and later share the same product, their model is only known
+// from the receiver itself.
+var ubloxGenerations = map[uint16]string{
+	0x01a5: "u-blox 5",
+	0x01a6: "u-blox 6",
+	0x01a7: "u-blox 7",
+	0x01a8: "u-blox 8",
+}
+
+// UBX messages polled from the receivers
+const (
+	ubxClassACK = 0x05
+	ubxClassCFG = 0x06
+	ubxClassMON = 0x0a
+
+	ubxACKNAK  = 0x00
+	ubxCFGRate = 0x08
+	ubxMONVer  = 0x04
+)
+
+// maxUBXSize bounds how much is read from a receiver while waiting for its
+// answers, the NMEA sentences it outputs included
+const maxUBXSize = 65536
+
+// rtkCapability tells which RTK roles a receiver model supports
+type rtkCapability struct {
+	RTK         bool
+	BaseStation bool
+}
+
+// rtkModels are the RTK capabilities of the u-blox high precision modules, by
+// the model they report in UBX-MON-VER
+var rtkModels = map[string]rtkCapability{
+	"ZED-F9P":   {RTK: true, BaseStation: true},
+	"NEO-F9P":   {RTK: true, BaseStation: true},
+	"ZED-X20P":  {RTK: true, BaseStation: true},
+	"NEO-M8P-2": {RTK: true, BaseStation: true},
+	"NEO-M8P-0": {BaseStation: true},
+	// Heading and dead reckoning modules are rovers only
+	"ZED-F9H": {RTK: true},
+	"ZED-F9R": {RTK: true},
+}
+
+// constellations are the GNSS systems a receiver lists in its extensions
+var constellations = map[string]bool{
+	"GPS": true, "GLO": true, "GAL": true, "BDS": true, "QZSS": true, "SBAS": true, "NAVIC": true, "IMES": true,
+}
+
+// receiverInfo holds what a receiver answered to the UBX polls
+type receiverInfo struct {
+	Software        string
+	Hardware        string
+	Model           string
+	Firmware        string
+	ProtocolVersion string
+	Constellations  []string
+	// Configured rates, zero when the receiver did not answer UBX-CFG-RATE
+	MeasurementRate int
+	NavigationRate  int
+}
+
+// isGNSSCandidate reports whether the device may be a u-blox receiver
+func isGNSSCandidate(device Device) bool {
+	return device.VendorID == ubloxVendorID || strings.Contains(strings.ToLower(device.ProductName), "u-blox")
+}
+
+// ubxFrame encodes a UBX message, with its sync characters and checksum
+func ubxFrame(class byte, id byte, payload []byte) []byte {
+	frame := []byte{0xb5, 0x62, class, id, 0, 0}
+	binary.LittleEndian.PutUint16(frame[4:], uint16(len(payload)))
+	frame = append(frame, payload...)
+	a, b := ubxChecksum(frame[2:])
+	return append(frame, a, b)
+}
+
+// ubxChecksum is the 8-bit Fletcher checksum of the class, ID, length and
+// payload of a UBX message
+func ubxChecksum(data []byte) (byte, byte) {
+	var a, b byte
+	for _, c := range data {
+		a += c
+		b += a
+	}
+	return a, b
+}
+
+// ubxMessages returns the payloads of the valid UBX messages found in data,
+// by class and ID. The NMEA sentences and corrupted messages are skipped.
+func ubxMessages(data []byte) map[uint16][]byte {
+	messages := map[uint16][]byte{}
+	for {
+		start := bytes.Index(data, []byte{0xb5, 0x62})
+		if start < 0 || len(data)-start < 8 {
+			return messages
+		}
+		data = data[start:]
+		length := int(binary.LittleEndian.Uint16(data[4:6]))
+		if len(data) < length+8 {
+			return messages
+		}
+		a, b := ubxChecksum(data[2 : 6+length])
+		if a != data[6+length] || b != data[7+length] {
+			data = data[2:]
+			continue
+		}
+		messages[uint16(data[2])<<8|uint16(data[3])] = data[6 : 6+length]
+		data = data[8+length:]
+	}
+}
+
+// ubxComplete tells whether the receiver answered both polls, the rate poll
+// being refused by the receivers not supporting it
+func ubxComplete(data []byte) bool {
+	messages := ubxMessages(data)
+	if _, found := messages[ubxClassMON<<8|ubxMONVer]; !found {
+		return false
+	}
+	if _, found := messages[ubxClassCFG<<8|ubxCFGRate]; found {
+		return true
+	}
+	nak, found := messages[ubxClassACK<<8|ubxACKNAK]
+	return found && len(nak) >= 2 && nak[0] == ubxClassCFG && nak[1] == ubxCFGRate
+}
+
+// parseReceiver decodes the answers of a receiver to UBX-MON-VER and
+// UBX-CFG-RATE. Only the version is required.
+func parseReceiver(data []byte) (receiverInfo, error) {
+	messages := ubxMessages(data)
+	version, found := messages[ubxClassMON<<8|ubxMONVer]
+	if !found {
+		return receiverInfo{}, errors.New("no UBX-MON-VER answer")
+	}
+	if len(version) < 40 || (len(version)-40)%30 != 0 {
+		return receiverInfo{}, errors.New("malformed UBX-MON-VER answer")
+	}
+
+	info := receiverInfo{Software: ubxString(version[:30]), Hardware: ubxString(version[30:40])}
+	for offset := 40; offset < len(version); offset += 30 {
+		extension := ubxString(version[offset : offset+30])
+		switch {
+		case strings.HasPrefix(extension, "MOD="):
+			info.Model = strings.TrimPrefix(extension, "MOD=")
+		case strings.HasPrefix(extension, "FWVER="):
+			info.Firmware = strings.TrimPrefix(extension, "FWVER=")
+		case strings.HasPrefix(extension, "PROTVER="):
+			info.ProtocolVersion = strings.TrimPrefix(extension, "PROTVER=")
+		default:
+			for _, system := range strings.Split(extension, ";") {
+				if constellations[strings.ToUpper(system)] {
+					info.Constellations = append(info.Constellations, system)
+				}
+			}
+		}
+	}
+
+	if rate, found := messages[ubxClassCFG<<8|ubxCFGRate]; found && len(rate) >= 4 {
+		info.MeasurementRate = int(binary.LittleEndian.Uint16(rate[0:2]))
+		info.NavigationRate = int(binary.LittleEndian.Uint16(rate[2:4]))
+	}
+	return info, nil
+}
+
+// ubxString decodes a NUL padded string of a UBX message
+func ubxString(data []byte) string {
+	if end := bytes.IndexByte(data, 0); end >= 0 {
+		data = data[:end]
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// pollReceiver polls the version and the navigation rate of a receiver. The
+// F9 generation deprecated UBX-CFG-RATE for the configuration interface, but
+// still answers it.
+func pollReceiver(ctx context.Context, path string, timeout time.Duration) ([]byte, error) {
+	request := append(ubxFrame(ubxClassMON, ubxMONVer, nil), ubxFrame(ubxClassCFG, ubxCFGRate, nil)...)
+	data, err := querySerial(ctx, path, timeout, request, ubxComplete, maxUBXSize)
+	switch {
+	case errors.Is(err, errSerialTimeout):
+		return data, errors.New("timed out waiting for the receiver to answer")
+	case errors.Is(err, errSerialLimit):
+		return data, errors.New("no UBX answer found in the serial data")
+	}
+	return data, err
+}
+
+// apply sets what the receiver answered in its record, with the RTK
+// capability of its model. Unknown models running a high precision firmware
+// are reported as RTK rovers.
+func (info receiverInfo) apply(gnss *GNSS) {
+	gnss.Model = info.Model
+	gnss.Firmware = info.Firmware
+	if len(gnss.Firmware) == 0 {
+		gnss.Firmware = info.Software
+	}
+	gnss.HardwareVersion = info.Hardware
+	gnss.ProtocolVersion = info.ProtocolVersion
+	gnss.Constellations = info.Constellations
+
+	capability, known := rtkModels[info.Model]
+	if !known && strings.HasPrefix(info.Firmware, "HPG") {
+		capability.RTK = true
+	}
+	gnss.RTK = capability.RTK
+	gnss.BaseStation = capability.BaseStation
+
+	gnss.MeasurementRate = info.MeasurementRate
+	gnss.NavigationRate = info.NavigationRate
+	if info.MeasurementRate > 0 && info.NavigationRate > 0 {
+		rate := 1000 / float64(info.MeasurementRate*info.NavigationRate)
+		gnss.UpdateRate = math.Round(rate*100) / 100
+	}
+}
+
+// probeGNSS reports the u-blox receivers. With receiver probing enabled, the
+// serial port is polled once per attached receiver for its model, firmware
+// and configured rates, which tell its RTK capability.
+func (d *Discoverer) probeGNSS(ctx context.Context, device Device, peripheral *Peripheral) {
+	if !isGNSSCandidate(device) {
+		return
+	}
+	nodes := d.classNodes("tty", device)
+	if len(nodes) == 0 {
+		return
+	}
+	serialDevice := d.devDir + nodes[0].Name
+
+	gnss := &GNSS{Protocol: "UBX", SerialDevice: serialDevice, Generation: ubloxGenerations[device.ProductID]}
+	peripheral.GNSS = gnss
+
+	if d.gnssTimeout <= 0 {
+		return
+	}
+
+	cacheKey := device.DevicePath() + serialDevice
+	d.gnssSeen[cacheKey] = true
+	info, cached := d.gnssCache[cacheKey]
+	if !cached {
+		data, err := pollReceiver(ctx, serialDevice, d.gnssTimeout)
+		var parseErr error
+		// Timeouts still leave the version when only the rate is missing
+		if info, parseErr = parseReceiver(data); parseErr != nil {
+			if err == nil {
+				err = parseErr
+			}
+			log.Infof("No UBX answer read from %s. Reason: %s", serialDevice, err)
+			return
+		}
+		d.gnssCache[cacheKey] = info
+	}
+
+	gnss.Confirmed = true
+	info.apply(gnss)
+}
+
+// pruneGNSSCache forgets the receivers that were not seen in the last scan, so
+// a receiver plugged back in, maybe configured again, is polled again
+func (d *Discoverer) pruneGNSSCache() {
+	for key := range d.gnssCache {
+		if !d.gnssSeen[key] {
+			delete(d.gnssCache, key)
+		}
+	}
+	d.gnssSeen = map[string]bool{}
+}
@@ -0,0 +1,154 @@
+package peripherals
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// monVer encodes the UBX-MON-VER answer of a receiver
+func monVer(software string, hardware string, extensions ...string) []byte {
+	payload := make([]byte, 40+30*len(extensions))
+	copy(payload, software)
+	copy(payload[30:], hardware)
+	for i, extension := range extensions {
+		copy(payload[40+30*i:], extension)
+	}
+	return ubxFrame(ubxClassMON, ubxMONVer, payload)
+}
+
+// cfgRate encodes the UBX-CFG-RATE answer of a receiver
+func cfgRate(measurement uint16, navigation uint16) []byte {
+	payload := make([]byte, 6)
+	binary.LittleEndian.PutUint16(payload[0:], measurement)
+	binary.LittleEndian.PutUint16(payload[2:], navigation)
+	binary.LittleEndian.PutUint16(payload[4:], 1)
+	return ubxFrame(ubxClassCFG, ubxCFGRate, payload)
+}
+
+var zedF9P = monVer("EXT CORE 1.00 (3fda8e)", "00190000", "ROM BASE 0x118B2060", "FWVER=HPG 1.32",
+	"PROTVER=27.31", "MOD=ZED-F9P", "GPS;GLO;GAL;BDS", "QZSS")
+
+func TestUBXFrame(t *testing.T) {
+	if poll := ubxFrame(ubxClassMON, ubxMONVer, nil); !bytes.Equal(poll, []byte{0xb5, 0x62, 0x0a, 0x04, 0, 0, 0x0e, 0x34}) {
+		t.Errorf("unexpected UBX-MON-VER poll % x", poll)
+	}
+	if poll := ubxFrame(ubxClassCFG, ubxCFGRate, nil); !bytes.Equal(poll, []byte{0xb5, 0x62, 0x06, 0x08, 0, 0, 0x0e, 0x30}) {
+		t.Errorf("unexpected UBX-CFG-RATE poll % x", poll)
+	}
+}
+
+func TestParseReceiver(t *testing.T) {
+	corrupted := cfgRate(1000, 1)
+	corrupted[len(corrupted)-1]++
+	data := bytes.Join([][]byte{
+		[]byte("$GNGGA,120000.00,4717.11399,N,00833.91590,E,4,12,0.50,499.6,M,48.0,M,1.0,0000*5B\r\n"),
+		zedF9P, corrupted, []byte("$GNGSA,A,3,,,,,,,,,,,,,1.0,0.5,0.8,1*00\r\n"),
+	}, nil)
+
+	if ubxComplete(data) {
+		t.Error("expected the answers to be incomplete without the rate")
+	}
+	info, err := parseReceiver(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := receiverInfo{Software: "EXT CORE 1.00 (3fda8e)", Hardware: "00190000", Model: "ZED-F9P", Firmware: "HPG 1.32",
+		ProtocolVersion: "27.31", Constellations: []string{"GPS", "GLO", "GAL", "BDS", "QZSS"}}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("expected %+v, got %+v", expected, info)
+	}
+
+	data = append(data, cfgRate(100, 1)...)
+	if !ubxComplete(data) {
+		t.Error("expected the answers to be complete")
+	}
+	if info, _ := parseReceiver(data); info.MeasurementRate != 100 || info.NavigationRate != 1 {
+		t.Errorf("expected the configured rates, got %+v", info)
+	}
+
+	// Receivers refusing the rate poll answered all they could
+	nak := ubxFrame(ubxClassACK, ubxACKNAK, []byte{ubxClassCFG, ubxCFGRate})
+	if !ubxComplete(append(append([]byte{}, zedF9P...), nak...)) {
+		t.Error("expected a refused rate poll to complete the answers")
+	}
+
+	if _, err := parseReceiver([]byte("$GNGGA,,,,,,0,00,99.99,,,,,,*56\r\n")); err == nil {
+		t.Error("expected NMEA output alone to be refused")
+	}
+}
+
+func TestReceiverCapability(t *testing.T) {
+	tests := []struct {
+		info        receiverInfo
+		rtk         bool
+		baseStation bool
+	}{
+		{receiverInfo{Model: "ZED-F9P", Firmware: "HPG 1.32"}, true, true},
+		{receiverInfo{Model: "NEO-M8P-0", Firmware: "HPG 1.40"}, false, true},
+		{receiverInfo{Model: "ZED-F9R", Firmware: "HPS 1.30"}, true, false},
+		{receiverInfo{Model: "ZED-F9X", Firmware: "HPG 9.00"}, true, false},
+		{receiverInfo{Model: "NEO-M9N", Firmware: "SPG 4.04"}, false, false},
+	}
+	for _, test := range tests {
+		gnss := &GNSS{}
+		test.info.apply(gnss)
+		if gnss.RTK != test.rtk || gnss.BaseStation != test.baseStation {
+			t.Errorf("expected %s to be RTK %v and base station %v, got %+v", test.info.Model, test.rtk, test.baseStation, gnss)
+		}
+	}
+
+	gnss := &GNSS{}
+	receiverInfo{Software: "ROM CORE 3.01 (107888)", MeasurementRate: 125, NavigationRate: 2}.apply(gnss)
+	if gnss.Firmware != "ROM CORE 3.01 (107888)" || gnss.UpdateRate != 4 {
+		t.Errorf("unexpected receiver %+v", gnss)
+	}
+}
+
+func TestProbeGNSS(t *testing.T) {
+	sysfs := t.TempDir()
+	receiver := fakeUSBDevice(t, sysfs, "1-1", "1", "6")
+	fakeClassNode(t, sysfs, fmt.Sprintf("%s/1-1:1.0/ttyACM0", receiver), "tty", "ttyACM0")
+	device := Device{Bus: 1, Address: 6, VendorID: 0x1546, ProductID: 0x01a9, ProductName: "u-blox GNSS receiver"}
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs))
+	peripheral := Peripheral{}
+	d.probeGNSS(context.Background(), device, &peripheral)
+	if gnss := peripheral.GNSS; gnss == nil || gnss.SerialDevice != "/dev/ttyACM0" || gnss.Confirmed || gnss.RTK {
+		t.Fatalf("expected an unconfirmed receiver, got %+v", gnss)
+	}
+
+	// Receivers are polled once per attachment
+	d = NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs), WithGNSSProbe(time.Second))
+	info, _ := parseReceiver(append(append([]byte{}, zedF9P...), cfgRate(100, 1)...))
+	d.gnssCache[device.DevicePath()+"/dev/ttyACM0"] = info
+	peripheral = Peripheral{}
+	d.probeGNSS(context.Background(), device, &peripheral)
+	gnss := peripheral.GNSS
+	if !gnss.Confirmed || gnss.Model != "ZED-F9P" || !gnss.RTK || !gnss.BaseStation || gnss.UpdateRate != 10 {
+		t.Errorf("unexpected receiver %+v", gnss)
+	}
+	if details := classDetails(peripheral, uvcFormats{}); details == nil || details.Sensor == nil || details.Sensor.Kind != SensorGNSS {
+		t.Errorf("expected the receiver in the sensor section, got %+v", details)
+	}
+
+	// The scan which saw the receiver keeps it, the next one forgets it
+	d.pruneGNSSCache()
+	if len(d.gnssCache) != 1 {
+		t.Error("expected the attached receiver to be kept")
+	}
+	d.pruneGNSSCache()
+	if len(d.gnssCache) != 0 {
+		t.Error("expected the detached receiver to be forgotten")
+	}
+
+	peripheral = Peripheral{}
+	d.probeGNSS(context.Background(), Device{Bus: 1, Address: 6, VendorID: 0x0403, ProductID: 0x6001}, &peripheral)
+	if peripheral.GNSS != nil {
+		t.Errorf("expected other serial devices to be left out, got %+v", peripheral.GNSS)
+	}
+}
@@ -0,0 +1,243 @@
+package peripherals
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// HIDType is the kind of device behind a HID interface
+type HIDType string
+
+const (
+	HIDKeyboard       HIDType = "keyboard"
+	HIDMouse          HIDType = "mouse"
+	HIDGameController HIDType = "game-controller"
+	HIDConsumer       HIDType = "consumer-control"
+	HIDBarcodeScanner HIDType = "barcode-scanner"
+	HIDSensor         HIDType = "sensor"
+	HIDSecurityKey    HIDType = "security-key"
+	HIDVendorSpecific HIDType = "vendor-specific"
+	HIDOther          HIDType = "other"
+)
+
+// HID usage pages
+const (
+	usagePageGenericDesktop = 0x01
+	usagePageConsumer       = 0x0c
+	usagePageSensor         = 0x20
+	usagePageBarcodeScanner = 0x8c
+	usagePageVendorMin      = 0xff00
+)
+
+// Generic desktop usages
+const (
+	usagePointer  = 0x01
+	usageMouse    = 0x02
+	usageJoystick = 0x04
+	usageGamepad  = 0x05
+	usageKeyboard = 0x06
+	usageKeypad   = 0x07
+)
+
+// barcodeScannerVendors lists vendors whose HID keyboards are almost always
+// barcode scanners in keyboard wedge mode
+var barcodeScannerVendors = map[uint16]bool{
+	0x05e0: true, // Symbol / Zebra
+	0x05f9: true, // Datalogic
+	0x0c2e: true, // Honeywell (Metrologic)
+	0x1eab: true, // Newland
+	0x0536: true, // Hand Held Products
+	0x065a: true, // Opticon
+}
+
+// hidUsage is the usage of a top-level application collection
+type hidUsage struct {
+	Page  uint16
+	Usage uint16
+}
+
+// parseReportDescriptor returns the usages of the top-level collections of a
+// HID report descriptor. Malformed trailing items are ignored.
+func parseReportDescriptor(data []byte) []hidUsage {
+	var usages []hidUsage
+	var page uint16
+	var localUsages []uint32
+	depth := 0
+
+	for i := 0; i < len(data); {
+		prefix := data[i]
+
+		// Long items carry vendor data only
+		if prefix == 0xfe {
+			if i+1 >= len(data) {
+				break
+			}
+			i += 3 + int(data[i+1])
+			continue
+		}
+
+		size := int(prefix & 0x03)
+		if size == 3 {
+			size = 4
+		}
+		if i+1+size > len(data) {
+			break
+		}
+		value := littleEndian(data[i+1 : i+1+size])
+		itemType := (prefix >> 2) & 0x03
+		tag := prefix >> 4
+		i += 1 + size
+
+		switch itemType {
+		case 0: // Main
+			switch tag {
+			case 0x0a: // Collection
+				if depth == 0 && value == 0x01 && len(localUsages) > 0 {
+					usages = append(usages, resolveUsage(page, localUsages[0], size))
+				}
+				depth++
+			case 0x0c: // End collection
+				if depth > 0 {
+					depth--
+				}
+			}
+			localUsages = nil
+		case 1: // Global
+			if tag == 0x00 {
+				page = uint16(value)
+			}
+		case 2: // Local
+			if tag == 0x00 {
+				localUsages = append(localUsages, value)
+			}
+		}
+	}
+	return usages
+}
+
+// resolveUsage applies the current usage page unless the usage was given as
+// an extended 32 bits usage holding its own page
+func resolveUsage(page uint16, usage uint32, size int) hidUsage {
+	if size == 4 {
+		return hidUsage{Page: uint16(usage >> 16), Usage: uint16(usage)}
+	}
+	return hidUsage{Page: page, Usage: uint16(usage)}
+}
+
+func littleEndian(data []byte) uint32 {
+	var value uint32
+	for i, b := range data {
+		value |= uint32(b) << (8 * uint(i))
+	}
+	return value
+}
+
+// classifyHID maps the top-level usages of a HID interface to device types
+func classifyHID(usages []hidUsage, device Device) []HIDType {
+	seen := map[HIDType]bool{}
+	var types []HIDType
+	add := func(t HIDType) {
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+
+	for _, u := range usages {
+		switch {
+		case u.Page == usagePageBarcodeScanner:
+			add(HIDBarcodeScanner)
+		case u.Page == usagePageGenericDesktop && (u.Usage == usageKeyboard || u.Usage == usageKeypad):
+			if looksLikeBarcodeScanner(device) {
+				add(HIDBarcodeScanner)
+			} else {
+				add(HIDKeyboard)
+			}
+		case u.Page == usagePageGenericDesktop && (u.Usage == usageMouse || u.Usage == usagePointer):
+			add(HIDMouse)
+		case u.Page == usagePageGenericDesktop && (u.Usage == usageJoystick || u.Usage == usageGamepad):
+			add(HIDGameController)
+		case u.Page == usagePageConsumer:
+			add(HIDConsumer)
+		case u.Page == usagePageSensor:
+			add(HIDSensor)
+		case u.Page == usagePageFIDO:
+			add(HIDSecurityKey)
+		case u.Page >= usagePageVendorMin:
+			add(HIDVendorSpecific)
+		default:
+			add(HIDOther)
+		}
+	}
+	return types
+}
+
+// looksLikeBarcodeScanner tells keyboard wedge scanners apart from actual
+// keyboards, since both report the same usages
+func looksLikeBarcodeScanner(device Device) bool {
+	if barcodeScannerVendors[device.VendorID] {
+		return true
+	}
+	name := strings.ToLower(device.ProductName)
+	return strings.Contains(name, "barcode") || strings.Contains(name, "scanner")
+}
+
+// IsBarcodeScanner reports whether a discovered peripheral is a barcode
+// scanner, either from its HID usages or, for scanners in serial mode, from
+// its vendor and name
+func IsBarcodeScanner(peripheral Peripheral) bool {
+	for _, node := range peripheral.HID {
+		for _, t := range node.Types {
+			if t == HIDBarcodeScanner {
+				return true
+			}
+		}
+	}
+
+	var vendorID uint16
+	if _, err := fmt.Sscanf(peripheral.Identifier, "%04x:", &vendorID); err != nil {
+		return false
+	}
+	return looksLikeBarcodeScanner(Device{VendorID: vendorID, ProductName: peripheral.Name})
+}
+
+// DeviceNodes lists the hidraw and serial nodes of a peripheral
+func DeviceNodes(peripheral Peripheral) []string {
+	var nodes []string
+	for _, node := range peripheral.HID {
+		nodes = append(nodes, node.DevicePath)
+	}
+	return append(nodes, peripheral.SerialDevices...)
+}
+
+// probeHID decodes the report descriptors of the hidraw nodes of the device and
+// reports them in the "hid" attribute, along with the types of the device
+func (d *Discoverer) probeHID(device Device, peripheral *Peripheral) {
+	nodes := d.classNodes("hidraw", device)
+	if len(nodes) == 0 {
+		return
+	}
+
+	var interfaces []HIDInterface
+	for _, node := range nodes {
+		descriptorPath := filepath.Join(node.Path, "device", "report_descriptor")
+		descriptor, err := ioutil.ReadFile(descriptorPath)
+		if err != nil {
+			log.Debugf("Unable to read HID report descriptor %s. Reason: %s", descriptorPath, err)
+		}
+
+		types := classifyHID(parseReportDescriptor(descriptor), device)
+		if types == nil {
+			types = []HIDType{}
+		}
+		interfaces = append(interfaces, HIDInterface{
+			DevicePath: d.devDir + node.Name,
+			Types:      types,
+		})
+	}
+	peripheral.HID = interfaces
+}
@@ -0,0 +1,148 @@
+package peripherals
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var (
+	bootKeyboardDescriptor = []byte{
+		0x05, 0x01, 0x09, 0x06, 0xa1, 0x01, 0x05, 0x07, 0x19, 0xe0, 0x29, 0xe7,
+		0x15, 0x00, 0x25, 0x01, 0x75, 0x01, 0x95, 0x08, 0x81, 0x02, 0xc0,
+	}
+	mouseDescriptor = []byte{
+		0x05, 0x01, 0x09, 0x02, 0xa1, 0x01, 0x09, 0x01, 0xa1, 0x00, 0x05, 0x09,
+		0x19, 0x01, 0x29, 0x03, 0x81, 0x02, 0xc0, 0xc0,
+	}
+	vendorDescriptor = []byte{
+		0x06, 0x00, 0xff, 0x09, 0x01, 0xa1, 0x01, 0x15, 0x00, 0x26, 0xff, 0x00,
+		0x75, 0x08, 0x95, 0x40, 0x81, 0x02, 0xc0,
+	}
+	posScannerDescriptor = []byte{
+		0x05, 0x8c, 0x09, 0x02, 0xa1, 0x01, 0x09, 0x12, 0x81, 0x02, 0xc0,
+	}
+)
+
+func TestParseReportDescriptor(t *testing.T) {
+	composite := append(append([]byte{}, bootKeyboardDescriptor...), mouseDescriptor...)
+
+	usages := parseReportDescriptor(composite)
+	expected := []hidUsage{{Page: 0x01, Usage: 0x06}, {Page: 0x01, Usage: 0x02}}
+	if !reflect.DeepEqual(usages, expected) {
+		t.Errorf("expected %v, got %v", expected, usages)
+	}
+
+	if usages := parseReportDescriptor([]byte{0x05, 0x01, 0x09}); len(usages) != 0 {
+		t.Errorf("expected truncated descriptor to yield no usage, got %v", usages)
+	}
+}
+
+func TestClassifyHID(t *testing.T) {
+	keyboard := Device{VendorID: 0x046d, ProductName: "K120 Keyboard"}
+	scanner := Device{VendorID: 0x0c2e, ProductName: "Xenon 1900"}
+
+	cases := []struct {
+		descriptor []byte
+		device     Device
+		expected   []HIDType
+	}{
+		{bootKeyboardDescriptor, keyboard, []HIDType{HIDKeyboard}},
+		{bootKeyboardDescriptor, scanner, []HIDType{HIDBarcodeScanner}},
+		{bootKeyboardDescriptor, Device{ProductName: "USB Barcode Reader"}, []HIDType{HIDBarcodeScanner}},
+		{mouseDescriptor, keyboard, []HIDType{HIDMouse}},
+		{vendorDescriptor, keyboard, []HIDType{HIDVendorSpecific}},
+		{posScannerDescriptor, keyboard, []HIDType{HIDBarcodeScanner}},
+	}
+
+	for i, c := range cases {
+		types := classifyHID(parseReportDescriptor(c.descriptor), c.device)
+		if !reflect.DeepEqual(types, c.expected) {
+			t.Errorf("case %d: expected %v, got %v", i, c.expected, types)
+		}
+	}
+}
+
+// fakeUSBDevice creates the sysfs folder of a USB device and returns it
+func fakeUSBDevice(t *testing.T, sysfs string, name string, bus string, address string) string {
+	dir := filepath.Join(sysfs, "devices", "pci0000:00", "usb"+bus, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "busnum"), bus)
+	writeFile(t, filepath.Join(dir, "devnum"), address)
+	return dir
+}
+
+// fakeClassNode creates a class node, such as hidraw0, under the given sysfs
+// device folder and links it from /sys/class
+func fakeClassNode(t *testing.T, sysfs string, deviceDir string, class string, node string) string {
+	nodeDir := filepath.Join(deviceDir, class, node)
+	if err := os.MkdirAll(nodeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(deviceDir, filepath.Join(nodeDir, "device")); err != nil {
+		t.Fatal(err)
+	}
+	classDir := filepath.Join(sysfs, "class", class)
+	if err := os.MkdirAll(classDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(nodeDir, filepath.Join(classDir, node)); err != nil {
+		t.Fatal(err)
+	}
+	return nodeDir
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProbeHID(t *testing.T) {
+	sysfs := t.TempDir()
+	usbDir := fakeUSBDevice(t, sysfs, "1-1", "1", "4")
+	hidDir := filepath.Join(usbDir, "1-1:1.0", "0003:0C2E:0B61.0001")
+	if err := os.MkdirAll(hidDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(hidDir, "report_descriptor"), string(bootKeyboardDescriptor))
+	fakeClassNode(t, sysfs, hidDir, "hidraw", "hidraw0")
+
+	otherDir := fakeUSBDevice(t, sysfs, "1-2", "1", "5")
+	fakeClassNode(t, sysfs, otherDir, "hidraw", "hidraw1")
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs))
+	peripheral := Peripheral{}
+	d.probeHID(Device{Bus: 1, Address: 4, VendorID: 0x0c2e}, &peripheral)
+
+	expected := []HIDInterface{{DevicePath: "/dev/hidraw0", Types: []HIDType{HIDBarcodeScanner}}}
+	if !reflect.DeepEqual(peripheral.HID, expected) {
+		t.Errorf("expected %v, got %v", expected, peripheral.HID)
+	}
+}
+
+func TestIsBarcodeScanner(t *testing.T) {
+	hidScanner := Peripheral{
+		Identifier: "ffff:0001",
+		HID:        []HIDInterface{{DevicePath: "/dev/hidraw0", Types: []HIDType{HIDBarcodeScanner}}},
+	}
+	serialScanner := Peripheral{Identifier: "0c2e:0b6a", SerialDevices: []string{"/dev/ttyACM0"}}
+	keyboard := Peripheral{
+		Identifier: "046d:c31c",
+		Name:       "Keyboard K120",
+		HID:        []HIDInterface{{DevicePath: "/dev/hidraw1", Types: []HIDType{HIDKeyboard}}},
+	}
+
+	if !IsBarcodeScanner(hidScanner) || !IsBarcodeScanner(serialScanner) {
+		t.Error("expected scanners to be recognised")
+	}
+	if IsBarcodeScanner(keyboard) {
+		t.Error("expected keyboard not to be a scanner")
+	}
+	if nodes := DeviceNodes(serialScanner); !reflect.DeepEqual(nodes, []string{"/dev/ttyACM0"}) {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+}
@@ -0,0 +1,135 @@
+package peripherals
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// containerID matches the 64 hexadecimal digits ID of a container in a
+// cgroup path, such as /docker/<id> or /system.slice/cri-containerd-<id>.scope
+var containerID = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// openNodes lists the processes holding each device node open, by node path.
+// Only the processes the manager can see are listed: the ones of its own
+// container, or all of them when it shares the host PID namespace.
+func (d *Discoverer) openNodes(nodes map[string]bool) map[string][]Holder {
+	holders := map[string][]Holder{}
+
+	entries, err := ioutil.ReadDir(d.procDir)
+	if err != nil {
+		logSysfsError(d.procDir, err)
+		return holders
+	}
+
+	self := os.Getpid()
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		// The manager probes the devices itself, it does not hold them
+		if err != nil || pid == self {
+			continue
+		}
+
+		fdDir := filepath.Join(d.procDir, entry.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		// Processes of other users, or gone since the listing
+		if err != nil {
+			continue
+		}
+
+		var held []string
+		seen := map[string]bool{}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !nodes[target] || seen[target] {
+				continue
+			}
+			seen[target] = true
+			held = append(held, target)
+		}
+		if len(held) == 0 {
+			continue
+		}
+
+		holder := Holder{PID: pid, Container: d.processContainer(entry.Name())}
+		holder.Command, _ = readSysfsString(filepath.Join(d.procDir, entry.Name(), "comm"))
+		for _, node := range held {
+			entry := holder
+			entry.DevicePath = node
+			holders[node] = append(holders[node], entry)
+		}
+	}
+	return holders
+}
+
+// processContainer returns the short ID of the container a process runs in,
+// from its cgroup, or an empty string for the host processes
+func (d *Discoverer) processContainer(pid string) string {
+	cgroup, err := readSysfsString(filepath.Join(d.procDir, pid, "cgroup"))
+	if err != nil {
+		return ""
+	}
+	ids := containerID.FindAllString(cgroup, -1)
+	if len(ids) == 0 {
+		return ""
+	}
+	return ids[len(ids)-1][:12]
+}
+
+// deviceNodes lists the device nodes of a peripheral
+func deviceNodes(peripheral Peripheral) []string {
+	nodes := []string{peripheral.DevicePath, peripheral.VideoDevice}
+	nodes = append(nodes, peripheral.VideoDevices...)
+	nodes = append(nodes, peripheral.SerialDevices...)
+	for _, adapter := range peripheral.DVBAdapters {
+		nodes = append(nodes, adapter.Frontend)
+	}
+	for _, node := range peripheral.HID {
+		nodes = append(nodes, node.DevicePath)
+	}
+	for _, disk := range peripheral.Storage {
+		nodes = append(nodes, disk.DevicePath)
+		nodes = append(nodes, disk.Partitions...)
+	}
+	if peripheral.Modem != nil {
+		nodes = append(nodes, peripheral.Modem.ControlDevice)
+	}
+	return nodes
+}
+
+// attributeHolders reports, for every peripheral, the processes holding its
+// device nodes open
+func (d *Discoverer) attributeHolders(peripherals []Peripheral) {
+	nodes := map[string]bool{}
+	for _, peripheral := range peripherals {
+		for _, node := range deviceNodes(peripheral) {
+			if len(node) > 0 {
+				nodes[node] = true
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+
+	open := d.openNodes(nodes)
+	for i := range peripherals {
+		var holders []Holder
+		for _, node := range deviceNodes(peripherals[i]) {
+			holders = append(holders, open[node]...)
+			// Nodes listed twice, like the video device of a camera, are only
+			// reported once
+			delete(open, node)
+		}
+		sort.Slice(holders, func(a, b int) bool {
+			if holders[a].DevicePath != holders[b].DevicePath {
+				return holders[a].DevicePath < holders[b].DevicePath
+			}
+			return holders[a].PID < holders[b].PID
+		})
+		peripherals[i].Holders = holders
+	}
+}
@@ -0,0 +1,54 @@
+package peripherals
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func fakeProcess(t *testing.T, procDir string, pid string, command string, cgroup string, files ...string) {
+	fdDir := filepath.Join(procDir, pid, "fd")
+	if err := os.MkdirAll(fdDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(procDir, pid, "comm"), command+"\n")
+	writeFile(t, filepath.Join(procDir, pid, "cgroup"), cgroup)
+	for i, file := range files {
+		if err := os.Symlink(file, filepath.Join(fdDir, string(rune('3'+i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAttributeHolders(t *testing.T) {
+	procDir := t.TempDir()
+	container := strings.Repeat("3f1c", 16)
+	fakeProcess(t, procDir, "120", "ffmpeg", "0::/system.slice/docker-"+container+".scope\n",
+		"/dev/video0", "/dev/video0", "socket:[4242]")
+	fakeProcess(t, procDir, "340", "minicom", "0::/user.slice/user-1000.slice\n", "/dev/ttyACM0", "/dev/null")
+	fakeProcess(t, procDir, "560", "bash", "0::/init.scope\n", "/dev/pts/0")
+	if err := os.MkdirAll(filepath.Join(procDir, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDiscoverer(&fakeBackend{}, WithProcDir(procDir), WithHolders())
+	discovered := []Peripheral{
+		{Identifier: "046d:0825", DevicePath: "/dev/bus/usb/001/004", VideoDevice: "/dev/video0"},
+		{Identifier: "2341:0043", DevicePath: "/dev/bus/usb/001/005", SerialDevices: []string{"/dev/ttyACM0"}},
+		{Identifier: "0c2e:0b61", DevicePath: "/dev/bus/usb/001/006"},
+	}
+	d.attributeHolders(discovered)
+
+	expected := [][]Holder{
+		{{DevicePath: "/dev/video0", PID: 120, Command: "ffmpeg", Container: container[:12]}},
+		{{DevicePath: "/dev/ttyACM0", PID: 340, Command: "minicom"}},
+		nil,
+	}
+	for i, peripheral := range discovered {
+		if !reflect.DeepEqual(peripheral.Holders, expected[i]) {
+			t.Errorf("%s: expected holders %+v, got %+v", peripheral.Identifier, expected[i], peripheral.Holders)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Package libusb implements the peripherals.Backend on top of libusb, through
+// gousb, with device names resolved from the bundled USB ID database.
+package libusb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/gousb"
+	"github.com/google/gousb/usbid"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/dfu"
+)
+
+// Backend lists devices through a libusb context
+type Backend struct {
+	ctx     *gousb.Context
+	onVisit peripherals.VisitFunc
+}
+
+// New initialises libusb. It fails when the host has no usable USB stack.
+func New() (b *Backend, err error) {
+	// gousb panics when libusb cannot be initialised
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: unable to initialise libusb: %v", peripherals.ErrNoContext, r)
+		}
+	}()
+
+	// Only one context should be needed for an application.  It should always be closed.
+	return &Backend{ctx: gousb.NewContext()}, nil
+}
+
+// OnVisit registers a function called for each device before it is decoded,
+// so a crash can be attributed to the descriptor that caused it
+func (b *Backend) OnVisit(f peripherals.VisitFunc) {
+	b.onVisit = f
+}
+
+// Close releases the libusb context
+func (b *Backend) Close() error {
+	return b.ctx.Close()
+}
+
+// Devices lists the attached devices from their descriptors, without opening
+// any of them
+func (b *Backend) Devices(ctx context.Context) ([]peripherals.Device, error) {
+	var devices []peripherals.Device
+
+	_, devErr := b.ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+
+		device := peripherals.Device{
+			Bus:       desc.Bus,
+			Address:   desc.Address,
+			VendorID:  uint16(desc.Vendor),
+			ProductID: uint16(desc.Product),
+			Revision:  uint16(desc.Device),
+			Speed:     speed(desc.Speed),
+		}
+
+		if b.onVisit != nil {
+			b.onVisit(device.Identifier(), device.DevicePath())
+		}
+
+		vendor := usbid.Vendors[desc.Vendor]
+		if vendor != nil {
+			device.VendorName = vendor.Name
+			if product := vendor.Product[desc.Product]; product != nil {
+				device.ProductName = product.String()
+			}
+		}
+
+		device.Classification = usbid.Classify(desc)
+		device.Interfaces = interfaceSettings(desc)
+
+		devices = append(devices, device)
+		return false
+	})
+
+	if err := ctx.Err(); err != nil {
+		return devices, err
+	}
+	return devices, devErr
+}
+
+func interfaceSettings(desc *gousb.DeviceDesc) []peripherals.InterfaceSetting {
+	var settings []peripherals.InterfaceSetting
+
+	// Configs is a map, sort it so classes are always listed in the same order
+	configs := make([]int, 0, len(desc.Configs))
+	for number := range desc.Configs {
+		configs = append(configs, number)
+	}
+	sort.Ints(configs)
+
+	for _, number := range configs {
+		for _, intf := range desc.Configs[number].Interfaces {
+			for _, ifSetting := range intf.AltSettings {
+				settings = append(settings, peripherals.InterfaceSetting{
+					Number:    ifSetting.Number,
+					Alternate: ifSetting.Alternate,
+					Class:     uint8(ifSetting.Class),
+					SubClass:  uint8(ifSetting.SubClass),
+					Protocol:  uint8(ifSetting.Protocol),
+					ClassName: className(ifSetting.Class),
+					Endpoints: endpoints(ifSetting),
+				})
+			}
+		}
+	}
+	return settings
+}
+
+func endpoints(setting gousb.InterfaceSetting) []peripherals.Endpoint {
+	// Endpoints is a map too
+	list := make([]peripherals.Endpoint, 0, len(setting.Endpoints))
+	for _, desc := range setting.Endpoints {
+		list = append(list, peripherals.Endpoint{
+			Number:        desc.Number,
+			In:            desc.Direction == gousb.EndpointDirectionIn,
+			Transfer:      transferType(desc.TransferType),
+			MaxPacketSize: desc.MaxPacketSize,
+			Interval:      desc.PollInterval,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Number != list[j].Number {
+			return list[i].Number < list[j].Number
+		}
+		return !list[i].In && list[j].In
+	})
+	return list
+}
+
+func transferType(t gousb.TransferType) string {
+	switch t {
+	case gousb.TransferTypeIsochronous:
+		return peripherals.TransferIsochronous
+	case gousb.TransferTypeBulk:
+		return peripherals.TransferBulk
+	case gousb.TransferTypeInterrupt:
+		return peripherals.TransferInterrupt
+	}
+	return peripherals.TransferControl
+}
+
+func speed(s gousb.Speed) peripherals.Speed {
+	switch s {
+	case gousb.SpeedLow:
+		return peripherals.SpeedLow
+	case gousb.SpeedFull:
+		return peripherals.SpeedFull
+	case gousb.SpeedHigh:
+		return peripherals.SpeedHigh
+	case gousb.SpeedSuper:
+		return peripherals.SpeedSuper
+	}
+	return peripherals.SpeedUnknown
+}
+
+func className(class gousb.Class) string {
+	if c := usbid.Classes[class]; c != nil {
+		return c.String()
+	}
+	return "unknown"
+}
+
+// OpenDFU opens the device at the given bus and address to flash it. It
+// implements dfu.Opener.
+func (b *Backend) OpenDFU(bus int, address int) (dfu.Device, error) {
+	devices, err := b.ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return desc.Bus == bus && desc.Address == address
+	})
+	if len(devices) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no device at bus %d address %d", bus, address)
+		}
+		return nil, err
+	}
+	for _, extra := range devices[1:] {
+		extra.Close()
+	}
+	return devices[0], nil
+}
+
+// Reset resets a device, which the kernel then sets up again as if it was
+// reattached
+func (b *Backend) Reset(ctx context.Context, device peripherals.Device) error {
+	devices, err := b.ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return desc.Bus == device.Bus && desc.Address == device.Address
+	})
+	for _, d := range devices {
+		defer d.Close()
+	}
+	if len(devices) == 0 {
+		if err == nil {
+			err = fmt.Errorf("no device at bus %d address %d", device.Bus, device.Address)
+		}
+		return err
+	}
+	return devices[0].Reset()
+}
@@ -0,0 +1,284 @@
+package peripherals
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Control protocols of cellular modems
+const (
+	ModemQMI  = "qmi"
+	ModemMBIM = "mbim"
+)
+
+// modemDrivers maps the drivers of modem control interfaces to their protocol
+var modemDrivers = map[string]string{
+	"qmi_wwan": ModemQMI,
+	"cdc_mbim": ModemMBIM,
+}
+
+// ModemQuerier reads the identity, SIM and network state of a cellular modem
+type ModemQuerier interface {
+	Query(ctx context.Context, modem Modem) (Modem, error)
+}
+
+// CLIModemQuerier queries modems through ModemManager with mmcli, and falls
+// back on talking QMI or MBIM to the control device with qmicli or mbimcli
+// when ModemManager does not run
+type CLIModemQuerier struct {
+	// Run runs a command and returns its standard output. Commands are
+	// executed when it is nil.
+	Run func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+func (q CLIModemQuerier) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if q.Run != nil {
+		return q.Run(ctx, name, args...)
+	}
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// Query fills in the modem attributes, leaving the control device and
+// protocol untouched
+func (q CLIModemQuerier) Query(ctx context.Context, modem Modem) (Modem, error) {
+	queried, mmErr := q.queryModemManager(ctx, modem)
+	if mmErr == nil {
+		return queried, nil
+	}
+
+	var err error
+	switch modem.Protocol {
+	case ModemQMI:
+		queried, err = q.queryQMI(ctx, modem)
+	case ModemMBIM:
+		queried, err = q.queryMBIM(ctx, modem)
+	default:
+		err = fmt.Errorf("unknown protocol %q", modem.Protocol)
+	}
+	if err != nil {
+		return modem, fmt.Errorf("%w. ModemManager: %s", err, mmErr)
+	}
+	return queried, nil
+}
+
+// mmcliModem holds the attributes of mmcli --output-json --modem
+type mmcliModem struct {
+	Modem struct {
+		ThreeGPP struct {
+			IMEI         string `json:"imei"`
+			OperatorCode string `json:"operator-code"`
+			OperatorName string `json:"operator-name"`
+		} `json:"3gpp"`
+		Generic struct {
+			AccessTechnologies []string `json:"access-technologies"`
+			EquipmentID        string   `json:"equipment-identifier"`
+			Ports              []string `json:"ports"`
+			SIM                string   `json:"sim"`
+			SignalQuality      struct {
+				Value string `json:"value"`
+			} `json:"signal-quality"`
+			State string `json:"state"`
+		} `json:"generic"`
+	} `json:"modem"`
+}
+
+func (q CLIModemQuerier) queryModemManager(ctx context.Context, modem Modem) (Modem, error) {
+	output, err := q.run(ctx, "mmcli", "--output-json", "--list-modems")
+	if err != nil {
+		return modem, err
+	}
+	var list struct {
+		Modems []string `json:"modem-list"`
+	}
+	if err := json.Unmarshal(output, &list); err != nil {
+		return modem, err
+	}
+
+	port := filepath.Base(modem.ControlDevice)
+	for _, path := range list.Modems {
+		output, err := q.run(ctx, "mmcli", "--output-json", "--modem", path)
+		if err != nil {
+			return modem, err
+		}
+		var info mmcliModem
+		if err := json.Unmarshal(output, &info); err != nil {
+			return modem, err
+		}
+		if !hasModemPort(info.Modem.Generic.Ports, port) {
+			continue
+		}
+
+		generic := info.Modem.Generic
+		modem.Source = "modemmanager"
+		modem.IMEI = info.Modem.ThreeGPP.IMEI
+		if len(modem.IMEI) == 0 {
+			modem.IMEI = generic.EquipmentID
+		}
+		simPresent := len(generic.SIM) > 0 && generic.SIM != "--"
+		modem.SIMPresent = &simPresent
+		modem.Operator = unknownAsEmpty(info.Modem.ThreeGPP.OperatorName)
+		modem.OperatorCode = unknownAsEmpty(info.Modem.ThreeGPP.OperatorCode)
+		modem.State = unknownAsEmpty(generic.State)
+		modem.AccessTechnologies = generic.AccessTechnologies
+		if quality, err := strconv.Atoi(generic.SignalQuality.Value); err == nil {
+			modem.SignalQuality = &quality
+		}
+		return modem, nil
+	}
+	return modem, fmt.Errorf("ModemManager does not manage %s", port)
+}
+
+// hasModemPort tells whether a port of mmcli, such as "cdc-wdm0 (qmi)", is
+// the given device node
+func hasModemPort(ports []string, name string) bool {
+	for _, port := range ports {
+		if fields := strings.Fields(port); len(fields) > 0 && fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func unknownAsEmpty(value string) string {
+	if value == "--" || value == "unknown" {
+		return ""
+	}
+	return value
+}
+
+// quotedField matches the "Name: 'value'" lines printed by qmicli and mbimcli
+var quotedField = regexp.MustCompile(`(?m)^\s*([^:\n]+?):\s*'([^'\n]*)'`)
+
+// quotedFields returns the quoted values of a qmicli or mbimcli output, by
+// name. Names repeated in several sections keep their first value.
+func quotedFields(output []byte) map[string]string {
+	fields := map[string]string{}
+	for _, match := range quotedField.FindAllSubmatch(output, -1) {
+		name := string(match[1])
+		if _, exists := fields[name]; !exists {
+			fields[name] = string(match[2])
+		}
+	}
+	return fields
+}
+
+// rssiDBM matches the strength of qmicli, such as "Network 'lte': '-63 dBm'"
+var rssiDBM = regexp.MustCompile(`'(-?\d+) dBm'`)
+
+// qmiSignalQuality turns an RSSI into a percentage over the -113 to -51 dBm
+// range, as ModemManager does
+func qmiSignalQuality(rssi int) int {
+	quality := (rssi + 113) * 100 / (113 - 51)
+	if quality < 0 {
+		return 0
+	}
+	if quality > 100 {
+		return 100
+	}
+	return quality
+}
+
+func (q CLIModemQuerier) queryQMI(ctx context.Context, modem Modem) (Modem, error) {
+	qmi := func(command string) ([]byte, error) {
+		return q.run(ctx, "qmicli", "--device", modem.ControlDevice, "--device-open-proxy", command)
+	}
+
+	ids, err := qmi("--dms-get-ids")
+	if err != nil {
+		return modem, fmt.Errorf("qmicli: %w", err)
+	}
+	modem.Source = "qmicli"
+	modem.IMEI = unknownAsEmpty(quotedFields(ids)["IMEI"])
+
+	if status, err := qmi("--uim-get-card-status"); err == nil {
+		if state, ok := quotedFields(status)["Card state"]; ok {
+			simPresent := state == "present"
+			modem.SIMPresent = &simPresent
+		}
+	}
+	if network, err := qmi("--nas-get-home-network"); err == nil {
+		fields := quotedFields(network)
+		modem.Operator = fields["Description"]
+		if len(fields["MCC"]) > 0 && len(fields["MNC"]) > 0 {
+			modem.OperatorCode = fields["MCC"] + fields["MNC"]
+		}
+	}
+	if strength, err := qmi("--nas-get-signal-strength"); err == nil {
+		if match := rssiDBM.FindSubmatch(strength); match != nil {
+			rssi, _ := strconv.Atoi(string(match[1]))
+			quality := qmiSignalQuality(rssi)
+			modem.SignalQuality = &quality
+		}
+	}
+	return modem, nil
+}
+
+func (q CLIModemQuerier) queryMBIM(ctx context.Context, modem Modem) (Modem, error) {
+	mbim := func(command string) ([]byte, error) {
+		return q.run(ctx, "mbimcli", "--device", modem.ControlDevice, "--device-open-proxy", command)
+	}
+
+	caps, err := mbim("--query-device-caps")
+	if err != nil {
+		return modem, fmt.Errorf("mbimcli: %w", err)
+	}
+	modem.Source = "mbimcli"
+	modem.IMEI = quotedFields(caps)["Device ID"]
+
+	if status, err := mbim("--query-subscriber-ready-status"); err == nil {
+		if state, ok := quotedFields(status)["Ready state"]; ok {
+			simPresent := state != "sim-not-inserted" && state != "bad-sim"
+			modem.SIMPresent = &simPresent
+		}
+	}
+	if provider, err := mbim("--query-home-provider"); err == nil {
+		fields := quotedFields(provider)
+		modem.Operator = fields["Provider name"]
+		modem.OperatorCode = fields["Provider ID"]
+	}
+	if signal, err := mbim("--query-signal-state"); err == nil {
+		// The RSSI is coded from 0 to 31, 99 when unknown
+		if rssi, err := strconv.Atoi(quotedFields(signal)["RSSI [0-31,99]"]); err == nil && rssi <= 31 {
+			quality := rssi * 100 / 31
+			modem.SignalQuality = &quality
+		}
+	}
+	return modem, nil
+}
+
+// probeModem detects the QMI or MBIM control interface of a cellular modem
+// and queries the modem through it
+func (d *Discoverer) probeModem(ctx context.Context, device Device, peripheral *Peripheral) {
+	// The cdc-wdm nodes belong to the interface bound to the modem driver
+	var modem *Modem
+	for _, node := range d.classNodes("usbmisc", device) {
+		driver, err := filepath.EvalSymlinks(filepath.Join(node.Path, "device", "driver"))
+		if err != nil {
+			continue
+		}
+		if protocol, ok := modemDrivers[filepath.Base(driver)]; ok {
+			modem = &Modem{Protocol: protocol, ControlDevice: d.devDir + node.Name}
+			break
+		}
+	}
+	if modem == nil {
+		return
+	}
+
+	if d.modems != nil && ctx.Err() == nil {
+		queried, err := d.modems.Query(ctx, *modem)
+		if err != nil {
+			log.Debugf("Unable to query modem %s. Reason: %s", modem.ControlDevice, err)
+		}
+		modem = &queried
+	}
+	peripheral.Modem = modem
+}
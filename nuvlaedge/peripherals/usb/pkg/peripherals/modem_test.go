@@ -0,0 +1,120 @@
+package peripherals
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeCommands answers the commands of a CLIModemQuerier with canned outputs,
+// keyed by the command line
+type fakeCommands map[string]string
+
+func (f fakeCommands) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, ok := f[name+" "+strings.Join(args, " ")]
+	if !ok {
+		return nil, errors.New("executable file not found in $PATH")
+	}
+	return []byte(output), nil
+}
+
+func TestCLIModemQuerierModemManager(t *testing.T) {
+	querier := CLIModemQuerier{Run: fakeCommands{
+		"mmcli --output-json --list-modems":                                  `{"modem-list": ["/org/freedesktop/ModemManager1/Modem/1", "/org/freedesktop/ModemManager1/Modem/0"]}`,
+		"mmcli --output-json --modem /org/freedesktop/ModemManager1/Modem/1": `{"modem": {"generic": {"ports": ["cdc-wdm1 (qmi)"]}}}`,
+		"mmcli --output-json --modem /org/freedesktop/ModemManager1/Modem/0": `{"modem": {
+			"3gpp": {"imei": "359225050000012", "operator-code": "22801", "operator-name": "Swisscom"},
+			"generic": {
+				"access-technologies": ["lte"],
+				"ports": ["cdc-wdm0 (qmi)", "wwan0 (net)"],
+				"sim": "/org/freedesktop/ModemManager1/SIM/0",
+				"signal-quality": {"recent": "yes", "value": "74"},
+				"state": "connected"
+			}}}`,
+	}.run}
+
+	modem, err := querier.Query(context.Background(), Modem{Protocol: ModemQMI, ControlDevice: "/dev/cdc-wdm0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modem.Source != "modemmanager" || modem.IMEI != "359225050000012" || modem.Operator != "Swisscom" ||
+		modem.OperatorCode != "22801" || modem.State != "connected" {
+		t.Errorf("unexpected modem %+v", modem)
+	}
+	if modem.SIMPresent == nil || !*modem.SIMPresent || modem.SignalQuality == nil || *modem.SignalQuality != 74 {
+		t.Errorf("unexpected SIM or signal in %+v", modem)
+	}
+}
+
+func TestCLIModemQuerierQMIAndMBIM(t *testing.T) {
+	querier := CLIModemQuerier{Run: fakeCommands{
+		"qmicli --device /dev/cdc-wdm0 --device-open-proxy --dms-get-ids": "[/dev/cdc-wdm0] Device IDs retrieved:\n" +
+			"\t ESN: '0'\n\tIMEI: '359225050000012'\n\tMEID: 'unknown'\n",
+		"qmicli --device /dev/cdc-wdm0 --device-open-proxy --uim-get-card-status": "[/dev/cdc-wdm0] Successfully got card status\n" +
+			"Slot [1]:\n\tCard state: 'absent'\n",
+		"qmicli --device /dev/cdc-wdm0 --device-open-proxy --nas-get-signal-strength": "[/dev/cdc-wdm0] Successfully got signal strength\n" +
+			"Current:\n\tNetwork 'lte': '-82 dBm'\n",
+
+		"mbimcli --device /dev/cdc-wdm1 --device-open-proxy --query-device-caps": "[/dev/cdc-wdm1] Device capabilities retrieved:\n" +
+			"\t  Device type: 'remote'\n\t    Device ID: '866758040000034'\n",
+		"mbimcli --device /dev/cdc-wdm1 --device-open-proxy --query-subscriber-ready-status": "\t Ready state: 'initialized'\n",
+		"mbimcli --device /dev/cdc-wdm1 --device-open-proxy --query-home-provider":           "\t  Provider ID: '20801'\n\tProvider name: 'Orange F'\n",
+		"mbimcli --device /dev/cdc-wdm1 --device-open-proxy --query-signal-state":            "\t  RSSI [0-31,99]: '20'\n",
+	}.run}
+
+	qmi, err := querier.Query(context.Background(), Modem{Protocol: ModemQMI, ControlDevice: "/dev/cdc-wdm0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qmi.Source != "qmicli" || qmi.IMEI != "359225050000012" || qmi.SIMPresent == nil || *qmi.SIMPresent {
+		t.Errorf("unexpected QMI modem %+v", qmi)
+	}
+	if qmi.SignalQuality == nil || *qmi.SignalQuality != 50 {
+		t.Errorf("expected a signal quality of 50%%, got %v", qmi.SignalQuality)
+	}
+
+	mbim, err := querier.Query(context.Background(), Modem{Protocol: ModemMBIM, ControlDevice: "/dev/cdc-wdm1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mbim.IMEI != "866758040000034" || mbim.Operator != "Orange F" || mbim.OperatorCode != "20801" ||
+		mbim.SIMPresent == nil || !*mbim.SIMPresent || mbim.SignalQuality == nil || *mbim.SignalQuality != 64 {
+		t.Errorf("unexpected MBIM modem %+v", mbim)
+	}
+
+	if _, err := querier.Query(context.Background(), Modem{Protocol: ModemQMI, ControlDevice: "/dev/cdc-wdm2"}); err == nil {
+		t.Error("expected a query without any tool to fail")
+	}
+}
+
+func TestProbeModem(t *testing.T) {
+	sysfs := t.TempDir()
+	modem := fakeUSBDevice(t, sysfs, "1-3", "1", "8")
+	iface := filepath.Join(modem, "1-3:1.4")
+	fakeClassNode(t, sysfs, iface, "usbmisc", "cdc-wdm0")
+	driver := filepath.Join(sysfs, "bus", "usb", "drivers", "qmi_wwan")
+	if err := os.MkdirAll(driver, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(driver, filepath.Join(iface, "driver")); err != nil {
+		t.Fatal(err)
+	}
+
+	devDir := t.TempDir() + "/"
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs), WithDevDir(devDir), WithModemQuerier(nil))
+	peripheral := Peripheral{}
+	d.probeModem(context.Background(), Device{Bus: 1, Address: 8}, &peripheral)
+
+	if peripheral.Modem == nil || peripheral.Modem.Protocol != ModemQMI || peripheral.Modem.ControlDevice != devDir+"cdc-wdm0" {
+		t.Fatalf("expected a QMI modem, got %+v", peripheral.Modem)
+	}
+
+	other := Peripheral{}
+	d.probeModem(context.Background(), Device{Bus: 1, Address: 9}, &other)
+	if other.Modem != nil {
+		t.Errorf("expected no modem, got %+v", other.Modem)
+	}
+}
@@ -0,0 +1,214 @@
+package peripherals
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// p1CableIDs lists the USB to serial converters P1 smart meter cables are
+// built on. They are generic chips, so a match alone only makes the device a
+// candidate, confirmed by reading a DSMR telegram.
+var p1CableIDs = map[string]bool{
+	"0403:6001": true, // FTDI FT232R
+	"0403:6014": true, // FTDI FT232H
+	"0403:6015": true, // FTDI FT-X
+}
+
+var obisPattern = regexp.MustCompile(`^(\d+-\d+:\d+\.\d+\.\d+)\(([^)]*)\)`)
+
+// maxTelegramSize bounds how much is read from a meter while looking for a
+// complete telegram
+const maxTelegramSize = 8192
+
+// telegram holds the identification of a meter, read from a DSMR telegram
+type telegram struct {
+	Header  string
+	Version string
+	MeterID string
+}
+
+// isP1Candidate reports whether the device may be a P1 cable
+func isP1Candidate(device Device) bool {
+	return p1CableIDs[device.Identifier()] || strings.Contains(strings.ToUpper(device.ProductName), "P1")
+}
+
+// parseTelegram decodes a DSMR telegram, from its "/" header to its "!" end.
+// DSMR 4 and later telegrams end with a CRC16 that must match.
+func parseTelegram(data []byte) (telegram, error) {
+	start := bytes.IndexByte(data, '/')
+	if start < 0 {
+		return telegram{}, errors.New("no telegram header")
+	}
+	end := bytes.IndexByte(data[start:], '!')
+	if end < 0 {
+		return telegram{}, errors.New("incomplete telegram")
+	}
+	end += start
+
+	trailer := strings.TrimSpace(string(data[end+1:]))
+	if len(trailer) >= 4 {
+		expected, err := strconv.ParseUint(trailer[:4], 16, 16)
+		if err != nil {
+			return telegram{}, fmt.Errorf("malformed telegram CRC %q", trailer[:4])
+		}
+		if crc := crc16(data[start : end+1]); uint16(expected) != crc {
+			return telegram{}, fmt.Errorf("telegram CRC mismatch: got %04X, expected %04X", crc, expected)
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(data[start+1:end]), "\r", ""), "\n")
+	t := telegram{Header: strings.TrimSpace(lines[0])}
+	for _, line := range lines[1:] {
+		match := obisPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		switch match[1] {
+		case "1-3:0.2.8":
+			t.Version = dsmrVersion(match[2])
+		case "0-0:96.1.1", "0-0:96.1.0":
+			t.MeterID = decodeMeterID(match[2])
+		}
+	}
+	return t, nil
+}
+
+// dsmrVersion turns the two digits version of a DSMR telegram, such as 50,
+// into 5.0
+func dsmrVersion(raw string) string {
+	if len(raw) != 2 {
+		return raw
+	}
+	return raw[:1] + "." + raw[1:]
+}
+
+// decodeMeterID decodes the equipment identifier, which meters send as the
+// hexadecimal encoding of an ASCII string
+func decodeMeterID(raw string) string {
+	decoded, err := hex.DecodeString(raw)
+	if err != nil {
+		return raw
+	}
+	for _, b := range decoded {
+		if b < 0x20 || b > 0x7e {
+			return raw
+		}
+	}
+	return string(decoded)
+}
+
+// crc16 is the CRC16/ARC checksum of DSMR telegrams
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xa001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// probeSmartMeter reports P1 cable candidates as smart meters. With telegram
+// probing enabled, the serial port is read once per attached cable to confirm
+// a meter answers and to get its identifier.
+func (d *Discoverer) probeSmartMeter(ctx context.Context, device Device, peripheral *Peripheral) {
+	if !isP1Candidate(device) {
+		return
+	}
+	nodes := d.classNodes("tty", device)
+	if len(nodes) == 0 {
+		return
+	}
+	serialDevice := d.devDir + nodes[0].Name
+
+	meter := &SmartMeter{Interface: "P1", SerialDevice: serialDevice}
+	peripheral.SmartMeter = meter
+
+	if d.p1Timeout <= 0 {
+		return
+	}
+
+	cacheKey := device.DevicePath() + serialDevice
+	d.p1Seen[cacheKey] = true
+	t, cached := d.p1Cache[cacheKey]
+	if !cached {
+		data, err := readTelegram(ctx, serialDevice, d.p1Timeout)
+		if err == nil {
+			t, err = parseTelegram(data)
+		}
+		if err != nil {
+			log.Infof("No DSMR telegram read from %s. Reason: %s", serialDevice, err)
+			return
+		}
+		d.p1Cache[cacheKey] = t
+	}
+
+	meter.Confirmed = true
+	meter.Header = t.Header
+	meter.DSMRVersion = t.Version
+	meter.MeterID = t.MeterID
+}
+
+// pruneP1Cache forgets the telegrams of the cables that were not seen in the
+// last scan, so a meter plugged back in is probed again
+func (d *Discoverer) pruneP1Cache() {
+	for key := range d.p1Cache {
+		if !d.p1Seen[key] {
+			delete(d.p1Cache, key)
+		}
+	}
+	d.p1Seen = map[string]bool{}
+}
+
+// Reasons a serial port read stopped before the data was complete
+var (
+	errSerialTimeout = errors.New("timed out")
+	errSerialLimit   = errors.New("read limit reached")
+)
+
+// readTelegram reads from the serial port of a DSMR 4 or 5 meter (115200 baud,
+// 8N1) until a complete telegram arrived or the timeout expired
+func readTelegram(ctx context.Context, path string, timeout time.Duration) ([]byte, error) {
+	data, err := querySerial(ctx, path, timeout, nil, telegramComplete, maxTelegramSize)
+	switch {
+	case errors.Is(err, errSerialTimeout):
+		return data, errors.New("timed out waiting for a telegram")
+	case errors.Is(err, errSerialLimit):
+		return data, errors.New("no telegram found in the serial data")
+	}
+	return data, err
+}
+
+// telegramComplete tells whether the buffer holds a telegram up to its end,
+// including the CRC line of DSMR 4 and later
+func telegramComplete(data []byte) bool {
+	start := bytes.IndexByte(data, '/')
+	if start < 0 {
+		return false
+	}
+	end := bytes.IndexByte(data[start:], '!')
+	return end >= 0 && bytes.IndexByte(data[start+end:], '\n') >= 0
+}
+
+// waitDeadline returns the earliest of the timeout and the context deadline
+func waitDeadline(ctx context.Context, timeout time.Duration) time.Time {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		return ctxDeadline
+	}
+	return deadline
+}
@@ -0,0 +1,69 @@
+package peripherals
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const dsmr5Body = "/ISk5\\2MT382-1000\r\n\r\n" +
+	"1-3:0.2.8(50)\r\n" +
+	"0-0:1.0.0(101209113020W)\r\n" +
+	"0-0:96.1.1(4B384547303034303436333935353037)\r\n" +
+	"1-0:1.8.1(123456.789*kWh)\r\n" +
+	"!"
+
+func TestCRC16(t *testing.T) {
+	if crc := crc16([]byte("123456789")); crc != 0xbb3d {
+		t.Errorf("expected CRC16/ARC check value BB3D, got %04X", crc)
+	}
+}
+
+func TestParseTelegram(t *testing.T) {
+	valid := fmt.Sprintf("garbage from the previous telegram\r\n%s%04X\r\n", dsmr5Body, crc16([]byte(dsmr5Body)))
+
+	telegram, err := parseTelegram([]byte(valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if telegram.Header != "ISk5\\2MT382-1000" || telegram.Version != "5.0" || telegram.MeterID != "K8EG004046395507" {
+		t.Errorf("unexpected telegram %+v", telegram)
+	}
+	if !telegramComplete([]byte(valid)) {
+		t.Error("expected telegram to be complete")
+	}
+
+	corrupted := strings.Replace(valid, "123456.789", "123456.788", 1)
+	if _, err := parseTelegram([]byte(corrupted)); err == nil {
+		t.Error("expected CRC mismatch to be detected")
+	}
+
+	// DSMR 2.2 telegrams have no CRC
+	legacy := "/KMP5 KA6U001585575011\r\n\r\n0-0:96.1.1(204B413655303031353835353735303131)\r\n!\r\n"
+	if telegram, err := parseTelegram([]byte(legacy)); err != nil || telegram.MeterID != " KA6U001585575011" {
+		t.Errorf("unexpected legacy telegram %+v (%v)", telegram, err)
+	}
+
+	if _, err := parseTelegram([]byte(dsmr5Body[:40])); err == nil {
+		t.Error("expected incomplete telegram to be refused")
+	}
+}
+
+func TestProbeSmartMeterCandidate(t *testing.T) {
+	sysfs := t.TempDir()
+	cable := fakeUSBDevice(t, sysfs, "1-1", "1", "6")
+	fakeClassNode(t, sysfs, fmt.Sprintf("%s/1-1:1.0/ttyUSB0", cable), "tty", "ttyUSB0")
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs))
+	peripheral := Peripheral{}
+	d.probeSmartMeter(context.Background(), Device{Bus: 1, Address: 6, VendorID: 0x0403, ProductID: 0x6001}, &peripheral)
+
+	meter := peripheral.SmartMeter
+	if meter == nil {
+		t.Fatal("expected FTDI cable to be reported as a smart meter candidate")
+	}
+	if meter.SerialDevice != "/dev/ttyUSB0" || meter.Confirmed {
+		t.Errorf("unexpected smart meter %v", meter)
+	}
+}
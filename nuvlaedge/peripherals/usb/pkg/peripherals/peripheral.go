@@ -0,0 +1,139 @@
+// Package peripherals discovers the USB peripherals attached to a NuvlaEdge
+// and describes them with the attributes of the nuvlabox-peripheral resource.
+//
+// The package does not depend on libusb: devices are enumerated by a Backend,
+// such as the one in the libusb sub-package, and enriched with the information
+// udev holds about their device nodes.
+package peripherals
+
+import (
+	"context"
+	"fmt"
+)
+
+const Interface = "USB"
+
+// USB-IF class codes
+const (
+	ClassAudio       uint8 = 0x01
+	ClassHID         uint8 = 0x03
+	ClassMassStorage uint8 = 0x08
+	ClassVideo       uint8 = 0x0e
+)
+
+// Device is the raw description of an attached USB device, as read from its
+// descriptors by a Backend.
+type Device struct {
+	Bus       int
+	Address   int
+	VendorID  uint16
+	ProductID uint16
+
+	// Revision is the bcdDevice release number of the device
+	Revision uint16
+	Speed    Speed
+
+	// Names resolved from the USB ID database. Empty when unknown.
+	VendorName  string
+	ProductName string
+
+	// Classification is a human readable summary of the device class,
+	// sub-class and protocol.
+	Classification string
+
+	Interfaces []InterfaceSetting
+}
+
+// InterfaceSetting is an alternate setting of one of the device interfaces
+type InterfaceSetting struct {
+	Number    int
+	Alternate int
+	Class     uint8
+	SubClass  uint8
+	Protocol  uint8
+	ClassName string
+	Endpoints []Endpoint
+}
+
+// Identifier returns the vendor:product pair identifying the device model
+func (d Device) Identifier() string {
+	return fmt.Sprintf("%04x:%04x", d.VendorID, d.ProductID)
+}
+
+// HasInterfaceClass reports whether any of the device interfaces is of the
+// given class
+func (d Device) HasInterfaceClass(class uint8) bool {
+	for _, setting := range d.Interfaces {
+		if setting.Class == class {
+			return true
+		}
+	}
+	return false
+}
+
+// DevicePath returns the usbfs node of the device
+func (d Device) DevicePath() string {
+	return fmt.Sprintf("/dev/bus/usb/%03d/%03d", d.Bus, d.Address)
+}
+
+// Backend enumerates the devices currently attached to the host
+type Backend interface {
+	Devices(ctx context.Context) ([]Device, error)
+	Close() error
+}
+
+// Resetter is implemented by the backends able to reset a device, for the
+// devices with the reset-on-attach quirk
+type Resetter interface {
+	Reset(ctx context.Context, device Device) error
+}
+
+// VisitFunc is called by a Backend with the identifier and device path of
+// every device, before its descriptors are decoded
+type VisitFunc func(identifier string, devicePath string)
+
+// newPeripheral builds the peripheral record of a device from its descriptors
+// only. Deep probing adds the udev provided attributes on top of it.
+func newPeripheral(device Device) Peripheral {
+	identifier := device.Identifier()
+
+	name := fmt.Sprintf("UNNAMED USB Device with ID %s", identifier)
+	product := "unknown product"
+	if len(device.ProductName) > 0 {
+		name = device.ProductName
+		product = device.ProductName
+	}
+
+	return Peripheral{
+		Name: name,
+		Description: fmt.Sprintf("%s device [%s] with ID %s. Protocol: %s",
+			Interface, product, identifier, device.Classification),
+		Interface:  Interface,
+		Identifier: identifier,
+		Classes:    interfaceClasses(device),
+		Available:  true,
+		DevicePath: device.DevicePath(),
+		// Leaving out the resources attribute since this is only used for
+		// block devices, which at the moment are already monitored by the
+		// NB Agent, so no need to duplicate the same information.
+		Vendor:    device.VendorName,
+		Product:   device.ProductName,
+		Firmware:  firmwareInfo(device),
+		Bandwidth: isochronousBandwidth(device),
+	}
+}
+
+// interfaceClasses lists the distinct classes of all the interface settings of
+// the device, in descriptor order
+func interfaceClasses(device Device) []string {
+	seen := make(map[string]bool)
+	classes := make([]string, 0)
+
+	for _, setting := range device.Interfaces {
+		if _, exists := seen[setting.ClassName]; !exists {
+			seen[setting.ClassName] = true
+			classes = append(classes, setting.ClassName)
+		}
+	}
+	return classes
+}
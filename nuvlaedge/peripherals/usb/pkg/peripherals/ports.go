@@ -0,0 +1,24 @@
+package peripherals
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// assignPorts reports the physical port each device is plugged in, as the
+// name of its sysfs folder: the bus, then the port of every hub on the way,
+// as 1-1.2 for port 2 of the hub on port 1 of bus 1. The names stay the same
+// across reboots and replugs, unlike the addresses, so sites can map them to
+// where the ports are.
+func (d *Discoverer) assignPorts(peripherals []Peripheral) {
+	ports := map[string]string{}
+	for _, device := range d.sysfsUSBDevices() {
+		// Root hubs, as usb1, are not plugged in any port
+		if name := filepath.Base(device.Dir); strings.Contains(name, "-") {
+			ports[Device{Bus: device.Bus, Address: device.Address}.DevicePath()] = name
+		}
+	}
+	for i := range peripherals {
+		peripherals[i].Port = ports[peripherals[i].DevicePath]
+	}
+}
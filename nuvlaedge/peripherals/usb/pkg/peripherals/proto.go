@@ -0,0 +1,141 @@
+package peripherals
+
+import (
+	"encoding/json"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/protowire"
+)
+
+// Field numbers of the Peripheral message, see
+// proto/nuvlaedge/peripherals/v1/peripheral.proto
+const (
+	protoIdentifier   = 1
+	protoName         = 2
+	protoDescription  = 3
+	protoInterface    = 4
+	protoClasses      = 5
+	protoAvailable    = 6
+	protoDevicePath   = 7
+	protoVendor       = 8
+	protoProduct      = 9
+	protoSerialNumber = 10
+	protoVideoDevice  = 11
+	protoPort         = 12
+	protoNode         = 13
+	protoExtensions   = 14
+)
+
+// protoAttributes are the JSON names of the attributes with a field of their
+// own in the Peripheral message, the others are carried in its extensions
+var protoAttributes = map[string]bool{
+	"identifier":    true,
+	"name":          true,
+	"description":   true,
+	"interface":     true,
+	"classes":       true,
+	"available":     true,
+	"device-path":   true,
+	"vendor":        true,
+	"product":       true,
+	"serial-number": true,
+	"video-device":  true,
+	"port":          true,
+	"node":          true,
+}
+
+// MarshalProto encodes the record as a Peripheral message of the protocol
+// buffers schema
+func (p Peripheral) MarshalProto() ([]byte, error) {
+	extensions, err := p.protoExtensions()
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	b = protowire.AppendString(b, protoIdentifier, p.Identifier)
+	b = protowire.AppendString(b, protoName, p.Name)
+	b = protowire.AppendString(b, protoDescription, p.Description)
+	b = protowire.AppendString(b, protoInterface, p.Interface)
+	for _, class := range p.Classes {
+		b = protowire.AppendBytes(b, protoClasses, []byte(class))
+	}
+	b = protowire.AppendBool(b, protoAvailable, p.Available)
+	b = protowire.AppendString(b, protoDevicePath, p.DevicePath)
+	b = protowire.AppendString(b, protoVendor, p.Vendor)
+	b = protowire.AppendString(b, protoProduct, p.Product)
+	b = protowire.AppendString(b, protoSerialNumber, p.SerialNumber)
+	b = protowire.AppendString(b, protoVideoDevice, p.VideoDevice)
+	b = protowire.AppendString(b, protoPort, p.Port)
+	b = protowire.AppendString(b, protoNode, p.Node)
+	b = protowire.AppendBytes(b, protoExtensions, extensions)
+	return b, nil
+}
+
+// protoExtensions encodes the attributes without field as a JSON object, nil
+// when there are none
+func (p Peripheral) protoExtensions() ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(data, &attributes); err != nil {
+		return nil, err
+	}
+	for key := range attributes {
+		if protoAttributes[key] {
+			delete(attributes, key)
+		}
+	}
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(attributes)
+}
+
+// UnmarshalProto decodes a Peripheral message. The fields this revision of
+// the schema does not know are skipped.
+func (p *Peripheral) UnmarshalProto(b []byte) error {
+	fields, err := protowire.Fields(b)
+	if err != nil {
+		return err
+	}
+
+	var r Peripheral
+	for _, field := range fields {
+		if field.Number == protoExtensions && field.Type == protowire.BytesType && len(field.Bytes) > 0 {
+			if err := json.Unmarshal(field.Bytes, &r); err != nil {
+				return err
+			}
+		}
+	}
+
+	text := map[int]*string{
+		protoIdentifier:   &r.Identifier,
+		protoName:         &r.Name,
+		protoDescription:  &r.Description,
+		protoInterface:    &r.Interface,
+		protoDevicePath:   &r.DevicePath,
+		protoVendor:       &r.Vendor,
+		protoProduct:      &r.Product,
+		protoSerialNumber: &r.SerialNumber,
+		protoVideoDevice:  &r.VideoDevice,
+		protoPort:         &r.Port,
+		protoNode:         &r.Node,
+	}
+	r.Classes = []string{}
+	for _, field := range fields {
+		switch {
+		case field.Number == protoAvailable && field.Type == protowire.VarintType:
+			r.Available = field.Value != 0
+		case field.Type != protowire.BytesType:
+		case field.Number == protoClasses:
+			r.Classes = append(r.Classes, string(field.Bytes))
+		case text[field.Number] != nil:
+			*text[field.Number] = string(field.Bytes)
+		}
+	}
+
+	*p = r
+	return nil
+}
@@ -0,0 +1,52 @@
+package peripherals
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/protowire"
+)
+
+func TestPeripheralProto(t *testing.T) {
+	peripheral := newPeripheral(webcam())
+	peripheral.SerialNumber = "200901010001"
+	peripheral.Port = "1-1.2"
+	peripheral.Firmware = &Firmware{Version: "0.10", Source: "bcdDevice"}
+	peripheral.Attributes = map[string]interface{}{"location": "front door"}
+
+	data, err := peripheral.MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Peripheral
+	if err := decoded.UnmarshalProto(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, peripheral) {
+		t.Errorf("expected %+v after a round trip, got %+v", peripheral, decoded)
+	}
+
+	// Fields of newer revisions of the schema are skipped
+	data = protowire.AppendString(data, 100, "future")
+	data = protowire.AppendVarint(data, 101, 7)
+	if err := decoded.UnmarshalProto(data); err != nil || decoded.Identifier != peripheral.Identifier {
+		t.Errorf("expected unknown fields to be skipped, got %+v (%v)", decoded, err)
+	}
+
+	if err := decoded.UnmarshalProto(data[:len(data)-1]); err == nil {
+		t.Error("expected a truncated message to fail")
+	}
+}
+
+func TestPeripheralProtoExtensions(t *testing.T) {
+	peripheral := newPeripheral(webcam())
+	extensions, err := peripheral.protoExtensions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The attributes with a field of their own are not repeated
+	if extensions != nil {
+		t.Errorf("expected no extensions, got %s", extensions)
+	}
+}
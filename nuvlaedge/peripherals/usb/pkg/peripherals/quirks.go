@@ -0,0 +1,208 @@
+package peripherals
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Quirk is a special handling a device model needs
+type Quirk string
+
+const (
+	// QuirkBadDescriptors marks devices misreporting their interface
+	// descriptors: the probes decoding them, the HID report descriptors, the
+	// UVC controls and the isochronous bandwidth, are skipped
+	QuirkBadDescriptors Quirk = "bad-descriptors"
+	// QuirkResetOnAttach marks devices failing to work until reset: they are
+	// reset once when first seen, by the backends able to
+	QuirkResetOnAttach Quirk = "reset-on-attach"
+	// QuirkNoUdev marks devices hanging the udev probing, which is skipped
+	QuirkNoUdev Quirk = "no-udev"
+	// QuirkNoAutosuspend marks devices failing once autosuspended, as many
+	// cameras and modems: their autosuspend is disabled when first seen
+	QuirkNoAutosuspend Quirk = "no-autosuspend"
+)
+
+var knownQuirks = map[Quirk]bool{
+	QuirkBadDescriptors: true,
+	QuirkResetOnAttach:  true,
+	QuirkNoUdev:         true,
+	QuirkNoAutosuspend:  true,
+}
+
+// QuirkEntry applies quirks to the revisions of a device model within
+// MinRevision and MaxRevision. Removed are quirks an earlier entry set, which
+// the user table can lift for the hardware it knows better.
+type QuirkEntry struct {
+	VendorID    uint16
+	ProductID   uint16
+	MinRevision uint16
+	MaxRevision uint16
+	Quirks      []Quirk
+	Removed     []Quirk
+	Note        string
+}
+
+func (e QuirkEntry) matches(device Device) bool {
+	return e.VendorID == device.VendorID && e.ProductID == device.ProductID &&
+		device.Revision >= e.MinRevision && device.Revision <= e.MaxRevision
+}
+
+// Quirks is a quirk table. All the entries matching a device apply, in order.
+type Quirks []QuirkEntry
+
+// DefaultQuirks is the table shipped with the manager
+var DefaultQuirks = Quirks{
+	{VendorID: 0x046d, ProductID: 0x0825, MaxRevision: 0xffff, Quirks: []Quirk{QuirkResetOnAttach},
+		Note: "Logitech C270 webcams may not stream until reset"},
+	{VendorID: 0x03f0, ProductID: 0x0701, MaxRevision: 0xffff, Quirks: []Quirk{QuirkBadDescriptors},
+		Note: "HP 5300C/5370C scanners report invalid configuration and interface descriptors"},
+	{VendorID: 0x12d1, ProductID: 0x1f01, MaxRevision: 0xffff, Quirks: []Quirk{QuirkNoUdev},
+		Note: "Huawei modems in storage mode hang udev while usb_modeswitch switches them"},
+}
+
+// Match returns the quirks of a device, sorted
+func (q Quirks) Match(device Device) []Quirk {
+	applied := map[Quirk]bool{}
+	for _, entry := range q {
+		if !entry.matches(device) {
+			continue
+		}
+		for _, quirk := range entry.Quirks {
+			applied[quirk] = true
+		}
+		for _, quirk := range entry.Removed {
+			delete(applied, quirk)
+		}
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+	quirks := make([]Quirk, 0, len(applied))
+	for quirk := range applied {
+		quirks = append(quirks, quirk)
+	}
+	sort.Slice(quirks, func(i, j int) bool { return quirks[i] < quirks[j] })
+	return quirks
+}
+
+func hasQuirk(quirks []Quirk, quirk Quirk) bool {
+	for _, q := range quirks {
+		if q == quirk {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadQuirks reads a user quirk table. A missing file is not an error and
+// yields an empty table.
+func LoadQuirks(path string) (Quirks, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	quirks, err := ParseQuirks(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return quirks, nil
+}
+
+// ParseQuirks decodes a quirk table, one device model per line, followed by
+// its quirks separated by commas. A quirk prefixed with "-" is removed from
+// the model. The revisions are optional, a single one or a range:
+//
+//	# vendor:product[:revision[-revision]] quirk[,quirk...]
+//	046d:0825 -reset-on-attach
+//	1234:5678:0100-01ff bad-descriptors,no-udev
+//	1199:9071 no-autosuspend
+//
+// The comment lines preceding an entry are its note.
+func ParseQuirks(r io.Reader) (Quirks, error) {
+	var quirks Quirks
+	var note []string
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			note = nil
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			note = append(note, strings.TrimSpace(strings.TrimPrefix(line, "#")))
+			continue
+		}
+
+		entry, err := parseQuirkEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		entry.Note = strings.Join(note, " ")
+		note = nil
+		quirks = append(quirks, entry)
+	}
+	return quirks, scanner.Err()
+}
+
+func parseQuirkEntry(line string) (QuirkEntry, error) {
+	entry := QuirkEntry{MaxRevision: 0xffff}
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return entry, fmt.Errorf("expected \"vendor:product quirks\", got %q", line)
+	}
+
+	ids := strings.Split(fields[0], ":")
+	if len(ids) < 2 || len(ids) > 3 {
+		return entry, fmt.Errorf("invalid device %q, expected vendor:product[:revision]", fields[0])
+	}
+	numbers := make([]uint16, 0, 4)
+	parts := ids[:2]
+	if len(ids) == 3 {
+		parts = append(parts, strings.SplitN(ids[2], "-", 2)...)
+	}
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 16, 16)
+		if err != nil {
+			return entry, fmt.Errorf("invalid device %q: %s is not a 4 digits hexadecimal number", fields[0], part)
+		}
+		numbers = append(numbers, uint16(n))
+	}
+	entry.VendorID, entry.ProductID = numbers[0], numbers[1]
+	switch len(numbers) {
+	case 3:
+		entry.MinRevision, entry.MaxRevision = numbers[2], numbers[2]
+	case 4:
+		entry.MinRevision, entry.MaxRevision = numbers[2], numbers[3]
+	}
+	if entry.MinRevision > entry.MaxRevision {
+		return entry, fmt.Errorf("invalid device %q: empty revision range", fields[0])
+	}
+
+	for _, name := range strings.Split(fields[1], ",") {
+		removed := strings.HasPrefix(name, "-")
+		quirk := Quirk(strings.TrimPrefix(name, "-"))
+		if !knownQuirks[quirk] {
+			return entry, fmt.Errorf("unknown quirk %q", quirk)
+		}
+		if removed {
+			entry.Removed = append(entry.Removed, quirk)
+		} else {
+			entry.Quirks = append(entry.Quirks, quirk)
+		}
+	}
+	return entry, nil
+}
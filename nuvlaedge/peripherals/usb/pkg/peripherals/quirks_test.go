@@ -0,0 +1,145 @@
+package peripherals
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type resettingBackend struct {
+	fakeBackend
+	resets []string
+}
+
+func (b *resettingBackend) Reset(ctx context.Context, device Device) error {
+	b.resets = append(b.resets, device.DevicePath())
+	return nil
+}
+
+func TestParseQuirks(t *testing.T) {
+	input := `# Shipped entry lifted on this site
+046d:0825 -reset-on-attach
+
+# Firmware 1.x hangs udev
+1234:5678:0100-01ff no-udev,bad-descriptors
+`
+	quirks, err := ParseQuirks(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Quirks{
+		{VendorID: 0x046d, ProductID: 0x0825, MaxRevision: 0xffff, Removed: []Quirk{QuirkResetOnAttach},
+			Note: "Shipped entry lifted on this site"},
+		{VendorID: 0x1234, ProductID: 0x5678, MinRevision: 0x0100, MaxRevision: 0x01ff,
+			Quirks: []Quirk{QuirkNoUdev, QuirkBadDescriptors}, Note: "Firmware 1.x hangs udev"},
+	}
+	if !reflect.DeepEqual(quirks, expected) {
+		t.Errorf("expected %+v, got %+v", expected, quirks)
+	}
+
+	for _, input := range []string{
+		"046d:0825\n",
+		"046d reset-on-attach\n",
+		"046d:zzzz reset-on-attach\n",
+		"046d:0825:0200-0100 reset-on-attach\n",
+		"046d:0825 reset-on-boot\n",
+	} {
+		if _, err := ParseQuirks(strings.NewReader(input)); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
+
+func TestMatchQuirks(t *testing.T) {
+	table := append(append(Quirks{}, DefaultQuirks...),
+		QuirkEntry{VendorID: 0x046d, ProductID: 0x0825, MinRevision: 0x0010, MaxRevision: 0x0010, Quirks: []Quirk{QuirkNoUdev}},
+		QuirkEntry{VendorID: 0x046d, ProductID: 0x0825, MaxRevision: 0xffff, Removed: []Quirk{QuirkResetOnAttach}},
+	)
+
+	device := webcam()
+	if quirks := table.Match(device); len(quirks) != 0 {
+		t.Errorf("expected the user entry to lift the shipped quirk, got %v", quirks)
+	}
+	device.Revision = 0x0010
+	if quirks := table.Match(device); !reflect.DeepEqual(quirks, []Quirk{QuirkNoUdev}) {
+		t.Errorf("expected the quirk of the revision, got %v", quirks)
+	}
+	if quirks := DefaultQuirks.Match(Device{VendorID: 0x1d6b, ProductID: 0x0002}); quirks != nil {
+		t.Errorf("expected no quirk for a root hub, got %v", quirks)
+	}
+}
+
+func TestDiscoverAppliesQuirks(t *testing.T) {
+	camera := webcam()
+	camera.Interfaces = append(camera.Interfaces, InterfaceSetting{
+		Number: 1, Alternate: 1, Class: 0x0e, ClassName: "Video",
+		Endpoints: []Endpoint{{Number: 1, In: true, Transfer: TransferIsochronous, MaxPacketSize: 3072, Interval: 125 * time.Microsecond}},
+	})
+	backend := &resettingBackend{fakeBackend: fakeBackend{devices: []Device{camera}}}
+	prober := &fakeProber{}
+	quirks := Quirks{{VendorID: 0x046d, ProductID: 0x0825, MaxRevision: 0xffff,
+		Quirks: []Quirk{QuirkResetOnAttach, QuirkNoUdev, QuirkBadDescriptors}}}
+
+	d := NewDiscoverer(backend, WithProber(prober), WithQuirks(quirks), WithSysfsDir(t.TempDir()))
+	for i := 0; i < 2; i++ {
+		discovered, err := d.Discover(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		peripheral := discovered[0]
+		if !reflect.DeepEqual(peripheral.Quirks, []Quirk{QuirkBadDescriptors, QuirkNoUdev, QuirkResetOnAttach}) {
+			t.Errorf("expected the applied quirks in the record, got %v", peripheral.Quirks)
+		}
+		if peripheral.Bandwidth != nil || len(d.Bandwidth()) != 0 {
+			t.Errorf("expected no bandwidth estimate from bad descriptors, got %+v", peripheral.Bandwidth)
+		}
+	}
+	if prober.calls != 0 {
+		t.Errorf("expected udev not to be probed, got %d calls", prober.calls)
+	}
+	if !reflect.DeepEqual(backend.resets, []string{"/dev/bus/usb/001/004"}) {
+		t.Errorf("expected a single reset on attach, got %v", backend.resets)
+	}
+
+	// A device reattached is reset again
+	backend.devices = nil
+	d.Discover(context.Background())
+	backend.devices = []Device{camera}
+	d.Discover(context.Background())
+	if len(backend.resets) != 2 {
+		t.Errorf("expected the reattached device to be reset, got %v", backend.resets)
+	}
+}
+
+func TestDiscoverDisablesAutosuspend(t *testing.T) {
+	sysfs := t.TempDir()
+	dir := filepath.Join(sysfs, "bus", "usb", "devices", "1-1")
+	if err := os.MkdirAll(filepath.Join(dir, "power"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "busnum"), "1")
+	writeFile(t, filepath.Join(dir, "devnum"), "4")
+	control := filepath.Join(dir, "power", "control")
+	writeFile(t, control, "auto")
+
+	backend := &fakeBackend{devices: []Device{webcam()}}
+	quirks := Quirks{{VendorID: 0x046d, ProductID: 0x0825, MaxRevision: 0xffff, Quirks: []Quirk{QuirkNoAutosuspend}}}
+	d := NewDiscoverer(backend, WithProber(&fakeProber{}), WithQuirks(quirks), WithSysfsDir(sysfs), WithDevDir(t.TempDir()))
+	if _, err := d.Discover(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(control); string(data) != AutosuspendDisabled {
+		t.Errorf("expected the autosuspend to be disabled, got %q", data)
+	}
+
+	// The setting is applied once per attachment
+	writeFile(t, control, "auto")
+	d.Discover(context.Background())
+	if data, _ := os.ReadFile(control); string(data) != "auto" {
+		t.Errorf("expected the autosuspend to be left alone, got %q", data)
+	}
+}
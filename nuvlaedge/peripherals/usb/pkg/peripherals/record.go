@@ -0,0 +1,311 @@
+package peripherals
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Peripheral is a discovered device, described with the attributes of the
+// nuvlabox-peripheral resource and the extensions reported by this manager.
+//
+// It encodes to the JSON document the agent expects, and decodes from it, so
+// other NuvlaEdge components can read the reports with the same type.
+type Peripheral struct {
+	Identifier   string   `json:"identifier"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Interface    string   `json:"interface"`
+	Classes      []string `json:"classes"`
+	Available    bool     `json:"available"`
+	DevicePath   string   `json:"device-path,omitempty"`
+	Vendor       string   `json:"vendor,omitempty"`
+	Product      string   `json:"product,omitempty"`
+	SerialNumber string   `json:"serial-number,omitempty"`
+	VideoDevice  string   `json:"video-device,omitempty"`
+
+	Firmware      *Firmware      `json:"firmware,omitempty"`
+	SerialDevices []string       `json:"serial-devices,omitempty"`
+	VideoDevices  []string       `json:"video-devices,omitempty"`
+	CaptureCard   bool           `json:"capture-card,omitempty"`
+	DVBAdapters   []DVBAdapter   `json:"dvb-adapters,omitempty"`
+	SmartMeter    *SmartMeter    `json:"smart-meter,omitempty"`
+	HID           []HIDInterface `json:"hid,omitempty"`
+	UVC           *UVC           `json:"uvc,omitempty"`
+	Storage       []BlockDevice  `json:"storage,omitempty"`
+	Modem         *Modem         `json:"modem,omitempty"`
+	SecurityToken *SecurityToken `json:"security-token,omitempty"`
+	DepthCamera   *DepthCamera   `json:"depth-camera,omitempty"`
+	GNSS          *GNSS          `json:"gnss,omitempty"`
+
+	// Details are the typed sections of the camera, storage, serial and
+	// sensor classes
+	Details *Details `json:"details,omitempty"`
+
+	// Drivers are the kernel drivers bound to the interfaces. DriverMissing
+	// flags the devices with standard interfaces no driver is bound to.
+	Drivers       []InterfaceDriver `json:"drivers,omitempty"`
+	DriverMissing bool              `json:"driver-missing,omitempty"`
+
+	// Bandwidth is reported for the devices with isochronous endpoints
+	Bandwidth *IsochronousBandwidth `json:"bandwidth,omitempty"`
+
+	// Quirks are the special handlings applied to the device model
+	Quirks []Quirk `json:"quirks,omitempty"`
+
+	// Holders are the processes holding the device nodes open, when holder
+	// attribution is enabled
+	Holders []Holder `json:"holders,omitempty"`
+
+	// HostCritical tells why the host relies on the device, as its boot disk
+	// or serial console. These devices are reported unavailable.
+	HostCritical string `json:"host-critical,omitempty"`
+
+	// Port is the physical port path of the device, as 1-1.2
+	Port string `json:"port,omitempty"`
+
+	// Visibility is set for devices the container cannot open, with a hint
+	// on how to give it access
+	Visibility string `json:"visibility,omitempty"`
+	Hint       string `json:"hint,omitempty"`
+
+	// Claim state, only reported when a claim registry is in use
+	Claimed      *bool  `json:"claimed,omitempty"`
+	ClaimedBy    string `json:"claimed-by,omitempty"`
+	ClaimExpires string `json:"claim-expires,omitempty"`
+
+	// Attachment tells since when the peripheral is attached, when attachment
+	// tracking is enabled
+	Attachment *Attachment `json:"attachment,omitempty"`
+
+	// Health grades the peripheral from the USB errors of its port, when
+	// health monitoring is enabled
+	Health *Health `json:"health,omitempty"`
+
+	// Suspect is set while the peripheral is missing from the scans, during
+	// the removal grace period
+	Suspect *Suspect `json:"suspect,omitempty"`
+
+	// Node is the cluster node the peripheral is attached to, in cluster
+	// inventories
+	Node string `json:"node,omitempty"`
+
+	// Attributes holds the additional attributes of the record, such as the
+	// ones set by technician overrides. They are encoded on top of the typed
+	// fields.
+	Attributes map[string]interface{} `json:"-"`
+}
+
+// Firmware describes the firmware of a device, from its descriptors
+type Firmware struct {
+	Version          string `json:"version,omitempty"`
+	Source           string `json:"source,omitempty"`
+	HardwareRevision string `json:"hardware-revision,omitempty"`
+	// DFU is "runtime" or "dfu" for devices supporting firmware upgrades
+	DFU string `json:"dfu,omitempty"`
+}
+
+// DVBAdapter is a DVB frontend of a TV tuner
+type DVBAdapter struct {
+	Adapter   int      `json:"adapter"`
+	Frontend  string   `json:"frontend"`
+	Name      string   `json:"name,omitempty"`
+	Standards []string `json:"standards,omitempty"`
+}
+
+// SmartMeter describes the meter behind a P1 cable
+type SmartMeter struct {
+	Interface    string `json:"interface"`
+	SerialDevice string `json:"serial-device"`
+	Confirmed    bool   `json:"confirmed"`
+	Header       string `json:"header,omitempty"`
+	DSMRVersion  string `json:"dsmr-version,omitempty"`
+	MeterID      string `json:"meter-id,omitempty"`
+}
+
+// GNSS describes a u-blox GNSS receiver, with its RTK capability
+type GNSS struct {
+	Protocol     string `json:"protocol"`
+	SerialDevice string `json:"serial-device"`
+	// Confirmed is set once the receiver answered the UBX polls
+	Confirmed       bool     `json:"confirmed"`
+	Generation      string   `json:"generation,omitempty"`
+	Model           string   `json:"model,omitempty"`
+	Firmware        string   `json:"firmware,omitempty"`
+	HardwareVersion string   `json:"hardware-version,omitempty"`
+	ProtocolVersion string   `json:"protocol-version,omitempty"`
+	Constellations  []string `json:"constellations,omitempty"`
+	// RTK is set for the receivers computing RTK fixes from correction data,
+	// BaseStation for the ones able to produce the corrections
+	RTK         bool `json:"rtk"`
+	BaseStation bool `json:"base-station"`
+	// MeasurementRate is the configured interval between measurements, in
+	// milliseconds, NavigationRate the number of measurements per solution,
+	// and UpdateRate the resulting rate of the solutions, in Hz
+	MeasurementRate int     `json:"measurement-rate,omitempty"`
+	NavigationRate  int     `json:"navigation-rate,omitempty"`
+	UpdateRate      float64 `json:"update-rate,omitempty"`
+}
+
+// HIDInterface is a hidraw node of a device, with the kinds of device its
+// report descriptor declares
+type HIDInterface struct {
+	DevicePath string    `json:"device-path"`
+	Types      []HIDType `json:"types"`
+}
+
+// UVC lists the controls of a USB Video Class camera
+type UVC struct {
+	CameraControls     []string                `json:"camera-controls"`
+	ProcessingControls []string                `json:"processing-controls"`
+	Autofocus          bool                    `json:"autofocus"`
+	PTZ                bool                    `json:"ptz"`
+	Ranges             map[string]ControlRange `json:"ranges,omitempty"`
+}
+
+// ControlRange is the range of a camera control, as exposed by the driver
+type ControlRange struct {
+	Min     int32 `json:"min"`
+	Max     int32 `json:"max"`
+	Step    int32 `json:"step"`
+	Default int32 `json:"default"`
+}
+
+// BlockDevice is a disk of a USB storage device
+type BlockDevice struct {
+	DevicePath  string   `json:"device-path"`
+	Size        int64    `json:"size,omitempty"`
+	Removable   bool     `json:"removable"`
+	ReadOnly    bool     `json:"read-only"`
+	Partitions  []string `json:"partitions,omitempty"`
+	Mountpoints []string `json:"mountpoints,omitempty"`
+	// HoldsRoot is set for the disk the running operating system booted from
+	HoldsRoot bool `json:"holds-root,omitempty"`
+	// Protected disks hold the root filesystem, a mounted filesystem or swap,
+	// and are refused by the remote actions
+	Protected       bool   `json:"protected"`
+	ProtectedReason string `json:"protected-reason,omitempty"`
+}
+
+// Modem describes a cellular modem, with its SIM and network state
+type Modem struct {
+	Protocol      string `json:"protocol"`
+	ControlDevice string `json:"control-device"`
+	// Source is the tool the modem was queried with, empty when it could not
+	// be queried
+	Source             string   `json:"source,omitempty"`
+	IMEI               string   `json:"imei,omitempty"`
+	SIMPresent         *bool    `json:"sim-present,omitempty"`
+	Operator           string   `json:"operator,omitempty"`
+	OperatorCode       string   `json:"operator-code,omitempty"`
+	State              string   `json:"state,omitempty"`
+	AccessTechnologies []string `json:"access-technologies,omitempty"`
+	// SignalQuality is in percent
+	SignalQuality *int `json:"signal-quality,omitempty"`
+}
+
+// Holder is a process holding a device node of a peripheral open
+type Holder struct {
+	DevicePath string `json:"device-path"`
+	PID        int    `json:"pid"`
+	Command    string `json:"command,omitempty"`
+	// Container is the short ID of the container of the process
+	Container string `json:"container,omitempty"`
+}
+
+// Attachment is the attachment history of a peripheral. The uptime of the
+// current attachment is the time since AttachedSince, it is not included in
+// Uptime so the record does not change at every scan.
+type Attachment struct {
+	AttachedSince string `json:"attached-since"`
+	// Attachments counts how many times the peripheral was attached
+	Attachments int `json:"attachments"`
+	// Uptime is the time, in seconds, the peripheral was attached before the
+	// current attachment
+	Uptime float64 `json:"uptime"`
+}
+
+// Health states
+const (
+	HealthGood     = "good"
+	HealthDegraded = "degraded"
+	HealthFailing  = "failing"
+)
+
+// Health is the state of a peripheral, with the USB errors the kernel reported
+// for its port over the health window
+type Health struct {
+	State        string `json:"state"`
+	Resets       int    `json:"resets"`
+	BabbleErrors int    `json:"babble-errors"`
+	OverCurrent  int    `json:"over-current"`
+}
+
+// Suspect tells since when a peripheral is missing from the scans, and when it
+// is reported removed unless a scan finds it again. The record is the last one
+// found.
+type Suspect struct {
+	MissingSince string `json:"missing-since"`
+	RemovalAt    string `json:"removal-at"`
+}
+
+// record avoids the recursion of the JSON methods
+type record Peripheral
+
+// knownAttributes are the JSON names of the typed fields
+var knownAttributes = jsonNames(reflect.TypeOf(record{}))
+
+func jsonNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if len(name) > 0 && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// MarshalJSON encodes the typed fields and the additional attributes in one
+// document
+func (p Peripheral) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(record(p))
+	if err != nil || len(p.Attributes) == 0 {
+		return data, err
+	}
+
+	merged := map[string]interface{}{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for key, value := range p.Attributes {
+		merged[key] = value
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON decodes a record, keeping the attributes without typed field
+// in Attributes
+func (p *Peripheral) UnmarshalJSON(data []byte) error {
+	var r record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for key, value := range all {
+		if knownAttributes[key] {
+			continue
+		}
+		if r.Attributes == nil {
+			r.Attributes = map[string]interface{}{}
+		}
+		r.Attributes[key] = value
+	}
+
+	*p = Peripheral(r)
+	return nil
+}
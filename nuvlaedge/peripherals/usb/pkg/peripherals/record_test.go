@@ -0,0 +1,38 @@
+package peripherals
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPeripheralJSON(t *testing.T) {
+	peripheral := newPeripheral(webcam())
+	peripheral.SerialNumber = "200901010001"
+	peripheral.Attributes = map[string]interface{}{"location": "front door"}
+
+	data, err := json.Marshal(peripheral)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if document["identifier"] != "046d:0825" || document["available"] != true ||
+		document["serial-number"] != "200901010001" || document["location"] != "front door" {
+		t.Errorf("unexpected document %s", data)
+	}
+	if _, exists := document["video-device"]; exists {
+		t.Errorf("expected empty attributes to be left out, got %s", data)
+	}
+
+	var decoded Peripheral
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, peripheral) {
+		t.Errorf("expected %+v after a round trip, got %+v", peripheral, decoded)
+	}
+}
@@ -0,0 +1,317 @@
+// Package replay records the device listings and serial numbers a discovery
+// session gets from the USB stack into a portable file, and replays them on
+// any machine, so a problem observed in the field can be reproduced in
+// development with the exact descriptors and attach and detach events.
+//
+// A recording holds one JSON document per line: a header, then the listing of
+// every scan, with the devices attached and detached since the previous one,
+// and the serial numbers looked up during the scan.
+//
+//	{"type":"header","version":1,"recorded":"2026-10-14T09:12:03Z","hostname":"edge-17","backend":"libusb"}
+//	{"type":"scan","scan":0,"offset":"0s","devices":[...],"attached":["046d:0825 /dev/bus/usb/001/004"]}
+//	{"type":"serial","scan":0,"device-path":"/dev/bus/usb/001/004","serial-number":"2F3C1A40"}
+//
+// The replay answers a scan for every listing requested, at the pace of the
+// manager rather than the one of the recording, and keeps answering the last
+// one once they are exhausted. The device nodes are not part of the
+// recordings: their probes find nothing when replaying.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/dfu"
+	log "github.com/sirupsen/logrus"
+)
+
+// Version of the recording format
+const Version = 1
+
+// Types of the entries of a recording
+const (
+	EntryHeader = "header"
+	EntryScan   = "scan"
+	EntrySerial = "serial"
+)
+
+// Entry is a line of a recording
+type Entry struct {
+	Type string `json:"type"`
+
+	// Header
+	Version  int    `json:"version,omitempty"`
+	Recorded string `json:"recorded,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Backend  string `json:"backend,omitempty"`
+
+	Scan int `json:"scan"`
+
+	// Scan, the offset from the start of the recording
+	Offset   string               `json:"offset,omitempty"`
+	Devices  []peripherals.Device `json:"devices,omitempty"`
+	Error    string               `json:"error,omitempty"`
+	Attached []string             `json:"attached,omitempty"`
+	Detached []string             `json:"detached,omitempty"`
+
+	// Serial number lookup
+	DevicePath   string `json:"device-path,omitempty"`
+	SerialNumber string `json:"serial-number,omitempty"`
+}
+
+// key identifies a device in the events
+func key(device peripherals.Device) string {
+	return device.Identifier() + " " + device.DevicePath()
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Recorder lists the devices with a backend and looks up the serial numbers
+// with a prober, appending what they answer to a recording
+type Recorder struct {
+	backend peripherals.Backend
+	prober  peripherals.Prober
+
+	mu       sync.Mutex
+	file     *os.File
+	writer   *bufio.Writer
+	start    time.Time
+	scan     int
+	attached map[string]bool
+}
+
+// NewRecorder creates the recording at path, replacing any previous one.
+// backendName is written in the header, to tell where the devices came from.
+func NewRecorder(path string, backend peripherals.Backend, backendName string, prober peripherals.Prober) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		backend:  backend,
+		prober:   prober,
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		start:    time.Now(),
+		scan:     -1,
+		attached: map[string]bool{},
+	}
+	hostname, _ := os.Hostname()
+	header := Entry{Type: EntryHeader, Version: Version, Recorded: r.start.UTC().Format(time.RFC3339),
+		Hostname: hostname, Backend: backendName}
+	if err := r.write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// write appends an entry, flushed right away so a crash loses nothing
+func (r *Recorder) write(entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := r.writer.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return r.writer.Flush()
+}
+
+// Devices lists the devices with the backend and records them
+func (r *Recorder) Devices(ctx context.Context) ([]peripherals.Device, error) {
+	devices, listErr := r.backend.Devices(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scan++
+	entry := Entry{Type: EntryScan, Scan: r.scan, Offset: time.Since(r.start).Round(time.Millisecond).String(), Devices: devices}
+	if listErr != nil {
+		entry.Error = listErr.Error()
+	}
+
+	current := map[string]bool{}
+	for _, device := range devices {
+		current[key(device)] = true
+		if !r.attached[key(device)] {
+			entry.Attached = append(entry.Attached, key(device))
+		}
+	}
+	// Listings interrupted by an error do not tell what was detached
+	if listErr == nil {
+		for _, device := range sortedKeys(r.attached) {
+			if !current[device] {
+				entry.Detached = append(entry.Detached, device)
+			}
+		}
+		r.attached = current
+	} else {
+		for device := range current {
+			r.attached[device] = true
+		}
+	}
+
+	if err := r.write(entry); err != nil {
+		log.Errorf("Unable to record the USB devices of scan %d. Reason: %s", r.scan, err)
+	}
+	return devices, listErr
+}
+
+// SerialNumber looks up the serial number with the prober and records it
+func (r *Recorder) SerialNumber(ctx context.Context, devicePath string) string {
+	serialNumber := r.prober.SerialNumber(ctx, devicePath)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := Entry{Type: EntrySerial, Scan: r.scan, DevicePath: devicePath, SerialNumber: serialNumber}
+	if err := r.write(entry); err != nil {
+		log.Errorf("Unable to record the serial number of %s. Reason: %s", devicePath, err)
+	}
+	return serialNumber
+}
+
+// Close closes the recording, not the backend
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// Backend replays a recording. It also implements peripherals.Prober,
+// answering the serial numbers recorded.
+type Backend struct {
+	Header Entry
+
+	mu      sync.Mutex
+	scans   []Entry
+	serials []map[string]string
+	next    int
+
+	onVisit peripherals.VisitFunc
+}
+
+// Load reads a recording
+func Load(path string) (*Backend, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b := &Backend{}
+	scanner := bufio.NewScanner(file)
+	// The listings of the busy hosts make long lines
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid recording %s, line %d: %w", path, line, err)
+		}
+		switch entry.Type {
+		case EntryHeader:
+			if entry.Version > Version {
+				return nil, fmt.Errorf("unsupported recording %s, version %d is newer than %d", path, entry.Version, Version)
+			}
+			b.Header = entry
+		case EntryScan:
+			b.scans = append(b.scans, entry)
+			b.serials = append(b.serials, map[string]string{})
+		case EntrySerial:
+			if len(b.serials) > 0 {
+				b.serials[len(b.serials)-1][entry.DevicePath] = entry.SerialNumber
+			}
+		default:
+			return nil, fmt.Errorf("invalid recording %s, line %d: unknown entry %q", path, line, entry.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(b.scans) == 0 {
+		return nil, fmt.Errorf("invalid recording %s: no scan recorded", path)
+	}
+	return b, nil
+}
+
+// Scans returns how many scans the recording holds
+func (b *Backend) Scans() int {
+	return len(b.scans)
+}
+
+// current is the index of the scan replayed last
+func (b *Backend) current() int {
+	if b.next == 0 {
+		return 0
+	}
+	return b.next - 1
+}
+
+// Devices answers the listing of the next scan of the recording
+func (b *Backend) Devices(ctx context.Context) ([]peripherals.Device, error) {
+	b.mu.Lock()
+	if b.next < len(b.scans) {
+		b.next++
+		if b.next == len(b.scans) {
+			log.Infof("Replayed the %d scans of the recording, repeating the last one", len(b.scans))
+		}
+	}
+	scan := b.scans[b.current()]
+	b.mu.Unlock()
+
+	devices := append([]peripherals.Device(nil), scan.Devices...)
+	if b.onVisit != nil {
+		for _, device := range devices {
+			b.onVisit(device.Identifier(), device.DevicePath())
+		}
+	}
+	if len(scan.Error) > 0 {
+		return devices, errors.New(scan.Error)
+	}
+	return devices, nil
+}
+
+// SerialNumber answers the serial number recorded for the node during the
+// scan replayed, or the last one recorded before it
+func (b *Backend) SerialNumber(ctx context.Context, devicePath string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for scan := b.current(); scan >= 0; scan-- {
+		if serialNumber, ok := b.serials[scan][devicePath]; ok {
+			return serialNumber
+		}
+	}
+	return ""
+}
+
+// OnVisit registers a function called for each device replayed
+func (b *Backend) OnVisit(f peripherals.VisitFunc) {
+	b.onVisit = f
+}
+
+// OpenDFU fails, the devices of a recording cannot be flashed. It implements
+// dfu.Opener.
+func (b *Backend) OpenDFU(bus int, address int) (dfu.Device, error) {
+	return nil, fmt.Errorf("unable to open the device at bus %d address %d: the devices are replayed from a recording", bus, address)
+}
+
+// Close has nothing to release
+func (b *Backend) Close() error {
+	return nil
+}
@@ -0,0 +1,145 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+type listing struct {
+	devices []peripherals.Device
+	err     error
+}
+
+type scriptedBackend struct {
+	listings []listing
+}
+
+func (b *scriptedBackend) Devices(ctx context.Context) ([]peripherals.Device, error) {
+	l := b.listings[0]
+	b.listings = b.listings[1:]
+	return l.devices, l.err
+}
+
+func (b *scriptedBackend) Close() error {
+	return nil
+}
+
+type serialProber map[string]string
+
+func (p serialProber) SerialNumber(ctx context.Context, devicePath string) string {
+	return p[devicePath]
+}
+
+var (
+	camera = peripherals.Device{Bus: 1, Address: 4, VendorID: 0x046d, ProductID: 0x0825, Speed: peripherals.SpeedHigh,
+		ProductName: "Webcam C270", Interfaces: []peripherals.InterfaceSetting{
+			{Number: 1, Alternate: 1, Class: peripherals.ClassVideo, ClassName: "Video", Endpoints: []peripherals.Endpoint{
+				{Number: 1, In: true, Transfer: peripherals.TransferIsochronous, MaxPacketSize: 944, Interval: 125 * time.Microsecond}}}}}
+	stick = peripherals.Device{Bus: 2, Address: 3, VendorID: 0x0781, ProductID: 0x5581,
+		Interfaces: []peripherals.InterfaceSetting{{Class: peripherals.ClassMassStorage, ClassName: "Mass Storage"}}}
+)
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	backend := &scriptedBackend{listings: []listing{
+		{devices: []peripherals.Device{camera}},
+		{devices: []peripherals.Device{camera, stick}},
+		{devices: []peripherals.Device{stick}, err: errors.New("libusb: timeout")},
+		{devices: []peripherals.Device{stick}},
+	}}
+	prober := serialProber{camera.DevicePath(): "2F3C1A40", stick.DevicePath(): "4C530001"}
+
+	recorder, err := NewRecorder(path, backend, "libusb", prober)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for scan := 0; scan < 4; scan++ {
+		devices, _ := recorder.Devices(context.Background())
+		if scan == 0 {
+			recorder.SerialNumber(context.Background(), camera.DevicePath())
+		}
+		if scan == 1 {
+			recorder.SerialNumber(context.Background(), stick.DevicePath())
+		}
+		if len(devices) == 0 {
+			t.Fatalf("Scan %d listed no device", scan)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 7 {
+		t.Fatalf("Recorded %d entries, expected 7:\n%s", len(lines), data)
+	}
+	if !strings.Contains(lines[3], `"attached":["0781:5581 /dev/bus/usb/002/003"]`) {
+		t.Errorf("Scan 1 = %s", lines[3])
+	}
+	// The failed listing tells nothing about the camera, the next one does
+	if strings.Contains(lines[5], "detached") || !strings.Contains(lines[6], `"detached":["046d:0825 /dev/bus/usb/001/004"]`) {
+		t.Errorf("Scans 2 and 3 = %s\n%s", lines[5], lines[6])
+	}
+
+	replayed, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Header.Backend != "libusb" || replayed.Scans() != 4 {
+		t.Errorf("Header = %+v, %d scans", replayed.Header, replayed.Scans())
+	}
+
+	var visited []string
+	replayed.OnVisit(func(identifier string, devicePath string) {
+		visited = append(visited, identifier)
+	})
+	devices, err := replayed.Devices(context.Background())
+	if err != nil || !reflect.DeepEqual(devices, []peripherals.Device{camera}) {
+		t.Errorf("Scan 0 = %+v, %v", devices, err)
+	}
+	if serial := replayed.SerialNumber(context.Background(), camera.DevicePath()); serial != "2F3C1A40" {
+		t.Errorf("Serial number of the camera = %q", serial)
+	}
+	replayed.Devices(context.Background())
+	if _, err := replayed.Devices(context.Background()); err == nil || err.Error() != "libusb: timeout" {
+		t.Errorf("Scan 2 error = %v", err)
+	}
+	// Serial numbers looked up in an earlier scan are still answered
+	if serial := replayed.SerialNumber(context.Background(), stick.DevicePath()); serial != "4C530001" {
+		t.Errorf("Serial number of the stick = %q", serial)
+	}
+	replayed.Devices(context.Background())
+	// The last scan is repeated once the recording is exhausted
+	if devices, _ := replayed.Devices(context.Background()); !reflect.DeepEqual(devices, []peripherals.Device{stick}) {
+		t.Errorf("Scan after the end = %+v", devices)
+	}
+	if len(visited) != 6 {
+		t.Errorf("Visited %v", visited)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"type":"header","version":1}`,
+		`{"type":"header","version":2}` + "\n" + `{"type":"scan","scan":0}`,
+		`{"type":"scan","scan":0}` + "\n" + `{"type":"event"}`,
+		`not json`,
+	} {
+		path := filepath.Join(t.TempDir(), "session.jsonl")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(path); err == nil {
+			t.Errorf("Recording %q was loaded", content)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package peripherals
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ClassSmartCard is the class of the CCID interfaces of smart card readers
+// and of the tokens embedding a smart card
+const ClassSmartCard uint8 = 0x0b
+
+// usagePageFIDO is the usage page of the FIDO U2F and FIDO2 HID interfaces
+const usagePageFIDO = 0xf1d0
+
+// Kinds of security tokens
+const (
+	TokenSecurityKey     = "security-key"
+	TokenHSM             = "hsm"
+	TokenSmartCardReader = "smart-card-reader"
+)
+
+// Applications of the security tokens
+const (
+	TokenFIDO      = "fido"
+	TokenOTP       = "otp"
+	TokenSmartCard = "smart-card"
+)
+
+// SecurityToken describes a security key, hardware security module or smart
+// card reader. It only tells what the token offers, nothing it holds.
+type SecurityToken struct {
+	Kind  string `json:"kind"`
+	Model string `json:"model"`
+	// Applications are the interfaces the token exposes
+	Applications []string `json:"applications"`
+	// Slots is the number of smart card slots of the CCID interface
+	Slots int `json:"slots,omitempty"`
+}
+
+// securityKeyVendors make mostly security keys, whose keyboard interface is an
+// OTP generator rather than a keyboard
+var securityKeyVendors = map[uint16]bool{
+	0x1050: true, // Yubico
+	0x20a0: true, // Nitrokey
+	0x096e: true, // Feitian
+	0x1ea8: true, // Thetis
+	0x311f: true, // Token2
+}
+
+// hsmModels are the USB hardware security modules
+var hsmModels = map[string]string{
+	"1050:0030": "YubiHSM 2",
+	"20a0:4230": "Nitrokey HSM 2",
+}
+
+// probeSecurityToken reports the security keys, from their FIDO interfaces or
+// vendor, the hardware security modules and the smart card readers
+//
+//	"security-token": {"kind": "security-key", "model": "YubiKey OTP+FIDO+CCID",
+//	                   "applications": ["fido", "otp", "smart-card"], "slots": 1}
+func (d *Discoverer) probeSecurityToken(device Device, peripheral *Peripheral) {
+	applications := map[string]bool{}
+	knownVendor := securityKeyVendors[device.VendorID]
+	for _, node := range peripheral.HID {
+		for _, t := range node.Types {
+			switch {
+			case t == HIDSecurityKey:
+				applications[TokenFIDO] = true
+			case t == HIDKeyboard && knownVendor:
+				applications[TokenOTP] = true
+			}
+		}
+	}
+	if device.HasInterfaceClass(ClassSmartCard) {
+		applications[TokenSmartCard] = true
+	}
+
+	token := SecurityToken{Model: device.ProductName}
+	if model, ok := hsmModels[device.Identifier()]; ok {
+		token.Kind, token.Model = TokenHSM, model
+	} else if applications[TokenFIDO] || (knownVendor && len(applications) > 0) {
+		token.Kind = TokenSecurityKey
+	} else if applications[TokenSmartCard] {
+		token.Kind = TokenSmartCardReader
+	} else {
+		return
+	}
+	if len(token.Model) == 0 {
+		token.Model = device.Identifier()
+	}
+	token.Applications = make([]string, 0, len(applications))
+	for application := range applications {
+		token.Applications = append(token.Applications, application)
+	}
+	sort.Strings(token.Applications)
+	if applications[TokenSmartCard] {
+		if dir, ok := d.usbDeviceDir(device); ok {
+			token.Slots = ccidSlots(filepath.Join(dir, "descriptors"))
+		}
+	}
+	peripheral.SecurityToken = &token
+}
+
+// ccidSlots counts the slots of the CCID interfaces, from the bMaxSlotIndex of
+// their class descriptors in the raw descriptors sysfs holds
+func ccidSlots(descriptorsPath string) int {
+	data, err := ioutil.ReadFile(descriptorsPath)
+	if err != nil {
+		return 0
+	}
+	slots := 0
+	smartCard := false
+	for i := 0; i+1 < len(data); {
+		length, kind := int(data[i]), data[i+1]
+		if length < 2 || i+length > len(data) {
+			break
+		}
+		switch {
+		case kind == 0x04 && length >= 9:
+			smartCard = data[i+5] == ClassSmartCard
+		case kind == 0x21 && smartCard && length >= 5:
+			slots += int(data[i+4]) + 1
+			smartCard = false
+		}
+		i += length
+	}
+	return slots
+}
+
+// Compliance tells whether the security tokens required on the edge are
+// attached
+type Compliance struct {
+	Compliant bool            `json:"compliant"`
+	Required  []string        `json:"required"`
+	Missing   []string        `json:"missing,omitempty"`
+	Tokens    []AttachedToken `json:"tokens"`
+}
+
+// AttachedToken is a security token attached, identified by its model only:
+// the serial numbers stay in the records, where they can be redacted
+type AttachedToken struct {
+	Identifier string `json:"identifier"`
+	SecurityToken
+}
+
+// CheckCompliance matches the security tokens discovered with the required
+// ones. A requirement is a kind of token, as hsm, or a shell pattern of the
+// identifiers or models of the tokens, as 1050:* or YubiKey*, compared
+// case-insensitively.
+func CheckCompliance(required []string, discovered []Peripheral) Compliance {
+	compliance := Compliance{Required: required, Tokens: []AttachedToken{}}
+	for _, peripheral := range discovered {
+		if peripheral.SecurityToken != nil {
+			identifier := strings.Join(strings.SplitN(peripheral.Identifier, ":", 3)[:2], ":")
+			compliance.Tokens = append(compliance.Tokens, AttachedToken{Identifier: identifier, SecurityToken: *peripheral.SecurityToken})
+		}
+	}
+	sort.Slice(compliance.Tokens, func(i, j int) bool { return compliance.Tokens[i].Identifier < compliance.Tokens[j].Identifier })
+	for _, requirement := range required {
+		pattern := strings.ToLower(requirement)
+		present := false
+		for _, token := range compliance.Tokens {
+			for _, value := range []string{token.Kind, token.Identifier, token.Model} {
+				if matched, _ := path.Match(pattern, strings.ToLower(value)); matched {
+					present = true
+				}
+			}
+		}
+		if !present {
+			compliance.Missing = append(compliance.Missing, requirement)
+		}
+	}
+	compliance.Compliant = len(compliance.Missing) == 0
+	return compliance
+}
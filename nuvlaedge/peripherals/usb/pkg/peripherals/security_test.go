@@ -0,0 +1,78 @@
+package peripherals
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fidoDescriptor is the report descriptor of a FIDO U2F HID interface
+var fidoDescriptor = []byte{
+	0x06, 0xd0, 0xf1, // Usage Page (FIDO Alliance)
+	0x09, 0x01, // Usage (U2F Authenticator Device)
+	0xa1, 0x01, // Collection (Application)
+	0x09, 0x20, 0x15, 0x00, 0x26, 0xff, 0x00, 0x75, 0x08, 0x95, 0x40, 0x81, 0x02,
+	0xc0, // End Collection
+}
+
+func TestProbeSecurityToken(t *testing.T) {
+	sysfs := t.TempDir()
+	fakeHostDevice(t, sysfs, "1-1", "1", "4", "1050", "0407")
+	yubikeyDir := filepath.Join(sysfs, "devices", "pci0000:00", "usb1", "1-1")
+	// Configuration, CCID interface and its class descriptor with two slots
+	writeFile(t, filepath.Join(yubikeyDir, "descriptors"), string([]byte{
+		0x09, 0x02, 0x5d, 0x00, 0x03, 0x01, 0x00, 0x80, 0x1e,
+		0x09, 0x04, 0x02, 0x00, 0x00, 0x0b, 0x00, 0x00, 0x00,
+		0x36, 0x21, 0x00, 0x01, 0x01, 0x05, 0x02, 0x00, 0x00, 0x00, 0xa0, 0x0f, 0x00, 0x00, 0xa0, 0x0f, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}))
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs))
+	yubikey := Device{Bus: 1, Address: 4, VendorID: 0x1050, ProductID: 0x0407, ProductName: "YubiKey OTP+FIDO+CCID",
+		Interfaces: []InterfaceSetting{{Number: 0, Class: ClassHID}, {Number: 1, Class: ClassHID}, {Number: 2, Class: ClassSmartCard}}}
+	peripheral := Peripheral{HID: []HIDInterface{
+		{DevicePath: "/dev/hidraw0", Types: classifyHID(parseReportDescriptor(bootKeyboardDescriptor), yubikey)},
+		{DevicePath: "/dev/hidraw1", Types: classifyHID(parseReportDescriptor(fidoDescriptor), yubikey)},
+	}}
+	d.probeSecurityToken(yubikey, &peripheral)
+	expected := &SecurityToken{Kind: TokenSecurityKey, Model: "YubiKey OTP+FIDO+CCID",
+		Applications: []string{TokenFIDO, TokenOTP, TokenSmartCard}, Slots: 2}
+	if !reflect.DeepEqual(peripheral.SecurityToken, expected) {
+		t.Errorf("YubiKey = %+v", peripheral.SecurityToken)
+	}
+
+	for _, c := range []struct {
+		device   Device
+		expected *SecurityToken
+	}{
+		{Device{VendorID: 0x1050, ProductID: 0x0030, Interfaces: []InterfaceSetting{{Class: ClassVendorSpecific}}},
+			&SecurityToken{Kind: TokenHSM, Model: "YubiHSM 2", Applications: []string{}}},
+		{Device{VendorID: 0x20a0, ProductID: 0x4230, Interfaces: []InterfaceSetting{{Class: ClassSmartCard}}},
+			&SecurityToken{Kind: TokenHSM, Model: "Nitrokey HSM 2", Applications: []string{TokenSmartCard}}},
+		{Device{VendorID: 0x04e6, ProductID: 0x5116, ProductName: "SCR3310", Interfaces: []InterfaceSetting{{Class: ClassSmartCard}}},
+			&SecurityToken{Kind: TokenSmartCardReader, Model: "SCR3310", Applications: []string{TokenSmartCard}}},
+		{Device{VendorID: 0x046d, ProductID: 0xc31c, Interfaces: []InterfaceSetting{{Class: ClassHID}}}, nil},
+	} {
+		peripheral := Peripheral{}
+		d.probeSecurityToken(c.device, &peripheral)
+		if !reflect.DeepEqual(peripheral.SecurityToken, c.expected) {
+			t.Errorf("Token of %s = %+v, expected %+v", c.device.Identifier(), peripheral.SecurityToken, c.expected)
+		}
+	}
+}
+
+func TestCheckCompliance(t *testing.T) {
+	discovered := []Peripheral{
+		{Identifier: "1050:0407:12345", SecurityToken: &SecurityToken{Kind: TokenSecurityKey, Model: "YubiKey OTP+FIDO+CCID"}},
+		{Identifier: "046d:0825"},
+	}
+	compliance := CheckCompliance([]string{"security-key", "yubikey*", "1050:*", "hsm"}, discovered)
+	if compliance.Compliant || !reflect.DeepEqual(compliance.Missing, []string{"hsm"}) || len(compliance.Tokens) != 1 ||
+		compliance.Tokens[0].Identifier != "1050:0407" {
+		t.Errorf("Compliance = %+v", compliance)
+	}
+	if compliance := CheckCompliance([]string{"1050:0407"}, discovered); !compliance.Compliant {
+		t.Errorf("Compliance = %+v", compliance)
+	}
+}
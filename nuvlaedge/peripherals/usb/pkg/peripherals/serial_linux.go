@@ -0,0 +1,60 @@
+package peripherals
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// querySerial configures the serial port at 115200 baud, 8N1, writes the
+// request when there is one, and reads until complete accepts the data, limit
+// bytes were read or the timeout expired
+func querySerial(ctx context.Context, path string, timeout time.Duration, request []byte,
+	complete func([]byte) bool, limit int) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	termios := syscall.Termios{
+		Cflag:  syscall.B115200 | syscall.CS8 | syscall.CREAD | syscall.CLOCAL,
+		Ispeed: syscall.B115200,
+		Ospeed: syscall.B115200,
+	}
+	termios.Cc[syscall.VMIN] = 1
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return nil, errno
+	}
+
+	deadline := waitDeadline(ctx, timeout)
+	if err := f.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if len(request) > 0 {
+		if _, err := f.Write(request); err != nil {
+			return nil, err
+		}
+	}
+
+	var data []byte
+	buf := make([]byte, 1024)
+	for len(data) < limit {
+		n, err := f.Read(buf)
+		data = append(data, buf[:n]...)
+		if complete(data) {
+			return data, nil
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return data, errSerialTimeout
+			}
+			return data, err
+		}
+	}
+	return data, errSerialLimit
+}
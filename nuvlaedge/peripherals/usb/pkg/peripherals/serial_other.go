@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package peripherals
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func querySerial(ctx context.Context, path string, timeout time.Duration, request []byte,
+	complete func([]byte) bool, limit int) ([]byte, error) {
+	return nil, errors.New("serial ports can only be read on Linux")
+}
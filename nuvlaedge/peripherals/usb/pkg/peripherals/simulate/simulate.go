@@ -0,0 +1,384 @@
+// Package simulate implements a peripherals.Backend attaching and detaching
+// synthetic devices along the timelines of a scenario file, so the manager,
+// the agent and the UI can be demonstrated, developed and tested without any
+// hardware.
+//
+// A scenario lists the devices, each made from a template or described in
+// full, with the times they are attached and detached at, counted from the
+// start of the simulation:
+//
+//	{
+//	  "repeat": "10m",
+//	  "devices": [
+//	    {"template": "camera", "serial-number": "2F3C1A40"},
+//	    {"template": "storage", "timeline": [
+//	      {"at": "30s", "event": "attach"}, {"at": "5m", "event": "detach"}]},
+//	    {"name": "Weather station", "vendor-id": "2341", "product-id": "0043",
+//	     "vendor": "Arduino SA", "product": "Uno R3", "speed": "full",
+//	     "interfaces": [{"class": 2, "class-name": "Communications"}],
+//	     "attributes": {"serial-devices": ["/dev/ttyACM0"]}}
+//	  ]
+//	}
+//
+// A device without timeline stays attached, and one whose first event is an
+// attachment is detached until then. With repeat, the timelines start over
+// at every period.
+//
+// The templates are camera, storage, sensor and keyboard. Their records get
+// the device nodes the kernel would have created, numbered in the order of
+// the devices, and the attributes of the device override the ones of the
+// template. Every simulated record is marked with the simulated attribute.
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/dfu"
+)
+
+// Events of the timelines
+const (
+	Attach = "attach"
+	Detach = "detach"
+)
+
+// Attribute marks the simulated records
+const Attribute = "simulated"
+
+// Duration is a duration written as in Go, as 90s or 5m
+type Duration time.Duration
+
+// UnmarshalJSON decodes the duration from its string form
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("invalid duration %s, expected a string such as \"90s\"", data)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Event attaches or detaches a device at a time of the timeline
+type Event struct {
+	At    Duration `json:"at"`
+	Event string   `json:"event"`
+}
+
+// Interface is an interface of a device described in full
+type Interface struct {
+	Class     uint8  `json:"class"`
+	SubClass  uint8  `json:"subclass"`
+	Protocol  uint8  `json:"protocol"`
+	ClassName string `json:"class-name"`
+}
+
+// Device is a simulated device. The fields set override the ones of the
+// template.
+type Device struct {
+	Template     string                 `json:"template"`
+	Name         string                 `json:"name"`
+	Bus          int                    `json:"bus"`
+	Address      int                    `json:"address"`
+	VendorID     string                 `json:"vendor-id"`
+	ProductID    string                 `json:"product-id"`
+	Vendor       string                 `json:"vendor"`
+	Product      string                 `json:"product"`
+	SerialNumber string                 `json:"serial-number"`
+	Speed        string                 `json:"speed"`
+	Interfaces   []Interface            `json:"interfaces"`
+	Attributes   map[string]interface{} `json:"attributes"`
+	Timeline     []Event                `json:"timeline"`
+}
+
+// Scenario is the content of a scenario file
+type Scenario struct {
+	Repeat  Duration `json:"repeat"`
+	Devices []Device `json:"devices"`
+}
+
+// Load reads a scenario file
+func Load(path string) (Scenario, error) {
+	var scenario Scenario
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return scenario, err
+	}
+	if err := json.Unmarshal(data, &scenario); err != nil {
+		return scenario, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	return scenario, nil
+}
+
+// simulated is a device of the scenario, ready to be listed
+type simulated struct {
+	device       peripherals.Device
+	serialNumber string
+	attributes   map[string]interface{}
+	timeline     []Event
+}
+
+// attached tells whether the device is attached at a time of its timeline
+func (s simulated) attached(elapsed time.Duration) bool {
+	if len(s.timeline) == 0 {
+		return true
+	}
+	attached := s.timeline[0].Event != Attach
+	for _, event := range s.timeline {
+		if time.Duration(event.At) > elapsed {
+			break
+		}
+		attached = event.Event == Attach
+	}
+	return attached
+}
+
+// Backend lists the devices of a scenario attached at the current time. It
+// also implements peripherals.Prober, answering the serial numbers of the
+// scenario.
+type Backend struct {
+	repeat  time.Duration
+	devices []simulated
+	start   time.Time
+	now     func() time.Time
+
+	onVisit peripherals.VisitFunc
+}
+
+// New starts the simulation of the scenario
+func New(scenario Scenario) (*Backend, error) {
+	b := &Backend{repeat: time.Duration(scenario.Repeat), start: time.Now(), now: time.Now}
+	if b.repeat < 0 {
+		return nil, fmt.Errorf("invalid scenario repeat period %s", b.repeat)
+	}
+
+	counts := map[string]int{}
+	addresses := map[string]bool{}
+	for i, d := range scenario.Devices {
+		s, err := build(d, counts[d.Template])
+		if err != nil {
+			return nil, fmt.Errorf("invalid simulated device %d: %w", i+1, err)
+		}
+		counts[d.Template]++
+
+		if s.device.Bus == 0 {
+			s.device.Bus = 1
+		}
+		if s.device.Address == 0 {
+			// Address 1 is the root hub of the bus
+			s.device.Address = i + 2
+		}
+		node := s.device.DevicePath()
+		if addresses[node] {
+			return nil, fmt.Errorf("invalid simulated device %d: %s is already used", i+1, node)
+		}
+		addresses[node] = true
+		b.devices = append(b.devices, s)
+	}
+	return b, nil
+}
+
+// build makes the device from its template. index is the number of devices
+// made from the same template before it.
+func build(d Device, index int) (simulated, error) {
+	s := simulated{attributes: map[string]interface{}{Attribute: true}}
+	if len(d.Template) > 0 {
+		t, ok := templates[d.Template]
+		if !ok {
+			return s, fmt.Errorf("unknown template %q, expected one of %s", d.Template, strings.Join(Templates(), ", "))
+		}
+		s.device = t.device
+		s.device.Interfaces = append([]peripherals.InterfaceSetting(nil), t.device.Interfaces...)
+		s.serialNumber = fmt.Sprintf("%s%04d", t.serialPrefix, index+1)
+		for key, value := range t.attributes(index) {
+			s.attributes[key] = value
+		}
+	}
+
+	s.device.Bus, s.device.Address = d.Bus, d.Address
+	for _, id := range []struct {
+		text  string
+		value *uint16
+		name  string
+	}{{d.VendorID, &s.device.VendorID, "vendor-id"}, {d.ProductID, &s.device.ProductID, "product-id"}} {
+		if len(id.text) == 0 {
+			continue
+		}
+		parsed, err := strconv.ParseUint(id.text, 16, 16)
+		if err != nil {
+			return s, fmt.Errorf("invalid %s %q, expected 4 hexadecimal digits", id.name, id.text)
+		}
+		*id.value = uint16(parsed)
+	}
+	if len(d.Template) == 0 && (len(d.VendorID) == 0 || len(d.ProductID) == 0) {
+		return s, fmt.Errorf("a template or the vendor-id and product-id are required")
+	}
+
+	if len(d.Vendor) > 0 {
+		s.device.VendorName = d.Vendor
+	}
+	if len(d.Product) > 0 {
+		s.device.ProductName = d.Product
+	}
+	if len(d.SerialNumber) > 0 {
+		s.serialNumber = d.SerialNumber
+	}
+	if len(d.Name) > 0 {
+		s.attributes["name"] = d.Name
+	}
+	if len(d.Speed) > 0 {
+		speed, ok := parseSpeed(d.Speed)
+		if !ok {
+			return s, fmt.Errorf("unknown speed %q", d.Speed)
+		}
+		s.device.Speed = speed
+	}
+	if len(d.Interfaces) > 0 {
+		s.device.Interfaces = nil
+		for n, i := range d.Interfaces {
+			name := i.ClassName
+			if len(name) == 0 {
+				name = "unknown"
+			}
+			s.device.Interfaces = append(s.device.Interfaces, peripherals.InterfaceSetting{
+				Number: n, Class: i.Class, SubClass: i.SubClass, Protocol: i.Protocol, ClassName: name,
+			})
+		}
+	}
+	for key, value := range d.Attributes {
+		s.attributes[key] = value
+	}
+
+	for _, event := range d.Timeline {
+		if event.Event != Attach && event.Event != Detach {
+			return s, fmt.Errorf("unknown event %q, expected %s or %s", event.Event, Attach, Detach)
+		}
+	}
+	s.timeline = append([]Event(nil), d.Timeline...)
+	sort.SliceStable(s.timeline, func(i, j int) bool { return s.timeline[i].At < s.timeline[j].At })
+	return s, nil
+}
+
+func parseSpeed(name string) (peripherals.Speed, bool) {
+	for speed := peripherals.SpeedLow; speed <= peripherals.SpeedSuperPlus; speed++ {
+		if speed.String() == name {
+			return speed, true
+		}
+	}
+	return peripherals.SpeedUnknown, false
+}
+
+// elapsed is the time of the timelines now
+func (b *Backend) elapsed() time.Duration {
+	elapsed := b.now().Sub(b.start)
+	if b.repeat > 0 {
+		elapsed %= b.repeat
+	}
+	return elapsed
+}
+
+// Devices lists the devices attached at the current time of the scenario
+func (b *Backend) Devices(ctx context.Context) ([]peripherals.Device, error) {
+	elapsed := b.elapsed()
+	var devices []peripherals.Device
+	for _, s := range b.devices {
+		if ctx.Err() != nil {
+			return devices, ctx.Err()
+		}
+		if !s.attached(elapsed) {
+			continue
+		}
+		if b.onVisit != nil {
+			b.onVisit(s.device.Identifier(), s.device.DevicePath())
+		}
+		devices = append(devices, s.device)
+	}
+	return devices, nil
+}
+
+// SerialNumber answers the serial number of the simulated device on the
+// usbfs node, empty for any other node
+func (b *Backend) SerialNumber(ctx context.Context, devicePath string) string {
+	for _, s := range b.devices {
+		if s.device.DevicePath() == devicePath {
+			return s.serialNumber
+		}
+	}
+	return ""
+}
+
+// Decorate adds the attributes of the simulated devices to their records, as
+// the device nodes the simulation cannot create
+func (b *Backend) Decorate(records []peripherals.Peripheral) []peripherals.Peripheral {
+	attributes := map[string]map[string]interface{}{}
+	serialNumbers := map[string]string{}
+	for _, s := range b.devices {
+		attributes[s.device.DevicePath()] = s.attributes
+		serialNumbers[s.device.DevicePath()] = s.serialNumber
+	}
+
+	decorated := make([]peripherals.Peripheral, 0, len(records))
+	for _, record := range records {
+		overlay, ok := attributes[record.DevicePath]
+		if !ok {
+			decorated = append(decorated, record)
+			continue
+		}
+		if len(record.SerialNumber) == 0 {
+			record.SerialNumber = serialNumbers[record.DevicePath]
+		}
+		decorated = append(decorated, overlayAttributes(record, overlay))
+	}
+	return decorated
+}
+
+// overlayAttributes sets the attributes on the record as if it was decoded
+// with them, so the typed ones go to their fields
+func overlayAttributes(record peripherals.Peripheral, overlay map[string]interface{}) peripherals.Peripheral {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return record
+	}
+	merged := map[string]interface{}{}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return record
+	}
+	for key, value := range overlay {
+		merged[key] = value
+	}
+	if data, err = json.Marshal(merged); err != nil {
+		return record
+	}
+	var decorated peripherals.Peripheral
+	if err := json.Unmarshal(data, &decorated); err != nil {
+		return record
+	}
+	return decorated
+}
+
+// OnVisit registers a function called for each device listed
+func (b *Backend) OnVisit(f peripherals.VisitFunc) {
+	b.onVisit = f
+}
+
+// OpenDFU fails, the simulated devices have no firmware to update. It
+// implements dfu.Opener.
+func (b *Backend) OpenDFU(bus int, address int) (dfu.Device, error) {
+	return nil, fmt.Errorf("unable to open the device at bus %d address %d: simulated devices have no firmware to update", bus, address)
+}
+
+// Close has nothing to release
+func (b *Backend) Close() error {
+	return nil
+}
@@ -0,0 +1,126 @@
+package simulate
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+const scenario = `{
+  "repeat": "10m",
+  "devices": [
+    {"template": "camera", "serial-number": "CAM1"},
+    {"template": "camera"},
+    {"template": "storage", "timeline": [
+      {"at": "5m", "event": "detach"}, {"at": "30s", "event": "attach"}]},
+    {"name": "Weather station", "vendor-id": "2341", "product-id": "0043", "speed": "full",
+     "interfaces": [{"class": 2, "class-name": "Communications"}],
+     "attributes": {"serial-devices": ["/dev/ttyACM0"], "location": "roof"},
+     "timeline": [{"at": "1m", "event": "detach"}]}
+  ]
+}`
+
+func load(t *testing.T, content string) Scenario {
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func listed(t *testing.T, b *Backend, at time.Duration) []string {
+	b.now = func() time.Time { return b.start.Add(at) }
+	devices, err := b.Devices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes []string
+	for _, d := range devices {
+		nodes = append(nodes, d.Identifier()+" "+d.DevicePath())
+	}
+	return nodes
+}
+
+func TestTimeline(t *testing.T) {
+	b, err := New(load(t, scenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		at       time.Duration
+		expected []string
+	}{
+		{0, []string{"046d:0825 /dev/bus/usb/001/002", "046d:0825 /dev/bus/usb/001/003", "2341:0043 /dev/bus/usb/001/005"}},
+		{2 * time.Minute, []string{"046d:0825 /dev/bus/usb/001/002", "046d:0825 /dev/bus/usb/001/003", "0781:5581 /dev/bus/usb/001/004"}},
+		{6 * time.Minute, []string{"046d:0825 /dev/bus/usb/001/002", "046d:0825 /dev/bus/usb/001/003"}},
+		// The timelines start over
+		{10*time.Minute + 10*time.Second, []string{"046d:0825 /dev/bus/usb/001/002", "046d:0825 /dev/bus/usb/001/003", "2341:0043 /dev/bus/usb/001/005"}},
+	} {
+		nodes := listed(t, b, c.at)
+		if len(nodes) != len(c.expected) {
+			t.Fatalf("Devices at %s = %v, expected %v", c.at, nodes, c.expected)
+		}
+		for i := range nodes {
+			if nodes[i] != c.expected[i] {
+				t.Errorf("Devices at %s = %v, expected %v", c.at, nodes, c.expected)
+			}
+		}
+	}
+
+	if serial := b.SerialNumber(context.Background(), "/dev/bus/usb/001/003"); serial != "2F3C0002" {
+		t.Errorf("Serial number of the second camera = %q", serial)
+	}
+}
+
+func TestDecorate(t *testing.T) {
+	b, err := New(load(t, scenario))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := b.Decorate([]peripherals.Peripheral{
+		{Identifier: "046d:0825:CAM1", Name: "Webcam C270", DevicePath: "/dev/bus/usb/001/003"},
+		{Identifier: "2341:0043", Name: "Uno", DevicePath: "/dev/bus/usb/001/005"},
+		{Identifier: "1d6b:0002", Name: "Hub", DevicePath: "/dev/bus/usb/002/001"},
+	})
+
+	camera := records[0]
+	if camera.VideoDevice != "/dev/video2" || len(camera.VideoDevices) != 2 || camera.SerialNumber != "2F3C0002" {
+		t.Errorf("Camera = %+v", camera)
+	}
+	station := records[1]
+	if station.Name != "Weather station" || len(station.SerialDevices) != 1 || station.Attributes["location"] != "roof" ||
+		station.Attributes[Attribute] != true {
+		t.Errorf("Weather station = %+v", station)
+	}
+	if hub := records[2]; hub.Attributes != nil {
+		t.Errorf("The real hub was decorated: %+v", hub)
+	}
+}
+
+func TestInvalidScenario(t *testing.T) {
+	for _, content := range []string{
+		`{"devices": [{"template": "toaster"}]}`,
+		`{"devices": [{"vendor-id": "2341"}]}`,
+		`{"devices": [{"template": "camera", "timeline": [{"at": "1m", "event": "unplug"}]}]}`,
+		`{"devices": [{"template": "camera", "address": 4}, {"template": "sensor", "address": 4}]}`,
+	} {
+		if _, err := New(load(t, content)); err == nil {
+			t.Errorf("Scenario %s was accepted", content)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "scenario.json")
+	_ = ioutil.WriteFile(path, []byte(`{"repeat": 600}`), 0644)
+	if _, err := Load(path); err == nil {
+		t.Error("A repeat period without unit was accepted")
+	}
+}
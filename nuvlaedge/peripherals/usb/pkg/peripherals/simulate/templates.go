@@ -0,0 +1,114 @@
+package simulate
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+// template is a common device model, with the attributes the probes of the
+// device nodes would add to its record
+type template struct {
+	device       peripherals.Device
+	serialPrefix string
+	attributes   func(index int) map[string]interface{}
+}
+
+var templates = map[string]template{
+	"camera": {
+		device: peripherals.Device{
+			VendorID: 0x046d, ProductID: 0x0825, Revision: 0x0012, Speed: peripherals.SpeedHigh,
+			VendorName: "Logitech, Inc.", ProductName: "Webcam C270",
+			Classification: "Miscellaneous Device, Interface Association",
+			Interfaces: []peripherals.InterfaceSetting{
+				{Number: 0, Class: peripherals.ClassVideo, SubClass: 1, ClassName: "Video"},
+				{Number: 1, Class: peripherals.ClassVideo, SubClass: 2, ClassName: "Video"},
+				{Number: 1, Alternate: 1, Class: peripherals.ClassVideo, SubClass: 2, ClassName: "Video",
+					Endpoints: []peripherals.Endpoint{{Number: 1, In: true, Transfer: peripherals.TransferIsochronous,
+						MaxPacketSize: 944, Interval: 125 * time.Microsecond}}},
+				{Number: 2, Class: peripherals.ClassAudio, SubClass: 1, ClassName: "Audio"},
+				{Number: 3, Class: peripherals.ClassAudio, SubClass: 2, ClassName: "Audio"},
+			},
+		},
+		serialPrefix: "2F3C",
+		attributes: func(index int) map[string]interface{} {
+			video := fmt.Sprintf("/dev/video%d", 2*index)
+			return map[string]interface{}{
+				"video-device":  video,
+				"video-devices": []interface{}{video, fmt.Sprintf("/dev/video%d", 2*index+1)},
+			}
+		},
+	},
+	"storage": {
+		device: peripherals.Device{
+			VendorID: 0x0781, ProductID: 0x5581, Revision: 0x0100, Speed: peripherals.SpeedSuper,
+			VendorName: "SanDisk Corp.", ProductName: "Ultra",
+			Classification: "(Defined at Interface level)",
+			Interfaces: []peripherals.InterfaceSetting{
+				{Number: 0, Class: peripherals.ClassMassStorage, SubClass: 6, Protocol: 0x50, ClassName: "Mass Storage"},
+			},
+		},
+		serialPrefix: "4C5300",
+		attributes: func(index int) map[string]interface{} {
+			disk := fmt.Sprintf("/dev/sd%c", 'a'+index%26)
+			return map[string]interface{}{
+				"storage": []interface{}{map[string]interface{}{
+					"device-path": disk,
+					"size":        int64(32017047552),
+					"removable":   true,
+					"read-only":   false,
+					"partitions":  []interface{}{disk + "1"},
+					"protected":   false,
+				}},
+			}
+		},
+	},
+	"sensor": {
+		device: peripherals.Device{
+			VendorID: 0x1a86, ProductID: 0x7523, Revision: 0x0264, Speed: peripherals.SpeedFull,
+			VendorName: "QinHeng Electronics", ProductName: "CH340 serial converter",
+			Classification: "Vendor Specific Class",
+			Interfaces: []peripherals.InterfaceSetting{
+				{Number: 0, Class: 0xff, SubClass: 1, Protocol: 2, ClassName: "Vendor Specific Class"},
+			},
+		},
+		serialPrefix: "CH",
+		attributes: func(index int) map[string]interface{} {
+			return map[string]interface{}{
+				"serial-devices": []interface{}{fmt.Sprintf("/dev/ttyUSB%d", index)},
+			}
+		},
+	},
+	"keyboard": {
+		device: peripherals.Device{
+			VendorID: 0x046d, ProductID: 0xc31c, Revision: 0x6400, Speed: peripherals.SpeedLow,
+			VendorName: "Logitech, Inc.", ProductName: "Keyboard K120",
+			Classification: "(Defined at Interface level)",
+			Interfaces: []peripherals.InterfaceSetting{
+				{Number: 0, Class: peripherals.ClassHID, SubClass: 1, Protocol: 1, ClassName: "Human Interface Device"},
+				{Number: 1, Class: peripherals.ClassHID, ClassName: "Human Interface Device"},
+			},
+		},
+		serialPrefix: "K120",
+		attributes: func(index int) map[string]interface{} {
+			return map[string]interface{}{
+				"hid": []interface{}{map[string]interface{}{
+					"device-path": fmt.Sprintf("/dev/hidraw%d", index),
+					"types":       []interface{}{"keyboard"},
+				}},
+			}
+		},
+	},
+}
+
+// Templates lists the names of the device templates
+func Templates() []string {
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
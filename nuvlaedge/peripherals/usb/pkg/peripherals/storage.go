@@ -0,0 +1,193 @@
+package peripherals
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Reasons a disk is protected from the remote storage actions
+const (
+	ProtectedRoot    = "root filesystem"
+	ProtectedMounted = "mounted"
+	ProtectedSwap    = "swap"
+)
+
+// sectorSize is the unit of the sysfs size attribute of block devices
+const sectorSize = 512
+
+// hostStorage is what the manager knows of the filesystems in use, gathered
+// once per probe of a storage device
+type hostStorage struct {
+	// mountpoints by major:minor device number
+	mounts map[string][]string
+	// rootDevices are the major:minor of the filesystem mounted at / and the
+	// kernel name of the root= device of the kernel command line
+	rootDevices map[string]bool
+	swaps       map[string]bool
+}
+
+// readHostStorage reads the mounts of the manager, the root device the kernel
+// booted from and the swap devices. The kernel command line and the swaps are
+// the ones of the host even in a container, so the root disk is recognised
+// while the container has its own root filesystem.
+func (d *Discoverer) readHostStorage() hostStorage {
+	host := hostStorage{mounts: map[string][]string{}, rootDevices: map[string]bool{}, swaps: map[string]bool{}}
+
+	mountInfo := filepath.Join(d.procDir, "self", "mountinfo")
+	if err := readLines(mountInfo, func(line string) {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return
+		}
+		host.mounts[fields[2]] = append(host.mounts[fields[2]], fields[4])
+		if fields[4] == "/" {
+			host.rootDevices[fields[2]] = true
+		}
+	}); err != nil {
+		logSysfsError(mountInfo, err)
+	}
+
+	cmdline, err := readSysfsString(filepath.Join(d.procDir, "cmdline"))
+	if err == nil {
+		for _, arg := range strings.Fields(cmdline) {
+			if strings.HasPrefix(arg, "root=") {
+				if name := d.resolveDeviceName(strings.TrimPrefix(arg, "root=")); len(name) > 0 {
+					host.rootDevices[name] = true
+				}
+			}
+		}
+	}
+
+	// Filename Type Size Used Priority
+	swaps := filepath.Join(d.procDir, "swaps")
+	if err := readLines(swaps, func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "/dev/") {
+			host.swaps[filepath.Base(fields[0])] = true
+		}
+	}); err != nil && !os.IsNotExist(err) {
+		logSysfsError(swaps, err)
+	}
+
+	return host
+}
+
+// resolveDeviceName returns the kernel name of a root= device, given as a
+// device node or as a PARTUUID, UUID or LABEL resolved through the udev links
+// of /dev/disk
+func (d *Discoverer) resolveDeviceName(device string) string {
+	links := map[string]string{"PARTUUID=": "by-partuuid", "UUID=": "by-uuid", "LABEL=": "by-label"}
+	for prefix, folder := range links {
+		if strings.HasPrefix(device, prefix) {
+			target, err := filepath.EvalSymlinks(filepath.Join(d.devDir, "disk", folder, strings.TrimPrefix(device, prefix)))
+			if err != nil {
+				return ""
+			}
+			return filepath.Base(target)
+		}
+	}
+	if strings.HasPrefix(device, "/dev/") {
+		return filepath.Base(device)
+	}
+	return ""
+}
+
+func readLines(path string, handle func(line string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		handle(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// probeStorage reports the disks of a USB storage device, with their
+// partitions and mount points. Disks holding the root filesystem, a mounted
+// filesystem or swap are marked protected, so the remote actions refuse to
+// touch them.
+func (d *Discoverer) probeStorage(device Device, peripheral *Peripheral) {
+	nodes := d.classNodes("block", device)
+	if len(nodes) == 0 {
+		return
+	}
+	host := d.readHostStorage()
+
+	var disks []BlockDevice
+	for _, node := range nodes {
+		// Partitions are listed along their disk, and live in its folder
+		if _, err := os.Stat(filepath.Join(node.Path, "partition")); err == nil {
+			continue
+		}
+
+		disk := BlockDevice{DevicePath: d.devDir + node.Name}
+		if sectors, err := readSysfsInt(filepath.Join(node.Path, "size")); err == nil {
+			disk.Size = int64(sectors) * sectorSize
+		}
+		removable, _ := readSysfsInt(filepath.Join(node.Path, "removable"))
+		readOnly, _ := readSysfsInt(filepath.Join(node.Path, "ro"))
+		disk.Removable = removable == 1
+		disk.ReadOnly = readOnly == 1
+
+		members := []classNode{node}
+		for _, partition := range nodes {
+			if filepath.Dir(partition.Path) == node.Path {
+				disk.Partitions = append(disk.Partitions, d.devDir+partition.Name)
+				members = append(members, partition)
+			}
+		}
+
+		var reasons []string
+		addReason := func(reason string) {
+			for _, r := range reasons {
+				if r == reason {
+					return
+				}
+			}
+			reasons = append(reasons, reason)
+		}
+		for _, member := range members {
+			number, _ := readSysfsString(filepath.Join(member.Path, "dev"))
+			if host.rootDevices[member.Name] || (len(number) > 0 && host.rootDevices[number]) {
+				disk.HoldsRoot = true
+				addReason(ProtectedRoot)
+			}
+			if mountpoints := host.mounts[number]; len(number) > 0 && len(mountpoints) > 0 {
+				disk.Mountpoints = append(disk.Mountpoints, mountpoints...)
+				addReason(ProtectedMounted)
+			}
+			if host.swaps[member.Name] {
+				addReason(ProtectedSwap)
+			}
+		}
+		sort.Strings(disk.Mountpoints)
+
+		disk.Protected = len(reasons) > 0
+		disk.ProtectedReason = strings.Join(reasons, ", ")
+		disks = append(disks, disk)
+	}
+
+	if len(disks) > 0 {
+		peripheral.Storage = disks
+	}
+}
+
+// ProtectedStorage reports whether any disk of the peripheral is protected,
+// and why. Actions writing to or handing out a storage device must refuse
+// protected ones, which hold the running system or filesystems in use.
+func (p Peripheral) ProtectedStorage() (string, bool) {
+	for _, disk := range p.Storage {
+		if disk.Protected {
+			return disk.DevicePath + " is protected (" + disk.ProtectedReason + ")", true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,91 @@
+package peripherals
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProbeStorage(t *testing.T) {
+	sysfs := t.TempDir()
+	stick := fakeUSBDevice(t, sysfs, "1-2", "1", "6")
+	iface := filepath.Join(stick, "1-2:1.0", "host0", "target0:0:0", "0:0:0:0")
+
+	disk := fakeClassNode(t, sysfs, iface, "block", "sda")
+	writeFile(t, filepath.Join(disk, "dev"), "8:0")
+	writeFile(t, filepath.Join(disk, "size"), "61440000")
+	writeFile(t, filepath.Join(disk, "removable"), "1")
+	writeFile(t, filepath.Join(disk, "ro"), "0")
+	for _, partition := range []struct{ name, dev string }{{"sda1", "8:1"}, {"sda2", "8:2"}} {
+		dir := filepath.Join(disk, partition.name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, "dev"), partition.dev)
+		writeFile(t, filepath.Join(dir, "partition"), partition.name[3:])
+		if err := os.Symlink(dir, filepath.Join(sysfs, "class", "block", partition.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	devDir := t.TempDir() + "/"
+	procDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(procDir, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(procDir, "self", "mountinfo"),
+		"22 1 0:21 / / rw,relatime - overlay overlay rw\n"+
+			"35 22 8:1 /boot /media/boot rw,relatime - vfat /dev/sda1 rw\n")
+	writeFile(t, filepath.Join(procDir, "cmdline"), "console=tty1 root=/dev/mmcblk0p2 rootwait\n")
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs), WithDevDir(devDir), WithProcDir(procDir))
+	device := Device{Bus: 1, Address: 6}
+	peripheral := Peripheral{}
+	d.probeStorage(device, &peripheral)
+
+	expected := []BlockDevice{{
+		DevicePath:      devDir + "sda",
+		Size:            61440000 * 512,
+		Removable:       true,
+		Partitions:      []string{devDir + "sda1", devDir + "sda2"},
+		Mountpoints:     []string{"/media/boot"},
+		Protected:       true,
+		ProtectedReason: ProtectedMounted,
+	}}
+	if !reflect.DeepEqual(peripheral.Storage, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, peripheral.Storage)
+	}
+	if _, protected := peripheral.ProtectedStorage(); !protected {
+		t.Error("expected mounted disk to be protected")
+	}
+
+	// The root device of the kernel command line, given by PARTUUID
+	writeFile(t, filepath.Join(procDir, "self", "mountinfo"), "22 1 0:21 / / rw,relatime - overlay overlay rw\n")
+	writeFile(t, filepath.Join(procDir, "cmdline"), "root=PARTUUID=6c586e13-02 rootwait\n")
+	writeFile(t, filepath.Join(procDir, "swaps"), "Filename Type Size Used Priority\n")
+	if err := os.MkdirAll(devDir+"disk/by-partuuid", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, devDir+"sda2", "")
+	if err := os.Symlink(devDir+"sda2", devDir+"disk/by-partuuid/6c586e13-02"); err != nil {
+		t.Fatal(err)
+	}
+
+	peripheral = Peripheral{}
+	d.probeStorage(device, &peripheral)
+	if len(peripheral.Storage) != 1 {
+		t.Fatalf("expected one disk, got %+v", peripheral.Storage)
+	}
+	if root := peripheral.Storage[0]; !root.HoldsRoot || !root.Protected || root.ProtectedReason != ProtectedRoot {
+		t.Errorf("expected the disk to hold the root filesystem, got %+v", root)
+	}
+
+	// Unused disks are left unprotected
+	writeFile(t, filepath.Join(procDir, "cmdline"), "root=/dev/mmcblk0p2\n")
+	peripheral = Peripheral{}
+	d.probeStorage(device, &peripheral)
+	if reason, protected := peripheral.ProtectedStorage(); protected {
+		t.Errorf("expected unused disk not to be protected, got %s", reason)
+	}
+}
@@ -0,0 +1,101 @@
+package peripherals
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// readSysfsString returns the trimmed content of a sysfs attribute
+func readSysfsString(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readSysfsInt(path string) (int, error) {
+	value, err := readSysfsString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// usbParent walks up a resolved sysfs device path until it finds the USB
+// device the node belongs to, and returns that device bus and address
+func usbParent(path string) (bus int, address int, dir string, ok bool) {
+	for dir = path; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		busnum, err := readSysfsInt(filepath.Join(dir, "busnum"))
+		if err != nil {
+			continue
+		}
+		devnum, err := readSysfsInt(filepath.Join(dir, "devnum"))
+		if err != nil {
+			continue
+		}
+		return busnum, devnum, dir, true
+	}
+	return 0, 0, "", false
+}
+
+// classNode is a node of a sysfs class, such as hidraw0 or ttyACM0
+type classNode struct {
+	Name string
+	// Path is the resolved sysfs folder of the node
+	Path string
+}
+
+// classNodes lists the nodes of a sysfs class that belong to the given
+// device, sorted by name
+func (d *Discoverer) classNodes(class string, device Device) []classNode {
+	var nodes []classNode
+
+	classDir := filepath.Join(d.sysfsDir, "class", class)
+	entries, err := ioutil.ReadDir(classDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logSysfsError(classDir, err)
+		}
+		return nodes
+	}
+
+	for _, entry := range entries {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(classDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		bus, address, _, ok := usbParent(resolved)
+		if ok && bus == device.Bus && address == device.Address {
+			nodes = append(nodes, classNode{Name: entry.Name(), Path: resolved})
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+func logSysfsError(path string, err error) {
+	log.Debugf("Unable to read %s. Reason: %s", path, err)
+}
+
+// probeSerialDevices lists the tty nodes created for the device, by cdc_acm or
+// a USB to serial driver
+func (d *Discoverer) probeSerialDevices(device Device, peripheral *Peripheral) {
+	nodes := d.classNodes("tty", device)
+	if len(nodes) == 0 {
+		return
+	}
+
+	serialDevices := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		serialDevices = append(serialDevices, d.devDir+node.Name)
+	}
+	peripheral.SerialDevices = serialDevices
+}
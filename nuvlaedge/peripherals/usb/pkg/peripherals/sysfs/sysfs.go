@@ -0,0 +1,255 @@
+// Package sysfs implements the peripherals.Backend on top of the USB devices
+// the kernel lists in sysfs, without libusb nor cgo. It is the fallback of the
+// hosts where libusb cannot be used.
+//
+// The descriptors are the ones the kernel caches: only the interfaces of the
+// active configuration, in their current alternate setting, are listed. The
+// names are the manufacturer and product strings of the devices, as there is
+// no USB ID database to resolve them. The bandwidth of the isochronous
+// devices is thus the one of the settings they currently use.
+package sysfs
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/dfu"
+)
+
+// DefaultDir is where the kernel lists the USB devices and interfaces
+const DefaultDir = "/sys/bus/usb/devices"
+
+// classNames are the interface class names of the USB ID database, so the
+// records read the same as with libusb
+var classNames = map[uint8]string{
+	0x00: "(Defined at Interface level)",
+	0x01: "Audio",
+	0x02: "Communications",
+	0x03: "Human Interface Device",
+	0x05: "Physical Interface Device",
+	0x06: "Imaging",
+	0x07: "Printer",
+	0x08: "Mass Storage",
+	0x09: "Hub",
+	0x0a: "CDC Data",
+	0x0b: "Chip/SmartCard",
+	0x0d: "Content Security",
+	0x0e: "Video",
+	0x58: "Xbox",
+	0xdc: "Diagnostic",
+	0xe0: "Wireless",
+	0xef: "Miscellaneous Device",
+	0xfe: "Application Specific Interface",
+	0xff: "Vendor Specific Class",
+}
+
+// speeds maps the speed attribute of the devices, in Mbps
+var speeds = map[string]peripherals.Speed{
+	"1.5":   peripherals.SpeedLow,
+	"12":    peripherals.SpeedFull,
+	"480":   peripherals.SpeedHigh,
+	"5000":  peripherals.SpeedSuper,
+	"10000": peripherals.SpeedSuperPlus,
+	"20000": peripherals.SpeedSuperPlus,
+}
+
+// transferTypes maps the type attribute of the endpoints
+var transferTypes = map[string]string{
+	"Control":   peripherals.TransferControl,
+	"Isoc":      peripherals.TransferIsochronous,
+	"Bulk":      peripherals.TransferBulk,
+	"Interrupt": peripherals.TransferInterrupt,
+}
+
+func className(class uint8) string {
+	if name, ok := classNames[class]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Backend lists devices from sysfs
+type Backend struct {
+	Dir string
+
+	onVisit peripherals.VisitFunc
+}
+
+// New lists the devices of dir, DefaultDir when empty. It fails when the host
+// has no USB device folder, as in containers without /sys.
+func New(dir string) (*Backend, error) {
+	if len(dir) == 0 {
+		dir = DefaultDir
+	}
+	if _, err := ioutil.ReadDir(dir); err != nil {
+		return nil, fmt.Errorf("%w: unable to list the USB devices in sysfs: %v", peripherals.ErrNoContext, err)
+	}
+	return &Backend{Dir: dir}, nil
+}
+
+// OnVisit registers a function called for each device before it is decoded
+func (b *Backend) OnVisit(f peripherals.VisitFunc) {
+	b.onVisit = f
+}
+
+// OpenDFU fails, as sysfs gives no access to the control transfers flashing
+// requires. It implements dfu.Opener.
+func (b *Backend) OpenDFU(bus int, address int) (dfu.Device, error) {
+	return nil, fmt.Errorf("unable to open the device at bus %d address %d: firmware updates need libusb", bus, address)
+}
+
+// Close has nothing to release
+func (b *Backend) Close() error {
+	return nil
+}
+
+func readString(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readNumber reads an attribute in the given base, the descriptor fields are
+// hexadecimal and the bus and device numbers decimal
+func readNumber(path string, base int, bits int) (uint64, error) {
+	return strconv.ParseUint(readString(path), base, bits)
+}
+
+// Devices lists the attached devices. The folders of the devices are named
+// after their port, such as 1-1.2, or usbN for the root hubs, those of their
+// interfaces after the port, configuration and interface, such as 1-1.2:1.0.
+func (b *Backend) Devices(ctx context.Context) ([]peripherals.Device, error) {
+	entries, err := ioutil.ReadDir(b.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []peripherals.Device
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return devices, err
+		}
+		if strings.Contains(entry.Name(), ":") {
+			continue
+		}
+		device, err := b.readDevice(filepath.Join(b.Dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		devices = append(devices, device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Bus != devices[j].Bus {
+			return devices[i].Bus < devices[j].Bus
+		}
+		return devices[i].Address < devices[j].Address
+	})
+	return devices, nil
+}
+
+func (b *Backend) readDevice(dir string) (peripherals.Device, error) {
+	var device peripherals.Device
+
+	bus, err := readNumber(filepath.Join(dir, "busnum"), 10, 16)
+	if err != nil {
+		return device, err
+	}
+	address, err := readNumber(filepath.Join(dir, "devnum"), 10, 16)
+	if err != nil {
+		return device, err
+	}
+	vendor, err := readNumber(filepath.Join(dir, "idVendor"), 16, 16)
+	if err != nil {
+		return device, err
+	}
+	product, err := readNumber(filepath.Join(dir, "idProduct"), 16, 16)
+	if err != nil {
+		return device, err
+	}
+	device = peripherals.Device{Bus: int(bus), Address: int(address), VendorID: uint16(vendor), ProductID: uint16(product)}
+	if revision, err := readNumber(filepath.Join(dir, "bcdDevice"), 16, 16); err == nil {
+		device.Revision = uint16(revision)
+	}
+	device.Speed = speeds[readString(filepath.Join(dir, "speed"))]
+
+	if b.onVisit != nil {
+		b.onVisit(device.Identifier(), device.DevicePath())
+	}
+
+	device.VendorName = readString(filepath.Join(dir, "manufacturer"))
+	device.ProductName = readString(filepath.Join(dir, "product"))
+	class, _ := readNumber(filepath.Join(dir, "bDeviceClass"), 16, 8)
+	device.Classification = className(uint8(class))
+	device.Interfaces = b.interfaceSettings(dir)
+	return device, nil
+}
+
+// endpoints reads the endpoints of an interface, the ep_XX subfolders named
+// after their address
+func endpoints(dir string) []peripherals.Endpoint {
+	matches, _ := filepath.Glob(filepath.Join(dir, "ep_*"))
+	sort.Strings(matches)
+
+	var list []peripherals.Endpoint
+	for _, ep := range matches {
+		address, err := readNumber(filepath.Join(ep, "bEndpointAddress"), 16, 8)
+		if err != nil {
+			continue
+		}
+		endpoint := peripherals.Endpoint{
+			Number:   int(address & 0x0f),
+			In:       address&0x80 != 0,
+			Transfer: transferTypes[readString(filepath.Join(ep, "type"))],
+		}
+		// The size is in the lower 11 bits, the additional transactions per
+		// microframe of the high speed endpoints in the next 2
+		if size, err := readNumber(filepath.Join(ep, "wMaxPacketSize"), 16, 16); err == nil {
+			endpoint.MaxPacketSize = int(size&0x7ff) * int(1+(size>>11)&0x3)
+		}
+		if interval, err := time.ParseDuration(readString(filepath.Join(ep, "interval"))); err == nil {
+			endpoint.Interval = interval
+		}
+		list = append(list, endpoint)
+	}
+	return list
+}
+
+// interfaceSettings reads the interfaces of the active configuration, which
+// are subfolders of the device named after it
+func (b *Backend) interfaceSettings(dir string) []peripherals.InterfaceSetting {
+	matches, _ := filepath.Glob(filepath.Join(dir, filepath.Base(dir)+":*"))
+	sort.Strings(matches)
+
+	var settings []peripherals.InterfaceSetting
+	for _, intf := range matches {
+		number, err := readNumber(filepath.Join(intf, "bInterfaceNumber"), 16, 8)
+		if err != nil {
+			continue
+		}
+		alternate, _ := readNumber(filepath.Join(intf, "bAlternateSetting"), 10, 8)
+		class, _ := readNumber(filepath.Join(intf, "bInterfaceClass"), 16, 8)
+		subClass, _ := readNumber(filepath.Join(intf, "bInterfaceSubClass"), 16, 8)
+		protocol, _ := readNumber(filepath.Join(intf, "bInterfaceProtocol"), 16, 8)
+		settings = append(settings, peripherals.InterfaceSetting{
+			Number:    int(number),
+			Alternate: int(alternate),
+			Class:     uint8(class),
+			SubClass:  uint8(subClass),
+			Protocol:  uint8(protocol),
+			ClassName: className(uint8(class)),
+			Endpoints: endpoints(intf),
+		})
+	}
+	sort.SliceStable(settings, func(i, j int) bool { return settings[i].Number < settings[j].Number })
+	return settings
+}
@@ -0,0 +1,105 @@
+package sysfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func writeAttributes(t *testing.T, dir string, attributes map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range attributes {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDevices(t *testing.T) {
+	root := t.TempDir()
+	writeAttributes(t, filepath.Join(root, "usb1"), map[string]string{
+		"busnum": "1", "devnum": "1", "idVendor": "1d6b", "idProduct": "0002",
+		"bcdDevice": "0515", "bDeviceClass": "09", "product": "EHCI Host Controller",
+	})
+	writeAttributes(t, filepath.Join(root, "usb1", "1-0:1.0"), map[string]string{
+		"bInterfaceNumber": "00", "bAlternateSetting": " 0", "bInterfaceClass": "09",
+		"bInterfaceSubClass": "00", "bInterfaceProtocol": "00",
+	})
+	camera := filepath.Join(root, "1-1.2")
+	writeAttributes(t, camera, map[string]string{
+		"busnum": "1", "devnum": "12", "idVendor": "046d", "idProduct": "0825",
+		"bcdDevice": "0010", "bDeviceClass": "ef", "manufacturer": "Logitech", "product": "Webcam C270",
+		"speed": "480",
+	})
+	writeAttributes(t, filepath.Join(camera, "1-1.2:1.2"), map[string]string{
+		"bInterfaceNumber": "02", "bAlternateSetting": " 0", "bInterfaceClass": "01",
+		"bInterfaceSubClass": "01", "bInterfaceProtocol": "00",
+	})
+	// The isochronous endpoint of the current setting, 2 transactions of 960
+	// bytes per microframe
+	writeAttributes(t, filepath.Join(camera, "1-1.2:1.2", "ep_84"), map[string]string{
+		"bEndpointAddress": "84", "type": "Isoc", "direction": "in", "wMaxPacketSize": "0bc0", "interval": "125us",
+	})
+	writeAttributes(t, filepath.Join(camera, "1-1.2:1.0"), map[string]string{
+		"bInterfaceNumber": "00", "bAlternateSetting": " 0", "bInterfaceClass": "0e",
+		"bInterfaceSubClass": "01", "bInterfaceProtocol": "00",
+	})
+	// The interfaces are also listed at the top level, and skipped there
+	writeAttributes(t, filepath.Join(root, "1-1.2:1.0"), map[string]string{"bInterfaceNumber": "00"})
+	// Devices without descriptors, such as a device being removed, are skipped
+	writeAttributes(t, filepath.Join(root, "1-1.3"), map[string]string{"busnum": "1"})
+
+	backend, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var visited []string
+	backend.OnVisit(func(identifier string, devicePath string) {
+		visited = append(visited, identifier+" "+devicePath)
+	})
+
+	devices, err := backend.Devices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected the root hub and the camera, got %+v", devices)
+	}
+	expected := peripherals.Device{
+		Bus: 1, Address: 12, VendorID: 0x046d, ProductID: 0x0825, Revision: 0x0010,
+		VendorName: "Logitech", ProductName: "Webcam C270", Classification: "Miscellaneous Device",
+		Speed: peripherals.SpeedHigh,
+		Interfaces: []peripherals.InterfaceSetting{
+			{Number: 0, Class: 0x0e, SubClass: 1, ClassName: "Video"},
+			{Number: 2, Class: 0x01, SubClass: 1, ClassName: "Audio", Endpoints: []peripherals.Endpoint{
+				{Number: 4, In: true, Transfer: peripherals.TransferIsochronous, MaxPacketSize: 1920, Interval: 125 * time.Microsecond},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(devices[1], expected) {
+		t.Errorf("expected %+v, got %+v", expected, devices[1])
+	}
+	if devices[0].Identifier() != "1d6b:0002" || devices[0].Classification != "Hub" {
+		t.Errorf("expected the root hub first, got %+v", devices[0])
+	}
+	sort.Strings(visited)
+	if !reflect.DeepEqual(visited, []string{"046d:0825 /dev/bus/usb/001/012", "1d6b:0002 /dev/bus/usb/001/001"}) {
+		t.Errorf("expected every device to be visited, got %v", visited)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, peripherals.ErrNoContext) {
+		t.Errorf("expected no USB context without a sysfs USB folder, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package peripherals
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// topologyGeneration sums up the USB topology as sysfs lists it: the devices
+// and their interfaces, and the number of each device on its bus, which the
+// kernel increments whenever a device is attached, even in the same port.
+// Linux keeps no generation counter of the USB topology, this listing is
+// its cheapest stand-in. It is empty when sysfs cannot be read.
+func (d *Discoverer) topologyGeneration() string {
+	dir := filepath.Join(d.sysfsDir, "bus", "usb", "devices")
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) == 0 {
+		return ""
+	}
+
+	// ReadDir sorts the entries by name
+	h := fnv.New64a()
+	for _, entry := range entries {
+		h.Write([]byte(entry.Name()))
+		if !strings.Contains(entry.Name(), ":") {
+			devnum, _ := readSysfsString(filepath.Join(dir, entry.Name(), "devnum"))
+			h.Write([]byte("=" + devnum))
+		}
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%d:%016x", len(entries), h.Sum64())
+}
+
+// listDevices lists the devices with the backend, or answers the last listing
+// when the topology cache is enabled and the USB topology did not change since
+func (d *Discoverer) listDevices(ctx context.Context) ([]Device, error) {
+	if !d.topologyCache {
+		return d.backend.Devices(ctx)
+	}
+
+	generation := d.topologyGeneration()
+	if len(generation) > 0 && generation == d.topology {
+		d.stats.Cached = true
+		return append([]Device(nil), d.listing...), nil
+	}
+
+	devices, err := d.backend.Devices(ctx)
+	d.topology, d.listing = "", nil
+	// Partial listings are never reused
+	if err == nil && len(generation) > 0 {
+		d.topology, d.listing = generation, devices
+	}
+	return devices, err
+}
@@ -0,0 +1,79 @@
+package peripherals
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type countingBackend struct {
+	fakeBackend
+	listings int
+}
+
+func (b *countingBackend) Devices(ctx context.Context) ([]Device, error) {
+	b.listings++
+	return b.fakeBackend.Devices(ctx)
+}
+
+// fakeTopologyEntry lists a device or interface in /sys/bus/usb/devices
+func fakeTopologyEntry(t *testing.T, sysfs string, name string, devnum string) {
+	dir := filepath.Join(sysfs, "bus", "usb", "devices", name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if len(devnum) > 0 {
+		writeFile(t, filepath.Join(dir, "devnum"), devnum)
+	}
+}
+
+func TestTopologyCache(t *testing.T) {
+	sysfs := t.TempDir()
+	fakeTopologyEntry(t, sysfs, "usb1", "1")
+	fakeTopologyEntry(t, sysfs, "1-1", "4")
+	fakeTopologyEntry(t, sysfs, "1-1:1.0", "")
+
+	backend := &countingBackend{fakeBackend: fakeBackend{devices: []Device{webcam()}}}
+	d := NewDiscoverer(backend, WithProber(&fakeProber{}), WithDevDir(t.TempDir()), WithSysfsDir(sysfs),
+		WithTopologyCache())
+
+	discover := func() []Peripheral {
+		discovered, err := d.Discover(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return discovered
+	}
+
+	discover()
+	if discovered := discover(); backend.listings != 1 || !d.Stats().Cached || len(discovered) != 1 {
+		t.Errorf("expected the unchanged topology to reuse the listing, got %d listings and %+v", backend.listings, d.Stats())
+	}
+
+	// The same device attached again in the same port gets a new number
+	writeFile(t, filepath.Join(sysfs, "bus", "usb", "devices", "1-1", "devnum"), "5")
+	discover()
+	if backend.listings != 2 || d.Stats().Cached {
+		t.Errorf("expected the reattached device to be listed again, got %d listings", backend.listings)
+	}
+
+	fakeTopologyEntry(t, sysfs, "1-2", "6")
+	backend.err = errors.New("libusb: timeout")
+	d.Discover(context.Background())
+	backend.err = nil
+	discover()
+	if backend.listings != 4 {
+		t.Errorf("expected the failed listing not to be reused, got %d listings", backend.listings)
+	}
+
+	// Without sysfs every scan enumerates the devices
+	d = NewDiscoverer(backend, WithProber(&fakeProber{}), WithDevDir(t.TempDir()), WithSysfsDir(t.TempDir()),
+		WithTopologyCache())
+	discover()
+	discover()
+	if backend.listings != 6 {
+		t.Errorf("expected every scan to list the devices without sysfs, got %d listings", backend.listings)
+	}
+}
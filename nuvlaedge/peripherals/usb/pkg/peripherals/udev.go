@@ -0,0 +1,142 @@
+package peripherals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Prober looks up the udev information of device nodes
+type Prober interface {
+	SerialNumber(ctx context.Context, devicePath string) string
+}
+
+// UdevadmProber resolves device information by walking the udev attributes of
+// a device with udevadm
+type UdevadmProber struct{}
+
+// SerialNumber returns the serial number udev holds for the device node, or an
+// empty string if it is unknown
+func (p UdevadmProber) SerialNumber(ctx context.Context, devicePath string) string {
+	serialNumber, err := p.Lookup(ctx, devicePath)
+	if errors.Is(err, ErrUdevTimeout) {
+		log.Debugf("Skipped the udev lookup of device %s. Reason: %s", devicePath, err)
+	} else if err != nil {
+		log.Errorf("Unable to run udevadm for device %s. Reason: %s", devicePath, err)
+	}
+	return serialNumber
+}
+
+// Lookup returns the serial number udev holds for the device node, empty if
+// it has none, or why udevadm could not tell. Lookups cut short by the
+// deadline of ctx fail with ErrUdevTimeout.
+func (UdevadmProber) Lookup(ctx context.Context, devicePath string) (string, error) {
+	cmd := exec.CommandContext(ctx, "udevadm", "info", "--attribute-walk", devicePath)
+
+	stdout, err := cmd.Output()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("%w: udevadm info %s", ErrUdevTimeout, devicePath)
+	}
+	if err != nil {
+		return "", fmt.Errorf("udevadm info %s: %w", devicePath, err)
+	}
+	return parseSerialNumber(string(stdout)), nil
+}
+
+// CachingProber memoizes the lookups of another prober by device node. udev
+// recreates the nodes of a device whenever it is attached, so a node is only
+// probed again once it is replaced, and scans of an unchanged USB topology run
+// no udevadm at all. Nodes that cannot be stat'ed are never cached.
+type CachingProber struct {
+	Prober Prober
+
+	mu      sync.Mutex
+	entries map[string]probeEntry
+	used    map[string]bool
+}
+
+type probeEntry struct {
+	node   os.FileInfo
+	serial string
+}
+
+// NewCachingProber wraps the given prober
+func NewCachingProber(prober Prober) *CachingProber {
+	return &CachingProber{Prober: prober, entries: map[string]probeEntry{}, used: map[string]bool{}}
+}
+
+// SerialNumber returns the cached serial number of the device node if it was
+// not replaced since the last lookup, and probes it otherwise
+func (c *CachingProber) SerialNumber(ctx context.Context, devicePath string) string {
+	node, statErr := os.Stat(devicePath)
+	if statErr != nil {
+		return c.Prober.SerialNumber(ctx, devicePath)
+	}
+
+	c.mu.Lock()
+	entry, cached := c.entries[devicePath]
+	c.used[devicePath] = true
+	c.mu.Unlock()
+	if cached && os.SameFile(entry.node, node) && entry.node.ModTime().Equal(node.ModTime()) {
+		return entry.serial
+	}
+
+	serial := c.Prober.SerialNumber(ctx, devicePath)
+	// Lookups interrupted by the end of the scan are not conclusive
+	if ctx.Err() != nil {
+		return serial
+	}
+
+	c.mu.Lock()
+	c.entries[devicePath] = probeEntry{node: node, serial: serial}
+	c.mu.Unlock()
+	return serial
+}
+
+// Prune forgets the nodes not looked up since the previous call, so the cache
+// does not grow with every device ever attached
+func (c *CachingProber) Prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for path := range c.entries {
+		if !c.used[path] {
+			delete(c.entries, path)
+		}
+	}
+	c.used = map[string]bool{}
+}
+
+// parseSerialNumber extracts the serial attribute closest to the device from an
+// udevadm attribute walk. Serials of the USB host controllers, reported by
+// parent devices, are only used when the device itself has none.
+func parseSerialNumber(attributeWalk string) string {
+	var serialNumber string = ""
+	var backupSerialNumber string = ""
+
+	for _, line := range strings.Split(attributeWalk, "\n") {
+		if strings.Contains(line, "serial") {
+			parts := strings.Split(line, "\"")
+			if len(parts) < 2 {
+				continue
+			}
+			if strings.Contains(line, ".usb") {
+				backupSerialNumber = parts[1]
+				continue
+			}
+			serialNumber = parts[1]
+			break
+		}
+	}
+
+	if len(serialNumber) == 0 && len(backupSerialNumber) > 0 {
+		serialNumber = backupSerialNumber
+	}
+
+	return serialNumber
+}
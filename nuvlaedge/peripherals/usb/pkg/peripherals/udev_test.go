@@ -0,0 +1,96 @@
+package peripherals
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const attributeWalk = `
+  looking at device '/devices/platform/scb/fd500000.pcie/pci0000:00/0000:00:00.0/0000:01:00.0/usb1/1-1/1-1.3':
+    KERNEL=="1-1.3"
+    SUBSYSTEM=="usb"
+    ATTR{product}=="USB Flash Disk"
+    ATTR{serial}=="4C530001231122116172"
+
+  looking at parent device '/devices/platform/scb/fd500000.pcie/pci0000:00/0000:00:00.0/0000:01:00.0/usb1':
+    ATTRS{serial}=="0000:01:00.0"
+`
+
+const hostControllerWalk = `
+  looking at device '/devices/platform/soc/3f980000.usb/usb1':
+    ATTR{serial}=="3f980000.usb"
+`
+
+func TestParseSerialNumber(t *testing.T) {
+	if serial := parseSerialNumber(attributeWalk); serial != "4C530001231122116172" {
+		t.Errorf("expected device serial number, got %q", serial)
+	}
+	if serial := parseSerialNumber(hostControllerWalk); serial != "3f980000.usb" {
+		t.Errorf("expected host controller serial number as fallback, got %q", serial)
+	}
+	if serial := parseSerialNumber("ATTR{serial}==malformed"); serial != "" {
+		t.Errorf("expected no serial number for malformed output, got %q", serial)
+	}
+}
+
+func TestLookupTimeout(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := (UdevadmProber{}).Lookup(ctx, "/dev/bus/usb/001/004"); !errors.Is(err, ErrUdevTimeout) {
+		t.Errorf("expected the lookup to time out, got %v", err)
+	}
+}
+
+func TestCachingProber(t *testing.T) {
+	dir := t.TempDir()
+	node := filepath.Join(dir, "video0")
+	if err := ioutil.WriteFile(node, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "video1")
+	inner := &fakeProber{serials: map[string]string{node: "200901010001", missing: "gone"}}
+	prober := NewCachingProber(inner)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if serial := prober.SerialNumber(ctx, node); serial != "200901010001" {
+			t.Fatalf("expected the node serial number, got %q", serial)
+		}
+	}
+	if inner.calls != 1 {
+		t.Errorf("expected an unchanged node to be probed once, got %d lookups", inner.calls)
+	}
+
+	// A replugged device gets a new node
+	if err := os.Remove(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(node, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(node, later, later); err != nil {
+		t.Fatal(err)
+	}
+	inner.serials[node] = "200901010002"
+	if serial := prober.SerialNumber(ctx, node); serial != "200901010002" {
+		t.Errorf("expected a recreated node to be probed again, got %q", serial)
+	}
+
+	prober.SerialNumber(ctx, missing)
+	prober.SerialNumber(ctx, missing)
+	if inner.calls != 4 {
+		t.Errorf("expected nodes that cannot be stat'ed to never be cached, got %d lookups", inner.calls)
+	}
+
+	prober.Prune()
+	prober.Prune()
+	if len(prober.entries) != 0 {
+		t.Errorf("expected unused nodes to be pruned, got %v", prober.entries)
+	}
+}
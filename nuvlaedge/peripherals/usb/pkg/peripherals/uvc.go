@@ -0,0 +1,190 @@
+package peripherals
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// UVC descriptor codes, from the USB Video Class 1.5 specification
+const (
+	descriptorTypeInterface   = 0x04
+	descriptorTypeCSInterface = 0x24
+
+	subClassVideoControl = 0x01
+
+	vcInputTerminal  = 0x02
+	vcProcessingUnit = 0x05
+
+	terminalTypeCamera = 0x0201
+)
+
+// cameraControls names the bits of the camera terminal bmControls
+var cameraControls = []string{
+	"scanning-mode",
+	"auto-exposure-mode",
+	"auto-exposure-priority",
+	"exposure-time-absolute",
+	"exposure-time-relative",
+	"focus-absolute",
+	"focus-relative",
+	"iris-absolute",
+	"iris-relative",
+	"zoom-absolute",
+	"zoom-relative",
+	"pan-tilt-absolute",
+	"pan-tilt-relative",
+	"roll-absolute",
+	"roll-relative",
+	"",
+	"",
+	"focus-auto",
+	"privacy",
+	"focus-simple",
+	"window",
+	"region-of-interest",
+}
+
+// processingControls names the bits of the processing unit bmControls
+var processingControls = []string{
+	"brightness",
+	"contrast",
+	"hue",
+	"saturation",
+	"sharpness",
+	"gamma",
+	"white-balance-temperature",
+	"white-balance-component",
+	"backlight-compensation",
+	"gain",
+	"power-line-frequency",
+	"hue-auto",
+	"white-balance-temperature-auto",
+	"white-balance-component-auto",
+	"digital-multiplier",
+	"digital-multiplier-limit",
+	"analog-video-standard",
+	"analog-lock-status",
+	"contrast-auto",
+}
+
+// uvcControls are the controls a camera advertises in its VideoControl
+// interface descriptors
+type uvcControls struct {
+	Camera     []string
+	Processing []string
+}
+
+// parseUVCControls reads the camera terminal and processing unit controls from
+// the raw descriptors of a device, as exposed by sysfs
+func parseUVCControls(descriptors []byte) uvcControls {
+	var controls uvcControls
+	inVideoControl := false
+
+	for len(descriptors) >= 2 {
+		length := int(descriptors[0])
+		if length < 2 || length > len(descriptors) {
+			break
+		}
+		descriptor := descriptors[:length]
+		descriptors = descriptors[length:]
+
+		switch descriptor[1] {
+		case descriptorTypeInterface:
+			inVideoControl = length >= 9 && descriptor[5] == ClassVideo && descriptor[6] == subClassVideoControl
+		case descriptorTypeCSInterface:
+			if !inVideoControl || length < 3 {
+				continue
+			}
+			switch descriptor[2] {
+			case vcInputTerminal:
+				if length < 15 || binary.LittleEndian.Uint16(descriptor[4:6]) != terminalTypeCamera {
+					continue
+				}
+				controls.Camera = append(controls.Camera, controlNames(descriptor[15:], int(descriptor[14]), cameraControls)...)
+			case vcProcessingUnit:
+				if length < 8 {
+					continue
+				}
+				controls.Processing = append(controls.Processing, controlNames(descriptor[8:], int(descriptor[7]), processingControls)...)
+			}
+		}
+	}
+	return controls
+}
+
+// controlNames decodes a bmControls bitmap of size bytes
+func controlNames(bitmap []byte, size int, names []string) []string {
+	if size > len(bitmap) {
+		size = len(bitmap)
+	}
+	var set []string
+	for i := 0; i < size*8 && i < len(names); i++ {
+		if bitmap[i/8]&(1<<uint(i%8)) != 0 && len(names[i]) > 0 {
+			set = append(set, names[i])
+		}
+	}
+	return set
+}
+
+// probeUVC reports the controls of a UVC camera, and the ranges of the main
+// ones as the uvcvideo driver exposes them on the video node:
+//
+//	"uvc": {
+//	  "camera-controls": ["auto-exposure-mode", "focus-absolute", "focus-auto"],
+//	  "processing-controls": ["brightness", "contrast"],
+//	  "autofocus": true,
+//	  "ptz": false,
+//	  "ranges": {"focus-absolute": {"min": 0, "max": 250, "step": 5, "default": 0}}
+//	}
+func (d *Discoverer) probeUVC(ctx context.Context, device Device, peripheral *Peripheral) {
+	dir, ok := d.usbDeviceDir(device)
+	if !ok {
+		return
+	}
+	path := filepath.Join(dir, "descriptors")
+	descriptors, err := ioutil.ReadFile(path)
+	if err != nil {
+		logSysfsError(path, err)
+		return
+	}
+
+	controls := parseUVCControls(descriptors)
+	if len(controls.Camera) == 0 && len(controls.Processing) == 0 {
+		return
+	}
+
+	uvc := &UVC{
+		CameraControls:     stringList(controls.Camera),
+		ProcessingControls: stringList(controls.Processing),
+		Autofocus:          contains(controls.Camera, "focus-auto"),
+		PTZ: contains(controls.Camera, "pan-tilt-absolute") || contains(controls.Camera, "pan-tilt-relative") ||
+			contains(controls.Camera, "zoom-absolute") || contains(controls.Camera, "zoom-relative"),
+	}
+
+	// Querying the ranges goes through the camera, which can be slow to answer
+	if nodes := d.classNodes("video4linux", device); len(nodes) > 0 && ctx.Err() == nil {
+		if ranges := queryControlRanges(d.devDir + nodes[0].Name); len(ranges) > 0 {
+			uvc.Ranges = ranges
+		}
+	}
+	peripheral.UVC = uvc
+}
+
+// stringList returns the values, or an empty list so it encodes as []
+func stringList(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
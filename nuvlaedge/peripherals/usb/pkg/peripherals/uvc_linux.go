@@ -0,0 +1,58 @@
+package peripherals
+
+import (
+	"encoding/binary"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// VIDIOC_QUERYCTRL, _IOWR('V', 36, struct v4l2_queryctrl)
+const vidiocQueryCtrl = 0xc0445624
+
+const (
+	v4l2QueryCtrlSize    = 68
+	v4l2CtrlFlagDisabled = 0x0001
+)
+
+// v4l2Controls are the V4L2 control ids of the ranges worth reporting
+var v4l2Controls = map[string]uint32{
+	"brightness":             0x00980900,
+	"exposure-time-absolute": 0x009a0902,
+	"pan-absolute":           0x009a0908,
+	"tilt-absolute":          0x009a0909,
+	"focus-absolute":         0x009a090a,
+	"zoom-absolute":          0x009a090d,
+}
+
+// queryControlRanges reads the range of the main controls of a video node.
+// Querying controls does not need the stream, so it works while another
+// application captures from the camera.
+func queryControlRanges(path string) map[string]ControlRange {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		logSysfsError(path, err)
+		return nil
+	}
+	defer f.Close()
+
+	ranges := map[string]ControlRange{}
+	for name, id := range v4l2Controls {
+		buf := make([]byte, v4l2QueryCtrlSize)
+		binary.LittleEndian.PutUint32(buf[0:4], id)
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), vidiocQueryCtrl, uintptr(unsafe.Pointer(&buf[0])))
+		if errno != 0 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(buf[56:60])&v4l2CtrlFlagDisabled != 0 {
+			continue
+		}
+		ranges[name] = ControlRange{
+			Min:     int32(binary.LittleEndian.Uint32(buf[40:44])),
+			Max:     int32(binary.LittleEndian.Uint32(buf[44:48])),
+			Step:    int32(binary.LittleEndian.Uint32(buf[48:52])),
+			Default: int32(binary.LittleEndian.Uint32(buf[52:56])),
+		}
+	}
+	return ranges
+}
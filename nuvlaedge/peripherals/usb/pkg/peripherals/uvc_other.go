@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package peripherals
+
+// queryControlRanges is only supported on Linux, where the uvcvideo driver
+// exposes the camera controls
+func queryControlRanges(path string) map[string]ControlRange {
+	return nil
+}
@@ -0,0 +1,76 @@
+package peripherals
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// c270Descriptors is an excerpt of the configuration of a Logitech C270: the
+// VideoControl interface with its camera terminal and processing unit
+var c270Descriptors = []byte{
+	// device descriptor
+	18, 0x01, 0x00, 0x02, 0xef, 0x02, 0x01, 0x40, 0x6d, 0x04, 0x25, 0x08, 0x12, 0x00, 0x00, 0x02, 0x01, 0x01,
+	// VideoControl interface
+	9, 0x04, 0x00, 0x00, 0x01, 0x0e, 0x01, 0x00, 0x00,
+	// camera terminal: auto-exposure-mode, auto-exposure-priority, exposure-time-absolute
+	18, 0x24, 0x02, 0x01, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x0e, 0x00, 0x00,
+	// processing unit: brightness, contrast, saturation, gain
+	11, 0x24, 0x05, 0x02, 0x01, 0x00, 0x00, 0x02, 0x0b, 0x02, 0x00,
+	// VideoStreaming interface, whose descriptors must be ignored
+	9, 0x04, 0x01, 0x00, 0x01, 0x0e, 0x02, 0x00, 0x00,
+	11, 0x24, 0x05, 0x02, 0x01, 0x00, 0x00, 0x02, 0xff, 0xff, 0x00,
+}
+
+func TestParseUVCControls(t *testing.T) {
+	controls := parseUVCControls(c270Descriptors)
+
+	expected := uvcControls{
+		Camera:     []string{"auto-exposure-mode", "auto-exposure-priority", "exposure-time-absolute"},
+		Processing: []string{"brightness", "contrast", "saturation", "gain"},
+	}
+	if !reflect.DeepEqual(controls, expected) {
+		t.Errorf("expected controls %+v, got %+v", expected, controls)
+	}
+
+	if controls := parseUVCControls(c270Descriptors[:20]); controls.Camera != nil || controls.Processing != nil {
+		t.Errorf("expected no controls from truncated descriptors, got %+v", controls)
+	}
+}
+
+func TestProbeUVC(t *testing.T) {
+	sysfs := t.TempDir()
+	usbDir := fakeUSBDevice(t, sysfs, "1-1", "1", "4")
+	writeFile(t, filepath.Join(usbDir, "descriptors"), string(c270Descriptors))
+
+	devicesDir := filepath.Join(sysfs, "bus", "usb", "devices")
+	if err := os.MkdirAll(devicesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(usbDir, filepath.Join(devicesDir, "1-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDiscoverer(&fakeBackend{}, WithSysfsDir(sysfs))
+	peripheral := Peripheral{}
+	d.probeUVC(context.Background(), webcam(), &peripheral)
+
+	uvc := peripheral.UVC
+	if uvc == nil {
+		t.Fatalf("expected UVC controls, got %v", peripheral)
+	}
+	if uvc.Autofocus || uvc.PTZ {
+		t.Errorf("expected a fixed focus camera without PTZ, got %v", uvc)
+	}
+	if len(uvc.ProcessingControls) != 4 {
+		t.Errorf("unexpected processing controls %v", uvc.ProcessingControls)
+	}
+
+	other := Peripheral{}
+	d.probeUVC(context.Background(), Device{Bus: 1, Address: 9}, &other)
+	if other.UVC != nil {
+		t.Error("expected no UVC controls for an unknown device")
+	}
+}
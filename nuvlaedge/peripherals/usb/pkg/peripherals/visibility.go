@@ -0,0 +1,136 @@
+package peripherals
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Visibility of a device from inside the container
+const (
+	// VisibilityHostOnly devices are attached to the host but the container
+	// cannot open them
+	VisibilityHostOnly = "host-only"
+)
+
+const usbfsHint = "The device is attached to the host but its node is missing from the container. " +
+	"Bind mount /dev/bus/usb, or pass the device to the peripheral manager container"
+
+// sysfsUSBDevice is a USB device as listed by the host sysfs
+type sysfsUSBDevice struct {
+	Dir       string
+	Bus       int
+	Address   int
+	VendorID  uint16
+	ProductID uint16
+	Product   string
+}
+
+// sysfsUSBDevices lists the USB devices known to sysfs. With the host /sys
+// mounted, this is the view of the host rather than the one of the container.
+func (d *Discoverer) sysfsUSBDevices() []sysfsUSBDevice {
+	devicesDir := filepath.Join(d.sysfsDir, "bus", "usb", "devices")
+	entries, err := ioutil.ReadDir(devicesDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logSysfsError(devicesDir, err)
+		}
+		return nil
+	}
+
+	var devices []sysfsUSBDevice
+	for _, entry := range entries {
+		// interfaces are listed next to the devices, as 1-1:1.0
+		if strings.Contains(entry.Name(), ":") {
+			continue
+		}
+		dir, err := filepath.EvalSymlinks(filepath.Join(devicesDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		bus, busErr := readSysfsInt(filepath.Join(dir, "busnum"))
+		address, addressErr := readSysfsInt(filepath.Join(dir, "devnum"))
+		if busErr != nil || addressErr != nil {
+			continue
+		}
+
+		device := sysfsUSBDevice{Dir: dir, Bus: bus, Address: address}
+		device.VendorID = readSysfsHex(filepath.Join(dir, "idVendor"))
+		device.ProductID = readSysfsHex(filepath.Join(dir, "idProduct"))
+		device.Product, _ = readSysfsString(filepath.Join(dir, "product"))
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+func readSysfsHex(path string) uint16 {
+	value, err := readSysfsString(path)
+	if err != nil {
+		return 0
+	}
+	parsed, _ := strconv.ParseUint(value, 16, 16)
+	return uint16(parsed)
+}
+
+// usbDeviceDir returns the sysfs folder of a USB device
+func (d *Discoverer) usbDeviceDir(device Device) (string, bool) {
+	for _, candidate := range d.sysfsUSBDevices() {
+		if candidate.Bus == device.Bus && candidate.Address == device.Address {
+			return candidate.Dir, true
+		}
+	}
+	return "", false
+}
+
+// usbfsNode returns the path of the usbfs node of a device in the container
+func (d *Discoverer) usbfsNode(bus int, address int) string {
+	return filepath.Join(d.devDir, "bus", "usb", fmt.Sprintf("%03d", bus), fmt.Sprintf("%03d", address))
+}
+
+// checkVisibility compares the devices the host sysfs lists with the usbfs
+// nodes available in the container. Discovered peripherals without a node are
+// flagged, and the devices the backend could not list at all are added as
+// unavailable peripherals, so none of them silently disappears.
+func (d *Discoverer) checkVisibility(peripherals []Peripheral) []Peripheral {
+	hostDevices := d.sysfsUSBDevices()
+	if len(hostDevices) == 0 {
+		return peripherals
+	}
+
+	discovered := map[string]int{}
+	for i, peripheral := range peripherals {
+		discovered[peripheral.DevicePath] = i
+	}
+
+	hidden := 0
+	for _, host := range hostDevices {
+		if _, err := os.Stat(d.usbfsNode(host.Bus, host.Address)); err == nil {
+			continue
+		}
+		hidden++
+
+		device := Device{Bus: host.Bus, Address: host.Address, VendorID: host.VendorID, ProductID: host.ProductID}
+		i, exists := discovered[device.DevicePath()]
+		if !exists {
+			device.ProductName = host.Product
+			device.Classification = "unknown"
+			peripheral := newPeripheral(device)
+			peripheral.Available = false
+			i = len(peripherals)
+			peripherals = append(peripherals, peripheral)
+		}
+		peripherals[i].Visibility = VisibilityHostOnly
+		peripherals[i].Hint = usbfsHint
+	}
+
+	if hidden > 0 {
+		log.Warnf("%d USB devices attached to the host are not accessible from the container. "+
+			"Is /dev/bus/usb mounted?", hidden)
+	}
+	return peripherals
+}
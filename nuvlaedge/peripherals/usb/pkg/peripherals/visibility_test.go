@@ -0,0 +1,74 @@
+package peripherals
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeHostDevice lists a device in the host sysfs view
+func fakeHostDevice(t *testing.T, sysfs string, name string, bus string, address string, vendor string, product string) {
+	dir := fakeUSBDevice(t, sysfs, name, bus, address)
+	writeFile(t, filepath.Join(dir, "idVendor"), vendor)
+	writeFile(t, filepath.Join(dir, "idProduct"), product)
+
+	devicesDir := filepath.Join(sysfs, "bus", "usb", "devices")
+	if err := os.MkdirAll(devicesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, filepath.Join(devicesDir, name)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckVisibility(t *testing.T) {
+	sysfs := t.TempDir()
+	devDir := t.TempDir()
+
+	// the camera is listed and has its node, the adapter is listed without
+	// node, and the modem is not listed by the backend at all
+	fakeHostDevice(t, sysfs, "1-1", "1", "4", "046d", "0825")
+	fakeHostDevice(t, sysfs, "1-2", "1", "5", "0bda", "8153")
+	fakeHostDevice(t, sysfs, "1-3", "1", "6", "12d1", "1506")
+	writeFile(t, filepath.Join(sysfs, "devices", "pci0000:00", "usb1", "1-3", "product"), "Modem")
+
+	node := filepath.Join(devDir, "bus", "usb", "001")
+	if err := os.MkdirAll(node, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(node, "004"), "")
+
+	adapter := Device{Bus: 1, Address: 5, VendorID: 0x0bda, ProductID: 0x8153}
+	backend := &fakeBackend{devices: []Device{webcam(), adapter}}
+	d := NewDiscoverer(backend, WithProber(&fakeProber{}), WithSysfsDir(sysfs), WithDevDir(devDir))
+
+	discovered, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discovered) != 3 {
+		t.Fatalf("expected the hidden modem to be reported, got %v", discovered)
+	}
+
+	if len(discovered[0].Visibility) > 0 {
+		t.Errorf("expected accessible camera not to be flagged, got %v", discovered[0])
+	}
+	if discovered[1].Visibility != VisibilityHostOnly || !discovered[1].Available {
+		t.Errorf("expected adapter to be flagged host-only, got %v", discovered[1])
+	}
+
+	for i, port := range []string{"1-1", "1-2", "1-3"} {
+		if discovered[i].Port != port {
+			t.Errorf("expected %s on port %s, got %q", discovered[i].Identifier, port, discovered[i].Port)
+		}
+	}
+
+	modem := discovered[2]
+	if modem.Identifier != "12d1:1506" || modem.Name != "Modem" || modem.Available {
+		t.Errorf("unexpected hidden device record %v", modem)
+	}
+	if modem.Visibility != VisibilityHostOnly || len(modem.Hint) == 0 {
+		t.Errorf("expected hidden device to carry a hint, got %v", modem)
+	}
+}
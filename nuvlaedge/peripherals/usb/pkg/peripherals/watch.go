@@ -0,0 +1,100 @@
+package peripherals
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EventType tells how a peripheral changed between two discoveries
+type EventType string
+
+const (
+	EventAdded   EventType = "added"
+	EventUpdated EventType = "updated"
+	EventRemoved EventType = "removed"
+)
+
+// Event reports a change of one peripheral. Removal events carry the last
+// known state of the peripheral.
+type Event struct {
+	Type       EventType
+	Peripheral Peripheral
+}
+
+// Watch runs a discovery every watch interval and streams the changes found
+// between consecutive scans. Peripherals present on the first scan are
+// reported as added. The channel is closed once ctx is done.
+func (d *Discoverer) Watch(ctx context.Context) <-chan Event {
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+
+		known := map[string]Peripheral{}
+		ticker := time.NewTicker(d.watchInterval)
+		defer ticker.Stop()
+
+		for {
+			peripherals, err := d.Discover(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				// Partial results would show the missing devices as removed
+				log.Errorf("A problem occurred while listing the USB peripherals %s. Skipping changes...", err)
+			} else {
+				current := byIdentifier(peripherals)
+				for _, event := range diff(known, current) {
+					select {
+					case events <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+				known = current
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
+
+func byIdentifier(peripherals []Peripheral) map[string]Peripheral {
+	indexed := make(map[string]Peripheral, len(peripherals))
+	for _, p := range peripherals {
+		indexed[p.Identifier] = p
+	}
+	return indexed
+}
+
+// diff lists the events turning the previous set of peripherals into the
+// current one
+func diff(previous map[string]Peripheral, current map[string]Peripheral) []Event {
+	var events []Event
+
+	for identifier, peripheral := range current {
+		old, exists := previous[identifier]
+		if !exists {
+			events = append(events, Event{Type: EventAdded, Peripheral: peripheral})
+		} else if !reflect.DeepEqual(old, peripheral) {
+			events = append(events, Event{Type: EventUpdated, Peripheral: peripheral})
+		}
+	}
+
+	for identifier, peripheral := range previous {
+		if _, exists := current[identifier]; !exists {
+			events = append(events, Event{Type: EventRemoved, Peripheral: peripheral})
+		}
+	}
+
+	return events
+}
@@ -0,0 +1,57 @@
+package peripherals
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	previous := map[string]Peripheral{
+		"0001:0001": {Identifier: "0001:0001", Name: "Kept"},
+		"0002:0002": {Identifier: "0002:0002", Name: "Renamed"},
+		"0003:0003": {Identifier: "0003:0003", Name: "Unplugged"},
+	}
+	current := map[string]Peripheral{
+		"0001:0001": {Identifier: "0001:0001", Name: "Kept"},
+		"0002:0002": {Identifier: "0002:0002", Name: "New name"},
+		"0004:0004": {Identifier: "0004:0004", Name: "Plugged"},
+	}
+
+	types := map[string]EventType{}
+	for _, event := range diff(previous, current) {
+		types[event.Peripheral.Identifier] = event.Type
+	}
+
+	expected := map[string]EventType{
+		"0002:0002": EventUpdated,
+		"0003:0003": EventRemoved,
+		"0004:0004": EventAdded,
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), types)
+	}
+	for identifier, eventType := range expected {
+		if types[identifier] != eventType {
+			t.Errorf("%s: expected %s event, got %q", identifier, eventType, types[identifier])
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	backend := &fakeBackend{devices: []Device{webcam()}}
+	d := NewDiscoverer(backend, WithProber(&fakeProber{}), WithDevDir(t.TempDir()),
+		WithWatchInterval(time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := d.Watch(ctx)
+
+	event := <-events
+	if event.Type != EventAdded || event.Peripheral.Identifier != "046d:0825" {
+		t.Errorf("expected the attached device to be reported as added, got %+v", event)
+	}
+
+	cancel()
+	for range events {
+	}
+}
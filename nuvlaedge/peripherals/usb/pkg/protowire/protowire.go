@@ -0,0 +1,118 @@
+// Package protowire encodes and decodes the protocol buffers wire format, for
+// the messages of the schemas in the proto folder of the module.
+//
+// Only the wire types the schemas use are supported: varints and length
+// delimited fields. The fields of other wire types are skipped on decoding,
+// with the unknown fields, so newer senders can add fields without breaking
+// older readers.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Wire types of the fields
+const (
+	VarintType  = 0
+	Fixed64Type = 1
+	BytesType   = 2
+	Fixed32Type = 5
+)
+
+// ErrTruncated is returned for messages ending in the middle of a field
+var ErrTruncated = errors.New("truncated protobuf message")
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// AppendVarint appends a varint field, omitted when zero as in proto3
+func AppendVarint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = appendUvarint(b, uint64(field)<<3|VarintType)
+	return appendUvarint(b, v)
+}
+
+// AppendBool appends a bool field, omitted when false
+func AppendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return AppendVarint(b, field, 1)
+}
+
+// AppendBytes appends a length delimited field, even when empty so it can
+// hold the embedded messages and repeated strings
+func AppendBytes(b []byte, field int, v []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|BytesType)
+	b = appendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendString appends a string field, omitted when empty
+func AppendString(b []byte, field int, v string) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return AppendBytes(b, field, []byte(v))
+}
+
+// Field is a field of a message. Value is set for the varints, Bytes for the
+// length delimited fields.
+type Field struct {
+	Number int
+	Type   int
+	Value  uint64
+	Bytes  []byte
+}
+
+// Fields decodes the fields of a message, in their order on the wire
+func Fields(b []byte) ([]Field, error) {
+	var fields []Field
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, ErrTruncated
+		}
+		b = b[n:]
+		if tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid protobuf field number %d", tag>>3)
+		}
+		field := Field{Number: int(tag >> 3), Type: int(tag & 7)}
+
+		switch field.Type {
+		case VarintType:
+			if field.Value, n = binary.Uvarint(b); n <= 0 {
+				return nil, ErrTruncated
+			}
+			b = b[n:]
+		case BytesType:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, ErrTruncated
+			}
+			field.Bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case Fixed64Type, Fixed32Type:
+			size := 8
+			if field.Type == Fixed32Type {
+				size = 4
+			}
+			if len(b) < size {
+				return nil, ErrTruncated
+			}
+			b = b[size:]
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d of field %d", field.Type, field.Number)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
@@ -0,0 +1,56 @@
+package protowire
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFields(t *testing.T) {
+	var b []byte
+	b = AppendString(b, 1, "usb")
+	b = AppendVarint(b, 3, 300)
+	b = AppendBool(b, 6, true)
+	b = AppendBytes(b, 4, nil)
+	// Zero values are left out, as in proto3
+	b = AppendString(b, 2, "")
+	b = AppendVarint(b, 5, 0)
+	b = AppendBool(b, 7, false)
+
+	fields, err := Fields(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Field{
+		{Number: 1, Type: BytesType, Bytes: []byte("usb")},
+		{Number: 3, Type: VarintType, Value: 300},
+		{Number: 6, Type: VarintType, Value: 1},
+		{Number: 4, Type: BytesType, Bytes: []byte{}},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("expected %+v, got %+v", expected, fields)
+	}
+}
+
+func TestFieldsSkipsFixed(t *testing.T) {
+	// Field 2 as fixed64 and field 3 as fixed32, followed by field 1 = 1
+	b := []byte{2<<3 | Fixed64Type, 1, 2, 3, 4, 5, 6, 7, 8, 3<<3 | Fixed32Type, 1, 2, 3, 4, 1 << 3, 1}
+	fields, err := Fields(b)
+	if err != nil || len(fields) != 1 || fields[0].Number != 1 || fields[0].Value != 1 {
+		t.Errorf("expected the fixed fields to be skipped, got %+v (%v)", fields, err)
+	}
+}
+
+func TestFieldsInvalid(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"truncated tag":    {0x80},
+		"truncated varint": {1 << 3, 0x80},
+		"truncated bytes":  {1<<3 | BytesType, 3, 'u'},
+		"truncated fixed":  {1<<3 | Fixed32Type, 1},
+		"field zero":       {0, 1},
+		"group":            {1<<3 | 3},
+	} {
+		if _, err := Fields(b); err == nil {
+			t.Errorf("expected %s to fail", name)
+		}
+	}
+}
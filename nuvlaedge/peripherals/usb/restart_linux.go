@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// restartManager replaces the process with a new run of the manager, with the
+// same arguments and environment, which loads the configuration again. The
+// container keeps running.
+func restartManager() {
+	executable, err := os.Executable()
+	if err == nil {
+		err = syscall.Exec(executable, os.Args, os.Environ())
+	}
+	log.Fatalf("Unable to restart the USB peripheral manager. Reason: %s", err)
+}
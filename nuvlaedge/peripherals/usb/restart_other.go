@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// restartManager exits for the manager to be started again by its supervisor,
+// the process is only replaced in place on Linux
+func restartManager() {
+	log.Warn("Exiting for the USB peripheral manager to be started again with the new configuration")
+	os.Exit(1)
+}
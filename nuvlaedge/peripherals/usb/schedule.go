@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+// scanScheduler adapts the time between two scans to the activity: right
+// after peripherals come, go or change availability, the scans run every
+// active interval, then the interval doubles at each quiet scan up to the
+// configured scan interval.
+type scanScheduler struct {
+	active       time.Duration
+	activePeriod time.Duration
+	idle         time.Duration
+
+	interval    time.Duration
+	lastChange  time.Time
+	fingerprint string
+}
+
+func newScanScheduler(cfg Config) *scanScheduler {
+	return &scanScheduler{active: cfg.ScanIntervalActive, activePeriod: cfg.ScanActivePeriod, idle: cfg.ScanInterval}
+}
+
+// fingerprint sums up what counts as activity: the attached peripherals and
+// their availability. Attributes such as the signal of a modem change at
+// every scan and are left out.
+func fingerprint(message map[string]peripherals.Peripheral) string {
+	entries := make([]string, 0, len(message))
+	for identifier, peripheral := range message {
+		if peripheral.Available {
+			identifier += "+"
+		}
+		entries = append(entries, identifier)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// next returns how long to wait before the next scan, given the report of the
+// last one. Without an active interval, the scans run at a fixed interval.
+func (s *scanScheduler) next(now time.Time, message map[string]peripherals.Peripheral) time.Duration {
+	if s.active <= 0 || s.active >= s.idle {
+		return s.idle
+	}
+
+	// The first scan counts as a change, the devices often settle after boot
+	if current := fingerprint(message); current != s.fingerprint || s.lastChange.IsZero() {
+		if !s.lastChange.IsZero() && s.interval > s.active {
+			log.Infof("USB peripherals changed, scanning every %s", s.active)
+		}
+		s.fingerprint = current
+		s.lastChange = now
+		s.interval = s.active
+		return s.interval
+	}
+
+	if now.Sub(s.lastChange) < s.activePeriod {
+		return s.interval
+	}
+	if s.interval *= 2; s.interval > s.idle {
+		s.interval = s.idle
+	}
+	return s.interval
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+)
+
+func TestScanSchedulerNext(t *testing.T) {
+	camera := map[string]peripherals.Peripheral{"046d:0825": {Identifier: "046d:0825", Available: true}}
+	plugged := map[string]peripherals.Peripheral{
+		"046d:0825": {Identifier: "046d:0825", Available: true},
+		"0781:5581": {Identifier: "0781:5581", Available: true},
+	}
+	claimed := map[string]peripherals.Peripheral{
+		"046d:0825": {Identifier: "046d:0825", Available: false},
+		"0781:5581": {Identifier: "0781:5581", Available: true},
+	}
+
+	s := newScanScheduler(Config{ScanInterval: 30 * time.Second, ScanIntervalActive: 2 * time.Second, ScanActivePeriod: 10 * time.Second})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	steps := []struct {
+		name     string
+		elapsed  time.Duration
+		message  map[string]peripherals.Peripheral
+		expected time.Duration
+	}{
+		{"first scan", 0, camera, 2 * time.Second},
+		{"active period", 2 * time.Second, camera, 2 * time.Second},
+		{"end of the active period", 8 * time.Second, camera, 2 * time.Second},
+		{"first quiet scan", 10 * time.Second, camera, 4 * time.Second},
+		{"doubling", 14 * time.Second, camera, 8 * time.Second},
+		{"doubling again", 22 * time.Second, camera, 16 * time.Second},
+		{"capped", 38 * time.Second, camera, 30 * time.Second},
+		{"staying capped", 68 * time.Second, camera, 30 * time.Second},
+		{"peripheral plugged", 98 * time.Second, plugged, 2 * time.Second},
+		{"availability change", 100 * time.Second, claimed, 2 * time.Second},
+		{"new active period", 108 * time.Second, claimed, 2 * time.Second},
+		{"quiet again", 110 * time.Second, claimed, 4 * time.Second},
+	}
+	for _, step := range steps {
+		if interval := s.next(start.Add(step.elapsed), step.message); interval != step.expected {
+			t.Errorf("%s: expected %s, got %s", step.name, step.expected, interval)
+		}
+	}
+}
+
+func TestScanSchedulerFixedInterval(t *testing.T) {
+	camera := map[string]peripherals.Peripheral{"046d:0825": {Identifier: "046d:0825", Available: true}}
+	tests := []struct {
+		name   string
+		active time.Duration
+	}{
+		{"no active interval", 0},
+		{"active interval above the scan interval", time.Minute},
+		{"active interval equal to the scan interval", 30 * time.Second},
+	}
+	for _, test := range tests {
+		s := newScanScheduler(Config{ScanInterval: 30 * time.Second, ScanIntervalActive: test.active, ScanActivePeriod: time.Minute})
+		now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		for i := 0; i < 3; i++ {
+			if interval := s.next(now.Add(time.Duration(i)*time.Second), camera); interval != 30*time.Second {
+				t.Errorf("%s: expected the scan interval, got %s", test.name, interval)
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SelfTestReport is the name of the report of the startup self-test, in the
+// diagnostics folder
+const SelfTestReport = "self-test.json"
+
+// DevicesDir holds the device nodes libusb opens
+const DevicesDir = "/dev/bus/usb"
+
+// Exit codes of the startup self-test, one per check the manager cannot run
+// without, so the orchestrator tells the install problems apart
+const (
+	exitChannelDir = 3
+	exitLibusb     = 4
+	exitDevices    = 5
+)
+
+// Results of a check
+const (
+	checkPassed  = "passed"
+	checkWarning = "warning"
+	checkFailed  = "failed"
+	checkSkipped = "skipped"
+)
+
+// earliestSaneTime is before any report of this build. A clock behind it was
+// never set, as on the boards without RTC booting without network.
+var earliestSaneTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// selfTestCheck is the result of a check, with what to do about it
+type selfTestCheck struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+	Remedy string `json:"remedy,omitempty"`
+
+	exitCode int
+}
+
+type selfTestReport struct {
+	Time    string          `json:"time"`
+	Version string          `json:"version"`
+	Backend string          `json:"backend"`
+	Passed  bool            `json:"passed"`
+	Checks  []selfTestCheck `json:"checks"`
+}
+
+// exitCode is the code of the first failed check, zero when none failed
+func (r selfTestReport) exitCode() int {
+	for _, check := range r.Checks {
+		if check.Result == checkFailed {
+			return check.exitCode
+		}
+	}
+	return 0
+}
+
+// runSelfTest checks that the host gives the manager what it needs to report
+// the peripherals. The checks of the USB stack are skipped when simulating or
+// replaying, the devices do not come from it.
+func runSelfTest(cfg Config) selfTestReport {
+	synthetic := len(cfg.Simulate) > 0 || len(cfg.Replay) > 0
+	return newSelfTestReport(cfg,
+		checkChannelDir(ChannelPath),
+		checkLibusb(cfg, synthetic),
+		checkDevices(cfg, synthetic),
+		checkUdevadm(synthetic),
+		checkClock(time.Now()),
+	)
+}
+
+// newSelfTestReport sums up the results of the checks, in the order they ran
+func newSelfTestReport(cfg Config, checks ...selfTestCheck) selfTestReport {
+	report := selfTestReport{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Version: buildVersion(),
+		Backend: cfg.Backend,
+		Checks:  checks,
+	}
+	report.Passed = report.exitCode() == 0
+	return report
+}
+
+// checkChannelDir makes sure the reports can be written to the buffer
+func checkChannelDir(dir string) selfTestCheck {
+	check := selfTestCheck{Name: "channel-dir", Result: checkPassed, exitCode: exitChannelDir,
+		Remedy: "mount the NuvlaEdge data volume read-write in the peripheral container"}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		check.Result, check.Detail = checkFailed, err.Error()
+		return check
+	}
+	probe, err := ioutil.TempFile(dir, ".self-test-")
+	if err != nil {
+		check.Result, check.Detail = checkFailed, err.Error()
+		return check
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	check.Remedy = ""
+	return check
+}
+
+// checkLibusb initialises libusb when the configuration may use it. Only an
+// explicit libusb backend fails the test, auto falls back to sysfs.
+func checkLibusb(cfg Config, synthetic bool) selfTestCheck {
+	check := selfTestCheck{Name: "libusb", Result: checkPassed, exitCode: exitLibusb}
+	if synthetic || cfg.Backend == backendSysfs {
+		check.Result = checkSkipped
+		return check
+	}
+	failure := checkFailed
+	if cfg.Backend != backendLibusb {
+		failure = checkWarning
+	}
+	if !libusbAvailable {
+		check.Result, check.Detail = failure, "this build has no libusb support"
+		check.Remedy = "use a build with libusb, or set USB_BACKEND=sysfs"
+		return check
+	}
+	backend, err := openLibusb()
+	if err != nil {
+		check.Result, check.Detail = failure, err.Error()
+		check.Remedy = "install libusb-1.0 in the image, or set USB_BACKEND=sysfs"
+		return check
+	}
+	backend.Close()
+	return check
+}
+
+// checkDevices makes sure the device nodes are visible from the container.
+// Without them libusb lists nothing, and the probes of the other backends
+// cannot open the devices.
+func checkDevices(cfg Config, synthetic bool) selfTestCheck {
+	check := selfTestCheck{Name: "devices", Result: checkPassed, exitCode: exitDevices}
+	if synthetic {
+		check.Result = checkSkipped
+		return check
+	}
+	failure := checkWarning
+	if cfg.Backend == backendLibusb {
+		failure = checkFailed
+	}
+	buses, err := ioutil.ReadDir(DevicesDir)
+	if err == nil && len(buses) == 0 {
+		check.Detail = DevicesDir + " is empty"
+	} else if err != nil {
+		check.Detail = err.Error()
+	}
+	if len(check.Detail) > 0 {
+		check.Result = failure
+		check.Remedy = "bind mount /dev, or at least " + DevicesDir + ", in the peripheral container"
+		return check
+	}
+	if _, err := os.Stat(filepath.Join(cfg.SysfsDir, "bus", "usb", "devices")); err != nil {
+		check.Result, check.Detail = checkWarning, err.Error()
+		check.Remedy = "mount sysfs in the peripheral container, the ports, disks and interfaces are read from it"
+	}
+	return check
+}
+
+// checkUdevadm looks for the udevadm the serial numbers are read with
+func checkUdevadm(synthetic bool) selfTestCheck {
+	check := selfTestCheck{Name: "udevadm", Result: checkPassed}
+	if synthetic {
+		check.Result = checkSkipped
+		return check
+	}
+	if _, err := exec.LookPath("udevadm"); err != nil {
+		check.Result, check.Detail = checkWarning, err.Error()
+		check.Remedy = "install udev in the image, the peripherals are reported without serial number"
+	}
+	return check
+}
+
+// checkClock warns about the clocks the report times cannot be trusted with
+func checkClock(now time.Time) selfTestCheck {
+	check := selfTestCheck{Name: "clock", Result: checkPassed}
+	if now.Before(earliestSaneTime) {
+		check.Result, check.Detail = checkWarning, "the system clock is set to "+now.UTC().Format(time.RFC3339)
+		check.Remedy = "set the clock of the host, or give it network access to synchronize it"
+		return check
+	}
+	if synchronized, _, known := clockSynchronized(); known && !synchronized {
+		check.Result, check.Detail = checkWarning, "the system clock is not synchronized"
+		check.Remedy = "enable NTP on the host"
+	}
+	return check
+}
+
+// logSelfTest logs the report and writes it to the diagnostics folder
+func logSelfTest(report selfTestReport, cfg Config) {
+	for _, check := range report.Checks {
+		switch check.Result {
+		case checkFailed:
+			log.Errorf("Self-test %s failed: %s. Remedy: %s", check.Name, check.Detail, check.Remedy)
+		case checkWarning:
+			log.Warnf("Self-test %s: %s. Remedy: %s", check.Name, check.Detail, check.Remedy)
+		default:
+			log.Debugf("Self-test %s %s", check.Name, check.Result)
+		}
+	}
+
+	if err := os.MkdirAll(cfg.DiagnosticsPath, os.ModePerm); err != nil {
+		log.Errorf("Unable to create diagnostics folder %s. Reason: %s", cfg.DiagnosticsPath, err)
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Errorf("Unable to encode the self-test report. Reason: %s", err)
+		return
+	}
+	file := filepath.Join(cfg.DiagnosticsPath, SelfTestReport)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		log.Errorf("Unable to write the self-test report %s. Reason: %s", file, err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelfTestExitCode(t *testing.T) {
+	channel := selfTestCheck{Name: "channel-dir", Result: checkPassed, exitCode: exitChannelDir}
+	libusb := selfTestCheck{Name: "libusb", Result: checkPassed, exitCode: exitLibusb}
+	devices := selfTestCheck{Name: "devices", Result: checkPassed, exitCode: exitDevices}
+	udevadm := selfTestCheck{Name: "udevadm", Result: checkPassed}
+	with := func(check selfTestCheck, result string) selfTestCheck {
+		check.Result = result
+		return check
+	}
+
+	tests := []struct {
+		name     string
+		checks   []selfTestCheck
+		expected int
+	}{
+		{"all passed", []selfTestCheck{channel, libusb, devices, udevadm}, 0},
+		{"warnings and skipped checks", []selfTestCheck{channel, with(libusb, checkWarning), with(devices, checkSkipped),
+			with(udevadm, checkWarning)}, 0},
+		{"read-only channel", []selfTestCheck{with(channel, checkFailed), libusb, devices, udevadm}, exitChannelDir},
+		{"no libusb", []selfTestCheck{channel, with(libusb, checkFailed), devices, udevadm}, exitLibusb},
+		{"no device nodes", []selfTestCheck{channel, libusb, with(devices, checkFailed), udevadm}, exitDevices},
+		{"first failure wins", []selfTestCheck{channel, with(libusb, checkFailed), with(devices, checkFailed), udevadm}, exitLibusb},
+	}
+	for _, test := range tests {
+		report := newSelfTestReport(Config{Backend: backendLibusb}, test.checks...)
+		if code := report.exitCode(); code != test.expected {
+			t.Errorf("%s: expected exit code %d, got %d", test.name, test.expected, code)
+		}
+		if report.Passed != (test.expected == 0) {
+			t.Errorf("%s: expected passed to be %v", test.name, test.expected == 0)
+		}
+	}
+}
+
+func TestSelfTestChecks(t *testing.T) {
+	// A file where the channel folder should be
+	file := filepath.Join(t.TempDir(), "channel")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if check := checkChannelDir(file); check.Result != checkFailed || check.exitCode != exitChannelDir {
+		t.Errorf("expected the channel check to fail with %d, got %+v", exitChannelDir, check)
+	}
+	if check := checkChannelDir(t.TempDir()); check.Result != checkPassed {
+		t.Errorf("expected the channel check to pass, got %+v", check)
+	}
+
+	if !libusbAvailable {
+		if check := checkLibusb(Config{Backend: backendLibusb}, false); check.Result != checkFailed || check.exitCode != exitLibusb {
+			t.Errorf("expected the libusb check to fail with %d, got %+v", exitLibusb, check)
+		}
+		if check := checkLibusb(Config{Backend: backendAuto}, false); check.Result != checkWarning {
+			t.Errorf("expected the libusb check to warn with the auto backend, got %+v", check)
+		}
+	}
+	if check := checkDevices(Config{Backend: backendLibusb}, false); check.exitCode != exitDevices {
+		t.Errorf("expected the devices check to fail with %d, got %+v", exitDevices, check)
+	}
+
+	// Simulations do not need the USB stack
+	for _, check := range []selfTestCheck{checkLibusb(Config{Backend: backendLibusb}, true),
+		checkDevices(Config{Backend: backendLibusb}, true), checkUdevadm(true)} {
+		if check.Result != checkSkipped {
+			t.Errorf("expected %s to be skipped, got %s", check.Name, check.Result)
+		}
+	}
+}
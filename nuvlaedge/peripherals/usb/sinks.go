@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/backlog"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/cluster"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+)
+
+// sinkTimeout bounds every network operation of the MQTT and REST sinks
+const sinkTimeout = 10 * time.Second
+
+// buildSinks creates the report sinks listed in the configuration
+func buildSinks(cfg Config) ([]sink.Sink, error) {
+	var sinks []sink.Sink
+
+	for _, name := range cfg.Sinks {
+		switch name {
+		case "file":
+			layout, ok := sink.Layouts[cfg.BufferLayout]
+			if !ok {
+				return nil, fmt.Errorf("unknown buffer layout %q", cfg.BufferLayout)
+			}
+			permissions, err := sink.ParsePermissions(cfg.BufferFileMode, cfg.BufferOwner)
+			if err != nil {
+				return nil, err
+			}
+			fileSink := &sink.FileSink{Dir: ChannelPath, Sender: PeripheralName, Layout: layout, Permissions: &permissions}
+			if err := fileSink.Prepare(); err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, fileSink)
+		case "mqtt":
+			hostname, _ := os.Hostname()
+			sinks = append(sinks, &sink.MQTTSink{
+				Broker:   cfg.MQTTBroker,
+				Topic:    cfg.MQTTTopic,
+				ClientID: "nuvlaedge-usb-" + hostname,
+				Username: cfg.MQTTUsername,
+				Password: cfg.MQTTPassword,
+				Timeout:  sinkTimeout,
+			})
+		case "socket":
+			socket, err := sink.ListenSocket(cfg.SocketPath, PeripheralName, cfg.SocketEncoding, sinkTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("unable to listen on %s: %w", cfg.SocketPath, err)
+			}
+			sinks = append(sinks, socket)
+		case "rest":
+			if len(cfg.RESTURL) == 0 {
+				return nil, fmt.Errorf("the rest sink requires USB_REST_URL to be set")
+			}
+			sinks = append(sinks, sink.NewRESTSink(cfg.RESTURL, sinkTimeout))
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+
+	if len(sinks) == 0 {
+		return nil, fmt.Errorf("at least one sink must be configured in USB_SINKS")
+	}
+
+	if len(cfg.SnapshotPath) > 0 {
+		sinks = append(sinks, &sink.SnapshotSink{Path: cfg.SnapshotPath})
+	}
+
+	switch cfg.ClusterRole {
+	case "":
+	case "member", "leader":
+		sinks = append(sinks, &cluster.NodeSink{Dir: cfg.ClusterPath, Node: cfg.NodeName})
+	default:
+		return nil, fmt.Errorf("unknown cluster role %q", cfg.ClusterRole)
+	}
+	return sinks, nil
+}
+
+// closeDispatcher gives the sinks a moment to deliver the queued reports
+func closeDispatcher(dispatcher *sink.Dispatcher) {
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+	dispatcher.Close(ctx)
+}
+
+// newBacklogMonitor follows the consumption of the buffer files by the agent
+// until ctx is done. Nil without the file sink or when disabled.
+func newBacklogMonitor(ctx context.Context, cfg Config, background *sync.WaitGroup) *backlog.Monitor {
+	if cfg.BacklogStallAfter <= 0 {
+		return nil
+	}
+	for _, name := range cfg.Sinks {
+		if name == "file" {
+			monitor := backlog.New(ChannelPath, cfg.BacklogStallAfter)
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				monitor.Run(ctx)
+			}()
+			return monitor
+		}
+	}
+	return nil
+}
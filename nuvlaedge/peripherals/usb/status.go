@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/backlog"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/deployments"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/health"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/instance"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/latency"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/maintenance"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/power"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/typec"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	log "github.com/sirupsen/logrus"
+)
+
+const StatusPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/status.json"
+
+// Module status values understood by the agent status handler
+const (
+	statusRunning = "RUNNING"
+	statusWarning = "WARNING"
+	statusFailing = "FAILING"
+)
+
+// managerStatus is the health fragment the agent adds to the status notes of
+// the nuvlabox-status
+type managerStatus struct {
+	Status         string         `json:"status"`
+	Time           string         `json:"time"`
+	Devices        int            `json:"devices"`
+	DevicesByClass map[string]int `json:"devices-by-class"`
+	ScanDuration   float64        `json:"last-scan-duration"`
+	ScanErrors     int            `json:"scan-errors"`
+	LastError      string         `json:"last-error,omitempty"`
+	Sinks          []sink.Health  `json:"sinks,omitempty"`
+	// Maintenance in progress, and the changes over the last one
+	Maintenance     *maintenance.Window  `json:"maintenance,omitempty"`
+	LastMaintenance *maintenance.Summary `json:"last-maintenance,omitempty"`
+	// Conflict is another manager instance standing by, kept from reporting
+	// to the same channel
+	Conflict *instance.Owner `json:"conflict,omitempty"`
+	// Clock is set when the system clock is not synchronized or jumped
+	Clock *clockStatus `json:"clock,omitempty"`
+	// Bandwidth is the estimated utilization of the buses with isochronous
+	// devices
+	Bandwidth []peripherals.BusBandwidth `json:"bandwidth,omitempty"`
+	// Latency is the time the last attached peripherals took to be detected,
+	// reported and acknowledged, by stage
+	Latency map[string]latency.Percentiles `json:"latency,omitempty"`
+	// Power is the power source of the host, while it runs on battery
+	Power *power.State `json:"power,omitempty"`
+	// Compliance tells whether the required security tokens are attached
+	Compliance *peripherals.Compliance `json:"compliance,omitempty"`
+	// Backlog is the number and age of the buffer files the agent has not
+	// consumed yet
+	Backlog *backlog.Status `json:"backlog,omitempty"`
+	// USBC are the USB-C ports of the host, with the alternate modes and
+	// Power Delivery contract of the partners plugged in
+	USBC []typec.Port `json:"usb-c,omitempty"`
+	// Deployments are the device paths the running containers map, with the
+	// peripherals they are nodes of and the ones that no longer exist
+	Deployments *deployments.Report `json:"deployments,omitempty"`
+	// OverCurrent are the hubs whose ports had over-current conditions over
+	// the health window, with the peripherals they cut off
+	OverCurrent []health.HubOverCurrent `json:"over-current,omitempty"`
+}
+
+// statusWriter keeps the error counters across scans and rewrites the status
+// fragment after each of them
+type statusWriter struct {
+	path        string
+	maintenance *maintenance.Mode
+	clock       *clockWatcher
+	lock        *instance.Lock
+	latency     *latency.Tracker
+	backlog     *backlog.Monitor
+	deployments *deployments.Checker
+	health      *health.Monitor
+	power       *power.Policy
+	required    []string
+	errors      int
+	lastError   string
+	// warnings are the bandwidth warnings already logged, by bus
+	warnings map[int]string
+	// missing are the security tokens already logged missing
+	missing string
+}
+
+func newStatusWriter(path string, mode *maintenance.Mode, lock *instance.Lock, tracker *latency.Tracker, monitor *backlog.Monitor, checker *deployments.Checker, healthMonitor *health.Monitor, energy *power.Policy, required []string) *statusWriter {
+	return &statusWriter{path: path, maintenance: mode, clock: newClockWatcher(), lock: lock, latency: tracker,
+		backlog: monitor, deployments: checker, health: healthMonitor, power: energy, required: required, warnings: map[int]string{}}
+}
+
+// record saves the outcome of a scan and the state of the sinks. A recovered
+// panic is failing, an enumeration error, a sink with an open circuit or a
+// second manager instance a warning, as is a bus without the bandwidth for its
+// isochronous devices, an agent no longer consuming the buffer, a required
+// security token missing, a container mapping a device path that no longer
+// exists or a hub port with an over-current condition.
+func (w *statusWriter) record(discovered []peripherals.Peripheral, stats peripherals.ScanStats, bandwidth []peripherals.BusBandwidth, devErr error, recovered bool, sinks []sink.Health) error {
+	status := managerStatus{
+		Status:         statusRunning,
+		Time:           time.Now().UTC().Format(time.RFC3339),
+		Devices:        len(discovered),
+		DevicesByClass: countByClass(discovered),
+		ScanDuration:   stats.Duration.Seconds(),
+		Sinks:          sinks,
+		Bandwidth:      bandwidth,
+	}
+	status.Maintenance, status.LastMaintenance = w.maintenance.Status()
+	status.Clock = w.clock.status()
+	if w.latency != nil {
+		status.Latency = w.latency.Summary()
+	}
+	status.USBC = typec.Read(peripherals.DefaultSysfsDir)
+	if w.power != nil && w.power.Saving() {
+		state := w.power.State()
+		status.Power = &state
+	}
+	if w.backlog != nil {
+		backlog := w.backlog.Status(time.Now())
+		status.Backlog = &backlog
+		if backlog.Stalled {
+			status.Status = statusWarning
+		}
+	}
+	if w.deployments != nil {
+		if status.Deployments = w.deployments.Report(); status.Deployments != nil && status.Deployments.Missing > 0 {
+			status.Status = statusWarning
+		}
+	}
+	if w.health != nil {
+		if status.OverCurrent = w.health.OverCurrent(); len(status.OverCurrent) > 0 {
+			status.Status = statusWarning
+		}
+	}
+	if status.Conflict = w.lock.Conflict(time.Now()); status.Conflict != nil {
+		status.Status = statusWarning
+	}
+	for _, health := range sinks {
+		if health.State != sink.CircuitClosed {
+			status.Status = statusWarning
+		}
+	}
+	if w.checkBandwidth(bandwidth) {
+		status.Status = statusWarning
+	}
+	// Partial scans may miss the tokens
+	if len(w.required) > 0 && devErr == nil && !recovered {
+		compliance := peripherals.CheckCompliance(w.required, discovered)
+		status.Compliance = &compliance
+		if w.checkCompliance(compliance) {
+			status.Status = statusWarning
+		}
+	}
+
+	switch {
+	case recovered:
+		status.Status = statusFailing
+		w.errors++
+		w.lastError = "USB discovery panicked"
+	case devErr != nil:
+		status.Status = statusWarning
+		w.errors++
+		w.lastError = devErr.Error()
+	}
+	status.ScanErrors = w.errors
+	status.LastError = w.lastError
+
+	bData, _ := json.Marshal(status)
+	if err := os.MkdirAll(filepath.Dir(w.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, bData, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.path)
+}
+
+// checkBandwidth logs the bandwidth warnings when they change, and tells
+// whether there is any
+func (w *statusWriter) checkBandwidth(bandwidth []peripherals.BusBandwidth) bool {
+	warned := map[int]string{}
+	for _, bus := range bandwidth {
+		if bus.Warning == "" {
+			continue
+		}
+		warned[bus.Bus] = bus.Warning
+		if w.warnings[bus.Bus] != bus.Warning {
+			log.Warnf("%s (devices %v)", bus.Warning, bus.Devices)
+		}
+	}
+	for bus := range w.warnings {
+		if _, exists := warned[bus]; !exists {
+			log.Infof("The isochronous devices of bus %d fit in its bandwidth again", bus)
+		}
+	}
+	w.warnings = warned
+	return len(warned) > 0
+}
+
+// checkCompliance logs the security tokens missing when they change, and tells
+// whether any is
+func (w *statusWriter) checkCompliance(compliance peripherals.Compliance) bool {
+	missing := strings.Join(compliance.Missing, ", ")
+	switch {
+	case missing == w.missing:
+	case len(missing) > 0:
+		log.Warnf("The required security tokens %s are not attached", missing)
+	default:
+		log.Infof("The required security tokens are attached")
+	}
+	w.missing = missing
+	return !compliance.Compliant
+}
+
+// countByClass counts the peripherals having each interface class. A device
+// with several classes counts once in each of them.
+func countByClass(discovered []peripherals.Peripheral) map[string]int {
+	counts := map[string]int{}
+	for _, peripheral := range discovered {
+		for _, class := range peripheral.Classes {
+			counts[class]++
+		}
+	}
+	return counts
+}
@@ -1,214 +1,652 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"runtime/debug"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/google/gousb"
-	"github.com/google/gousb/usbid"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/audit"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/bom"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/census"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/changes"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/cluster"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/deployments"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/events"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/grace"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/health"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/instance"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/location"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/lock"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/maintenance"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/overrides"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/plugins"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/power"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/privacy"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/registry"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/sink"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/throttle"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/internal/uptime"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals"
+	"github.com/nuvlaedge/nuvlaedge/nuvlaedge/peripherals/usb/pkg/peripherals/simulate"
 	log "github.com/sirupsen/logrus"
 )
 
-const DatetimeFormat = "01022006150405"
 const NuvlaEdgeRootFileSystem = "/var/lib/nuvlaedge/"
 const PeripheralsFolder = ".peripherals/"
 const PeripheralName = "usb"
 const ChannelPath = NuvlaEdgeRootFileSystem + PeripheralsFolder + PeripheralName + "/buffer/"
 
-var lsUsbFunctional = false
-
-func getSerialNumberForDevice(devicePath string) string {
-	cmd := exec.Command("udevadm", "info", "--attribute-walk", devicePath)
-
-	stdout, cmdErr := cmd.Output()
-	var serialNumber string = ""
-	var backupSerialNumber string = ""
+func onContextError(err error) {
+	log.Warnf("Unable to initialize USB discovery. Host might be incompatible with this "+
+		"peripheral manager. Trying again later... Reason: %s", err)
+	time.Sleep(10 * time.Second)
+	os.Exit(0)
+}
 
-	if cmdErr != nil {
-		log.Errorf("Unable to run udevadm for device %s. Reason: %s", devicePath, cmdErr.Error())
-		return serialNumber
+func checkFileSystem() {
+	log.Infof("Creating USB folder structure %s", ChannelPath)
+	if err := os.MkdirAll(ChannelPath, os.ModePerm); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	for _, line := range strings.Split(string(stdout), "\n") {
-		if strings.Contains(line, "serial") {
-			if strings.Contains(line, ".usb") {
-				backupSerialNumber = strings.Split(line, "\"")[1]
-				continue
-			}
-			serialNumber = strings.Split(line, "\"")[1]
-			break
-		}
+// applyLocation sets the location of the port the peripheral is plugged in,
+// which the overrides of the device may replace
+func applyLocation(peripheral *peripherals.Peripheral, locations location.Map) {
+	place := locations.Locate(peripheral.Port)
+	if len(place) == 0 {
+		return
+	}
+	if peripheral.Attributes == nil {
+		peripheral.Attributes = map[string]interface{}{}
 	}
+	peripheral.Attributes["location"] = place
+}
 
-	if len(serialNumber) == 0 && len(backupSerialNumber) > 0 {
-		serialNumber = backupSerialNumber
+// applyOverrides merges the technician provided attributes for the device with
+// the given serial number into its peripheral record
+func applyOverrides(peripheral *peripherals.Peripheral, overridesPath string) {
+	serialNumber := peripheral.SerialNumber
+	if len(serialNumber) == 0 {
+		return
 	}
 
-	return serialNumber
+	attributes, err := overrides.Load(overridesPath, serialNumber)
+	if err != nil {
+		log.Errorf("Unable to load overrides for device %s. Reason: %s", serialNumber, err)
+		return
+	}
+	if len(attributes) == 0 {
+		return
+	}
+	if peripheral.Attributes == nil {
+		peripheral.Attributes = map[string]interface{}{}
+	}
+	if ignored := overrides.Merge(peripheral.Attributes, attributes); len(ignored) > 0 {
+		log.Warnf("Ignoring reserved attributes %v in overrides for device %s", ignored, serialNumber)
+	}
 }
 
-func onContextError() {
-	if !lsUsbFunctional {
-		log.Warn("Unable to initialize USB discovery. Host might be incompatible with this " +
-			"peripheral manager. Trying again later...")
-		time.Sleep(10 * time.Second)
-		log.Info(string(debug.Stack()))
-		os.Exit(0)
+// buildMessage indexes the peripherals by identifier, as expected by the agent.
+// When a claim registry is given, every record tells whether an application
+// holds the peripheral. The redactor, if any, only rewrites the reported
+// copies: the discovered records keep the real serial numbers and paths.
+func buildMessage(discovered []peripherals.Peripheral, cfg Config, claims *lock.Registry, redactor *privacy.Redactor) map[string]peripherals.Peripheral {
+	locations, err := location.Load(cfg.PortLocationsPath)
+	if err != nil {
+		log.Errorf("Unable to load the locations of the USB ports. Reason: %s", err)
+	}
+	message := map[string]peripherals.Peripheral{}
+	for i := range discovered {
+		peripheral := &discovered[i]
+		applyLocation(peripheral, locations)
+		applyOverrides(peripheral, cfg.OverridesPath)
+		if claims != nil {
+			claims.Annotate(peripheral)
+		}
+		message[peripheral.Identifier] = redactor.Redact(*peripheral)
 	}
+	return message
 }
 
-func getUsbContext() *gousb.Context {
-	defer onContextError()
-	c := gousb.NewContext()
-	lsUsbFunctional = true
-	return c
+// sleep waits for the given duration, or until ctx is done
+func sleep(ctx context.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
 }
 
-func formatFileName() string {
-	now := time.Now().Format(DatetimeFormat)
-	return string(now) + "_" + PeripheralName + ".json"
+// scanContext bounds a single scan with the configured timeout. Unlike the
+// scan budget, which only stops the deep probing, the timeout interrupts the
+// whole scan. A zero timeout disables the limit.
+func scanContext(ctx context.Context, cfg Config) (context.Context, context.CancelFunc) {
+	if cfg.ScanTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, cfg.ScanTimeout)
 }
 
-func checkFileSystem() {
-	log.Infof("Creating USB folder structure %s", ChannelPath)
-	if err := os.MkdirAll(ChannelPath, os.ModePerm); err != nil {
-		log.Fatal(err)
+// newDeploymentsChecker follows the device paths of the containers listed
+// from the configured API. Nil when disabled.
+func newDeploymentsChecker(cfg Config) (*deployments.Checker, error) {
+	var source deployments.Source
+	switch cfg.DeploymentsSource {
+	case "":
+		return nil, nil
+	case "docker":
+		source = deployments.NewDocker(cfg.DockerSocket)
+	case "kubernetes":
+		kubernetes, err := deployments.NewKubernetes(cfg.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		source = kubernetes
+	default:
+		return nil, fmt.Errorf("unknown deployments source %q", cfg.DeploymentsSource)
 	}
+	checker := deployments.New(source, cfg.DeploymentsInterval, sinkTimeout)
+	checker.RemediationsPath = cfg.RemediationsPath
+	return checker, nil
 }
 
-func saveDiscoveredPeripherals(data map[string]interface{}) {
-	bData, _ := json.Marshal(data)
-	file := ChannelPath + formatFileName()
-	log.Infof("Saving USB peripherals to %s", file)
-	_ = os.WriteFile(
-		file,
-		bData,
-		0644)
+// acquireInstanceLock waits until no other manager reports to the channel. A
+// manager standing by records itself, so the active one reports the conflict.
+func acquireInstanceLock(ctx context.Context, cfg Config) *instance.Lock {
+	if len(cfg.InstanceLockPath) == 0 {
+		return nil
+	}
+	self := instance.Self(time.Now())
+	for {
+		lock, holder, err := instance.Acquire(cfg.InstanceLockPath, self, 3*cfg.ScanInterval)
+		if err == nil {
+			return lock
+		}
+		if errors.Is(err, instance.ErrLocked) {
+			log.Errorf("Another USB peripheral manager (pid %d on %s, started %s) reports to %s. Standing by...",
+				holder.PID, holder.Host, holder.Started, ChannelPath)
+			if err := instance.ReportConflict(cfg.InstanceLockPath, self, time.Now()); err != nil {
+				log.Errorf("Unable to report the manager conflict. Reason: %s", err)
+			}
+		} else {
+			log.Errorf("Unable to lock %s. Reason: %s", cfg.InstanceLockPath, err)
+		}
+		sleep(ctx, cfg.ScanInterval)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
 }
 
 func main() {
+	benchmark := flag.Bool("benchmark", false, "Measure the scan latency and exit")
+	iterations := flag.Int("iterations", 20, "Number of scans to run in benchmark mode")
+	migrate := flag.Bool("migrate-buffer", false, "Rename the buffer files of the legacy Python manager and exit")
+	record := flag.String("record", "", "Record the USB devices listed by every scan in a file, to replay them later")
+	recording := flag.String("replay", "", "Replay the USB devices of a recorded discovery session instead of the attached ones")
+	scenario := flag.String("simulate", "", "Report the synthetic peripherals of a scenario file instead of the attached ones")
+	selfTest := flag.Bool("self-test", false, "Check the host provides what the manager needs, write the diagnostics report and exit")
+	flag.Parse()
+
+	if *migrate {
+		migrated, err := sink.MigrateBuffer(ChannelPath)
+		if err != nil {
+			log.Fatalf("Unable to migrate the buffer files. Reason: %s", err)
+		}
+		log.Infof("Migrated %d buffer files", migrated)
+		return
+	}
+
 	log.Info("Peripheral Manager USB has started")
 
-	// Only one context should be needed for an application.  It should always be closed.
-	ctx := getUsbContext()
-	defer func(ctx *gousb.Context) {
-		ctx.Close()
-	}(ctx)
+	cfg := loadConfig()
 
-	var available string = "True"
-	var devInterface string = "USB"
-	var videoFilesBasedir string = "/dev/"
-	checkFileSystem()
+	// The manager restarts in place to apply the configuration pushed from
+	// Nuvla, once everything is released
+	restart := false
+	defer func() {
+		if restart {
+			restartManager()
+		}
+	}()
 
-	for true {
-		// Default name for USB
-		name := "UNNAMED USB Device"
-		var message = map[string]interface{}{}
+	if len(*scenario) > 0 {
+		cfg.Simulate = *scenario
+	}
+	if len(*record) > 0 {
+		cfg.Record = *record
+	}
+	if len(*recording) > 0 {
+		cfg.Replay = *recording
+	}
 
-		_, devErr := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-			identifier := fmt.Sprintf("%s:%s", desc.Vendor, desc.Product)
+	// Installs missing the USB stack or the data volume fail right away, with
+	// a distinct exit code, instead of reporting no peripheral
+	if cfg.SelfTest || *selfTest {
+		report := runSelfTest(cfg)
+		logSelfTest(report, cfg)
+		if code := report.exitCode(); code != 0 {
+			log.Errorf("The self-test failed, see %s", filepath.Join(cfg.DiagnosticsPath, SelfTestReport))
+			os.Exit(code)
+		}
+		if *selfTest {
+			return
+		}
+	}
 
-			devicePath := fmt.Sprintf("/dev/bus/usb/%03d/%03d", desc.Bus, desc.Address)
+	options := []peripherals.Option{
+		peripherals.WithScanBudget(cfg.ScanBudget),
+		peripherals.WithDeepScanEvery(cfg.DeepScanEvery),
+		peripherals.WithP1Probe(cfg.P1ProbeTimeout),
+		peripherals.WithGNSSProbe(cfg.GNSSProbeTimeout),
+	}
+	var backend usbBackend
+	var simulation *simulate.Backend
+	switch {
+	case len(cfg.Simulate) > 0:
+		simulated, simulationOptions, dir, err := openSimulation(cfg)
+		if err != nil {
+			log.Fatalf("Unable to start the simulation of %s. Reason: %s", cfg.Simulate, err)
+		}
+		defer os.RemoveAll(dir)
+		backend, simulation = simulated, simulated
+		options = append(options, simulationOptions...)
+		log.Warnf("Reporting the simulated peripherals of %s instead of the attached ones", cfg.Simulate)
+	case len(cfg.Replay) > 0:
+		replayed, replayOptions, dir, err := openReplay(cfg)
+		if err != nil {
+			log.Fatalf("Unable to replay the discovery session of %s. Reason: %s", cfg.Replay, err)
+		}
+		defer os.RemoveAll(dir)
+		backend = replayed
+		options = append(options, replayOptions...)
+		log.Warnf("Replaying the %d scans recorded on %s in %s instead of listing the attached devices",
+			replayed.Scans(), replayed.Header.Hostname, cfg.Replay)
+	default:
+		opened, backendName, err := openBackend(context.Background(), cfg)
+		if errors.Is(err, peripherals.ErrNoContext) {
+			onContextError(err)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		backend = opened
+		log.Infof("Listing the USB devices with %s", backendName)
 
-			vendor := usbid.Vendors[desc.Vendor]
+		if len(cfg.Record) > 0 {
+			recording, recorder, err := recordBackend(cfg, opened, backendName)
+			if err != nil {
+				log.Fatalf("Unable to record the discovery session in %s. Reason: %s", cfg.Record, err)
+			}
+			backend = recording
+			options = append(options, peripherals.WithProber(recorder))
+			log.Infof("Recording the discovery session in %s", cfg.Record)
+		} else if cfg.TopologyCache {
+			// Recordings hold every listing
+			options = append(options, peripherals.WithTopologyCache())
+		}
+	}
+	defer backend.Close()
 
-			product := vendor.Product[desc.Product]
+	if cfg.ReportHolders {
+		options = append(options, peripherals.WithHolders())
+	}
+	quirks, err := peripherals.LoadQuirks(cfg.QuirksPath)
+	if err != nil {
+		log.Errorf("Ignoring the quirk table. Reason: %s", err)
+	} else if len(quirks) > 0 {
+		log.Infof("Extending the shipped device quirks with %d entries from %s", len(quirks), cfg.QuirksPath)
+	}
+	for _, device := range cfg.NoAutosuspend {
+		entry, err := peripherals.ParseQuirks(strings.NewReader(device + " " + string(peripherals.QuirkNoAutosuspend)))
+		if err != nil {
+			log.Errorf("Ignoring the autosuspend setting of %s. Reason: %s", device, err)
+			continue
+		}
+		quirks = append(quirks, entry...)
+	}
+	if len(quirks) > 0 {
+		options = append(options, peripherals.WithQuirks(append(append(peripherals.Quirks{}, peripherals.DefaultQuirks...), quirks...)))
+	}
+	discoverer := peripherals.NewDiscoverer(backend, options...)
 
-			description := fmt.Sprintf("%s device [%s] with ID %s. Protocol: %s",
-				devInterface,
-				product,
-				identifier,
-				usbid.Classify(desc))
+	// Stopping the container cancels the scan, actions and aggregation in
+	// progress. The dispatcher is not bound to ctx, so the sinks still get a
+	// moment to deliver the last reports.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-			if product != nil {
-				name = fmt.Sprintf("%s", product)
-			} else {
-				name = fmt.Sprintf("%s with ID %s", name, identifier)
-			}
+	if *benchmark {
+		runBenchmark(ctx, backend, cfg, *iterations)
+		return
+	}
 
-			classesAux := make(map[string]bool)
+	redactor, err := privacy.New(cfg.Privacy, cfg.PrivacySalt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	throttles, err := throttle.Parse(cfg.ClassThrottles)
+	if err != nil {
+		log.Fatal(err)
+	}
+	classThrottle := throttle.New(throttles)
+	var removals *grace.Grace
+	if cfg.RemovalGracePeriod > 0 {
+		removals = grace.New(cfg.RemovalGracePeriod)
+	}
 
-			classes := make([]interface{}, 0)
+	checkFileSystem()
+	instanceLock := acquireInstanceLock(ctx, cfg)
+	if ctx.Err() != nil {
+		return
+	}
+	defer instanceLock.Release()
+	if err := writeCapabilities(cfg.CapabilitiesPath, buildCapabilities(cfg)); err != nil {
+		log.Errorf("Unable to write the peripheral manager capabilities. Reason: %s", err)
+	}
 
-			for _, cfg := range desc.Configs {
-				for _, intf := range cfg.Interfaces {
-					for _, ifSetting := range intf.AltSettings {
-						class := fmt.Sprintf("%s", usbid.Classes[ifSetting.Class])
-						if _, exists := classesAux[class]; !exists {
-							classesAux[class] = true
-							classes = append(classes, class)
-						}
-					}
-				}
-			}
+	sinks, err := buildSinks(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dispatcher := sink.NewDispatcher(sinks, cfg.SinkQueueSize,
+		sink.RetryPolicy{
+			MaxRetries:       cfg.SinkMaxRetries,
+			Backoff:          cfg.SinkBackoff,
+			FailureThreshold: cfg.SinkFailureThreshold,
+			OpenTimeout:      cfg.SinkOpenTimeout,
+		})
 
-			serialNumber := getSerialNumberForDevice(devicePath)
+	var background sync.WaitGroup
+	if cfg.ClusterRole == "leader" {
+		aggregator := &cluster.Aggregator{Dir: cfg.ClusterPath, StaleAfter: cfg.ClusterStaleAfter}
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			_ = aggregator.Run(ctx, cfg.ScanInterval)
+		}()
+	}
 
-			peripheral := map[string]interface{}{
-				"name":        name,
-				"description": description,
-				"interface":   devInterface,
-				"identifier":  identifier,
-				"classes":     classes,
-				"available":   available,
-				//"resources": n/a
-				// Leaving out the resources attribute since this is only used for
-				// block devices, which at the moment are already monitored by the
-				// NB Agent, so no need to duplicate the same information.
-				// To re-implement this attribute, check the raw legacy code in [1]
-			}
+	var auditLog *audit.Log
+	if len(cfg.AuditLogPath) > 0 {
+		if auditLog, err = audit.Open(cfg.AuditLogPath, int64(cfg.AuditLogMaxSize), cfg.AuditLogMaxFiles); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-			if len(vendor.Name) > 0 {
-				peripheral["vendor"] = vendor.Name
-			}
+	// The device state and the metrics are shared through the registry, the
+	// usb section being only written by the scan loop subscribers
+	shared := registry.New()
+	state, err := shared.Register(PeripheralName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer state.Close()
 
-			if product != nil {
-				peripheral["product"] = fmt.Sprintf("%s", product)
-			}
+	var journal *changes.Journal
+	if len(cfg.APIListen) > 0 {
+		journal = changes.New(cfg.APIMaxChanges, time.Now())
+		serveAPI(ctx, cfg, journal, shared, &background)
+	}
 
-			if len(devicePath) > 0 {
-				peripheral["device-path"] = devicePath
-			}
+	var uptimes *uptime.Tracker
+	if len(cfg.UptimePath) > 0 {
+		// Peripherals missed by several scans in a row were unplugged while
+		// the manager was not running
+		if uptimes, err = uptime.Open(cfg.UptimePath, 3*cfg.ScanInterval); err != nil {
+			log.Errorf("Unable to load the peripheral attachment history, starting a new one. Reason: %s", err)
+		}
+	}
 
-			if len(serialNumber) > 0 {
-				peripheral["serial-number"] = serialNumber
-			}
+	var healthMonitor *health.Monitor
+	if cfg.HealthWindow > 0 {
+		if healthMonitor, err = health.New(cfg.HealthWindow, cfg.KernelLogDevice, peripherals.DefaultSysfsDir, peripherals.DefaultProcDir); err != nil {
+			log.Warnf("Unable to read the USB errors of the kernel log, only following the over-current counters. Reason: %s", err)
+		}
+		defer healthMonitor.Close()
+	}
 
-			devFiles, vfErr := ioutil.ReadDir(videoFilesBasedir)
-			if vfErr != nil {
-				log.Errorf("Unable to read files under %s. Reason: %s", videoFilesBasedir, vfErr.Error())
-				return false
-			}
+	claims := lock.NewRegistry(cfg.LocksPath)
+	actions, err := buildActionChannel(cfg, state, claims, backend.OpenDFU)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if actions != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			runActionChannel(ctx, actions)
+		}()
+	}
 
-			for _, df := range devFiles {
-				if strings.HasPrefix(df.Name(), "video") {
-					vfSerialNumber := getSerialNumberForDevice(videoFilesBasedir + df.Name())
-					if vfSerialNumber == serialNumber {
-						peripheral["video-device"] = videoFilesBasedir + df.Name()
-						break
-					}
-				}
-			}
+	var extensions *plugins.Runner
+	if len(cfg.PluginsPath) > 0 {
+		extensions = plugins.New(cfg.PluginsPath, cfg.PluginTimeout)
+		extensions.WASMOnly = cfg.PluginsWASMOnly
+		defer extensions.Close(context.Background())
+	}
 
-			// we now have a peripheral categorized, but is it new
-			message[identifier] = peripheral
-			return false
+	tracker := newScanTracker()
+	backend.OnVisit(tracker.visit)
+	scheduler := newScanScheduler(cfg)
+	mode := maintenance.New(cfg.MaintenancePath)
+	latencies := newLatencyTracker(cfg, dispatcher)
+	monitor := newBacklogMonitor(ctx, cfg, &background)
+	var energy *power.Policy
+	if cfg.BatteryScanInterval > 0 {
+		energy = &power.Policy{SysfsDir: peripherals.DefaultSysfsDir, Interval: cfg.BatteryScanInterval,
+			LowCapacity: cfg.BatteryLowCapacity, LowInterval: cfg.BatteryLowScanInterval}
+	}
+	checker, err := newDeploymentsChecker(cfg)
+	if err != nil {
+		log.Errorf("Unable to follow the device paths of the running containers. Reason: %s", err)
+	}
+	status := newStatusWriter(cfg.StatusPath, mode, instanceLock, latencies, monitor, checker, healthMonitor, energy, cfg.RequiredTokens)
+
+	// The subsystems follow the scans and reports from the bus, in the order
+	// they subscribe
+	bus := events.New()
+	if checker != nil {
+		// Partial scans may miss the peripherals the containers use
+		bus.OnScanCompleted(func(scan events.ScanCompleted) {
+			if scan.Complete() {
+				checker.Check(ctx, scan.Time, scan.Discovered)
+			}
+		})
+	}
+	bus.OnScanCompleted(func(scan events.ScanCompleted) {
+		state.Counter("scans").Add(1)
+		if scan.Err != nil {
+			state.Counter("scan-errors").Add(1)
+		}
+		if scan.Recovered {
+			state.Counter("scan-panics").Add(1)
+		}
+		state.Gauge("devices").Set(float64(len(scan.Discovered)))
+		state.Gauge("scan-duration-seconds").Set(scan.Stats.Duration.Seconds())
+	})
+	// The status reports the over-current conditions of this scan
+	if healthMonitor != nil {
+		bus.OnScanCompleted(func(scan events.ScanCompleted) {
+			if !scan.Recovered {
+				healthMonitor.Update(scan.Time, scan.Discovered, scan.Err == nil)
+			}
 		})
-		jsonMessage, _ := json.MarshalIndent(message, "", "  ")
+	}
+	bus.OnScanCompleted(func(scan events.ScanCompleted) {
+		if err := status.record(scan.Discovered, scan.Stats, scan.Bandwidth, scan.Err, scan.Recovered, dispatcher.Health()); err != nil {
+			log.Errorf("Unable to write the peripheral manager status. Reason: %s", err)
+		}
+	})
+	if latencies != nil {
+		bus.OnScanCompleted(func(scan events.ScanCompleted) {
+			if scan.Complete() {
+				latencies.Scan(scan.Time, attachTimes(scan.Discovered, cfg.UdevDataDir, scan.Time))
+			}
+		})
+	}
+	if uptimes != nil {
+		bus.OnScanCompleted(func(scan events.ScanCompleted) {
+			if scan.Recovered {
+				return
+			}
+			if err := uptimes.Update(scan.Time, scan.Discovered, scan.Err == nil); err != nil {
+				log.Errorf("Unable to save the peripheral attachment history. Reason: %s", err)
+			}
+		})
+	}
+	if cfg.CensusInterval > 0 && len(cfg.CensusPath) > 0 {
+		// Only complete scans count all the hardware of the edge
+		hardware := census.New(cfg.CensusPath, cfg.CensusInterval)
+		bus.OnScanCompleted(func(scan events.ScanCompleted) {
+			if !scan.Complete() {
+				return
+			}
+			if _, err := hardware.Update(scan.Time, scan.Discovered); err != nil {
+				log.Errorf("Unable to write the hardware census. Reason: %s", err)
+			}
+		})
+	}
+	if cfg.BOMInterval > 0 && len(cfg.BOMPath) > 0 {
+		edge := bom.Edge{Name: cfg.NodeName, Tool: "NuvlaEdge USB peripheral manager", Version: buildVersion()}
+		materials, err := bom.New(cfg.BOMPath, cfg.BOMKeyPath, cfg.BOMInterval, edge)
+		if err != nil {
+			log.Errorf("Unable to sign the hardware bill of materials. Reason: %s", err)
+		} else {
+			// The evidence must list all the hardware, partial scans are left out
+			bus.OnScanCompleted(func(scan events.ScanCompleted) {
+				if !scan.Complete() {
+					return
+				}
+				if _, err := materials.Update(scan.Time, scan.Discovered); err != nil {
+					log.Errorf("Unable to write the hardware bill of materials. Reason: %s", err)
+				}
+			})
+		}
+	}
+	bus.OnDeviceAdded(func(added events.DeviceAdded) {
+		log.Debugf("Peripheral %s attached at %s", added.Peripheral.Identifier, added.Peripheral.DevicePath)
+	})
+	bus.OnDeviceRemoved(func(removed events.DeviceRemoved) {
+		log.Debugf("Peripheral %s detached from %s", removed.Peripheral.Identifier, removed.Peripheral.DevicePath)
+	})
+	// The actions keep working on the current peripherals during a
+	// maintenance, only the reports are held back
+	bus.OnReportBuilt(func(built events.ReportBuilt) {
+		state.Publish(built.Report.Time, built.Discovered)
+		if built.HeldBack {
+			state.Counter("reports-held-back").Add(1)
+		} else {
+			state.Counter("reports").Add(1)
+		}
+	})
+	bus.OnReportBuilt(func(built events.ReportBuilt) {
+		if built.HeldBack {
+			return
+		}
+		jsonMessage, _ := json.MarshalIndent(built.Report.Peripherals, "", "  ")
 		log.Infof("Usb found with feats: %s", string(jsonMessage))
-		log.Infof("Generating File name: %s", formatFileName())
-		saveDiscoveredPeripherals(message)
+		dispatcher.Publish(built.Report)
+	})
+	if auditLog != nil {
+		bus.OnReportBuilt(func(built events.ReportBuilt) {
+			if built.HeldBack {
+				return
+			}
+			if _, err := auditLog.Record(built.Report.Time, built.Report.Peripherals); err != nil {
+				log.Errorf("Unable to write the peripheral audit log. Reason: %s", err)
+			}
+		})
+	}
+	if journal != nil {
+		bus.OnReportBuilt(func(built events.ReportBuilt) {
+			if built.HeldBack {
+				return
+			}
+			if err := journal.Record(built.Report.Time, built.Report.Peripherals); err != nil {
+				log.Errorf("Unable to record the peripheral changes. Reason: %s", err)
+			}
+		})
+	}
+
+	for ctx.Err() == nil {
+		if remoteConfigChanged() {
+			log.Info("The configuration pushed from Nuvla changed, restarting the USB peripheral manager to apply it")
+			restart = true
+			stop()
+			break
+		}
+		if energy != nil {
+			energy.Refresh()
+			discoverer.SetShallow(energy.Saving())
+		}
+		scanCtx, cancel := scanContext(ctx, cfg)
+		discovered, devErr, recovered := safeDiscoverPeripherals(scanCtx, discoverer, tracker, cfg)
+		cancel()
+		if simulation != nil {
+			discovered = simulation.Decorate(discovered)
+		}
+		if extensions != nil {
+			extensions.Apply(ctx, discovered)
+		}
+		// Scans interrupted by the shutdown are incomplete, they are not reported
+		if ctx.Err() != nil {
+			break
+		}
+		mode.Refresh(time.Now())
+		bus.PublishScan(events.ScanCompleted{Time: time.Now(), Discovered: discovered, Stats: discoverer.Stats(),
+			Bandwidth: discoverer.Bandwidth(), Err: devErr, Recovered: recovered})
+		if recovered {
+			if tracker.panics >= cfg.MaxConsecutivePanics {
+				log.Errorf("USB discovery panicked %d times in a row. Exiting...", tracker.panics)
+				closeDispatcher(dispatcher)
+				backend.Close()
+				os.Exit(1)
+			}
+			sleep(ctx, cfg.ScanInterval)
+			continue
+		}
+
+		message := buildMessage(discovered, cfg, claims, redactor)
+		if removals != nil {
+			var suspects []string
+			if message, suspects = removals.Apply(time.Now(), message); len(suspects) > 0 {
+				log.Debugf("Reporting the missing peripherals %s as suspects until their grace period ends", strings.Join(suspects, ", "))
+			}
+		}
+		if len(throttles) > 0 {
+			var heldBack []string
+			if message, heldBack = classThrottle.Apply(time.Now(), message); len(heldBack) > 0 {
+				log.Debugf("Holding back the updates of the throttled peripherals %s", strings.Join(heldBack, ", "))
+			}
+		}
+		report := sink.Report{Time: time.Now().UTC(), Peripherals: message}
+		publish, _ := mode.Report(report.Time, message)
+		bus.Publish(events.ReportBuilt{Report: report, Discovered: discovered, HeldBack: !publish})
 
 		if devErr != nil {
 			log.Errorf("A problem occurred while listing the USB peripherals %s. Continuing...", devErr)
 		}
 
-		time.Sleep(30 * time.Second)
+		next := scheduler.next(time.Now(), message)
+		if energy != nil {
+			next = energy.Next(next)
+		}
+		sleep(ctx, next)
 	}
-}
\ No newline at end of file
+
+	log.Info("Stopping the USB peripheral manager")
+	background.Wait()
+	closeDispatcher(dispatcher)
+}
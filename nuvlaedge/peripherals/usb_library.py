@@ -0,0 +1,80 @@
+#!/usr/bin/env python3
+# -*- coding: utf-8 -*-
+
+"""NuvlaEdge Peripheral Manager USB, in process
+
+Discovers the USB peripherals through the C shared library build of the USB peripheral manager, for the minimal installs
+where the manager cannot run in its own container. The library is built with:
+    go build -buildmode=c-shared -o libnuvlaedgeusb.so ./capi
+
+and looked up at NUVLAEDGE_USB_LIBRARY. The peripherals are reported as the manager reports them.
+"""
+import ctypes
+import json
+import logging
+import os
+
+from nuvlaedge.peripherals.peripheral import Peripheral
+from nuvlaedge.common.nuvlaedge_config import parse_arguments_and_initialize_logging
+
+logger: logging.Logger = logging.getLogger(__name__)
+
+USB_LIBRARY = os.getenv('NUVLAEDGE_USB_LIBRARY', '/usr/lib/libnuvlaedgeusb.so')
+USB_SCAN_TIMEOUT = int(os.getenv('USB_SCAN_TIMEOUT', '60'))
+
+
+class USBLibrary:
+    """
+    Binding of the USB discovery library
+    """
+
+    def __init__(self, path: str = USB_LIBRARY, loader=ctypes.CDLL):
+        """
+        :raises OSError: when the library cannot be loaded
+        """
+        self.library = loader(path)
+        self.library.nuvlaedge_usb_discover.argtypes = [ctypes.c_int]
+        # Kept as a raw pointer, a c_char_p result would be copied and never released
+        self.library.nuvlaedge_usb_discover.restype = ctypes.c_void_p
+        self.library.nuvlaedge_usb_free.argtypes = [ctypes.c_void_p]
+        self.library.nuvlaedge_usb_free.restype = None
+
+    def discover(self, timeout: int = USB_SCAN_TIMEOUT) -> dict:
+        """
+        Runs a USB scan in process
+        :param timeout: Scan timeout, in seconds. 0 to disable it
+        :return: The USB peripherals by identifier
+        """
+        result = self.library.nuvlaedge_usb_discover(timeout * 1000)
+        try:
+            output = json.loads(ctypes.string_at(result))
+        finally:
+            self.library.nuvlaedge_usb_free(result)
+
+        if output.get('error'):
+            logger.error(f'A problem occurred while listing the USB peripherals: {output["error"]}')
+        return output.get('peripherals') or {}
+
+
+def usb_manager(library: USBLibrary, **kwargs) -> dict:
+    return library.discover()
+
+
+def main():
+    global logger
+    parse_arguments_and_initialize_logging('USB Peripheral')
+
+    logger = logging.getLogger(__name__)
+    logger.info('USB PERIPHERAL MANAGER STARTED, IN PROCESS')
+
+    library = USBLibrary()
+    usb_peripheral: Peripheral = Peripheral('usb')
+    usb_peripheral.run(usb_manager, library=library)
+
+
+def entry():
+    main()
+
+
+if __name__ == '__main__':
+    main()
@@ -1,4 +1,7 @@
-from datetime import datetime
+import json
+import tempfile
+from datetime import datetime, timezone
+from pathlib import Path
 from unittest import TestCase
 from unittest.mock import Mock, patch
 
@@ -76,10 +79,44 @@ class TestStatusHandler(TestCase):
         mock_add.assert_called_once_with('test_report')
         mock_process.assert_called_once()
 
+    @patch('nuvlaedge.agent.common.status_handler.FILE_NAMES')
+    def test_get_peripherals_status(self, mock_file_names):
+        with tempfile.TemporaryDirectory() as tmp:
+            peripherals = Path(tmp)
+            mock_file_names.PERIPHERALS_FOLDER = peripherals
+
+            (peripherals / 'usb').mkdir()
+            (peripherals / 'usb' / 'status.json').write_text(json.dumps({
+                'status': 'WARNING',
+                'time': datetime.now(timezone.utc).isoformat(),
+                'devices': 2,
+                'devices-by-class': {'Video': 1, 'Audio': 1},
+                'last-scan-duration': 0.25,
+                'scan-errors': 1,
+                'last-error': 'timeout'}))
+            (peripherals / 'gpu').mkdir()
+            (peripherals / 'gpu' / 'status.json').write_text(json.dumps({
+                'status': 'RUNNING',
+                'time': '2020-01-01T00:00:00Z'}))
+
+            self.test_status_handler._get_peripherals_status()
+
+            reports = {}
+            while not self.test_status_handler.status_channel.empty():
+                report = self.test_status_handler.status_channel.get()
+                reports[report.origin_module] = report
+
+            self.assertEqual(reports['Peripheral usb'].module_status, 'WARNING')
+            self.assertEqual(reports['Peripheral usb'].message,
+                             '2 devices (Audio: 1, Video: 1), last scan 0.25s, 1 errors - last error: timeout')
+            # Stale status
+            self.assertEqual(reports['Peripheral gpu'].module_status, 'UNKNOWN')
+
     @patch('nuvlaedge.agent.common.status_handler.NuvlaEdgeStatusHandler.update_status')
+    @patch('nuvlaedge.agent.common.status_handler.NuvlaEdgeStatusHandler._get_peripherals_status')
     @patch('nuvlaedge.agent.common.status_handler.NuvlaEdgeStatusHandler._get_system_manager_status')
     @patch('nuvlaedge.agent.common.status_handler.NuvlaEdgeStatusHandler._get_coe_status')
-    def test_get_status(self, mock_coe, mock_sm_status, mock_update):
+    def test_get_status(self, mock_coe, mock_sm_status, mock_peripherals_status, mock_update):
         self.test_status_handler._status = 'OPERATIONAL'
         self.test_status_handler._notes = ['Test note']
         self.assertEqual(self.test_status_handler.get_status(Mock()), ('OPERATIONAL', ['Test note']))
@@ -1,6 +1,6 @@
 import filelock
 
-from datetime import datetime
+from datetime import datetime, timezone
 from pathlib import Path
 
 from unittest import TestCase
@@ -18,26 +18,50 @@ class TestFileBroker(TestCase):
     def test_decode_message_from_file_name(self):
 
         # Test standard formatting without errors
-        sample_date: str = datetime.now().strftime(CTE.DATETIME_FORMAT)
+        sample_date: str = '20240501T120000Z'
+        sample_time = datetime(2024, 5, 1, 12, 0, 0, tzinfo=timezone.utc)
         sample_sender = 'sender'
         sample_name: str = f'{sample_date}_{sample_sender}.json'
         self.assertEqual(
             self.test_broker.decode_message_from_file_name(sample_name),
-            (datetime.strptime(sample_date, CTE.DATETIME_FORMAT), sample_sender),
+            (sample_time, sample_sender),
             'Failed')
 
+        # Test names with a sequence number
+        self.assertEqual(
+            self.test_broker.decode_message_from_file_name(f'{sample_date}_usb_1714564800000001.json'),
+            (sample_time, 'usb'),
+            'Failed')
+
+        # Test names in local time, from older peripheral managers
+        legacy_date: str = datetime.now().strftime(CTE.DATETIME_FORMAT)
+        message_time, sender = self.test_broker.decode_message_from_file_name(f'{legacy_date}_usb.json')
+        self.assertEqual(message_time, datetime.strptime(legacy_date, CTE.DATETIME_FORMAT).astimezone())
+        self.assertIsNotNone(message_time.tzinfo)
+        self.assertEqual(sender, 'usb')
+
         # Test regex comparison
         with self.assertRaises(MessageFormatError) as context:
             self.test_broker.decode_message_from_file_name('non')
             self.assertTrue('Filename non' in context.exception)
 
+    def test_decode_sequence_from_file_name(self):
+        self.assertEqual(self.test_broker.decode_sequence_from_file_name('05012024120000_usb_42.json'), 42)
+        self.assertEqual(self.test_broker.decode_sequence_from_file_name('05012024120000_usb.json'), 0)
+
     @mock.patch('nuvlaedge.broker.file_broker.datetime')
     def test_compose_file_name(self, mock_datetime):
-        dummy_date = datetime.now()
+        dummy_date = datetime(2024, 5, 1, 12, 0, 0, tzinfo=timezone.utc)
         mock_datetime.now.return_value = dummy_date
         sender = 'sender'
-        self.assertEqual(self.test_broker.compose_file_name(sender),
-                         f'{dummy_date.strftime(CTE.DATETIME_FORMAT)}_{sender}.json')
+        FileBroker._last_sequence = 0
+        self.assertEqual(self.test_broker.compose_file_name(sender), f'20240501T120000Z_{sender}_1714564800000000.json')
+        mock_datetime.now.assert_called_once_with(timezone.utc)
+
+        # Messages published within the same microsecond still get increasing sequences
+        second = self.test_broker.compose_file_name(sender)
+        self.assertEqual(second, f'20240501T120000Z_{sender}_1714564800000001.json')
+        self.assertEqual(self.test_broker.decode_sequence_from_file_name(second), 1714564800000001)
 
     @mock.patch.object(Path, 'exists')
     @mock.patch.object(Path, 'iterdir')
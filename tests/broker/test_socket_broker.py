@@ -0,0 +1,112 @@
+import io
+import json
+import socket
+import struct
+import tempfile
+import threading
+import time
+from datetime import datetime, timezone
+from pathlib import Path
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.broker.socket_broker import SocketBroker, FrameError, read_frame
+
+
+def frame(message: dict) -> bytes:
+    payload = json.dumps(message).encode()
+    return struct.pack('>I', len(payload)) + payload
+
+
+def varint(value: int) -> bytes:
+    data = b''
+    while value > 0x7f:
+        data += bytes([value & 0x7f | 0x80])
+        value >>= 7
+    return data + bytes([value])
+
+
+def field(number: int, value: int | bytes | str) -> bytes:
+    if isinstance(value, int):
+        return varint(number << 3) + varint(value)
+    if isinstance(value, str):
+        value = value.encode()
+    return varint(number << 3 | 2) + varint(len(value)) + value
+
+
+class TestSocketBroker(TestCase):
+
+    def test_read_frame(self):
+        message = {'sender': 'usb', 'time': '2024-05-01T12:00:00Z', 'sequence': 1, 'data': {}}
+        self.assertEqual(read_frame(io.BytesIO(frame(message))), message)
+
+        with self.assertRaises(EOFError):
+            read_frame(io.BytesIO(frame(message)[:-1]))
+        with self.assertRaises(FrameError):
+            read_frame(io.BytesIO(b'\xff\xff\xff\xff'))
+        with self.assertRaises(FrameError):
+            read_frame(io.BytesIO(struct.pack('>I', 3) + b'{[}'))
+
+    def test_read_protobuf_frame(self):
+        peripheral = (field(1, '046d:0825') + field(2, 'Webcam C270') + field(5, 'Video') + field(5, 'Audio') +
+                      field(6, 1) + field(7, '/dev/bus/usb/001/004') + field(14, '{"location": "front door"}') +
+                      field(100, 'future'))
+        event = (field(1, 'usb') + field(2, field(1, 1714564800) + field(2, 500000000)) + field(3, 7) +
+                 field(4, field(1, '046d:0825') + field(2, peripheral)))
+        message = read_frame(io.BytesIO(struct.pack('>I', len(event)) + event))
+
+        self.assertEqual(message['sender'], 'usb')
+        self.assertEqual(message['sequence'], 7)
+        self.assertEqual(message['time'], datetime(2024, 5, 1, 12, 0, 0, 500000, tzinfo=timezone.utc))
+        self.assertEqual(message['data'], {'046d:0825': {
+            'identifier': '046d:0825', 'name': 'Webcam C270', 'classes': ['Video', 'Audio'], 'available': True,
+            'device-path': '/dev/bus/usb/001/004', 'location': 'front door'}})
+
+        with self.assertRaises(FrameError):
+            read_frame(io.BytesIO(struct.pack('>I', 2) + field(1, 'usb')[:2]))
+
+    def test_consume(self):
+        root = tempfile.TemporaryDirectory()
+        self.addCleanup(root.cleanup)
+        channel = Path(root.name) / '.peripherals' / 'usb'
+        channel.mkdir(parents=True)
+
+        server = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+        self.addCleanup(server.close)
+        server.bind(str(channel / 'manager.sock'))
+        server.listen(1)
+
+        def serve():
+            conn, _ = server.accept()
+            with conn:
+                conn.sendall(frame({'sender': 'usb', 'time': '2024-05-01T12:00:00Z', 'sequence': 1,
+                                    'data': {'046d:0825': {'identifier': '046d:0825'}}}))
+                time.sleep(1)
+        threading.Thread(target=serve, daemon=True).start()
+
+        fallback = mock.Mock()
+        fallback.consume.return_value = []
+        broker = SocketBroker(root.name, fallback=fallback)
+        self.addCleanup(broker.close)
+
+        messages = []
+        deadline = time.monotonic() + 5
+        while not messages and time.monotonic() < deadline:
+            messages = broker.consume('.peripherals/usb')
+            time.sleep(0.05)
+
+        self.assertEqual(len(messages), 1)
+        self.assertEqual(messages[0].sender, 'usb')
+        self.assertEqual(messages[0].sequence, 1)
+        self.assertEqual(messages[0].data, {'046d:0825': {'identifier': '046d:0825'}})
+        # The buffer folder is still read, for the managers not streaming yet
+        fallback.consume.assert_called_with('.peripherals/usb')
+
+    def test_consume_without_socket(self):
+        fallback = mock.Mock()
+        fallback.consume.return_value = ['message']
+        broker = SocketBroker('/nonexistent', fallback=fallback)
+
+        self.assertEqual(broker.consume('.peripherals/network'), ['message'])
+        self.assertEqual(broker.readers, {})
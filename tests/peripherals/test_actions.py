@@ -0,0 +1,51 @@
+import json
+import tempfile
+from pathlib import Path
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.actions import ActionChannel, STATUS_FAILED, STATUS_REJECTED, STATUS_SUCCESS
+
+
+class TestActionChannel(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.channel = ActionChannel(self.temp_dir.name)
+        self.channel.requests_folder.mkdir(parents=True)
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def request(self, name: str, content):
+        (self.channel.requests_folder / f'{name}.json').write_text(json.dumps(content))
+
+    def result(self, name: str) -> dict:
+        return json.loads((self.channel.results_folder / f'{name}.json').read_text())
+
+    def test_process_pending(self):
+        handler = mock.Mock(return_value={'address': 'AA:BB:CC:DD:EE:FF'})
+        self.channel.register('pair', handler)
+        self.channel.register('connect', mock.Mock(side_effect=RuntimeError('out of range')))
+
+        self.request('1', {'id': 'first', 'action': 'pair', 'identifier': 'aa:bb:cc:dd:ee:ff'})
+        self.request('2', {'action': 'connect', 'identifier': 'aa:bb:cc:dd:ee:ff'})
+        self.request('3', {'id': '../escape', 'action': 'unpair', 'identifier': 'aa:bb:cc:dd:ee:ff'})
+        (self.channel.requests_folder / '4.json').write_text('not json')
+
+        self.channel.process_pending()
+
+        handler.assert_called_once_with({'id': 'first', 'action': 'pair', 'identifier': 'aa:bb:cc:dd:ee:ff'})
+        result = self.result('first')
+        self.assertEqual(result['status'], STATUS_SUCCESS)
+        self.assertEqual(result['data'], {'address': 'AA:BB:CC:DD:EE:FF'})
+        self.assertEqual(result['progress'], 100)
+
+        self.assertEqual(self.result('2')['status'], STATUS_FAILED)
+        self.assertEqual(self.result('2')['message'], 'out of range')
+        self.assertEqual(self.result('3')['status'], STATUS_REJECTED)
+
+        # Requests are consumed whatever their outcome
+        self.assertEqual(list(self.channel.requests_folder.iterdir()), [])
+        self.assertEqual(self.channel.actions, ['connect', 'pair'])
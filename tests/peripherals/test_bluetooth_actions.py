@@ -0,0 +1,35 @@
+import subprocess
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.bluetooth import actions
+
+
+class TestBluetoothActions(TestCase):
+
+    @mock.patch('subprocess.run')
+    def test_bluetoothctl(self, mock_run):
+        mock_run.return_value = subprocess.CompletedProcess([], 0, stdout='Pairing successful\n', stderr='')
+        self.assertEqual(actions.bluetoothctl('pair', 'aa:bb:cc:dd:ee:ff'), 'Pairing successful')
+        self.assertEqual(mock_run.call_args[0][0][-2:], ['pair', 'AA:BB:CC:DD:EE:FF'])
+
+        mock_run.return_value = subprocess.CompletedProcess([], 0, stdout='Failed to connect: org.bluez.Error.Failed',
+                                                            stderr='')
+        with self.assertRaises(actions.BluetoothActionError):
+            actions.bluetoothctl('connect', 'aa:bb:cc:dd:ee:ff')
+
+        mock_run.side_effect = subprocess.TimeoutExpired('bluetoothctl', 35)
+        with self.assertRaises(actions.BluetoothActionError):
+            actions.bluetoothctl('connect', 'aa:bb:cc:dd:ee:ff')
+
+        mock_run.reset_mock()
+        with self.assertRaises(actions.BluetoothActionError):
+            actions.bluetoothctl('pair', 'aa:bb:cc:dd:ee:ff; reboot')
+        mock_run.assert_not_called()
+
+    def test_build_action_channel(self):
+        self.assertIsNone(actions.build_action_channel('/tmp', []))
+        self.assertEqual(actions.build_action_channel('/tmp', ['pair', 'trust']).actions, ['pair', 'trust'])
+        with self.assertRaises(ValueError):
+            actions.build_action_channel('/tmp', ['remove'])
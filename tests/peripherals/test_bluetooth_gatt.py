@@ -0,0 +1,60 @@
+import asyncio
+from types import SimpleNamespace
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.bluetooth import gatt
+
+
+def uuid16(alias: int) -> str:
+    return f'0000{alias:04x}{gatt.BLUETOOTH_BASE_UUID_SUFFIX}'
+
+
+ENVIRONMENTAL_SENSING = SimpleNamespace(uuid=uuid16(0x181a), characteristics=[
+    SimpleNamespace(uuid=uuid16(0x2a6e), properties=['read', 'notify']),
+    SimpleNamespace(uuid=uuid16(0x2a6f), properties=['read']),
+])
+VENDOR_SERVICE = SimpleNamespace(uuid='6e400001-b5a3-f393-e0a9-e50e24dcca9e', characteristics=[])
+
+
+@mock.patch.object(gatt, 'uuidstr_to_str', lambda uuid: uuid[4:8])
+class TestGattEnumeration(TestCase):
+
+    def test_short_uuid(self):
+        self.assertEqual(gatt.short_uuid(uuid16(0x180d).upper()), 0x180d)
+        self.assertIsNone(gatt.short_uuid(VENDOR_SERVICE.uuid))
+
+    def test_describe_services(self):
+        services, capabilities = gatt.describe_services([ENVIRONMENTAL_SENSING, VENDOR_SERVICE])
+        self.assertEqual(capabilities, ['environmental-sensing', 'temperature', 'humidity'])
+        self.assertEqual(len(services), 2)
+        self.assertEqual(services[0]['characteristics'][0]['properties'], ['read', 'notify'])
+
+    @mock.patch.object(gatt, 'BleakClient')
+    def test_enrich(self, mock_client):
+        client = mock_client.return_value.__aenter__.return_value
+        client.services = [ENVIRONMENTAL_SENSING]
+
+        enumerator = gatt.GattEnumerator(max_devices=1, retry_interval=3600)
+        devices = {'AA:BB:CC:DD:EE:01': {'classes': ['Environmental Sensing']},
+                   'AA:BB:CC:DD:EE:02': {'classes': []}}
+        asyncio.run(enumerator.enrich(devices))
+
+        # Limited to one connection per scan
+        mock_client.assert_called_once_with('AA:BB:CC:DD:EE:01', timeout=enumerator.timeout)
+        self.assertEqual(devices['AA:BB:CC:DD:EE:01']['classes'],
+                         ['Environmental Sensing', 'environmental-sensing', 'temperature', 'humidity'])
+        self.assertIn('gatt-services', devices['AA:BB:CC:DD:EE:01']['additional-assets'])
+        self.assertNotIn('additional-assets', devices['AA:BB:CC:DD:EE:02'])
+
+        # Known devices are not connected to again, and refusing ones are not retried before the interval
+        mock_client.reset_mock()
+        mock_client.return_value.__aenter__.side_effect = TimeoutError
+        asyncio.run(enumerator.enrich(devices))
+        mock_client.assert_called_once_with('AA:BB:CC:DD:EE:02', timeout=enumerator.timeout)
+
+        mock_client.reset_mock()
+        asyncio.run(enumerator.enrich(devices))
+        mock_client.assert_not_called()
+        self.assertIn('gatt-services', devices['AA:BB:CC:DD:EE:01']['additional-assets'])
@@ -0,0 +1,166 @@
+import io
+import json
+import tempfile
+from contextlib import redirect_stdout
+from unittest import TestCase
+
+from nuvlaedge.common.constant_files import FileConstants
+from nuvlaedge.peripherals import cli
+
+
+def webcam(**kwargs):
+    return {'identifier': '046d:0825', 'interface': 'USB', 'classes': ['video'], 'available': True,
+            'name': 'Webcam C270', 'serial-number': 'ABC123', 'device-path': '/dev/bus/usb/001/004',
+            'video-devices': ['/dev/video0', '/dev/video1'], **kwargs}
+
+
+class TestInspect(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.files = FileConstants(self.temp_dir.name)
+        folder = self.files.PERIPHERALS_FOLDER
+
+        usb = folder / 'usb'
+        (usb / 'buffer').mkdir(parents=True)
+        (usb / 'status.json').write_text(json.dumps({'status': 'RUNNING', 'time': '2024-05-01T12:00:00Z'}))
+        (usb / 'latest.json').write_text(json.dumps({'time': '2024-05-01T12:00:00Z',
+                                                     'peripherals': {'046d:0825': webcam()}}))
+
+        network = folder / 'network' / 'buffer'
+        network.mkdir(parents=True)
+        (network / '1714564800_network.json').write_text(json.dumps({'192.168.1.20': {
+            'identifier': '192.168.1.20', 'interface': 'SSDP', 'classes': ['printer'], 'available': True}}))
+        (network / '1714564800_network_1.json').write_text(json.dumps({'192.168.1.21': {
+            'identifier': '192.168.1.21', 'interface': 'SSDP', 'classes': ['camera'], 'available': True}}))
+        (network / 'network.lock').write_text('')
+
+        self.files.LOCAL_PERIPHERAL_DB.write_text(json.dumps({
+            '046d:0825': {**webcam(name='Entrance camera'), 'id': 'nuvlabox-peripheral/1'},
+            'F0:F5:BD:00:11:22': {'identifier': 'F0:F5:BD:00:11:22', 'interface': 'Bluetooth', 'classes': [],
+                                  'available': False, 'id': 'nuvlabox-peripheral/2'}}))
+        self.files.PENDING_PERIPHERAL_OPERATIONS.write_text(json.dumps([
+            {'operation': 'delete', 'identifier': 'F0:F5:BD:00:11:22', 'resource-id': 'nuvlabox-peripheral/2'}]))
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def test_inspect(self):
+        result = cli.inspect(self.files)
+
+        self.assertEqual(set(result['managers']), {'usb', 'network'})
+        self.assertEqual(result['managers']['usb']['status'], 'RUNNING')
+        self.assertEqual(result['managers']['usb']['peripherals'], ['046d:0825'])
+        # The latest report of the buffer is the one of the highest sequence
+        self.assertEqual(result['managers']['network']['buffered-reports'], 2)
+        self.assertEqual(result['managers']['network']['peripherals'], ['192.168.1.21'])
+
+        camera = result['peripherals']['046d:0825']
+        self.assertEqual(camera['name'], 'Webcam C270')
+        self.assertEqual(camera['managers'], ['usb'])
+        self.assertEqual(camera['device-nodes'], ['/dev/bus/usb/001/004', '/dev/video0', '/dev/video1'])
+        self.assertEqual(camera['state'], 'registered')
+        self.assertEqual(camera['nuvla-id'], 'nuvlabox-peripheral/1')
+
+        self.assertEqual(result['peripherals']['192.168.1.21']['state'], 'not registered')
+        self.assertEqual(result['peripherals']['F0:F5:BD:00:11:22']['state'], 'pending delete')
+        self.assertEqual(result['peripherals']['F0:F5:BD:00:11:22']['managers'], [])
+        self.assertEqual(result['pending-operations'], 1)
+
+        # Nothing is consumed
+        self.assertEqual(len(list((self.files.PERIPHERALS_FOLDER / 'network' / 'buffer').glob('*.json'))), 2)
+
+    def run_cli(self, *args) -> str:
+        output = io.StringIO()
+        with redirect_stdout(output):
+            self.assertEqual(cli.main(['inspect', '--root', self.temp_dir.name, *args]), 0)
+        return output.getvalue()
+
+    def test_table(self):
+        output = self.run_cli()
+        self.assertRegex(output, r'usb\s+RUNNING\s+1\s+0\s+2024-05-01T12:00:00Z')
+        self.assertRegex(output, r'046d:0825\s+usb\s+USB\s+Webcam C270\s+video\s+ABC123\s+'
+                                 r'/dev/bus/usb/001/004,/dev/video0,/dev/video1\s+registered')
+        self.assertIn('1 operations waiting for Nuvla to be reachable', output)
+
+    def test_tree(self):
+        output = self.run_cli('--tree', '--manager', 'usb')
+        self.assertEqual(output.splitlines(), [
+            'usb [RUNNING, 0 buffered reports]',
+            '└── 046d:0825 (Webcam C270) registered',
+            '    ├── interface: USB',
+            '    ├── classes: video',
+            '    ├── serial: ABC123',
+            '    ├── nodes: /dev/bus/usb/001/004, /dev/video0, /dev/video1',
+            '    └── nuvla: nuvlabox-peripheral/1',
+        ])
+
+    def test_json(self):
+        result = json.loads(self.run_cli('--json', '--manager', 'network'))
+        self.assertEqual(list(result['peripherals']), ['192.168.1.21'])
+
+    def test_empty(self):
+        output = io.StringIO()
+        with tempfile.TemporaryDirectory() as root, redirect_stdout(output):
+            cli.main(['inspect', '--root', root])
+        self.assertEqual(output.getvalue(), 'No peripheral manager running\n\nNo peripheral known\n')
+
+
+class TestBOM(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.files = FileConstants(self.temp_dir.name)
+        self.usb = self.files.PERIPHERALS_FOLDER / 'usb'
+        self.usb.mkdir(parents=True)
+        self.document = json.dumps({'bomFormat': 'CycloneDX', 'specVersion': '1.5', 'components': []}).encode()
+        (self.usb / cli.BOM_FILE).write_bytes(self.document)
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def run_cli(self, *args) -> tuple[int, str]:
+        output = io.StringIO()
+        with redirect_stdout(output):
+            code = cli.main(['bom', '--root', self.temp_dir.name, *args])
+        return code, output.getvalue()
+
+    def sign(self) -> bytes:
+        try:
+            from cryptography.hazmat.primitives.asymmetric.ed25519 import Ed25519PrivateKey
+            from cryptography.hazmat.primitives.serialization import Encoding, PublicFormat
+        except ImportError:
+            self.skipTest('cryptography is not installed')
+        key = Ed25519PrivateKey.generate()
+        (self.usb / cli.BOM_PUBLIC_KEY_FILE).write_bytes(
+            key.public_key().public_bytes(Encoding.PEM, PublicFormat.SubjectPublicKeyInfo))
+        signature = key.sign(self.document)
+        (self.usb / (cli.BOM_FILE + '.sig')).write_bytes(signature)
+        return signature
+
+    def test_unsigned(self):
+        self.assertEqual(cli.verify_bom(self.document, None, b'key'), 'unsigned')
+        code, output = self.run_cli()
+        self.assertEqual(code, 0)
+        self.assertEqual(json.loads(output)['bomFormat'], 'CycloneDX')
+
+        with tempfile.TemporaryDirectory() as root:
+            self.assertEqual(cli.main(['bom', '--root', root]), 1)
+
+    def test_verify(self):
+        signature = self.sign()
+        public_key = (self.usb / cli.BOM_PUBLIC_KEY_FILE).read_bytes()
+        self.assertEqual(cli.verify_bom(self.document, signature, public_key), 'valid')
+        self.assertEqual(cli.verify_bom(self.document + b' ', signature, public_key), 'invalid')
+
+        (self.usb / cli.BOM_FILE).write_bytes(self.document + b'\n')
+        self.assertEqual(self.run_cli()[0], 1)
+
+    def test_export(self):
+        signature = self.sign()
+        with tempfile.TemporaryDirectory() as exported:
+            output = f'{exported}/edge-01.cdx.json'
+            self.assertEqual(self.run_cli('--output', output), (0, ''))
+            with open(output, 'rb') as document, open(output + '.sig', 'rb') as exported_signature:
+                self.assertEqual(document.read(), self.document)
+                self.assertEqual(exported_signature.read(), signature)
@@ -0,0 +1,101 @@
+import json
+import os
+import tempfile
+from unittest import TestCase
+
+from nuvlaedge.peripherals import config_file
+
+
+class TestConfigFile(TestCase):
+
+    def write(self, content: str) -> str:
+        f = tempfile.NamedTemporaryFile('w', suffix='.yaml', delete=False)
+        f.write(content)
+        f.close()
+        self.addCleanup(os.remove, f.name)
+        return f.name
+
+    def test_load_section(self):
+        path = self.write('''# Peripheral managers
+usb:
+  scan-interval: 10s
+network:
+  subnet-sweep: true   # also sweep the local subnets
+  sweep-interval: 300
+  probe-exclude: [10.0.0.5, "10.0.1.0/24"]
+  service-ports:
+    - 554
+    - 8080:http
+''')
+        environ = {'NETWORK_SWEEP_INTERVAL': '60'}
+        exported = config_file.load_section('network', path, environ)
+
+        self.assertEqual(exported, {
+            'NETWORK_SUBNET_SWEEP': 'true',
+            'NETWORK_PROBE_EXCLUDE': '10.0.0.5,10.0.1.0/24',
+            'NETWORK_SERVICE_PORTS': '554,8080:http',
+        })
+        # The environment overrides the file
+        self.assertEqual(environ['NETWORK_SWEEP_INTERVAL'], '60')
+
+    def test_missing_file(self):
+        environ = {}
+        self.assertEqual(config_file.load_section('bluetooth', '/nonexistent/peripherals.yaml', environ), {})
+        self.assertEqual(environ, {})
+
+    def test_invalid_settings(self):
+        path = self.write('''bluetooth:
+  gatt-enumaration: true
+  gatt-max-devices: many
+  actions: pair
+wifi:
+  scan: true
+''')
+        with self.assertRaises(config_file.ConfigFileError) as context:
+            config_file.load_section('bluetooth', path, {})
+
+        message = str(context.exception)
+        self.assertIn(f'{path}:2: unknown setting bluetooth.gatt-enumaration, did you mean gatt-enumeration?', message)
+        self.assertIn(f'{path}:3: bluetooth.gatt-max-devices must be an integer, got "many"', message)
+        self.assertIn(f'{path}:4: bluetooth.actions must be a list, such as [pair], got "pair"', message)
+        self.assertIn(f'{path}:5: unknown section wifi', message)
+
+    def test_parse_errors(self):
+        for content, expected in [
+            ('gatt-enumeration: true\n', 'test.yaml:1: expected a section name'),
+            ('network:\n  sweep:\n    interval: 300\n', 'test.yaml:3: settings of a section must all have the same'),
+            ('network:\n\tsubnet-sweep: true\n', 'test.yaml:2: indentation must use spaces'),
+            ('network:\n  oui-file: "/usr/share\n', 'test.yaml:2: unterminated string'),
+            ('network:\n  subnet-sweep: true\n    - 1\n', 'test.yaml:3: list item outside of a list'),
+        ]:
+            with self.assertRaises(config_file.ConfigFileError) as context:
+                config_file.parse(content, 'test.yaml')
+            self.assertTrue(str(context.exception).startswith(expected), str(context.exception))
+
+    def test_schemas_match_the_managers(self):
+        # Every Python manager section is validated against a schema
+        self.assertEqual(set(config_file.SCHEMAS), set(config_file.SECTIONS) - {'usb'})
+
+    def test_write_remote_config(self):
+        folder = tempfile.TemporaryDirectory()
+        self.addCleanup(folder.cleanup)
+        path = os.path.join(folder.name, '.peripherals', 'remote_config.json')
+
+        # Nothing to write until Nuvla configures the managers
+        self.assertFalse(config_file.write_remote_config(None, path))
+        self.assertFalse(os.path.exists(path))
+
+        configuration = {'usb': {'scan-interval': '10s', 'sinks': ['file', 'socket']}}
+        self.assertTrue(config_file.write_remote_config(configuration, path))
+        with open(path) as f:
+            self.assertEqual(json.load(f), configuration)
+        # The file is only replaced when the configuration changes
+        self.assertFalse(config_file.write_remote_config(dict(configuration), path))
+
+        # Invalid configurations leave the current one in place
+        self.assertFalse(config_file.write_remote_config({'usb': '10s'}, path))
+        self.assertFalse(config_file.write_remote_config(['usb'], path))
+        self.assertTrue(os.path.exists(path))
+
+        self.assertTrue(config_file.write_remote_config({}, path))
+        self.assertFalse(os.path.exists(path))
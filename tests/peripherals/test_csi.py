@@ -0,0 +1,128 @@
+import tempfile
+from pathlib import Path
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals import csi
+
+RPICAM_LIST = """Available cameras
+-----------------
+0 : imx708_wide [4608x2592 10-bit RGGB] (/base/axi/pcie@120000/rp1/i2c@88000/imx708@1a)
+    Modes: 'SRGGB10_CSI2P' : 1536x864 [120.13 fps - (768, 432)/3072x1728 crop]
+                             2304x1296 [56.03 fps - (0, 0)/4608x2592 crop]
+                             4608x2592 [14.35 fps - (0, 0)/4608x2592 crop]
+
+1 : imx219 [3280x2464 10-bit RGGB] (/base/axi/pcie@120000/rp1/i2c@80000/imx219@10)
+    Modes: 'SRGGB10_CSI2P' : 640x480 [206.65 fps - (1000, 752)/1280x960 crop]
+                             1920x1080 [47.57 fps - (680, 692)/1920x1080 crop]
+           'SRGGB8' : 640x480 [206.65 fps - (1000, 752)/1280x960 crop]
+                      3280x2464 [21.19 fps - (0, 0)/3280x2464 crop]
+"""
+
+V4L2_FORMATS = """ioctl: VIDIOC_ENUM_FMT
+	Type: Video Capture
+
+	[0]: 'RG10' (10-bit Bayer RGRG/GBGB)
+		Size: Discrete 3264x2464
+			Interval: Discrete 0.048s (21.000 fps)
+		Size: Discrete 1920x1080
+			Interval: Discrete 0.033s (30.000 fps)
+"""
+
+
+class TestCSI(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.dir = Path(self.temp_dir.name)
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def test_parse_libcamera_list(self):
+        cameras = csi.parse_libcamera_list(RPICAM_LIST)
+        self.assertEqual(len(cameras), 2)
+        self.assertEqual(cameras[0]['sensor'], 'imx708_wide')
+        self.assertEqual(cameras[0]['resolutions'], ['1536x864', '2304x1296', '4608x2592'])
+        self.assertEqual(cameras[1]['index'], 1)
+        self.assertEqual(cameras[1]['resolutions'], ['640x480', '1920x1080', '3280x2464'])
+        self.assertEqual(cameras[1]['frame-rates']['640x480'], 206.65)
+        self.assertEqual(cameras[1]['node'], '/base/axi/pcie@120000/rp1/i2c@80000/imx219@10')
+
+        self.assertEqual(csi.parse_libcamera_list('No cameras available!\n'), [])
+
+    def test_parse_cam_list(self):
+        cameras = csi.parse_cam_list("Available cameras:\n1: 'ov5647' (/base/soc/i2c0mux/i2c@1/ov5647@36)\n")
+        self.assertEqual(cameras, [{'index': 0, 'sensor': 'ov5647', 'resolutions': [],
+                                    'node': '/base/soc/i2c0mux/i2c@1/ov5647@36', 'frame-rates': {}}])
+
+    def test_parse_vcgencmd_camera(self):
+        self.assertEqual(csi.parse_vcgencmd_camera('supported=1 detected=2, libcamera interfaces=0\n'), 2)
+        self.assertEqual(csi.parse_vcgencmd_camera('error=1 error_msg="Command not registered"'), 0)
+
+    @mock.patch('nuvlaedge.peripherals.csi.run_command')
+    def test_raspberry_pi_cameras(self, mock_run):
+        # rpicam-hello is missing, libcamera-hello answers
+        mock_run.side_effect = lambda command: RPICAM_LIST if command[0] == 'libcamera-hello' else None
+        self.assertEqual([c['sensor'] for c in csi.raspberry_pi_cameras()], ['imx708_wide', 'imx219'])
+
+        mock_run.side_effect = lambda command: 'supported=1 detected=1' if command[0] == 'vcgencmd' else None
+        self.assertEqual(csi.raspberry_pi_cameras(), [{'index': 0, 'sensor': None, 'resolutions': [],
+                                                       'node': None, 'frame-rates': {}}])
+
+        mock_run.side_effect = lambda command: None
+        self.assertEqual(csi.raspberry_pi_cameras(), [])
+
+    @mock.patch('nuvlaedge.peripherals.csi.run_command')
+    def test_jetson_cameras(self, mock_run):
+        for video, name in (('video0', 'vi-output, imx219 9-0010'), ('video1', 'uvcvideo'),
+                            ('video10', 'vi-output, imx477 10-001a')):
+            (self.dir / video).mkdir()
+            (self.dir / video / 'name').write_text(name + '\n')
+        mock_run.side_effect = lambda command: V4L2_FORMATS if command[-1] == '/dev/video0' else None
+
+        cameras = csi.jetson_cameras(self.dir)
+        self.assertEqual([(c['sensor'], c['device-path']) for c in cameras],
+                         [('imx219', '/dev/video0'), ('imx477', '/dev/video10')])
+        self.assertEqual(cameras[0]['resolutions'], ['1920x1080', '3264x2464'])
+        self.assertEqual(cameras[0]['node'], '9-0010')
+        self.assertEqual(cameras[1]['resolutions'], [])
+
+        self.assertEqual(csi.jetson_cameras(self.dir / 'missing'), [])
+
+    def test_format_camera(self):
+        camera = csi.parse_libcamera_list(RPICAM_LIST)[0]
+        peripheral = csi.format_camera(camera, 'Raspberry Pi 5 Model B Rev 1.0')
+        self.assertEqual(peripheral['identifier'], 'csi-0-imx708_wide')
+        self.assertEqual(peripheral['interface'], 'CSI')
+        self.assertEqual(peripheral['name'], 'IMX708 CSI camera')
+        self.assertEqual(peripheral['vendor'], 'Sony')
+        self.assertEqual(peripheral['description'],
+                         'CSI camera 0 of the Raspberry Pi 5 Model B Rev 1.0, sensor imx708_wide, up to 4608x2592')
+        self.assertEqual(peripheral['additional-assets']['csi']['resolutions'], ['1536x864', '2304x1296', '4608x2592'])
+        self.assertNotIn('device-path', peripheral)
+
+        unknown = csi.format_camera({'index': 1, 'sensor': None, 'resolutions': [], 'node': None, 'frame-rates': {}},
+                                    'Raspberry Pi 3 Model B Rev 1.2')
+        self.assertEqual(unknown['identifier'], 'csi-1')
+        self.assertEqual(unknown['name'], 'CSI camera')
+        self.assertNotIn('vendor', unknown)
+
+    @mock.patch('nuvlaedge.peripherals.csi.jetson_cameras')
+    @mock.patch('nuvlaedge.peripherals.csi.raspberry_pi_cameras')
+    def test_flow(self, mock_pi, mock_jetson):
+        model = self.dir / 'model'
+        mock_pi.return_value = csi.parse_libcamera_list(RPICAM_LIST)
+        mock_jetson.return_value = []
+
+        model.write_text('Raspberry Pi 4 Model B Rev 1.4\0')
+        self.assertEqual(list(csi.flow(str(model))), ['csi-0-imx708_wide', 'csi-1-imx219'])
+
+        model.write_text('NVIDIA Jetson Orin Nano Developer Kit\0')
+        self.assertEqual(csi.flow(str(model), str(self.dir)), {})
+        mock_jetson.assert_called_once_with(str(self.dir))
+
+        model.write_text('Generic x86 board')
+        self.assertEqual(csi.flow(str(model)), {})
+        self.assertEqual(csi.flow(str(self.dir / 'missing')), {})
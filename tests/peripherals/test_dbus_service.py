@@ -0,0 +1,183 @@
+import json
+import socket
+import tempfile
+import threading
+from unittest import TestCase
+
+from nuvlaedge.common.constant_files import FileConstants
+from nuvlaedge.peripherals import dbus_service
+from nuvlaedge.peripherals.dbus_service import BusConnection, Message, PeripheralsService
+
+
+def call(member: str, signature: str = '', *body, interface: str = dbus_service.INTERFACE,
+         path: str = dbus_service.OBJECT_PATH) -> Message:
+    fields = {dbus_service.PATH: path, dbus_service.MEMBER: member, dbus_service.SENDER: ':1.42'}
+    if interface:
+        fields[dbus_service.FIELD_INTERFACE] = interface
+    return Message(dbus_service.METHOD_CALL, fields, signature, body, serial=7)
+
+
+class TestMarshalling(TestCase):
+
+    def test_round_trip(self):
+        message = Message(dbus_service.METHOD_RETURN, {dbus_service.REPLY_SERIAL: 3, dbus_service.DESTINATION: ':1.5'},
+                          'sa{sv}asub', ['x', {'Count': ('u', 2), 'Managers': ('as', ['usb', 'network'])},
+                                         ['a', 'bc'], 7, True], serial=12)
+        data = message.encode()
+        self.assertEqual(len(data) % 4, 0)
+
+        decoded, length = Message.decode(data + b'next')
+        self.assertEqual(length, len(data))
+        self.assertEqual(decoded.type, dbus_service.METHOD_RETURN)
+        self.assertEqual(decoded.serial, 12)
+        self.assertEqual(decoded.reply_serial, 3)
+        self.assertEqual(decoded.signature, 'sa{sv}asub')
+        self.assertEqual(decoded.body, ['x', {'Count': ('u', 2), 'Managers': ('as', ['usb', 'network'])},
+                                        ['a', 'bc'], 7, True])
+
+        self.assertEqual(Message.decode(data[:-1]), (None, 0))
+
+    def test_signature(self):
+        self.assertEqual(dbus_service.split_signature('sa{sv}(ii)aas'), ['s', 'a{sv}', '(ii)', 'aas'])
+        with self.assertRaises(ValueError):
+            dbus_service.split_signature('a{sv')
+        with self.assertRaises(ValueError):
+            dbus_service.split_signature('z')
+
+    def test_socket_path(self):
+        self.assertEqual(dbus_service.socket_path('unix:path=/run/dbus/system_bus_socket'),
+                         '/run/dbus/system_bus_socket')
+        self.assertEqual(dbus_service.socket_path('tcp:host=x,port=1;unix:abstract=/tmp/dbus-x%2Cy,guid=1'),
+                         '\0/tmp/dbus-x,y')
+        with self.assertRaises(ValueError):
+            dbus_service.socket_path('tcp:host=localhost,port=1234')
+
+
+class TestPeripheralsService(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.files = FileConstants(self.temp_dir.name)
+        usb = self.files.PERIPHERALS_FOLDER / 'usb'
+        usb.mkdir(parents=True)
+        self.snapshot = usb / 'latest.json'
+        self.write_snapshot({'046d:0825': {'identifier': '046d:0825', 'name': 'Webcam C270', 'available': True,
+                                           'video-devices': ['/dev/video0', '/dev/video1'],
+                                           'additional-assets': {'device-info': {'category': 'camera'}}}})
+        self.service = PeripheralsService(self.files)
+
+    def write_snapshot(self, peripherals: dict):
+        self.snapshot.write_text(json.dumps({'time': '2024-05-01T12:00:00Z', 'peripherals': peripherals}))
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def test_calls(self):
+        self.assertEqual(self.service.update(), [])
+
+        reply = self.service.handle(call('List'))
+        self.assertEqual((reply.type, reply.reply_serial, reply.fields[dbus_service.DESTINATION]),
+                         (dbus_service.METHOD_RETURN, 7, ':1.42'))
+        self.assertEqual(reply.body, [['046d:0825']])
+
+        attributes = self.service.handle(call('Get', 's', '046d:0825')).body[0]
+        self.assertEqual(attributes['name'], 'Webcam C270')
+        self.assertEqual(attributes['available'], 'true')
+        self.assertEqual(attributes['video-devices'], '/dev/video0\n/dev/video1')
+        self.assertEqual(json.loads(attributes['additional-assets']), {'device-info': {'category': 'camera'}})
+        self.assertEqual(attributes['state'], 'not registered')
+        self.assertEqual(attributes['managers'], 'usb')
+
+        reply = self.service.handle(call('Get', 's', 'unknown'))
+        self.assertEqual((reply.type, reply.error_name), (dbus_service.ERROR, dbus_service.UNKNOWN_PERIPHERAL))
+
+        self.assertIn('usb', json.loads(self.service.handle(call('Inspect', interface=None)).body[0])['managers'])
+
+    def test_properties(self):
+        self.service.update()
+        reply = self.service.handle(call('GetAll', 's', dbus_service.INTERFACE, interface=dbus_service.PROPERTIES))
+        self.assertEqual(reply.body, [{'Count': ('u', 1), 'Managers': ('as', ['usb'])}])
+        reply = self.service.handle(call('Get', 'ss', dbus_service.INTERFACE, 'Count',
+                                         interface=dbus_service.PROPERTIES))
+        self.assertEqual(reply.body, [('u', 1)])
+        reply = self.service.handle(call('Set', 'ssv', dbus_service.INTERFACE, 'Count', ('u', 2),
+                                         interface=dbus_service.PROPERTIES))
+        self.assertEqual(reply.error_name, 'org.freedesktop.DBus.Error.PropertyReadOnly')
+
+    def test_introspection_and_errors(self):
+        reply = self.service.handle(call('Introspect', interface=dbus_service.INTROSPECTABLE))
+        self.assertIn('<signal name="PeripheralAdded">', reply.body[0])
+        reply = self.service.handle(call('Introspect', interface=dbus_service.INTROSPECTABLE, path='/org'))
+        self.assertEqual(reply.body, ['<node><node name="nuvlaedge"/></node>'])
+
+        self.assertEqual(self.service.handle(call('List', path='/other')).error_name,
+                         'org.freedesktop.DBus.Error.UnknownObject')
+        self.assertEqual(self.service.handle(call('Remove', 's', 'x')).error_name,
+                         'org.freedesktop.DBus.Error.UnknownMethod')
+
+        no_reply = call('List')
+        no_reply.flags = dbus_service.NO_REPLY_EXPECTED
+        self.assertIsNone(self.service.handle(no_reply))
+
+    def test_signals(self):
+        self.service.update()
+        self.write_snapshot({'046d:0825': {'identifier': '046d:0825', 'name': 'Webcam C270', 'available': False},
+                             '0781:5581': {'identifier': '0781:5581', 'name': 'Ultra'}})
+        self.files.LOCAL_PERIPHERAL_DB.write_text(json.dumps({'046d:0825': {'id': 'nuvlabox-peripheral/1'}}))
+        signals = self.service.update()
+        self.assertEqual([(s.member, s.body) for s in signals],
+                         [('PeripheralChanged', ['046d:0825', 'registered']), ('PeripheralAdded', ['0781:5581'])])
+        self.assertEqual(signals[0].path, dbus_service.OBJECT_PATH)
+
+        self.write_snapshot({})
+        self.files.LOCAL_PERIPHERAL_DB.write_text('{}')
+        self.assertEqual([(s.member, s.body) for s in self.service.update()],
+                         [('PeripheralRemoved', ['046d:0825']), ('PeripheralRemoved', ['0781:5581'])])
+
+
+class TestBusConnection(TestCase):
+
+    def test_authenticate_and_call(self):
+        bus, client = socket.socketpair()
+        client.settimeout(2)
+        received = []
+
+        def fake_bus():
+            data = b''
+            while b'BEGIN\r\n' not in data:
+                chunk = bus.recv(4096)
+                data += chunk
+                if b'AUTH EXTERNAL' in chunk:
+                    bus.sendall(b'OK 1234deadbeef\r\n')
+            received.append(data)
+            buffer = data.split(b'BEGIN\r\n', 1)[1]
+            while True:
+                message, length = Message.decode(buffer)
+                if message:
+                    break
+                buffer += bus.recv(4096)
+            received.append(message)
+            # A signal sent before the reply is kept for later
+            bus.sendall(Message(dbus_service.SIGNAL, {dbus_service.PATH: dbus_service.DBUS_PATH,
+                                                      dbus_service.MEMBER: 'NameAcquired'}, 's', [':1.9']).encode())
+            bus.sendall(Message(dbus_service.METHOD_RETURN, {dbus_service.REPLY_SERIAL: message.serial}, 's',
+                                [':1.9'], serial=1).encode())
+
+        thread = threading.Thread(target=fake_bus, daemon=True)
+        thread.start()
+        connection = BusConnection(client)
+        connection.authenticate()
+        reply = connection.call(dbus_service.DBUS_NAME, dbus_service.DBUS_PATH, dbus_service.DBUS_NAME, 'Hello')
+        thread.join(2)
+
+        self.assertTrue(received[0].startswith(b'\0AUTH EXTERNAL '))
+        self.assertEqual((received[1].member, received[1].fields[dbus_service.DESTINATION]),
+                         ('Hello', dbus_service.DBUS_NAME))
+        self.assertEqual(reply.body, [':1.9'])
+        self.assertEqual(connection.receive(0).member, 'NameAcquired')
+        self.assertIsNone(connection.receive(0))
+
+        bus.close()
+        with self.assertRaises(ConnectionError):
+            connection.receive(1)
+        connection.close()
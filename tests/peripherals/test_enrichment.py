@@ -0,0 +1,98 @@
+import json
+import tempfile
+from pathlib import Path
+from unittest import TestCase
+
+import mock
+import requests
+
+from nuvlaedge.peripherals import enrichment
+from nuvlaedge.peripherals.enrichment import PeripheralEnricher, lookup_key
+
+
+def response(status=200, body=None):
+    mocked = mock.Mock(status_code=status)
+    mocked.json.return_value = body
+    if status >= 400:
+        mocked.raise_for_status.side_effect = requests.HTTPError(f'{status} error')
+    return mocked
+
+
+def webcam():
+    return {'identifier': '046d:0825', 'interface': 'USB', 'classes': ['video'], 'available': True,
+            'vendor': 'Logitech, Inc.', 'product': 'Webcam C270', 'serial-number': 'ABC123'}
+
+
+class TestPeripheralEnrichment(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.cache_file = Path(self.temp_dir.name) / 'enrichment_cache.json'
+        self.enricher = PeripheralEnricher(self.cache_file, url='https://devices.example.com/api')
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def test_lookup_key(self):
+        self.assertEqual(lookup_key(webcam()), 'usb/046d/0825')
+        self.assertEqual(lookup_key({'identifier': 'F0:F5:BD:00:11:22', 'interface': 'Bluetooth',
+                                     'vendor': 'Nordic Semiconductor', 'product': 'Thingy:52'}),
+                         'bluetooth/Nordic%20Semiconductor/Thingy%3A52')
+        self.assertIsNone(lookup_key({'identifier': '192.168.1.20', 'interface': 'SSDP', 'vendor': 'Axis'}))
+
+    @mock.patch('nuvlaedge.peripherals.enrichment.requests.get')
+    def test_enrich(self, get):
+        get.return_value = response(body={'icon': 'https://devices.example.com/icons/c270.svg', 'category': 'camera',
+                                          'datasheet': '', 'price': 29})
+        peripherals = self.enricher.enrich({'046d:0825': webcam()})
+
+        get.assert_called_once_with('https://devices.example.com/api/usb/046d/0825', timeout=5.0,
+                                    headers={'Accept': 'application/json'})
+        self.assertEqual(peripherals['046d:0825']['additional-assets'],
+                         {'device-info': {'icon': 'https://devices.example.com/icons/c270.svg', 'category': 'camera'}})
+        cache = json.loads(self.cache_file.read_text())
+        self.assertEqual(cache['usb/046d/0825']['metadata']['category'], 'camera')
+
+        # Served from the cache, also after a restart
+        enricher = PeripheralEnricher(self.cache_file, url='https://devices.example.com/api')
+        peripherals = enricher.enrich({'046d:0825': {**webcam(), 'additional-assets': {'uvc': {}}}})
+        get.assert_called_once()
+        self.assertEqual(set(peripherals['046d:0825']['additional-assets']), {'uvc', 'device-info'})
+
+    @mock.patch('nuvlaedge.peripherals.enrichment.requests.get')
+    def test_unknown_model(self, get):
+        get.return_value = response(status=404)
+        peripherals = self.enricher.enrich({'046d:0825': webcam()})
+        self.assertNotIn('additional-assets', peripherals['046d:0825'])
+
+        get.reset_mock()
+        self.enricher.enrich({'046d:0825': webcam()})
+        get.assert_not_called()
+
+        # Looked up again the next day
+        with mock.patch('nuvlaedge.peripherals.enrichment.time.time', return_value=self.enricher.cache[
+                'usb/046d/0825']['fetched'] + enrichment.UNKNOWN_TTL + 1):
+            self.enricher.enrich({'046d:0825': webcam()})
+        get.assert_called_once()
+
+    @mock.patch('nuvlaedge.peripherals.enrichment.requests.get')
+    def test_offline(self, get):
+        self.cache_file.write_text(json.dumps({'usb/046d/0825': {'metadata': {'category': 'camera'}, 'fetched': 0}}))
+        get.side_effect = requests.ConnectionError('no route to host')
+
+        peripherals = self.enricher.enrich({'046d:0825': webcam(), '0c2e:0b61': {**webcam(), 'identifier': '0c2e:0b61'}})
+        # The expired entry is still used, and the service is not queried again until the backoff ends
+        self.assertEqual(peripherals['046d:0825']['additional-assets']['device-info'], {'category': 'camera'})
+        self.assertNotIn('additional-assets', peripherals['0c2e:0b61'])
+        get.assert_called_once()
+        self.assertEqual(json.loads(self.cache_file.read_text())['usb/046d/0825']['fetched'], 0)
+
+    @mock.patch('nuvlaedge.peripherals.enrichment.requests.get')
+    def test_max_lookups(self, get):
+        get.return_value = response(status=404)
+        peripherals = {f'1234:{i:04x}': {**webcam(), 'identifier': f'1234:{i:04x}'}
+                       for i in range(enrichment.MAX_LOOKUPS + 5)}
+        self.enricher.enrich(peripherals)
+        self.assertEqual(get.call_count, enrichment.MAX_LOOKUPS)
+        self.enricher.enrich(peripherals)
+        self.assertEqual(get.call_count, enrichment.MAX_LOOKUPS + 5)
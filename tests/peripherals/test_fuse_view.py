@@ -0,0 +1,88 @@
+import errno
+import json
+import os
+import stat
+import tempfile
+from unittest import TestCase
+
+from nuvlaedge.common.constant_files import FileConstants
+from nuvlaedge.peripherals import fuse_view
+from nuvlaedge.peripherals.fuse_view import PeripheralsView
+
+
+class TestFuseView(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.files = FileConstants(self.temp_dir.name)
+        usb = self.files.PERIPHERALS_FOLDER / 'usb'
+        usb.mkdir(parents=True)
+        self.snapshot = usb / 'latest.json'
+        self.write_snapshot(name='Webcam C270')
+        self.files.LOCAL_PERIPHERAL_DB.write_text(json.dumps({
+            '046d:0825': {'identifier': '046d:0825', 'id': 'nuvlabox-peripheral/1'}}))
+
+        self.view = PeripheralsView(self.files, refresh=0)
+
+    def write_snapshot(self, **kwargs):
+        self.snapshot.write_text(json.dumps({'time': '2024-05-01T12:00:00Z', 'peripherals': {
+            '046d:0825': {'identifier': '046d:0825', 'interface': 'USB', 'classes': ['video'], 'available': True,
+                          'video-devices': ['/dev/video0', '/dev/video1'],
+                          'additional-assets': {'device-info': {'category': 'camera'}}, **kwargs},
+            'opc.tcp://plc/': {'identifier': 'opc.tcp://plc/', 'interface': 'OPC UA', 'available': False}}}))
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def read(self, path: str) -> str:
+        self.view.open(path, os.O_RDONLY)
+        return self.view.read(path, 4096, 0).decode()
+
+    def test_tree(self):
+        self.assertEqual(self.view.readdir('/'), ['.', '..', '046d:0825', 'opc.tcp:%2F%2Fplc%2F'])
+        self.assertEqual(self.view.readdir('/046d:0825'), ['.', '..', 'attributes', 'managers', 'state'])
+
+        self.assertEqual(self.read('/046d:0825/attributes/name'), 'Webcam C270\n')
+        self.assertEqual(self.read('/046d:0825/attributes/available'), 'true\n')
+        self.assertEqual(self.read('/046d:0825/attributes/video-devices'), '/dev/video0\n/dev/video1\n')
+        self.assertEqual(self.read('/046d:0825/attributes/id'), 'nuvlabox-peripheral/1\n')
+        self.assertEqual(self.read('/046d:0825/attributes/additional-assets/device-info/category'), 'camera\n')
+        self.assertEqual(self.read('/046d:0825/state'), 'registered\n')
+        self.assertEqual(self.read('/046d:0825/managers'), 'usb\n')
+        self.assertEqual(self.read('/opc.tcp:%2F%2Fplc%2F/attributes/available'), 'false\n')
+
+        self.assertEqual(self.view.read('/046d:0825/attributes/name', 4, 7), b'C270')
+
+    def test_attributes(self):
+        attributes = self.view.getattr('/046d:0825/attributes/name')
+        self.assertTrue(stat.S_ISREG(attributes['st_mode']))
+        self.assertEqual(attributes['st_mode'] & 0o777, 0o444)
+        self.assertEqual(attributes['st_size'], len('Webcam C270\n'))
+        self.assertTrue(stat.S_ISDIR(self.view.getattr('/046d:0825/attributes')['st_mode']))
+
+    def test_errors(self):
+        for operation, args, expected in [('getattr', ('/unknown',), errno.ENOENT),
+                                          ('getattr', ('/046d:0825/state/more',), errno.ENOENT),
+                                          ('readdir', ('/046d:0825/state', None), errno.ENOTDIR),
+                                          ('open', ('/046d:0825/state', os.O_WRONLY), errno.EROFS),
+                                          ('open', ('/046d:0825', os.O_RDONLY), errno.EISDIR),
+                                          ('unlink', ('/046d:0825/state',), errno.EROFS),
+                                          ('readlink', ('/046d:0825/state',), errno.ENOSYS)]:
+            with self.assertRaises(OSError) as context:
+                self.view(operation, *args)
+            self.assertEqual(context.exception.errno, expected, operation)
+
+    def test_refresh(self):
+        self.assertEqual(self.view('read', '/046d:0825/attributes/name', 100, 0, None), b'Webcam C270\n')
+        self.write_snapshot(name='Entrance camera')
+        self.assertEqual(self.read('/046d:0825/attributes/name'), 'Entrance camera\n')
+
+        # The view is kept between refreshes
+        self.view.refresh = 3600
+        self.write_snapshot(name='Parking camera')
+        self.assertEqual(self.read('/046d:0825/attributes/name'), 'Entrance camera\n')
+
+    def test_encoding(self):
+        self.assertEqual(fuse_view.encode_value(None), b'')
+        self.assertEqual(fuse_view.encode_value(3), b'3\n')
+        self.assertEqual(fuse_view.to_node([{'a': 1}, {'b': False}]), {'0': {'a': b'1\n'}, '1': {'b': b'false\n'}})
@@ -0,0 +1,82 @@
+import json
+import tempfile
+from pathlib import Path
+from unittest import TestCase
+
+from nuvlaedge.peripherals.gpu import jetson
+
+
+class TestJetson(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.dir = Path(self.temp_dir.name)
+        self.device_tree = self.dir / 'device-tree'
+        self.host = self.dir / 'host'
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def write(self, path: Path, content: str):
+        path.parent.mkdir(parents=True, exist_ok=True)
+        path.write_text(content)
+
+    def fake_orin_nano(self):
+        self.write(self.device_tree / 'compatible', 'nvidia,p3768-0000+p3767-0005\0nvidia,p3767-0005\0nvidia,tegra234\0')
+        self.write(self.device_tree / 'model', 'NVIDIA Jetson Orin Nano Developer Kit\0')
+        self.write(self.device_tree / 'serial-number', '1421022012345\0')
+        # The Orin Nano has two decoders in its device tree, and its encoder disabled
+        self.write(self.device_tree / 'bus@0' / 'nvdec@15480000' / 'status', 'okay\0')
+        (self.device_tree / 'bus@0' / 'nvdec1@15540000').mkdir(parents=True)
+        self.write(self.device_tree / 'bus@0' / 'nvenc@154c0000' / 'status', 'disabled\0')
+        self.write(self.host / 'etc' / 'nv_tegra_release',
+                   '# R35 (release), REVISION: 4.1, GCID: 33958178, BOARD: t186ref, EABI: aarch64, DATE: Tue Aug  1\n')
+
+    def test_jetson_module(self):
+        self.fake_orin_nano()
+        self.write(self.host / 'usr' / 'local' / 'cuda' / 'version.json', json.dumps({'cuda': {'version': '11.4.315'}}))
+
+        module = jetson.jetson_module(self.device_tree, self.host)
+        self.assertEqual(module, {
+            'model': 'NVIDIA Jetson Orin Nano Developer Kit',
+            'serial-number': '1421022012345',
+            'part-number': 'p3768-0000+p3767-0005',
+            'soc': 'tegra234',
+            'family': 'Orin',
+            'l4t': '35.4.1',
+            'jetpack': '5.1.2',
+            'cuda': '11.4.315',
+            'compute-capability': '8.7',
+            'nvenc': 0,
+            'nvdec': 2
+        })
+
+    def test_jetson_module_versions(self):
+        self.fake_orin_nano()
+        # The nvidia-jetpack package prevails over the L4T release, CUDA 10 has a version.txt
+        self.write(self.host / 'var' / 'lib' / 'dpkg' / 'status',
+                   'Package: nvidia-l4t-core\nVersion: 35.4.1-20230801124926\n\n'
+                   'Package: nvidia-jetpack\nStatus: install ok installed\nVersion: 5.1.3-b29\n')
+        self.write(self.host / 'usr' / 'local' / 'cuda' / 'version.txt', 'CUDA Version 10.2.300\n')
+
+        module = jetson.jetson_module(self.device_tree, self.host)
+        self.assertEqual(module['jetpack'], '5.1.3')
+        self.assertEqual(module['cuda'], '10.2.300')
+
+    def test_not_a_jetson(self):
+        self.write(self.device_tree / 'compatible', 'raspberrypi,4-model-b\0brcm,bcm2711\0')
+        self.assertIsNone(jetson.jetson_module(self.device_tree, self.host))
+        self.assertIsNone(jetson.platform_peripheral(self.dir / 'missing', self.host))
+
+    def test_platform_peripheral(self):
+        self.fake_orin_nano()
+        peripheral = jetson.platform_peripheral(self.device_tree, self.host)
+
+        self.assertEqual(peripheral['identifier'], 'jetson-1421022012345')
+        self.assertEqual(peripheral['interface'], 'platform')
+        self.assertEqual(peripheral['classes'], ['platform', 'gpu', 'cuda', 'nvdec'])
+        self.assertEqual(peripheral['product'], 'Jetson Orin')
+        self.assertEqual(peripheral['description'],
+                         'NVIDIA Jetson Orin Nano Developer Kit (tegra234), CUDA compute capability 8.7, '
+                         'JetPack 5.1.2, 0 NVENC and 2 NVDEC engines')
+        self.assertIsNone(peripheral['additional-assets']['jetson']['cuda'])
@@ -0,0 +1,158 @@
+import json
+import os
+import stat
+import tempfile
+import time
+from pathlib import Path
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.models.peripheral import PeripheralData
+from nuvlaedge.peripherals.hooks import ATTACHED, DETACHED, MOVED, HookRule, PeripheralHooks
+
+
+class TestPeripheralHooks(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.dir = Path(self.temp_dir.name)
+        self.mock_nuvla = mock.Mock()
+        self.mock_report = mock.Mock()
+        self.hooks = PeripheralHooks(self.dir / 'hooks.json', self.dir / 'hook_results.json',
+                                     self.mock_nuvla, self.mock_report, 'nuvlabox/1')
+        self.camera = PeripheralData(identifier='046d:0825', available=True, interface='USB',
+                                     classes=['Video', 'Audio'])
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def write_rules(self, rules: list):
+        (self.dir / 'hooks.json').write_text(json.dumps(rules))
+        # Makes sure the reload is not hidden by the mtime resolution
+        os.utime(self.dir / 'hooks.json', (0, len(rules)))
+
+    def results(self) -> list:
+        return json.loads((self.dir / 'hook_results.json').read_text())
+
+    def run_hooks(self, event: str, peripherals: dict):
+        for future in self.hooks.trigger(event, peripherals):
+            future.result()
+
+    def test_matches(self):
+        rule = HookRule(name='camera', match={'interface': 'usb', 'classes': '*vid*'}, deployment='deployment/1')
+        camera = self.camera.model_dump(by_alias=True, exclude_none=True)
+        self.assertTrue(rule.matches(ATTACHED, camera))
+        self.assertFalse(rule.matches(DETACHED, camera))
+        self.assertFalse(rule.matches(ATTACHED, {**camera, 'classes': ['Audio']}))
+        self.assertFalse(rule.matches(ATTACHED, {'identifier': 'x', 'classes': ['Video']}))
+
+    def test_rules(self):
+        self.assertEqual(self.hooks.rules, [])
+
+        self.write_rules([{'name': 'ok', 'deployment': 'deployment/1'},
+                          {'name': 'both', 'deployment': 'deployment/1', 'script': '/bin/true'},
+                          {'name': 'neither'},
+                          {'name': 'notify-and-script', 'notify': 'critical', 'script': '/bin/true'},
+                          {'name': 'page', 'notify': 'urgent'},
+                          {'event': 'plugged'}])
+        self.assertEqual([r.name for r in self.hooks.rules], ['ok'])
+
+        self.write_rules([])
+        self.assertEqual(self.hooks.rules, [])
+
+    def test_deployment_hook(self):
+        self.write_rules([{'name': 'vision', 'match': {'classes': 'Video'}, 'deployment': 'deployment/1'},
+                          {'name': 'vision-stop', 'event': 'detached', 'deployment': 'deployment/1'}])
+
+        self.run_hooks(ATTACHED, {self.camera.identifier: self.camera})
+        self.mock_nuvla.get.assert_called_once_with('deployment/1')
+        self.mock_nuvla.operation.assert_called_once_with(self.mock_nuvla.get.return_value, 'start')
+
+        self.mock_nuvla.reset_mock()
+        self.run_hooks(DETACHED, {self.camera.identifier: self.camera})
+        self.mock_nuvla.operation.assert_called_once_with(mock.ANY, 'stop')
+
+        self.mock_nuvla.operation.side_effect = Exception('forbidden')
+        self.run_hooks(DETACHED, {self.camera.identifier: self.camera})
+        self.mock_report.assert_called_once()
+
+        self.assertEqual([(r['hook'], r['success']) for r in self.results()],
+                         [('vision', True), ('vision-stop', True), ('vision-stop', False)])
+
+    def test_moved_hook(self):
+        self.write_rules([{'name': 'redeploy', 'event': 'moved', 'deployment': '*'},
+                          {'name': 'pinned', 'event': 'moved', 'match': {'container': 'meter-*'},
+                           'deployment': 'deployment/2', 'operation': 'restart'}])
+        serial = {'identifier': '1a86:7523', 'path': '/dev/ttyUSB0', 'new-path': '/dev/ttyUSB1',
+                  'container': 'meter-reader', 'deployment': '1f0c5b2e'}
+
+        self.run_hooks(MOVED, {'1a86:7523': serial})
+        self.mock_nuvla.get.assert_has_calls([mock.call('deployment/1f0c5b2e'), mock.call('deployment/2')],
+                                             any_order=True)
+        self.mock_nuvla.operation.assert_has_calls([mock.call(mock.ANY, 'update'), mock.call(mock.ANY, 'restart')],
+                                                   any_order=True)
+
+        # Containers outside of deployments have none to redeploy
+        self.mock_nuvla.reset_mock()
+        self.run_hooks(MOVED, {'1a86:7523': {**serial, 'container': 'logger', 'deployment': None}})
+        self.mock_nuvla.operation.assert_not_called()
+        self.mock_report.assert_called_once()
+
+
+        self.write_rules([{'name': 'camera-lost', 'event': 'detached', 'match': {'identifier': '046d:*'},
+                           'notify': 'critical'}])
+        self.mock_nuvla.add.return_value.data = {'resource-id': 'event/1'}
+
+        self.run_hooks(ATTACHED, {self.camera.identifier: self.camera})
+        self.mock_nuvla.add.assert_not_called()
+
+        self.run_hooks(DETACHED, {self.camera.identifier: self.camera})
+        self.mock_nuvla.add.assert_called_once_with('event', {
+            'name': 'Peripheral hook camera-lost',
+            'category': 'user',
+            'severity': 'critical',
+            'timestamp': mock.ANY,
+            'content': {
+                'resource': {'href': 'nuvlabox/1'},
+                'state': 'Peripheral 046d:0825 (046d:0825) detached'
+            }
+        })
+        self.assertEqual(self.results()[0]['message'],
+                         'critical event event/1: Peripheral 046d:0825 (046d:0825) detached')
+
+        hooks = PeripheralHooks(self.dir / 'hooks.json', self.dir / 'hook_results.json', None, self.mock_report)
+        for future in hooks.trigger(DETACHED, {self.camera.identifier: self.camera}):
+            future.result()
+        self.mock_report.assert_called_once()
+
+    def test_script_hook(self):
+        script = self.dir / 'hook.sh'
+        script.write_text('#!/bin/sh\necho "$PERIPHERAL_EVENT $PERIPHERAL_IDENTIFIER"\n')
+        script.chmod(script.stat().st_mode | stat.S_IEXEC)
+        self.write_rules([{'name': 'script', 'match': {'identifier': '046d:*'}, 'script': str(script)}])
+
+        self.run_hooks(ATTACHED, {self.camera.identifier: self.camera, 'other': {'classes': []}})
+        self.assertEqual(self.results(), [{
+            'hook': 'script',
+            'event': ATTACHED,
+            'identifier': '046d:0825',
+            'success': True,
+            'message': 'attached 046d:0825',
+            'time': mock.ANY
+        }])
+        self.mock_report.assert_not_called()
+
+    def test_trigger_does_not_wait(self):
+        script = self.dir / 'slow.sh'
+        script.write_text('#!/bin/sh\nsleep 2\n')
+        script.chmod(script.stat().st_mode | stat.S_IEXEC)
+        self.write_rules([{'name': 'slow', 'match': {'identifier': '046d:*'}, 'script': str(script)}])
+
+        start = time.monotonic()
+        futures = self.hooks.trigger(ATTACHED, {self.camera.identifier: self.camera})
+        self.assertLess(time.monotonic() - start, 1)
+        self.assertEqual(len(futures), 1)
+
+        futures[0].result()
+        self.assertTrue(self.results()[0]['success'])
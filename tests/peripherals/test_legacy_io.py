@@ -0,0 +1,132 @@
+import os
+import tempfile
+from pathlib import Path
+from unittest import TestCase
+
+from nuvlaedge.peripherals import legacy_io
+
+PCI_IDS = """# PCI ids
+13fe  Advantech Co. Ltd
+	1680  PCI-1680 Rev.A1 2-port CAN-bus Card
+	1756  PCI-1756 64-ch Isolated Digital I/O PCI Card
+1393  Moxa Technologies Co Ltd
+	1681  CP-168U V2 Smart Serial Board (8-port)
+		1393 1681  CP-168U
+8086  Intel Corporation
+	1533  I210 Gigabit Network Connection
+"""
+
+
+class TestLegacyIO(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.dir = Path(self.temp_dir.name)
+        self.dev = self.dir / 'dev'
+        self.dev.mkdir()
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def write(self, path: Path, content: str):
+        path.parent.mkdir(parents=True, exist_ok=True)
+        path.write_text(content)
+
+    def pci_device(self, address: str, vendor: str, device: str, driver: str | None = None) -> Path:
+        path = self.dir / 'pci' / address
+        self.write(path / 'vendor', f'0x{vendor}\n')
+        self.write(path / 'device', f'0x{device}\n')
+        if driver:
+            (self.dir / 'drivers' / driver).mkdir(parents=True, exist_ok=True)
+            os.symlink(self.dir / 'drivers' / driver, path / 'driver')
+        return path
+
+    def test_parse_ieee1284_id(self):
+        self.assertEqual(legacy_io.parse_ieee1284_id('MFG:Hewlett-Packard;MDL:LaserJet 4;CLS:PRINTER;'),
+                         {'manufacturer': 'Hewlett-Packard', 'model': 'LaserJet 4', 'class': 'PRINTER'})
+        self.assertEqual(legacy_io.parse_ieee1284_id('CLASS:PRINTER;\nMODEL:Label 300;\nMANUFACTURER:Zebra;\n'),
+                         {'manufacturer': 'Zebra', 'model': 'Label 300', 'class': 'PRINTER'})
+        self.assertEqual(legacy_io.parse_ieee1284_id(''), {})
+
+    def test_parallel_ports(self):
+        proc = self.dir / 'parport'
+        self.write(proc / 'default' / 'timeslice', '200\n')
+        self.write(proc / 'parport0' / 'base-addr', '888\t1912\n')
+        self.write(proc / 'parport0' / 'irq', '7\n')
+        self.write(proc / 'parport0' / 'modes', 'PCSPP,TRISTATE,EPP\n')
+        self.write(proc / 'parport0' / 'autoprobe', 'CLASS:PRINTER;\nMODEL:Label 300;\nMANUFACTURER:Zebra;\n')
+        self.write(proc / 'parport1' / 'base-addr', '632\t1656\n')
+        (self.dev / 'parport0').touch()
+        (self.dev / 'lp0').touch()
+
+        ports = legacy_io.parallel_ports(proc, self.dev)
+        self.assertEqual([p['name'] for p in ports], ['parport0', 'parport1'])
+        self.assertEqual(ports[0]['base-address'], '0x378')
+        self.assertEqual(ports[0]['modes'], ['PCSPP', 'TRISTATE', 'EPP'])
+        self.assertEqual(ports[0]['device-paths'], [str(self.dev / 'parport0'), str(self.dev / 'lp0')])
+
+        printer = legacy_io.format_parallel_port(ports[0])
+        self.assertTrue(printer['available'])
+        self.assertEqual(printer['classes'], ['parallel', 'printer'])
+        self.assertEqual(printer['name'], 'Label 300')
+        self.assertEqual(printer['vendor'], 'Zebra')
+        self.assertEqual(printer['device-path'], str(self.dev / 'parport0'))
+        self.assertEqual(printer['additional-assets']['parallel']['irq'], '7')
+
+        # The container was not given the node of the second port
+        port = legacy_io.format_parallel_port(ports[1])
+        self.assertFalse(port['available'])
+        self.assertEqual(port['name'], 'Parallel port')
+        self.assertNotIn('device-path', port)
+
+        self.assertEqual(legacy_io.parallel_ports(self.dir / 'missing', self.dev), [])
+
+    def test_load_pci_names(self):
+        self.write(self.dir / 'pci.ids', PCI_IDS)
+        names = legacy_io.load_pci_names([str(self.dir / 'missing'), str(self.dir / 'pci.ids')])
+        self.assertEqual(names['1393'], 'Moxa Technologies Co Ltd')
+        self.assertEqual(names['1393:1681'], 'CP-168U V2 Smart Serial Board (8-port)')
+        self.assertEqual(names['13fe:1756'], 'PCI-1756 64-ch Isolated Digital I/O PCI Card')
+        self.assertNotIn('8086:1533', names)
+        self.assertEqual(legacy_io.load_pci_names([]), {})
+
+    def test_pci_cards(self):
+        moxa = self.pci_device('0000:03:00.0', '1393', '1681', driver='mxser')
+        for tty in ('ttyMI0', 'ttyMI1'):
+            (moxa / 'tty' / tty).mkdir(parents=True)
+            (self.dev / tty).touch()
+        daq = self.pci_device('0000:04:00.0', '13fe', '1756')
+        (daq / 'comedi' / 'comedi0').mkdir(parents=True)
+        serial = self.pci_device('0000:05:00.0', '13fe', '1612', driver='serial')
+        # The 8250 ports hang off port devices on recent kernels
+        (serial / '0000:05:00.0:0' / '0000:05:00.0:0.0' / 'tty' / 'ttyS4').mkdir(parents=True)
+        (self.dev / 'ttyS4').touch()
+        self.pci_device('0000:00:1f.6', '8086', '1533', driver='e1000e')
+
+        names = {'13fe': 'Advantech Co. Ltd', '1393:1681': 'CP-168U V2 Smart Serial Board (8-port)'}
+        cards = legacy_io.pci_cards(self.dir / 'pci', self.dev, names)
+        self.assertEqual([c['address'] for c in cards], ['0000:03:00.0', '0000:04:00.0', '0000:05:00.0'])
+
+        board = legacy_io.format_pci_card(cards[0])
+        self.assertEqual(board['identifier'], 'pci-0000:03:00.0')
+        self.assertEqual(board['name'], 'CP-168U V2 Smart Serial Board (8-port)')
+        self.assertEqual(board['vendor'], 'Moxa')
+        self.assertEqual(board['classes'], ['serial'])
+        self.assertEqual(board['device-paths'], [str(self.dev / 'ttyMI0'), str(self.dev / 'ttyMI1')])
+        self.assertTrue(board['available'])
+        self.assertEqual(board['additional-assets']['pci']['driver'], 'mxser')
+
+        # No comedi node in the container, and no driver bound
+        card = legacy_io.format_pci_card(cards[1])
+        self.assertEqual(card['name'], 'Advantech PCI card 1756')
+        self.assertEqual(card['classes'], ['data-acquisition'])
+        self.assertFalse(card['available'])
+        self.assertIn('no driver bound', card['description'])
+
+        self.assertEqual(legacy_io.format_pci_card(cards[2])['device-path'], str(self.dev / 'ttyS4'))
+
+    def test_flow(self):
+        self.write(self.dir / 'parport' / 'parport0' / 'base-addr', '888\t1912\n')
+        self.pci_device('0000:03:00.0', '1393', '1681')
+        peripherals = legacy_io.flow(str(self.dir / 'parport'), str(self.dir / 'pci'), str(self.dev))
+        self.assertEqual(sorted(peripherals), ['parport0', 'pci-0000:03:00.0'])
@@ -0,0 +1,163 @@
+import socket
+import struct
+import threading
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.network import bacnet
+
+
+def i_am(instance, vendor_id, network=None, mac=b''):
+    apdu = b'\x10\x00' + b'\xc4' + struct.pack('>I', (bacnet.OBJECT_DEVICE << 22) | instance)
+    apdu += b'\x22\x05\xc4' + b'\x91\x00' + b'\x21' + bytes([vendor_id])
+    if network is None:
+        return bacnet.bvlc(bacnet.BVLC_ORIGINAL_UNICAST, b'\x01\x00' + apdu)
+    return bacnet.bvlc(bacnet.BVLC_ORIGINAL_UNICAST,
+                       b'\x01\x08' + struct.pack('>HB', network, len(mac)) + mac + apdu)
+
+
+def character_string(value):
+    encoded = b'\x00' + value.encode()
+    return b'\x75' + bytes([len(encoded)]) + encoded
+
+
+def ack(invoke_id, instance, property_id, value, network=None, mac=b''):
+    apdu = bytes([0x30, invoke_id, bacnet.SERVICE_READ_PROPERTY])
+    apdu += b'\x0c' + struct.pack('>I', (bacnet.OBJECT_DEVICE << 22) | instance) + bytes([0x19, property_id])
+    apdu += b'\x3e' + value + b'\x3f'
+    if network is None:
+        return bacnet.bvlc(bacnet.BVLC_ORIGINAL_UNICAST, b'\x01\x00' + apdu)
+    return bacnet.bvlc(bacnet.BVLC_ORIGINAL_UNICAST,
+                       b'\x01\x08' + struct.pack('>HB', network, len(mac)) + mac + apdu)
+
+
+class FakeDevices(threading.Thread):
+    """
+    Answers as a BACnet controller and a sensor behind its router, the sensor failing to tell its names
+    """
+
+    def __init__(self):
+        super().__init__(daemon=True)
+        self.sock = socket.socket(socket.AF_INET, socket.SOCK_DGRAM)
+        self.sock.bind(('127.0.0.1', 0))
+        self.sock.settimeout(2)
+        self.port = self.sock.getsockname()[1]
+
+    def run(self):
+        try:
+            while True:
+                data, address = self.sock.recvfrom(1500)
+                apdu = bacnet.parse_npdu(bacnet.parse_bvlc(data)[0])[0]
+                if apdu[:2] == b'\x10\x08':
+                    self.sock.sendto(i_am(1001, 5), address)
+                    self.sock.sendto(i_am(2002, 24, network=5, mac=b'\x0a'), address)
+                elif apdu[0] >> 4 == bacnet.PDU_CONFIRMED_REQUEST:
+                    # The requests to the sensor are addressed to its network
+                    routed = data[5] & 0x20
+                    self.read_property(apdu, 5 if routed else None, data[9:10] if routed else b'', address)
+        except (socket.timeout, OSError):
+            return
+
+    def read_property(self, apdu, network, mac, address):
+        invoke_id, property_id = apdu[2], apdu[10]
+        if network is not None:
+            if property_id == bacnet.PROPERTY_OBJECT_LIST:
+                self.sock.sendto(ack(invoke_id, 2002, property_id, b'\x21\x03', network, mac), address)
+            else:
+                error = bytes([0x50, invoke_id, bacnet.SERVICE_READ_PROPERTY, 0x91, 0x02, 0x91, 0x20])
+                self.sock.sendto(bacnet.bvlc(bacnet.BVLC_ORIGINAL_UNICAST,
+                                             b'\x01\x08' + struct.pack('>HB', network, 1) + mac + error), address)
+            return
+        values = {
+            bacnet.PROPERTY_OBJECT_NAME: character_string('AHU-1'),
+            bacnet.PROPERTY_VENDOR_NAME: character_string('Johnson Controls, Inc.'),
+            bacnet.PROPERTY_MODEL_NAME: character_string('FX-PC'),
+            bacnet.PROPERTY_OBJECT_LIST: b'\x22\x01\x2c',
+        }
+        self.sock.sendto(ack(invoke_id, 1001, property_id, values[property_id]), address)
+
+
+class TestBACnet(TestCase):
+
+    def test_requests(self):
+        self.assertEqual(bacnet.who_is(), bytes.fromhex('810b000c0120ffff00ff1008'))
+        self.assertEqual(bacnet.read_property(7, 1001, bacnet.PROPERTY_OBJECT_LIST, 0),
+                         bytes.fromhex('810a00130104000507' + '0c' + '0c020003e9' + '194c' + '2900'))
+        # Routed to network 5, MAC address 0a
+        self.assertEqual(bacnet.read_property(1, 2002, bacnet.PROPERTY_OBJECT_NAME, network=5, mac=b'\x0a'),
+                         bytes.fromhex('810a0016' + '012400050' + '10aff' + '00050' + '10c' + '0c020007d2' + '194d'))
+
+    def test_parse_i_am(self):
+        apdu, network, mac = bacnet.parse_npdu(bacnet.parse_bvlc(i_am(2002, 24, network=5, mac=b'\x0a'))[0])
+        self.assertEqual((network, mac), (5, b'\x0a'))
+        self.assertEqual(bacnet.parse_i_am(apdu),
+                         {'instance': 2002, 'max-apdu': 1476, 'segmentation': 'both', 'vendor-id': 24})
+        self.assertIsNone(bacnet.parse_i_am(b'\x10\x08'))
+        with self.assertRaises(bacnet.BACnetError):
+            bacnet.parse_i_am(b'\x10\x00\xc4\x00')
+
+        # Forwarded by a BBMD for 10.0.0.20:47808
+        forwarded = bytes([0x81, 0x04, 0x00, 0x00]) + socket.inet_aton('10.0.0.20') + struct.pack('>H', 47808)
+        forwarded += b'\x01\x00\x10\x00'
+        forwarded = forwarded[:2] + struct.pack('>H', len(forwarded)) + forwarded[4:]
+        self.assertEqual(bacnet.parse_bvlc(forwarded), (b'\x01\x00\x10\x00', '10.0.0.20:47808'))
+        with self.assertRaises(bacnet.BACnetError):
+            bacnet.parse_bvlc(b'\x81\x0a\x00\x10\x01')
+
+    def test_read_tag(self):
+        tag, offset = bacnet.read_tag(b'\x75\xfe\x00\x10' + b'\x00' + b'a' * 15, 0)
+        self.assertEqual((tag.number, len(tag.value), offset), (7, 16, 20))
+        self.assertTrue(bacnet.read_tag(b'\x3e', 0)[0].opening)
+        self.assertTrue(bacnet.read_tag(b'\x3f', 0)[0].closing)
+        self.assertEqual(bacnet.decode_string(b'\x05Caf\xe9'), 'Café')
+        with self.assertRaises(bacnet.BACnetError):
+            bacnet.read_tag(b'\x24\x01', 0)
+
+    def test_discover(self):
+        devices = FakeDevices()
+        devices.start()
+        discovery = bacnet.BACnetDiscovery(timeout=0.5, port=devices.port, targets=['127.0.0.1'], exclusions=[])
+        peripherals = discovery.discover()
+        devices.join()
+
+        controller = peripherals['bacnet-1001']
+        self.assertEqual(controller['name'], 'AHU-1')
+        self.assertEqual(controller['vendor'], 'Johnson Controls, Inc.')
+        self.assertEqual(controller['product'], 'FX-PC')
+        self.assertEqual(controller['interface'], 'BACnet/IP')
+        self.assertEqual(controller['device-path'], f'127.0.0.1:{devices.port}')
+        self.assertEqual(controller['additional-assets']['bacnet']['object-count'], 300)
+
+        sensor = peripherals['bacnet-2002']
+        self.assertEqual(sensor['name'], 'BACnet device 2002')
+        self.assertEqual(sensor['vendor'], 'Automated Logic')
+        self.assertNotIn('product', sensor)
+        self.assertEqual(sensor['additional-assets']['bacnet'],
+                         {'instance': 2002, 'vendor-id': 24, 'max-apdu': 1476, 'segmentation': 'both', 'network': 5,
+                          'mac': '0a', 'object-count': 3})
+
+        # The inventory is kept until the next discovery
+        with mock.patch.object(discovery, 'open_socket') as open_socket:
+            self.assertIs(discovery.discover(), peripherals)
+            open_socket.assert_not_called()
+
+    def test_excluded(self):
+        discovery = bacnet.BACnetDiscovery(exclusions=bacnet.parse_exclusions('10.0.0.0/24'))
+        self.assertTrue(discovery.excluded('10.0.0.20:47808'))
+        self.assertFalse(discovery.excluded('10.0.1.20:47808'))
+
+        sock = mock.Mock()
+        device = {'instance': 1, 'vendor-id': 999, 'max-apdu': 480, 'segmentation': 'none', 'address': '10.0.0.20:47808',
+                  'network': None, 'mac': None, '_mac': b''}
+        with mock.patch.object(discovery, 'open_socket') as open_socket, \
+                mock.patch.object(discovery, 'who_is', return_value={1: device}):
+            open_socket.return_value.__enter__.return_value = sock
+            peripherals = discovery.discover()
+        sock.sendto.assert_not_called()
+        self.assertNotIn('vendor', peripherals['bacnet-1'])
+
+    def test_socket_error(self):
+        discovery = bacnet.BACnetDiscovery(exclusions=[])
+        with mock.patch.object(discovery, 'open_socket', side_effect=OSError('no network')):
+            self.assertEqual(discovery.discover(), {})
@@ -0,0 +1,75 @@
+import json
+import os
+import tempfile
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.network import network_manager
+from nuvlaedge.peripherals.network.leader import LeaderElection
+
+
+class Clock:
+
+    def __init__(self, now=1714564800.0):
+        self.now = now
+
+    def __call__(self):
+        return self.now
+
+
+class TestLeaderElection(TestCase):
+
+    def setUp(self) -> None:
+        self.dir = tempfile.TemporaryDirectory()
+        self.path = os.path.join(self.dir.name, 'shared', 'network.lock')
+        self.clock = Clock()
+        self.first = LeaderElection(path=self.path, ttl=120, identity='edge-1:42', clock=self.clock)
+        self.second = LeaderElection(path=self.path, ttl=120, identity='edge-2:7', clock=self.clock)
+
+    def tearDown(self) -> None:
+        self.dir.cleanup()
+
+    def test_election(self):
+        self.assertTrue(self.first.elect())
+        self.assertFalse(self.second.elect())
+        with open(self.path) as f:
+            self.assertEqual(json.load(f), {'holder': 'edge-1:42', 'renewed': 1714564800.0, 'ttl': 120})
+
+        # Renewals keep the lease past its TTL
+        self.clock.now += 100
+        self.assertTrue(self.first.elect())
+        self.clock.now += 100
+        self.assertFalse(self.second.elect())
+
+        # The lease of a leader gone silent expires
+        self.clock.now += 121
+        self.assertTrue(self.second.elect())
+        self.assertFalse(self.first.elect())
+        self.assertFalse(self.first.leader)
+        self.assertEqual([f for f in os.listdir(os.path.dirname(self.path))], ['network.lock'])
+
+    def test_release(self):
+        self.assertTrue(self.first.elect())
+        self.second.release()
+        self.assertTrue(os.path.exists(self.path))
+
+        self.first.release()
+        self.assertFalse(os.path.exists(self.path))
+        self.assertTrue(self.second.elect())
+
+    def test_invalid_lease(self):
+        os.makedirs(os.path.dirname(self.path))
+        with open(self.path, 'w') as f:
+            f.write('{"holder": "edge-3:1"')
+        self.assertTrue(self.first.elect())
+
+        with open(self.path, 'w') as f:
+            json.dump({'holder': 'edge-3:1', 'renewed': 'yesterday'}, f)
+        self.assertTrue(self.second.elect())
+
+    @mock.patch('nuvlaedge.peripherals.network.ssdp_manager')
+    def test_standby(self, ssdp_manager):
+        self.assertTrue(self.first.elect())
+        self.assertEqual(network_manager(zc_obj=None, zc_listener=None, wsdaemon=None, leader=self.second), {})
+        ssdp_manager.assert_not_called()
@@ -0,0 +1,167 @@
+import socket
+import struct
+import threading
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.network import opcua
+
+
+def string(value):
+    return opcua._string(value)
+
+
+def response_header(status=0):
+    # Timestamp, request handle, status, no diagnostics, no string table, no additional header
+    return b'\x00' * 8 + struct.pack('<II', 1, status) + b'\x00' + struct.pack('<i', 0) + b'\x00\x00\x00'
+
+
+def application(uri, name, app_type, urls):
+    return (string(uri) + string('urn:example:product') + b'\x02' + string(name) + struct.pack('<i', app_type)
+            + string(None) + string(None) + struct.pack('<i', len(urls)) + b''.join(string(u) for u in urls))
+
+
+def endpoint(url, mode, policy, tokens):
+    body = string(url) + application('urn:example:plc', 'PLC', 0, [url]) + struct.pack('<i', -1)
+    body += struct.pack('<i', mode) + string(f'http://opcfoundation.org/UA/SecurityPolicy#{policy}')
+    body += struct.pack('<i', len(tokens))
+    for token in tokens:
+        body += string(f'token-{token}') + struct.pack('<i', token) + string(None) + string(None) + string(None)
+    return body + string('http://opcfoundation.org/UA-Profile/Transport/uatcp-uasc-uabinary') + bytes([mode * 10])
+
+
+class FakeServer(threading.Thread):
+    """
+    Answers the discovery services of an OPC UA server, the GetEndpoints response split in two chunks
+    """
+
+    def __init__(self, find_servers: bytes, endpoints: bytes):
+        super().__init__(daemon=True)
+        self.sock = socket.socket()
+        self.sock.bind(('127.0.0.1', 0))
+        self.sock.listen(4)
+        self.port = self.sock.getsockname()[1]
+        self.responses = {opcua.FIND_SERVERS_REQUEST: (opcua.FIND_SERVERS_RESPONSE, find_servers),
+                          opcua.GET_ENDPOINTS_REQUEST: (opcua.GET_ENDPOINTS_RESPONSE, endpoints)}
+        self.requests = []
+
+    def recv(self, conn, size):
+        data = b''
+        while len(data) < size:
+            chunk = conn.recv(size - len(data))
+            if not chunk:
+                raise EOFError
+            data += chunk
+        return data
+
+    def send(self, conn, message_type, chunk_type, payload):
+        conn.sendall(message_type + chunk_type + struct.pack('<I', 8 + len(payload)) + payload)
+
+    def serve(self, conn):
+        while True:
+            header = self.recv(conn, 8)
+            body = self.recv(conn, struct.unpack('<I', header[4:])[0] - 8)
+            message_type = header[:3]
+            if message_type == b'HEL':
+                self.send(conn, b'ACK', b'F', struct.pack('<IIIII', 0, 65536, 65536, 0, 0))
+            elif message_type == b'OPN':
+                security = string(opcua.SECURITY_POLICY_NONE) + string(None) + string(None)
+                self.send(conn, b'OPN', b'F', struct.pack('<I', 7) + security + struct.pack('<II', 1, 1)
+                          + opcua._type_id(opcua.OPEN_SECURE_CHANNEL_RESPONSE) + response_header()
+                          + struct.pack('<IIIqI', 0, 7, 1, 0, 60000) + struct.pack('<i', 0))
+            elif message_type == b'MSG':
+                decoder = opcua.Decoder(body[16:])
+                request = decoder.node_id()
+                self.requests.append(request)
+                response, parameters = self.responses[request]
+                payload = opcua._type_id(response) + response_header() + parameters
+                prefix = struct.pack('<III', 7, 1, 2) + struct.pack('<I', 2)
+                half = len(payload) // 2
+                self.send(conn, b'MSG', b'C', prefix + payload[:half])
+                self.send(conn, b'MSG', b'F', prefix + payload[half:])
+            elif message_type == b'CLO':
+                return
+
+    def run(self):
+        while True:
+            try:
+                conn, _ = self.sock.accept()
+            except OSError:
+                return
+            with conn:
+                try:
+                    self.serve(conn)
+                except EOFError:
+                    pass
+
+    def stop(self):
+        self.sock.close()
+
+
+class TestOPCUA(TestCase):
+
+    def test_discover(self):
+        server = FakeServer(b'', b'')
+        url = f'opc.tcp://127.0.0.1:{server.port}'
+        # An LDS listing itself and a PLC whose discovery URL uses a name that does not resolve
+        found = [application('urn:example:lds', 'LDS', 3, [url]),
+                 application('urn:example:plc', 'PLC', 0, ['opc.tcp://plc.invalid:4840'])]
+        endpoints = [endpoint(url, 1, 'None', [0]), endpoint(url, 3, 'Basic256Sha256', [1, 2])]
+        server.responses[opcua.FIND_SERVERS_REQUEST] = (opcua.FIND_SERVERS_RESPONSE,
+                                                       struct.pack('<i', 2) + b''.join(found))
+        server.responses[opcua.GET_ENDPOINTS_REQUEST] = (opcua.GET_ENDPOINTS_RESPONSE,
+                                                        struct.pack('<i', 2) + b''.join(endpoints))
+        server.start()
+        self.addCleanup(server.stop)
+
+        discovery = opcua.OPCUADiscovery(urls=[url], exclusions=[], timeout=2)
+        peripherals = discovery.discover()
+
+        self.assertEqual(list(peripherals), ['urn:example:plc'])
+        plc = peripherals['urn:example:plc']
+        self.assertEqual(plc['interface'], 'OPC UA')
+        self.assertEqual(plc['classes'], ['opc-ua', 'server'])
+        self.assertEqual(plc['device-path'], url)
+        self.assertEqual(plc['description'], 'OPC UA server PLC, product urn:example:product, 2 endpoints, '
+                                             'security policies Basic256Sha256 None')
+        self.assertEqual(plc['additional-assets']['opc-ua']['endpoints'], [
+            {'url': url, 'security-mode': 'None', 'security-policy': 'None', 'security-level': 10,
+             'user-tokens': ['anonymous']},
+            {'url': url, 'security-mode': 'SignAndEncrypt', 'security-policy': 'Basic256Sha256',
+             'security-level': 30, 'user-tokens': ['certificate', 'username']},
+        ])
+        self.assertEqual(server.requests, [opcua.FIND_SERVERS_REQUEST, opcua.GET_ENDPOINTS_REQUEST])
+
+    def test_decoder_errors(self):
+        with self.assertRaises(opcua.OPCUAError):
+            opcua.Decoder(b'\x05\x00\x00\x00ab').string()
+        with self.assertRaises(opcua.OPCUAError):
+            opcua.Decoder(response_header(0x80340000)).response_header()
+        with self.assertRaises(opcua.OPCUAError):
+            opcua.SecureChannel('http://127.0.0.1:4840')
+
+    def test_unreachable_and_excluded(self):
+        discovery = opcua.OPCUADiscovery(urls=[], exclusions=opcua.parse_exclusions('10.0.0.0/24'), timeout=0.2)
+        with mock.patch.object(discovery, 'servers', return_value=[]) as mock_servers:
+            discovery.discover(['opc.tcp://10.0.0.5:4840', 'opc.tcp://10.0.1.5:4840'])
+            mock_servers.assert_called_once_with('opc.tcp://10.0.1.5:4840')
+
+        # Nothing listens on the port
+        probe = socket.socket()
+        probe.bind(('127.0.0.1', 0))
+        port = probe.getsockname()[1]
+        probe.close()
+        self.assertEqual(discovery.servers(f'opc.tcp://127.0.0.1:{port}'), [])
+
+    def test_candidate_urls(self):
+        service = mock.Mock(type=opcua.MDNS_SERVICE_TYPE, port=4841, properties={b'path': b'UA/Server'})
+        service.parsed_addresses.return_value = ['192.168.1.20']
+        other = mock.Mock(type='_http._tcp.local.')
+        self.assertEqual(opcua.mdns_urls({'plc': service, 'web': other}), ['opc.tcp://192.168.1.20:4841/UA/Server'])
+
+        swept = {
+            'aa:bb': {'classes': ['plc', 'opc-ua'], 'device-path': '192.168.1.30', 'interface': 'LAN'},
+            'cc:dd': {'classes': ['printer'], 'device-path': '192.168.1.31'},
+        }
+        self.assertEqual(opcua.swept_urls(swept), ['opc.tcp://192.168.1.30:4840'])
@@ -0,0 +1,65 @@
+import ipaddress
+import socket
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.network import services
+
+
+class TestServiceDetection(TestCase):
+
+    def test_parse_ports(self):
+        self.assertEqual(services.parse_ports(None), services.SERVICE_PORTS)
+        self.assertEqual(services.parse_ports('554, 8080:http,nope,9999'),
+                         {554: 'rtsp', 8080: 'http', 9999: 'tcp/9999'})
+
+    def test_parse_exclusions(self):
+        self.assertEqual(services.parse_exclusions(''), [])
+        self.assertEqual(services.parse_exclusions('10.0.0.5,10.0.1.0/24,nope'),
+                         [ipaddress.ip_network('10.0.0.5/32'), ipaddress.ip_network('10.0.1.0/24')])
+
+    @mock.patch('time.sleep')
+    def test_rate_limiter(self, mock_sleep):
+        limiter = services.RateLimiter(2)
+        limiter.wait()
+        mock_sleep.assert_not_called()
+        limiter.wait()
+        self.assertAlmostEqual(mock_sleep.call_args[0][0], 0.5, places=1)
+
+    @mock.patch('socket.create_connection')
+    def test_probe(self, mock_connect):
+        detector = services.ServiceDetector(ports={80: 'http', 502: 'modbus', 554: 'rtsp'}, rate=0, exclusions=[])
+        connection = mock_connect.return_value.__enter__.return_value
+
+        connection.recv.return_value = b'HTTP/1.0 200 OK\r\nServer: lighttpd\r\n\r\n'
+        self.assertEqual(detector.probe('10.0.0.2', 80), 'lighttpd')
+        connection.sendall.assert_called_once_with(services.SERVICE_REQUESTS['http'])
+
+        # Nothing is ever sent to the industrial protocols
+        connection.reset_mock()
+        self.assertEqual(detector.probe('10.0.0.2', 502), '')
+        connection.sendall.assert_not_called()
+        connection.recv.assert_not_called()
+
+        connection.recv.side_effect = socket.timeout
+        self.assertEqual(detector.probe('10.0.0.2', 554), '')
+
+        mock_connect.side_effect = ConnectionRefusedError
+        self.assertIsNone(detector.probe('10.0.0.2', 554))
+
+    def test_detect(self):
+        detector = services.ServiceDetector(ports={554: 'rtsp', 8554: 'rtsp', 9100: 'jetdirect'}, rate=0,
+                                            exclusions=services.parse_exclusions('10.0.1.0/24'))
+        with mock.patch.object(detector, 'probe', side_effect=['RTSP/1.0 200 OK', '', None]) as mock_probe:
+            self.assertEqual(detector.detect('10.0.0.2'), {'rtsp': 'RTSP/1.0 200 OK'})
+            self.assertEqual(mock_probe.call_count, 3)
+
+            mock_probe.reset_mock()
+            self.assertEqual(detector.detect('10.0.1.2'), {})
+            mock_probe.assert_not_called()
+
+    def test_classify(self):
+        self.assertEqual(services.classify({}), [])
+        self.assertEqual(services.classify({'rtsp': '', 'http': ''}), ['camera', 'rtsp', 'http'])
+        self.assertEqual(services.classify({'ipp': '', 'jetdirect': ''}), ['printer', 'ipp', 'jetdirect'])
@@ -0,0 +1,66 @@
+import ipaddress
+import tempfile
+from pathlib import Path
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.network import sweep
+
+
+class TestSubnetSweep(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.dir = Path(self.temp_dir.name)
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def test_read_arp_table(self):
+        arp = self.dir / 'arp'
+        arp.write_text('IP address       HW type     Flags       HW address            Mask     Device\n'
+                       '192.168.1.10     0x1         0x2         00:1B:1B:aa:bb:cc     *        eth0\n'
+                       '192.168.1.11     0x1         0x0         00:00:00:00:00:00     *        eth0\n')
+        self.assertEqual(sweep.read_arp_table(arp), {'192.168.1.10': '00:1b:1b:aa:bb:cc'})
+        self.assertEqual(sweep.read_arp_table(self.dir / 'missing'), {})
+
+    def test_vendor(self):
+        oui = self.dir / 'oui.txt'
+        oui.write_text('00-11-22   (hex)\t\tExample Corp\n001122     (base 16)\t\tExample Corp\n')
+        self.assertEqual(sweep.load_oui_database(oui), {'00:11:22': 'Example Corp'})
+
+        sweeper = sweep.SubnetSweeper(oui_file=oui)
+        self.assertEqual(sweeper.vendor('00:11:22:33:44:55'), 'Example Corp')
+        self.assertEqual(sweeper.vendor('00:1b:1b:aa:bb:cc'), 'Siemens AG')
+        self.assertIsNone(sweeper.vendor('02:00:00:00:00:01'))
+
+    def test_format_host(self):
+        host = sweep.format_host('192.168.1.10', '00:1b:1b:aa:bb:cc', 'Siemens AG', {'s7comm': ''})
+        self.assertEqual(host['identifier'], '00:1b:1b:aa:bb:cc')
+        self.assertEqual(host['classes'], ['plc', 's7comm'])
+        self.assertEqual(host['vendor'], 'Siemens AG')
+
+        self.assertEqual(sweep.format_host('192.168.1.11', '02:00:00:00:00:01', None, {})['classes'], ['host'])
+
+    @mock.patch.object(sweep, 'read_arp_table')
+    @mock.patch.object(sweep, 'wake_up')
+    @mock.patch.object(sweep, 'local_subnets')
+    @mock.patch('time.sleep')
+    def test_sweep(self, mock_sleep, mock_subnets, mock_wake_up, mock_arp):
+        mock_subnets.return_value = [(ipaddress.ip_address('10.0.0.1'), ipaddress.ip_network('10.0.0.0/30'))]
+        mock_arp.return_value = {'10.0.0.2': '00:1b:1b:aa:bb:cc'}
+        detector = mock.MagicMock()
+        detector.detect.return_value = {'http': 'lighttpd'}
+
+        sweeper = sweep.SubnetSweeper(oui_file=self.dir / 'missing', detector=detector)
+        inventory = sweeper.sweep()
+        mock_wake_up.assert_called_once_with('10.0.0.2')
+        detector.detect.assert_called_once_with('10.0.0.2')
+        self.assertEqual(list(inventory.keys()), ['00:1b:1b:aa:bb:cc'])
+        self.assertEqual(inventory['00:1b:1b:aa:bb:cc']['classes'], ['http'])
+
+        # Reported from cache until the next sweep is due
+        mock_wake_up.reset_mock()
+        self.assertEqual(sweeper.sweep(), inventory)
+        mock_wake_up.assert_not_called()
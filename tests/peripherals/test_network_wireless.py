@@ -0,0 +1,109 @@
+import os
+import struct
+import tempfile
+from pathlib import Path
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals.network import wireless
+
+
+def nested(attributes: dict[int, bytes]) -> bytes:
+    return b''.join(wireless.attribute(attr_type, payload) for attr_type, payload in attributes.items())
+
+
+class TestWireless(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.dir = Path(self.temp_dir.name)
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def test_parse_attributes(self):
+        data = wireless.attribute(1, b'abc') + wireless.attribute(2 | 0x8000, struct.pack('=I', 7))
+        self.assertEqual(wireless.parse_attributes(data), {1: b'abc', 2: struct.pack('=I', 7)})
+        self.assertEqual(wireless.parse_attributes(b'\x02\x00'), {})
+
+    def test_merge_wiphy(self):
+        wiphys = {}
+        index = struct.pack('=I', 0)
+        # Split dumps spread the description of a wiphy over several messages
+        wireless.merge_wiphy(wiphys, {
+            wireless.NL80211_ATTR_WIPHY: index,
+            wireless.NL80211_ATTR_WIPHY_NAME: b'phy0\0',
+            wireless.NL80211_ATTR_SUPPORTED_IFTYPES: nested({2: b'', 3: b'', 6: b''})
+        })
+        wireless.merge_wiphy(wiphys, {
+            wireless.NL80211_ATTR_WIPHY: index,
+            wireless.NL80211_ATTR_WIPHY_BANDS: nested({
+                0: nested({wireless.NL80211_BAND_ATTR_HT_CAPA: b'\0\0'}),
+                1: nested({wireless.NL80211_BAND_ATTR_HT_CAPA: b'\0\0', wireless.NL80211_BAND_ATTR_VHT_CAPA: b'\0' * 4})
+            })
+        })
+        wireless.merge_wiphy(wiphys, {wireless.NL80211_ATTR_WIPHY_NAME: b'ignored\0'})
+
+        self.assertEqual(wiphys, {0: {
+            'name': 'phy0',
+            'modes': {'station', 'access-point', 'monitor'},
+            'bands': {'2.4GHz': {'802.11n'}, '5GHz': {'802.11n', '802.11ac'}}
+        }})
+
+    def fake_phy(self, name: str, bus: str, driver: str) -> Path:
+        sysfs = self.dir / 'sys'
+        device = sysfs / 'devices' / f'{name}-device'
+        (device / 'net' / f'wlan{name[-1]}').mkdir(parents=True)
+        for target, link in ((bus, 'subsystem'), (driver, 'driver')):
+            (sysfs / target).mkdir(parents=True, exist_ok=True)
+            os.symlink(sysfs / target, device / link)
+        phy = sysfs / 'class' / 'ieee80211' / name
+        phy.mkdir(parents=True)
+        (phy / 'macaddress').write_text('00:c0:ca:11:22:33\n')
+        os.symlink(device, phy / 'device')
+        return device
+
+    def test_read_sysfs_adapters(self):
+        device = self.fake_phy('phy0', 'bus/pci', 'drivers/iwlwifi')
+        (device / 'vendor').write_text('0x8086\n')
+        (device / 'device').write_text('0x2723\n')
+
+        adapters = wireless.read_sysfs_adapters(self.dir / 'sys' / 'class' / 'ieee80211')
+        self.assertEqual(adapters, [{
+            'phy': 'phy0',
+            'mac': '00:c0:ca:11:22:33',
+            'bus': 'pci',
+            'driver': 'iwlwifi',
+            'vendor-id': '0x8086',
+            'product-id': '0x2723',
+            'product': None,
+            'interfaces': ['wlan0']
+        }])
+        self.assertEqual(wireless.read_sysfs_adapters(self.dir / 'missing'), [])
+
+    def test_wireless_adapters(self):
+        self.fake_phy('phy1', 'bus/usb', 'drivers/rt2800usb')
+        nl80211 = mock.Mock()
+        nl80211.wiphys.return_value = {'phy1': {
+            'name': 'phy1',
+            'modes': {'station', 'monitor'},
+            'bands': {'5GHz': {'802.11n'}, '2.4GHz': {'802.11n'}}
+        }}
+
+        adapters = wireless.wireless_adapters(self.dir / 'sys' / 'class' / 'ieee80211', nl80211)
+        adapter = adapters['00:c0:ca:11:22:33']
+        self.assertEqual(adapter['interface'], 'USB')
+        self.assertEqual(adapter['classes'], ['wifi', 'monitor-mode'])
+        self.assertEqual(adapter['device-path'], 'wlan1')
+        wifi = adapter['additional-assets']['wifi']
+        self.assertEqual(wifi['bands'], ['2.4GHz', '5GHz'])
+        self.assertTrue(wifi['monitor-mode'])
+        self.assertFalse(wifi['access-point'])
+        self.assertEqual(wifi['driver'], 'rt2800usb')
+
+        # Without nl80211, the adapters are still reported
+        nl80211.wiphys.side_effect = OSError('Protocol not supported')
+        adapter = wireless.wireless_adapters(self.dir / 'sys' / 'class' / 'ieee80211', nl80211)['00:c0:ca:11:22:33']
+        self.assertEqual(adapter['classes'], ['wifi'])
+        self.assertNotIn('bands', adapter['additional-assets']['wifi'])
@@ -0,0 +1,58 @@
+import tempfile
+from pathlib import Path
+from unittest import TestCase
+
+import mock
+import requests
+
+from nuvlaedge.peripherals.pending_operations import PendingOperation, PendingOperationsQueue
+
+
+class TestPendingOperationsQueue(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.file = Path(self.temp_dir.name) / 'pending_operations.json'
+        self.queue = PendingOperationsQueue(self.file)
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def test_push_merges_superseded_operations(self):
+        self.queue.push(PendingOperation(operation='create', identifier='id_1', data={'name': 'Camera'}))
+        self.queue.push(PendingOperation(operation='update', identifier='id_1', data={'available': False}))
+        self.assertEqual(len(self.queue), 1)
+        self.assertEqual(self.queue.operations[0].data, {'name': 'Camera', 'available': False})
+
+        # Peripherals created and removed while offline never reach Nuvla
+        self.queue.push(PendingOperation(operation='delete', identifier='id_1'))
+        self.assertEqual(len(self.queue), 0)
+
+        self.queue.push(PendingOperation(operation='update', identifier='id_2', resource_id='p/2', data={'a': 1}))
+        self.queue.push(PendingOperation(operation='update', identifier='id_2', resource_id='p/2', data={'b': 2}))
+        self.assertEqual(self.queue.operations[0].data, {'a': 1, 'b': 2})
+
+        self.queue.push(PendingOperation(operation='delete', identifier='id_2', resource_id='p/2'))
+        self.assertEqual([o.operation for o in self.queue.operations], ['delete'])
+
+        # Plugged back in after the removal: both operations are kept, in order
+        self.queue.push(PendingOperation(operation='create', identifier='id_2', data={'name': 'Modem'}))
+        self.assertEqual([o.operation for o in self.queue.operations], ['delete', 'create'])
+
+    def test_persistence(self):
+        self.queue.push(PendingOperation(operation='delete', identifier='id_1', resource_id='p/1'))
+        self.assertEqual(PendingOperationsQueue(self.file).operations, self.queue.operations)
+
+    def test_replay(self):
+        self.queue.push(PendingOperation(operation='delete', identifier='id_1', resource_id='p/1'))
+        self.queue.push(PendingOperation(operation='delete', identifier='id_2', resource_id='p/2'))
+        self.queue.push(PendingOperation(operation='delete', identifier='id_3', resource_id='p/3'))
+
+        execute = mock.Mock(side_effect=[None, requests.exceptions.ConnectionError()])
+        self.assertEqual(self.queue.replay(execute), 1)
+        self.assertEqual([o.identifier for o in self.queue.operations], ['id_2', 'id_3'])
+
+        # Operations refused by Nuvla are dropped instead of blocking the queue
+        execute = mock.Mock(side_effect=[ValueError('refused'), None])
+        self.assertEqual(self.queue.replay(execute), 1)
+        self.assertEqual(len(PendingOperationsQueue(self.file)), 0)
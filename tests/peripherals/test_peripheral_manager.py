@@ -1,3 +1,5 @@
+import json
+import tempfile
 from pathlib import Path
 from datetime import datetime
 
@@ -7,6 +9,7 @@ import mock
 from nuvlaedge.models.peripheral import PeripheralData
 from nuvlaedge.models.messages import NuvlaEdgeMessage
 from nuvlaedge.agent.workers.peripheral_manager import PeripheralManager, PeripheralsDBManager
+from nuvlaedge.peripherals.hooks import ATTACHED, MOVED
 
 
 class TestPeripheralManager(TestCase):
@@ -79,6 +82,27 @@ class TestPeripheralManager(TestCase):
         for i in self.test_manager.available_messages:
             self.assertEqual(i, {'id': 'idx'})
 
+        # The latest of the messages published within the same second is used
+        same_second = datetime(2024, 5, 1, 12, 0, 0)
+        self.mock_broker.consume.return_value = [
+            NuvlaEdgeMessage(sender='usb', data={'id': 'latest'}, time=same_second, sequence=1714564800000002),
+            NuvlaEdgeMessage(sender='usb', data={'id': 'oldest'}, time=same_second, sequence=1714564800000001),
+        ]
+        self.test_manager.running_peripherals = {Path('usb')}
+        self.assertEqual([{'id': 'latest'}], list(self.test_manager.available_messages))
+
+    def test_report_peripherals(self):
+        peripherals = {'046d:0825': {'identifier': '046d:0825'}}
+        self.assertEqual(peripherals, PeripheralManager.report_peripherals(peripherals))
+
+        batch = {'peripherals': peripherals,
+                 'events': [{'time': '2024-03-05T10:20:30Z', 'event': 'added', 'identifier': '046d:0825'}]}
+        self.assertEqual(peripherals, PeripheralManager.report_peripherals(batch))
+
+        self.mock_broker.consume.return_value = [NuvlaEdgeMessage(sender='usb', data=batch, time=datetime.now())]
+        self.test_manager.running_peripherals = {Path('usb')}
+        self.assertEqual([peripherals], list(self.test_manager.available_messages))
+
     def test_join_new_peripherals(self):
 
         self.assertEqual({}, self.test_manager.join_new_peripherals([]))
@@ -99,3 +123,78 @@ class TestPeripheralManager(TestCase):
             sample_peripheral['classes'] = 'notalist'
             self.test_manager.join_new_peripherals([sample_peripheral])
             self.assertEqual(3, manager_logger.call_count)
+
+    def test_reconcile_on_startup(self):
+        detected = {'idx': PeripheralData(identifier='idx', available=True, classes=['net'])}
+        self.test_manager.running_peripherals = {Path('usb'), Path('network')}
+
+        with mock.patch.object(PeripheralsDBManager, 'reconcile') as mock_reconcile, \
+                mock.patch.object(self.test_manager.hooks, 'trigger') as mock_trigger:
+            # Waits for every running manager to report
+            self.test_manager._reported_managers = {'usb'}
+            self.test_manager.reconcile_on_startup(detected)
+            mock_reconcile.assert_not_called()
+
+            self.test_manager._reported_managers = {'usb', 'network'}
+            mock_reconcile.side_effect = Exception('offline')
+            self.test_manager.reconcile_on_startup({})
+            self.assertFalse(self.test_manager._reconciled)
+
+            mock_reconcile.side_effect = None
+            mock_reconcile.return_value = ({'idx'}, {})
+            self.test_manager.reconcile_on_startup({})
+            mock_reconcile.assert_called_with(detected, remove_stale=True)
+            self.assertTrue(self.test_manager._reconciled)
+            mock_trigger.assert_any_call(ATTACHED, detected)
+
+        # Managers that never report do not hold the reconciliation back forever
+        self.test_manager._reported_managers = set()
+        self.test_manager._started -= self.test_manager.RECONCILE_TIMEOUT + 1
+        self.assertTrue(self.test_manager.startup_scan_complete)
+
+        # But the peripherals they may have registered are not removed then
+        self.test_manager._reconciled = False
+        self.test_manager._reported_managers = {'usb'}
+        with mock.patch.object(PeripheralsDBManager, 'reconcile') as mock_reconcile, \
+                mock.patch.object(self.test_manager.hooks, 'trigger'):
+            mock_reconcile.return_value = (set(), {})
+            self.test_manager.reconcile_on_startup(detected)
+            mock_reconcile.assert_called_with(detected, remove_stale=False)
+
+        # Nor when no peripheral was detected at all
+        self.test_manager._reconciled = False
+        self.test_manager._reported_managers = {'usb', 'network'}
+        with mock.patch.object(PeripheralsDBManager, 'reconcile') as mock_reconcile, \
+                mock.patch.object(self.test_manager.hooks, 'trigger'):
+            mock_reconcile.return_value = (set(), {})
+            self.test_manager.reconcile_on_startup({})
+            mock_reconcile.assert_called_with({}, remove_stale=False)
+
+    def test_process_remediations(self):
+        remediation = {'id': 'a1b2c3d4e5f6:/dev/ttyUSB0:/dev/ttyUSB1', 'container': 'meter-reader',
+                       'container-id': 'a1b2c3d4e5f6', 'deployment': '1f0c5b2e', 'peripheral': '1a86:7523',
+                       'path': '/dev/ttyUSB0', 'new-path': '/dev/ttyUSB1', 'since': '2024-05-01T12:01:00Z'}
+        with tempfile.TemporaryDirectory() as manager:
+            file = Path(manager) / PeripheralManager.REMEDIATIONS_FILE
+            file.write_text(json.dumps([remediation]))
+            self.test_manager.running_peripherals = {Path(manager)}
+
+            with mock.patch.object(PeripheralsDBManager, 'get') as mock_get, \
+                    mock.patch.object(self.test_manager.hooks, 'trigger') as mock_trigger:
+                mock_get.return_value = PeripheralData(identifier='1a86:7523', available=True, classes=['Serial'])
+                self.test_manager.process_remediations()
+                mock_trigger.assert_called_once()
+                event, peripherals = mock_trigger.call_args.args
+                self.assertEqual(event, MOVED)
+                self.assertEqual(peripherals['1a86:7523']['new-path'], '/dev/ttyUSB1')
+                self.assertEqual(peripherals['1a86:7523']['classes'], ['Serial'])
+
+                # The hooks run once per remediation, until the manager clears it
+                self.test_manager.process_remediations()
+                mock_trigger.assert_called_once()
+
+                file.write_text('[]')
+                self.test_manager.process_remediations()
+                file.write_text(json.dumps([remediation]))
+                self.test_manager.process_remediations()
+                self.assertEqual(mock_trigger.call_count, 2)
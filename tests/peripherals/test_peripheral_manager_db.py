@@ -1,15 +1,18 @@
+import tempfile
 from pathlib import Path
 from datetime import datetime, timedelta
 
 from unittest import TestCase
 
 import mock
+import requests
 
 import nuvlaedge.peripherals.peripheral_manager_db
 from nuvlaedge.common.constants import CTE
 from nuvlaedge.common.utils import format_datetime_for_nuvla
 from nuvlaedge.peripherals.peripheral_manager_db import PeripheralData, PeripheralsDBManager
 from nuvlaedge.models.nuvla_resources import NuvlaBoxPeripheralResource as PeripheralResource
+from nuvlaedge.peripherals.pending_operations import PendingOperation, PendingOperationsQueue
 
 
 class TestPeripheralsDBManager(TestCase):
@@ -17,6 +20,7 @@ class TestPeripheralsDBManager(TestCase):
     def setUp(self) -> None:
         self.mock_nuvla = mock.Mock()
         self.test_db = PeripheralsDBManager(self.mock_nuvla, 'test_uuid')
+        self.test_db.metadata = mock.Mock()
 
     @staticmethod
     def get_sample_peripheral_resource():
@@ -52,6 +56,7 @@ class TestPeripheralsDBManager(TestCase):
         self.test_db.synchronize()
         self.assertEqual(self.test_db._local_db, {})
         self.assertEqual(self.test_db._latest_update, {})
+        self.test_db.metadata.sync.assert_called_once_with({})
 
         # Test remote found
         mock_collection.count = 1
@@ -67,6 +72,7 @@ class TestPeripheralsDBManager(TestCase):
         self.test_db.synchronize()
         self.assertEqual(self.test_db._latest_update, {'id': datetime.fromisoformat(t_str)})
         mock_storage.assert_called_once()
+        self.test_db.metadata.sync.assert_called_with(test_peripheral)
 
         # Test remove update from local registry
         mock_storage.reset_mock()
@@ -239,3 +245,83 @@ class TestPeripheralsDBManager(TestCase):
             self.test_db.edit(test_data)
             self.assertTrue('id' in self.test_db._latest_update)
             mock_update_local.assert_called_once()
+
+    def test_peripheral_changed(self):
+        stored = self.get_sample_peripheral_resource()
+        self.assertFalse(self.test_db.peripheral_changed(stored, self.get_sample_peripheral_data()))
+
+        detected = self.get_sample_peripheral_data()
+        detected.name = 'Renamed'
+        self.assertTrue(self.test_db.peripheral_changed(stored, detected))
+
+    @mock.patch.object(PeripheralsDBManager, 'synchronize')
+    @mock.patch.object(PeripheralsDBManager, 'update_local_storage')
+    def test_reconcile(self, mock_storage, mock_sync):
+        unchanged = self.get_sample_peripheral_resource()
+        changed = PeripheralResource(id='nuvlabox-peripheral/2', identifier='id_2', available=True, classes=['a'])
+        stale = PeripheralResource(id='nuvlabox-peripheral/3', identifier='id_3', available=True, classes=['a'])
+        self.test_db._local_db = {'id_1': unchanged, 'id_2': changed, 'id_3': stale}
+
+        detected = {
+            'id_1': self.get_sample_peripheral_data(),
+            'id_2': PeripheralData(identifier='id_2', available=False, classes=['a']),
+            'id_4': PeripheralData(identifier='id_4', available=True, classes=['b'])
+        }
+        with mock.patch.object(PeripheralsDBManager, 'remove_peripheral') as mock_remove, \
+                mock.patch.object(PeripheralsDBManager, 'add_peripheral') as mock_add:
+            added, removed = self.test_db.reconcile(detected)
+
+            self.assertEqual(added, {'id_4'})
+            self.assertEqual(removed, {'id_3': stale})
+            mock_sync.assert_called_once()
+            mock_remove.assert_called_once_with('id_3')
+            mock_add.assert_called_once_with(detected['id_4'])
+            self.mock_nuvla.edit.assert_called_once_with('nuvlabox-peripheral/2', data=mock.ANY)
+            self.assertFalse(self.test_db._local_db['id_2'].available)
+            self.assertIn('id_1', self.test_db._latest_update)
+            mock_storage.assert_called_once()
+
+        # Stale peripherals are kept when some manager did not report
+        self.test_db._local_db = {'id_1': unchanged, 'id_3': stale}
+        with mock.patch.object(PeripheralsDBManager, 'remove_peripheral') as mock_remove, \
+                mock.patch.object(PeripheralsDBManager, 'add_peripheral'):
+            _, removed = self.test_db.reconcile({'id_1': self.get_sample_peripheral_data()}, remove_stale=False)
+            self.assertEqual(removed, {})
+            mock_remove.assert_not_called()
+
+        # Peripherals failing to update in Nuvla are not updated locally either
+        self.mock_nuvla.edit.side_effect = Exception('offline')
+        self.test_db._local_db = {'id_2': changed}
+        self.test_db.edit_peripheral(detected['id_2'])
+        self.assertTrue(self.test_db._local_db['id_2'].available)
+
+    def test_offline_operations_are_queued(self):
+        with tempfile.TemporaryDirectory() as temp_dir, \
+                mock.patch.object(PeripheralsDBManager, 'add_remote_peripheral') as mock_add_remote:
+            self.test_db.pending = PendingOperationsQueue(Path(temp_dir) / 'pending_operations.json')
+
+            mock_add_remote.side_effect = requests.exceptions.ConnectionError()
+            self.test_db.add_peripheral(self.get_sample_peripheral_data())
+            self.assertEqual([o.operation for o in self.test_db.pending.operations], ['create'])
+            self.assertIn('id_1', self.test_db._local_db)
+
+            # Once operations are pending, new ones are queued behind them
+            mock_add_remote.reset_mock()
+            self.test_db.add_peripheral(PeripheralData(identifier='id_2', available=True, classes=['class']))
+            mock_add_remote.assert_not_called()
+            self.assertEqual(len(self.test_db.pending), 2)
+
+    def test_execute_pending(self):
+        self.test_db._local_db = {'id_1': self.get_sample_peripheral_resource()}
+        mock_response = mock.Mock()
+        mock_response.data = {'status': 201, 'resource-id': 'nuvlabox-peripheral/1'}
+        self.mock_nuvla.add.return_value = mock_response
+
+        self.test_db._execute_pending(PendingOperation(operation='create', identifier='id_1', data={}))
+        self.assertEqual(self.test_db._local_db['id_1'].id, 'nuvlabox-peripheral/1')
+
+        self.test_db._execute_pending(PendingOperation(operation='update', identifier='id_1', data={'name': 'x'}))
+        self.mock_nuvla.edit.assert_called_once_with('nuvlabox-peripheral/1', data={'name': 'x'})
+
+        self.test_db._execute_pending(PendingOperation(operation='delete', identifier='id_1', resource_id='p/1'))
+        self.mock_nuvla.delete.assert_called_once_with('p/1')
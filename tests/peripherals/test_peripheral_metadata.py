@@ -0,0 +1,55 @@
+import json
+import tempfile
+from pathlib import Path
+from types import SimpleNamespace
+from unittest import TestCase
+
+from nuvlaedge.peripherals.metadata import PeripheralMetadataWriter, metadata_file_name, peripheral_metadata
+
+
+def resource(**kwargs):
+    attributes = {'id': 'nuvlabox-peripheral/1', 'name': None, 'description': None, 'tags': None, 'updated': None}
+    return SimpleNamespace(**{**attributes, **kwargs})
+
+
+class TestPeripheralMetadata(TestCase):
+
+    def setUp(self) -> None:
+        self.temp_dir = tempfile.TemporaryDirectory()
+        self.folder = Path(self.temp_dir.name) / 'metadata'
+        self.writer = PeripheralMetadataWriter(self.folder)
+
+    def tearDown(self) -> None:
+        self.temp_dir.cleanup()
+
+    def test_file_name(self):
+        self.assertEqual(metadata_file_name('046d:0825'), '046d:0825.json')
+        self.assertEqual(metadata_file_name('../etc/passwd'), '.._etc_passwd.json')
+
+    def test_peripheral_metadata(self):
+        metadata = peripheral_metadata('046d:0825', resource(name='Entrance camera',
+                                                             tags=['role:entrance-camera', 'building-a']))
+        self.assertEqual(metadata['roles'], ['entrance-camera'])
+        self.assertEqual(metadata['tags'], ['role:entrance-camera', 'building-a'])
+        self.assertEqual(peripheral_metadata('046d:0825', resource())['roles'], [])
+
+    def test_sync(self):
+        camera = resource(name='Entrance camera', description='Above the main door', tags=['role:entrance-camera'])
+        self.writer.sync({'046d:0825': camera, '0c2e:0b61': resource(id='nuvlabox-peripheral/2')})
+
+        written = json.loads((self.folder / '046d:0825.json').read_text())
+        self.assertEqual(written['description'], 'Above the main door')
+        self.assertEqual(written['roles'], ['entrance-camera'])
+        self.assertTrue((self.folder / '0c2e:0b61.json').exists())
+
+        # Unchanged metadata is not rewritten, removed peripherals lose theirs
+        mtime = (self.folder / '046d:0825.json').stat().st_mtime_ns
+        (self.folder / 'notes.txt').write_text('kept')
+        self.writer.sync({'046d:0825': camera})
+        self.assertEqual((self.folder / '046d:0825.json').stat().st_mtime_ns, mtime)
+        self.assertFalse((self.folder / '0c2e:0b61.json').exists())
+        self.assertTrue((self.folder / 'notes.txt').exists())
+
+        camera.tags = ['role:exit-camera']
+        self.writer.sync({'046d:0825': camera})
+        self.assertEqual(json.loads((self.folder / '046d:0825.json').read_text())['roles'], ['exit-camera'])
@@ -0,0 +1,43 @@
+import ctypes
+import json
+from unittest import TestCase
+
+import mock
+
+from nuvlaedge.peripherals import usb_library
+
+
+class FakeLibrary:
+    """
+    Stands for the C shared library, answering with a C string
+    """
+
+    def __init__(self, output: dict):
+        self.buffer = ctypes.create_string_buffer(json.dumps(output).encode())
+        self.nuvlaedge_usb_discover = mock.Mock(return_value=ctypes.addressof(self.buffer))
+        self.nuvlaedge_usb_free = mock.Mock()
+
+
+class TestUSBLibrary(TestCase):
+
+    def test_discover(self):
+        fake = FakeLibrary({'peripherals': {'046d:0825': {'identifier': '046d:0825', 'name': 'Webcam C270'}}})
+        library = usb_library.USBLibrary('libnuvlaedgeusb.so', loader=lambda path: fake)
+
+        self.assertEqual(fake.nuvlaedge_usb_discover.restype, ctypes.c_void_p)
+        self.assertEqual(library.discover(5), {'046d:0825': {'identifier': '046d:0825', 'name': 'Webcam C270'}})
+        fake.nuvlaedge_usb_discover.assert_called_once_with(5000)
+        fake.nuvlaedge_usb_free.assert_called_once_with(ctypes.addressof(fake.buffer))
+
+    def test_discover_error(self):
+        fake = FakeLibrary({'peripherals': {}, 'error': 'libusb: not found'})
+        library = usb_library.USBLibrary('libnuvlaedgeusb.so', loader=lambda path: fake)
+
+        with self.assertLogs(usb_library.logger, 'ERROR'):
+            self.assertEqual(library.discover(), {})
+        fake.nuvlaedge_usb_free.assert_called_once()
+
+    def test_usb_manager(self):
+        library = mock.Mock()
+        library.discover.return_value = {'046d:0825': {}}
+        self.assertEqual(usb_library.usb_manager(library=library), {'046d:0825': {}})